@@ -0,0 +1,766 @@
+// Copyright (c) 2015 Pagoda Box Inc
+//
+// This Source Code Form is subject to the terms of the Mozilla Public License, v.
+// 2.0. If a copy of the MPL was not distributed with this file, You can obtain one
+// at http://mozilla.org/MPL/2.0/.
+//
+
+// admin provides an http api that operators can use to look at the state of a
+// node, and to drive the decider by hand.
+package admin
+
+import (
+	"encoding/json"
+	"github.com/nanopack/yoke/chaos"
+	"github.com/nanopack/yoke/config"
+	"github.com/nanopack/yoke/metrics"
+	"github.com/nanopack/yoke/monitor"
+	"github.com/nanopack/yoke/state"
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DefaultSwitchoverTimeout is how long a switchover waits for a backup to catch up
+// when no timeout is given
+var DefaultSwitchoverTimeout = time.Minute
+
+type (
+	Admin struct {
+		sync.RWMutex
+		me      state.State
+		others  []state.State
+		port    int
+		decider monitor.Decider
+		reload  func() error
+		replace func(old, address string) error
+		syncs   monitor.SyncReporter
+		chaos   *chaos.Monkey
+		mux     *http.ServeMux
+	}
+
+	// Status is what the node reports about itself
+	Status struct {
+		Role        string                `json:"role"`
+		DBRole      string                `json:"db_role"`
+		Synced      bool                  `json:"synced"`
+		Phase       monitor.SyncPhase     `json:"phase,omitempty"` // how far along a backup is following the active node
+		Position    uint64                `json:"position"`
+		Location    string                `json:"location"`
+		Endpoint    string                `json:"endpoint,omitempty"`
+		Epoch       uint64                `json:"epoch"`
+		Paused      bool                  `json:"paused"`
+		Maintenance bool                  `json:"maintenance"`
+		DryRun      bool                  `json:"dry_run"`
+		Plan        *monitor.Plan         `json:"plan,omitempty"`
+		Decision    *monitor.Decision     `json:"decision,omitempty"`
+		RTO         *config.RTO           `json:"rto,omitempty"` // how long a failover can take, with an rto_target set
+		Version     string                `json:"database_version,omitempty"`
+		Sync        *monitor.SyncProgress `json:"sync,omitempty"` // the copy of the data the node takes or sends, while it runs
+	}
+
+	// Node is what the cluster status reports about one of the nodes, as the node
+	// tells it about itself
+	Node struct {
+		Location   string            `json:"location"`
+		Role       string            `json:"role,omitempty"`
+		DBRole     string            `json:"db_role,omitempty"`
+		Synced     bool              `json:"synced"`
+		Phase      monitor.SyncPhase `json:"phase,omitempty"` // only backups have one
+		Position   uint64            `json:"position"`
+		LagBytes   int64             `json:"lag_bytes"`
+		LagSeconds float64           `json:"lag_seconds"`
+		Epoch      uint64            `json:"epoch"`
+		Rule       string            `json:"rule,omitempty"`             // the row of the transition table its decider last went by
+		Decided    *time.Time        `json:"decided,omitempty"`          // when it went by it
+		Peers      map[string]string `json:"peers,omitempty"`            // how its last recheck reached each of the other nodes
+		Version    string            `json:"database_version,omitempty"` // the nodes differ while a major upgrade is rolled out
+		Error      string            `json:"error,omitempty"`            // why the node could not be asked
+	}
+
+	// Belief is what the decider of the node made of the cluster on its last check
+	Belief struct {
+		monitor.ClusterState
+		Error string `json:"error,omitempty"` // what the check failed with
+	}
+
+	// a state that gossips how its node sees the cluster
+	summarizer interface {
+		GetSummary() (state.Summary, error)
+	}
+
+	// a state that knows the epoch of the cluster
+	epocher interface {
+		GetEpoch() (state.Epoch, error)
+	}
+
+	// Replica is a backup that can serve read only queries
+	Replica struct {
+		Location string `json:"location"`
+		Endpoint string `json:"endpoint"`
+		Epoch    uint64 `json:"epoch"` // the newest epoch the backup has seen
+	}
+
+	// Primary is the node that takes the writes of the cluster. A client keeps the
+	// answer with the highest epoch, an answer with a lower one is from before the
+	// last takeover.
+	Primary struct {
+		Location string `json:"location"`
+		Endpoint string `json:"endpoint"`
+		DBRole   string `json:"db_role"`
+		Epoch    uint64 `json:"epoch"`
+	}
+)
+
+// New creates the admin api for the local node. The transition endpoints are
+// unavailable until a decider is attached.
+func New(me state.State) *Admin {
+	admin := &Admin{
+		me:  me,
+		mux: http.NewServeMux(),
+	}
+	admin.mux.HandleFunc("/status", admin.status)
+	admin.mux.HandleFunc("/primary", admin.primary)
+	admin.mux.HandleFunc("/replicas", admin.replicas)
+	admin.mux.HandleFunc("/history", admin.history)
+	admin.mux.HandleFunc("/sync", admin.syncProgress)
+	admin.mux.HandleFunc("/cluster", admin.cluster)
+	admin.mux.HandleFunc("/decider", admin.belief)
+	admin.mux.Handle("/metrics", metrics.Handler())
+	admin.mux.HandleFunc("/demote", admin.post(func(decider monitor.Decider) error {
+		return decider.Demote()
+	}))
+	admin.mux.HandleFunc("/promote", admin.post(func(decider monitor.Decider) error {
+		return decider.Promote()
+	}))
+	admin.mux.HandleFunc("/recheck", admin.recheck)
+	admin.mux.HandleFunc("/switchover", admin.switchover)
+	admin.mux.HandleFunc("/pause", admin.post(func(decider monitor.Decider) error {
+		decider.Pause()
+		return nil
+	}))
+	admin.mux.HandleFunc("/resume", admin.post(func(decider monitor.Decider) error {
+		decider.Resume()
+		return nil
+	}))
+	admin.mux.HandleFunc("/upgrade", admin.post(func(decider monitor.Decider) error {
+		return decider.Upgrade()
+	}))
+	admin.mux.HandleFunc("/major-upgrade", admin.majorUpgrade)
+	admin.mux.HandleFunc("/dr/promote", admin.post(func(decider monitor.Decider) error {
+		return decider.PromoteDR()
+	}))
+	admin.mux.HandleFunc("/dry-run", admin.dryRun)
+	admin.mux.HandleFunc("/maintenance", admin.maintenance)
+	admin.mux.HandleFunc("/reload", admin.reloadConfig)
+	admin.mux.HandleFunc("/replace", admin.replacePeer)
+	admin.mux.HandleFunc("/chaos", admin.chaosMode)
+	return admin
+}
+
+// SetDecider attaches the decider that the admin api will drive
+func (admin *Admin) SetDecider(decider monitor.Decider) {
+	admin.Lock()
+	defer admin.Unlock()
+	admin.decider = decider
+}
+
+// SetCluster tells the admin api about the other nodes in the cluster, and the port
+// clients connect to the databases on
+func (admin *Admin) SetCluster(others []state.State, databasePort int) {
+	admin.Lock()
+	defer admin.Unlock()
+	admin.others = others
+	admin.port = databasePort
+}
+
+// SetReloader sets what reloads the config of the node when it is asked for
+func (admin *Admin) SetReloader(reload func() error) {
+	admin.Lock()
+	defer admin.Unlock()
+	admin.reload = reload
+}
+
+// SetReplacer sets what points the node at a node that replaced a dead one
+func (admin *Admin) SetReplacer(replace func(old, address string) error) {
+	admin.Lock()
+	defer admin.Unlock()
+	admin.replace = replace
+}
+
+// SetSyncReporter sets what reports the copy of the data the node runs
+func (admin *Admin) SetSyncReporter(syncs monitor.SyncReporter) {
+	admin.Lock()
+	defer admin.Unlock()
+	admin.syncs = syncs
+}
+
+// the copy of the data the node runs, nil while there is none
+func (admin *Admin) syncing() *monitor.SyncProgress {
+	admin.RLock()
+	syncs := admin.syncs
+	admin.RUnlock()
+	if syncs == nil {
+		return nil
+	}
+	return syncs.Syncing()
+}
+
+// syncProgress reports how far along the copy of the data is. Unlike the status it
+// answers while a transition runs, which is when the data is copied.
+func (admin *Admin) syncProgress(res http.ResponseWriter, req *http.Request) {
+	if req.Method != "GET" {
+		http.Error(res, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	progress := admin.syncing()
+	if progress == nil {
+		http.Error(res, "no data is being copied", http.StatusNotFound)
+		return
+	}
+	reply(res, progress)
+}
+
+// SetChaos sets the monkey that the chaos endpoint turns on and off
+func (admin *Admin) SetChaos(monkey *chaos.Monkey) {
+	admin.Lock()
+	defer admin.Unlock()
+	admin.chaos = monkey
+}
+
+// Listen starts serving the admin api on address
+func (admin *Admin) Listen(address string) (io.Closer, error) {
+	listener, err := net.Listen("tcp", address)
+	if err != nil {
+		return nil, err
+	}
+	go http.Serve(listener, admin)
+	return listener, nil
+}
+
+func (admin *Admin) ServeHTTP(res http.ResponseWriter, req *http.Request) {
+	admin.mux.ServeHTTP(res, req)
+}
+
+func (admin *Admin) status(res http.ResponseWriter, req *http.Request) {
+	if req.Method != "GET" {
+		http.Error(res, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	admin.writeStatus(res)
+}
+
+func (admin *Admin) writeStatus(res http.ResponseWriter) {
+	status, err := admin.Status()
+	if err != nil {
+		http.Error(res, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	reply(res, status)
+}
+
+// Status collects the current status of the local node
+func (admin *Admin) Status() (Status, error) {
+	var err error
+	status := Status{Location: admin.me.Location()}
+	status.Endpoint = admin.endpoint(status.Location)
+	if status.Role, err = admin.me.GetRole(); err != nil {
+		return status, err
+	}
+	if status.DBRole, err = admin.me.GetDBRole(); err != nil {
+		return status, err
+	}
+	if status.Synced, err = admin.me.HasSynced(); err != nil {
+		return status, err
+	}
+	if status.Position, err = admin.me.GetPosition(); err != nil {
+		return status, err
+	}
+	status.Epoch = epochOf(admin.me)
+	status.Sync = admin.syncing()
+	if state.DBRole(status.DBRole) == state.Backup {
+		delay, bytes, err := admin.me.Lag()
+		if err != nil {
+			return status, err
+		}
+		admin.RLock()
+		others := admin.others
+		admin.RUnlock()
+		status.Phase = phaseOf(status.Synced, status.Position, writablePosition(others), delay, bytes)
+	}
+	if gossip, ok := admin.me.(summarizer); ok {
+		if summary, err := gossip.GetSummary(); err == nil {
+			status.Version = summary.Version
+		}
+	}
+	if config.Conf.RTOTarget > 0 {
+		rto := config.Conf.RTO()
+		status.RTO = &rto
+	}
+
+	admin.RLock()
+	decider := admin.decider
+	admin.RUnlock()
+	if decider != nil {
+		status.Paused = decider.Paused()
+		status.Maintenance = decider.InMaintenance()
+		status.DryRun, status.Plan = decider.Planned()
+		if decision := decider.Decided(); decision.Rule != "" {
+			status.Decision = &decision
+		}
+	}
+	return status, nil
+}
+
+// replicas lists the synced backups of the cluster, so that read only queries can
+// be sent to them. A backup stops being listed as soon as it is promoted.
+func (admin *Admin) replicas(res http.ResponseWriter, req *http.Request) {
+	if req.Method != "GET" {
+		http.Error(res, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	admin.RLock()
+	nodes := append([]state.State{admin.me}, admin.others...)
+	admin.RUnlock()
+
+	replicas := []Replica{}
+	for _, node := range nodes {
+		if role, err := node.GetDBRole(); err != nil || state.DBRole(role) != state.Backup {
+			continue
+		}
+		if synced, err := node.HasSynced(); err != nil || !synced {
+			continue
+		}
+		location := node.Location()
+		replicas = append(replicas, Replica{Location: location, Endpoint: admin.endpoint(location), Epoch: epochOf(node)})
+	}
+	reply(res, replicas)
+}
+
+// primary reports the node that takes the writes of the cluster. While two nodes
+// take writes, the one that started in the higher epoch is reported.
+func (admin *Admin) primary(res http.ResponseWriter, req *http.Request) {
+	if req.Method != "GET" {
+		http.Error(res, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	admin.RLock()
+	nodes := append([]state.State{admin.me}, admin.others...)
+	admin.RUnlock()
+
+	var primary *Primary
+	for _, node := range nodes {
+		role, err := node.GetDBRole()
+		if err != nil || (state.DBRole(role) != state.Active && state.DBRole(role) != state.Single) {
+			continue
+		}
+		epoch := epochOf(node)
+		if primary != nil && primary.Epoch >= epoch {
+			continue
+		}
+		location := node.Location()
+		primary = &Primary{Location: location, Endpoint: admin.endpoint(location), DBRole: role, Epoch: epoch}
+	}
+	if primary == nil {
+		http.Error(res, "the cluster has no writable node", http.StatusServiceUnavailable)
+		return
+	}
+	reply(res, primary)
+}
+
+// the newest epoch node has seen, 0 when it can't tell
+func epochOf(node state.State) uint64 {
+	if epochs, ok := node.(epocher); ok {
+		if epoch, err := epochs.GetEpoch(); err == nil {
+			return epoch.Number
+		}
+	}
+	return 0
+}
+
+// the position of the first of nodes that takes writes, 0 when none of them does
+func writablePosition(nodes []state.State) uint64 {
+	for _, node := range nodes {
+		role, err := node.GetDBRole()
+		if err != nil {
+			continue
+		}
+		switch state.DBRole(role) {
+		case state.Active, state.Single:
+			if position, err := node.GetPosition(); err == nil {
+				return position
+			}
+		}
+	}
+	return 0
+}
+
+// phaseOf is the phase of a backup at position, while the writable node is at
+// ahead. The lag the backup measured is gone by when ahead isn't known.
+func phaseOf(synced bool, position, ahead uint64, delay time.Duration, bytes int64) monitor.SyncPhase {
+	if ahead != 0 {
+		bytes = 0
+		if ahead > position {
+			bytes = int64(ahead - position)
+		}
+	}
+	return monitor.PhaseOf(synced, delay, bytes, int64(config.Conf.MaxAllowedLagBytes), time.Duration(config.Conf.MaxAllowedLagSeconds)*time.Second)
+}
+
+// history lists the decisions and transitions the decider made, oldest first
+func (admin *Admin) history(res http.ResponseWriter, req *http.Request) {
+	if req.Method != "GET" {
+		http.Error(res, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	admin.RLock()
+	decider := admin.decider
+	admin.RUnlock()
+	if decider == nil {
+		http.Error(res, "the cluster is not ready yet", http.StatusServiceUnavailable)
+		return
+	}
+	reply(res, decider.History())
+}
+
+// belief reports what the decider made of the cluster on its last check
+func (admin *Admin) belief(res http.ResponseWriter, req *http.Request) {
+	if req.Method != "GET" {
+		http.Error(res, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	admin.RLock()
+	decider := admin.decider
+	admin.RUnlock()
+	if decider == nil {
+		http.Error(res, "the cluster is not ready yet", http.StatusServiceUnavailable)
+		return
+	}
+	belief := Belief{ClusterState: decider.State()}
+	if err := decider.LastError(); err != nil {
+		belief.Error = err.Error()
+	}
+	reply(res, belief)
+}
+
+// cluster reports on every node of the cluster, this node first, from what each of
+// them tells about itself. A node that can't be asked is reported with the error it
+// failed with. It works on the monitor too.
+func (admin *Admin) cluster(res http.ResponseWriter, req *http.Request) {
+	if req.Method != "GET" {
+		http.Error(res, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	admin.RLock()
+	nodes := append([]state.State{admin.me}, admin.others...)
+	admin.RUnlock()
+
+	cluster := make([]Node, 0, len(nodes))
+	for _, node := range nodes {
+		described := Node{Location: node.Location()}
+		if err := describe(node, &described); err != nil {
+			described.Error = err.Error()
+		}
+		cluster = append(cluster, described)
+	}
+
+	// a backup is as far behind as the writable node is ahead of it, which is newer
+	// than the lag the backup measured on its last check
+	ahead := uint64(0)
+	for _, described := range cluster {
+		switch state.DBRole(described.DBRole) {
+		case state.Active, state.Single:
+			ahead = described.Position
+		}
+	}
+	for i, described := range cluster {
+		if described.Error == "" && state.DBRole(described.DBRole) == state.Backup {
+			cluster[i].Phase = phaseOf(described.Synced, described.Position, ahead, time.Duration(described.LagSeconds*float64(time.Second)), described.LagBytes)
+		}
+	}
+	reply(res, cluster)
+}
+
+// describe fills in what node tells about itself
+func describe(node state.State, described *Node) error {
+	var err error
+	if described.Role, err = node.GetRole(); err != nil {
+		return err
+	}
+	if described.DBRole, err = node.GetDBRole(); err != nil {
+		return err
+	}
+	if described.Synced, err = node.HasSynced(); err != nil {
+		return err
+	}
+	if described.Position, err = node.GetPosition(); err != nil {
+		return err
+	}
+	delay, bytes, err := node.Lag()
+	if err != nil {
+		return err
+	}
+	described.LagBytes, described.LagSeconds = bytes, delay.Seconds()
+	if epochs, ok := node.(epocher); ok {
+		epoch, err := epochs.GetEpoch()
+		if err != nil {
+			return err
+		}
+		described.Epoch = epoch.Number
+	}
+	if gossip, ok := node.(summarizer); ok {
+		summary, err := gossip.GetSummary()
+		if err != nil {
+			return err
+		}
+		described.Rule, described.Peers, described.Version = summary.Rule, summary.Peers, summary.Version
+		if !summary.Decided.IsZero() {
+			described.Decided = &summary.Decided
+		}
+	}
+	return nil
+}
+
+// where clients reach the database of the node at location, which is only known
+// once the cluster has been set
+func (admin *Admin) endpoint(location string) string {
+	admin.RLock()
+	port := admin.port
+	admin.RUnlock()
+	host, _, err := net.SplitHostPort(location)
+	if port == 0 || err != nil {
+		return ""
+	}
+	return net.JoinHostPort(host, strconv.Itoa(port))
+}
+
+// wraps an action on the decider so that it can only be triggered with a POST
+func (admin *Admin) post(action func(monitor.Decider) error) http.HandlerFunc {
+	return func(res http.ResponseWriter, req *http.Request) {
+		if req.Method != "POST" {
+			http.Error(res, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		admin.RLock()
+		decider := admin.decider
+		admin.RUnlock()
+		if decider == nil {
+			http.Error(res, "the cluster is not ready yet", http.StatusServiceUnavailable)
+			return
+		}
+
+		config.Log.Info("[admin] %v requested by %v", req.URL.Path, req.RemoteAddr)
+		switch err := action(decider); err {
+		case nil:
+		case monitor.ClusterUnaviable, monitor.SwitchoverTimeout, monitor.NotCaughtUp:
+			http.Error(res, err.Error(), http.StatusServiceUnavailable)
+			return
+		case monitor.NotActive, monitor.NotBackup, monitor.NotDR, monitor.NoLease, monitor.NotUpgradable, monitor.NotFrozen, monitor.StillRunning:
+			http.Error(res, err.Error(), http.StatusConflict)
+			return
+		default:
+			http.Error(res, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		admin.writeStatus(res)
+	}
+}
+
+// recheck rechecks the cluster right away, with the 'force' query parameter set to
+// 'true' the decided transition is made even when the decider already made it
+func (admin *Admin) recheck(res http.ResponseWriter, req *http.Request) {
+	force := false
+	if value := req.URL.Query().Get("force"); value != "" {
+		var err error
+		if force, err = strconv.ParseBool(value); err != nil {
+			http.Error(res, "force has to be 'true' or 'false'", http.StatusBadRequest)
+			return
+		}
+	}
+
+	admin.post(func(decider monitor.Decider) error {
+		if force {
+			return decider.Force()
+		}
+		return decider.ReCheck()
+	})(res, req)
+}
+
+// switchover hands the active role over to a backup, the optional 'timeout' query
+// parameter (e.g. '90s') limits how long to wait for a backup to catch up
+func (admin *Admin) switchover(res http.ResponseWriter, req *http.Request) {
+	timeout := DefaultSwitchoverTimeout
+	if value := req.URL.Query().Get("timeout"); value != "" {
+		var err error
+		if timeout, err = time.ParseDuration(value); err != nil {
+			http.Error(res, err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+
+	admin.post(func(decider monitor.Decider) error {
+		return decider.Switchover(timeout)
+	})(res, req)
+}
+
+// majorUpgrade runs the stage of a major upgrade given by the 'stage' query
+// parameter, the 'timeout' is how long the upgrade stage waits for the backup to
+// catch up with the frozen active node
+func (admin *Admin) majorUpgrade(res http.ResponseWriter, req *http.Request) {
+	stage := req.URL.Query().Get("stage")
+	known := false
+	for _, name := range monitor.UpgradeStages {
+		known = known || name == stage
+	}
+	if !known {
+		http.Error(res, "stage has to be one of "+strings.Join(monitor.UpgradeStages, ", "), http.StatusBadRequest)
+		return
+	}
+	timeout := DefaultSwitchoverTimeout
+	if value := req.URL.Query().Get("timeout"); value != "" {
+		var err error
+		if timeout, err = time.ParseDuration(value); err != nil {
+			http.Error(res, err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+
+	admin.post(func(decider monitor.Decider) error {
+		return decider.MajorUpgrade(stage, timeout)
+	})(res, req)
+}
+
+// dryRun turns dry running on or off with the 'enabled' query parameter ('true' or
+// 'false')
+func (admin *Admin) dryRun(res http.ResponseWriter, req *http.Request) {
+	enabled, err := strconv.ParseBool(req.URL.Query().Get("enabled"))
+	if err != nil {
+		http.Error(res, "enabled has to be 'true' or 'false'", http.StatusBadRequest)
+		return
+	}
+
+	admin.post(func(decider monitor.Decider) error {
+		decider.DryRun(enabled)
+		return nil
+	})(res, req)
+}
+
+// maintenance puts the cluster in maintenance or takes it out again with the
+// 'enabled' query parameter ('true' or 'false')
+func (admin *Admin) maintenance(res http.ResponseWriter, req *http.Request) {
+	enabled, err := strconv.ParseBool(req.URL.Query().Get("enabled"))
+	if err != nil {
+		http.Error(res, "enabled has to be 'true' or 'false'", http.StatusBadRequest)
+		return
+	}
+
+	admin.post(func(decider monitor.Decider) error {
+		return decider.Maintenance(enabled)
+	})(res, req)
+}
+
+// reloadConfig reads the config file again, it works on the monitor too as it
+// does not need a decider
+func (admin *Admin) reloadConfig(res http.ResponseWriter, req *http.Request) {
+	if req.Method != "POST" {
+		http.Error(res, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	admin.RLock()
+	reload := admin.reload
+	admin.RUnlock()
+	if reload == nil {
+		http.Error(res, "the config can not be reloaded", http.StatusServiceUnavailable)
+		return
+	}
+
+	config.Log.Info("[admin] %v requested by %v", req.URL.Path, req.RemoteAddr)
+	if err := reload(); err != nil {
+		http.Error(res, err.Error(), http.StatusBadRequest)
+		return
+	}
+	admin.writeStatus(res)
+}
+
+// replacePeer swaps the dead node at the 'old' address for the node at the 'new'
+// address (both IP:port of the rpc endpoint). It has to be asked of every node
+// that is left in the cluster, the monitor included.
+func (admin *Admin) replacePeer(res http.ResponseWriter, req *http.Request) {
+	if req.Method != "POST" {
+		http.Error(res, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	old, address := req.URL.Query().Get("old"), req.URL.Query().Get("new")
+	if old == "" || address == "" {
+		http.Error(res, "old and new have to be given", http.StatusBadRequest)
+		return
+	}
+
+	admin.RLock()
+	replace := admin.replace
+	admin.RUnlock()
+	if replace == nil {
+		http.Error(res, "nodes can not be replaced", http.StatusServiceUnavailable)
+		return
+	}
+
+	config.Log.Info("[admin] %v requested by %v", req.URL.Path, req.RemoteAddr)
+	switch err := replace(old, address); err {
+	case nil:
+	case monitor.UnknownPeer:
+		http.Error(res, err.Error(), http.StatusNotFound)
+		return
+	default:
+		http.Error(res, err.Error(), http.StatusBadRequest)
+		return
+	}
+	admin.writeStatus(res)
+}
+
+// chaosMode reports the chaos mode of the node, a POST turns it on or off with the
+// 'enabled' query parameter ('true' or 'false'). It doesn't need a decider, so that
+// it can always be turned off.
+func (admin *Admin) chaosMode(res http.ResponseWriter, req *http.Request) {
+	admin.RLock()
+	monkey := admin.chaos
+	admin.RUnlock()
+	if monkey == nil {
+		http.Error(res, "the node has no chaos mode", http.StatusServiceUnavailable)
+		return
+	}
+
+	switch req.Method {
+	case "GET":
+	case "POST":
+		enabled, err := strconv.ParseBool(req.URL.Query().Get("enabled"))
+		if err != nil {
+			http.Error(res, "enabled has to be 'true' or 'false'", http.StatusBadRequest)
+			return
+		}
+		config.Log.Info("[admin] %v requested by %v", req.URL.Path, req.RemoteAddr)
+		monkey.Enable(enabled)
+	default:
+		http.Error(res, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	reply(res, monkey.Status())
+}
+
+func reply(res http.ResponseWriter, body interface{}) {
+	res.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(res).Encode(body); err != nil {
+		config.Log.Error("[admin] failed to write reply %v", err)
+	}
+}
@@ -0,0 +1,530 @@
+// Copyright (c) 2015 Pagoda Box Inc
+//
+// This Source Code Form is subject to the terms of the Mozilla Public License, v.
+// 2.0. If a copy of the MPL was not distributed with this file, You can obtain one
+// at http://mozilla.org/MPL/2.0/.
+//
+
+package admin_test
+
+import (
+	"encoding/json"
+	"errors"
+	"github.com/golang/mock/gomock"
+	"github.com/nanopack/yoke/admin"
+	"github.com/nanopack/yoke/chaos"
+	"github.com/nanopack/yoke/config"
+	"github.com/nanopack/yoke/monitor"
+	"github.com/nanopack/yoke/monitor/mock"
+	"github.com/nanopack/yoke/state"
+	"github.com/nanopack/yoke/state/mock"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func expectStatus(me *mock_state.MockState) {
+	me.EXPECT().Location().Return("127.0.0.1:4400")
+	me.EXPECT().GetRole().Return("primary", nil)
+	me.EXPECT().GetDBRole().Return("active", nil)
+	me.EXPECT().HasSynced().Return(false, nil)
+	me.EXPECT().GetPosition().Return(uint64(12), nil)
+}
+
+func TestStatus(test *testing.T) {
+	ctrl := gomock.NewController(test)
+	defer ctrl.Finish()
+
+	me := mock_state.NewMockState(ctrl)
+	api := admin.New(me)
+
+	expectStatus(me)
+	res := httptest.NewRecorder()
+	api.ServeHTTP(res, httptest.NewRequest("GET", "/status", nil))
+	if res.Code != http.StatusOK {
+		test.Logf("wrong status code %v", res.Code)
+		test.FailNow()
+	}
+
+	status := admin.Status{}
+	if err := json.NewDecoder(res.Body).Decode(&status); err != nil {
+		test.Log(err)
+		test.FailNow()
+	}
+	if status.Role != "primary" || status.DBRole != "active" || status.Position != 12 {
+		test.Logf("wrong status was returned %v", status)
+		test.Fail()
+	}
+}
+
+func TestActions(test *testing.T) {
+	ctrl := gomock.NewController(test)
+	defer ctrl.Finish()
+
+	me := mock_state.NewMockState(ctrl)
+	decider := mock_monitor.NewMockDecider(ctrl)
+	api := admin.New(me)
+
+	// nothing can happen until the decider is ready
+	res := httptest.NewRecorder()
+	api.ServeHTTP(res, httptest.NewRequest("POST", "/demote", nil))
+	if res.Code != http.StatusServiceUnavailable {
+		test.Logf("wrong status code %v", res.Code)
+		test.Fail()
+	}
+
+	api.SetDecider(decider)
+
+	res = httptest.NewRecorder()
+	api.ServeHTTP(res, httptest.NewRequest("GET", "/promote", nil))
+	if res.Code != http.StatusMethodNotAllowed {
+		test.Logf("wrong status code %v", res.Code)
+		test.Fail()
+	}
+
+	decider.EXPECT().Promote().Return(nil)
+	decider.EXPECT().Paused().Return(false)
+	decider.EXPECT().InMaintenance().Return(false)
+	decider.EXPECT().Planned().Return(false, nil)
+	decider.EXPECT().Decided().Return(monitor.Decision{})
+	expectStatus(me)
+	res = httptest.NewRecorder()
+	api.ServeHTTP(res, httptest.NewRequest("POST", "/promote", nil))
+	if res.Code != http.StatusOK {
+		test.Logf("wrong status code %v", res.Code)
+		test.Fail()
+	}
+
+	decider.EXPECT().ReCheck().Return(monitor.ClusterUnaviable)
+	res = httptest.NewRecorder()
+	api.ServeHTTP(res, httptest.NewRequest("POST", "/recheck", nil))
+	if res.Code != http.StatusServiceUnavailable {
+		test.Logf("wrong status code %v", res.Code)
+		test.Fail()
+	}
+
+	decider.EXPECT().Force().Return(monitor.ClusterUnaviable)
+	res = httptest.NewRecorder()
+	api.ServeHTTP(res, httptest.NewRequest("POST", "/recheck?force=true", nil))
+	if res.Code != http.StatusServiceUnavailable {
+		test.Logf("wrong status code %v", res.Code)
+		test.Fail()
+	}
+}
+
+func TestSwitchover(test *testing.T) {
+	ctrl := gomock.NewController(test)
+	defer ctrl.Finish()
+
+	me := mock_state.NewMockState(ctrl)
+	decider := mock_monitor.NewMockDecider(ctrl)
+	api := admin.New(me)
+	api.SetDecider(decider)
+
+	res := httptest.NewRecorder()
+	api.ServeHTTP(res, httptest.NewRequest("POST", "/switchover?timeout=soon", nil))
+	if res.Code != http.StatusBadRequest {
+		test.Logf("wrong status code %v", res.Code)
+		test.Fail()
+	}
+
+	decider.EXPECT().Switchover(90 * time.Second).Return(monitor.NotActive)
+	res = httptest.NewRecorder()
+	api.ServeHTTP(res, httptest.NewRequest("POST", "/switchover?timeout=90s", nil))
+	if res.Code != http.StatusConflict {
+		test.Logf("wrong status code %v", res.Code)
+		test.Fail()
+	}
+}
+
+func TestDryRun(test *testing.T) {
+	ctrl := gomock.NewController(test)
+	defer ctrl.Finish()
+
+	me := mock_state.NewMockState(ctrl)
+	decider := mock_monitor.NewMockDecider(ctrl)
+	api := admin.New(me)
+	api.SetDecider(decider)
+
+	res := httptest.NewRecorder()
+	api.ServeHTTP(res, httptest.NewRequest("POST", "/dry-run?enabled=maybe", nil))
+	if res.Code != http.StatusBadRequest {
+		test.Logf("wrong status code %v", res.Code)
+		test.Fail()
+	}
+
+	decider.EXPECT().DryRun(true)
+	decider.EXPECT().Paused().Return(false)
+	decider.EXPECT().InMaintenance().Return(false)
+	decider.EXPECT().Planned().Return(true, &monitor.Plan{Transition: "active"})
+	decider.EXPECT().Decided().Return(monitor.Decision{})
+	expectStatus(me)
+	res = httptest.NewRecorder()
+	api.ServeHTTP(res, httptest.NewRequest("POST", "/dry-run?enabled=true", nil))
+	if res.Code != http.StatusOK {
+		test.Logf("wrong status code %v", res.Code)
+		test.FailNow()
+	}
+
+	status := admin.Status{}
+	if err := json.NewDecoder(res.Body).Decode(&status); err != nil {
+		test.Log(err)
+		test.FailNow()
+	}
+	if !status.DryRun || status.Plan == nil || status.Plan.Transition != "active" {
+		test.Logf("wrong status %+v", status)
+		test.Fail()
+	}
+}
+
+func TestMaintenance(test *testing.T) {
+	ctrl := gomock.NewController(test)
+	defer ctrl.Finish()
+
+	me := mock_state.NewMockState(ctrl)
+	decider := mock_monitor.NewMockDecider(ctrl)
+	api := admin.New(me)
+	api.SetDecider(decider)
+
+	res := httptest.NewRecorder()
+	api.ServeHTTP(res, httptest.NewRequest("POST", "/maintenance", nil))
+	if res.Code != http.StatusBadRequest {
+		test.Logf("wrong status code %v", res.Code)
+		test.Fail()
+	}
+
+	decider.EXPECT().Maintenance(true).Return(nil)
+	decider.EXPECT().Paused().Return(false)
+	decider.EXPECT().InMaintenance().Return(true)
+	decider.EXPECT().Planned().Return(false, nil)
+	decider.EXPECT().Decided().Return(monitor.Decision{})
+	expectStatus(me)
+	res = httptest.NewRecorder()
+	api.ServeHTTP(res, httptest.NewRequest("POST", "/maintenance?enabled=true", nil))
+	if res.Code != http.StatusOK {
+		test.Logf("wrong status code %v", res.Code)
+		test.FailNow()
+	}
+
+	status := admin.Status{}
+	if err := json.NewDecoder(res.Body).Decode(&status); err != nil {
+		test.Log(err)
+		test.FailNow()
+	}
+	if !status.Maintenance {
+		test.Logf("wrong status %+v", status)
+		test.Fail()
+	}
+}
+
+func TestReplicas(test *testing.T) {
+	ctrl := gomock.NewController(test)
+	defer ctrl.Finish()
+
+	me := mock_state.NewMockState(ctrl)
+	backup := mock_state.NewMockState(ctrl)
+	syncing := mock_state.NewMockState(ctrl)
+	api := admin.New(me)
+	api.SetCluster([]state.State{backup, syncing}, 5432)
+
+	me.EXPECT().GetDBRole().Return("active", nil)
+	backup.EXPECT().GetDBRole().Return("backup", nil)
+	backup.EXPECT().HasSynced().Return(true, nil)
+	backup.EXPECT().Location().Return("10.0.0.2:4400")
+	syncing.EXPECT().GetDBRole().Return("backup", nil)
+	syncing.EXPECT().HasSynced().Return(false, nil)
+
+	res := httptest.NewRecorder()
+	api.ServeHTTP(res, httptest.NewRequest("GET", "/replicas", nil))
+	if res.Code != http.StatusOK {
+		test.Logf("wrong status code %v", res.Code)
+		test.FailNow()
+	}
+
+	replicas := []admin.Replica{}
+	if err := json.NewDecoder(res.Body).Decode(&replicas); err != nil {
+		test.Log(err)
+		test.FailNow()
+	}
+	if len(replicas) != 1 || replicas[0].Endpoint != "10.0.0.2:5432" {
+		test.Logf("wrong replicas %v", replicas)
+		test.Fail()
+	}
+}
+
+// a state that knows the epoch it is in
+type epoched struct {
+	*mock_state.MockState
+	epoch uint64
+}
+
+func (node epoched) GetEpoch() (state.Epoch, error) {
+	return state.Epoch{Number: node.epoch}, nil
+}
+
+func TestPrimary(test *testing.T) {
+	ctrl := gomock.NewController(test)
+	defer ctrl.Finish()
+
+	me := epoched{mock_state.NewMockState(ctrl), 3}
+	stale := epoched{mock_state.NewMockState(ctrl), 2}
+	api := admin.New(me)
+	api.SetCluster([]state.State{stale}, 5432)
+
+	// the node that still takes writes from before the last takeover isn't reported
+	me.EXPECT().GetDBRole().Return("active", nil)
+	me.EXPECT().Location().Return("10.0.0.1:4400")
+	stale.EXPECT().GetDBRole().Return("single", nil)
+	res := httptest.NewRecorder()
+	api.ServeHTTP(res, httptest.NewRequest("GET", "/primary", nil))
+	if res.Code != http.StatusOK {
+		test.Logf("wrong status code %v", res.Code)
+		test.FailNow()
+	}
+	primary := admin.Primary{}
+	if err := json.NewDecoder(res.Body).Decode(&primary); err != nil {
+		test.Log(err)
+		test.FailNow()
+	}
+	if primary.Endpoint != "10.0.0.1:5432" || primary.DBRole != "active" || primary.Epoch != 3 {
+		test.Logf("wrong primary %+v", primary)
+		test.Fail()
+	}
+
+	me.EXPECT().GetDBRole().Return("backup", nil)
+	stale.EXPECT().GetDBRole().Return("dead", nil)
+	res = httptest.NewRecorder()
+	api.ServeHTTP(res, httptest.NewRequest("GET", "/primary", nil))
+	if res.Code != http.StatusServiceUnavailable {
+		test.Logf("a cluster without a writable node should have been unavailable, not %v", res.Code)
+		test.Fail()
+	}
+}
+
+func TestHistory(test *testing.T) {
+	ctrl := gomock.NewController(test)
+	defer ctrl.Finish()
+
+	me := mock_state.NewMockState(ctrl)
+	decider := mock_monitor.NewMockDecider(ctrl)
+	api := admin.New(me)
+
+	res := httptest.NewRecorder()
+	api.ServeHTTP(res, httptest.NewRequest("GET", "/history", nil))
+	if res.Code != http.StatusServiceUnavailable {
+		test.Logf("the history was served without a decider (%v)", res.Code)
+		test.Fail()
+	}
+
+	api.SetDecider(decider)
+	decider.EXPECT().History().Return([]monitor.HistoryEntry{{Trigger: "recheck", Rule: "no backup", To: state.Single, Outcome: monitor.Transitioned}})
+	res = httptest.NewRecorder()
+	api.ServeHTTP(res, httptest.NewRequest("GET", "/history", nil))
+	if res.Code != http.StatusOK {
+		test.Logf("wrong status code %v", res.Code)
+		test.FailNow()
+	}
+
+	history := []monitor.HistoryEntry{}
+	if err := json.NewDecoder(res.Body).Decode(&history); err != nil {
+		test.Log(err)
+		test.FailNow()
+	}
+	if len(history) != 1 || history[0].Rule != "no backup" || history[0].To != state.Single {
+		test.Logf("wrong history %v", history)
+		test.Fail()
+	}
+}
+
+func TestDecider(test *testing.T) {
+	ctrl := gomock.NewController(test)
+	defer ctrl.Finish()
+
+	me := mock_state.NewMockState(ctrl)
+	decider := mock_monitor.NewMockDecider(ctrl)
+	api := admin.New(me)
+	api.SetDecider(decider)
+
+	believed := monitor.ClusterState{
+		Trigger:  "recheck",
+		DBRole:   state.Backup,
+		Peers:    []monitor.PeerState{{Location: "127.0.0.1:4400", Reached: "unreachable"}},
+		Unknown:  1,
+		Decision: monitor.Decision{Rule: "wait for unchecked"},
+	}
+	decider.EXPECT().State().Return(believed)
+	decider.EXPECT().LastError().Return(monitor.ClusterUnaviable)
+	res := httptest.NewRecorder()
+	api.ServeHTTP(res, httptest.NewRequest("GET", "/decider", nil))
+	if res.Code != http.StatusOK {
+		test.Logf("wrong status code %v", res.Code)
+		test.FailNow()
+	}
+
+	belief := admin.Belief{}
+	if err := json.NewDecoder(res.Body).Decode(&belief); err != nil {
+		test.Log(err)
+		test.FailNow()
+	}
+	if belief.DBRole != state.Backup || belief.Unknown != 1 || len(belief.Peers) != 1 || belief.Peers[0].Reached != "unreachable" {
+		test.Logf("wrong belief %+v", belief)
+		test.Fail()
+	}
+	if belief.Decision.Rule != "wait for unchecked" || belief.Error != monitor.ClusterUnaviable.Error() {
+		test.Logf("wrong decision or error %+v", belief)
+		test.Fail()
+	}
+}
+
+func TestCluster(test *testing.T) {
+	ctrl := gomock.NewController(test)
+	defer ctrl.Finish()
+
+	me := mock_state.NewMockState(ctrl)
+	backup := mock_state.NewMockState(ctrl)
+	gone := mock_state.NewMockState(ctrl)
+	api := admin.New(me)
+	api.SetCluster([]state.State{backup, gone}, 5432)
+
+	expectStatus(me)
+	me.EXPECT().Lag().Return(time.Duration(0), int64(0), nil)
+	backup.EXPECT().Location().Return("10.0.0.2:4400")
+	backup.EXPECT().GetRole().Return("secondary", nil)
+	backup.EXPECT().GetDBRole().Return("backup", nil)
+	backup.EXPECT().HasSynced().Return(true, nil)
+	backup.EXPECT().GetPosition().Return(uint64(10), nil)
+	backup.EXPECT().Lag().Return(2*time.Second, int64(2), nil)
+	gone.EXPECT().Location().Return("10.0.0.3:4400")
+	gone.EXPECT().GetRole().Return("", errors.New("connection refused"))
+
+	res := httptest.NewRecorder()
+	api.ServeHTTP(res, httptest.NewRequest("GET", "/cluster", nil))
+	if res.Code != http.StatusOK {
+		test.Logf("wrong status code %v", res.Code)
+		test.FailNow()
+	}
+
+	cluster := []admin.Node{}
+	if err := json.NewDecoder(res.Body).Decode(&cluster); err != nil {
+		test.Log(err)
+		test.FailNow()
+	}
+	if len(cluster) != 3 || cluster[0].DBRole != "active" || cluster[1].LagSeconds != 2 || cluster[2].Error == "" {
+		test.Logf("wrong cluster %+v", cluster)
+		test.Fail()
+	}
+	// the backup is 2 bytes behind the active node
+	if len(cluster) == 3 && (cluster[0].Phase != "" || cluster[1].Phase != monitor.Streaming) {
+		test.Logf("wrong phases %+v", cluster)
+		test.Fail()
+	}
+}
+
+func TestReload(test *testing.T) {
+	ctrl := gomock.NewController(test)
+	defer ctrl.Finish()
+
+	me := mock_state.NewMockState(ctrl)
+	api := admin.New(me)
+
+	res := httptest.NewRecorder()
+	api.ServeHTTP(res, httptest.NewRequest("POST", "/reload", nil))
+	if res.Code != http.StatusServiceUnavailable {
+		test.Logf("wrong status code %v", res.Code)
+		test.Fail()
+	}
+
+	// a config that does not make sense is refused
+	api.SetReloader(func() error { return errors.New("bad check_interval") })
+	res = httptest.NewRecorder()
+	api.ServeHTTP(res, httptest.NewRequest("POST", "/reload", nil))
+	if res.Code != http.StatusBadRequest {
+		test.Logf("wrong status code %v", res.Code)
+		test.Fail()
+	}
+
+	reloaded := false
+	api.SetReloader(func() error {
+		reloaded = true
+		return nil
+	})
+	expectStatus(me)
+	res = httptest.NewRecorder()
+	api.ServeHTTP(res, httptest.NewRequest("POST", "/reload", nil))
+	if res.Code != http.StatusOK || !reloaded {
+		test.Logf("the config was not reloaded %v", res.Code)
+		test.Fail()
+	}
+}
+
+func TestReplace(test *testing.T) {
+	ctrl := gomock.NewController(test)
+	defer ctrl.Finish()
+
+	me := mock_state.NewMockState(ctrl)
+	api := admin.New(me)
+	replaced := map[string]string{}
+	api.SetReplacer(func(old, address string) error {
+		if old != "10.0.0.2:4400" {
+			return monitor.UnknownPeer
+		}
+		replaced[old] = address
+		return nil
+	})
+
+	res := httptest.NewRecorder()
+	api.ServeHTTP(res, httptest.NewRequest("POST", "/replace?old=10.0.0.9:4400&new=10.0.0.5:4400", nil))
+	if res.Code != http.StatusNotFound {
+		test.Logf("wrong status code %v", res.Code)
+		test.Fail()
+	}
+
+	expectStatus(me)
+	res = httptest.NewRecorder()
+	api.ServeHTTP(res, httptest.NewRequest("POST", "/replace?old=10.0.0.2:4400&new=10.0.0.5:4400", nil))
+	if res.Code != http.StatusOK || replaced["10.0.0.2:4400"] != "10.0.0.5:4400" {
+		test.Logf("the node was not replaced %v %v", res.Code, replaced)
+		test.Fail()
+	}
+}
+
+func TestChaos(test *testing.T) {
+	ctrl := gomock.NewController(test)
+	defer ctrl.Finish()
+
+	me := mock_state.NewMockState(ctrl)
+	api := admin.New(me)
+
+	res := httptest.NewRecorder()
+	api.ServeHTTP(res, httptest.NewRequest("GET", "/chaos", nil))
+	if res.Code != http.StatusServiceUnavailable {
+		test.Logf("wrong status code %v", res.Code)
+		test.Fail()
+	}
+
+	monkey, err := chaos.New(config.Config{ChaosFailureRate: 10})
+	if err != nil {
+		test.Log(err)
+		test.FailNow()
+	}
+	api.SetChaos(monkey)
+	res = httptest.NewRecorder()
+	api.ServeHTTP(res, httptest.NewRequest("POST", "/chaos?enabled=maybe", nil))
+	if res.Code != http.StatusBadRequest {
+		test.Logf("wrong status code %v", res.Code)
+		test.Fail()
+	}
+
+	res = httptest.NewRecorder()
+	api.ServeHTTP(res, httptest.NewRequest("POST", "/chaos?enabled=true", nil))
+	status := chaos.Status{}
+	if err := json.NewDecoder(res.Body).Decode(&status); err != nil {
+		test.Log(err)
+		test.FailNow()
+	}
+	if !status.Enabled || status.FailureRate != 10 || !monkey.Status().Enabled {
+		test.Logf("the chaos mode should have been turned on %+v", status)
+		test.Fail()
+	}
+}
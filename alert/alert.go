@@ -0,0 +1,159 @@
+// Copyright (c) 2015 Pagoda Box Inc
+//
+// This Source Code Form is subject to the terms of the Mozilla Public License, v.
+// 2.0. If a copy of the MPL was not distributed with this file, You can obtain one
+// at http://mozilla.org/MPL/2.0/.
+//
+
+// alert sends the events of a node to slack, pagerduty and email, as set up in the
+// [alert] section of the config. Every event has a severity, and every destination
+// is only sent the events that are at least as severe as its own severity.
+package alert
+
+import (
+	"fmt"
+	"github.com/nanopack/yoke/config"
+	"github.com/nanopack/yoke/events"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// Severity is how urgently someone should look at an event
+type Severity int
+
+const (
+	Info Severity = iota
+	Warning
+	Critical
+)
+
+type (
+	// Destination is somewhere alerts are sent to
+	Destination interface {
+		Send(event events.Event, severity Severity) error
+	}
+
+	// Alerter sends every event to the destinations it is severe enough for
+	Alerter struct {
+		routes []route
+	}
+
+	route struct {
+		name        string
+		min         Severity
+		destination Destination
+	}
+)
+
+// how severe each event is, the events that aren't listed are Info
+var severities = map[events.Type]Severity{
+	events.ClusterUnavailable: Critical,
+	events.SplitBrain:         Critical,
+	events.Unhealthy:          Critical,
+	events.TransitionFailed:   Critical,
+	events.RoleMismatch:       Critical,
+	events.WALDiscarded:       Critical,
+	events.DRPromoted:         Critical,
+	events.SyncUnverified:     Critical,
+	events.BackupUnverified:   Critical,
+	events.SyncLost:           Warning,
+	events.PromotionCompleted: Warning,
+	events.SingleCompleted:    Warning,
+	events.Stopped:            Warning,
+	events.RoleUnrecorded:     Warning,
+	events.WALBloat:           Warning,
+}
+
+// SeverityOf returns how severe an event of the type is
+func SeverityOf(kind events.Type) Severity {
+	return severities[kind]
+}
+
+// ParseSeverity parses 'info', 'warning' or 'critical'
+func ParseSeverity(name string) (Severity, error) {
+	switch name {
+	case "info":
+		return Info, nil
+	case "warning":
+		return Warning, nil
+	case "critical":
+		return Critical, nil
+	}
+	return Info, fmt.Errorf("unknown severity '%v'", name)
+}
+
+func (severity Severity) String() string {
+	switch severity {
+	case Warning:
+		return "warning"
+	case Critical:
+		return "critical"
+	}
+	return "info"
+}
+
+// New creates an alerter for the destinations of the [alert] section of the
+// config. The severities were confirmed when the config was read.
+func New(conf config.Config) *Alerter {
+	client := &http.Client{Timeout: time.Duration(conf.AlertTimeout) * time.Second}
+	alerter := &Alerter{}
+	if conf.AlertSlackURL != "" {
+		alerter.Route("slack", conf.AlertSlackSeverity, Slack{URL: conf.AlertSlackURL, Client: client})
+	}
+	if conf.AlertPagerKey != "" {
+		alerter.Route("pagerduty", conf.AlertPagerSeverity, PagerDuty{URL: conf.AlertPagerURL, Key: conf.AlertPagerKey, Client: client})
+	}
+	if len(conf.AlertEmails()) != 0 {
+		alerter.Route("email", conf.AlertEmailSeverity, Email{
+			Server:   conf.AlertSMTPServer,
+			User:     conf.AlertSMTPUser,
+			Password: conf.AlertSMTPPassword,
+			From:     conf.AlertEmailFrom,
+			To:       conf.AlertEmails(),
+			Timeout:  time.Duration(conf.AlertTimeout) * time.Second,
+		})
+	}
+	return alerter
+}
+
+// Route sends the events that are at least as severe as min to destination, an
+// unknown severity sends it every event
+func (alerter *Alerter) Route(name, min string, destination Destination) {
+	severity, _ := ParseSeverity(min)
+	alerter.routes = append(alerter.routes, route{name: name, min: severity, destination: destination})
+}
+
+// Enabled returns if any destination was set up
+func (alerter *Alerter) Enabled() bool {
+	return len(alerter.routes) != 0
+}
+
+// Handle is an events.Handler that sends the event to every destination it is
+// severe enough for
+func (alerter *Alerter) Handle(event events.Event) {
+	severity := SeverityOf(event.Type)
+	for _, route := range alerter.routes {
+		if severity < route.min {
+			continue
+		}
+		if err := route.destination.Send(event, severity); err != nil {
+			config.Log.Error("[alert] unable to send '%v' to %v: %v", event.Type, route.name, err)
+		}
+	}
+}
+
+// Summary describes the event in a line
+func Summary(event events.Event) string {
+	summary := fmt.Sprintf("yoke %v: %v", event.Node, strings.Replace(string(event.Type), "_", " ", -1))
+	if event.DBRole != "" {
+		summary += fmt.Sprintf(" (%v)", event.DBRole)
+	}
+	if event.Peer != "" {
+		summary += fmt.Sprintf(", peer %v", event.Peer)
+	}
+	if event.Error != "" {
+		summary += fmt.Sprintf(": %v", event.Error)
+	}
+	return summary
+}
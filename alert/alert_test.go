@@ -0,0 +1,135 @@
+// Copyright (c) 2015 Pagoda Box Inc
+//
+// This Source Code Form is subject to the terms of the Mozilla Public License, v.
+// 2.0. If a copy of the MPL was not distributed with this file, You can obtain one
+// at http://mozilla.org/MPL/2.0/.
+//
+
+package alert_test
+
+import (
+	"bufio"
+	"encoding/json"
+	"github.com/nanopack/yoke/alert"
+	"github.com/nanopack/yoke/config"
+	"github.com/nanopack/yoke/events"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestRoutes(test *testing.T) {
+	slack := []map[string]string{}
+	pager := []map[string]interface{}{}
+	server := httptest.NewServer(http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+		switch req.URL.Path {
+		case "/slack":
+			body := map[string]string{}
+			json.NewDecoder(req.Body).Decode(&body)
+			slack = append(slack, body)
+		case "/pagerduty":
+			body := map[string]interface{}{}
+			json.NewDecoder(req.Body).Decode(&body)
+			pager = append(pager, body)
+			res.WriteHeader(http.StatusAccepted)
+		}
+	}))
+	defer server.Close()
+
+	conf := config.Defaults
+	conf.AlertSlackURL = server.URL + "/slack"
+	conf.AlertPagerKey = "key"
+	conf.AlertPagerURL = server.URL + "/pagerduty"
+	alerter := alert.New(conf)
+	if !alerter.Enabled() {
+		test.Log("the alerter should have destinations")
+		test.FailNow()
+	}
+
+	alerter.Handle(events.Event{Type: events.DemotionStarted, Node: "10.0.0.1:4400"})
+	alerter.Handle(events.Event{Type: events.PromotionCompleted, Node: "10.0.0.1:4400", DBRole: "active"})
+	alerter.Handle(events.Event{Type: events.ClusterUnavailable, Node: "10.0.0.1:4400"})
+
+	// slack is sent warnings and worse, pagerduty only what is critical
+	if len(slack) != 2 || slack[0]["text"] != "yoke 10.0.0.1:4400: promotion completed (active)" {
+		test.Logf("wrong messages were sent to slack %v", slack)
+		test.Fail()
+	}
+	if len(pager) != 1 || pager[0]["routing_key"] != "key" || pager[0]["dedup_key"] != "yoke-10.0.0.1:4400-cluster_unavailable" {
+		test.Logf("wrong events were sent to pagerduty %v", pager)
+		test.Fail()
+	}
+}
+
+// a mail server that only understands enough to take a single mail
+func mailServer(test *testing.T, mails chan string) net.Listener {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		test.Log(err)
+		test.FailNow()
+	}
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		reader := bufio.NewReader(conn)
+		conn.Write([]byte("220 localhost\r\n"))
+		mail := ""
+		for {
+			line, err := reader.ReadString('\n')
+			if err != nil {
+				return
+			}
+			switch command := strings.ToUpper(strings.TrimSpace(line)); {
+			case strings.HasPrefix(command, "EHLO"), strings.HasPrefix(command, "HELO"):
+				conn.Write([]byte("250 localhost\r\n"))
+			case command == "DATA":
+				conn.Write([]byte("354 go ahead\r\n"))
+				for {
+					line, err := reader.ReadString('\n')
+					if err != nil || line == ".\r\n" {
+						break
+					}
+					mail += line
+				}
+				mails <- mail
+				conn.Write([]byte("250 ok\r\n"))
+			case command == "QUIT":
+				conn.Write([]byte("221 bye\r\n"))
+				return
+			default:
+				conn.Write([]byte("250 ok\r\n"))
+			}
+		}
+	}()
+	return listener
+}
+
+func TestEmail(test *testing.T) {
+	mails := make(chan string, 1)
+	listener := mailServer(test, mails)
+	defer listener.Close()
+
+	conf := config.Defaults
+	conf.AlertSMTPServer = listener.Addr().String()
+	conf.AlertEmailFrom = "yoke@example.com"
+	conf.AlertEmailTo = "ops@example.com"
+	alerter := alert.New(conf)
+	alerter.Handle(events.Event{Type: events.SyncLost, Node: "10.0.0.1:4400"})
+	alerter.Handle(events.Event{Type: events.SplitBrain, Node: "10.0.0.1:4400", Peer: "10.0.0.2:4400"})
+
+	select {
+	case mail := <-mails:
+		if !strings.Contains(mail, "Subject: [critical] yoke 10.0.0.1:4400: split brain, peer 10.0.0.2:4400") {
+			test.Logf("wrong mail was sent:\n%v", mail)
+			test.Fail()
+		}
+	default:
+		test.Log("no mail was sent")
+		test.Fail()
+	}
+}
@@ -0,0 +1,157 @@
+// Copyright (c) 2015 Pagoda Box Inc
+//
+// This Source Code Form is subject to the terms of the Mozilla Public License, v.
+// 2.0. If a copy of the MPL was not distributed with this file, You can obtain one
+// at http://mozilla.org/MPL/2.0/.
+//
+
+package alert
+
+import (
+	"bytes"
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"github.com/nanopack/yoke/events"
+	"net"
+	"net/http"
+	"net/smtp"
+	"strings"
+	"time"
+)
+
+type (
+	// Slack posts alerts to a slack incoming webhook
+	Slack struct {
+		URL    string
+		Client *http.Client
+	}
+
+	// PagerDuty triggers incidents through the pagerduty events api v2. Alerts for
+	// the same kind of event on the same node are grouped into one incident.
+	PagerDuty struct {
+		URL    string
+		Key    string // the integration (routing) key of the service
+		Client *http.Client
+	}
+
+	// Email sends alerts as plain text mails through an smtp server, with
+	// STARTTLS when the server offers it
+	Email struct {
+		Server   string // host:port
+		User     string // no authentication when this is empty
+		Password string
+		From     string
+		To       []string
+		Timeout  time.Duration
+	}
+)
+
+func (slack Slack) Send(event events.Event, severity Severity) error {
+	text := Summary(event)
+	if severity == Critical {
+		text = ":rotating_light: " + text
+	}
+	return post(slack.Client, slack.URL, map[string]string{"text": text})
+}
+
+func (pager PagerDuty) Send(event events.Event, severity Severity) error {
+	return post(pager.Client, pager.URL, map[string]interface{}{
+		"routing_key":  pager.Key,
+		"event_action": "trigger",
+		"dedup_key":    fmt.Sprintf("yoke-%v-%v", event.Node, event.Type),
+		"payload": map[string]interface{}{
+			"summary":        Summary(event),
+			"source":         event.Node,
+			"severity":       severity.String(),
+			"timestamp":      event.Time.Format(time.RFC3339),
+			"component":      "yoke",
+			"class":          string(event.Type),
+			"custom_details": event,
+		},
+	})
+}
+
+func (email Email) Send(event events.Event, severity Severity) error {
+	summary := Summary(event)
+	body := &bytes.Buffer{}
+	fmt.Fprintf(body, "From: %v\r\n", email.From)
+	fmt.Fprintf(body, "To: %v\r\n", strings.Join(email.To, ", "))
+	fmt.Fprintf(body, "Subject: [%v] %v\r\n", severity, summary)
+	fmt.Fprintf(body, "Date: %v\r\n", time.Now().Format(time.RFC1123Z))
+	fmt.Fprintf(body, "Content-Type: text/plain; charset=utf-8\r\n\r\n")
+	details, err := json.MarshalIndent(event, "", "  ")
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(body, "%v\r\n\r\n%s\r\n", summary, details)
+	return email.send(body.Bytes())
+}
+
+// like smtp.SendMail, with a timeout
+func (email Email) send(message []byte) error {
+	conn, err := net.DialTimeout("tcp", email.Server, email.Timeout)
+	if err != nil {
+		return err
+	}
+	if email.Timeout > 0 {
+		conn.SetDeadline(time.Now().Add(email.Timeout))
+	}
+	host, _, err := net.SplitHostPort(email.Server)
+	if err != nil {
+		conn.Close()
+		return err
+	}
+	client, err := smtp.NewClient(conn, host)
+	if err != nil {
+		conn.Close()
+		return err
+	}
+	defer client.Close()
+
+	if ok, _ := client.Extension("STARTTLS"); ok {
+		if err := client.StartTLS(&tls.Config{ServerName: host}); err != nil {
+			return err
+		}
+	}
+	if email.User != "" {
+		if err := client.Auth(smtp.PlainAuth("", email.User, email.Password, host)); err != nil {
+			return err
+		}
+	}
+	if err := client.Mail(email.From); err != nil {
+		return err
+	}
+	for _, to := range email.To {
+		if err := client.Rcpt(to); err != nil {
+			return err
+		}
+	}
+	data, err := client.Data()
+	if err != nil {
+		return err
+	}
+	if _, err := data.Write(message); err != nil {
+		return err
+	}
+	if err := data.Close(); err != nil {
+		return err
+	}
+	return client.Quit()
+}
+
+func post(client *http.Client, url string, body interface{}) error {
+	encoded, err := json.Marshal(body)
+	if err != nil {
+		return err
+	}
+	res, err := client.Post(url, "application/json", bytes.NewReader(encoded))
+	if err != nil {
+		return err
+	}
+	res.Body.Close()
+	if res.StatusCode < 200 || res.StatusCode > 299 {
+		return fmt.Errorf("unexpected response %v", res.Status)
+	}
+	return nil
+}
@@ -0,0 +1,324 @@
+// Copyright (c) 2015 Pagoda Box Inc
+//
+// This Source Code Form is subject to the terms of the Mozilla Public License, v.
+// 2.0. If a copy of the MPL was not distributed with this file, You can obtain one
+// at http://mozilla.org/MPL/2.0/.
+//
+
+// archive takes base backups of the local database and stores them next to the
+// archived WAL segments, so the database can be recovered to any point in time.
+// The archive destination is in any of the storage of the storage package.
+package archive
+
+import (
+	"errors"
+	"fmt"
+	"github.com/nanopack/yoke/config"
+	"github.com/nanopack/yoke/storage"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Layout names base backups by the time they were taken, names sort in the
+// order the backups were taken
+const Layout = "20060102T150405Z"
+
+var (
+	NoDestination = errors.New("there is no archive destination configured")
+	NoBackup      = errors.New("there is no base backup from before the target time")
+	NotEmpty      = errors.New("the data directory has to be empty to restore into it")
+)
+
+// BaseBackup takes a base backup of the local database and stores it in the archive
+func BaseBackup(conf config.Config) (string, error) {
+	if conf.ArchiveDestination == "" {
+		return "", NoDestination
+	}
+	store, err := conf.Storage()
+	if err != nil {
+		return "", err
+	}
+
+	dir, err := ioutil.TempDir("", "yoke-base")
+	if err != nil {
+		return "", err
+	}
+	defer os.RemoveAll(dir)
+
+	version, err := conf.DataVersion()
+	if err != nil {
+		return "", err
+	}
+	name := time.Now().UTC().Format(Layout)
+	config.Log.Info("[archive] taking base backup '%v'", name)
+	err = run("pg_basebackup",
+		"-h", "localhost",
+		"-p", fmt.Sprintf("%d", conf.PGPort),
+		"-U", conf.SystemUser,
+		"-D", dir,
+		"--format=tar",
+		baseCompression(conf, version),
+		version.WALMethod("fetch"),
+		"--checkpoint=fast")
+	if err != nil {
+		return "", err
+	}
+	// without a manifest the WAL the backup needs is never pruned
+	encryption := config.Encryption{Name: conf.ArchiveEncryption, Key: conf.ArchiveEncryptionKey}
+	if err := writeManifest(dir, name, encryption); err != nil {
+		config.Log.Warn("[archive] base backup '%v' has no manifest %v", name, err)
+	}
+	if err := encrypt(dir, encryption); err != nil {
+		return "", err
+	}
+
+	if err := store.Upload(dir, "base/"+name); err != nil {
+		return "", err
+	}
+	config.Log.Info("[archive] stored base backup '%v'", name)
+	return name, nil
+}
+
+// Restore puts the last base backup taken before target into the empty data
+// directory, and configures postgres to replay the archived WAL up to target the
+// next time it starts.
+func Restore(conf config.Config, target time.Time) error {
+	if conf.ArchiveDestination == "" {
+		return NoDestination
+	}
+
+	entries, err := ioutil.ReadDir(conf.DataDir)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	if len(entries) != 0 {
+		return NotEmpty
+	}
+
+	store, err := conf.Storage()
+	if err != nil {
+		return err
+	}
+	backups, err := list(store, "base")
+	if err != nil {
+		return err
+	}
+	name := LatestBefore(backups, target)
+	if name == "" {
+		return NoBackup
+	}
+
+	config.Log.Info("[archive] restoring base backup '%v'", name)
+	if err := unpack(conf, name); err != nil {
+		return err
+	}
+	return writeRecovery(conf, target)
+}
+
+// unpack downloads the base backup name and unpacks it into the data directory
+func unpack(conf config.Config, name string) error {
+	dir, err := ioutil.TempDir("", "yoke-restore")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(dir)
+
+	store, err := conf.Storage()
+	if err != nil {
+		return err
+	}
+	if err := store.Download("base/"+name, dir); err != nil {
+		return err
+	}
+	if err := decrypt(conf, dir); err != nil {
+		return err
+	}
+	if err := os.MkdirAll(conf.DataDir, 0700); err != nil {
+		return err
+	}
+	base, tool, err := baseFile(dir)
+	if err != nil {
+		return err
+	}
+	args := []string{"-xf", base, "-C", conf.DataDir}
+	if tool != "" {
+		args = append([]string{"-I", tool}, args...)
+	}
+	return run("tar", args...)
+}
+
+// encrypt encrypts every file of the base backup in dir but its manifest, nothing
+// of it is uploaded unencrypted
+func encrypt(dir string, encryption config.Encryption) error {
+	if !encryption.Encrypted() {
+		return nil
+	}
+	entries, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return err
+	}
+	for _, entry := range entries {
+		if entry.IsDir() || entry.Name() == "manifest.json" {
+			continue
+		}
+		path := filepath.Join(dir, entry.Name())
+		if err := run("bash", "-c", encryption.Encrypt(path, path+encryption.Suffix())); err != nil {
+			return err
+		}
+		if err := os.Remove(path); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// decrypt decrypts the files of the base backup in dir that are encrypted
+func decrypt(conf config.Config, dir string) error {
+	entries, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return err
+	}
+	for _, entry := range entries {
+		encryption := conf.EncryptionOf(entry.Name())
+		if entry.IsDir() || !encryption.Encrypted() {
+			continue
+		}
+		path := filepath.Join(dir, entry.Name())
+		if err := run("bash", "-c", encryption.Decrypt(path, strings.TrimSuffix(path, encryption.Suffix()))); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// baseCompression is the flag base backups are compressed with, the compression
+// of the archive when postgres can compress with it and gzip otherwise
+func baseCompression(conf config.Config, version config.PGVersion) string {
+	compression := config.Compression{Name: conf.ArchiveCompression, Level: conf.ArchiveCompressLevel}
+	if flag := version.BackupCompression(compression, "client"); flag != "" {
+		return flag
+	}
+	return "--gzip"
+}
+
+// baseFile finds the tar of the base backup that was downloaded into dir, and the
+// tool tar decompresses it with, none when it isn't compressed
+func baseFile(dir string) (path, tool string, err error) {
+	for _, name := range append([]string{"none"}, config.Compressions...) {
+		path = filepath.Join(dir, "base.tar"+config.Compression{Name: name}.Suffix())
+		if _, err := os.Stat(path); err != nil {
+			continue
+		}
+		if name == "none" {
+			return path, "", nil
+		}
+		return path, name, nil
+	}
+	return "", "", errors.New("there is no base.tar in the base backup")
+}
+
+// LatestBefore returns the name of the last of the base backups that was taken at
+// or before target, or an empty string if there is none
+func LatestBefore(backups []string, target time.Time) string {
+	sort.Strings(backups)
+	latest := ""
+	for _, name := range backups {
+		taken, err := time.Parse(Layout, name)
+		if err != nil || taken.After(target) {
+			continue
+		}
+		latest = name
+	}
+	return latest
+}
+
+// writes a recovery.conf that replays the archive up to target and then lets the
+// database start accepting writes again, a zero target replays all of it. Postgres
+// 12 and later take the same parameters from the postgresql.auto.conf while there
+// is a recovery.signal.
+func writeRecovery(conf config.Config, target time.Time) error {
+	recoveryTarget := ""
+	if !target.IsZero() {
+		recoveryTarget = target.UTC().Format("2006-01-02 15:04:05 MST")
+	}
+	if version, err := conf.DataVersion(); err == nil && version.AtLeast(12) {
+		err := conf.SetAutoConf(map[string]string{
+			"restore_command":        conf.RestoreCommand(),
+			"recovery_target_time":   recoveryTarget,
+			"recovery_target_action": "promote",
+		})
+		if err != nil {
+			return err
+		}
+		// a backup taken on a backup node would otherwise stay a backup
+		if err := os.Remove(filepath.Join(conf.DataDir, "standby.signal")); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		return ioutil.WriteFile(filepath.Join(conf.DataDir, "recovery.signal"), nil, 0600)
+	}
+	if target.IsZero() {
+		return ioutil.WriteFile(filepath.Join(conf.DataDir, "recovery.conf"), []byte(fmt.Sprintf(`#~-----------------------------------------------------------------------------
+# YOKE CONFIG
+#------------------------------------------------------------------------------
+
+# IMPORTANT: this config file was generated by Yoke to recover the database from
+# the archive, postgres renames it once the recovery has finished.
+
+restore_command = '%s'
+`, conf.RestoreCommand())), 0600)
+	}
+	return ioutil.WriteFile(filepath.Join(conf.DataDir, "recovery.conf"), []byte(fmt.Sprintf(`#~-----------------------------------------------------------------------------
+# YOKE CONFIG
+#------------------------------------------------------------------------------
+
+# IMPORTANT: this config file was generated by Yoke to recover the database to
+# a point in time, postgres renames it once the recovery has finished.
+
+restore_command = '%s'
+recovery_target_time = '%s'
+recovery_target_action = 'promote'
+`, conf.RestoreCommand(), target.UTC().Format("2006-01-02 15:04:05 MST"))), 0600)
+}
+
+// list returns the names of the entries directly under remote
+func list(store storage.Driver, remote string) ([]string, error) {
+	entries, err := store.List(remote)
+	if err != nil {
+		return nil, err
+	}
+	names := []string{}
+	for _, entry := range entries {
+		names = append(names, entry.Name)
+	}
+	return names, nil
+}
+
+// listFiles returns the files directly under remote
+func listFiles(store storage.Driver, remote string) ([]storage.Entry, error) {
+	entries, err := store.List(remote)
+	if err != nil {
+		return nil, err
+	}
+	files := []storage.Entry{}
+	for _, entry := range entries {
+		if !entry.Dir {
+			files = append(files, entry)
+		}
+	}
+	return files, nil
+}
+
+func run(name string, args ...string) error {
+	config.Log.Debug("[archive] running %v %v", name, args)
+	cmd := exec.Command(name, args...)
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("%v failed: %v %s", name, err, strings.TrimSpace(string(out)))
+	}
+	return nil
+}
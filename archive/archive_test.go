@@ -0,0 +1,168 @@
+// Copyright (c) 2015 Pagoda Box Inc
+//
+// This Source Code Form is subject to the terms of the Mozilla Public License, v.
+// 2.0. If a copy of the MPL was not distributed with this file, You can obtain one
+// at http://mozilla.org/MPL/2.0/.
+//
+
+package archive_test
+
+import (
+	"github.com/nanopack/yoke/archive"
+	"github.com/nanopack/yoke/config"
+	"github.com/nanopack/yoke/metrics"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestLatestBefore(test *testing.T) {
+	backups := []string{"20260102T000000Z", "20260101T000000Z", "garbage", "20260103T000000Z"}
+
+	target := time.Date(2026, 1, 2, 12, 0, 0, 0, time.UTC)
+	if name := archive.LatestBefore(backups, target); name != "20260102T000000Z" {
+		test.Logf("wrong backup was picked '%v'", name)
+		test.Fail()
+	}
+
+	target = time.Date(2025, 12, 31, 0, 0, 0, 0, time.UTC)
+	if name := archive.LatestBefore(backups, target); name != "" {
+		test.Logf("no backup should have been picked, got '%v'", name)
+		test.Fail()
+	}
+}
+
+func TestSchedule(test *testing.T) {
+	schedule, err := archive.ParseSchedule("*/15 3 * * 1-5")
+	if err != nil {
+		test.Log(err)
+		test.FailNow()
+	}
+
+	// a monday
+	if !schedule.Matches(time.Date(2026, 1, 5, 3, 30, 0, 0, time.UTC)) {
+		test.Log("the schedule should fire at 3:30 on a monday")
+		test.Fail()
+	}
+	if schedule.Matches(time.Date(2026, 1, 5, 3, 31, 0, 0, time.UTC)) {
+		test.Log("the schedule should not fire at 3:31")
+		test.Fail()
+	}
+	// a sunday
+	if schedule.Matches(time.Date(2026, 1, 4, 3, 30, 0, 0, time.UTC)) {
+		test.Log("the schedule should not fire on a sunday")
+		test.Fail()
+	}
+
+	for _, spec := range []string{"* * * *", "60 * * * *", "a * * * *", "*/0 * * * *"} {
+		if _, err := archive.ParseSchedule(spec); err == nil {
+			test.Logf("'%v' should not parse", spec)
+			test.Fail()
+		}
+	}
+}
+
+func TestVerifyBackup(test *testing.T) {
+	conf := config.Defaults
+	conf.ArchiveDestination = ""
+
+	// a backup that couldn't be verified counts as failed
+	verification, err := archive.VerifyBackup(conf)
+	if err != archive.NoDestination || verification.Error == "" {
+		test.Logf("there is nothing to verify without a destination %+v %v", verification, err)
+		test.Fail()
+	}
+	if failed := metrics.Values()["yoke_backup_verifications_total"]["failed"]; failed != 1 {
+		test.Logf("the failed verification was not counted %v", failed)
+		test.Fail()
+	}
+
+	// no verify_schedule never verifies
+	if (archive.Schedule{}).Matches(time.Now()) {
+		test.Log("an empty schedule should never fire")
+		test.Fail()
+	}
+}
+
+func TestPrune(test *testing.T) {
+	dir, err := ioutil.TempDir("", "yoke-archive")
+	if err != nil {
+		test.Log(err)
+		test.FailNow()
+	}
+	defer os.RemoveAll(dir)
+
+	conf := config.Defaults
+	conf.ArchiveDestination = dir
+	conf.ArchiveRetainCount = 2
+	conf.ArchiveRetainWALDays = 1
+	now := time.Date(2026, 1, 10, 0, 0, 0, 0, time.UTC)
+
+	backups := map[string]string{
+		"20260101T000000Z": "000000010000000000000002",
+		"20260102T000000Z": "000000010000000000000004",
+		"20260103T000000Z": "000000020000000000000006",
+	}
+	for name, start := range backups {
+		os.MkdirAll(filepath.Join(dir, "base", name), 0700)
+		ioutil.WriteFile(filepath.Join(dir, "base", name, "manifest.json"), []byte(`{"name":"`+name+`","start_wal":"`+start+`"}`), 0600)
+	}
+	os.MkdirAll(filepath.Join(dir, "wal"), 0700)
+	old := now.Add(-48 * time.Hour)
+	for _, name := range []string{"000000010000000000000002.zst", "000000010000000000000003", "000000010000000000000003.00000028.backup", "00000002.history", "000000010000000000000004", "000000020000000000000005.partial", "000000020000000000000006"} {
+		ioutil.WriteFile(filepath.Join(dir, "wal", name), nil, 0600)
+		os.Chtimes(filepath.Join(dir, "wal", name), old, old)
+	}
+	// archived within the retain_wal_days
+	recent := now.Add(-time.Hour)
+	os.Chtimes(filepath.Join(dir, "wal", "000000010000000000000003"), recent, recent)
+
+	if err := archive.Prune(conf, now); err != nil {
+		test.Log(err)
+		test.FailNow()
+	}
+	kept, _ := filepath.Glob(filepath.Join(dir, "base", "*"))
+	if len(kept) != 2 || filepath.Base(kept[0]) != "20260102T000000Z" {
+		test.Logf("the two most recent backups should have been kept %v", kept)
+		test.Fail()
+	}
+	// the oldest kept backup starts at segment 4
+	want := []string{"000000010000000000000003", "000000010000000000000004", "00000002.history", "000000020000000000000005.partial", "000000020000000000000006"}
+	wal, _ := filepath.Glob(filepath.Join(dir, "wal", "*"))
+	if len(wal) != len(want) {
+		test.Logf("should have kept %v, not %v", want, wal)
+		test.FailNow()
+	}
+	for i, name := range want {
+		if filepath.Base(wal[i]) != name {
+			test.Logf("should have kept %v, not %v", want, wal)
+			test.Fail()
+			break
+		}
+	}
+}
+
+func TestClone(test *testing.T) {
+	dir, err := ioutil.TempDir("", "yoke-clone")
+	if err != nil {
+		test.Log(err)
+		test.FailNow()
+	}
+	defer os.RemoveAll(dir)
+
+	conf := config.Defaults
+	conf.ArchiveDestination = ""
+	if err := archive.Clone(conf, filepath.Join(dir, "data"), ""); err != archive.NoDestination {
+		test.Logf("there is nothing to clone without a destination (%v)", err)
+		test.Fail()
+	}
+
+	// a clone never lands on top of a database
+	ioutil.WriteFile(filepath.Join(dir, "PG_VERSION"), []byte("15"), 0600)
+	if err := archive.Clone(conf, dir, "10.0.0.2:5432"); err != archive.NotEmpty {
+		test.Logf("the clone should not have gone into a directory that isn't empty (%v)", err)
+		test.Fail()
+	}
+}
@@ -0,0 +1,141 @@
+// Copyright (c) 2015 Pagoda Box Inc
+//
+// This Source Code Form is subject to the terms of the Mozilla Public License, v.
+// 2.0. If a copy of the MPL was not distributed with this file, You can obtain one
+// at http://mozilla.org/MPL/2.0/.
+//
+
+package archive
+
+import (
+	"fmt"
+	"github.com/nanopack/yoke/config"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// how long a clone gets to start before it is left to start on its own, one
+// that replays a lot of WAL takes longer
+var cloneStartTimeout = 60 * time.Second
+
+// Clone makes a disposable copy of the database in the empty directory dir on this
+// host and starts it on the pg_port, for staging or analytics. The copy is taken
+// from the database at from ('host:port', e.g. a backup node), or from the last
+// base backup and the WAL archived since when from is empty. The copy takes writes
+// once it is up to date, and is a database of its own from then on: it never
+// archives into the archive, and none of the nodes know about it, so the roles of
+// the cluster are left alone.
+func Clone(conf config.Config, dir, from string) error {
+	entries, err := ioutil.ReadDir(dir)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	if len(entries) != 0 {
+		return NotEmpty
+	}
+	clone := conf
+	clone.DataDir = strings.TrimSuffix(dir, "/") + "/"
+
+	if from == "" {
+		err = cloneBackup(clone)
+	} else {
+		err = cloneDatabase(clone, from)
+	}
+	if err != nil {
+		return err
+	}
+
+	// the postgresql.conf came along with the copy
+	err = clone.SetAutoConf(map[string]string{
+		"port":                      fmt.Sprintf("%d", conf.PGPort),
+		"archive_mode":              "off",
+		"archive_command":           "",
+		"synchronous_standby_names": "",
+	})
+	if err != nil {
+		return err
+	}
+
+	config.Log.Info("[archive] starting the clone in '%v' on port %v", dir, conf.PGPort)
+	log := filepath.Join(clone.DataDir, "clone.log")
+	err = run("pg_ctl", "start", "-D", clone.DataDir, "-w", "-t", fmt.Sprintf("%d", int(cloneStartTimeout.Seconds())), "-l", log)
+	if err != nil {
+		if exec.Command("pg_ctl", "status", "-D", clone.DataDir).Run() != nil {
+			return err
+		}
+		config.Log.Info("[archive] the clone is still replaying WAL, see '%v'", log)
+	}
+	return nil
+}
+
+// cloneBackup restores the last base backup into the data directory of clone, and
+// has it replay all of the archived WAL
+func cloneBackup(clone config.Config) error {
+	if clone.ArchiveDestination == "" {
+		return NoDestination
+	}
+	store, err := clone.Storage()
+	if err != nil {
+		return err
+	}
+	backups, err := list(store, "base")
+	if err != nil {
+		return err
+	}
+	name := LatestBefore(backups, time.Now())
+	if name == "" {
+		return NoBackup
+	}
+
+	config.Log.Info("[archive] cloning base backup '%v' into '%v'", name, clone.DataDir)
+	if err := unpack(clone, name); err != nil {
+		return err
+	}
+	return writeRecovery(clone, time.Time{})
+}
+
+// cloneDatabase copies the database at from into the data directory of clone with
+// pg_basebackup, a backup node can be copied from as well as the active node
+func cloneDatabase(clone config.Config, from string) error {
+	host, port := from, fmt.Sprintf("%d", clone.PGPort)
+	if i := strings.LastIndex(from, ":"); i > 0 {
+		host, port = from[:i], from[i+1:]
+	}
+	// 'pg_basebackup (PostgreSQL) 15.3'
+	out, err := exec.Command("pg_basebackup", "--version").Output()
+	if err != nil {
+		return err
+	}
+	fields := append([]string{""}, strings.Fields(string(out))...)
+	version, err := config.ParsePGVersion(fields[len(fields)-1])
+	if err != nil {
+		return err
+	}
+
+	config.Log.Info("[archive] cloning the database at '%v' into '%v'", from, clone.DataDir)
+	backup := exec.Command("pg_basebackup",
+		"-h", host,
+		"-p", port,
+		"-U", clone.ReplicationRole(),
+		"-D", clone.DataDir,
+		version.WALMethod("stream"),
+		"--checkpoint=fast")
+	if clone.ReplicationPassword != "" {
+		backup.Env = append(os.Environ(), "PGPASSWORD="+clone.ReplicationPassword)
+	}
+	if out, err := backup.CombinedOutput(); err != nil {
+		return fmt.Errorf("pg_basebackup failed: %v %s", err, strings.TrimSpace(string(out)))
+	}
+
+	// a copy of a backup node would follow the active node
+	for _, name := range []string{"standby.signal", "recovery.signal", "recovery.conf"} {
+		if err := os.Remove(filepath.Join(clone.DataDir, name)); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,90 @@
+// Copyright (c) 2015 Pagoda Box Inc
+//
+// This Source Code Form is subject to the terms of the Mozilla Public License, v.
+// 2.0. If a copy of the MPL was not distributed with this file, You can obtain one
+// at http://mozilla.org/MPL/2.0/.
+//
+
+package archive
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/nanopack/yoke/config"
+	"github.com/nanopack/yoke/storage"
+	"io/ioutil"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+)
+
+// Manifest describes a base backup, it is stored next to it as manifest.json.
+// Base backups taken before there were manifests have none.
+type Manifest struct {
+	Name        string `json:"name"`
+	StartWAL    string `json:"start_wal"`            // the first WAL segment a restore of the backup replays
+	Compression string `json:"compression"`          // of the base.tar
+	Encryption  string `json:"encryption,omitempty"` // of every file of the backup
+	Key         string `json:"key,omitempty"`        // the age recipients, or the key the data keys are wrapped with
+}
+
+// the segment in the 'START WAL LOCATION: 0/2000028 (file 000000010000000000000002)'
+// line of a backup_label
+var startWAL = regexp.MustCompile(`START WAL LOCATION: .* \(file ([0-9A-F]{24})\)`)
+
+// writeManifest describes the base backup name that pg_basebackup wrote into dir,
+// from the backup_label in its base.tar, and the encryption it is going to get
+func writeManifest(dir, name string, encryption config.Encryption) error {
+	base, tool, err := baseFile(dir)
+	if err != nil {
+		return err
+	}
+	args := []string{"-xOf", base, "backup_label"}
+	if tool != "" {
+		args = append([]string{"-I", tool}, args...)
+	}
+	label, err := exec.Command("tar", args...).Output()
+	if err != nil {
+		return fmt.Errorf("the backup_label could not be read from '%v' (%v)", base, err)
+	}
+	match := startWAL.FindSubmatch(label)
+	if match == nil {
+		return fmt.Errorf("the backup_label of '%v' has no start WAL location", name)
+	}
+	manifest := Manifest{Name: name, StartWAL: string(match[1]), Compression: "none"}
+	if tool != "" {
+		manifest.Compression = tool
+	}
+	if encryption.Encrypted() {
+		manifest.Encryption, manifest.Key = encryption.Name, encryption.Key
+	}
+	contents, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(filepath.Join(dir, "manifest.json"), contents, 0600)
+}
+
+// readManifest reads the manifest of the base backup name, the manifest is empty
+// when the backup has none
+func readManifest(store storage.Driver, name string) (Manifest, error) {
+	manifest := Manifest{}
+	contents, err := store.Read("base/" + name + "/manifest.json")
+	if err != nil {
+		// the backups taken before there were manifests
+		if files, listed := list(store, "base/"+name); listed == nil && !contains(files, "manifest.json") {
+			return manifest, nil
+		}
+		return manifest, err
+	}
+	return manifest, json.Unmarshal(contents, &manifest)
+}
+
+func contains(names []string, name string) bool {
+	for _, each := range names {
+		if each == name {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,278 @@
+// Copyright (c) 2015 Pagoda Box Inc
+//
+// This Source Code Form is subject to the terms of the Mozilla Public License, v.
+// 2.0. If a copy of the MPL was not distributed with this file, You can obtain one
+// at http://mozilla.org/MPL/2.0/.
+//
+
+package archive
+
+import (
+	"context"
+	"fmt"
+	"github.com/nanopack/yoke/config"
+	"github.com/nanopack/yoke/state"
+	"github.com/nanopack/yoke/storage"
+	"sort"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+type (
+	// Schedule is a cron style schedule, 'minute hour day-of-month month day-of-week'.
+	// Every field can be '*', a number, a range ('1-5'), a step ('*/15') or a comma
+	// separated list of those.
+	Schedule struct {
+		fields [5]map[int]bool
+	}
+
+	// Scheduler takes base backups on the schedule from the config, and verifies the
+	// last one on the verify_schedule. Both are done on a backup node so the active
+	// node does not have to carry the load.
+	Scheduler struct {
+		conf      config.Config
+		schedule  Schedule
+		verify    Schedule
+		verifying int32 // a verification is running, they can take longer than a minute
+		me        state.State
+		others    []state.State
+	}
+)
+
+// the range of values each of the fields of a schedule can have
+var bounds = [5][2]int{{0, 59}, {0, 23}, {1, 31}, {1, 12}, {0, 6}}
+
+// ParseSchedule parses a cron style schedule
+func ParseSchedule(spec string) (Schedule, error) {
+	schedule := Schedule{}
+	fields := strings.Fields(spec)
+	if len(fields) != 5 {
+		return schedule, fmt.Errorf("a schedule needs 5 fields, got '%v'", spec)
+	}
+	for i, field := range fields {
+		values, err := parseField(field, bounds[i][0], bounds[i][1])
+		if err != nil {
+			return schedule, err
+		}
+		schedule.fields[i] = values
+	}
+	return schedule, nil
+}
+
+func parseField(field string, min, max int) (map[int]bool, error) {
+	values := map[int]bool{}
+	for _, part := range strings.Split(field, ",") {
+		step := 1
+		if i := strings.Index(part, "/"); i != -1 {
+			var err error
+			if step, err = strconv.Atoi(part[i+1:]); err != nil || step < 1 {
+				return nil, fmt.Errorf("bad step in '%v'", field)
+			}
+			part = part[:i]
+		}
+
+		low, high := min, max
+		switch {
+		case part == "*":
+		case strings.Contains(part, "-"):
+			bounds := strings.SplitN(part, "-", 2)
+			var err error
+			if low, err = strconv.Atoi(bounds[0]); err != nil {
+				return nil, fmt.Errorf("bad range in '%v'", field)
+			}
+			if high, err = strconv.Atoi(bounds[1]); err != nil {
+				return nil, fmt.Errorf("bad range in '%v'", field)
+			}
+		default:
+			value, err := strconv.Atoi(part)
+			if err != nil {
+				return nil, fmt.Errorf("bad value in '%v'", field)
+			}
+			low, high = value, value
+		}
+		if low < min || high > max || low > high {
+			return nil, fmt.Errorf("'%v' is out of range", field)
+		}
+
+		for value := low; value <= high; value += step {
+			values[value] = true
+		}
+	}
+	return values, nil
+}
+
+// Matches checks if the schedule fires during the minute of t
+func (schedule Schedule) Matches(t time.Time) bool {
+	return schedule.fields[0][t.Minute()] &&
+		schedule.fields[1][t.Hour()] &&
+		schedule.fields[2][t.Day()] &&
+		schedule.fields[3][int(t.Month())] &&
+		schedule.fields[4][int(t.Weekday())]
+}
+
+// NewScheduler creates a scheduler for the local node from the [archive] section
+// of the config. others are the other nodes in the cluster that run a database. A
+// schedule that is empty never fires.
+func NewScheduler(conf config.Config, me state.State, others []state.State) (*Scheduler, error) {
+	scheduler := &Scheduler{
+		conf:   conf,
+		me:     me,
+		others: others,
+	}
+	var err error
+	if conf.ArchiveSchedule != "" {
+		if scheduler.schedule, err = ParseSchedule(conf.ArchiveSchedule); err != nil {
+			return nil, err
+		}
+	}
+	if conf.VerifyBackupSchedule != "" {
+		if scheduler.verify, err = ParseSchedule(conf.VerifyBackupSchedule); err != nil {
+			return nil, err
+		}
+	}
+	return scheduler, nil
+}
+
+// Run checks the schedule every minute until ctx is done
+func (scheduler *Scheduler) Run(ctx context.Context) {
+	ticker := time.NewTicker(time.Minute)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			if scheduler.verify.Matches(now) && scheduler.responsible() && atomic.CompareAndSwapInt32(&scheduler.verifying, 0, 1) {
+				go func() {
+					defer atomic.StoreInt32(&scheduler.verifying, 0)
+					VerifyBackup(scheduler.conf)
+				}()
+			}
+			if !scheduler.schedule.Matches(now) || !scheduler.responsible() {
+				continue
+			}
+			if _, err := BaseBackup(scheduler.conf); err != nil {
+				config.Log.Error("[archive] scheduled base backup failed %v", err)
+				continue
+			}
+			if err := Prune(scheduler.conf, now); err != nil {
+				config.Log.Error("[archive] pruning old base backups failed %v", err)
+			}
+		}
+	}
+}
+
+// only one node takes the scheduled backup, the first of the synced backups
+func (scheduler *Scheduler) responsible() bool {
+	if role, err := scheduler.me.GetDBRole(); err != nil || state.DBRole(role) != state.Backup {
+		return false
+	}
+	if synced, err := scheduler.me.HasSynced(); err != nil || !synced {
+		return false
+	}
+	location := scheduler.me.Location()
+	for _, other := range scheduler.others {
+		if role, err := other.GetDBRole(); err != nil || state.DBRole(role) != state.Backup {
+			continue
+		}
+		if synced, err := other.HasSynced(); err == nil && synced && other.Location() < location {
+			return false
+		}
+	}
+	return true
+}
+
+// Prune removes the base backups that fall outside of the retention policy in the
+// config, and then the archived WAL that is older than retain_wal_days and that
+// none of the base backups that were kept replays. The most recent base backup is
+// always kept.
+func Prune(conf config.Config, now time.Time) error {
+	store, err := conf.Storage()
+	if err != nil {
+		return err
+	}
+	backups, err := list(store, "base")
+	if err != nil {
+		return err
+	}
+	sort.Sort(sort.Reverse(sort.StringSlice(backups)))
+
+	kept := []string{}
+	for _, name := range backups {
+		taken, err := time.Parse(Layout, name)
+		if err != nil {
+			continue
+		}
+		if len(kept) == 0 {
+			kept = append(kept, name)
+			continue
+		}
+		tooMany := conf.ArchiveRetainCount != 0 && len(kept) >= conf.ArchiveRetainCount
+		tooOld := conf.ArchiveRetainDays != 0 && now.Sub(taken) > time.Duration(conf.ArchiveRetainDays)*24*time.Hour
+		if tooMany || tooOld {
+			config.Log.Info("[archive] removing base backup '%v'", name)
+			if err := store.Remove("base/" + name); err != nil {
+				return err
+			}
+			continue
+		}
+		kept = append(kept, name)
+	}
+
+	if conf.ArchiveRetainWALDays == 0 || len(kept) == 0 {
+		return nil
+	}
+	return pruneWAL(conf, store, kept, now)
+}
+
+// pruneWAL removes the WAL segments that were archived more than retain_wal_days
+// ago, and that come before the first one the oldest of the kept base backups
+// replays. Nothing is removed when that backup has no manifest to tell where its
+// WAL starts.
+func pruneWAL(conf config.Config, store storage.Driver, kept []string, now time.Time) error {
+	oldest := kept[len(kept)-1]
+	manifest, err := readManifest(store, oldest)
+	if err != nil {
+		return err
+	}
+	if manifest.StartWAL == "" {
+		config.Log.Warn("[archive] base backup '%v' has no manifest, no WAL is pruned", oldest)
+		return nil
+	}
+
+	segments, err := listFiles(store, "wal")
+	if err != nil {
+		return err
+	}
+	retained := now.Add(-time.Duration(conf.ArchiveRetainWALDays) * 24 * time.Hour)
+	prunable := []string{}
+	for _, segment := range segments {
+		if segment.Modified.Before(retained) && WALBefore(segment.Name, manifest.StartWAL) {
+			prunable = append(prunable, segment.Name)
+		}
+	}
+	if len(prunable) == 0 {
+		return nil
+	}
+	config.Log.Info("[archive] removing %v WAL segments from before '%v', where base backup '%v' starts", len(prunable), manifest.StartWAL, oldest)
+	return store.RemoveAll("wal", prunable)
+}
+
+// WALBefore checks if the archived file name holds WAL from before the segment
+// start: a segment, compressed or not, a partial segment or the .backup file of a
+// base backup. The timelines are not compared, a new timeline carries on from the
+// WAL position of the old one. The .history files of the timelines are always
+// kept.
+func WALBefore(name, start string) bool {
+	if len(name) < 24 || len(start) != 24 || strings.HasSuffix(name, ".history") {
+		return false
+	}
+	for _, c := range name[:24] {
+		if !strings.ContainsRune("0123456789ABCDEF", c) {
+			return false
+		}
+	}
+	return name[8:24] < start[8:]
+}
@@ -0,0 +1,156 @@
+// Copyright (c) 2015 Pagoda Box Inc
+//
+// This Source Code Form is subject to the terms of the Mozilla Public License, v.
+// 2.0. If a copy of the MPL was not distributed with this file, You can obtain one
+// at http://mozilla.org/MPL/2.0/.
+//
+
+package archive
+
+import (
+	"fmt"
+	"github.com/nanopack/yoke/config"
+	"github.com/nanopack/yoke/events"
+	"github.com/nanopack/yoke/metrics"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// Verification is how the check of a base backup turned out
+type Verification struct {
+	Backup  string        `json:"backup"`
+	Started time.Time     `json:"started"`
+	Took    time.Duration `json:"took"`
+	Results []string      `json:"results,omitempty"` // what each of the verify_queries returned
+	Error   string        `json:"error,omitempty"`
+}
+
+var (
+	backupVerifications = metrics.NewCounter("yoke_backup_verifications_total", "Number of base backups this node restored to verify them, by how they turned out.", "result")
+	backupVerified      = metrics.NewGauge("yoke_backup_verified_timestamp_seconds", "When the last base backup that verified was taken, as a unix timestamp.", "")
+	backupVerifyTime    = metrics.NewTimer("yoke_backup_verify_seconds", "How long restoring a base backup to verify it took.", "")
+)
+
+// VerifyBackup restores the last base backup and the WAL archived since into a
+// scratch database next to the running one, and runs the verify_queries against it
+// once it has replayed all of it. The scratch database only listens on a socket in
+// its own directory, doesn't archive anything and is thrown away afterwards. How
+// it turned out is counted in the metrics and published as an event, so a backup
+// that doesn't restore is alerted on.
+func VerifyBackup(conf config.Config) (Verification, error) {
+	verification := Verification{Started: time.Now()}
+	err := verifyBackup(conf, &verification)
+	verification.Took = time.Since(verification.Started)
+	backupVerifyTime.Observe("", verification.Took)
+	if err != nil {
+		verification.Error = err.Error()
+		backupVerifications.Inc("failed")
+		config.Log.Error("[archive] base backup '%v' did not verify %v", verification.Backup, err)
+		events.Publish(events.Event{Type: events.BackupUnverified, Error: verification.Error})
+		return verification, err
+	}
+	backupVerifications.Inc("ok")
+	if taken, err := time.Parse(Layout, verification.Backup); err == nil {
+		backupVerified.Set("", float64(taken.Unix()))
+	}
+	config.Log.Info("[archive] base backup '%v' verified in %v", verification.Backup, verification.Took)
+	events.Publish(events.Event{Type: events.BackupVerified})
+	return verification, nil
+}
+
+func verifyBackup(conf config.Config, verification *Verification) error {
+	if conf.ArchiveDestination == "" {
+		return NoDestination
+	}
+	store, err := conf.Storage()
+	if err != nil {
+		return err
+	}
+	backups, err := list(store, "base")
+	if err != nil {
+		return err
+	}
+	verification.Backup = LatestBefore(backups, verification.Started)
+	if verification.Backup == "" {
+		return NoBackup
+	}
+
+	dir, err := ioutil.TempDir("", "yoke-verify")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(dir)
+	scratch := conf
+	scratch.DataDir = filepath.Join(dir, "data") + "/"
+
+	config.Log.Info("[archive] restoring base backup '%v' into '%v' to verify it", verification.Backup, dir)
+	if err := unpack(scratch, verification.Backup); err != nil {
+		return err
+	}
+	if err := writeRecovery(scratch, time.Time{}); err != nil {
+		return err
+	}
+	// only yoke connects to the scratch database, over its socket
+	hba := filepath.Join(dir, "pg_hba.conf")
+	if err := ioutil.WriteFile(hba, []byte("local all all trust\n"), 0600); err != nil {
+		return err
+	}
+
+	timeout := time.Duration(conf.VerifyBackupTimeout) * time.Second
+	options := strings.Join([]string{
+		"-p", fmt.Sprintf("%d", conf.PGPort),
+		"-c", "listen_addresses=''",
+		"-c", "unix_socket_directories='" + dir + "'",
+		"-c", "hba_file='" + hba + "'",
+		"-c", "archive_mode=off",
+		"-c", "synchronous_standby_names=''",
+		"-c", "hot_standby=on",
+		"-c", "shared_buffers=128MB",
+	}, " ")
+	// a start that timed out can leave it running all the same
+	defer run("pg_ctl", "stop", "-D", scratch.DataDir, "-m", "immediate")
+	err = run("pg_ctl", "start", "-D", scratch.DataDir, "-w", "-t", fmt.Sprintf("%d", int(timeout.Seconds())), "-l", filepath.Join(dir, "postgres.log"), "-o", options)
+	if err != nil {
+		return err
+	}
+
+	// the scratch database takes writes once it replayed everything there is
+	deadline := verification.Started.Add(timeout)
+	for {
+		recovering, err := query(conf, dir, "select pg_is_in_recovery()")
+		if err == nil && recovering == "f" {
+			break
+		}
+		if time.Now().After(deadline) {
+			return fmt.Errorf("was still recovering after %v (%v)", timeout, err)
+		}
+		<-time.After(time.Second)
+	}
+
+	for _, sql := range strings.Split(conf.VerifyBackupQueries, ";") {
+		if sql = strings.TrimSpace(sql); sql == "" {
+			continue
+		}
+		result, err := query(conf, dir, sql)
+		if err != nil {
+			return err
+		}
+		config.Log.Info("[archive] '%v' returned '%v'", sql, result)
+		verification.Results = append(verification.Results, result)
+	}
+	return nil
+}
+
+// query runs sql against the scratch database with its socket in dir, and returns
+// the first row it returned
+func query(conf config.Config, dir, sql string) (string, error) {
+	out, err := exec.Command("psql", "-h", dir, "-p", fmt.Sprintf("%d", conf.PGPort), "-U", conf.SystemUser, "-d", "postgres", "-X", "-A", "-t", "-v", "ON_ERROR_STOP=1", "-c", sql).CombinedOutput()
+	if err != nil {
+		return "", fmt.Errorf("'%v' failed: %v %s", sql, err, strings.TrimSpace(string(out)))
+	}
+	return strings.TrimSpace(strings.SplitN(string(out), "\n", 2)[0]), nil
+}
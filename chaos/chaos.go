@@ -0,0 +1,197 @@
+// Copyright (c) 2015 Pagoda Box Inc
+//
+// This Source Code Form is subject to the terms of the Mozilla Public License, v.
+// 2.0. If a copy of the MPL was not distributed with this file, You can obtain one
+// at http://mozilla.org/MPL/2.0/.
+//
+
+// chaos injects faults into a running node, so that a staging cluster can show how
+// the applications on top of it cope with failovers. While it is on, calls to the
+// other nodes fail or are slowed down at random, and the active node is demoted
+// at random, handing the active role over to a backup. It is off unless the
+// config or the admin api turns it on, and turning it off stops it at once.
+
+package chaos
+
+import (
+	"context"
+	"github.com/nanopack/yoke/archive"
+	"github.com/nanopack/yoke/config"
+	"github.com/nanopack/yoke/state"
+	"math/rand"
+	"sync"
+	"time"
+)
+
+type (
+	// Monkey injects the faults of the [chaos] section of the config
+	Monkey struct {
+		sync.Mutex
+		enabled        bool
+		schedule       *archive.Schedule // nil injects at any time
+		spec           string
+		failureRate    int
+		latency        time.Duration
+		transitionRate int
+		random         *rand.Rand
+		injected       Injected
+	}
+
+	// Injected counts the faults that were injected since the node started
+	Injected struct {
+		Failures    int `json:"failures"`
+		Delays      int `json:"delays"`
+		Transitions int `json:"transitions"`
+	}
+
+	// Status is what the monkey reports about itself
+	Status struct {
+		Enabled        bool     `json:"enabled"`
+		Active         bool     `json:"active"` // if faults are injected right now, going by the schedule
+		Schedule       string   `json:"schedule,omitempty"`
+		FailureRate    int      `json:"failure_rate"`
+		LatencyMs      int      `json:"latency_ms"`
+		TransitionRate int      `json:"transition_rate"`
+		Injected       Injected `json:"injected"`
+	}
+
+	// Demoter is what forced transitions are made through, the decider is one
+	Demoter interface {
+		Demote() error
+	}
+)
+
+// New creates a monkey from the [chaos] section of the config
+func New(conf config.Config) (*Monkey, error) {
+	monkey := &Monkey{random: rand.New(rand.NewSource(time.Now().UnixNano()))}
+	return monkey, monkey.Reload(conf)
+}
+
+// Reload takes the [chaos] section of conf over, what was injected so far is kept
+func (monkey *Monkey) Reload(conf config.Config) error {
+	var schedule *archive.Schedule
+	if conf.ChaosSchedule != "" {
+		parsed, err := archive.ParseSchedule(conf.ChaosSchedule)
+		if err != nil {
+			return err
+		}
+		schedule = &parsed
+	}
+	monkey.Lock()
+	defer monkey.Unlock()
+	if monkey.enabled != conf.ChaosEnabled {
+		monkey.announce(conf.ChaosEnabled)
+	}
+	monkey.enabled = conf.ChaosEnabled
+	monkey.schedule = schedule
+	monkey.spec = conf.ChaosSchedule
+	monkey.failureRate = conf.ChaosFailureRate
+	monkey.latency = time.Duration(conf.ChaosLatency) * time.Millisecond
+	monkey.transitionRate = conf.ChaosTransitionRate
+	return nil
+}
+
+// Enable turns the monkey on or off, off is the kill switch: nothing is injected
+// from then on, until it is turned on again
+func (monkey *Monkey) Enable(enabled bool) {
+	monkey.Lock()
+	defer monkey.Unlock()
+	if monkey.enabled != enabled {
+		monkey.announce(enabled)
+	}
+	monkey.enabled = enabled
+}
+
+func (monkey *Monkey) announce(enabled bool) {
+	if enabled {
+		config.Log.Warn("[chaos] faults will be injected into this node")
+		return
+	}
+	config.Log.Info("[chaos] faults are no longer injected")
+}
+
+// Status reports how the monkey is set up and what it injected so far
+func (monkey *Monkey) Status() Status {
+	monkey.Lock()
+	defer monkey.Unlock()
+	return Status{
+		Enabled:        monkey.enabled,
+		Active:         monkey.active(time.Now()),
+		Schedule:       monkey.spec,
+		FailureRate:    monkey.failureRate,
+		LatencyMs:      int(monkey.latency / time.Millisecond),
+		TransitionRate: monkey.transitionRate,
+		Injected:       monkey.injected,
+	}
+}
+
+// Inject slows a call to another node down by up to the latency, and fails it at
+// the failure rate as if it timed out
+func (monkey *Monkey) Inject(location string) error {
+	monkey.Lock()
+	if !monkey.active(time.Now()) {
+		monkey.Unlock()
+		return nil
+	}
+	var delay time.Duration
+	if monkey.latency > 0 {
+		delay = time.Duration(monkey.random.Int63n(int64(monkey.latency) + 1))
+		monkey.injected.Delays++
+	}
+	fail := monkey.roll(monkey.failureRate)
+	if fail {
+		monkey.injected.Failures++
+	}
+	monkey.Unlock()
+
+	<-time.After(delay)
+	if fail {
+		config.Log.With(config.Fields{"peer": location}).Debug("[chaos] failing the call")
+		return state.Timeout
+	}
+	return nil
+}
+
+// Run demotes the node at the transition rate every interval while it is active,
+// until ctx is done
+func (monkey *Monkey) Run(ctx context.Context, me state.State, demoter Demoter, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			if !monkey.transition(now, me) {
+				continue
+			}
+			config.Log.Warn("[chaos] demoting the active node")
+			if err := demoter.Demote(); err != nil {
+				config.Log.Error("[chaos] the node could not be demoted %v", err)
+			}
+		}
+	}
+}
+
+// decides if the node is demoted now
+func (monkey *Monkey) transition(now time.Time, me state.State) bool {
+	monkey.Lock()
+	defer monkey.Unlock()
+	if !monkey.active(now) || !monkey.roll(monkey.transitionRate) {
+		return false
+	}
+	if role, err := me.GetDBRole(); err != nil || state.DBRole(role) != state.Active {
+		return false
+	}
+	monkey.injected.Transitions++
+	return true
+}
+
+func (monkey *Monkey) active(now time.Time) bool {
+	return monkey.enabled && (monkey.schedule == nil || monkey.schedule.Matches(now))
+}
+
+// rolls a dice that comes up rate percent of the time
+func (monkey *Monkey) roll(rate int) bool {
+	return rate > 0 && monkey.random.Intn(100) < rate
+}
@@ -0,0 +1,112 @@
+// Copyright (c) 2015 Pagoda Box Inc
+//
+// This Source Code Form is subject to the terms of the Mozilla Public License, v.
+// 2.0. If a copy of the MPL was not distributed with this file, You can obtain one
+// at http://mozilla.org/MPL/2.0/.
+//
+
+package chaos_test
+
+import (
+	"context"
+	"github.com/golang/mock/gomock"
+	"github.com/nanopack/yoke/chaos"
+	"github.com/nanopack/yoke/config"
+	"github.com/nanopack/yoke/state"
+	"github.com/nanopack/yoke/state/mock"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+type demoter chan struct{}
+
+// a node whose db role can be changed while the monkey runs
+type node struct {
+	*mock_state.MockState
+	dbRole atomic.Value
+}
+
+func (me *node) GetDBRole() (string, error) {
+	return me.dbRole.Load().(string), nil
+}
+
+func (demoted demoter) Demote() error {
+	demoted <- struct{}{}
+	return nil
+}
+
+func TestInject(test *testing.T) {
+	monkey, err := chaos.New(config.Config{ChaosFailureRate: 100})
+	if err != nil {
+		test.Log(err)
+		test.FailNow()
+	}
+	if err := monkey.Inject("10.0.0.2:4400"); err != nil {
+		test.Logf("nothing should be injected until the monkey is enabled %v", err)
+		test.Fail()
+	}
+
+	monkey.Enable(true)
+	if err := monkey.Inject("10.0.0.2:4400"); err != state.Timeout {
+		test.Logf("the call should have failed %v", err)
+		test.Fail()
+	}
+
+	// the kill switch
+	monkey.Enable(false)
+	if err := monkey.Inject("10.0.0.2:4400"); err != nil {
+		test.Logf("nothing should be injected once the monkey is disabled %v", err)
+		test.Fail()
+	}
+	if status := monkey.Status(); status.Enabled || status.Injected.Failures != 1 {
+		test.Logf("wrong status %+v", status)
+		test.Fail()
+	}
+
+	// a schedule that never matches
+	if err := monkey.Reload(config.Config{ChaosEnabled: true, ChaosFailureRate: 100, ChaosSchedule: "0 0 30 2 *"}); err != nil {
+		test.Log(err)
+		test.FailNow()
+	}
+	if err := monkey.Inject("10.0.0.2:4400"); err != nil {
+		test.Logf("nothing should be injected outside of the schedule %v", err)
+		test.Fail()
+	}
+	if err := monkey.Reload(config.Config{ChaosSchedule: "every day"}); err == nil {
+		test.Log("a bad schedule should have been refused")
+		test.Fail()
+	}
+}
+
+func TestForcedTransitions(test *testing.T) {
+	ctrl := gomock.NewController(test)
+	defer ctrl.Finish()
+
+	me := &node{MockState: mock_state.NewMockState(ctrl)}
+	me.dbRole.Store(string(state.Backup))
+	monkey, err := chaos.New(config.Config{ChaosEnabled: true, ChaosTransitionRate: 100})
+	if err != nil {
+		test.Log(err)
+		test.FailNow()
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	demoted := make(demoter, 1)
+
+	go monkey.Run(ctx, me, demoted, 10*time.Millisecond)
+	select {
+	case <-demoted:
+		test.Log("a backup should not have been demoted")
+		test.FailNow()
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	me.dbRole.Store(string(state.Active))
+	select {
+	case <-demoted:
+	case <-time.After(time.Second):
+		test.Log("the active node should have been demoted")
+		test.FailNow()
+	}
+}
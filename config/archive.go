@@ -0,0 +1,160 @@
+// Copyright (c) 2015 Pagoda Box Inc
+//
+// This Source Code Form is subject to the terms of the Mozilla Public License, v.
+// 2.0. If a copy of the MPL was not distributed with this file, You can obtain one
+// at http://mozilla.org/MPL/2.0/.
+//
+
+// archive.go builds the commands postgres uses to ship WAL segments to the archive
+// destination, compressed and encrypted if it is set to, and to fetch them back
+// during recovery. The storage of the destination copies them, see storage.
+
+package config
+
+import (
+	"fmt"
+	"github.com/nanopack/yoke/storage"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Storage is the driver of the storage the archive destination is in
+func (conf Config) Storage() (storage.Driver, error) {
+	return storage.New(conf.ArchiveStorage, conf.ArchiveDestination, conf.ArchiveEndpoint)
+}
+
+// ArchiveCommand returns the archive_command postgres runs for every finished WAL
+// segment. Nothing is archived when there is no archive destination. A compressed
+// or encrypted segment is packed into a temporary file first, so that a segment
+// that was only packed half way is never archived.
+func (conf Config) ArchiveCommand() string {
+	if conf.ArchiveDestination == "" {
+		return "exit 0"
+	}
+	driver, err := conf.Storage()
+	if err != nil {
+		// postgres keeps the segment until it is archived
+		return "exit 1"
+	}
+	packing := packing{conf.archiveCompression(), conf.archiveEncryption()}
+	if !packing.packed() {
+		return driver.Put("%p", "wal/%f")
+	}
+
+	suffix := packing.suffix()
+	temp := filepath.Join(os.TempDir(), "yoke-%f"+suffix)
+	return fmt.Sprintf("%s && %s && rm -f %s", packing.pack("%p", temp), driver.Put(temp, "wal/%f"+suffix), temp)
+}
+
+// RestoreCommand returns the restore_command postgres runs to fetch a WAL segment
+// back out of the archive. The archive can hold segments of every compression it
+// was ever set to, encrypted or not, the ones it is set to are tried first and
+// then the others.
+func (conf Config) RestoreCommand() string {
+	if conf.ArchiveDestination == "" {
+		return "exit 0"
+	}
+	driver, err := conf.Storage()
+	if err != nil {
+		return "exit 1"
+	}
+	compression := conf.archiveCompression()
+	compressions := []Compression{compression}
+	if compression.Compressed() {
+		compressions = append(compressions, Compression{Name: "none"})
+	}
+	for _, name := range Compressions {
+		if name != compression.Name {
+			compressions = append(compressions, Compression{Name: name})
+		}
+	}
+	encryptions := []Encryption{conf.archiveEncryption()}
+	if encryptions[0].Encrypted() {
+		encryptions = append(encryptions, Encryption{Name: "none"})
+	}
+
+	tries := []string{}
+	for _, encryption := range encryptions {
+		for _, compression := range compressions {
+			tries = append(tries, restore(driver, packing{compression, encryption}))
+		}
+	}
+	if len(tries) == 1 {
+		return tries[0]
+	}
+	return "(" + strings.Join(tries, ") || (") + ")"
+}
+
+// restore fetches a WAL segment that was archived with packing
+func restore(driver storage.Driver, packing packing) string {
+	suffix := packing.suffix()
+	archived := "wal/%f" + suffix
+	if !packing.packed() {
+		return driver.Get(archived, "%p")
+	}
+	fetched := filepath.Join(os.TempDir(), "yoke-%f"+suffix)
+	return fmt.Sprintf("%s && %s && rm -f %s", driver.Get(archived, fetched), packing.unpack(fetched, "%p"), fetched)
+}
+
+// packing is the compression and then the encryption a WAL segment is archived with
+type packing struct {
+	compression Compression
+	encryption  Encryption
+}
+
+func (packing packing) packed() bool {
+	return packing.compression.Compressed() || packing.encryption.Encrypted()
+}
+
+func (packing packing) suffix() string {
+	return packing.compression.Suffix() + packing.encryption.Suffix()
+}
+
+// pack is the shell command that compresses and encrypts the file in into out
+func (packing packing) pack(in, out string) string {
+	switch {
+	case packing.compression.Compressed() && packing.encryption.Encrypted():
+		between := out + ".packing"
+		return fmt.Sprintf("%s && %s && rm -f %s", packing.compression.Compress(in, between), packing.encryption.Encrypt(between, out), between)
+	case packing.encryption.Encrypted():
+		return packing.encryption.Encrypt(in, out)
+	}
+	return packing.compression.Compress(in, out)
+}
+
+// unpack is the shell command that decrypts and decompresses the file in into out
+func (packing packing) unpack(in, out string) string {
+	switch {
+	case packing.compression.Compressed() && packing.encryption.Encrypted():
+		between := out + ".packing"
+		return fmt.Sprintf("%s && %s && rm -f %s", packing.encryption.Decrypt(in, between), packing.compression.Decompress(between, out), between)
+	case packing.encryption.Encrypted():
+		return packing.encryption.Decrypt(in, out)
+	}
+	return packing.compression.Decompress(in, out)
+}
+
+// the compression WAL segments are archived with
+func (conf Config) archiveCompression() Compression {
+	if conf.ArchiveCompression == "" {
+		return Compression{Name: "none"}
+	}
+	return Compression{Name: conf.ArchiveCompression, Level: conf.ArchiveCompressLevel}
+}
+
+// the driver of the storage has to understand the destination
+func confirmStorage() error {
+	if Conf.ArchiveDestination == "" {
+		return nil
+	}
+	// the storage of a plugin is only there once the plugin was started
+	_, plugin := Conf.Plugin(Conf.ArchiveStorage)
+	if _, err := Conf.Storage(); err != nil && !plugin {
+		return fmt.Errorf("I could not understand the [archive] destination, %v (storage:'%s' destination:'%s').", err, Conf.ArchiveStorage, Conf.ArchiveDestination)
+	}
+	if strings.Contains(Conf.ArchiveDestination+Conf.ArchiveEndpoint, "'") || strings.ContainsAny(Conf.ArchiveDestination+Conf.ArchiveEndpoint, " \t") {
+		return fmt.Errorf("I can only archive to a destination without quotes or spaces.")
+	}
+	return nil
+}
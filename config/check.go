@@ -0,0 +1,184 @@
+// Copyright (c) 2015 Pagoda Box Inc
+//
+// This Source Code Form is subject to the terms of the Mozilla Public License, v.
+// 2.0. If a copy of the MPL was not distributed with this file, You can obtain one
+// at http://mozilla.org/MPL/2.0/.
+//
+
+// check.go reads the config file strictly, an option yoke doesn't know is most
+// likely a typo that would otherwise leave the default in place without a word.
+// It also checks what the options point at for 'yoke <config> check-config'.
+
+package config
+
+import (
+	"fmt"
+	"github.com/nanopack/yoke/state"
+	"github.com/vaughan0/go-ini"
+	"net"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// options is the config file as it is parsed, it keeps track of the options that
+// were read and of the ones that could not be
+type options struct {
+	ini.File
+	read map[string]bool
+	errs []error
+}
+
+func newOptions(file ini.File) *options {
+	return &options{File: file, read: map[string]bool{}}
+}
+
+// Get returns the option name of section, and remembers that it is known. A flag
+// or an environment variable wins over the file.
+func (file *options) Get(section, name string) (string, bool) {
+	key := section + "." + name
+	file.read[key] = true
+	if value, ok := Flags[key]; ok {
+		return value, true
+	}
+	if value, ok := os.LookupEnv(envName(section, name)); ok {
+		return value, true
+	}
+	return file.File.Get(section, name)
+}
+
+// sections returns the name of every section of the file and of the flags
+func (file *options) sections() []string {
+	seen := map[string]bool{}
+	for section := range file.File {
+		seen[section] = true
+	}
+	for key := range Flags {
+		seen[key[:strings.LastIndex(key, ".")]] = true
+	}
+	sections := make([]string, 0, len(seen))
+	for section := range seen {
+		sections = append(sections, section)
+	}
+	sort.Strings(sections)
+	return sections
+}
+
+// section returns every option of a section whose names yoke can't know up front,
+// from the file and the flags. They can't be looked up in the environment.
+func (file *options) section(section string) map[string]string {
+	options := map[string]string{}
+	for name, value := range file.File[section] {
+		file.read[section+"."+name] = true
+		options[name] = value
+	}
+	for key, value := range Flags {
+		if i := strings.LastIndex(key, "."); key[:i] == section {
+			file.read[key] = true
+			options[key[i+1:]] = value
+		}
+	}
+	return options
+}
+
+// unknown returns a problem for every option of the file that was never read
+func (file *options) unknown() []error {
+	var names []string
+	for section, options := range file.File {
+		for name := range options {
+			if !file.read[section+"."+name] {
+				names = append(names, fmt.Sprintf("[%s] %s", section, name))
+			}
+		}
+	}
+	for key := range Flags {
+		if !file.read[key] {
+			names = append(names, "--"+strings.TrimPrefix(key, "config."))
+		}
+	}
+	sort.Strings(names)
+	errs := make([]error, 0, len(names))
+	for _, name := range names {
+		errs = append(errs, fmt.Errorf("I do not know the option %s, is it misspelled?", name))
+	}
+	return errs
+}
+
+// CheckEnvironment checks what the options of conf point at without starting
+// anything: that the directories are there or can be created, that the files are
+// there, that the node can listen on its address and that the other nodes and the
+// monitors answer within timeout.
+func (conf Config) CheckEnvironment(timeout time.Duration) []error {
+	var errs []error
+	dialed := map[string]bool{}
+	for _, db := range conf.Databases() {
+		location := db.AdvertiseAddress()
+		dirs := [][2]string{{"status_dir", db.StatusDir}}
+		if state.Role(db.Role) != state.Monitor {
+			dirs = append(dirs, [2]string{"data_dir", db.DataDir})
+		}
+		for _, dir := range dirs {
+			if err := creatable(dir[1]); err != nil {
+				errs = append(errs, fmt.Errorf("I can not use the %s (%v)", dir[0], err))
+			}
+		}
+
+		listener, err := net.Listen("tcp", location)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("I can not listen on '%s', is yoke running already? (%v)", location, err))
+		} else {
+			listener.Close()
+		}
+
+		dialed[location] = true
+		for _, address := range append(db.Others(location), db.Monitors()...) {
+			if dialed[address] {
+				continue
+			}
+			dialed[address] = true
+			conn, err := net.DialTimeout("tcp", address, timeout)
+			if err != nil {
+				errs = append(errs, fmt.Errorf("I can not reach '%s' (%v)", address, err))
+				continue
+			}
+			conn.Close()
+		}
+	}
+
+	for _, file := range [][2]string{
+		{"[tls] cert", conf.TLSCert},
+		{"[tls] key", conf.TLSKey},
+		{"[tls] ca", conf.TLSCA},
+		{"[dns] key_file", conf.DNSKeyFile},
+		{"[pgbouncer] config_file", conf.PgbouncerConfig},
+	} {
+		if file[1] == "" {
+			continue
+		}
+		if _, err := os.Stat(file[1]); err != nil {
+			errs = append(errs, fmt.Errorf("I can not find the %s (%v)", file[0], err))
+		}
+	}
+	return errs
+}
+
+// creatable checks that dir is a directory, or that the directory it would be
+// created in is there
+func creatable(dir string) error {
+	info, err := os.Stat(dir)
+	switch {
+	case os.IsNotExist(err):
+		parent := filepath.Dir(strings.TrimSuffix(dir, "/"))
+		if _, err := os.Stat(parent); err != nil {
+			return err
+		}
+		return nil
+	case err != nil:
+		return err
+	case !info.IsDir():
+		return fmt.Errorf("'%s' is not a directory", dir)
+	}
+	return nil
+}
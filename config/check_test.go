@@ -0,0 +1,205 @@
+// Copyright (c) 2015 Pagoda Box Inc
+//
+// This Source Code Form is subject to the terms of the Mozilla Public License, v.
+// 2.0. If a copy of the MPL was not distributed with this file, You can obtain one
+// at http://mozilla.org/MPL/2.0/.
+//
+
+package config_test
+
+import (
+	"github.com/nanopack/yoke/config"
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestCheck(test *testing.T) {
+	defer func() { config.Conf = config.Defaults }()
+	file, err := ioutil.TempFile("", "yoke.ini")
+	if err != nil {
+		test.Log(err)
+		test.FailNow()
+	}
+	defer os.Remove(file.Name())
+
+	valid := `[config]
+role=primary
+advertise_ip=10.0.0.1
+primary=10.0.0.1:4400
+secondary=10.0.0.2:4400
+monitor=10.0.0.3:4400
+log_level=warn
+`
+	ioutil.WriteFile(file.Name(), []byte(valid), 0600)
+	if errs := config.Check(file.Name()); len(errs) != 0 {
+		test.Logf("the config should have been fine %v", errs)
+		test.Fail()
+	}
+	if config.Conf.LogLevel != "warn" {
+		test.Logf("the log_level was not read %v", config.Conf.LogLevel)
+		test.Fail()
+	}
+
+	// a typo, a number that isn't and a duration that can't be are all reported
+	config.Conf = config.Defaults
+	ioutil.WriteFile(file.Name(), []byte(valid+"chek_interval=5\npg_port=five\ndecision_timeout=-1\n"), 0600)
+	errs := config.Check(file.Name())
+	if len(errs) != 3 {
+		test.Logf("should have found 3 problems %v", errs)
+		test.FailNow()
+	}
+	for i, want := range []string{"pg_port", "chek_interval", "decision_timeout"} {
+		if !strings.Contains(errs[i].Error(), want) {
+			test.Logf("should have been about the %v (%v)", want, errs[i])
+			test.Fail()
+		}
+	}
+
+	// a boolean is only what strconv takes for one
+	config.Conf = config.Defaults
+	ioutil.WriteFile(file.Name(), []byte(valid+"rewind=yes\n"), 0600)
+	if errs := config.Check(file.Name()); len(errs) != 1 || !strings.Contains(errs[0].Error(), "rewind") {
+		test.Logf("rewind=yes should have been refused %v", errs)
+		test.Fail()
+	}
+	config.Conf = config.Defaults
+	ioutil.WriteFile(file.Name(), []byte(valid+"rewind=True\n"), 0600)
+	if errs := config.Check(file.Name()); len(errs) != 0 || !config.Conf.Rewind {
+		test.Logf("rewind=True should have been read %v", errs)
+		test.Fail()
+	}
+
+	// an rsynced copy can't be verified
+	config.Conf = config.Defaults
+	ioutil.WriteFile(file.Name(), []byte(valid+"verify_sync=true\n"), 0600)
+	if errs := config.Check(file.Name()); len(errs) != 1 || !strings.Contains(errs[0].Error(), "verify") {
+		test.Logf("verify_sync should have been refused with rsync %v", errs)
+		test.Fail()
+	}
+	config.Conf = config.Defaults
+	ioutil.WriteFile(file.Name(), []byte(valid+"verify_sync=true\nsync_strategy=pg_basebackup\n"), 0600)
+	if errs := config.Check(file.Name()); len(errs) != 0 || !config.Conf.VerifySync {
+		test.Logf("verify_sync should have been fine with pg_basebackup %v", errs)
+		test.Fail()
+	}
+
+	// only rsync copies are split into streams
+	config.Conf = config.Defaults
+	ioutil.WriteFile(file.Name(), []byte(valid+"sync_streams=4\nsync_strategy=pg_basebackup\n"), 0600)
+	if errs := config.Check(file.Name()); len(errs) != 1 || !strings.Contains(errs[0].Error(), "streams") {
+		test.Logf("sync_streams should have been refused with pg_basebackup %v", errs)
+		test.Fail()
+	}
+
+	// the ssh yoke manages is only what rsync copies over
+	config.Conf = config.Defaults
+	ioutil.WriteFile(file.Name(), []byte(valid+"sync_strategy=pg_basebackup\n[ssh]\nmanage=true\n"), 0600)
+	if errs := config.Check(file.Name()); len(errs) != 1 || !strings.Contains(errs[0].Error(), "ssh") {
+		test.Logf("[ssh] manage should have been refused with pg_basebackup %v", errs)
+		test.Fail()
+	}
+	config.Conf = config.Defaults
+	ioutil.WriteFile(file.Name(), []byte(valid+"[ssh]\nmanage=true\n"), 0600)
+	if errs := config.Check(file.Name()); len(errs) != 1 || !strings.Contains(errs[0].Error(), "authenticate") {
+		test.Logf("[ssh] manage should have been refused without authentication %v", errs)
+		test.Fail()
+	}
+	config.Conf = config.Defaults
+	ioutil.WriteFile(file.Name(), []byte(valid+"[ssh]\nmanage=true\n[auth]\nsecret=shared\n"), 0600)
+	if errs := config.Check(file.Name()); len(errs) != 0 {
+		test.Logf("[ssh] manage should have been fine with a secret %v", errs)
+		test.Fail()
+	}
+
+	// a compression has to be one yoke knows, at a level it takes
+	config.Conf = config.Defaults
+	ioutil.WriteFile(file.Name(), []byte(valid+"sync_compression=brotli\n"), 0600)
+	if errs := config.Check(file.Name()); len(errs) != 1 || !strings.Contains(errs[0].Error(), "sync_compression") {
+		test.Logf("sync_compression=brotli should have been refused %v", errs)
+		test.Fail()
+	}
+	config.Conf = config.Defaults
+	ioutil.WriteFile(file.Name(), []byte(valid+"[archive]\ncompression=gzip\ncompression_level=12\n"), 0600)
+	if errs := config.Check(file.Name()); len(errs) != 1 || !strings.Contains(errs[0].Error(), "compression_level") {
+		test.Logf("gzip should have been refused at level 12 %v", errs)
+		test.Fail()
+	}
+
+	// the backups restore the age encrypted WAL with the identity
+	config.Conf = config.Defaults
+	ioutil.WriteFile(file.Name(), []byte(valid+"[archive]\nencryption=age\nencryption_key=age1abc\n"), 0600)
+	if errs := config.Check(file.Name()); len(errs) != 1 || !strings.Contains(errs[0].Error(), "decryption_key") {
+		test.Logf("age should have been refused without a decryption_key %v", errs)
+		test.Fail()
+	}
+
+	config.Conf = config.Defaults
+	ioutil.WriteFile(file.Name(), []byte(valid+"[archive]\ndestination=ftp://host/yoke\n"), 0600)
+	if errs := config.Check(file.Name()); len(errs) != 1 || !strings.Contains(errs[0].Error(), "unknown storage") {
+		test.Logf("an ftp destination should have been refused %v", errs)
+		test.Fail()
+	}
+
+	config.Conf = config.Defaults
+	ioutil.WriteFile(file.Name(), []byte(valid+"[migrate]\nsource=host=old.example.com dbname=app\n"), 0600)
+	if errs := config.Check(file.Name()); len(errs) != 1 || !strings.Contains(errs[0].Error(), "[migrate] source") {
+		test.Logf("a source that names its database should have been refused %v", errs)
+		test.Fail()
+	}
+
+	// a plugin is selected by its name, and hands its own options on
+	config.Conf = config.Defaults
+	plugin := "database=acme\n[plugin.acme]\npath=/usr/lib/yoke/yoke-acme\nport=389\nsuffix=dc=example\n"
+	ioutil.WriteFile(file.Name(), []byte(valid+plugin), 0600)
+	if errs := config.Check(file.Name()); len(errs) != 0 || config.Conf.DatabasePort() != 389 {
+		test.Logf("the plugin should have been the database %v %v", errs, config.Conf.DatabasePort())
+		test.Fail()
+	}
+	if acme, ok := config.Conf.Plugin("acme"); !ok || acme.Options["suffix"] != "dc=example" {
+		test.Logf("the options of the plugin were not read %v", acme)
+		test.Fail()
+	}
+
+	config.Conf = config.Defaults
+	ioutil.WriteFile(file.Name(), []byte(valid+"[plugin.acme]\npath=yoke-acme\n"), 0600)
+	if errs := config.Check(file.Name()); len(errs) != 1 || !strings.Contains(errs[0].Error(), "[plugin.acme]") {
+		test.Logf("a plugin without an absolute path should have been refused %v", errs)
+		test.Fail()
+	}
+}
+
+func TestOverrides(test *testing.T) {
+	defer func() { config.Conf = config.Defaults }()
+	defer func() { config.Flags = map[string]string{} }()
+	os.Setenv("YOKE_PG_PORT", "6543")
+	os.Setenv("YOKE_RPC_TIMEOUT_MS", "300")
+	os.Setenv("YOKE_DRY_RUN", "1")
+	defer os.Unsetenv("YOKE_PG_PORT")
+	defer os.Unsetenv("YOKE_RPC_TIMEOUT_MS")
+	defer os.Unsetenv("YOKE_DRY_RUN")
+
+	// a flag wins over the environment, which wins over the file
+	rest, err := config.ParseFlags([]string{"--role=primary", "--advertise_ip=10.0.0.1", "--primary=10.0.0.1:4400", "--secondary=10.0.0.2:4400", "--monitor=10.0.0.3:4400", "--rpc.timeout_ms=250", "check-config"})
+	if err != nil || len(rest) != 1 || rest[0] != "check-config" {
+		test.Logf("the flags were not taken out %v (%v)", rest, err)
+		test.FailNow()
+	}
+	if errs := config.Check(""); len(errs) != 0 {
+		test.Logf("the config should have been fine %v", errs)
+		test.FailNow()
+	}
+	if config.Conf.PGPort != 6543 || config.Conf.RPCTimeout != 250 || config.Conf.Role != "primary" || !config.Conf.DryRun {
+		test.Logf("the overrides were not applied %v %v %v %v", config.Conf.PGPort, config.Conf.RPCTimeout, config.Conf.Role, config.Conf.DryRun)
+		test.Fail()
+	}
+
+	// a misspelled flag is reported like a misspelled option
+	config.Conf = config.Defaults
+	config.ParseFlags([]string{"--pg_prot=5433"})
+	if errs := config.Check(""); len(errs) != 1 || !strings.Contains(errs[0].Error(), "--pg_prot") {
+		test.Logf("the misspelled flag should have been reported %v", errs)
+		test.Fail()
+	}
+}
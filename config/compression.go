@@ -0,0 +1,163 @@
+// Copyright (c) 2015 Pagoda Box Inc
+//
+// This Source Code Form is subject to the terms of the Mozilla Public License, v.
+// 2.0. If a copy of the MPL was not distributed with this file, You can obtain one
+// at http://mozilla.org/MPL/2.0/.
+//
+
+// compression.go has the compressions the data can be copied to the backups and
+// the WAL archived with, and how each of them is run.
+
+package config
+
+import (
+	"fmt"
+	"os/exec"
+	"strings"
+)
+
+// Compression is how the data is compressed, and at what level. The level is left
+// to the tool when it is 0.
+type Compression struct {
+	Name  string // 'zstd', 'lz4', 'gzip' or 'none'
+	Level int
+}
+
+// Compressions are the ones yoke knows, the best one first
+var Compressions = []string{"zstd", "lz4", "gzip"}
+
+// the levels each compression takes
+var compressionLevels = map[string][2]int{
+	"zstd": {1, 19},
+	"lz4":  {1, 12},
+	"gzip": {1, 9},
+}
+
+// NegotiateCompression picks the compression of a copy to a node that can take the
+// compressions theirs: the preferred one when it can, otherwise the best one it
+// can. Nothing is compressed when none was preferred or it can take none.
+func NegotiateCompression(preferred string, level int, theirs []string) Compression {
+	if preferred == "" || preferred == "none" {
+		return Compression{Name: "none"}
+	}
+	can := map[string]bool{}
+	for _, name := range theirs {
+		can[name] = true
+	}
+	if can[preferred] {
+		return Compression{Name: preferred, Level: level}
+	}
+	for _, name := range Compressions {
+		// the level of one compression means nothing to another
+		if can[name] {
+			return Compression{Name: name}
+		}
+	}
+	return Compression{Name: "none"}
+}
+
+// Compressed is if the compression compresses anything
+func (compression Compression) Compressed() bool {
+	return compression.Name != "" && compression.Name != "none"
+}
+
+// Suffix is the extension of the files it compressed
+func (compression Compression) Suffix() string {
+	switch compression.Name {
+	case "zstd":
+		return ".zst"
+	case "lz4":
+		return ".lz4"
+	case "gzip":
+		return ".gz"
+	}
+	return ""
+}
+
+// Compress is the shell command that compresses the file in into out
+func (compression Compression) Compress(in, out string) string {
+	level := ""
+	if compression.Level != 0 {
+		level = fmt.Sprintf(" -%d", compression.Level)
+	}
+	return fmt.Sprintf("%s%s -c %s > %s", compression.tool(), level, in, out)
+}
+
+// Decompress is the shell command that decompresses the file in into out
+func (compression Compression) Decompress(in, out string) string {
+	return fmt.Sprintf("%s -d -c %s > %s", compression.tool(), in, out)
+}
+
+// RsyncArgs are the arguments rsync compresses a copy with. gzip is the zlib every
+// rsync has, the others need rsync 3.2 on both ends.
+func (compression Compression) RsyncArgs() string {
+	if !compression.Compressed() {
+		return ""
+	}
+	args := "--compress"
+	if compression.Name != "gzip" {
+		args += " --compress-choice=" + compression.Name
+	}
+	if compression.Level != 0 {
+		args += fmt.Sprintf(" --compress-level=%d", compression.Level)
+	}
+	return args
+}
+
+func (compression Compression) tool() string {
+	if compression.Name == "gzip" {
+		return "gzip"
+	}
+	return compression.Name + " -q"
+}
+
+// RsyncCompressions are the compressions the rsync of the node can take, from the
+// 'Compress list' rsync 3.2 prints with its version. An older rsync only has zlib.
+func RsyncCompressions() []string {
+	out, err := exec.Command("rsync", "--version").Output()
+	if err != nil {
+		return nil
+	}
+	return parseRsyncCompressions(string(out))
+}
+
+func parseRsyncCompressions(version string) []string {
+	lines := strings.Split(version, "\n")
+	for i, line := range lines {
+		if !strings.HasPrefix(strings.TrimSpace(line), "Compress list:") || i+1 == len(lines) {
+			continue
+		}
+		names := []string{}
+		seen := map[string]bool{}
+		for _, name := range strings.Fields(lines[i+1]) {
+			switch name {
+			case "zlib", "zlibx":
+				name = "gzip"
+			case "zstd", "lz4":
+			default:
+				continue
+			}
+			if !seen[name] {
+				seen[name] = true
+				names = append(names, name)
+			}
+		}
+		return names
+	}
+	return []string{"gzip"}
+}
+
+// confirmCompression checks the compression of an option and its level
+func confirmCompression(option, name string, level int) error {
+	if name == "none" {
+		return nil
+	}
+	levels, ok := compressionLevels[name]
+	if !ok {
+		return fmt.Errorf("I could not understand the %s, it is none, %s (%s:'%s').", option, strings.Join(Compressions, ", "), option, name)
+	}
+	if level != 0 && (level < levels[0] || level > levels[1]) {
+		return fmt.Errorf("I could not understand the %s_level, %s takes 0 or %d to %d (%s_level:'%d').", option, name, levels[0], levels[1], option, level)
+	}
+	return nil
+}
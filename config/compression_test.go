@@ -0,0 +1,78 @@
+// Copyright (c) 2015 Pagoda Box Inc
+//
+// This Source Code Form is subject to the terms of the Mozilla Public License, v.
+// 2.0. If a copy of the MPL was not distributed with this file, You can obtain one
+// at http://mozilla.org/MPL/2.0/.
+//
+
+package config_test
+
+import (
+	"github.com/nanopack/yoke/config"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestNegotiateCompression(test *testing.T) {
+	for _, negotiated := range []struct {
+		preferred string
+		level     int
+		theirs    []string
+		want      config.Compression
+		args      string
+	}{
+		{"none", 3, []string{"zstd"}, config.Compression{Name: "none"}, ""},
+		{"zstd", 3, []string{"zstd", "lz4", "gzip"}, config.Compression{Name: "zstd", Level: 3}, "--compress --compress-choice=zstd --compress-level=3"},
+		// the level of zstd means nothing to lz4
+		{"zstd", 3, []string{"gzip", "lz4"}, config.Compression{Name: "lz4"}, "--compress --compress-choice=lz4"},
+		{"gzip", 0, []string{"zstd", "gzip"}, config.Compression{Name: "gzip"}, "--compress"},
+		{"lz4", 0, nil, config.Compression{Name: "none"}, ""},
+	} {
+		compression := config.NegotiateCompression(negotiated.preferred, negotiated.level, negotiated.theirs)
+		if compression != negotiated.want || compression.RsyncArgs() != negotiated.args {
+			test.Logf("%v to %v should have been %+v '%v', not %+v '%v'", negotiated.preferred, negotiated.theirs, negotiated.want, negotiated.args, compression, compression.RsyncArgs())
+			test.Fail()
+		}
+	}
+}
+
+func TestArchiveCompression(test *testing.T) {
+	conf := config.Defaults
+	conf.ArchiveDestination = "/backups"
+	conf.ArchiveCompression = "zstd"
+	conf.ArchiveCompressLevel = 5
+
+	// a segment that was only compressed half way is never archived
+	archive := strings.Replace(conf.ArchiveCommand(), os.TempDir(), "/tmp", -1)
+	if !strings.Contains(archive, "zstd -q -5 -c %p > /tmp/yoke-%f.zst && ") || !strings.Contains(archive, "cp /tmp/yoke-%f.zst /backups/wal/%f.zst.tmp && mv /backups/wal/%f.zst.tmp /backups/wal/%f.zst") {
+		test.Logf("the segment is not compressed into the archive '%v'", archive)
+		test.Fail()
+	}
+	if strings.Contains(archive, "'") {
+		test.Logf("the archive_command can't be quoted in postgresql.conf '%v'", archive)
+		test.Fail()
+	}
+
+	// the segments archived before the compression changed are still restored
+	restore := strings.Replace(conf.RestoreCommand(), os.TempDir(), "/tmp", -1)
+	for _, want := range []string{"cp /backups/wal/%f.zst /tmp/yoke-%f.zst && zstd -q -d -c /tmp/yoke-%f.zst > %p", "cp /backups/wal/%f %p", "gzip -d -c /tmp/yoke-%f.gz > %p"} {
+		if !strings.Contains(restore, want) {
+			test.Logf("the restore_command doesn't '%v' '%v'", want, restore)
+			test.Fail()
+		}
+	}
+	if strings.Index(restore, ".zst") > strings.Index(restore, ".gz") {
+		test.Logf("the compression of the archive should be tried first '%v'", restore)
+		test.Fail()
+	}
+
+	if flag := config.PGVersion(150000).BackupCompression(config.Compression{Name: "lz4", Level: 2}, "server"); flag != "--compress=server-lz4:2" {
+		test.Logf("postgres 15 compresses on the server, not '%v'", flag)
+		test.Fail()
+	}
+	if flag := config.PGVersion(140000).BackupCompression(config.Compression{Name: "zstd"}, "client"); flag != "" {
+		test.Logf("postgres 14 can only gzip, not '%v'", flag)
+		test.Fail()
+	}
+}
@@ -8,184 +8,1472 @@
 package config
 
 import (
+	"context"
+	"fmt"
 	"github.com/jcelliott/lumber"
-	"github.com/vaughan0/go-ini"
+	"github.com/nanopack/yoke/state"
 	"net"
 	"os"
-	"os/exec"
 	"os/user"
 	"strconv"
 	"strings"
+	"time"
 )
 
 // Config is the struct of all global configuration data
 // it is set by a config file that is the first arguement
 // given to the exec
 type Config struct {
-	Role              string
-	AdvertiseIp       string
-	AdvertisePort     int
-	PGPort            int
-	Monitor           string
-	Primary           string
-	Secondary         string
-	DataDir           string
-	StatusDir         string
-	SyncCommand       string
-	DecisionTimeout   int
-	Vip               string
-	VipAddCommand     string
-	VipRemoveCommand  string
-	RoleChangeCommand string
-	SystemUser        string
+	Role                 string
+	AdvertiseIp          string
+	AdvertisePort        int
+	PGPort               int
+	MySQLPort            int
+	MySQLUser            string
+	MySQLPassword        string
+	ReplicationUser      string
+	ReplicationPassword  string
+	RedisPort            int
+	RedisPassword        string
+	ScriptToActive       string
+	ScriptToBackupOf     string
+	ScriptToSingle       string
+	ScriptStop           string
+	ScriptPosition       string
+	ScriptPort           int
+	ScriptTimeout        int
+	Monitor              string
+	Arbiter              string
+	EtcdEndpoint         string
+	EtcdPrefix           string
+	EtcdTTL              int
+	KubeAPI              string
+	KubeNamespace        string
+	KubeLeasePrefix      string
+	KubeLeaseTTL         int
+	ObjectEndpoint       string
+	ObjectBucket         string
+	ObjectRegion         string
+	ObjectDialect        string
+	ObjectPrefix         string
+	ObjectAccessKey      string
+	ObjectSecretKey      string
+	ObjectTTL            int
+	DiscoveryBackend     string
+	DiscoveryAddress     string
+	DiscoveryService     string
+	DiscoveryToken       string
+	DiscoveryExpect      int
+	Primary              string
+	Secondary            string
+	DataDir              string
+	StatusDir            string
+	HistoryFile          string
+	SyncCommand          string
+	SyncStreamCommand    string
+	SyncStreams          int
+	SyncMode             string
+	SyncStrategy         string
+	VerifySync           bool
+	SyncRateLimit        int
+	SyncRetries          int
+	SyncRetryDelay       int
+	SyncCompression      string
+	SyncCompressionLevel int
+	Cascade              string
+	DRSource             string
+	DRPromoteAfter       int
+	Database             string
+	LogFormat            string
+	LogLevel             string
+	Rewind               bool
+	DryRun               bool
+	DecisionTimeout      int
+	CheckInterval        int
+	CheckJitter          int
+	AdaptiveChecks       bool
+	MinCheckInterval     int
+	HeartbeatInterval    int
+	ConcurrentProbes     bool
+	MaxAllowedLagBytes   int
+	MaxAllowedLagSeconds int
+	FailoverDelay        int
+	RTOTarget            int
+	StartupQuorum        string
+	SplitBrainPolicy     string
+	UnavailablePolicy    string
+	PromotionLease       int
+	StartupRole          string
+	FailureDetector      string
+	PeerFailures         int
+	PeerTimeout          int
+	PhiThreshold         int
+	StartupAttempts      int
+	StartupRetryDelay    int
+	StartupMaxRetryDelay int
+	Vip                  string
+	VipAddCommand        string
+	VipRemoveCommand     string
+	VipBackend           string
+	VipInterface         string
+	VipAWSAllocationID   string
+	VipAWSInstanceID     string
+	VipGCPRoute          string
+	VipGCPNetwork        string
+	VipGCPInstance       string
+	VipGCPZone           string
+	RoleChangeCommand    string
+	FenceCommand         string
+	FenceTimeout         int
+	FenceSelfCommand     string
+	UpgradeCommand       string
+	UpgradeTimeout       int
+	UpgradeOldBinDir     string
+	UpgradeNewBinDir     string
+	UpgradeLink          bool
+	DrainTimeout         int
+	DrainTerminate       bool
+	PreHookCommand       string
+	PostHookCommand      string
+	HookTimeout          int
+	HealthQuery          bool
+	HealthMinFreeDiskMB  int
+	HealthMaxWALSizeMB   int
+	HealthMaxConnections int
+	HealthFailures       int
+	HealthTimeout        int
+	WALGuardWarnMB       int
+	WALGuardLimitMB      int
+	WALGuardMinFreeMB    int
+	WALGuardPolicy       string
+	AdminListen          string
+	AuthSecret           string
+	RPCTimeout           int
+	RPCRetries           int
+	RPCRetryDelay        int
+	RPCTransport         string
+	TLSCert              string
+	TLSKey               string
+	TLSCA                string
+	ArchiveDestination   string
+	ArchiveStorage       string
+	ArchiveEndpoint      string
+	ArchiveSchedule      string
+	ArchiveRetainCount   int
+	ArchiveRetainDays    int
+	ArchiveRetainWALDays int
+	ArchiveCompression   string
+	ArchiveCompressLevel int
+	ArchiveEncryption    string
+	ArchiveEncryptionKey string
+	ArchiveDecryptionKey string
+	VerifyBackupSchedule string
+	VerifyBackupQueries  string
+	VerifyBackupTimeout  int
+	MigrateSource        string
+	MigrateDatabases     string
+	MigrateTimeout       int
+	ProxyListen          string
+	WebhookURL           string
+	WebhookHeader        string
+	WebhookEvent         string
+	WebhookRetries       int
+	WebhookRetryDelay    int
+	WebhookTimeout       int
+	ChaosEnabled         bool
+	ChaosSchedule        string
+	ChaosFailureRate     int
+	ChaosLatency         int
+	ChaosTransitionRate  int
+	StatsdAddress        string
+	StatsdFormat         string
+	StatsdInterval       int
+	AlertSlackURL        string
+	AlertSlackSeverity   string
+	AlertPagerKey        string
+	AlertPagerURL        string
+	AlertPagerSeverity   string
+	AlertSMTPServer      string
+	AlertSMTPUser        string
+	AlertSMTPPassword    string
+	AlertEmailFrom       string
+	AlertEmailTo         string
+	AlertEmailSeverity   string
+	AlertTimeout         int
+	TraceEndpoint        string
+	TraceService         string
+	DNSBackend           string
+	DNSName              string
+	DNSZone              string
+	DNSTTL               int
+	DNSServer            string
+	DNSKeyFile           string
+	DNSAddress           string
+	PgbouncerHost        string
+	PgbouncerPort        int
+	PgbouncerUser        string
+	PgbouncerPassword    string
+	PgbouncerDatabase    string
+	PgbouncerConfig      string
+	PgbouncerTimeout     int
+	VaultAddress         string
+	VaultToken           string
+	SecretsRefresh       int
+	SSHManage            bool
+	SSHDir               string
+	SSHHostKey           string
+	SSHAuthorizedKeys    string
+	SSHPort              int
+	SSHConnectTimeout    int
+	SSHAliveInterval     int
+	PGParameters         map[string]map[string]string // the [postgres] sections, by role ("" for every role)
+	SystemUser           string
+	Instance             string // the name of the [instance.<name>] section, empty for [config]
+	Logger               Logger // set by programs that embed yoke, it isn't read from the file
+
+	// the databases the node runs next to the one of the [config] section
+	instances []instance
+	// the programs the node starts for the [plugin.<name>] sections
+	plugins []Plugin
 }
 
 // establish constants
 // these are singleton values that are used throughout
 // the package.
 var (
-	Conf = Config{
-		AdvertisePort:   4400,
-		PGPort:          5432,
-		DataDir:         "/data/",
-		StatusDir:       "./status/",
-		SyncCommand:     "rsync -a --delete {{local_dir}} {{slave_ip}}:{{slave_dir}}",
-		DecisionTimeout: 10,
-		SystemUser:      SystemUser(),
-	}
-	Log = lumber.NewConsoleLogger(lumber.INFO)
+	Defaults = Config{
+		AdvertisePort:        4400,
+		PGPort:               5432,
+		DataDir:              "/data/",
+		StatusDir:            "./status/",
+		SyncCommand:          "rsync -a -e \"{{ssh}}\" {{compress}} --delete --partial --info=progress2 --bwlimit={{rate_limit}} {{local_dir}} {{slave_ip}}:{{slave_dir}}",
+		SyncStreamCommand:    "rsync -a -e \"{{ssh}}\" {{compress}} --partial --info=progress2 --bwlimit={{rate_limit}} --files-from={{files_from}} {{local_dir}} {{slave_ip}}:{{slave_dir}}",
+		SyncStreams:          1,
+		SyncMode:             "on",
+		SyncStrategy:         "rsync",
+		SyncRetries:          3,
+		SyncRetryDelay:       10,
+		SyncCompression:      "none",
+		ArchiveCompression:   "none",
+		ArchiveEncryption:    "none",
+		Database:             "postgres",
+		MySQLPort:            3306,
+		MySQLUser:            "root",
+		ReplicationUser:      "repl",
+		RedisPort:            6379,
+		ScriptTimeout:        60,
+		LogFormat:            "console",
+		LogLevel:             "info",
+		DiscoveryAddress:     "127.0.0.1:8500",
+		DiscoveryService:     "yoke",
+		DiscoveryExpect:      3,
+		EtcdEndpoint:         "http://127.0.0.1:2379",
+		EtcdPrefix:           "/yoke",
+		EtcdTTL:              10,
+		KubeAPI:              "https://kubernetes.default.svc",
+		KubeLeasePrefix:      "yoke",
+		KubeLeaseTTL:         10,
+		ObjectEndpoint:       "https://s3.amazonaws.com",
+		ObjectRegion:         "us-east-1",
+		ObjectDialect:        "s3",
+		ObjectPrefix:         "yoke",
+		ObjectTTL:            10,
+		DecisionTimeout:      10,
+		CheckInterval:        2,
+		CheckJitter:          10,
+		MinCheckInterval:     250,
+		FailureDetector:      "count",
+		StartupRole:          "config",
+		PeerFailures:         1,
+		PhiThreshold:         8,
+		StartupRetryDelay:    1,
+		StartupMaxRetryDelay: 30,
+		FenceTimeout:         30,
+		FenceSelfCommand:     "systemctl poweroff",
+		UpgradeTimeout:       600,
+		VerifyBackupQueries:  "select count(*) from pg_database",
+		VerifyBackupTimeout:  3600,
+		MigrateTimeout:       300,
+		DrainTimeout:         30,
+		HookTimeout:          30,
+		RPCTimeout:           1000,
+		RPCRetryDelay:        100,
+		RPCTransport:         "rpc",
+		HealthFailures:       3,
+		HealthTimeout:        5,
+		WALGuardPolicy:       "alert",
+		WebhookEvent:         "promotion_completed,demotion_completed,single_completed,stopped,split_brain",
+		WebhookRetries:       3,
+		WebhookRetryDelay:    1,
+		WebhookTimeout:       5,
+		StatsdFormat:         "statsd",
+		StatsdInterval:       10,
+		AlertSlackSeverity:   "warning",
+		AlertPagerURL:        "https://events.pagerduty.com/v2/enqueue",
+		AlertPagerSeverity:   "critical",
+		AlertEmailSeverity:   "critical",
+		AlertTimeout:         5,
+		TraceService:         "yoke",
+		DNSTTL:               30,
+		PgbouncerPort:        6432,
+		PgbouncerUser:        "pgbouncer",
+		PgbouncerTimeout:     10,
+		VaultAddress:         os.Getenv("VAULT_ADDR"),
+		VaultToken:           os.Getenv("VAULT_TOKEN"),
+		SecretsRefresh:       60,
+		SSHHostKey:           "/etc/ssh/ssh_host_ed25519_key.pub",
+		SSHPort:              22,
+		SSHConnectTimeout:    10,
+		SSHAliveInterval:     15,
+		SystemUser:           SystemUser(),
+	}
+	Conf = Defaults
+	Log  = NewLogger(os.Stdout, lumber.INFO, "console")
 )
 
 // init Initializeds the config file and the other constants
 func Init(path string) {
+	if errs := Check(path); len(errs) != 0 {
+		for _, err := range errs {
+			Log.Fatal("%v", err)
+		}
+		Log.Close()
+		os.Exit(1)
+	}
+	warnRTO(Conf)
 
-	//
-	file, err := ini.LoadFile(path)
+	// every line from here on says which node wrote it
+	Log.Set("node", Conf.AdvertiseAddress())
+	Log.Set("role", Conf.Role)
+}
+
+// Check reads the config file at path into Conf and confirms its options, every
+// problem with them is returned. Unknown options and numbers that aren't are
+// problems too.
+func Check(path string) []error {
+	file, err := loadFile(path)
 	if err != nil {
-		Log.Error("[config.init]: Failed to load config file!\n%s\n", err)
-		os.Exit(1)
+		return []error{fmt.Errorf("I could not load the config file (%v)", err)}
+	}
+
+	options := newOptions(file)
+	parse(options, &Conf)
+	errs := append(options.errs, options.unknown()...)
+	setLogLevel(Conf.LogLevel)
+	if err := confirmLogFormat(); err != nil {
+		return append(errs, err)
 	}
+	Log.Format(Conf.LogFormat)
 
+	for _, confirm := range []func() error{
+		confirmDiscovery,
+		confirmPeers,
+		confirmRole,
+		confirmAdvertiseIp,
+		confirmAdvertisePort,
+		confirmSyncMode,
+		confirmSyncStrategy,
+		confirmCompressions,
+		confirmEncryption,
+		confirmStorage,
+		confirmMigrate,
+		confirmCascade,
+		confirmDR,
+		confirmDatabase,
+		confirmStartupQuorum,
+		confirmFailureDetector,
+		confirmSplitBrainPolicy,
+		confirmUnavailablePolicy,
+		confirmDurations,
+		confirmPromotionLease,
+		confirmStartupRole,
+		confirmCheckJitter,
+		confirmHeartbeatInterval,
+		confirmRTOTarget,
+		confirmRPCTransport,
+		confirmChaos,
+		confirmStatsd,
+		confirmAlert,
+		confirmDNS,
+		confirmPgbouncer,
+		confirmSecrets,
+		confirmSSH,
+		confirmMajorUpgrade,
+		confirmPGParameters,
+		confirmWALGuard,
+		confirmInstances,
+		confirmPlugins,
+	} {
+		if err := confirm(); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errs
+}
+
+// parse reads the options from file into conf
+func parse(file *options, conf *Config) {
 	// no conversion required for strings.
 	if role, ok := file.Get("config", "role"); ok {
-		Conf.Role = role
+		conf.Role = role
 	}
 
 	if dDir, ok := file.Get("config", "data_dir"); ok {
-		Conf.DataDir = dDir
+		conf.DataDir = dDir
 	}
 
 	// make sure the datadir ends with a slash this should make it easier to handle
-	if !strings.HasSuffix(Conf.DataDir, "/") {
-		Conf.DataDir = Conf.DataDir + "/"
+	if !strings.HasSuffix(conf.DataDir, "/") {
+		conf.DataDir = conf.DataDir + "/"
 	}
 
 	if sDir, ok := file.Get("config", "status_dir"); ok {
-		Conf.StatusDir = sDir
+		conf.StatusDir = sDir
 	}
 
 	if sMonitor, ok := file.Get("config", "monitor"); ok {
-		Conf.Monitor = sMonitor
+		conf.Monitor = sMonitor
+	}
+	if arbiter, ok := file.Get("config", "arbiter"); ok {
+		conf.Arbiter = arbiter
 	}
 	if sPrimary, ok := file.Get("config", "primary"); ok {
-		Conf.Primary = sPrimary
+		conf.Primary = sPrimary
 	}
 	if sSecondary, ok := file.Get("config", "secondary"); ok {
-		Conf.Secondary = sSecondary
+		conf.Secondary = sSecondary
 	}
 
-	if !strings.HasSuffix(Conf.StatusDir, "/") {
-		Conf.StatusDir = Conf.StatusDir + "/"
+	if !strings.HasSuffix(conf.StatusDir, "/") {
+		conf.StatusDir = conf.StatusDir + "/"
+	}
+
+	// the history is kept with the rest of the status of the node unless it is
+	// given a place of its own
+	if history, ok := file.Get("config", "history_file"); ok {
+		conf.HistoryFile = history
+	}
+	if conf.HistoryFile == "" {
+		conf.HistoryFile = conf.StatusDir + "history.log"
 	}
 
 	if sync, ok := file.Get("config", "sync_command"); ok {
-		Conf.SyncCommand = sync
+		conf.SyncCommand = sync
+	}
+	if sync, ok := file.Get("config", "sync_stream_command"); ok {
+		conf.SyncStreamCommand = sync
+	}
+
+	if quorum, ok := file.Get("config", "startup_quorum"); ok {
+		conf.StartupQuorum = quorum
+	}
+
+	if policy, ok := file.Get("config", "split_brain_policy"); ok {
+		conf.SplitBrainPolicy = policy
+	}
+
+	if policy, ok := file.Get("config", "unavailable_policy"); ok {
+		conf.UnavailablePolicy = policy
+	}
+
+	if startupRole, ok := file.Get("config", "startup_role"); ok {
+		conf.StartupRole = startupRole
+	}
+
+	if detector, ok := file.Get("config", "failure_detector"); ok {
+		conf.FailureDetector = detector
+	}
+
+	if syncMode, ok := file.Get("config", "sync_mode"); ok {
+		conf.SyncMode = syncMode
+	}
+
+	if database, ok := file.Get("config", "database"); ok {
+		conf.Database = database
+	}
+
+	if user, ok := file.Get("mysql", "user"); ok {
+		conf.MySQLUser = user
+	}
+
+	if password, ok := file.Get("mysql", "password"); ok {
+		conf.MySQLPassword = password
+	}
+
+	if user, ok := file.Get("mysql", "replication_user"); ok {
+		conf.ReplicationUser = user
+	}
+
+	if password, ok := file.Get("mysql", "replication_password"); ok {
+		conf.ReplicationPassword = password
+	}
+
+	if password, ok := file.Get("redis", "password"); ok {
+		conf.RedisPassword = password
+	}
+
+	if command, ok := file.Get("script", "to_active"); ok {
+		conf.ScriptToActive = command
+	}
+	if command, ok := file.Get("script", "to_backup_of"); ok {
+		conf.ScriptToBackupOf = command
+	}
+	if command, ok := file.Get("script", "to_single"); ok {
+		conf.ScriptToSingle = command
+	}
+	if command, ok := file.Get("script", "stop"); ok {
+		conf.ScriptStop = command
 	}
+	if command, ok := file.Get("script", "position"); ok {
+		conf.ScriptPosition = command
+	}
+
+	if compression, ok := file.Get("config", "sync_compression"); ok {
+		conf.SyncCompression = compression
+	}
+	if strategy, ok := file.Get("config", "sync_strategy"); ok {
+		conf.SyncStrategy = strategy
+	}
+	parseBool(&conf.VerifySync, file, "config", "verify_sync")
+
+	if cascade, ok := file.Get("config", "cascade"); ok {
+		conf.Cascade = cascade
+	}
+
+	if source, ok := file.Get("dr", "source"); ok {
+		conf.DRSource = source
+	}
+	parseInt(&conf.DRPromoteAfter, file, "dr", "promote_after")
+
+	if format, ok := file.Get("config", "log_format"); ok {
+		conf.LogFormat = format
+	}
+
+	parseBool(&conf.Rewind, file, "config", "rewind")
+
+	parseBool(&conf.DryRun, file, "config", "dry_run")
+
+	parseBool(&conf.AdaptiveChecks, file, "config", "adaptive_checks")
+
+	parseBool(&conf.ConcurrentProbes, file, "config", "concurrent_probes")
 
 	if ip, ok := file.Get("config", "advertise_ip"); ok {
-		Conf.AdvertiseIp = ip
+		conf.AdvertiseIp = ip
 	}
 
 	if vip, ok := file.Get("vip", "ip"); ok {
-		Conf.Vip = vip
+		conf.Vip = vip
 	}
 	if vipAddCommand, ok := file.Get("vip", "add_command"); ok {
-		Conf.VipAddCommand = vipAddCommand
+		conf.VipAddCommand = vipAddCommand
 	}
 	if vipRemoveCommand, ok := file.Get("vip", "remove_command"); ok {
-		Conf.VipRemoveCommand = vipRemoveCommand
+		conf.VipRemoveCommand = vipRemoveCommand
+	}
+	if vipBackend, ok := file.Get("vip", "backend"); ok {
+		conf.VipBackend = vipBackend
+	}
+	if vipInterface, ok := file.Get("vip", "interface"); ok {
+		conf.VipInterface = vipInterface
+	}
+	if allocation, ok := file.Get("vip", "aws_allocation_id"); ok {
+		conf.VipAWSAllocationID = allocation
+	}
+	if instance, ok := file.Get("vip", "aws_instance_id"); ok {
+		conf.VipAWSInstanceID = instance
+	}
+	if route, ok := file.Get("vip", "gcp_route"); ok {
+		conf.VipGCPRoute = route
+	}
+	if network, ok := file.Get("vip", "gcp_network"); ok {
+		conf.VipGCPNetwork = network
+	}
+	if instance, ok := file.Get("vip", "gcp_instance"); ok {
+		conf.VipGCPInstance = instance
+	}
+	if zone, ok := file.Get("vip", "gcp_zone"); ok {
+		conf.VipGCPZone = zone
 	}
 
 	if rcCommand, ok := file.Get("role_change", "command"); ok {
-		Conf.RoleChangeCommand = rcCommand
+		conf.RoleChangeCommand = rcCommand
+	}
+
+	if upgrade, ok := file.Get("upgrade", "command"); ok {
+		conf.UpgradeCommand = upgrade
+	}
+	if bin, ok := file.Get("upgrade", "old_bin_dir"); ok {
+		conf.UpgradeOldBinDir = bin
+	}
+	if bin, ok := file.Get("upgrade", "new_bin_dir"); ok {
+		conf.UpgradeNewBinDir = bin
+	}
+	parseBool(&conf.UpgradeLink, file, "upgrade", "link")
+
+	parseBool(&conf.DrainTerminate, file, "switchover", "drain_terminate")
+
+	if secret, ok := file.Get("auth", "secret"); ok {
+		conf.AuthSecret = secret
+	}
+
+	if cert, ok := file.Get("tls", "cert"); ok {
+		conf.TLSCert = cert
+	}
+	if key, ok := file.Get("tls", "key"); ok {
+		conf.TLSKey = key
+	}
+	if ca, ok := file.Get("tls", "ca"); ok {
+		conf.TLSCA = ca
+	}
+
+	if destination, ok := file.Get("archive", "destination"); ok {
+		conf.ArchiveDestination = destination
+	}
+	if driver, ok := file.Get("archive", "storage"); ok {
+		conf.ArchiveStorage = driver
+	}
+	if endpoint, ok := file.Get("archive", "endpoint"); ok {
+		conf.ArchiveEndpoint = endpoint
+	}
+	if schedule, ok := file.Get("archive", "schedule"); ok {
+		conf.ArchiveSchedule = schedule
+	}
+	if compression, ok := file.Get("archive", "compression"); ok {
+		conf.ArchiveCompression = compression
+	}
+	if encryption, ok := file.Get("archive", "encryption"); ok {
+		conf.ArchiveEncryption = encryption
+	}
+	if key, ok := file.Get("archive", "encryption_key"); ok {
+		conf.ArchiveEncryptionKey = key
+	}
+	if key, ok := file.Get("archive", "decryption_key"); ok {
+		conf.ArchiveDecryptionKey = key
+	}
+	if schedule, ok := file.Get("archive", "verify_schedule"); ok {
+		conf.VerifyBackupSchedule = schedule
+	}
+	if queries, ok := file.Get("archive", "verify_queries"); ok {
+		conf.VerifyBackupQueries = queries
+	}
+	if source, ok := file.Get("migrate", "source"); ok {
+		conf.MigrateSource = source
+	}
+	if databases, ok := file.Get("migrate", "databases"); ok {
+		conf.MigrateDatabases = databases
+	}
+
+	if proxyListen, ok := file.Get("proxy", "listen"); ok {
+		conf.ProxyListen = proxyListen
+	}
+
+	if pre, ok := file.Get("hooks", "pre_transition"); ok {
+		conf.PreHookCommand = pre
+	}
+
+	if post, ok := file.Get("hooks", "post_transition"); ok {
+		conf.PostHookCommand = post
+	}
+
+	parseBool(&conf.HealthQuery, file, "health", "query")
+
+	if policy, ok := file.Get("wal_guard", "policy"); ok {
+		conf.WALGuardPolicy = policy
+	}
+
+	if fenceCommand, ok := file.Get("fence", "command"); ok {
+		conf.FenceCommand = fenceCommand
+	}
+	if selfCommand, ok := file.Get("fence", "self_command"); ok {
+		conf.FenceSelfCommand = selfCommand
+	}
+
+	if endpoint, ok := file.Get("etcd", "endpoint"); ok {
+		conf.EtcdEndpoint = endpoint
+	}
+	if prefix, ok := file.Get("etcd", "prefix"); ok {
+		conf.EtcdPrefix = prefix
+	}
+
+	if api, ok := file.Get("kubernetes", "api"); ok {
+		conf.KubeAPI = api
+	}
+	if namespace, ok := file.Get("kubernetes", "namespace"); ok {
+		conf.KubeNamespace = namespace
+	}
+	if prefix, ok := file.Get("kubernetes", "lease_prefix"); ok {
+		conf.KubeLeasePrefix = prefix
+	}
+
+	if endpoint, ok := file.Get("objectstore", "endpoint"); ok {
+		conf.ObjectEndpoint = endpoint
+	}
+	if bucket, ok := file.Get("objectstore", "bucket"); ok {
+		conf.ObjectBucket = bucket
+	}
+	if region, ok := file.Get("objectstore", "region"); ok {
+		conf.ObjectRegion = region
+	}
+	if dialect, ok := file.Get("objectstore", "dialect"); ok {
+		conf.ObjectDialect = dialect
+	}
+	if prefix, ok := file.Get("objectstore", "prefix"); ok {
+		conf.ObjectPrefix = prefix
+	}
+	if accessKey, ok := file.Get("objectstore", "access_key"); ok {
+		conf.ObjectAccessKey = accessKey
+	}
+	if secretKey, ok := file.Get("objectstore", "secret_key"); ok {
+		conf.ObjectSecretKey = secretKey
+	}
+
+	if backend, ok := file.Get("discovery", "backend"); ok {
+		conf.DiscoveryBackend = backend
+	}
+	if address, ok := file.Get("discovery", "address"); ok {
+		conf.DiscoveryAddress = address
+	}
+	if service, ok := file.Get("discovery", "service"); ok {
+		conf.DiscoveryService = service
+	}
+	if token, ok := file.Get("discovery", "token"); ok {
+		conf.DiscoveryToken = token
+	}
+
+	if adminListen, ok := file.Get("admin", "listen"); ok {
+		conf.AdminListen = adminListen
+	}
+
+	if webhookURL, ok := file.Get("webhook", "url"); ok {
+		conf.WebhookURL = webhookURL
+	}
+	if webhookHeader, ok := file.Get("webhook", "headers"); ok {
+		conf.WebhookHeader = webhookHeader
+	}
+	if webhookEvent, ok := file.Get("webhook", "events"); ok {
+		conf.WebhookEvent = webhookEvent
+	}
+
+	parseInt(&conf.AdvertisePort, file, "config", "advertise_port")
+	parseInt(&conf.PGPort, file, "config", "pg_port")
+	parseInt(&conf.DecisionTimeout, file, "config", "decision_timeout")
+	parseInt(&conf.CheckInterval, file, "config", "check_interval")
+	parseInt(&conf.CheckJitter, file, "config", "check_jitter")
+	parseInt(&conf.MinCheckInterval, file, "config", "min_check_interval_ms")
+	parseInt(&conf.HeartbeatInterval, file, "config", "heartbeat_interval_ms")
+	parseInt(&conf.MaxAllowedLagBytes, file, "config", "max_allowed_lag_bytes")
+	parseInt(&conf.MaxAllowedLagSeconds, file, "config", "max_allowed_lag_seconds")
+	parseInt(&conf.FailoverDelay, file, "config", "failover_delay")
+	parseInt(&conf.RTOTarget, file, "config", "rto_target")
+	parseInt(&conf.PromotionLease, file, "config", "promotion_lease")
+	parseInt(&conf.StartupAttempts, file, "config", "startup_attempts")
+	parseInt(&conf.PeerFailures, file, "config", "peer_failures")
+	parseInt(&conf.PeerTimeout, file, "config", "peer_timeout")
+	parseInt(&conf.PhiThreshold, file, "config", "phi_threshold")
+	parseInt(&conf.StartupRetryDelay, file, "config", "startup_retry_delay")
+	parseInt(&conf.StartupMaxRetryDelay, file, "config", "startup_max_retry_delay")
+	parseInt(&conf.ArchiveRetainCount, file, "archive", "retain_count")
+	parseInt(&conf.ArchiveRetainDays, file, "archive", "retain_days")
+	parseInt(&conf.ArchiveRetainWALDays, file, "archive", "retain_wal_days")
+	parseInt(&conf.ArchiveCompressLevel, file, "archive", "compression_level")
+	parseInt(&conf.VerifyBackupTimeout, file, "archive", "verify_timeout")
+	parseInt(&conf.MigrateTimeout, file, "migrate", "timeout")
+	parseInt(&conf.FenceTimeout, file, "fence", "timeout")
+	parseInt(&conf.UpgradeTimeout, file, "upgrade", "timeout")
+	parseInt(&conf.SyncRateLimit, file, "config", "sync_rate_limit")
+	parseInt(&conf.SyncStreams, file, "config", "sync_streams")
+	parseInt(&conf.SyncRetries, file, "config", "sync_retries")
+	parseInt(&conf.SyncRetryDelay, file, "config", "sync_retry_delay")
+	parseInt(&conf.SyncCompressionLevel, file, "config", "sync_compression_level")
+	parseInt(&conf.DrainTimeout, file, "switchover", "drain_timeout")
+	parseInt(&conf.MySQLPort, file, "mysql", "port")
+	parseInt(&conf.RedisPort, file, "redis", "port")
+	parseInt(&conf.ScriptPort, file, "script", "port")
+	parseInt(&conf.ScriptTimeout, file, "script", "timeout")
+	parseInt(&conf.HookTimeout, file, "hooks", "timeout")
+	parseInt(&conf.DiscoveryExpect, file, "discovery", "expect")
+	parseInt(&conf.EtcdTTL, file, "etcd", "ttl")
+	parseInt(&conf.KubeLeaseTTL, file, "kubernetes", "lease_ttl")
+	parseInt(&conf.ObjectTTL, file, "objectstore", "ttl")
+	parseInt(&conf.RPCTimeout, file, "rpc", "timeout_ms")
+	parseInt(&conf.RPCRetries, file, "rpc", "retries")
+	parseInt(&conf.RPCRetryDelay, file, "rpc", "retry_delay_ms")
+	if transport, ok := file.Get("rpc", "transport"); ok {
+		conf.RPCTransport = transport
+	}
+	parseInt(&conf.HealthMinFreeDiskMB, file, "health", "min_free_disk_mb")
+	parseInt(&conf.HealthMaxWALSizeMB, file, "health", "max_wal_size_mb")
+	parseInt(&conf.HealthMaxConnections, file, "health", "max_connections_percent")
+	parseInt(&conf.HealthFailures, file, "health", "failures")
+	parseInt(&conf.HealthTimeout, file, "health", "timeout")
+	parseInt(&conf.WALGuardWarnMB, file, "wal_guard", "warn_mb")
+	parseInt(&conf.WALGuardLimitMB, file, "wal_guard", "limit_mb")
+	parseInt(&conf.WALGuardMinFreeMB, file, "wal_guard", "min_free_disk_mb")
+	parseInt(&conf.WebhookRetries, file, "webhook", "retries")
+	parseInt(&conf.WebhookRetryDelay, file, "webhook", "retry_delay")
+	parseInt(&conf.WebhookTimeout, file, "webhook", "timeout")
+
+	parseBool(&conf.ChaosEnabled, file, "chaos", "enabled")
+	if schedule, ok := file.Get("chaos", "schedule"); ok {
+		conf.ChaosSchedule = schedule
+	}
+	parseInt(&conf.ChaosFailureRate, file, "chaos", "failure_rate")
+	parseInt(&conf.ChaosLatency, file, "chaos", "latency_ms")
+	parseInt(&conf.ChaosTransitionRate, file, "chaos", "transition_rate")
+
+	if statsd, ok := file.Get("metrics", "statsd"); ok {
+		conf.StatsdAddress = statsd
+	}
+	if format, ok := file.Get("metrics", "statsd_format"); ok {
+		conf.StatsdFormat = format
+	}
+	parseInt(&conf.StatsdInterval, file, "metrics", "statsd_interval")
+
+	if slack, ok := file.Get("alert", "slack_url"); ok {
+		conf.AlertSlackURL = slack
+	}
+	if severity, ok := file.Get("alert", "slack_severity"); ok {
+		conf.AlertSlackSeverity = severity
+	}
+	if key, ok := file.Get("alert", "pagerduty_key"); ok {
+		conf.AlertPagerKey = key
+	}
+	if url, ok := file.Get("alert", "pagerduty_url"); ok {
+		conf.AlertPagerURL = url
+	}
+	if severity, ok := file.Get("alert", "pagerduty_severity"); ok {
+		conf.AlertPagerSeverity = severity
+	}
+	if server, ok := file.Get("alert", "smtp_server"); ok {
+		conf.AlertSMTPServer = server
 	}
+	if user, ok := file.Get("alert", "smtp_user"); ok {
+		conf.AlertSMTPUser = user
+	}
+	if password, ok := file.Get("alert", "smtp_password"); ok {
+		conf.AlertSMTPPassword = password
+	}
+	if from, ok := file.Get("alert", "email_from"); ok {
+		conf.AlertEmailFrom = from
+	}
+	if to, ok := file.Get("alert", "email_to"); ok {
+		conf.AlertEmailTo = to
+	}
+	if severity, ok := file.Get("alert", "email_severity"); ok {
+		conf.AlertEmailSeverity = severity
+	}
+	parseInt(&conf.AlertTimeout, file, "alert", "timeout")
 
-	parseInt(&Conf.AdvertisePort, file, "config", "advertise_port")
-	parseInt(&Conf.PGPort, file, "config", "pg_port")
-	parseInt(&Conf.DecisionTimeout, file, "config", "decision_timeout")
+	if endpoint, ok := file.Get("trace", "otlp_endpoint"); ok {
+		conf.TraceEndpoint = endpoint
+	}
+	if service, ok := file.Get("trace", "service_name"); ok {
+		conf.TraceService = service
+	}
 
+	if backend, ok := file.Get("dns", "backend"); ok {
+		conf.DNSBackend = backend
+	}
+	if name, ok := file.Get("dns", "name"); ok {
+		conf.DNSName = name
+	}
+	if zone, ok := file.Get("dns", "zone"); ok {
+		conf.DNSZone = zone
+	}
+	parseInt(&conf.DNSTTL, file, "dns", "ttl")
+	if server, ok := file.Get("dns", "server"); ok {
+		conf.DNSServer = server
+	}
+	if keyFile, ok := file.Get("dns", "key_file"); ok {
+		conf.DNSKeyFile = keyFile
+	}
+	if address, ok := file.Get("dns", "address"); ok {
+		conf.DNSAddress = address
+	}
+
+	if host, ok := file.Get("pgbouncer", "host"); ok {
+		conf.PgbouncerHost = host
+	}
+	parseInt(&conf.PgbouncerPort, file, "pgbouncer", "port")
+	if user, ok := file.Get("pgbouncer", "user"); ok {
+		conf.PgbouncerUser = user
+	}
+	if password, ok := file.Get("pgbouncer", "password"); ok {
+		conf.PgbouncerPassword = password
+	}
+	if database, ok := file.Get("pgbouncer", "database"); ok {
+		conf.PgbouncerDatabase = database
+	}
+	if configFile, ok := file.Get("pgbouncer", "config_file"); ok {
+		conf.PgbouncerConfig = configFile
+	}
+	parseInt(&conf.PgbouncerTimeout, file, "pgbouncer", "timeout")
+
+	if address, ok := file.Get("secrets", "vault_address"); ok {
+		conf.VaultAddress = address
+	}
+	if token, ok := file.Get("secrets", "vault_token"); ok {
+		conf.VaultToken = token
+	}
+	parseInt(&conf.SecretsRefresh, file, "secrets", "refresh_interval")
+
+	parseBool(&conf.SSHManage, file, "ssh", "manage")
+	if dir, ok := file.Get("ssh", "dir"); ok {
+		conf.SSHDir = dir
+	}
+	if hostKey, ok := file.Get("ssh", "host_key"); ok {
+		conf.SSHHostKey = hostKey
+	}
+	if authorized, ok := file.Get("ssh", "authorized_keys"); ok {
+		conf.SSHAuthorizedKeys = authorized
+	}
+	parseInt(&conf.SSHPort, file, "ssh", "port")
+	parseInt(&conf.SSHConnectTimeout, file, "ssh", "connect_timeout")
+	parseInt(&conf.SSHAliveInterval, file, "ssh", "alive_interval")
+
+	if logLevel, ok := file.Get("config", "log_level"); ok {
+		conf.LogLevel = logLevel
+	}
 	if logLevel, ok := file.Get("config", "Log_level"); ok {
-		switch logLevel {
-		case "TRACE", "trace":
-			Log.Level(lumber.TRACE)
-		case "DEBUG", "debug":
-			Log.Level(lumber.DEBUG)
-		case "INFO", "info":
-			Log.Level(lumber.INFO)
-		case "WARN", "warn":
-			Log.Level(lumber.WARN)
-		case "ERROR", "error":
-			Log.Level(lumber.ERROR)
-		case "FATAL", "fatal":
-			Log.Level(lumber.FATAL)
+		conf.LogLevel = logLevel
+	}
+
+	conf.PGParameters = map[string]map[string]string{}
+	for _, role := range []string{"", "active", "backup", "single"} {
+		section := "postgres"
+		if role != "" {
+			section += "." + role
+		}
+		if parameters := file.section(section); len(parameters) != 0 {
+			conf.PGParameters[role] = parameters
 		}
 	}
-	confirmPeers()
-	confirmRole()
-	confirmAdvertiseIp()
-	confirmAdvertisePort()
 
+	parseInstances(file, conf)
+	parsePlugins(file, conf)
 }
 
-func confirmPeers() {
-	if Conf.Monitor == "" || Conf.Primary == "" || Conf.Secondary == "" {
-		Log.Fatal("I need connection Credentials for monitor, primary and secondary")
-		Log.Close()
-		os.Exit(1)
+// setLogLevel changes the level of the log, an unknown level is ignored
+func setLogLevel(level string) {
+	switch level {
+	case "TRACE", "trace":
+		Log.Level(lumber.TRACE)
+	case "DEBUG", "debug":
+		Log.Level(lumber.DEBUG)
+	case "INFO", "info":
+		Log.Level(lumber.INFO)
+	case "WARN", "warn":
+		Log.Level(lumber.WARN)
+	case "ERROR", "error":
+		Log.Level(lumber.ERROR)
+	case "FATAL", "fatal":
+		Log.Level(lumber.FATAL)
+	}
+}
+
+func confirmDiscovery() error {
+	switch Conf.DiscoveryBackend {
+	case "":
+		return nil
+	case "consul":
+	case "kubernetes":
+		confirmPod()
+	default:
+		return fmt.Errorf("I could not understand the discovery backend (backend:'%s').", Conf.DiscoveryBackend)
+	}
+	if Conf.Role == "" || Conf.AdvertiseIp == "" {
+		return fmt.Errorf("I need the role and the advertise_ip of this node to register it")
 	}
+	return nil
 }
 
-func confirmRole() {
+// in a StatefulSet the first pod starts out as the primary and the others as
+// secondaries, and the pod ip is handed down through the POD_IP variable
+func confirmPod() {
+	if Conf.Role == "" {
+		name, _ := os.Hostname()
+		if strings.HasSuffix(name, "-0") {
+			Conf.Role = string(state.Primary)
+		} else {
+			Conf.Role = string(state.Secondary)
+		}
+	}
+	if Conf.AdvertiseIp == "" {
+		Conf.AdvertiseIp = os.Getenv("POD_IP")
+	}
+}
+
+func confirmPeers() error {
+	// the peers are looked up once the node has registered itself
+	if Conf.DiscoveryBackend != "" {
+		return nil
+	}
+	if Conf.Primary == "" || Conf.Secondary == "" {
+		return fmt.Errorf("I need connection Credentials for primary and secondary")
+	}
+	// the monitor is only required when it is the one arbitrating the cluster
+	if (Conf.Arbiter == "" || Conf.Arbiter == "monitor") && Conf.Monitor == "" {
+		return fmt.Errorf("I need connection Credentials for monitor, primary and secondary")
+	}
+	return nil
+}
+
+func confirmRole() error {
 	if Conf.Role == "" {
 		Conf.Role = getRole()
 	}
-	if Conf.Role != "monitor" && Conf.Role != "primary" && Conf.Role != "secondary" {
-		Log.Fatal("I could not find the appropriate role (role:'%s').", Conf.Role)
-		Log.Close()
-		os.Exit(1)
+	if !state.Role(Conf.Role).Valid() {
+		return fmt.Errorf("I could not find the appropriate role (role:'%s').", Conf.Role)
 	}
+	return nil
 }
 
-func confirmAdvertiseIp() {
+func confirmAdvertiseIp() error {
 	if Conf.AdvertiseIp == "" || Conf.AdvertiseIp == "0.0.0.0" {
 		getAdvertiseData()
 	}
 	if Conf.AdvertiseIp == "" || Conf.AdvertiseIp == "0.0.0.0" {
-		Log.Fatal("I could not find the appropriate AdvertiseIP (ip:'%s').", Conf.AdvertiseIp)
-		Log.Close()
-		os.Exit(1)
+		return fmt.Errorf("I could not find the appropriate AdvertiseIP (ip:'%s').", Conf.AdvertiseIp)
 	}
+	return nil
 }
 
-func confirmAdvertisePort() {
+func confirmAdvertisePort() error {
 	if Conf.AdvertisePort == 0 {
-		Log.Fatal("I could not find the appropriate Port to listen on (port:'0').")
-		Log.Close()
-		os.Exit(1)
+		return fmt.Errorf("I could not find the appropriate Port to listen on (port:'0').")
+	}
+	return nil
+}
+
+func confirmSyncMode() error {
+	if Conf.SyncMode != "on" && Conf.SyncMode != "off" && Conf.SyncMode != "strict" {
+		return fmt.Errorf("I could not understand the sync_mode (sync_mode:'%s').", Conf.SyncMode)
+	}
+	return nil
+}
+
+func confirmDatabase() error {
+	switch Conf.Database {
+	case "postgres", "mysql", "redis":
+		return nil
+	case "script":
+		return confirmScript()
+	}
+	// a plugin moves a service between roles
+	if plugin, ok := Conf.Plugin(Conf.Database); ok {
+		if plugin.Port < 1 {
+			return fmt.Errorf("I need the port the service of the [plugin.%s] section listens on (port:'%d').", plugin.Name, plugin.Port)
+		}
+		return nil
+	}
+	return fmt.Errorf("I could not understand the database (database:'%s').", Conf.Database)
+}
+
+// every transition but stopping needs a command
+func confirmScript() error {
+	for _, command := range []struct{ name, value string }{
+		{"to_active", Conf.ScriptToActive},
+		{"to_backup_of", Conf.ScriptToBackupOf},
+		{"to_single", Conf.ScriptToSingle},
+	} {
+		if command.value == "" {
+			return fmt.Errorf("I need the %s command of the [script] section to move the service between roles.", command.name)
+		}
+	}
+	if Conf.ScriptPort < 1 || Conf.ScriptPort > 65535 {
+		return fmt.Errorf("I could not understand the [script] port, it is the port the service listens on (port:'%d').", Conf.ScriptPort)
+	}
+	return nil
+}
+
+func confirmSyncStrategy() error {
+	if Conf.SyncStrategy != "rsync" && Conf.SyncStrategy != "pg_basebackup" {
+		return fmt.Errorf("I could not understand the sync_strategy (sync_strategy:'%s').", Conf.SyncStrategy)
+	}
+	// an rsynced copy is only consistent once its WAL was replayed, there is
+	// nothing to check it against before that
+	if Conf.VerifySync && (Conf.Database != "postgres" || Conf.SyncStrategy != "pg_basebackup") {
+		return fmt.Errorf("I can only verify the copies postgres backups take themselves (database:'%s', sync_strategy:'%s').", Conf.Database, Conf.SyncStrategy)
+	}
+	// pg_basebackup can't be held to less than 32kB/s
+	if Conf.SyncRateLimit != 0 && (Conf.SyncRateLimit < 32 || Conf.SyncRateLimit > 1048576) {
+		return fmt.Errorf("I could not understand the sync_rate_limit, it is 0 or between 32 and 1048576 kB/s (sync_rate_limit:'%d').", Conf.SyncRateLimit)
+	}
+	if Conf.SyncStreams < 1 {
+		return fmt.Errorf("I could not understand the sync_streams, it is at least 1 (sync_streams:'%d').", Conf.SyncStreams)
+	}
+	if Conf.SyncStreams > 1 && Conf.SyncStrategy != "rsync" {
+		return fmt.Errorf("I can only split the copy of the rsync sync_strategy into streams (sync_strategy:'%s').", Conf.SyncStrategy)
+	}
+	if Conf.SyncRetries < 0 {
+		return fmt.Errorf("I could not understand the sync_retries, it can't be negative (sync_retries:'%d').", Conf.SyncRetries)
+	}
+	return nil
+}
+
+func confirmCascade() error {
+	if Conf.Cascade == "" {
+		return nil
+	}
+	if Conf.Database != "postgres" || Conf.SyncStrategy != "pg_basebackup" {
+		return fmt.Errorf("I can only cascade postgres backups that take their own copy (database:'%s', sync_strategy:'%s').", Conf.Database, Conf.SyncStrategy)
+	}
+	nodes := map[string]bool{Conf.Primary: true}
+	for _, secondary := range Conf.Secondaries() {
+		nodes[secondary] = true
+	}
+	for _, pair := range splitList(Conf.Cascade) {
+		split := strings.SplitN(pair, "=", 2)
+		if len(split) != 2 || !nodes[strings.TrimSpace(split[0])] || !nodes[strings.TrimSpace(split[1])] {
+			return fmt.Errorf("I could not understand the cascade, it is a list of 'node=upstream' pairs of nodes in the cluster (cascade:'%s').", pair)
+		}
+	}
+	// a chain that comes back around has no node at its top to stream from
+	for node := range nodes {
+		seen := map[string]bool{}
+		for upstream := Conf.Upstream(node); upstream != ""; upstream = Conf.Upstream(upstream) {
+			if upstream == node || seen[upstream] {
+				return fmt.Errorf("I could not follow the cascade, '%s' ends up streaming from itself (cascade:'%s').", node, Conf.Cascade)
+			}
+			seen[upstream] = true
+		}
+	}
+	return nil
+}
+
+func confirmDR() error {
+	sources := Conf.DRSources()
+	switch {
+	case len(sources) == 0:
+		return nil
+	case Conf.Database != "postgres" || Conf.SyncStrategy != "pg_basebackup":
+		return fmt.Errorf("I can only track another cluster with postgres backups that take their own copy (database:'%s', sync_strategy:'%s').", Conf.Database, Conf.SyncStrategy)
+	case Conf.DRPromoteAfter < 0:
+		return fmt.Errorf("I could not understand the dr promote_after, it is how many seconds the other cluster has to be gone before this one takes writes, 0 never (promote_after:'%d').", Conf.DRPromoteAfter)
+	default:
+		nodes := map[string]bool{Conf.Primary: true}
+		for _, node := range append(Conf.Secondaries(), Conf.Monitors()...) {
+			nodes[node] = true
+		}
+		for _, source := range sources {
+			if nodes[source] {
+				return fmt.Errorf("I could not understand the dr source, '%s' is a node of this cluster (source:'%s').", source, Conf.DRSource)
+			}
+		}
+		return nil
+	}
+}
+
+func confirmLogFormat() error {
+	if Conf.LogFormat != "console" && Conf.LogFormat != "json" {
+		return fmt.Errorf("I could not understand the log_format (log_format:'%s').", Conf.LogFormat)
+	}
+	return nil
+}
+
+func confirmSplitBrainPolicy() error {
+	switch Conf.SplitBrainPolicy {
+	case "", "halt", "position", "primary":
+		return nil
+	}
+	return fmt.Errorf("I could not understand the split_brain_policy (split_brain_policy:'%s').", Conf.SplitBrainPolicy)
+}
+
+// the options that are a number of seconds or milliseconds can't be less than
+// the least each of them makes sense with
+func confirmDurations() error {
+	for _, duration := range []struct {
+		name  string
+		value int
+		least int
+	}{
+		{"check_interval", Conf.CheckInterval, 1},
+		{"decision_timeout", Conf.DecisionTimeout, 1},
+		{"min_check_interval_ms", Conf.MinCheckInterval, 0},
+		{"failover_delay", Conf.FailoverDelay, 0},
+		{"promotion_lease", Conf.PromotionLease, 0},
+		{"peer_timeout", Conf.PeerTimeout, 0},
+		{"max_allowed_lag_seconds", Conf.MaxAllowedLagSeconds, 0},
+		{"startup_retry_delay", Conf.StartupRetryDelay, 0},
+		{"startup_max_retry_delay", Conf.StartupMaxRetryDelay, 0},
+		{"sync_retry_delay", Conf.SyncRetryDelay, 0},
+		{"[rpc] timeout_ms", Conf.RPCTimeout, 1},
+		{"[rpc] retry_delay_ms", Conf.RPCRetryDelay, 0},
+		{"[fence] timeout", Conf.FenceTimeout, 1},
+		{"[upgrade] timeout", Conf.UpgradeTimeout, 1},
+		{"[archive] verify_timeout", Conf.VerifyBackupTimeout, 1},
+		{"[migrate] timeout", Conf.MigrateTimeout, 1},
+		{"[switchover] drain_timeout", Conf.DrainTimeout, 0},
+		{"[hooks] timeout", Conf.HookTimeout, 1},
+		{"[script] timeout", Conf.ScriptTimeout, 1},
+		{"[health] timeout", Conf.HealthTimeout, 1},
+		{"[webhook] timeout", Conf.WebhookTimeout, 1},
+		{"[webhook] retry_delay", Conf.WebhookRetryDelay, 0},
+		{"[alert] timeout", Conf.AlertTimeout, 1},
+		{"[etcd] ttl", Conf.EtcdTTL, 1},
+		{"[kubernetes] lease_ttl", Conf.KubeLeaseTTL, 1},
+		{"[objectstore] ttl", Conf.ObjectTTL, 1},
+		{"[secrets] refresh_interval", Conf.SecretsRefresh, 1},
+		{"[ssh] connect_timeout", Conf.SSHConnectTimeout, 1},
+		{"[ssh] alive_interval", Conf.SSHAliveInterval, 1},
+	} {
+		if duration.value < duration.least {
+			return fmt.Errorf("I could not understand the %s, it is a duration of at least %d (%s:'%d').", duration.name, duration.least, duration.name, duration.value)
+		}
+	}
+	return nil
+}
+
+// the lease is renewed on every check, so it has to outlast a few of them
+func confirmPromotionLease() error {
+	if Conf.PromotionLease == 0 || Conf.PromotionLease > 2*Conf.CheckInterval {
+		return nil
+	}
+	return fmt.Errorf("I could not understand the promotion_lease, it is 0 or more than twice the check_interval (promotion_lease:'%d').", Conf.PromotionLease)
+}
+
+func confirmUnavailablePolicy() error {
+	switch Conf.UnavailablePolicy {
+	case "", "stop", "read_only", "serve", "power_off":
+		return nil
+	}
+	return fmt.Errorf("I could not understand the unavailable_policy (unavailable_policy:'%s').", Conf.UnavailablePolicy)
+}
+
+func confirmStartupQuorum() error {
+	switch Conf.StartupQuorum {
+	case "", "all", "majority":
+		return nil
+	}
+	if count, err := strconv.Atoi(Conf.StartupQuorum); err != nil || count < 1 {
+		return fmt.Errorf("I could not understand the startup_quorum (startup_quorum:'%s').", Conf.StartupQuorum)
+	}
+	return nil
+}
+
+func confirmStartupRole() error {
+	switch Conf.StartupRole {
+	case "", "config", "data":
+		return nil
+	}
+	return fmt.Errorf("I could not understand the startup_role (startup_role:'%s').", Conf.StartupRole)
+}
+
+func confirmCheckJitter() error {
+	if Conf.CheckJitter >= 0 && Conf.CheckJitter <= 100 {
+		return nil
+	}
+	return fmt.Errorf("I could not understand the check_jitter, it is a percent of the check_interval (check_jitter:'%d').", Conf.CheckJitter)
+}
+
+func confirmHeartbeatInterval() error {
+	if Conf.HeartbeatInterval >= 0 {
+		return nil
+	}
+	return fmt.Errorf("I could not understand the heartbeat_interval_ms, it is 0 or more (heartbeat_interval_ms:'%d').", Conf.HeartbeatInterval)
+}
+
+func confirmRTOTarget() error {
+	if Conf.RTOTarget >= 0 {
+		return nil
+	}
+	return fmt.Errorf("I could not understand the rto_target, it is 0 or more seconds (rto_target:'%d').", Conf.RTOTarget)
+}
+
+func confirmChaos() error {
+	if err := chaosRates(Conf); err == nil {
+		return nil
+	}
+	return fmt.Errorf("I could not understand the [chaos] rates, they are percents (failure_rate:'%d', transition_rate:'%d', latency_ms:'%d').", Conf.ChaosFailureRate, Conf.ChaosTransitionRate, Conf.ChaosLatency)
+}
+
+// the rates of the chaos mode are percents, the latency can't be negative
+func chaosRates(conf Config) error {
+	if conf.ChaosFailureRate < 0 || conf.ChaosFailureRate > 100 || conf.ChaosTransitionRate < 0 || conf.ChaosTransitionRate > 100 || conf.ChaosLatency < 0 {
+		return fmt.Errorf("the [chaos] failure_rate and transition_rate have to be between 0 and 100, and latency_ms can't be negative")
+	}
+	return nil
+}
+
+func confirmStatsd() error {
+	switch {
+	case Conf.StatsdFormat != "statsd" && Conf.StatsdFormat != "datadog":
+		return fmt.Errorf("I could not understand the statsd_format, it is 'statsd' or 'datadog' (statsd_format:'%s').", Conf.StatsdFormat)
+	case Conf.StatsdAddress != "" && Conf.StatsdInterval <= 0:
+		return fmt.Errorf("I could not understand the statsd_interval, it is how many seconds apart the gauges are sent (statsd_interval:'%d').", Conf.StatsdInterval)
+	}
+	return nil
+}
+
+func confirmAlert() error {
+	for option, severity := range map[string]string{
+		"slack_severity":     Conf.AlertSlackSeverity,
+		"pagerduty_severity": Conf.AlertPagerSeverity,
+		"email_severity":     Conf.AlertEmailSeverity,
+	} {
+		switch severity {
+		case "info", "warning", "critical":
+			continue
+		}
+		return fmt.Errorf("I could not understand the %s, it is 'info', 'warning' or 'critical' (%s:'%s').", option, option, severity)
+	}
+	if Conf.AlertEmailTo != "" && (Conf.AlertSMTPServer == "" || Conf.AlertEmailFrom == "") {
+		return fmt.Errorf("I could not send the alert emails, they need an smtp_server and an email_from (smtp_server:'%s', email_from:'%s').", Conf.AlertSMTPServer, Conf.AlertEmailFrom)
+	}
+	return nil
+}
+
+func confirmDNS() error {
+	switch {
+	case Conf.DNSBackend == "":
+		return nil
+	case Conf.DNSBackend != "route53" && Conf.DNSBackend != "clouddns" && Conf.DNSBackend != "rfc2136":
+		return fmt.Errorf("I could not understand the dns backend, it is 'route53', 'clouddns' or 'rfc2136' (backend:'%s').", Conf.DNSBackend)
+	case Conf.DNSName == "":
+		return fmt.Errorf("I need the name of the dns record to point at the active node")
+	case Conf.DNSBackend != "rfc2136" && Conf.DNSZone == "":
+		return fmt.Errorf("I need the zone the dns record is in for the '%s' dns backend", Conf.DNSBackend)
+	case Conf.DNSBackend == "rfc2136" && Conf.DNSServer == "":
+		return fmt.Errorf("I need the server to send the dns updates to for the 'rfc2136' dns backend")
+	case Conf.DNSTTL <= 0:
+		return fmt.Errorf("I could not understand the dns ttl, it is how many seconds the record may be cached (ttl:'%d').", Conf.DNSTTL)
+	}
+	return nil
+}
+
+func confirmPgbouncer() error {
+	switch {
+	case Conf.PgbouncerHost == "":
+		return nil
+	case Conf.Database != "postgres":
+		return fmt.Errorf("I can only coordinate pgbouncer for postgres (database:'%s').", Conf.Database)
+	case Conf.PgbouncerTimeout <= 0:
+		return fmt.Errorf("I could not understand the pgbouncer timeout, it is how many seconds a pause may take (timeout:'%d').", Conf.PgbouncerTimeout)
+	}
+	return nil
+}
+
+// a password that is looked up in vault needs to know where vault is
+func confirmSecrets() error {
+	for _, password := range []string{Conf.MySQLPassword, Conf.ReplicationPassword, Conf.RedisPassword} {
+		if strings.HasPrefix(password, "vault:") && Conf.VaultAddress == "" {
+			return fmt.Errorf("I need the vault_address of the [secrets] section to look up '%s'.", password)
+		}
 	}
+	return nil
+}
+
+func confirmCompressions() error {
+	if err := confirmCompression("sync_compression", Conf.SyncCompression, Conf.SyncCompressionLevel); err != nil {
+		return err
+	}
+	return confirmCompression("[archive] compression", Conf.ArchiveCompression, Conf.ArchiveCompressLevel)
+}
+
+// the databases are added to the source of a migration one by one
+func confirmMigrate() error {
+	if Conf.MigrateSource == "" {
+		return nil
+	}
+	if Conf.Database != "postgres" {
+		return fmt.Errorf("I can only migrate into postgres.")
+	}
+	if strings.Contains(Conf.MigrateSource, "dbname=") {
+		return fmt.Errorf("I could not understand the [migrate] source, the databases option names the databases (source:'%s').", Conf.MigrateSource)
+	}
+	return nil
+}
+
+// the ssh yoke manages is only what rsync copies the data over
+func confirmSSH() error {
+	if Conf.SSHPort < 1 || Conf.SSHPort > 65535 {
+		return fmt.Errorf("I could not understand the [ssh] port (port:'%d').", Conf.SSHPort)
+	}
+	if Conf.SSHManage && (Conf.Database != "postgres" || Conf.SyncStrategy != "rsync") {
+		return fmt.Errorf("I can only manage the ssh postgres copies its data over with rsync (database:'%s', sync_strategy:'%s').", Conf.Database, Conf.SyncStrategy)
+	}
+	// whoever answers for a node hands out the key that is authorized for it
+	if Conf.SSHManage && Conf.TLSCert == "" && len(Conf.AuthSecrets()) == 0 {
+		return fmt.Errorf("I can only manage the ssh when the nodes authenticate each other, with the [tls] certificates or the [auth] secret.")
+	}
+	return nil
+}
+
+// pg_upgrade needs the binaries of both versions
+func confirmMajorUpgrade() error {
+	if Conf.UpgradeOldBinDir == "" && Conf.UpgradeNewBinDir == "" {
+		return nil
+	}
+	if Conf.Database != "postgres" {
+		return fmt.Errorf("I can only upgrade postgres with pg_upgrade (database:'%s').", Conf.Database)
+	}
+	if Conf.UpgradeOldBinDir == "" || Conf.UpgradeNewBinDir == "" {
+		return fmt.Errorf("I need both the old_bin_dir and the new_bin_dir of the [upgrade] section to run pg_upgrade.")
+	}
+	return nil
+}
+
+// the [postgres] sections can't take over the options yoke sets itself
+func confirmPGParameters() error {
+	if len(Conf.PGParameters) != 0 && Conf.Database != "postgres" {
+		return fmt.Errorf("I can only set the parameters of the [postgres] sections on postgres (database:'%s').", Conf.Database)
+	}
+	for role, parameters := range Conf.PGParameters {
+		section := "postgres"
+		if role != "" {
+			section += "." + role
+		}
+		for name := range parameters {
+			switch {
+			case !parameterRegex.MatchString(name):
+				return fmt.Errorf("I could not understand the [%s] %s, it is not the name of a postgres parameter.", section, name)
+			case overwriteRegex.MatchString(name+" = ") || managedParameters[name]:
+				return fmt.Errorf("I set the [%s] %s myself, it can not be changed.", section, name)
+			}
+		}
+	}
+	return nil
+}
+
+func confirmWALGuard() error {
+	switch Conf.WALGuardPolicy {
+	case "", "alert", "drop_slots", "stop_archiving":
+		return nil
+	}
+	return fmt.Errorf("I could not understand the wal_guard policy, it is 'alert', 'drop_slots' or 'stop_archiving' (policy:'%s').", Conf.WALGuardPolicy)
+}
+
+func confirmRPCTransport() error {
+	switch Conf.RPCTransport {
+	case "", "rpc", "grpc":
+		return nil
+	}
+	return fmt.Errorf("I could not understand the rpc transport (transport:'%s').", Conf.RPCTransport)
+}
+
+func confirmFailureDetector() error {
+	switch Conf.FailureDetector {
+	case "", "count", "phi":
+		return nil
+	}
+	return fmt.Errorf("I could not understand the failure_detector (failure_detector:'%s').", Conf.FailureDetector)
 }
 
 func getRole() string {
+	switch {
+	case localNode(Conf.Monitors()) != "":
+		return string(state.Monitor)
+	case localNode([]string{Conf.Primary}) != "":
+		return string(state.Primary)
+	case localNode(Conf.Secondaries()) != "":
+		return string(state.Secondary)
+	}
+	return ""
+}
+
+// localNode returns the first of the nodes that is listening on one of the
+// addresses of this machine
+func localNode(nodes []string) string {
 	ifaces, err := net.Interfaces()
 	if err != nil {
 		return ""
@@ -196,13 +1484,10 @@ func getRole() string {
 		// handle err
 		for _, addr := range addrs {
 			str := strings.Split(addr.String(), "/")[0]
-			switch {
-			case strings.HasPrefix(Conf.Monitor, str):
-				return "monitor"
-			case strings.HasPrefix(Conf.Primary, str):
-				return "primary"
-			case strings.HasPrefix(Conf.Monitor, str):
-				return "secondary"
+			for _, node := range nodes {
+				if strings.HasPrefix(node, str+":") {
+					return node
+				}
 			}
 		}
 	}
@@ -211,17 +1496,25 @@ func getRole() string {
 
 func getAdvertiseData() {
 	if Conf.AdvertiseIp == "" || Conf.AdvertiseIp == "0.0.0.0" || Conf.AdvertisePort == 0 {
-		Log.Info(Conf.AdvertiseIp)
+		Log.Info("%v", Conf.AdvertiseIp)
 		var self string
-		switch Conf.Role {
-		case "monitor":
-			self = Conf.Monitor
-		case "primary":
+		switch state.Role(Conf.Role) {
+		case state.Monitor:
+			monitors := Conf.Monitors()
+			self = localNode(monitors)
+			if self == "" && len(monitors) == 1 {
+				self = monitors[0]
+			}
+		case state.Primary:
 			self = Conf.Primary
-		case "secondary":
-			self = Conf.Secondary
+		case state.Secondary:
+			secondaries := Conf.Secondaries()
+			self = localNode(secondaries)
+			if self == "" && len(secondaries) == 1 {
+				self = secondaries[0]
+			}
 		}
-		Log.Info(self)
+		Log.Info("%v", self)
 		connArr := strings.Split(self, ":")
 		if len(connArr) == 2 {
 			Conf.AdvertiseIp = connArr[0]
@@ -235,21 +1528,191 @@ func getAdvertiseData() {
 	}
 }
 
+// AdvertiseAddress returns the IP:port combination this node broadcasts to the
+// other nodes
+func (conf Config) AdvertiseAddress() string {
+	return fmt.Sprintf("%v:%d", conf.AdvertiseIp, conf.AdvertisePort)
+}
+
+// Logging returns the logger the parts of yoke that are created from this config
+// write to, Log unless another one was set
+func (conf Config) Logging() Logger {
+	if conf.Logger == nil {
+		return Log
+	}
+	return conf.Logger
+}
+
+// Secondaries returns every secondary node, the secondary option can hold a comma
+// separated list of nodes when the cluster has more than one backup
+func (conf Config) Secondaries() []string {
+	return splitList(conf.Secondary)
+}
+
+// Upstream returns the node that the node at location streams from while they are
+// both backups, it is empty for a node that streams from the active node. The
+// cascade option is a comma separated list of 'node=upstream' pairs.
+func (conf Config) Upstream(location string) string {
+	for _, pair := range splitList(conf.Cascade) {
+		split := strings.SplitN(pair, "=", 2)
+		if len(split) == 2 && strings.TrimSpace(split[0]) == location {
+			return strings.TrimSpace(split[1])
+		}
+	}
+	return ""
+}
+
+// DRSources returns the nodes of the cluster this one is the DR cluster of, the dr
+// source option is a comma separated list of them
+func (conf Config) DRSources() []string {
+	return splitList(conf.DRSource)
+}
+
+// Monitors returns every monitor node, the monitor option can hold a comma separated
+// list of nodes so that the cluster keeps its arbiter when one of them is down
+func (conf Config) Monitors() []string {
+	return splitList(conf.Monitor)
+}
+
+// DatabasePort returns the port clients connect to the database on
+func (conf Config) DatabasePort() int {
+	switch conf.Database {
+	case "mysql":
+		return conf.MySQLPort
+	case "redis":
+		return conf.RedisPort
+	case "script":
+		return conf.ScriptPort
+	}
+	if plugin, ok := conf.Plugin(conf.Database); ok {
+		return plugin.Port
+	}
+	return conf.PGPort
+}
+
+// CallTimeout returns how long a single call to another node may take
+func (conf Config) CallTimeout() time.Duration {
+	return time.Duration(conf.RPCTimeout) * time.Millisecond
+}
+
+// AuthSecrets returns the shared secrets of the cluster, the first one is the one
+// this node authenticates itself with
+func (conf Config) AuthSecrets() []string {
+	return splitList(conf.AuthSecret)
+}
+
+// WebhookURLs returns every url events are posted to
+func (conf Config) WebhookURLs() []string {
+	return splitList(conf.WebhookURL)
+}
+
+// WebhookEvents returns the types of the events that are posted to the webhooks
+func (conf Config) WebhookEvents() []string {
+	return splitList(conf.WebhookEvent)
+}
+
+// WebhookHeaders returns the extra headers sent with every webhook, they are
+// configured as a comma separated list of 'Name: value' pairs
+func (conf Config) WebhookHeaders() map[string]string {
+	headers := map[string]string{}
+	for _, header := range splitList(conf.WebhookHeader) {
+		pair := strings.SplitN(header, ":", 2)
+		if len(pair) != 2 {
+			continue
+		}
+		headers[strings.TrimSpace(pair[0])] = strings.TrimSpace(pair[1])
+	}
+	return headers
+}
+
+// AlertEmails returns the addresses the alert emails are sent to
+func (conf Config) AlertEmails() []string {
+	return splitList(conf.AlertEmailTo)
+}
+
+// splitList splits a comma separated option, dropping any empty entries
+func splitList(list string) []string {
+	items := []string{}
+	for _, item := range strings.Split(list, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+// Others returns the address of every other node in the cluster that runs a
+// database, as seen from the node that advertises itself at location
+func (conf Config) Others(location string) []string {
+	secondaries := conf.Secondaries()
+	switch state.Role(conf.Role) {
+	case state.Primary:
+		return secondaries
+	case state.Secondary:
+		others := []string{conf.Primary}
+		for _, secondary := range secondaries {
+			// with only one secondary it has to be this node
+			if secondary != location && len(secondaries) > 1 {
+				others = append(others, secondary)
+			}
+		}
+		return others
+	}
+	return nil
+}
+
+// ReplacePeer swaps the address of a peer for the address of the node that replaced
+// it, it returns false when old is not a peer
+func (conf *Config) ReplacePeer(old, address string) bool {
+	replaced := false
+	if conf.Primary == old {
+		conf.Primary = address
+		replaced = true
+	}
+	monitors := conf.Monitors()
+	for i, monitor := range monitors {
+		if monitor == old {
+			monitors[i] = address
+			replaced = true
+		}
+	}
+	conf.Monitor = strings.Join(monitors, ",")
+	secondaries := conf.Secondaries()
+	for i, secondary := range secondaries {
+		if secondary == old {
+			secondaries[i] = address
+			replaced = true
+		}
+	}
+	conf.Secondary = strings.Join(secondaries, ",")
+	return replaced
+}
+
 //
-func parseInt(val *int, file ini.File, section, name string) {
+func parseInt(val *int, file *options, section, name string) {
 	if port, ok := file.Get(section, name); ok {
 		i, err := strconv.ParseInt(port, 10, 64)
 		if err != nil {
-			Log.Fatal(name + " is not an int")
-			Log.Close()
-			os.Exit(1)
+			file.errs = append(file.errs, fmt.Errorf("I could not understand the %s, it is a whole number (%s:'%s').", name, name, port))
+			return
 		}
 		*val = int(i)
 	}
 }
 
+func parseBool(val *bool, file *options, section, name string) {
+	if value, ok := file.Get(section, name); ok {
+		b, err := strconv.ParseBool(value)
+		if err != nil {
+			file.errs = append(file.errs, fmt.Errorf("I could not understand the %s, it is true or false (%s:'%s').", name, name, value))
+			return
+		}
+		*val = b
+	}
+}
+
 //
-func parseArr(val *[]string, file ini.File, section, name string) {
+func parseArr(val *[]string, file *options, section, name string) {
 	if peers, ok := file.Get(section, name); ok {
 		*val = strings.Split(peers, ",")
 	}
@@ -259,7 +1722,7 @@ func SystemUser() string {
 	username := "postgres"
 	usr, err := user.Current()
 	if err != nil {
-		cmd := exec.Command("bash", "-c", "whoami")
+		cmd := Shell(context.Background(), "whoami")
 		bytes, err := cmd.Output()
 		if err == nil {
 			str := string(bytes)
@@ -0,0 +1,122 @@
+// Copyright (c) 2015 Pagoda Box Inc
+//
+// This Source Code Form is subject to the terms of the Mozilla Public License, v.
+// 2.0. If a copy of the MPL was not distributed with this file, You can obtain one
+// at http://mozilla.org/MPL/2.0/.
+//
+
+// encryption.go has how the base backups and the WAL segments are encrypted before
+// they are archived, and how each encryption is run.
+
+package config
+
+import (
+	"fmt"
+	"github.com/nanopack/yoke/crypt"
+	"os"
+	"strings"
+)
+
+// Encryption is how the archive is encrypted, and with what key
+type Encryption struct {
+	Name     string // 'age', 'aes-gcm' or 'none'
+	Key      string // the age recipients separated by commas, or the key aes-gcm wraps its data keys with, see crypt
+	Identity string // the age identity file the archive is decrypted with
+}
+
+// Encrypted is if the encryption encrypts anything
+func (encryption Encryption) Encrypted() bool {
+	return encryption.Name != "" && encryption.Name != "none"
+}
+
+// Suffix is the extension of the files it encrypted
+func (encryption Encryption) Suffix() string {
+	switch encryption.Name {
+	case "age":
+		return ".age"
+	case "aes-gcm":
+		return ".enc"
+	}
+	return ""
+}
+
+// Encrypt is the shell command that encrypts the file in into out. aes-gcm is done
+// by yoke itself, see crypt.
+func (encryption Encryption) Encrypt(in, out string) string {
+	if encryption.Name == "age" {
+		recipients := ""
+		for _, recipient := range strings.Split(encryption.Key, ",") {
+			recipients += " -r " + strings.TrimSpace(recipient)
+		}
+		return fmt.Sprintf("age%s -o %s %s", recipients, out, in)
+	}
+	return fmt.Sprintf("%s encrypt %s < %s > %s", yoke(), encryption.Key, in, out)
+}
+
+// Decrypt is the shell command that decrypts the file in into out. An aes-gcm file
+// that was not encrypted with the Key is refused.
+func (encryption Encryption) Decrypt(in, out string) string {
+	if encryption.Name == "age" {
+		return fmt.Sprintf("age -d -i %s -o %s %s", encryption.Identity, out, in)
+	}
+	return fmt.Sprintf("%s decrypt %s < %s > %s", yoke(), encryption.Key, in, out)
+}
+
+// EncryptionOf is the encryption the file name was encrypted with, from its suffix.
+// It is only decrypted with the keys of the config, never with one the archive
+// names.
+func (conf Config) EncryptionOf(name string) Encryption {
+	for _, encryption := range []Encryption{{Name: "age"}, {Name: "aes-gcm"}} {
+		if strings.HasSuffix(name, encryption.Suffix()) {
+			encryption.Key = conf.ArchiveEncryptionKey
+			encryption.Identity = conf.ArchiveDecryptionKey
+			return encryption
+		}
+	}
+	return Encryption{Name: "none"}
+}
+
+// the encryption the archive is set to
+func (conf Config) archiveEncryption() Encryption {
+	if conf.ArchiveEncryption == "" {
+		return Encryption{Name: "none"}
+	}
+	return Encryption{Name: conf.ArchiveEncryption, Key: conf.ArchiveEncryptionKey, Identity: conf.ArchiveDecryptionKey}
+}
+
+// the yoke binary, which encrypts with aes-gcm for the archive_command
+func yoke() string {
+	if binary, err := os.Executable(); err == nil {
+		return binary
+	}
+	return "yoke"
+}
+
+// the keys end up in the archive_command, which is quoted in postgresql.conf
+func confirmEncryption() error {
+	switch Conf.ArchiveEncryption {
+	case "none":
+		return nil
+	case "age":
+		for _, recipient := range strings.Split(Conf.ArchiveEncryptionKey, ",") {
+			if !strings.HasPrefix(strings.TrimSpace(recipient), "age1") {
+				return fmt.Errorf("I could not understand the [archive] encryption_key, age takes its recipients separated by commas (encryption_key:'%s').", Conf.ArchiveEncryptionKey)
+			}
+		}
+		// the restore_command of the backups decrypts the WAL too
+		if Conf.ArchiveDecryptionKey == "" {
+			return fmt.Errorf("I can only decrypt an age encrypted archive with the identity file of a decryption_key.")
+		}
+	case "aes-gcm":
+		if err := crypt.CheckKey(Conf.ArchiveEncryptionKey); err != nil {
+			return fmt.Errorf("I could not understand the [archive] encryption_key, %v (encryption_key:'%s').", err, Conf.ArchiveEncryptionKey)
+		}
+	default:
+		return fmt.Errorf("I could not understand the [archive] encryption, it is none, age or aes-gcm (encryption:'%s').", Conf.ArchiveEncryption)
+	}
+	if strings.Contains(Conf.ArchiveEncryptionKey+Conf.ArchiveDecryptionKey, "'") || strings.ContainsAny(Conf.ArchiveDecryptionKey, " \t") ||
+		(Conf.ArchiveEncryption == "aes-gcm" && strings.ContainsAny(Conf.ArchiveEncryptionKey, " \t")) {
+		return fmt.Errorf("I can only take [archive] encryption keys without quotes or spaces.")
+	}
+	return nil
+}
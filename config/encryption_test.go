@@ -0,0 +1,52 @@
+// Copyright (c) 2015 Pagoda Box Inc
+//
+// This Source Code Form is subject to the terms of the Mozilla Public License, v.
+// 2.0. If a copy of the MPL was not distributed with this file, You can obtain one
+// at http://mozilla.org/MPL/2.0/.
+//
+
+package config_test
+
+import (
+	"github.com/nanopack/yoke/config"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestArchiveEncryption(test *testing.T) {
+	conf := config.Defaults
+	conf.ArchiveDestination = "s3://bucket/yoke"
+	conf.ArchiveCompression = "lz4"
+	conf.ArchiveEncryption = "age"
+	conf.ArchiveEncryptionKey = "age1abc, age1def"
+	conf.ArchiveDecryptionKey = "/etc/yoke/age.txt"
+
+	// compressed first, encrypted data doesn't compress
+	archive := strings.Replace(conf.ArchiveCommand(), os.TempDir(), "/tmp", -1)
+	if !strings.Contains(archive, "lz4 -q -c %p > /tmp/yoke-%f.lz4.age.packing && age -r age1abc -r age1def -o /tmp/yoke-%f.lz4.age /tmp/yoke-%f.lz4.age.packing") ||
+		!strings.Contains(archive, "s3://bucket/yoke/wal/%f.lz4.age") {
+		test.Logf("the segment is not compressed and encrypted '%v'", archive)
+		test.Fail()
+	}
+
+	// the segments archived before the encryption was set are still restored
+	restore := strings.Replace(conf.RestoreCommand(), os.TempDir(), "/tmp", -1)
+	for _, want := range []string{"age -d -i /etc/yoke/age.txt -o %p.packing /tmp/yoke-%f.lz4.age && lz4 -q -d -c %p.packing > %p", "aws s3 cp --quiet s3://bucket/yoke/wal/%f %p"} {
+		if !strings.Contains(restore, want) {
+			test.Logf("the restore_command doesn't '%v' '%v'", want, restore)
+			test.Fail()
+		}
+	}
+	if strings.Index(restore, ".age") > strings.Index(restore, "wal/%f.lz4 ") {
+		test.Logf("the encrypted segments should be tried first '%v'", restore)
+		test.Fail()
+	}
+
+	conf.ArchiveEncryption = "aes-gcm"
+	conf.ArchiveEncryptionKey = "aws:alias/yoke"
+	if encryption := conf.EncryptionOf("base.tar.zst.enc"); encryption.Name != "aes-gcm" || !strings.HasSuffix(encryption.Decrypt("base.tar.zst.enc", "base.tar.zst"), " decrypt aws:alias/yoke < base.tar.zst.enc > base.tar.zst") {
+		test.Logf("the base backup is not decrypted with aes-gcm %+v", encryption)
+		test.Fail()
+	}
+}
@@ -0,0 +1,151 @@
+// Copyright (c) 2015 Pagoda Box Inc
+//
+// This Source Code Form is subject to the terms of the Mozilla Public License, v.
+// 2.0. If a copy of the MPL was not distributed with this file, You can obtain one
+// at http://mozilla.org/MPL/2.0/.
+//
+
+// instance.go lets a node run several databases on the same hosts. Every
+// [instance.<name>] section is another database next to the one of the [config]
+// section, with ports and directories of its own:
+//
+//	[instance.billing]
+//	advertise_port=4401
+//	port=5433
+//	data_dir=/data/billing/
+//	status_dir=/var/yoke/billing/
+//	primary=10.0.0.1:4401
+//	secondary=10.0.0.2:4401
+//
+// Every other option is the one of the [config] section.
+
+package config
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// the options an [instance.<name>] section can set
+type instance struct {
+	name          string
+	advertisePort int
+	port          int
+	dataDir       string
+	statusDir     string
+	historyFile   string
+	primary       string
+	secondary     string
+}
+
+// parseInstances reads every [instance.<name>] section of file into conf, in the
+// order of their names. An instance can only be given by flags too, but not only
+// by the environment.
+func parseInstances(file *options, conf *Config) {
+	conf.instances = nil
+	for _, section := range file.sections() {
+		if !strings.HasPrefix(section, "instance.") {
+			continue
+		}
+		db := instance{name: strings.TrimPrefix(section, "instance.")}
+		parseInt(&db.advertisePort, file, section, "advertise_port")
+		parseInt(&db.port, file, section, "port")
+		db.dataDir, _ = file.Get(section, "data_dir")
+		db.statusDir, _ = file.Get(section, "status_dir")
+		db.historyFile, _ = file.Get(section, "history_file")
+		db.primary, _ = file.Get(section, "primary")
+		db.secondary, _ = file.Get(section, "secondary")
+		conf.instances = append(conf.instances, db)
+	}
+}
+
+// Databases returns the config of every database the node runs, the one of the
+// [config] section first. The leader keys of the etcd, kubernetes and objectstore
+// arbiters get the name of the instance added to their prefix, so each database
+// is arbitrated on its own.
+func (conf Config) Databases() []Config {
+	databases := []Config{conf}
+	for _, db := range conf.instances {
+		other := conf
+		other.instances = nil
+		other.Instance = db.name
+		other.AdvertisePort = db.advertisePort
+		switch conf.Database {
+		case "mysql":
+			other.MySQLPort = db.port
+		case "redis":
+			other.RedisPort = db.port
+		case "script":
+			other.ScriptPort = db.port
+		default:
+			other.PGPort = db.port
+		}
+		other.DataDir = withSlash(db.dataDir)
+		other.StatusDir = withSlash(db.statusDir)
+		other.HistoryFile = db.historyFile
+		if other.HistoryFile == "" {
+			other.HistoryFile = other.StatusDir + "history.log"
+		}
+		other.Primary = db.primary
+		other.Secondary = db.secondary
+
+		other.EtcdPrefix = conf.EtcdPrefix + "/" + db.name
+		other.KubeLeasePrefix = conf.KubeLeasePrefix + "-" + db.name
+		other.ObjectPrefix = conf.ObjectPrefix + "/" + db.name
+		other.Logger = conf.Logging().With(Fields{"instance": db.name, "node": other.AdvertiseAddress()})
+		databases = append(databases, other)
+	}
+	return databases
+}
+
+// withSlash makes sure a directory ends with a slash, an empty one stays empty
+func withSlash(dir string) string {
+	if dir == "" || strings.HasSuffix(dir, "/") {
+		return dir
+	}
+	return dir + "/"
+}
+
+// every database of the node needs ports and directories of its own
+func confirmInstances() error {
+	if len(Conf.instances) == 0 {
+		return nil
+	}
+	if err := instancesApart(Conf); err != nil {
+		return fmt.Errorf("I could not run the [instance] sections, %v", err)
+	}
+	return nil
+}
+
+// instancesApart checks that no two databases of conf share a port or a directory
+func instancesApart(conf Config) error {
+	if conf.DiscoveryBackend != "" {
+		return fmt.Errorf("the peers of every instance have to be configured, they can not be discovered (backend:'%s')", conf.DiscoveryBackend)
+	}
+	taken := map[string]string{}
+	for _, db := range conf.Databases() {
+		name := db.Instance
+		if name == "" {
+			name = "config"
+		}
+		if db.Primary == "" || db.Secondary == "" {
+			return fmt.Errorf("'%s' needs a primary and a secondary", name)
+		}
+		for option, value := range map[string]string{
+			"advertise_port": strconv.Itoa(db.AdvertisePort),
+			"port":           strconv.Itoa(db.DatabasePort()),
+			"data_dir":       db.DataDir,
+			"status_dir":     db.StatusDir,
+		} {
+			if value == "0" || value == "" {
+				return fmt.Errorf("'%s' needs a %s of its own", name, option)
+			}
+			if other, ok := taken[option+"="+value]; ok {
+				return fmt.Errorf("'%s' and '%s' have the same %s (%s:'%s')", other, name, option, option, value)
+			}
+			taken[option+"="+value] = name
+		}
+	}
+	return nil
+}
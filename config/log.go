@@ -0,0 +1,181 @@
+// Copyright (c) 2015 Pagoda Box Inc
+//
+// This Source Code Form is subject to the terms of the Mozilla Public License, v.
+// 2.0. If a copy of the MPL was not distributed with this file, You can obtain one
+// at http://mozilla.org/MPL/2.0/.
+//
+
+// log.go is the logger every part of yoke writes to. Every line carries the fields
+// that identify the node (node, role, dbrole) and optionally what the line is about
+// (event, peer), written either for people to read or as one json object per line.
+
+package config
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/jcelliott/lumber"
+	"io"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+type (
+	// Fields are attached to log lines, keyed by name
+	Fields map[string]string
+
+	// Logger is what the parts of yoke write their lines to. Programs that embed
+	// yoke set their own in the config to route the lines through their logging
+	// stack, Log is used otherwise.
+	Logger interface {
+		Fatal(format string, args ...interface{})
+		Error(format string, args ...interface{})
+		Warn(format string, args ...interface{})
+		Info(format string, args ...interface{})
+		Debug(format string, args ...interface{})
+		Trace(format string, args ...interface{})
+		// With returns a logger that attaches fields to every line
+		With(fields Fields) Logger
+	}
+
+	// LineLogger writes leveled log lines with fields attached to them. It works
+	// wherever a lumber.Logger is expected.
+	LineLogger struct {
+		*output
+		fields Fields
+	}
+
+	// the destination shared by a logger and everything derived from it
+	output struct {
+		sync.Mutex
+		out    io.Writer
+		level  int
+		json   bool
+		fields Fields
+	}
+)
+
+var levels = []string{"TRACE", "DEBUG", "INFO", "WARN", "ERROR", "FATAL"}
+
+// NewLogger creates a logger that writes lines at or above level to out, as json
+// when the format is "json" and readable text otherwise
+func NewLogger(out io.Writer, level int, format string) *LineLogger {
+	return &LineLogger{
+		output: &output{
+			out:    out,
+			level:  level,
+			json:   format == "json",
+			fields: Fields{},
+		},
+	}
+}
+
+// With returns a logger that attaches fields to every line, on top of the fields
+// of this logger
+func (logger *LineLogger) With(fields Fields) Logger {
+	combined := Fields{}
+	for key, value := range logger.fields {
+		combined[key] = value
+	}
+	for key, value := range fields {
+		combined[key] = value
+	}
+	return &LineLogger{output: logger.output, fields: combined}
+}
+
+// Set attaches a field to every line written from now on, by this logger and
+// every logger derived from it. An empty value removes the field.
+func (logger *LineLogger) Set(key, value string) {
+	logger.Lock()
+	defer logger.Unlock()
+	if value == "" {
+		delete(logger.output.fields, key)
+		return
+	}
+	logger.output.fields[key] = value
+}
+
+// Format switches between json and readable text
+func (logger *LineLogger) Format(format string) {
+	logger.Lock()
+	defer logger.Unlock()
+	logger.json = format == "json"
+}
+
+func (logger *LineLogger) Level(level int) {
+	logger.Lock()
+	defer logger.Unlock()
+	logger.level = level
+}
+
+func (logger *LineLogger) Close() {}
+
+func (logger *LineLogger) Fatal(format string, args ...interface{}) {
+	logger.write(lumber.FATAL, format, args...)
+}
+
+func (logger *LineLogger) Error(format string, args ...interface{}) {
+	logger.write(lumber.ERROR, format, args...)
+}
+
+func (logger *LineLogger) Warn(format string, args ...interface{}) {
+	logger.write(lumber.WARN, format, args...)
+}
+
+func (logger *LineLogger) Info(format string, args ...interface{}) {
+	logger.write(lumber.INFO, format, args...)
+}
+
+func (logger *LineLogger) Debug(format string, args ...interface{}) {
+	logger.write(lumber.DEBUG, format, args...)
+}
+
+func (logger *LineLogger) Trace(format string, args ...interface{}) {
+	logger.write(lumber.TRACE, format, args...)
+}
+
+func (logger *LineLogger) write(level int, format string, args ...interface{}) {
+	logger.Lock()
+	defer logger.Unlock()
+	if level < logger.level {
+		return
+	}
+
+	fields := Fields{}
+	for key, value := range logger.output.fields {
+		fields[key] = value
+	}
+	for key, value := range logger.fields {
+		fields[key] = value
+	}
+	now := time.Now().UTC()
+	message := strings.TrimRight(fmt.Sprintf(format, args...), "\n")
+
+	if logger.json {
+		line := map[string]string{}
+		for key, value := range fields {
+			line[key] = value
+		}
+		line["time"] = now.Format(time.RFC3339Nano)
+		line["level"] = strings.ToLower(levels[level])
+		line["msg"] = message
+		bytes, err := json.Marshal(line)
+		if err != nil {
+			return
+		}
+		logger.out.Write(append(bytes, '\n'))
+		return
+	}
+
+	keys := make([]string, 0, len(fields))
+	for key := range fields {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		message += fmt.Sprintf(" %v=%v", key, fields[key])
+	}
+	fmt.Fprintf(logger.out, "%v %-5v %v\n", now.Format("2006-01-02 15:04:05"), levels[level], message)
+}
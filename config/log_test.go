@@ -0,0 +1,65 @@
+// Copyright (c) 2015 Pagoda Box Inc
+//
+// This Source Code Form is subject to the terms of the Mozilla Public License, v.
+// 2.0. If a copy of the MPL was not distributed with this file, You can obtain one
+// at http://mozilla.org/MPL/2.0/.
+//
+
+package config_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"github.com/jcelliott/lumber"
+	"github.com/nanopack/yoke/config"
+	"strings"
+	"testing"
+)
+
+func TestJSONLog(test *testing.T) {
+	out := &bytes.Buffer{}
+	log := config.NewLogger(out, lumber.INFO, "json")
+	log.Set("node", "127.0.0.1:4400")
+	log.Debug("too quiet to show up")
+	log.With(config.Fields{"peer": "127.0.0.1:4401"}).Info("other node is '%v'", "backup")
+	log.Set("dbrole", "active")
+	log.Warn("done")
+
+	lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+	if len(lines) != 2 {
+		test.Logf("expected 2 lines, got %q", lines)
+		test.FailNow()
+	}
+
+	first := map[string]string{}
+	if err := json.Unmarshal([]byte(lines[0]), &first); err != nil {
+		test.Log(err)
+		test.FailNow()
+	}
+	if first["msg"] != "other node is 'backup'" || first["level"] != "info" || first["node"] != "127.0.0.1:4400" || first["peer"] != "127.0.0.1:4401" {
+		test.Logf("wrong fields %v", first)
+		test.Fail()
+	}
+
+	second := map[string]string{}
+	if err := json.Unmarshal([]byte(lines[1]), &second); err != nil {
+		test.Log(err)
+		test.FailNow()
+	}
+	if second["dbrole"] != "active" || second["peer"] != "" {
+		test.Logf("wrong fields %v", second)
+		test.Fail()
+	}
+}
+
+func TestConsoleLog(test *testing.T) {
+	out := &bytes.Buffer{}
+	log := config.NewLogger(out, lumber.INFO, "console")
+	log.Set("role", "primary")
+	log.With(config.Fields{"event": "stopped"}).Info("[events] stopped")
+
+	if !strings.HasSuffix(out.String(), "INFO  [events] stopped event=stopped role=primary\n") {
+		test.Logf("wrong line %q", out.String())
+		test.Fail()
+	}
+}
@@ -0,0 +1,71 @@
+// Copyright (c) 2015 Pagoda Box Inc
+//
+// This Source Code Form is subject to the terms of the Mozilla Public License, v.
+// 2.0. If a copy of the MPL was not distributed with this file, You can obtain one
+// at http://mozilla.org/MPL/2.0/.
+//
+
+// override.go lets every option of the config file be set from the environment
+// and the command line as well, so a container can be configured without writing
+// a file. A flag wins over the environment, which wins over the file:
+//
+//	[config] pg_port          YOKE_PG_PORT          --pg_port=5433
+//	[rpc] timeout_ms          YOKE_RPC_TIMEOUT_MS   --rpc.timeout_ms=250
+//	[instance.billing] port   YOKE_INSTANCE_BILLING_PORT   --instance.billing.port=5434
+
+package config
+
+import (
+	"fmt"
+	"github.com/vaughan0/go-ini"
+	"strings"
+	"unicode"
+)
+
+// Flags are the options given on the command line, keyed by 'section.name'
+var Flags = map[string]string{}
+
+// ParseFlags takes the '--name=value' and '--section.name=value' options out of
+// args and into Flags, the other arguments are returned in order
+func ParseFlags(args []string) ([]string, error) {
+	rest := []string{}
+	for _, arg := range args {
+		if !strings.HasPrefix(arg, "--") {
+			rest = append(rest, arg)
+			continue
+		}
+		pair := strings.SplitN(strings.TrimPrefix(arg, "--"), "=", 2)
+		if len(pair) != 2 || pair[0] == "" {
+			return nil, fmt.Errorf("I could not understand '%s', options are given as --name=value or --section.name=value", arg)
+		}
+		name := pair[0]
+		if !strings.Contains(name, ".") {
+			name = "config." + name
+		}
+		Flags[name] = pair[1]
+	}
+	return rest, nil
+}
+
+// envName returns the environment variable the option name of section is set
+// with, the options of the [config] section go without the section
+func envName(section, name string) string {
+	if section != "config" {
+		name = section + "_" + name
+	}
+	return "YOKE_" + strings.Map(func(r rune) rune {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) {
+			return unicode.ToUpper(r)
+		}
+		return '_'
+	}, name)
+}
+
+// loadFile loads the config file at path, no path is a file without any options
+// for a node that is configured through the environment and the flags
+func loadFile(path string) (ini.File, error) {
+	if path == "" {
+		return ini.File{}, nil
+	}
+	return ini.LoadFile(path)
+}
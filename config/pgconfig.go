@@ -14,23 +14,46 @@ import (
 	"bufio"
 	"bytes"
 	"fmt"
+	"io/ioutil"
 	"os"
 	"regexp"
+	"sort"
 	"strings"
 )
 
 var (
 	replicationRegex = regexp.MustCompile(`^\s*#?\s*(local|host)\s*(replication)`)
-	overwriteRegex   = regexp.MustCompile(`^\s*#?\s*(listen_addresses|port|wal_level|archive_mode|archive_command|max_wal_senders|wal_keep_segments|hot_standby|synchronous_standby_names)\s*=\s*`)
+	parameterRegex   = regexp.MustCompile(`^[a-z_][a-z0-9_.]*$`)
+	overwriteRegex   = regexp.MustCompile(`^\s*#?\s*(listen_addresses|port|wal_level|archive_mode|archive_command|max_wal_senders|wal_keep_segments|wal_keep_size|hot_standby|synchronous_standby_names|wal_log_hints)\s*=\s*`)
 )
 
-// configureHBAConf attempts to open the 'pg_hba.conf' file. Once open it will scan
+// the parameters of the recovery that yoke writes, next to the ones of overwriteRegex
+var managedParameters = map[string]bool{
+	"primary_conninfo":         true,
+	"restore_command":          true,
+	"recovery_target_timeline": true,
+	"standby_mode":             true,
+	"trigger_file":             true,
+	"promote_trigger_file":     true,
+}
+
+// RoleFile is the file in the data_dir with the parameters of the [postgres]
+// sections for the role the node is in, postgresql.conf includes it
+const RoleFile = "yoke_role.conf"
+
+// ConfigureHBAConf configures the 'pg_hba.conf' of the data_dir of Conf
+func ConfigureHBAConf(ips ...string) error {
+	return Conf.ConfigureHBAConf(ips...)
+}
+
+// ConfigureHBAConf attempts to open the 'pg_hba.conf' file. Once open it will scan
 // the file line by line looking for replication settings, and overwrite only those
-// settings with the settings required for redundancy on Yoke
-func ConfigureHBAConf(ip string) error {
+// settings with the settings required for redundancy on Yoke. Every ip is allowed
+// to replicate from this node.
+func (conf Config) ConfigureHBAConf(ips ...string) error {
 
 	// open the pg_hba.conf
-	file := Conf.DataDir + "pg_hba.conf"
+	file := conf.DataDir + "pg_hba.conf"
 	f, err := os.OpenFile(file, os.O_RDWR, 0644)
 	if err != nil {
 		return err
@@ -70,7 +93,16 @@ func ConfigureHBAConf(ip string) error {
 	}
 
 	// add a replication connection into the hba.conf file so that data can be replicated
-	// to other nodes
+	// to other nodes. hand written replication lines were dropped above, so these
+	// are the only way to replicate from this node
+	method := "trust"
+	if conf.ReplicationPassword != "" {
+		method = "md5"
+	}
+	replication := &bytes.Buffer{}
+	for _, ip := range ips {
+		fmt.Fprintf(replication, "host    replication     %s        %s/32            %s\n", conf.ReplicationRole(), ip, method)
+	}
 	_, err = fmt.Fprintf(f, `%v
 #~-----------------------------------------------------------------------------
 # YOKE CONFIG
@@ -83,19 +115,32 @@ func ConfigureHBAConf(ip string) error {
 # IMPORTANT: these settings will always be overriden when the server boots. They
 # are set dynamically and so should never change.
 
-host    replication     %s        %s/32            trust
-`, string(buffer.Bytes()), Conf.SystemUser, ip)
+%s`, string(buffer.Bytes()), string(replication.Bytes()))
 
 	return err
 }
 
-// configurePGConf attempts to open the 'postgresql.conf' file. Once open it will
+// ReplicationRole is the postgres role the backups replicate with. Without a
+// replication_password they connect as the system user, which pg_hba trusts.
+func (conf Config) ReplicationRole() string {
+	if conf.ReplicationPassword == "" {
+		return conf.SystemUser
+	}
+	return conf.ReplicationUser
+}
+
+// ConfigurePGConf configures the 'postgresql.conf' of the data_dir of Conf
+func ConfigurePGConf(ip string, port int) error {
+	return Conf.ConfigurePGConf(ip, port)
+}
+
+// ConfigurePGConf attempts to open the 'postgresql.conf' file. Once open it will
 // scan the file line by line looking for replication settings, and overwrite only
 // those settings with the settings required for redundancy
-func ConfigurePGConf(ip string, port int) error {
+func (conf Config) ConfigurePGConf(ip string, port int) error {
 
 	// open the postgresql.conf
-	file := Conf.DataDir + "postgresql.conf"
+	file := conf.DataDir + "postgresql.conf"
 	f, err := os.OpenFile(file, os.O_RDWR, 0644)
 	if err != nil {
 		return err
@@ -137,6 +182,12 @@ func ConfigurePGConf(ip string, port int) error {
 		return err
 	}
 
+	// a data_dir that was not created yet gets the settings of the oldest version
+	version, err := conf.DataVersion()
+	if err != nil {
+		version = MinPGVersion
+	}
+
 	// write manual configurations into an 'entry'
 	_, err = fmt.Fprintf(f, `%v
 #~-----------------------------------------------------------------------------
@@ -159,32 +210,81 @@ wal_level = hot_standby           # minimal, archive, or hot_standby
                                   # (change requires restart)
 archive_mode = on                 # allows archiving to be done
                                   # (change requires restart)
-archive_command = 'exit 0'        # command to use to archive a logfile segment
+archive_command = '%s'            # command to use to archive a logfile segment
                                   # placeholders: %%p = path of file to archive
                                   #               %%f = file name only
                                   # e.g. 'test ! -f /mnt/server/archivedir/%%f && cp %%p /mnt/server/archivedir/%%f'
 max_wal_senders = 10              # max number of walsender processes
                                   # (change requires restart)
-wal_keep_segments = 16          	# in logfile segments, 16MB each; 0 disables
+%s# WAL kept for the backups to catch up with
 hot_standby = on                  # "on" allows queries during recovery
                                   # (change requires restart)
 synchronous_standby_names = '*'   # standby servers that provide sync rep
                                   # comma-separated list of application_name
                                   # from standby(s); '*' = any
-`, string(buffer.Bytes()), ip, port)
+wal_log_hints = on                # lets pg_rewind bring back a node that was
+                                  # writable (change requires restart)
+include_if_exists = '%s'          # the parameters of the [postgres] sections for
+                                  # the role of the node
+`, string(buffer.Bytes()), ip, port, conf.ArchiveCommand(), version.walKeep(), RoleFile)
 
 	return err
 }
 
-// createRecovery creates a 'recovery.conf' file with the necessary settings
+// ConfigureRole writes the parameters of the [postgres] section and of the
+// [postgres.<role>] section into the RoleFile of the data_dir, the ones of the
+// role win. Postgres picks them up when it is reloaded.
+func (conf Config) ConfigureRole(role string) error {
+	parameters := map[string]string{}
+	for _, section := range []string{"", role} {
+		for name, value := range conf.PGParameters[section] {
+			parameters[name] = value
+		}
+	}
+	names := make([]string, 0, len(parameters))
+	for name := range parameters {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	buffer := &bytes.Buffer{}
+	fmt.Fprintf(buffer, `#~-----------------------------------------------------------------------------
+# YOKE CONFIG
+#------------------------------------------------------------------------------
+
+# IMPORTANT: this config file is dynamically generated by Yoke for the '%s' role
+# from the [postgres] sections of its config, any changes made here will be
+# overriden.
+
+`, role)
+	for _, name := range names {
+		fmt.Fprintf(buffer, "%s = %s\n", name, quoteParameter(parameters[name]))
+	}
+	return ioutil.WriteFile(conf.DataDir+RoleFile, buffer.Bytes(), 0644)
+}
+
+// CreateRecovery creates the 'recovery.conf' in the data_dir of Conf
+func CreateRecovery(ip string, port int) error {
+	return Conf.CreateRecovery(ip, port)
+}
+
+// CreateRecovery creates a 'recovery.conf' file with the necessary settings
 // required for redundancy on Yoke. This method is called on the node that
-// is being configured to run the 'backup' instance of postgres
-func createRecovery(ip string, port int) error {
+// is being configured to run the 'backup' instance of postgres. Postgres 12 and
+// later have no recovery.conf, they are set up by createStandby instead.
+func (conf Config) CreateRecovery(ip string, port int) error {
+	version, err := conf.DataVersion()
+	if err != nil {
+		return err
+	}
+	if version.AtLeast(12) {
+		return conf.createStandby(ip, port)
+	}
 
-	file := Conf.DataDir + "recovery.conf"
+	file := conf.DataDir + "recovery.conf"
 
 	// open/truncate the recover.conf
-	f, err := os.OpenFile(file, os.O_RDWR, 0644)
+	f, err := os.OpenFile(file, os.O_RDWR|os.O_CREATE, 0644)
 	if err != nil {
 		return err
 	}
@@ -205,18 +305,94 @@ func createRecovery(ip string, port int) error {
 # tries to connect to the primary according to the connection settings
 # primary_conninfo, and receives XLOG records continuously.
 standby_mode = on
-primary_conninfo = 'host=%s port=%d application_name=backup'
+primary_conninfo = %s
+
+# follow the node it streams from onto a new timeline, which is what a backup that
+# is promoted starts one of
+recovery_target_timeline = 'latest'
 
 # restore_command specifies the shell command that is executed to copy log files
 # back from archival storage. This parameter is *required* for an archive
-# recovery, but optional for streaming replication. The given command satisfies
-# the requirement without doing anything.
-restore_command = 'exit 0'
+# recovery, but optional for streaming replication. Without an archive the given
+# command satisfies the requirement without doing anything.
+restore_command = '%s'
 
 # the presence of this file will stop this this node from recovering from the
 # remote node.
 trigger_file = '/data/var/db/postgresql/i-am-primary'
-`, ip, port)
+`, quoteParameter(conf.Conninfo(ip, port)), conf.RestoreCommand())
 
 	return err
 }
+
+// createStandby sets up postgres 12 and later to start as a standby. The recovery
+// parameters go into the postgresql.auto.conf, which is the file ALTER SYSTEM
+// writes, and standby.signal has postgres follow them. A node promoted with
+// pg_promote() removes the signal file itself.
+func (conf Config) createStandby(ip string, port int) error {
+	// postgres refuses to start with a recovery.conf that is left from before an
+	// upgrade
+	if err := os.Remove(conf.DataDir + "recovery.conf"); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	err := conf.SetAutoConf(map[string]string{
+		"primary_conninfo":         conf.Conninfo(ip, port),
+		"recovery_target_timeline": "latest",
+		"restore_command":          conf.RestoreCommand(),
+		// a restored node would stop streaming at the target it was restored to
+		"recovery_target_time":   "",
+		"recovery_target_action": "",
+	})
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(conf.DataDir+"standby.signal", nil, 0600)
+}
+
+// SetAutoConf sets parameters in the postgresql.auto.conf of the data_dir, for when
+// postgres isn't running to take them with ALTER SYSTEM. A parameter without a
+// value is removed, the other parameters of the file are kept.
+func (conf Config) SetAutoConf(parameters map[string]string) error {
+	file := conf.DataDir + "postgresql.auto.conf"
+	contents, err := ioutil.ReadFile(file)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	buffer := &bytes.Buffer{}
+	for _, line := range strings.Split(string(contents), "\n") {
+		name := strings.TrimSpace(strings.SplitN(line, "=", 2)[0])
+		if _, ok := parameters[name]; ok || line == "" {
+			continue
+		}
+		fmt.Fprintln(buffer, line)
+	}
+	names := make([]string, 0, len(parameters))
+	for name := range parameters {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		if parameters[name] != "" {
+			fmt.Fprintf(buffer, "%s = %s\n", name, quoteParameter(parameters[name]))
+		}
+	}
+	return ioutil.WriteFile(file, buffer.Bytes(), 0600)
+}
+
+// Conninfo is the primary_conninfo of a backup that streams from ip and port,
+// with the user and password of the replication role when there is a
+// replication_password
+func (conf Config) Conninfo(ip string, port int) string {
+	conninfo := fmt.Sprintf("host=%s port=%d application_name=backup", ip, port)
+	if conf.ReplicationPassword == "" {
+		return conninfo
+	}
+	quote := strings.NewReplacer(`\`, `\\`, `'`, `\'`)
+	return conninfo + fmt.Sprintf(" user='%s' password='%s'", quote.Replace(conf.ReplicationUser), quote.Replace(conf.ReplicationPassword))
+}
+
+// quoteParameter quotes the value of a parameter of a postgres config file
+func quoteParameter(value string) string {
+	return "'" + strings.Replace(value, "'", "''", -1) + "'"
+}
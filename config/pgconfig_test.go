@@ -0,0 +1,168 @@
+// Copyright (c) 2015 Pagoda Box Inc
+//
+// This Source Code Form is subject to the terms of the Mozilla Public License, v.
+// 2.0. If a copy of the MPL was not distributed with this file, You can obtain one
+// at http://mozilla.org/MPL/2.0/.
+//
+
+package config_test
+
+import (
+	"github.com/nanopack/yoke/config"
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestReplicationEntries(test *testing.T) {
+	dir, err := ioutil.TempDir("", "yoke-data")
+	if err != nil {
+		test.Log(err)
+		test.FailNow()
+	}
+	defer os.RemoveAll(dir)
+
+	conf := config.Defaults
+	conf.DataDir = dir + "/"
+	conf.SystemUser = "postgres"
+	// a hand written replication line would let the backups in without a password
+	ioutil.WriteFile(dir+"/pg_hba.conf", []byte("local   all   all   trust\nhost    replication   all   0.0.0.0/0   trust\n"), 0600)
+	if err := conf.ConfigureHBAConf("10.0.0.2"); err != nil {
+		test.Log(err)
+		test.FailNow()
+	}
+	hba, _ := ioutil.ReadFile(dir + "/pg_hba.conf")
+	if strings.Contains(string(hba), "0.0.0.0/0") || !strings.Contains(string(hba), "host    replication     postgres        10.0.0.2/32            trust") {
+		test.Logf("the replication entries are wrong\n%s", hba)
+		test.Fail()
+	}
+
+	// with a password the backups replicate as the replication_user
+	conf.ReplicationPassword = "it's"
+	if err := conf.ConfigureHBAConf("10.0.0.2"); err != nil {
+		test.Log(err)
+		test.FailNow()
+	}
+	hba, _ = ioutil.ReadFile(dir + "/pg_hba.conf")
+	if strings.Count(string(hba), "replication") != 1 || !strings.Contains(string(hba), "host    replication     repl        10.0.0.2/32            md5") {
+		test.Logf("the replication entries are wrong\n%s", hba)
+		test.Fail()
+	}
+
+	ioutil.WriteFile(dir+"/PG_VERSION", []byte("9.6\n"), 0600)
+	if err := conf.CreateRecovery("10.0.0.1", 5432); err != nil {
+		test.Log(err)
+		test.FailNow()
+	}
+	recovery, _ := ioutil.ReadFile(dir + "/recovery.conf")
+	if !strings.Contains(string(recovery), `primary_conninfo = 'host=10.0.0.1 port=5432 application_name=backup user=''repl'' password=''it\''s'''`) {
+		test.Logf("the primary_conninfo is wrong\n%s", recovery)
+		test.Fail()
+	}
+}
+
+func TestStandbySignal(test *testing.T) {
+	dir, err := ioutil.TempDir("", "yoke-data")
+	if err != nil {
+		test.Log(err)
+		test.FailNow()
+	}
+	defer os.RemoveAll(dir)
+
+	conf := config.Defaults
+	conf.DataDir = dir + "/"
+	ioutil.WriteFile(dir+"/PG_VERSION", []byte("15\n"), 0600)
+	ioutil.WriteFile(dir+"/recovery.conf", []byte("standby_mode = on\n"), 0600)
+	ioutil.WriteFile(dir+"/postgresql.auto.conf", []byte("# Do not edit this file manually!\nwork_mem = '64MB'\nprimary_conninfo = 'host=10.0.0.3'\n"), 0600)
+	if err := conf.CreateRecovery("10.0.0.1", 5432); err != nil {
+		test.Log(err)
+		test.FailNow()
+	}
+
+	if _, err := os.Stat(dir + "/standby.signal"); err != nil {
+		test.Logf("the standby.signal is missing %v", err)
+		test.Fail()
+	}
+	if _, err := os.Stat(dir + "/recovery.conf"); !os.IsNotExist(err) {
+		test.Log("postgres does not start with a recovery.conf")
+		test.Fail()
+	}
+	auto, _ := ioutil.ReadFile(dir + "/postgresql.auto.conf")
+	if !strings.HasPrefix(string(auto), "# Do not edit this file manually!\nwork_mem = '64MB'\nprimary_conninfo = 'host=10.0.0.1 port=5432 application_name=backup'\n") {
+		test.Logf("the recovery parameters are wrong\n%s", auto)
+		test.Fail()
+	}
+}
+
+func TestConfigureRole(test *testing.T) {
+	defer func() { config.Conf = config.Defaults }()
+	dir, err := ioutil.TempDir("", "yoke-data")
+	if err != nil {
+		test.Log(err)
+		test.FailNow()
+	}
+	defer os.RemoveAll(dir)
+
+	path := dir + "/yoke.ini"
+	ioutil.WriteFile(path, []byte(`[config]
+role=primary
+advertise_ip=10.0.0.1
+primary=10.0.0.1:4400
+secondary=10.0.0.2:4400
+monitor=10.0.0.3:4400
+data_dir=`+dir+`/
+
+[postgres]
+work_mem=64MB
+synchronous_commit=local
+
+[postgres.active]
+synchronous_commit=remote_apply
+
+[postgres.backup]
+hot_standby_feedback=on
+`), 0600)
+	if errs := config.Check(path); len(errs) != 0 {
+		test.Logf("the config should have been fine %v", errs)
+		test.FailNow()
+	}
+	if err := config.Conf.ConfigureRole("active"); err != nil {
+		test.Log(err)
+		test.FailNow()
+	}
+	settings, _ := ioutil.ReadFile(dir + "/" + config.RoleFile)
+	if !strings.HasSuffix(string(settings), "\nsynchronous_commit = 'remote_apply'\nwork_mem = '64MB'\n") {
+		test.Logf("the settings of the active role are wrong\n%s", settings)
+		test.Fail()
+	}
+
+	// the options yoke sets itself can't be changed
+	config.Conf = config.Defaults
+	ioutil.WriteFile(path, []byte("[config]\nrole=primary\nadvertise_ip=10.0.0.1\nprimary=10.0.0.1:4400\nsecondary=10.0.0.2:4400\nmonitor=10.0.0.3:4400\n\n[postgres.backup]\nprimary_conninfo=host=elsewhere\n"), 0600)
+	if errs := config.Check(path); len(errs) != 1 || !strings.Contains(errs[0].Error(), "primary_conninfo") {
+		test.Logf("the primary_conninfo should have been refused %v", errs)
+		test.Fail()
+	}
+}
+
+func TestPGUpgrade(test *testing.T) {
+	conf := config.Defaults
+	conf.DataDir = "/var/lib/yoke/data/"
+	conf.UpgradeOldBinDir = "/usr/lib/postgresql/15/bin"
+	conf.UpgradeNewBinDir = "/usr/lib/postgresql/17/bin"
+	conf.UpgradeLink = true
+	if conf.UpgradeDataDir() != "/var/lib/yoke/data.upgrade/" || conf.OldDataDir() != "/var/lib/yoke/data.old/" {
+		test.Logf("the data_dirs of the upgrade are wrong '%v' '%v'", conf.UpgradeDataDir(), conf.OldDataDir())
+		test.Fail()
+	}
+
+	check := conf.PGUpgrade(true)
+	args := strings.Join(check.Args, " ")
+	if check.Path != "/usr/lib/postgresql/17/bin/pg_upgrade" || check.Dir != "/var/lib/yoke" ||
+		!strings.Contains(args, "--old-datadir /var/lib/yoke/data/ --new-datadir /var/lib/yoke/data.upgrade/") ||
+		!strings.HasSuffix(args, "--link --check") {
+		test.Logf("pg_upgrade is run wrong %v in '%v'", args, check.Dir)
+		test.Fail()
+	}
+}
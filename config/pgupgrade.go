@@ -0,0 +1,54 @@
+// Copyright (c) 2015 Pagoda Box Inc
+//
+// This Source Code Form is subject to the terms of the Mozilla Public License, v.
+// 2.0. If a copy of the MPL was not distributed with this file, You can obtain one
+// at http://mozilla.org/MPL/2.0/.
+//
+
+// pgupgrade.go has where pg_upgrade writes the upgraded data_dir, and where the
+// data_dir of the old version is kept once the upgraded one took its place.
+
+package config
+
+import (
+	"fmt"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+// UpgradeDataDir is where pg_upgrade creates the data_dir of the new version, next
+// to the data_dir
+func (conf Config) UpgradeDataDir() string {
+	return strings.TrimSuffix(conf.DataDir, "/") + ".upgrade/"
+}
+
+// OldDataDir is where the data_dir of the old version is kept after an upgrade, an
+// operator removes it once the new version was found to work
+func (conf Config) OldDataDir() string {
+	return strings.TrimSuffix(conf.DataDir, "/") + ".old/"
+}
+
+// PGUpgrade is the pg_upgrade of the new version that upgrades the data_dir into
+// the UpgradeDataDir, or only checks if it could with check. The old postgres has
+// to be stopped unless it only checks.
+func (conf Config) PGUpgrade(check bool) *exec.Cmd {
+	args := []string{
+		"--old-bindir", conf.UpgradeOldBinDir,
+		"--new-bindir", conf.UpgradeNewBinDir,
+		"--old-datadir", conf.DataDir,
+		"--new-datadir", conf.UpgradeDataDir(),
+		"--old-port", fmt.Sprintf("%d", conf.PGPort),
+		"--username", conf.SystemUser,
+	}
+	if conf.UpgradeLink {
+		args = append(args, "--link")
+	}
+	if check {
+		args = append(args, "--check")
+	}
+	cmd := exec.Command(filepath.Join(conf.UpgradeNewBinDir, "pg_upgrade"), args...)
+	// older versions of pg_upgrade write their logs where they are run
+	cmd.Dir = filepath.Dir(strings.TrimSuffix(conf.DataDir, "/"))
+	return cmd
+}
@@ -0,0 +1,157 @@
+// Copyright (c) 2015 Pagoda Box Inc
+//
+// This Source Code Form is subject to the terms of the Mozilla Public License, v.
+// 2.0. If a copy of the MPL was not distributed with this file, You can obtain one
+// at http://mozilla.org/MPL/2.0/.
+//
+
+// pgversion.go keeps what differs between the versions of postgres in one place,
+// the rest of yoke asks the version which command, flag or function to use.
+
+package config
+
+import (
+	"fmt"
+	"io/ioutil"
+	"strconv"
+	"strings"
+)
+
+// PGVersion is the server_version_num of a postgres, e.g. 90624 for 9.6.24 and
+// 150004 for 15.4
+type PGVersion int
+
+const (
+	// MinPGVersion is the oldest postgres yoke runs
+	MinPGVersion PGVersion = 90600
+	// TestedPGVersion is the newest major version yoke was tested with, newer ones
+	// are run with a warning
+	TestedPGVersion PGVersion = 170000
+)
+
+// ParsePGVersion reads a server_version_num, or a version as it is written in the
+// PG_VERSION of a data directory ('9.6', '15') or by postgres --version ('9.6.24')
+func ParsePGVersion(version string) (PGVersion, error) {
+	version = strings.TrimSpace(version)
+	if !strings.Contains(version, ".") && len(version) >= 5 {
+		number, err := strconv.Atoi(version)
+		return PGVersion(number), err
+	}
+	parts := strings.SplitN(version, ".", 3)
+	major, err := strconv.Atoi(parts[0])
+	if err != nil {
+		return 0, fmt.Errorf("'%s' is not a postgres version", version)
+	}
+	if major >= 10 || len(parts) == 1 {
+		return PGVersion(major * 10000), nil
+	}
+	minor, err := strconv.Atoi(parts[1])
+	if err != nil {
+		return 0, fmt.Errorf("'%s' is not a postgres version", version)
+	}
+	return PGVersion(major*10000 + minor*100), nil
+}
+
+// DataVersion returns the version of postgres the data_dir was created with, it
+// only knows the major version
+func (conf Config) DataVersion() (PGVersion, error) {
+	contents, err := ioutil.ReadFile(conf.DataDir + "PG_VERSION")
+	if err != nil {
+		return 0, err
+	}
+	return ParsePGVersion(string(contents))
+}
+
+func (version PGVersion) String() string {
+	if version >= 100000 {
+		if version%10000 == 0 {
+			return strconv.Itoa(int(version) / 10000)
+		}
+		return fmt.Sprintf("%d.%d", version/10000, version%10000)
+	}
+	if version%100 == 0 {
+		return fmt.Sprintf("%d.%d", version/10000, version/100%100)
+	}
+	return fmt.Sprintf("%d.%d.%d", version/10000, version/100%100, version%100)
+}
+
+// Major is the major version, 9.6 and 15 for 9.6.24 and 15.4
+func (version PGVersion) Major() PGVersion {
+	if version >= 100000 {
+		return version / 10000 * 10000
+	}
+	return version / 100 * 100
+}
+
+// AtLeast checks if the version is major or later
+func (version PGVersion) AtLeast(major int) bool {
+	return int(version) >= major*10000
+}
+
+// Supported returns why yoke can't run the version, nil when it can
+func (version PGVersion) Supported() error {
+	if version < MinPGVersion {
+		return fmt.Errorf("postgres %v is not supported, yoke needs %v or later", version, MinPGVersion)
+	}
+	return nil
+}
+
+// Promotion is how a backup is promoted: postgres 12 and later with pg_promote(),
+// the older ones by creating the trigger_file of the recovery.conf
+func (version PGVersion) Promotion() string {
+	if version.AtLeast(12) {
+		return "pg_promote"
+	}
+	return "trigger_file"
+}
+
+// LSNFunctions are the functions that return the WAL position written by a
+// writable database, received by a backup and replayed by it. They were called
+// xlog locations before postgres 10.
+func (version PGVersion) LSNFunctions() (current, received, replayed string) {
+	if version.AtLeast(10) {
+		return "pg_current_wal_lsn()", "pg_last_wal_receive_lsn()", "pg_last_wal_replay_lsn()"
+	}
+	return "pg_current_xlog_location()", "pg_last_xlog_receive_location()", "pg_last_xlog_replay_location()"
+}
+
+// WALMethod is the flag of pg_basebackup that says how the WAL is included
+func (version PGVersion) WALMethod(method string) string {
+	if version.AtLeast(10) {
+		return "--wal-method=" + method
+	}
+	return "--xlog-method=" + method
+}
+
+// BackupCompression is the flag of pg_basebackup that compresses the copy with
+// compression, on the 'server' before it is sent or on the 'client' after. Only
+// postgres 15 and later can compress on the server or with anything but gzip,
+// it is empty when the version can't.
+func (version PGVersion) BackupCompression(compression Compression, where string) string {
+	if !compression.Compressed() {
+		return ""
+	}
+	if !version.AtLeast(15) {
+		if where != "client" || compression.Name != "gzip" {
+			return ""
+		}
+		if compression.Level != 0 {
+			return fmt.Sprintf("--compress=%d", compression.Level)
+		}
+		return "--gzip"
+	}
+	flag := "--compress=" + where + "-" + compression.Name
+	if compression.Level != 0 {
+		flag += fmt.Sprintf(":%d", compression.Level)
+	}
+	return flag
+}
+
+// walKeep is the setting of postgresql.conf that keeps WAL around for the backups,
+// wal_keep_segments became a size in postgres 13
+func (version PGVersion) walKeep() string {
+	if version.AtLeast(13) {
+		return "wal_keep_size = 256MB         "
+	}
+	return "wal_keep_segments = 16        "
+}
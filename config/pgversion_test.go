@@ -0,0 +1,50 @@
+// Copyright (c) 2015 Pagoda Box Inc
+//
+// This Source Code Form is subject to the terms of the Mozilla Public License, v.
+// 2.0. If a copy of the MPL was not distributed with this file, You can obtain one
+// at http://mozilla.org/MPL/2.0/.
+//
+
+package config_test
+
+import (
+	"github.com/nanopack/yoke/config"
+	"testing"
+)
+
+func TestPGVersion(test *testing.T) {
+	for _, version := range []struct {
+		given     string
+		number    config.PGVersion
+		name      string
+		promotion string
+		walMethod string
+		supported bool
+	}{
+		{"9.5", 90500, "9.5", "trigger_file", "--xlog-method=stream", false},
+		{"9.6", 90600, "9.6", "trigger_file", "--xlog-method=stream", true},
+		{"90624", 90624, "9.6.24", "trigger_file", "--xlog-method=stream", true},
+		{"9.6.24", 90600, "9.6", "trigger_file", "--xlog-method=stream", true},
+		{"11", 110000, "11", "trigger_file", "--wal-method=stream", true},
+		{"150004", 150004, "15.4", "pg_promote", "--wal-method=stream", true},
+	} {
+		number, err := config.ParsePGVersion(version.given)
+		if err != nil || number != version.number {
+			test.Logf("'%v' should have been %v, not %v (%v)", version.given, version.number, number, err)
+			test.Fail()
+			continue
+		}
+		if number.String() != version.name || number.Promotion() != version.promotion || number.WALMethod("stream") != version.walMethod || (number.Supported() == nil) != version.supported {
+			test.Logf("'%v' is not handled like %+v", version.given, version)
+			test.Fail()
+		}
+	}
+	if number, _ := config.ParsePGVersion("150004"); number.Major() != 150000 {
+		test.Logf("the major version of 15.4 is %v", number.Major())
+		test.Fail()
+	}
+	if _, err := config.ParsePGVersion("devel"); err == nil {
+		test.Log("'devel' is not a version")
+		test.Fail()
+	}
+}
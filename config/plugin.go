@@ -0,0 +1,89 @@
+// Copyright (c) 2015 Pagoda Box Inc
+//
+// This Source Code Form is subject to the terms of the Mozilla Public License, v.
+// 2.0. If a copy of the MPL was not distributed with this file, You can obtain one
+// at http://mozilla.org/MPL/2.0/.
+//
+
+// plugin.go reads the [plugin.<name>] sections. Every one is a program that is
+// started with the node and serves the plugins it was built with under its name
+// (see the plugin package):
+//
+//	[plugin.acme]
+//	path=/usr/lib/yoke/yoke-acme
+//	args=--verbose
+//	port=5500
+//	region=eu-west-1
+//
+// 'database=acme', 'arbiter=acme' and 'storage=acme' of the [archive] section then
+// use it, and a plugin that takes notifications gets every event. Every option of
+// the section, the ones yoke does not know as well, is handed to the plugin.
+
+package config
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+)
+
+// Plugin is the [plugin.<name>] section of a plugin
+type Plugin struct {
+	Name    string
+	Path    string
+	Args    []string
+	Port    int // the port the service of the plugin listens on, when it is the database
+	Timeout int // seconds a call to the plugin may take, moving a base backup may take longer
+	Options map[string]string
+}
+
+// parsePlugins reads every [plugin.<name>] section of file into conf, in the order
+// of their names
+func parsePlugins(file *options, conf *Config) {
+	conf.plugins = nil
+	for _, section := range file.sections() {
+		if !strings.HasPrefix(section, "plugin.") {
+			continue
+		}
+		plugin := Plugin{Name: strings.TrimPrefix(section, "plugin."), Timeout: 60}
+		plugin.Path, _ = file.Get(section, "path")
+		if args, ok := file.Get(section, "args"); ok {
+			plugin.Args = strings.Fields(args)
+		}
+		parseInt(&plugin.Port, file, section, "port")
+		parseInt(&plugin.Timeout, file, section, "timeout")
+		plugin.Options = file.section(section)
+		conf.plugins = append(conf.plugins, plugin)
+	}
+}
+
+// Plugins returns every plugin the node starts
+func (conf Config) Plugins() []Plugin {
+	return conf.plugins
+}
+
+// Plugin returns the plugin called name, if there is one
+func (conf Config) Plugin(name string) (Plugin, bool) {
+	for _, plugin := range conf.plugins {
+		if plugin.Name == name {
+			return plugin, true
+		}
+	}
+	return Plugin{}, false
+}
+
+// every plugin needs a program to start
+func confirmPlugins() error {
+	for _, plugin := range Conf.plugins {
+		if plugin.Path == "" || !filepath.IsAbs(plugin.Path) {
+			return fmt.Errorf("I need the absolute path of the program of the [plugin.%s] section (path:'%s').", plugin.Name, plugin.Path)
+		}
+		if plugin.Port < 0 || plugin.Port > 65535 {
+			return fmt.Errorf("I could not understand the [plugin.%s] port, it is the port the service listens on (port:'%d').", plugin.Name, plugin.Port)
+		}
+		if plugin.Timeout < 1 {
+			return fmt.Errorf("I could not understand the [plugin.%s] timeout, it is at least a second (timeout:'%d').", plugin.Name, plugin.Timeout)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,98 @@
+// Copyright (c) 2015 Pagoda Box Inc
+//
+// This Source Code Form is subject to the terms of the Mozilla Public License, v.
+// 2.0. If a copy of the MPL was not distributed with this file, You can obtain one
+// at http://mozilla.org/MPL/2.0/.
+//
+
+package config
+
+import (
+	"fmt"
+	"time"
+)
+
+// Reload reads the config file at path again and applies the options that can be
+// changed while the node is running: the check interval, the timeouts, the peer
+// addresses, the log level, the lag and failure thresholds, the rto_target, the
+// [auth] secret and the chaos mode. Every other option
+// keeps the value the node was started with. Conf is left alone when the file can
+// not be read or the new options do not make sense.
+func Reload(path string) (Config, error) {
+	file, err := loadFile(path)
+	if err != nil {
+		return Conf, err
+	}
+	fresh := Defaults
+	options := newOptions(file)
+	parse(options, &fresh)
+	if errs := append(options.errs, options.unknown()...); len(errs) != 0 {
+		return Conf, errs[0]
+	}
+
+	conf := Conf
+	// discovered peers are not in the file
+	if conf.DiscoveryBackend == "" {
+		conf.Primary = fresh.Primary
+		conf.Secondary = fresh.Secondary
+		conf.Monitor = fresh.Monitor
+	}
+	conf.CheckInterval = fresh.CheckInterval
+	conf.DecisionTimeout = fresh.DecisionTimeout
+	conf.RPCTimeout = fresh.RPCTimeout
+	conf.RPCRetries = fresh.RPCRetries
+	conf.RPCRetryDelay = fresh.RPCRetryDelay
+	conf.HealthTimeout = fresh.HealthTimeout
+	conf.HealthFailures = fresh.HealthFailures
+	conf.PeerFailures = fresh.PeerFailures
+	conf.PeerTimeout = fresh.PeerTimeout
+	conf.PhiThreshold = fresh.PhiThreshold
+	conf.MaxAllowedLagBytes = fresh.MaxAllowedLagBytes
+	conf.MaxAllowedLagSeconds = fresh.MaxAllowedLagSeconds
+	conf.FailoverDelay = fresh.FailoverDelay
+	conf.RTOTarget = fresh.RTOTarget
+	conf.LogLevel = fresh.LogLevel
+	conf.AuthSecret = fresh.AuthSecret
+	conf.ChaosEnabled = fresh.ChaosEnabled
+	conf.ChaosSchedule = fresh.ChaosSchedule
+	conf.ChaosFailureRate = fresh.ChaosFailureRate
+	conf.ChaosLatency = fresh.ChaosLatency
+	conf.ChaosTransitionRate = fresh.ChaosTransitionRate
+	if err := confirmReload(conf); err != nil {
+		return Conf, err
+	}
+
+	setLogLevel(conf.LogLevel)
+	Conf = conf
+	warnRTO(conf)
+	return conf, nil
+}
+
+// the confirm functions exit the process, a node that is already running only
+// refuses the reload
+func confirmReload(conf Config) error {
+	switch {
+	case conf.Primary == "" || conf.Secondary == "":
+		return fmt.Errorf("the primary and secondary can not be removed")
+	case (conf.Arbiter == "" || conf.Arbiter == "monitor") && conf.Monitor == "":
+		return fmt.Errorf("the monitor can not be removed while it arbitrates the cluster")
+	case len(conf.Others(conf.AdvertiseAddress())) != len(Conf.Others(Conf.AdvertiseAddress())):
+		return fmt.Errorf("peers can only be added or removed by restarting the node")
+	case len(conf.Monitors()) != len(Conf.Monitors()):
+		return fmt.Errorf("monitors can only be added or removed by restarting the node")
+	case conf.CheckInterval <= 0:
+		return fmt.Errorf("the check_interval has to be at least a second")
+	case conf.RPCTimeout <= 0:
+		return fmt.Errorf("the rpc timeout_ms has to be positive")
+	case conf.RTOTarget < 0:
+		return fmt.Errorf("the rto_target can not be negative")
+	case conf.SSHManage && conf.TLSCert == "" && len(conf.AuthSecrets()) == 0:
+		return fmt.Errorf("the [auth] secret can not be removed while the ssh is managed without [tls] certificates")
+	}
+	return chaosRates(conf)
+}
+
+// Interval returns how long the decider waits between checks of the cluster
+func (conf Config) Interval() time.Duration {
+	return time.Duration(conf.CheckInterval) * time.Second
+}
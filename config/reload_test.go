@@ -0,0 +1,92 @@
+// Copyright (c) 2015 Pagoda Box Inc
+//
+// This Source Code Form is subject to the terms of the Mozilla Public License, v.
+// 2.0. If a copy of the MPL was not distributed with this file, You can obtain one
+// at http://mozilla.org/MPL/2.0/.
+//
+
+package config_test
+
+import (
+	"github.com/nanopack/yoke/config"
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestReload(test *testing.T) {
+	started := config.Defaults
+	started.Role = "primary"
+	started.AdvertiseIp = "10.0.0.1"
+	started.Primary = "10.0.0.1:4400"
+	started.Secondary = "10.0.0.2:4400"
+	started.Monitor = "10.0.0.3:4400"
+	config.Conf = started
+	defer func() { config.Conf = config.Defaults }()
+
+	file, err := ioutil.TempFile("", "yoke.ini")
+	if err != nil {
+		test.Log(err)
+		test.FailNow()
+	}
+	defer os.Remove(file.Name())
+	file.WriteString(`[config]
+role=secondary
+primary=10.0.0.1:4400
+secondary=10.0.0.5:4400
+monitor=10.0.0.3:4400
+check_interval=5
+max_allowed_lag_bytes=1024
+pg_port=6543
+
+[rpc]
+timeout_ms=250
+
+[auth]
+secret=new,old
+`)
+	file.Close()
+
+	conf, err := config.Reload(file.Name())
+	if err != nil {
+		test.Log(err)
+		test.FailNow()
+	}
+	if conf.Interval() != 5*time.Second || conf.MaxAllowedLagBytes != 1024 || conf.CallTimeout() != 250*time.Millisecond {
+		test.Logf("the reloadable options were not applied %+v", conf)
+		test.Fail()
+	}
+	if secrets := conf.AuthSecrets(); len(secrets) != 2 || secrets[0] != "new" {
+		test.Logf("the secrets were not applied %v", secrets)
+		test.Fail()
+	}
+	if conf.Secondary != "10.0.0.5:4400" {
+		test.Logf("the peers were not applied %v", conf.Secondary)
+		test.Fail()
+	}
+	// the rest needs a restart
+	if conf.Role != "primary" || conf.PGPort != 5432 || config.Conf.Role != "primary" {
+		test.Logf("an option that needs a restart changed %v %v", conf.Role, conf.PGPort)
+		test.Fail()
+	}
+
+	// adding a peer is refused, and nothing is applied
+	ioutil.WriteFile(file.Name(), []byte("[config]\nprimary=10.0.0.1:4400\nsecondary=10.0.0.5:4400,10.0.0.6:4400\nmonitor=10.0.0.3:4400\ncheck_interval=9\n"), 0600)
+	if _, err := config.Reload(file.Name()); err == nil || config.Conf.CheckInterval != 5 {
+		test.Logf("the reload should have been refused %v", err)
+		test.Fail()
+	}
+}
+
+func TestReplacePeer(test *testing.T) {
+	conf := config.Config{Primary: "10.0.0.1:4400", Secondary: "10.0.0.2:4400,10.0.0.3:4400", Monitor: "10.0.0.4:4400"}
+	if !conf.ReplacePeer("10.0.0.3:4400", "10.0.0.5:4400") || conf.Secondary != "10.0.0.2:4400,10.0.0.5:4400" {
+		test.Logf("the secondary was not replaced %v", conf.Secondary)
+		test.Fail()
+	}
+	if conf.ReplacePeer("10.0.0.9:4400", "10.0.0.6:4400") {
+		test.Log("a node that is not a peer was replaced")
+		test.Fail()
+	}
+}
@@ -0,0 +1,104 @@
+// Copyright (c) 2015 Pagoda Box Inc
+//
+// This Source Code Form is subject to the terms of the Mozilla Public License, v.
+// 2.0. If a copy of the MPL was not distributed with this file, You can obtain one
+// at http://mozilla.org/MPL/2.0/.
+//
+
+// rto.go works out how long a failover can take with the intervals, timeouts and
+// retries of the config, so that it can be held against the rto_target. It is the
+// worst case: the active node dies right after it last answered, and every call to
+// it runs into the timeout.
+
+package config
+
+import (
+	"fmt"
+	"time"
+)
+
+// RTO is how long a failover can take, as the options have it, in seconds
+type RTO struct {
+	Target    float64  `json:"target"`
+	Estimate  float64  `json:"estimate"`
+	Detection float64  `json:"detection"` // until the dead node is given up on
+	Confirm   float64  `json:"confirm"`   // the check that asks the arbiter before taking over
+	Lease     float64  `json:"lease"`     // until the lease of the dead node runs out
+	Delay     float64  `json:"delay"`     // the failover_delay
+	Met       bool     `json:"met"`
+	Warnings  []string `json:"warnings,omitempty"`
+}
+
+// RTO works out how long a failover can take and how that holds up against the
+// rto_target, a target of 0 is always met
+func (conf Config) RTO() RTO {
+	// a call that fails takes the timeout on every attempt, and the waits between them
+	call := conf.CallTimeout()
+	failedCall := call*time.Duration(conf.RPCRetries+1) + time.Duration(conf.RPCRetryDelay)*time.Millisecond*(1<<uint(conf.RPCRetries)-1)
+
+	// the node is given up on after peer_failures failures in a row, at the heartbeat
+	// or the check_interval with its jitter. a heartbeat is a single call.
+	interval := time.Duration(conf.CheckInterval) * time.Second * time.Duration(100+conf.CheckJitter) / 100
+	probe := failedCall
+	if conf.HeartbeatInterval > 0 {
+		interval = time.Duration(conf.HeartbeatInterval) * time.Millisecond
+		probe = call
+	}
+	failures := conf.PeerFailures
+	if failures < 1 {
+		failures = 1
+	}
+	detection := time.Duration(failures) * (interval + probe)
+	if timeout := time.Duration(conf.PeerTimeout) * time.Second; timeout > detection {
+		detection = timeout
+	}
+
+	// the check that takes over asks the node again and then the arbiter, at the same
+	// time with concurrent_probes
+	confirm := 2 * failedCall
+	if conf.ConcurrentProbes {
+		confirm = failedCall
+	}
+	lease := time.Duration(conf.PromotionLease) * time.Second
+	delay := time.Duration(conf.FailoverDelay) * time.Second
+	estimate := detection + confirm + lease + delay
+	target := time.Duration(conf.RTOTarget) * time.Second
+
+	rto := RTO{
+		Target:    target.Seconds(),
+		Estimate:  estimate.Seconds(),
+		Detection: detection.Seconds(),
+		Confirm:   confirm.Seconds(),
+		Lease:     lease.Seconds(),
+		Delay:     delay.Seconds(),
+		Met:       target == 0 || estimate <= target,
+	}
+	if rto.Met {
+		return rto
+	}
+
+	rto.Warnings = append(rto.Warnings, fmt.Sprintf("a failover can take %v, more than the rto_target of %v", estimate, target))
+	if conf.FailureDetector == "phi" {
+		rto.Warnings = append(rto.Warnings, "the phi detector gives up on a node by how regularly it used to answer, the detection is worked out as if it was 'count'")
+	}
+	if detection > target/2 {
+		rto.Warnings = append(rto.Warnings, fmt.Sprintf("giving up on a dead node takes %v, lower the check_interval, peer_failures or peer_timeout, or send heartbeats with heartbeat_interval_ms", detection))
+	}
+	if confirm > target/4 {
+		rto.Warnings = append(rto.Warnings, fmt.Sprintf("the calls to a dead node take %v, lower the rpc timeout_ms or retries, or set concurrent_probes", confirm))
+	}
+	if lease > target/2 {
+		rto.Warnings = append(rto.Warnings, fmt.Sprintf("the promotion_lease of the dead node runs for %v", lease))
+	}
+	if delay > target/2 {
+		rto.Warnings = append(rto.Warnings, fmt.Sprintf("the failover_delay waits %v", delay))
+	}
+	return rto
+}
+
+// warns about every way the config misses the rto_target
+func warnRTO(conf Config) {
+	for _, warning := range conf.RTO().Warnings {
+		Log.Warn("[config.rto] %s", warning)
+	}
+}
@@ -0,0 +1,55 @@
+// Copyright (c) 2015 Pagoda Box Inc
+//
+// This Source Code Form is subject to the terms of the Mozilla Public License, v.
+// 2.0. If a copy of the MPL was not distributed with this file, You can obtain one
+// at http://mozilla.org/MPL/2.0/.
+//
+
+package config_test
+
+import (
+	"github.com/nanopack/yoke/config"
+	"testing"
+)
+
+func TestRTO(test *testing.T) {
+	conf := config.Defaults
+
+	// a check every 2.2s at worst, a second for the call that gives up on the node and
+	// two for the check that takes over
+	conf.RTOTarget = 10
+	rto := conf.RTO()
+	if !rto.Met || rto.Estimate != 5.2 || rto.Detection != 3.2 || rto.Confirm != 2 || len(rto.Warnings) != 0 {
+		test.Logf("the defaults should meet a 10s target %+v", rto)
+		test.Fail()
+	}
+
+	// the lease and the delay add up
+	conf.RTOTarget = 5
+	conf.PromotionLease = 5
+	conf.FailoverDelay = 3
+	rto = conf.RTO()
+	if rto.Met || rto.Estimate != 13.2 || len(rto.Warnings) != 5 {
+		test.Logf("a 5s target should have been missed on every count %+v", rto)
+		test.Fail()
+	}
+
+	// heartbeats and concurrent probes bring it down
+	conf.PromotionLease = 0
+	conf.FailoverDelay = 0
+	conf.HeartbeatInterval = 500
+	conf.ConcurrentProbes = true
+	rto = conf.RTO()
+	if !rto.Met || rto.Estimate != 2.5 {
+		test.Logf("the heartbeats should meet a 5s target %+v", rto)
+		test.Fail()
+	}
+
+	// no target is always met
+	conf.RTOTarget = 0
+	conf.PeerTimeout = 60
+	if rto = conf.RTO(); !rto.Met || rto.Detection != 60 {
+		test.Logf("no target should have been met %+v", rto)
+		test.Fail()
+	}
+}
@@ -0,0 +1,24 @@
+// Copyright (c) 2015 Pagoda Box Inc
+//
+// This Source Code Form is subject to the terms of the Mozilla Public License, v.
+// 2.0. If a copy of the MPL was not distributed with this file, You can obtain one
+// at http://mozilla.org/MPL/2.0/.
+//
+
+package config
+
+import (
+	"context"
+	"os/exec"
+	"runtime"
+)
+
+// Shell returns the command that runs command through the shell of the system,
+// bash everywhere but on windows, where it is cmd. The command is killed once ctx
+// is done.
+func Shell(ctx context.Context, command string) *exec.Cmd {
+	if runtime.GOOS == "windows" {
+		return exec.CommandContext(ctx, "cmd", "/C", command)
+	}
+	return exec.CommandContext(ctx, "bash", "-c", command)
+}
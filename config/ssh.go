@@ -0,0 +1,63 @@
+// Copyright (c) 2015 Pagoda Box Inc
+//
+// This Source Code Form is subject to the terms of the Mozilla Public License, v.
+// 2.0. If a copy of the MPL was not distributed with this file, You can obtain one
+// at http://mozilla.org/MPL/2.0/.
+//
+
+// ssh.go has the ssh the sync commands copy the data over, and where yoke keeps
+// the key it generated for the node and the host keys of the other nodes it pinned
+// when it manages the ssh of the node.
+
+package config
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// SSHKeyFile is the private key the node copies the data with, its public key is
+// next to it with '.pub'
+func (conf Config) SSHKeyFile() string {
+	return filepath.Join(conf.sshDir(), "id_ed25519")
+}
+
+// KnownHostsFile is where the host keys of the other nodes are pinned
+func (conf Config) KnownHostsFile() string {
+	return filepath.Join(conf.sshDir(), "known_hosts")
+}
+
+// AuthorizedKeysFile is where the keys of the other nodes are authorized, the
+// authorized_keys of the account yoke runs as unless it is set
+func (conf Config) AuthorizedKeysFile() string {
+	if conf.SSHAuthorizedKeys != "" {
+		return conf.SSHAuthorizedKeys
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		home = "~"
+	}
+	return filepath.Join(home, ".ssh", "authorized_keys")
+}
+
+// SSHCommand is the ssh the sync commands run rsync over, the {{ssh}} of their
+// templates. It gives up on a node that doesn't answer within the connect_timeout,
+// or that stops answering for three alive_intervals. With [ssh] manage it only
+// uses the key of the node and only trusts the host keys it pinned.
+func (conf Config) SSHCommand() string {
+	command := fmt.Sprintf("ssh -p %d -o BatchMode=yes -o ConnectTimeout=%d -o ServerAliveInterval=%d -o ServerAliveCountMax=3",
+		conf.SSHPort, conf.SSHConnectTimeout, conf.SSHAliveInterval)
+	if conf.SSHManage {
+		command += fmt.Sprintf(" -i %s -o IdentitiesOnly=yes -o UserKnownHostsFile=%s -o StrictHostKeyChecking=yes",
+			conf.SSHKeyFile(), conf.KnownHostsFile())
+	}
+	return command
+}
+
+func (conf Config) sshDir() string {
+	if conf.SSHDir != "" {
+		return conf.SSHDir
+	}
+	return filepath.Join(conf.StatusDir, "ssh")
+}
@@ -0,0 +1,324 @@
+// Copyright (c) 2015 Pagoda Box Inc
+//
+// This Source Code Form is subject to the terms of the Mozilla Public License, v.
+// 2.0. If a copy of the MPL was not distributed with this file, You can obtain one
+// at http://mozilla.org/MPL/2.0/.
+//
+
+// crypt encrypts the base backups and the WAL segments of the archive with
+// AES-256-GCM before they leave the node. Every file gets a data key of its own,
+// which is stored in the file wrapped by the key it names:
+//
+//	aws:alias/yoke                      an aws kms key, used through the aws cli
+//	gcp:projects/p/locations/l/keyRings/r/cryptoKeys/k
+//	                                    a google cloud kms key, used through gcloud
+//	file:/etc/yoke/archive.key          32 bytes, or 64 hex digits, kept on the nodes
+//
+// A file is only decrypted with the key it is expected to be wrapped with, so one
+// that was planted with a data key wrapped by some other key is refused. The
+// header is authenticated along with every chunk.
+package crypt
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os/exec"
+	"strings"
+	"time"
+)
+
+// the files start with the magic, then the key and the wrapped data key each
+// preceded by their length, then the nonce prefix and the chunks. Everything up to
+// the chunks is the additional data of each of them.
+const (
+	magic     = "YOKEAES1"
+	chunkSize = 64 * 1024
+	prefixLen = 7 // the nonce is the prefix, the number of the chunk and if it is the last
+)
+
+var (
+	NotEncrypted = errors.New("it was not encrypted by yoke")
+	Truncated    = errors.New("it was cut short")
+)
+
+// how long kms gets to wrap or unwrap a data key
+var kmsTimeout = 30 * time.Second
+
+// CheckKey makes sure key names a key that data keys can be wrapped with
+func CheckKey(key string) error {
+	switch {
+	case strings.HasPrefix(key, "aws:") && len(key) > len("aws:"),
+		strings.HasPrefix(key, "gcp:") && len(key) > len("gcp:"):
+		return nil
+	case strings.HasPrefix(key, "file:"):
+		_, err := readKey(strings.TrimPrefix(key, "file:"))
+		return err
+	}
+	return fmt.Errorf("'%v' is not an 'aws:', 'gcp:' or 'file:' key", key)
+}
+
+// Encrypt encrypts what it reads from in with a new data key, which it wraps with
+// key, and writes it to out
+func Encrypt(key string, in io.Reader, out io.Writer) error {
+	dataKey := make([]byte, 32)
+	if _, err := rand.Read(dataKey); err != nil {
+		return err
+	}
+	wrapped, err := wrap(key, dataKey)
+	if err != nil {
+		return err
+	}
+	aead, err := newGCM(dataKey)
+	if err != nil {
+		return err
+	}
+	prefix := make([]byte, prefixLen)
+	if _, err := rand.Read(prefix); err != nil {
+		return err
+	}
+
+	header := &bytes.Buffer{}
+	header.WriteString(magic)
+	writeField(header, []byte(key))
+	writeField(header, wrapped)
+	header.Write(prefix)
+	if _, err := out.Write(header.Bytes()); err != nil {
+		return err
+	}
+	additional := header.Bytes()
+
+	// a chunk is only sealed as the last one once in has nothing left after it
+	reader := bufio.NewReaderSize(in, chunkSize)
+	chunk := make([]byte, chunkSize)
+	for counter := uint32(0); ; counter++ {
+		n, err := io.ReadFull(reader, chunk)
+		if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+			return err
+		}
+		last, err := lastChunk(reader)
+		if err != nil {
+			return err
+		}
+		if _, err := out.Write(aead.Seal(nil, nonce(prefix, counter, last), chunk[:n], additional)); err != nil {
+			return err
+		}
+		if last {
+			return nil
+		}
+	}
+}
+
+// Decrypt decrypts what Encrypt wrote to in, unwrapping the data key with key, and
+// writes it to out. A file that names another key is refused before anything is
+// unwrapped. Nothing of a chunk that doesn't authenticate is written, and a file
+// that is cut short is an error once everything before was.
+func Decrypt(key string, in io.Reader, out io.Writer) error {
+	reader := bufio.NewReaderSize(in, chunkSize+16)
+	header := make([]byte, len(magic))
+	if _, err := io.ReadFull(reader, header); err != nil || string(header) != magic {
+		return NotEncrypted
+	}
+	named, err := readField(reader)
+	if err != nil {
+		return err
+	}
+	if string(named) != key {
+		return fmt.Errorf("it names the key '%s', not '%v'", named, key)
+	}
+	wrapped, err := readField(reader)
+	if err != nil {
+		return err
+	}
+	prefix := make([]byte, prefixLen)
+	if _, err := io.ReadFull(reader, prefix); err != nil {
+		return Truncated
+	}
+	additional := bytes.NewBufferString(magic)
+	writeField(additional, named)
+	writeField(additional, wrapped)
+	additional.Write(prefix)
+
+	dataKey, err := unwrap(key, wrapped)
+	if err != nil {
+		return err
+	}
+	aead, err := newGCM(dataKey)
+	if err != nil {
+		return err
+	}
+
+	chunk := make([]byte, chunkSize+aead.Overhead())
+	for counter := uint32(0); ; counter++ {
+		n, err := io.ReadFull(reader, chunk)
+		if err != nil && err != io.ErrUnexpectedEOF {
+			return Truncated
+		}
+		last, err := lastChunk(reader)
+		if err != nil {
+			return err
+		}
+		plain, err := aead.Open(nil, nonce(prefix, counter, last), chunk[:n], additional.Bytes())
+		if err != nil {
+			if last {
+				// a chunk that was not sealed as the last one is followed by more
+				if _, again := aead.Open(nil, nonce(prefix, counter, false), chunk[:n], additional.Bytes()); again == nil {
+					return Truncated
+				}
+			}
+			return fmt.Errorf("chunk %d does not authenticate (%v)", counter, err)
+		}
+		if _, err := out.Write(plain); err != nil {
+			return err
+		}
+		if last {
+			return nil
+		}
+	}
+}
+
+// lastChunk checks if the chunk that was just read is the last one
+func lastChunk(reader *bufio.Reader) (bool, error) {
+	_, err := reader.Peek(1)
+	if err == io.EOF {
+		return true, nil
+	}
+	return false, err
+}
+
+func nonce(prefix []byte, counter uint32, last bool) []byte {
+	nonce := make([]byte, 12)
+	copy(nonce, prefix)
+	binary.BigEndian.PutUint32(nonce[prefixLen:], counter)
+	if last {
+		nonce[11] = 1
+	}
+	return nonce
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+func writeField(buffer *bytes.Buffer, field []byte) {
+	binary.Write(buffer, binary.BigEndian, uint16(len(field)))
+	buffer.Write(field)
+}
+
+func readField(in io.Reader) ([]byte, error) {
+	var length uint16
+	if err := binary.Read(in, binary.BigEndian, &length); err != nil {
+		return nil, Truncated
+	}
+	field := make([]byte, length)
+	if _, err := io.ReadFull(in, field); err != nil {
+		return nil, Truncated
+	}
+	return field, nil
+}
+
+// wrap encrypts the data key with key
+func wrap(key string, dataKey []byte) ([]byte, error) {
+	switch {
+	case strings.HasPrefix(key, "aws:"):
+		out, err := kms(dataKey, "aws", "kms", "encrypt", "--key-id", strings.TrimPrefix(key, "aws:"), "--plaintext", "fileb:///dev/stdin", "--output", "text", "--query", "CiphertextBlob")
+		if err != nil {
+			return nil, err
+		}
+		return base64.StdEncoding.DecodeString(strings.TrimSpace(string(out)))
+	case strings.HasPrefix(key, "gcp:"):
+		return kms(dataKey, "gcloud", "kms", "encrypt", "--key", strings.TrimPrefix(key, "gcp:"), "--plaintext-file", "-", "--ciphertext-file", "-")
+	case strings.HasPrefix(key, "file:"):
+		master, err := readKey(strings.TrimPrefix(key, "file:"))
+		if err != nil {
+			return nil, err
+		}
+		aead, err := newGCM(master)
+		if err != nil {
+			return nil, err
+		}
+		nonce := make([]byte, aead.NonceSize())
+		if _, err := rand.Read(nonce); err != nil {
+			return nil, err
+		}
+		return aead.Seal(nonce, nonce, dataKey, nil), nil
+	}
+	return nil, CheckKey(key)
+}
+
+// unwrap decrypts the data key that was wrapped with key
+func unwrap(key string, wrapped []byte) ([]byte, error) {
+	switch {
+	case strings.HasPrefix(key, "aws:"):
+		out, err := kms(wrapped, "aws", "kms", "decrypt", "--key-id", strings.TrimPrefix(key, "aws:"), "--ciphertext-blob", "fileb:///dev/stdin", "--output", "text", "--query", "Plaintext")
+		if err != nil {
+			return nil, err
+		}
+		return base64.StdEncoding.DecodeString(strings.TrimSpace(string(out)))
+	case strings.HasPrefix(key, "gcp:"):
+		return kms(wrapped, "gcloud", "kms", "decrypt", "--key", strings.TrimPrefix(key, "gcp:"), "--ciphertext-file", "-", "--plaintext-file", "-")
+	case strings.HasPrefix(key, "file:"):
+		master, err := readKey(strings.TrimPrefix(key, "file:"))
+		if err != nil {
+			return nil, err
+		}
+		aead, err := newGCM(master)
+		if err != nil {
+			return nil, err
+		}
+		if len(wrapped) < aead.NonceSize() {
+			return nil, Truncated
+		}
+		dataKey, err := aead.Open(nil, wrapped[:aead.NonceSize()], wrapped[aead.NonceSize():], nil)
+		if err != nil {
+			return nil, fmt.Errorf("the data key does not unwrap with '%v' (%v)", key, err)
+		}
+		return dataKey, nil
+	}
+	return nil, CheckKey(key)
+}
+
+// kms runs a kms cli with input on its stdin
+func kms(input []byte, name string, args ...string) ([]byte, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), kmsTimeout)
+	defer cancel()
+	cmd := exec.CommandContext(ctx, name, args...)
+	cmd.Stdin = bytes.NewReader(input)
+	stderr := &bytes.Buffer{}
+	cmd.Stderr = stderr
+	out, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("%v %v failed: %v %s", name, args[1], err, strings.TrimSpace(stderr.String()))
+	}
+	return out, nil
+}
+
+// readKey reads a key file of 32 bytes, or of 64 hex digits
+func readKey(file string) ([]byte, error) {
+	contents, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	if len(contents) == 32 {
+		return contents, nil
+	}
+	key, err := hex.DecodeString(strings.TrimSpace(string(contents)))
+	if err != nil || len(key) != 32 {
+		return nil, fmt.Errorf("'%v' is not a key of 32 bytes or 64 hex digits", file)
+	}
+	return key, nil
+}
@@ -0,0 +1,114 @@
+// Copyright (c) 2015 Pagoda Box Inc
+//
+// This Source Code Form is subject to the terms of the Mozilla Public License, v.
+// 2.0. If a copy of the MPL was not distributed with this file, You can obtain one
+// at http://mozilla.org/MPL/2.0/.
+//
+
+package crypt_test
+
+import (
+	"bytes"
+	"crypto/rand"
+	"github.com/nanopack/yoke/crypt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestCrypt(test *testing.T) {
+	file, err := ioutil.TempFile("", "yoke.key")
+	if err != nil {
+		test.Log(err)
+		test.FailNow()
+	}
+	defer os.Remove(file.Name())
+	ioutil.WriteFile(file.Name(), []byte(strings.Repeat("0f", 32)+"\n"), 0600)
+	key := "file:" + file.Name()
+	if err := crypt.CheckKey(key); err != nil {
+		test.Log(err)
+		test.FailNow()
+	}
+
+	for _, size := range []int{0, 1, 64 * 1024, 64*1024 + 1, 200 * 1024} {
+		plain := make([]byte, size)
+		rand.Read(plain)
+		encrypted := &bytes.Buffer{}
+		if err := crypt.Encrypt(key, bytes.NewReader(plain), encrypted); err != nil {
+			test.Log(err)
+			test.FailNow()
+		}
+		decrypted := &bytes.Buffer{}
+		if err := crypt.Decrypt(key, bytes.NewReader(encrypted.Bytes()), decrypted); err != nil || !bytes.Equal(decrypted.Bytes(), plain) {
+			test.Logf("%v bytes did not come back (%v)", size, err)
+			test.Fail()
+		}
+
+		// a flipped bit is found
+		tampered := append([]byte{}, encrypted.Bytes()...)
+		tampered[len(tampered)-1] ^= 1
+		if err := crypt.Decrypt(key, bytes.NewReader(tampered), ioutil.Discard); err == nil {
+			test.Logf("a tampered file of %v bytes decrypted", size)
+			test.Fail()
+		}
+	}
+
+	// a file that is cut off after a whole chunk is still found out
+	plain := make([]byte, 100*1024)
+	encrypted := &bytes.Buffer{}
+	crypt.Encrypt(key, bytes.NewReader(plain), encrypted)
+	cut := encrypted.Len() - (100*1024 - 64*1024 + 16)
+	if err := crypt.Decrypt(key, bytes.NewReader(encrypted.Bytes()[:cut]), ioutil.Discard); err != crypt.Truncated {
+		test.Logf("a file cut short should not decrypt (%v)", err)
+		test.Fail()
+	}
+
+	if err := crypt.Decrypt(key, strings.NewReader("plain text"), ioutil.Discard); err != crypt.NotEncrypted {
+		test.Logf("plain text was not encrypted (%v)", err)
+		test.Fail()
+	}
+	if err := crypt.CheckKey("vault:secret"); err == nil {
+		test.Log("vault keys can't wrap data keys")
+		test.Fail()
+	}
+}
+
+// a file someone planted with a data key of their own is refused
+func TestPlanted(test *testing.T) {
+	dir, err := ioutil.TempDir("", "crypt")
+	if err != nil {
+		test.Log(err)
+		test.FailNow()
+	}
+	defer os.RemoveAll(dir)
+	master := []byte(strings.Repeat("0f", 32) + "\n")
+	ioutil.WriteFile(filepath.Join(dir, "a.key"), master, 0600)
+	ioutil.WriteFile(filepath.Join(dir, "b.key"), master, 0600)
+	ioutil.WriteFile(filepath.Join(dir, "c.key"), []byte(strings.Repeat("f0", 32)+"\n"), 0600)
+	key := "file:" + filepath.Join(dir, "a.key")
+
+	planted := &bytes.Buffer{}
+	if err := crypt.Encrypt("file:"+filepath.Join(dir, "c.key"), strings.NewReader("forged WAL"), planted); err != nil {
+		test.Log(err)
+		test.FailNow()
+	}
+	if err := crypt.Decrypt(key, bytes.NewReader(planted.Bytes()), ioutil.Discard); err == nil {
+		test.Log("a file wrapped with another key was decrypted")
+		test.Fail()
+	}
+
+	// the data key unwraps with b.key too, but the header names another key than
+	// the one the chunks were sealed under
+	encrypted := &bytes.Buffer{}
+	if err := crypt.Encrypt(key, strings.NewReader("WAL"), encrypted); err != nil {
+		test.Log(err)
+		test.FailNow()
+	}
+	renamed := bytes.Replace(encrypted.Bytes(), []byte("a.key"), []byte("b.key"), 1)
+	if err := crypt.Decrypt("file:"+filepath.Join(dir, "b.key"), bytes.NewReader(renamed), ioutil.Discard); err == nil {
+		test.Log("a file with a changed header was decrypted")
+		test.Fail()
+	}
+}
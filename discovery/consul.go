@@ -0,0 +1,216 @@
+// Copyright (c) 2015 Pagoda Box Inc
+//
+// This Source Code Form is subject to the terms of the Mozilla Public License, v.
+// 2.0. If a copy of the MPL was not distributed with this file, You can obtain one
+// at http://mozilla.org/MPL/2.0/.
+//
+
+package discovery
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"github.com/nanopack/yoke/config"
+	"github.com/nanopack/yoke/state"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+type (
+	// consul registers the node with the local consul agent over its http api
+	consul struct {
+		sync.Mutex
+		address string
+		service string
+		token   string
+		client  *http.Client
+		node    Node
+		dbRole  string
+	}
+
+	consulCheck struct {
+		CheckID                        string `json:"CheckID"`
+		Name                           string `json:"Name"`
+		TTL                            string `json:"TTL"`
+		DeregisterCriticalServiceAfter string `json:"DeregisterCriticalServiceAfter"`
+	}
+
+	consulService struct {
+		ID      string            `json:"ID"`
+		Name    string            `json:"Name"`
+		Address string            `json:"Address"`
+		Port    int               `json:"Port"`
+		Tags    []string          `json:"Tags"`
+		Meta    map[string]string `json:"Meta"`
+		Check   consulCheck       `json:"Check"`
+	}
+
+	// what the catalog knows about every instance of a service
+	consulEntry struct {
+		Address        string
+		ServiceAddress string
+		ServicePort    int
+		ServiceMeta    map[string]string
+	}
+)
+
+// the check has to be passed or failed at least this often, a node that stops
+// doing so is removed from the catalog after the second timeout
+const (
+	consulTTL        = "30s"
+	consulDeregister = "10m"
+)
+
+func newConsul(conf config.Config) *consul {
+	return &consul{
+		address: conf.DiscoveryAddress,
+		service: conf.DiscoveryService,
+		token:   conf.DiscoveryToken,
+		client:  &http.Client{Timeout: 5 * time.Second},
+	}
+}
+
+// Register adds the node to the catalog as an instance of the service
+func (consul *consul) Register(node Node) error {
+	consul.Lock()
+	defer consul.Unlock()
+	consul.node = node
+	consul.dbRole = string(state.Initialized)
+	return consul.register()
+}
+
+// Nodes returns every registered instance of the service
+func (consul *consul) Nodes() ([]Node, error) {
+	res, err := consul.request("GET", "/v1/catalog/service/"+consul.service, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+
+	entries := []consulEntry{}
+	if err := json.NewDecoder(res.Body).Decode(&entries); err != nil {
+		return nil, err
+	}
+	nodes := make([]Node, 0, len(entries))
+	for _, entry := range entries {
+		address := entry.ServiceAddress
+		if address == "" {
+			address = entry.Address
+		}
+		nodes = append(nodes, Node{
+			Role:    entry.ServiceMeta["role"],
+			Address: net.JoinHostPort(address, strconv.Itoa(entry.ServicePort)),
+		})
+	}
+	return nodes, nil
+}
+
+// Publish tags the service with the db role, and passes its check while the
+// database is running
+func (consul *consul) Publish(dbRole string) error {
+	consul.Lock()
+	defer consul.Unlock()
+	if dbRole != consul.dbRole {
+		consul.dbRole = dbRole
+		// the tags of a service can only be changed by registering it again
+		if err := consul.register(); err != nil {
+			return err
+		}
+	}
+
+	status := "critical"
+	switch state.DBRole(dbRole) {
+	case state.Active, state.Single, state.Backup:
+		status = "passing"
+	}
+	update := map[string]string{"Status": status, "Output": fmt.Sprintf("the database is '%v'", dbRole)}
+	res, err := consul.request("PUT", "/v1/agent/check/update/"+consul.checkID(), update)
+	if err != nil {
+		return err
+	}
+	res.Body.Close()
+	return nil
+}
+
+// Deregister removes the node from the catalog
+func (consul *consul) Deregister() error {
+	consul.Lock()
+	defer consul.Unlock()
+	res, err := consul.request("PUT", "/v1/agent/service/deregister/"+consul.serviceID(), nil)
+	if err != nil {
+		return err
+	}
+	res.Body.Close()
+	return nil
+}
+
+func (consul *consul) register() error {
+	host, port, err := net.SplitHostPort(consul.node.Address)
+	if err != nil {
+		return err
+	}
+	portNumber, err := strconv.Atoi(port)
+	if err != nil {
+		return err
+	}
+	service := consulService{
+		ID:      consul.serviceID(),
+		Name:    consul.service,
+		Address: host,
+		Port:    portNumber,
+		Tags:    []string{consul.node.Role, consul.dbRole},
+		Meta:    map[string]string{"role": consul.node.Role, "db_role": consul.dbRole},
+		Check: consulCheck{
+			CheckID:                        consul.checkID(),
+			Name:                           "yoke db role",
+			TTL:                            consulTTL,
+			DeregisterCriticalServiceAfter: consulDeregister,
+		},
+	}
+	res, err := consul.request("PUT", "/v1/agent/service/register", service)
+	if err != nil {
+		return err
+	}
+	res.Body.Close()
+	return nil
+}
+
+// every node registers its own instance of the service
+func (consul *consul) serviceID() string {
+	return consul.service + "-" + consul.node.Address
+}
+
+func (consul *consul) checkID() string {
+	return "service:" + consul.serviceID()
+}
+
+// sends a request to the agent, failing on anything but a successful reply
+func (consul *consul) request(method, path string, body interface{}) (*http.Response, error) {
+	var payload bytes.Buffer
+	if body != nil {
+		if err := json.NewEncoder(&payload).Encode(body); err != nil {
+			return nil, err
+		}
+	}
+	req, err := http.NewRequest(method, "http://"+consul.address+path, &payload)
+	if err != nil {
+		return nil, err
+	}
+	if consul.token != "" {
+		req.Header.Set("X-Consul-Token", consul.token)
+	}
+
+	res, err := consul.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if res.StatusCode < 200 || res.StatusCode > 299 {
+		res.Body.Close()
+		return nil, fmt.Errorf("unexpected response %v", res.Status)
+	}
+	return res, nil
+}
@@ -0,0 +1,122 @@
+// Copyright (c) 2015 Pagoda Box Inc
+//
+// This Source Code Form is subject to the terms of the Mozilla Public License, v.
+// 2.0. If a copy of the MPL was not distributed with this file, You can obtain one
+// at http://mozilla.org/MPL/2.0/.
+//
+
+// discovery registers the nodes of a cluster in a service catalog, so that they
+// can find each other without their addresses being in the config, and publishes
+// the role each node is running as.
+package discovery
+
+import (
+	"errors"
+	"fmt"
+	"github.com/nanopack/yoke/config"
+	"github.com/nanopack/yoke/state"
+	"sort"
+	"strings"
+	"time"
+)
+
+var NoPeers = errors.New("the catalog needs a primary and at least one secondary")
+
+type (
+	// Registry is a service catalog every node of the cluster registers in
+	Registry interface {
+		Register(Node) error
+		Nodes() ([]Node, error)
+		Publish(dbRole string) error
+		Deregister() error
+	}
+
+	// Node is a node that registered itself, Address is where its rpc endpoint
+	// listens (IP:port)
+	Node struct {
+		Role    string
+		Address string
+	}
+)
+
+// how often a node looks for the others while it waits for them to register, and
+// how often it publishes its role afterwards
+var (
+	WaitInterval    = 2 * time.Second
+	PublishInterval = 10 * time.Second
+)
+
+// New creates the registry of the discovery backend that was selected in the config
+func New(conf config.Config) (Registry, error) {
+	switch conf.DiscoveryBackend {
+	case "consul":
+		return newConsul(conf), nil
+	case "kubernetes":
+		return newKubernetes(conf)
+	}
+	return nil, fmt.Errorf("unknown discovery backend '%v'", conf.DiscoveryBackend)
+}
+
+// Wait blocks until at least expect nodes have registered, and returns them
+func Wait(registry Registry, expect int) []Node {
+	for {
+		nodes, err := registry.Nodes()
+		if err == nil && len(nodes) >= expect {
+			return nodes
+		}
+		if err != nil {
+			config.Log.Warn("[discovery] could not look up the other nodes (%v)", err)
+		} else {
+			config.Log.Info("[discovery] waiting for %v of %v nodes to register", expect-len(nodes), expect)
+		}
+		<-time.After(WaitInterval)
+	}
+}
+
+// Apply fills the peers of the config in from the registered nodes
+func Apply(conf *config.Config, nodes []Node) error {
+	conf.Primary = ""
+	conf.Monitor = ""
+	secondaries := []string{}
+	monitors := []string{}
+	for _, node := range nodes {
+		switch state.Role(node.Role) {
+		case state.Primary:
+			conf.Primary = node.Address
+		case state.Secondary:
+			secondaries = append(secondaries, node.Address)
+		case state.Monitor:
+			monitors = append(monitors, node.Address)
+		}
+	}
+	if conf.Primary == "" || len(secondaries) == 0 {
+		return NoPeers
+	}
+	// every node has to list the secondaries and the monitors in the same order
+	sort.Strings(secondaries)
+	conf.Secondary = strings.Join(secondaries, ",")
+	sort.Strings(monitors)
+	conf.Monitor = strings.Join(monitors, ",")
+	return nil
+}
+
+// Publish keeps the db role of me up to date in the registry, until done is closed
+func Publish(registry Registry, me state.State, done <-chan struct{}) {
+	last := ""
+	for {
+		role, err := me.GetDBRole()
+		if err == nil {
+			if err := registry.Publish(role); err != nil {
+				config.Log.Warn("[discovery] could not publish the db role '%v' (%v)", role, err)
+			} else if role != last {
+				config.Log.Info("[discovery] published the db role '%v'", role)
+				last = role
+			}
+		}
+		select {
+		case <-done:
+			return
+		case <-time.After(PublishInterval):
+		}
+	}
+}
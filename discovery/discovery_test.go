@@ -0,0 +1,114 @@
+// Copyright (c) 2015 Pagoda Box Inc
+//
+// This Source Code Form is subject to the terms of the Mozilla Public License, v.
+// 2.0. If a copy of the MPL was not distributed with this file, You can obtain one
+// at http://mozilla.org/MPL/2.0/.
+//
+
+package discovery_test
+
+import (
+	"encoding/json"
+	"github.com/nanopack/yoke/config"
+	"github.com/nanopack/yoke/discovery"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// a consul agent that keeps the services registered with it
+type agent struct {
+	sync.Mutex
+	services map[string]map[string]interface{}
+	checks   map[string]string
+}
+
+func (agent *agent) ServeHTTP(res http.ResponseWriter, req *http.Request) {
+	agent.Lock()
+	defer agent.Unlock()
+	switch {
+	case req.URL.Path == "/v1/agent/service/register":
+		service := map[string]interface{}{}
+		json.NewDecoder(req.Body).Decode(&service)
+		agent.services[service["ID"].(string)] = service
+	case strings.HasPrefix(req.URL.Path, "/v1/agent/check/update/"):
+		update := map[string]string{}
+		json.NewDecoder(req.Body).Decode(&update)
+		agent.checks[strings.TrimPrefix(req.URL.Path, "/v1/agent/check/update/")] = update["Status"]
+	case req.URL.Path == "/v1/catalog/service/yoke":
+		entries := []map[string]interface{}{}
+		for _, service := range agent.services {
+			entries = append(entries, map[string]interface{}{
+				"Address":        "10.0.0.254",
+				"ServiceAddress": service["Address"],
+				"ServicePort":    service["Port"],
+				"ServiceMeta":    service["Meta"],
+			})
+		}
+		json.NewEncoder(res).Encode(entries)
+	default:
+		http.NotFound(res, req)
+	}
+}
+
+func TestConsul(test *testing.T) {
+	consul := &agent{services: map[string]map[string]interface{}{}, checks: map[string]string{}}
+	server := httptest.NewServer(consul)
+	defer server.Close()
+
+	conf := config.Config{
+		DiscoveryBackend: "consul",
+		DiscoveryAddress: strings.TrimPrefix(server.URL, "http://"),
+		DiscoveryService: "yoke",
+	}
+	nodes := map[string]string{"10.0.0.1:4400": "primary", "10.0.0.3:4400": "secondary", "10.0.0.2:4400": "secondary", "10.0.0.4:4400": "monitor"}
+	var primary discovery.Registry
+	for address, role := range nodes {
+		registry, err := discovery.New(conf)
+		if err != nil {
+			test.Log(err)
+			test.FailNow()
+		}
+		if err := registry.Register(discovery.Node{Role: role, Address: address}); err != nil {
+			test.Log(err)
+			test.FailNow()
+		}
+		if role == "primary" {
+			primary = registry
+		}
+	}
+
+	found := discovery.Wait(primary, 4)
+	if err := discovery.Apply(&conf, found); err != nil {
+		test.Log(err)
+		test.FailNow()
+	}
+	if conf.Primary != "10.0.0.1:4400" || conf.Secondary != "10.0.0.2:4400,10.0.0.3:4400" || conf.Monitor != "10.0.0.4:4400" {
+		test.Logf("wrong peers %v %v %v", conf.Primary, conf.Secondary, conf.Monitor)
+		test.Fail()
+	}
+
+	if err := primary.Publish("active"); err != nil {
+		test.Log(err)
+		test.FailNow()
+	}
+	service := consul.services["yoke-10.0.0.1:4400"]
+	if tags := service["Tags"].([]interface{}); len(tags) != 2 || tags[1] != "active" {
+		test.Logf("wrong tags %v", tags)
+		test.Fail()
+	}
+	if status := consul.checks["service:yoke-10.0.0.1:4400"]; status != "passing" {
+		test.Logf("wrong check status %v", status)
+		test.Fail()
+	}
+}
+
+func TestApplyNeedsPeers(test *testing.T) {
+	conf := config.Config{}
+	if err := discovery.Apply(&conf, []discovery.Node{{Role: "primary", Address: "10.0.0.1:4400"}}); err != discovery.NoPeers {
+		test.Logf("wrong error %v", err)
+		test.Fail()
+	}
+}
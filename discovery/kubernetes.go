@@ -0,0 +1,101 @@
+// Copyright (c) 2015 Pagoda Box Inc
+//
+// This Source Code Form is subject to the terms of the Mozilla Public License, v.
+// 2.0. If a copy of the MPL was not distributed with this file, You can obtain one
+// at http://mozilla.org/MPL/2.0/.
+//
+
+package discovery
+
+import (
+	"github.com/nanopack/yoke/config"
+	"github.com/nanopack/yoke/kube"
+	"github.com/nanopack/yoke/state"
+	"sync"
+)
+
+// the labels and annotations a pod is registered with
+const (
+	RoleLabel         = "yoke.nanopack.io/role"
+	DBRoleLabel       = "yoke.nanopack.io/db-role"
+	AddressAnnotation = "yoke.nanopack.io/address"
+)
+
+// kubernetes finds the other pods of a StatefulSet through the endpoints of its
+// headless service, and publishes the roles of this pod as labels on it
+type kubernetes struct {
+	sync.Mutex
+	client  *kube.Client
+	service string
+	pod     string
+	dbRole  string
+}
+
+func newKubernetes(conf config.Config) (*kubernetes, error) {
+	client, err := kube.InCluster(conf.KubeAPI, conf.KubeNamespace)
+	if err != nil {
+		return nil, err
+	}
+	return &kubernetes{client: client, service: conf.DiscoveryService, pod: kube.PodName()}, nil
+}
+
+// Register labels the pod with its role, and records where its rpc endpoint
+// listens so the other pods can find it
+func (kubernetes *kubernetes) Register(node Node) error {
+	kubernetes.Lock()
+	defer kubernetes.Unlock()
+	kubernetes.dbRole = string(state.Initialized)
+	labels := map[string]string{RoleLabel: node.Role, DBRoleLabel: kubernetes.dbRole}
+	return kubernetes.client.PatchPod(kubernetes.pod, labels, map[string]string{AddressAnnotation: node.Address})
+}
+
+// Nodes returns every pod behind the headless service that registered itself,
+// pods that are not ready yet are included as the service does not wait for
+// yoke to start the database
+func (kubernetes *kubernetes) Nodes() ([]Node, error) {
+	endpoints, err := kubernetes.client.GetEndpoints(kubernetes.service)
+	if err != nil {
+		return nil, err
+	}
+	nodes := []Node{}
+	for _, subset := range endpoints.Subsets {
+		for _, address := range append(subset.Addresses, subset.NotReadyAddresses...) {
+			if address.TargetRef == nil || address.TargetRef.Kind != "Pod" {
+				continue
+			}
+			pod, err := kubernetes.client.GetPod(address.TargetRef.Name)
+			if err != nil {
+				return nil, err
+			}
+			location, ok := pod.Metadata.Annotations[AddressAnnotation]
+			if !ok {
+				continue
+			}
+			nodes = append(nodes, Node{Role: pod.Metadata.Labels[RoleLabel], Address: location})
+		}
+	}
+	return nodes, nil
+}
+
+// Publish labels the pod with the db role, services can select the active node
+// with it
+func (kubernetes *kubernetes) Publish(dbRole string) error {
+	kubernetes.Lock()
+	defer kubernetes.Unlock()
+	if dbRole == kubernetes.dbRole {
+		return nil
+	}
+	if err := kubernetes.client.PatchPod(kubernetes.pod, map[string]string{DBRoleLabel: dbRole}, nil); err != nil {
+		return err
+	}
+	kubernetes.dbRole = dbRole
+	return nil
+}
+
+// Deregister removes the labels and the address from the pod
+func (kubernetes *kubernetes) Deregister() error {
+	kubernetes.Lock()
+	defer kubernetes.Unlock()
+	labels := map[string]string{RoleLabel: "", DBRoleLabel: ""}
+	return kubernetes.client.PatchPod(kubernetes.pod, labels, map[string]string{AddressAnnotation: ""})
+}
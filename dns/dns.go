@@ -0,0 +1,242 @@
+// Copyright (c) 2015 Pagoda Box Inc
+//
+// This Source Code Form is subject to the terms of the Mozilla Public License, v.
+// 2.0. If a copy of the MPL was not distributed with this file, You can obtain one
+// at http://mozilla.org/MPL/2.0/.
+//
+
+// dns points a dns record at the node that runs the writable database, for clients
+// that can't follow a vip. The record is updated through route53, cloud dns or an
+// rfc2136 dynamic update, and put back when the transition that moved it failed.
+package dns
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/nanopack/yoke/config"
+	"net"
+	"strings"
+	"sync"
+)
+
+type (
+	// Record is run around every transition of the performer, see
+	// monitor.RegisterHook. It points the record at this node before the node
+	// becomes writable, and back at what it held before when that failed.
+	Record struct {
+		sync.Mutex
+		backend backend
+		name    string
+		address string
+		ttl     int
+		log     config.Logger
+
+		// what the record held before this node took it over, while the
+		// transition is in flight
+		previous string
+		changed  bool
+	}
+
+	// where the record lives
+	backend interface {
+		get(name, kind string) (string, error)
+		set(name, kind, value string, ttl int) error
+	}
+
+	// route53 changes the record in a hosted zone with the aws cli
+	route53 struct {
+		run  runner
+		zone string
+	}
+
+	// cloudDNS changes the record in a managed zone with gcloud
+	cloudDNS struct {
+		run  runner
+		zone string
+	}
+
+	// rfc2136 sends a dynamic update to the server with nsupdate, signed with the
+	// tsig key in the key file when there is one
+	rfc2136 struct {
+		run    runner
+		server string
+		key    string
+	}
+
+	// runs a command with bash, with input on its stdin
+	runner func(name, command, input string) ([]byte, error)
+)
+
+// New creates the record from the [dns] section of the config, it is nil when no
+// backend is set
+func New(conf config.Config) (*Record, error) {
+	address := conf.DNSAddress
+	if address == "" {
+		address = conf.AdvertiseIp
+	}
+	record := &Record{name: conf.DNSName, address: address, ttl: conf.DNSTTL, log: conf.Logging()}
+
+	switch conf.DNSBackend {
+	case "":
+		return nil, nil
+	case "route53":
+		record.backend = route53{run: record.run, zone: conf.DNSZone}
+	case "clouddns":
+		record.backend = cloudDNS{run: record.run, zone: conf.DNSZone}
+	case "rfc2136":
+		record.backend = rfc2136{run: record.run, server: conf.DNSServer, key: conf.DNSKeyFile}
+	default:
+		return nil, fmt.Errorf("unknown dns backend '%v'", conf.DNSBackend)
+	}
+	if record.name == "" || record.address == "" {
+		return nil, fmt.Errorf("the dns record needs a name and an address")
+	}
+	return record, nil
+}
+
+// Before points the record at this node when it is about to become writable. A
+// record that can't be updated doesn't hold the transition up, the database is
+// still reachable on the address of the node.
+func (record *Record) Before(transition, from string) error {
+	if transition != "active" && transition != "single" {
+		return nil
+	}
+	record.Lock()
+	defer record.Unlock()
+	record.changed = false
+
+	previous, err := record.backend.get(record.name, record.kind())
+	if err != nil {
+		record.log.Warn("[dns] could not read '%v' (%v)", record.name, err)
+	}
+	if previous == record.address {
+		return nil
+	}
+	record.log.Info("[dns] pointing '%v' at '%v'", record.name, record.address)
+	if err := record.backend.set(record.name, record.kind(), record.address, record.ttl); err != nil {
+		record.log.Error("[dns] could not point '%v' at this node (%v)", record.name, err)
+		return nil
+	}
+	record.previous = previous
+	record.changed = true
+	return nil
+}
+
+// After puts the record back when the transition that moved it failed
+func (record *Record) After(transition, from string, err error) {
+	record.Lock()
+	defer record.Unlock()
+	if !record.changed {
+		return
+	}
+	record.changed = false
+	if err == nil || record.previous == "" {
+		return
+	}
+	record.log.Info("[dns] the transition failed, pointing '%v' back at '%v'", record.name, record.previous)
+	if err := record.backend.set(record.name, record.kind(), record.previous, record.ttl); err != nil {
+		record.log.Error("[dns] could not roll '%v' back (%v)", record.name, err)
+	}
+}
+
+// an A record for an ipv4 address, AAAA otherwise
+func (record *Record) kind() string {
+	if ip := net.ParseIP(record.address); ip != nil && ip.To4() == nil {
+		return "AAAA"
+	}
+	return "A"
+}
+
+func (record *Record) run(name, command, input string) ([]byte, error) {
+	record.log.Debug("[dns] %s command(%s)", name, command)
+	stderr := &bytes.Buffer{}
+	cmd := config.Shell(context.Background(), command)
+	cmd.Stdin = strings.NewReader(input)
+	cmd.Stderr = stderr
+	out, err := cmd.Output()
+	if err != nil {
+		return out, fmt.Errorf("%s failed: %v (%s)", name, err, strings.TrimSpace(stderr.String()))
+	}
+	return out, nil
+}
+
+func (dns route53) get(name, kind string) (string, error) {
+	out, err := dns.run("aws", fmt.Sprintf("aws route53 list-resource-record-sets --hosted-zone-id %s --start-record-name %s --start-record-type %s --max-items 1 --query 'ResourceRecordSets[?Name==`%s`&&Type==`%s`].ResourceRecords[0].Value' --output text",
+		dns.zone, fqdn(name), kind, fqdn(name), kind), "")
+	return value(out), err
+}
+
+func (dns route53) set(name, kind, address string, ttl int) error {
+	batch, err := json.Marshal(map[string]interface{}{
+		"Comment": "yoke failover",
+		"Changes": []interface{}{map[string]interface{}{
+			"Action": "UPSERT",
+			"ResourceRecordSet": map[string]interface{}{
+				"Name":            fqdn(name),
+				"Type":            kind,
+				"TTL":             ttl,
+				"ResourceRecords": []interface{}{map[string]string{"Value": address}},
+			},
+		}},
+	})
+	if err != nil {
+		return err
+	}
+	_, err = dns.run("aws", fmt.Sprintf("aws route53 change-resource-record-sets --hosted-zone-id %s --change-batch '%s'", dns.zone, batch), "")
+	return err
+}
+
+func (dns cloudDNS) get(name, kind string) (string, error) {
+	out, err := dns.run("gcloud", fmt.Sprintf("gcloud dns record-sets list --zone %s --name %s --type %s --format 'value(rrdatas[0])'", dns.zone, fqdn(name), kind), "")
+	return value(out), err
+}
+
+func (dns cloudDNS) set(name, kind, address string, ttl int) error {
+	// update only changes a record that exists
+	args := fmt.Sprintf("%s --zone %s --type %s --ttl %d --rrdatas %s", fqdn(name), dns.zone, kind, ttl, address)
+	_, err := dns.run("gcloud", fmt.Sprintf("gcloud dns record-sets update %s || gcloud dns record-sets create %s", args, args), "")
+	return err
+}
+
+func (dns rfc2136) get(name, kind string) (string, error) {
+	host, port := dns.hostPort()
+	out, err := dns.run("dig", fmt.Sprintf("dig +short @%s -p %s %s %s", host, port, fqdn(name), kind), "")
+	return value(out), err
+}
+
+func (dns rfc2136) set(name, kind, address string, ttl int) error {
+	host, port := dns.hostPort()
+	command := "nsupdate"
+	if dns.key != "" {
+		command += " -k " + dns.key
+	}
+	update := fmt.Sprintf("server %s %s\nupdate delete %s %s\nupdate add %s %d %s %s\nsend\n", host, port, fqdn(name), kind, fqdn(name), ttl, kind, address)
+	_, err := dns.run("nsupdate", command, update)
+	return err
+}
+
+// the server may be given without a port
+func (dns rfc2136) hostPort() (string, string) {
+	host, port, err := net.SplitHostPort(dns.server)
+	if err != nil {
+		return dns.server, "53"
+	}
+	return host, port
+}
+
+// the name with the trailing dot of an absolute name
+func fqdn(name string) string {
+	return strings.TrimSuffix(name, ".") + "."
+}
+
+// the first line a command printed, the clis print 'None' or nothing for a record
+// that doesn't exist
+func value(out []byte) string {
+	lines := strings.Fields(string(out))
+	if len(lines) == 0 || lines[0] == "None" {
+		return ""
+	}
+	return lines[0]
+}
@@ -0,0 +1,88 @@
+// Copyright (c) 2015 Pagoda Box Inc
+//
+// This Source Code Form is subject to the terms of the Mozilla Public License, v.
+// 2.0. If a copy of the MPL was not distributed with this file, You can obtain one
+// at http://mozilla.org/MPL/2.0/.
+//
+
+package dns_test
+
+import (
+	"errors"
+	"github.com/nanopack/yoke/config"
+	"github.com/nanopack/yoke/dns"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// puts an aws cli on the path that records the record it was asked to change, and
+// answers that the record points at 10.0.0.9
+func fakeAWS(test *testing.T) (string, func()) {
+	dir, err := ioutil.TempDir("", "dns")
+	if err != nil {
+		test.Log(err)
+		test.FailNow()
+	}
+	changes := filepath.Join(dir, "changes")
+	script := `#!/bin/bash
+case "$2" in
+list-resource-record-sets) echo 10.0.0.9 ;;
+change-resource-record-sets) echo "$6" | grep -o '"Value":"[^"]*"' >> ` + changes + ` ;;
+esac
+`
+	if err := ioutil.WriteFile(filepath.Join(dir, "aws"), []byte(script), 0755); err != nil {
+		test.Log(err)
+		test.FailNow()
+	}
+	path := os.Getenv("PATH")
+	os.Setenv("PATH", dir+":"+path)
+	return changes, func() {
+		os.Setenv("PATH", path)
+		os.RemoveAll(dir)
+	}
+}
+
+func TestRoute53(test *testing.T) {
+	changes, cleanup := fakeAWS(test)
+	defer cleanup()
+
+	record, err := dns.New(config.Config{DNSBackend: "route53", DNSName: "db.example.com", DNSZone: "Z1", DNSTTL: 30, AdvertiseIp: "10.0.0.1"})
+	if err != nil {
+		test.Log(err)
+		test.FailNow()
+	}
+
+	// a transition that succeeds keeps the record on this node
+	record.Before("single", "backup")
+	record.After("single", "backup", nil)
+	// one that fails points it back at the node it was on
+	record.Before("active", "single")
+	record.After("active", "single", errors.New("promotion failed"))
+	// a backup leaves the record alone
+	record.Before("backup", "active")
+
+	out, err := ioutil.ReadFile(changes)
+	if err != nil {
+		test.Log(err)
+		test.FailNow()
+	}
+	expected := `"Value":"10.0.0.1" "Value":"10.0.0.1" "Value":"10.0.0.9"`
+	if strings.Join(strings.Fields(string(out)), " ") != expected {
+		test.Logf("expected the changes %v, not %q", expected, out)
+		test.Fail()
+	}
+}
+
+func TestNew(test *testing.T) {
+	if record, err := dns.New(config.Config{}); err != nil || record != nil {
+		test.Logf("no backend should create no record, got %v %v", record, err)
+		test.Fail()
+	}
+	if _, err := dns.New(config.Config{DNSBackend: "bogus", DNSName: "db.example.com"}); err == nil {
+		test.Log("an unknown backend should not be accepted")
+		test.Fail()
+	}
+}
@@ -0,0 +1,163 @@
+// Copyright (c) 2015 Pagoda Box Inc
+//
+// This Source Code Form is subject to the terms of the Mozilla Public License, v.
+// 2.0. If a copy of the MPL was not distributed with this file, You can obtain one
+// at http://mozilla.org/MPL/2.0/.
+//
+
+// events publishes what a node is doing (role transitions, lost connectivity, lost
+// replication) to anything that subscribes, like alerting, webhooks or audit logs.
+package events
+
+import (
+	"github.com/nanopack/yoke/config"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Type identifies what happened
+type Type string
+
+const (
+	PromotionStarted   Type = "promotion_started"   // the node is becoming the active node
+	PromotionCompleted Type = "promotion_completed" // the node is the active node
+	DemotionStarted    Type = "demotion_started"    // the node is becoming a backup
+	DemotionCompleted  Type = "demotion_completed"  // the node is a backup
+	SingleStarted      Type = "single_started"      // the node is going to run without a backup
+	SingleCompleted    Type = "single_completed"    // the node is running without a backup
+	TransitionFailed   Type = "transition_failed"   // a transition did not complete
+	Stopped            Type = "stopped"             // the database on the node was stopped
+	ClusterUnavailable Type = "cluster_unavailable" // the node could not reach any other node
+	SyncLost           Type = "sync_lost"           // data is no longer being replicated to a backup
+	SyncUnverified     Type = "sync_unverified"     // the copy of the data a backup took did not verify and was thrown away
+	SplitBrain         Type = "split_brain"         // another node is running as the active node too
+	Unhealthy          Type = "unhealthy"           // the node kept failing its health checks and was stopped
+	Admitted           Type = "admitted"            // a node that replaced a dead one caught up and is decided on again
+	UpgradeStarted     Type = "upgrade_started"     // the database on the node was stopped to be upgraded
+	UpgradeCompleted   Type = "upgrade_completed"   // the database on the node was started again after an upgrade
+	RoleUnrecorded     Type = "role_unrecorded"     // the db role of the node could not be written to its state
+	RoleMismatch       Type = "role_mismatch"       // the state of the node holds another db role than the decider moved it to
+	WALBloat           Type = "wal_bloat"           // the WAL on the node grew past the wal guard's warn_mb
+	WALDiscarded       Type = "wal_discarded"       // the WAL kept for a backup or the archive was given up so the disk would not fill
+	DRPromoted         Type = "dr_promoted"         // the DR cluster stopped tracking the cluster it is the DR cluster of and takes writes
+	BackupVerified     Type = "backup_verified"     // the last base backup was restored into a scratch database and answered its queries
+	BackupUnverified   Type = "backup_unverified"   // the last base backup could not be restored, or the restored database failed its queries
+)
+
+// how many events a slow subscriber can fall behind before events are dropped
+const buffer = 64
+
+type (
+	// Event is a single thing that happened on the node
+	Event struct {
+		Type   Type      `json:"type"`
+		Time   time.Time `json:"time"`
+		Node   string    `json:"node"`
+		Role   string    `json:"role,omitempty"`
+		DBRole string    `json:"db_role,omitempty"`
+		Peer   string    `json:"peer,omitempty"`
+		Error  string    `json:"error,omitempty"`
+		Epoch  uint64    `json:"epoch,omitempty"` // the epoch the node was in, see SetEpoch
+	}
+
+	// Handler is called with every event that is published, in order
+	Handler func(Event)
+
+	// Bus delivers published events to every subscriber
+	Bus struct {
+		sync.Mutex
+		next        int
+		subscribers map[int]chan Event
+	}
+)
+
+// Default is the bus that the rest of yoke publishes to
+var Default = NewBus()
+
+// the epoch of the cluster as the node knows it
+var epoch uint64
+
+// SetEpoch records the epoch of the cluster, the events published from now on carry
+// it so that a consumer can tell what was reported before the last takeover
+func SetEpoch(current uint64) {
+	atomic.StoreUint64(&epoch, current)
+}
+
+// NewBus creates an empty event bus
+func NewBus() *Bus {
+	return &Bus{
+		subscribers: map[int]chan Event{},
+	}
+}
+
+// Subscribe calls handler for every event published to the default bus
+func Subscribe(handler Handler) (unsubscribe func()) {
+	return Default.Subscribe(handler)
+}
+
+// Publish sends an event to every subscriber of the default bus
+func Publish(event Event) {
+	Default.Publish(event)
+}
+
+// Subscribe calls handler for every event published on the bus. Handlers run in
+// their own goroutine so a slow handler does not hold up the node. Unsubscribing
+// waits for the handler to finish with the events that were already published.
+func (bus *Bus) Subscribe(handler Handler) (unsubscribe func()) {
+	events := make(chan Event, buffer)
+	done := make(chan struct{})
+
+	bus.Lock()
+	id := bus.next
+	bus.next++
+	bus.subscribers[id] = events
+	bus.Unlock()
+
+	go func() {
+		defer close(done)
+		for event := range events {
+			handler(event)
+		}
+	}()
+
+	return func() {
+		bus.Lock()
+		if _, ok := bus.subscribers[id]; ok {
+			delete(bus.subscribers, id)
+			close(events)
+		}
+		bus.Unlock()
+		<-done
+	}
+}
+
+// Publish sends an event to every subscriber, filling in the time, node and epoch
+// if they are missing
+func (bus *Bus) Publish(event Event) {
+	if event.Time.IsZero() {
+		event.Time = time.Now()
+	}
+	if event.Node == "" {
+		event.Node = config.Conf.AdvertiseAddress()
+	}
+	if event.Epoch == 0 {
+		event.Epoch = atomic.LoadUint64(&epoch)
+	}
+	log := config.Log.With(config.Fields{"event": string(event.Type), "peer": event.Peer})
+	if event.Error != "" {
+		log.Warn("[events] %v: %v", event.Type, event.Error)
+	} else {
+		log.Info("[events] %v", event.Type)
+	}
+
+	bus.Lock()
+	defer bus.Unlock()
+	for _, events := range bus.subscribers {
+		select {
+		case events <- event:
+		default:
+			log.Warn("[events] subscriber is falling behind, dropping '%v'", event.Type)
+		}
+	}
+}
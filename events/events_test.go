@@ -0,0 +1,51 @@
+// Copyright (c) 2015 Pagoda Box Inc
+//
+// This Source Code Form is subject to the terms of the Mozilla Public License, v.
+// 2.0. If a copy of the MPL was not distributed with this file, You can obtain one
+// at http://mozilla.org/MPL/2.0/.
+//
+
+package events_test
+
+import (
+	"github.com/nanopack/yoke/events"
+	"testing"
+	"time"
+)
+
+func TestPublish(test *testing.T) {
+	bus := events.NewBus()
+	received := make(chan events.Event, 2)
+	unsubscribe := bus.Subscribe(func(event events.Event) {
+		received <- event
+	})
+
+	bus.Publish(events.Event{Type: events.PromotionStarted, Node: "127.0.0.1:1234"})
+	bus.Publish(events.Event{Type: events.PromotionCompleted, Node: "127.0.0.1:1234"})
+
+	for _, expected := range []events.Type{events.PromotionStarted, events.PromotionCompleted} {
+		select {
+		case event := <-received:
+			if event.Type != expected {
+				test.Logf("expected '%v' got '%v'", expected, event.Type)
+				test.FailNow()
+			}
+			if event.Time.IsZero() {
+				test.Log("time was not filled in")
+				test.FailNow()
+			}
+		case <-time.After(time.Second):
+			test.Logf("never received '%v'", expected)
+			test.FailNow()
+		}
+	}
+
+	unsubscribe()
+	bus.Publish(events.Event{Type: events.Stopped, Node: "127.0.0.1:1234"})
+	select {
+	case event := <-received:
+		test.Logf("received '%v' after unsubscribing", event.Type)
+		test.FailNow()
+	case <-time.After(50 * time.Millisecond):
+	}
+}
@@ -0,0 +1,285 @@
+// Copyright (c) 2015 Pagoda Box Inc
+//
+// This Source Code Form is subject to the terms of the Mozilla Public License, v.
+// 2.0. If a copy of the MPL was not distributed with this file, You can obtain one
+// at http://mozilla.org/MPL/2.0/.
+//
+
+// kube is a small client for the parts of the kubernetes api yoke uses when it
+// runs as a sidecar of a StatefulSet: pods, endpoints and leases.
+package kube
+
+import (
+	"bytes"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// where the service account of a pod is mounted
+var ServiceAccount = "/var/run/secrets/kubernetes.io/serviceaccount"
+
+type (
+	// Client talks to the api server as the service account of the pod
+	Client struct {
+		api       string
+		namespace string
+		token     string
+		client    *http.Client
+	}
+
+	// Error is a reply from the api server that was not successful
+	Error struct {
+		Code    int
+		Message string
+	}
+
+	Metadata struct {
+		Name            string            `json:"name"`
+		Namespace       string            `json:"namespace,omitempty"`
+		ResourceVersion string            `json:"resourceVersion,omitempty"`
+		Labels          map[string]string `json:"labels,omitempty"`
+		Annotations     map[string]string `json:"annotations,omitempty"`
+	}
+
+	Pod struct {
+		Metadata Metadata `json:"metadata"`
+	}
+
+	Reference struct {
+		Kind string `json:"kind"`
+		Name string `json:"name"`
+	}
+
+	Address struct {
+		IP        string     `json:"ip"`
+		TargetRef *Reference `json:"targetRef,omitempty"`
+	}
+
+	Port struct {
+		Name string `json:"name,omitempty"`
+		Port int    `json:"port"`
+	}
+
+	Subset struct {
+		Addresses         []Address `json:"addresses,omitempty"`
+		NotReadyAddresses []Address `json:"notReadyAddresses,omitempty"`
+		Ports             []Port    `json:"ports,omitempty"`
+	}
+
+	Endpoints struct {
+		Metadata Metadata `json:"metadata"`
+		Subsets  []Subset `json:"subsets"`
+	}
+
+	LeaseSpec struct {
+		HolderIdentity       string `json:"holderIdentity"`
+		LeaseDurationSeconds int    `json:"leaseDurationSeconds"`
+		RenewTime            string `json:"renewTime,omitempty"`
+	}
+
+	Lease struct {
+		Metadata Metadata  `json:"metadata"`
+		Spec     LeaseSpec `json:"spec"`
+	}
+
+	LeaseList struct {
+		Items []Lease `json:"items"`
+	}
+)
+
+// the format of the MicroTime fields of a lease
+const MicroTime = "2006-01-02T15:04:05.000000Z07:00"
+
+// InCluster creates a client from the service account of the pod, namespace
+// defaults to the namespace of the pod
+func InCluster(api, namespace string) (*Client, error) {
+	token, err := ioutil.ReadFile(filepath.Join(ServiceAccount, "token"))
+	if err != nil {
+		return nil, err
+	}
+	if namespace == "" {
+		current, err := ioutil.ReadFile(filepath.Join(ServiceAccount, "namespace"))
+		if err != nil {
+			return nil, err
+		}
+		namespace = strings.TrimSpace(string(current))
+	}
+	ca, err := ioutil.ReadFile(filepath.Join(ServiceAccount, "ca.crt"))
+	if err != nil {
+		return nil, err
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(ca) {
+		return nil, fmt.Errorf("no certificates in the service account ca")
+	}
+	client := &http.Client{
+		Timeout:   10 * time.Second,
+		Transport: &http.Transport{TLSClientConfig: &tls.Config{RootCAs: pool}},
+	}
+	return New(api, namespace, strings.TrimSpace(string(token)), client), nil
+}
+
+// New creates a client for the api server at api
+func New(api, namespace, token string, client *http.Client) *Client {
+	return &Client{api: strings.TrimSuffix(api, "/"), namespace: namespace, token: token, client: client}
+}
+
+// PodName is the name of the pod this process runs in
+func PodName() string {
+	name, _ := os.Hostname()
+	return name
+}
+
+func (err Error) Error() string {
+	return fmt.Sprintf("kubernetes: %v (%v)", err.Message, err.Code)
+}
+
+// IsNotFound checks if the api server replied that the object does not exist
+func IsNotFound(err error) bool {
+	failed, ok := err.(Error)
+	return ok && failed.Code == http.StatusNotFound
+}
+
+// IsConflict checks if the api server replied that the object was changed by
+// someone else in the meantime
+func IsConflict(err error) bool {
+	failed, ok := err.(Error)
+	return ok && failed.Code == http.StatusConflict
+}
+
+func (client *Client) GetPod(name string) (Pod, error) {
+	pod := Pod{}
+	return pod, client.do("GET", client.core("pods", name), nil, &pod)
+}
+
+// PatchPod merges the labels and annotations into the metadata of the pod, an
+// empty value removes the key
+func (client *Client) PatchPod(name string, labels, annotations map[string]string) error {
+	metadata := map[string]interface{}{}
+	if labels != nil {
+		metadata["labels"] = nullable(labels)
+	}
+	if annotations != nil {
+		metadata["annotations"] = nullable(annotations)
+	}
+	return client.do("PATCH", client.core("pods", name), map[string]interface{}{"metadata": metadata}, nil)
+}
+
+func (client *Client) GetEndpoints(name string) (Endpoints, error) {
+	endpoints := Endpoints{}
+	return endpoints, client.do("GET", client.core("endpoints", name), nil, &endpoints)
+}
+
+// SetEndpoints replaces the subsets of the endpoints, creating them if they do not
+// exist yet
+func (client *Client) SetEndpoints(name string, subsets []Subset) error {
+	if subsets == nil {
+		subsets = []Subset{}
+	}
+	err := client.do("PATCH", client.core("endpoints", name), map[string]interface{}{"subsets": subsets}, nil)
+	if !IsNotFound(err) {
+		return err
+	}
+	endpoints := Endpoints{Metadata: Metadata{Name: name, Namespace: client.namespace}, Subsets: subsets}
+	return client.do("POST", client.core("endpoints", ""), endpoints, nil)
+}
+
+func (client *Client) GetLease(name string) (Lease, error) {
+	lease := Lease{}
+	return lease, client.do("GET", client.leases(name), nil, &lease)
+}
+
+// ListLeases returns the leases that match the label selector
+func (client *Client) ListLeases(selector string) ([]Lease, error) {
+	list := LeaseList{}
+	err := client.do("GET", client.leases("")+"?labelSelector="+selector, nil, &list)
+	return list.Items, err
+}
+
+func (client *Client) CreateLease(lease Lease) error {
+	lease.Metadata.Namespace = client.namespace
+	return client.do("POST", client.leases(""), lease, nil)
+}
+
+// UpdateLease replaces the lease, it fails with a conflict when the lease changed
+// since it was read
+func (client *Client) UpdateLease(lease Lease) error {
+	return client.do("PUT", client.leases(lease.Metadata.Name), lease, nil)
+}
+
+func (client *Client) core(kind, name string) string {
+	path := fmt.Sprintf("/api/v1/namespaces/%v/%v", client.namespace, kind)
+	if name != "" {
+		path += "/" + name
+	}
+	return path
+}
+
+func (client *Client) leases(name string) string {
+	path := fmt.Sprintf("/apis/coordination.k8s.io/v1/namespaces/%v/leases", client.namespace)
+	if name != "" {
+		path += "/" + name
+	}
+	return path
+}
+
+func (client *Client) do(method, path string, body interface{}, out interface{}) error {
+	var payload bytes.Buffer
+	if body != nil {
+		if err := json.NewEncoder(&payload).Encode(body); err != nil {
+			return err
+		}
+	}
+	req, err := http.NewRequest(method, client.api+path, &payload)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+client.token)
+	req.Header.Set("Accept", "application/json")
+	if method == "PATCH" {
+		req.Header.Set("Content-Type", "application/merge-patch+json")
+	} else {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	res, err := client.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	if res.StatusCode < 200 || res.StatusCode > 299 {
+		status := struct {
+			Message string `json:"message"`
+		}{}
+		json.NewDecoder(res.Body).Decode(&status)
+		if status.Message == "" {
+			status.Message = res.Status
+		}
+		return Error{Code: res.StatusCode, Message: status.Message}
+	}
+	if out == nil {
+		return nil
+	}
+	return json.NewDecoder(res.Body).Decode(out)
+}
+
+// a merge patch removes the keys that are set to null
+func nullable(values map[string]string) map[string]interface{} {
+	patch := map[string]interface{}{}
+	for key, value := range values {
+		if value == "" {
+			patch[key] = nil
+		} else {
+			patch[key] = value
+		}
+	}
+	return patch
+}
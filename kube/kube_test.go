@@ -0,0 +1,94 @@
+// Copyright (c) 2015 Pagoda Box Inc
+//
+// This Source Code Form is subject to the terms of the Mozilla Public License, v.
+// 2.0. If a copy of the MPL was not distributed with this file, You can obtain one
+// at http://mozilla.org/MPL/2.0/.
+//
+
+package kube_test
+
+import (
+	"encoding/json"
+	"encoding/pem"
+	"github.com/nanopack/yoke/kube"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestInCluster(test *testing.T) {
+	requests := []string{}
+	patches := []map[string]interface{}{}
+	server := httptest.NewTLSServer(http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+		if req.Header.Get("Authorization") != "Bearer secret" {
+			res.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		requests = append(requests, req.Method+" "+req.URL.Path)
+		switch req.Method {
+		case "PATCH":
+			patch := map[string]interface{}{}
+			json.NewDecoder(req.Body).Decode(&patch)
+			patches = append(patches, patch)
+			if req.URL.Path == "/api/v1/namespaces/db/endpoints/yoke-primary" {
+				res.WriteHeader(http.StatusNotFound)
+				json.NewEncoder(res).Encode(map[string]string{"message": "endpoints not found"})
+			}
+		case "POST":
+			res.WriteHeader(http.StatusCreated)
+		case "GET":
+			json.NewEncoder(res).Encode(kube.Pod{Metadata: kube.Metadata{Name: "yoke-1"}})
+		}
+	}))
+	defer server.Close()
+
+	// the service account mounted into the pod
+	dir, err := ioutil.TempDir("", "serviceaccount")
+	if err != nil {
+		test.Log(err)
+		test.FailNow()
+	}
+	defer os.RemoveAll(dir)
+	ca := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: server.Certificate().Raw})
+	ioutil.WriteFile(filepath.Join(dir, "ca.crt"), ca, 0600)
+	ioutil.WriteFile(filepath.Join(dir, "token"), []byte("secret\n"), 0600)
+	ioutil.WriteFile(filepath.Join(dir, "namespace"), []byte("db"), 0600)
+	kube.ServiceAccount = dir
+
+	client, err := kube.InCluster(server.URL, "")
+	if err != nil {
+		test.Log(err)
+		test.FailNow()
+	}
+	if err := client.PatchPod("yoke-0", map[string]string{"role": "primary", "old": ""}, nil); err != nil {
+		test.Log(err)
+		test.FailNow()
+	}
+	labels := patches[0]["metadata"].(map[string]interface{})["labels"].(map[string]interface{})
+	if old, ok := labels["old"]; !ok || old != nil || labels["role"] != "primary" {
+		test.Logf("wrong labels %v", labels)
+		test.Fail()
+	}
+
+	// endpoints that do not exist yet are created
+	if err := client.SetEndpoints("yoke-primary", []kube.Subset{{Addresses: []kube.Address{{IP: "10.0.0.1"}}}}); err != nil {
+		test.Log(err)
+		test.FailNow()
+	}
+	if len(requests) != 3 || requests[2] != "POST /api/v1/namespaces/db/endpoints" {
+		test.Logf("wrong requests %v", requests)
+		test.Fail()
+	}
+
+	if _, err := client.GetPod("yoke-1"); err != nil {
+		test.Log(err)
+		test.Fail()
+	}
+	if err := kube.New(server.URL, "db", "wrong", server.Client()).PatchPod("yoke-0", nil, nil); err == nil || kube.IsNotFound(err) {
+		test.Logf("wrong error %v", err)
+		test.Fail()
+	}
+}
@@ -8,26 +8,97 @@
 package main
 
 import (
+	"context"
 	"fmt"
 	"github.com/nanobox-io/golang-scribble"
+	"github.com/nanopack/yoke/admin"
+	"github.com/nanopack/yoke/alert"
+	"github.com/nanopack/yoke/archive"
+	"github.com/nanopack/yoke/chaos"
 	"github.com/nanopack/yoke/config"
+	"github.com/nanopack/yoke/crypt"
+	"github.com/nanopack/yoke/discovery"
+	"github.com/nanopack/yoke/dns"
+	"github.com/nanopack/yoke/events"
+	"github.com/nanopack/yoke/metrics"
+	"github.com/nanopack/yoke/migrate"
 	"github.com/nanopack/yoke/monitor"
+	"github.com/nanopack/yoke/pgbouncer"
+	"github.com/nanopack/yoke/plugin"
+	"github.com/nanopack/yoke/proxy"
+	"github.com/nanopack/yoke/secrets"
+	"github.com/nanopack/yoke/sshkeys"
 	"github.com/nanopack/yoke/state"
+	"github.com/nanopack/yoke/systemd"
+	"github.com/nanopack/yoke/trace"
+	"github.com/nanopack/yoke/vip"
+	"github.com/nanopack/yoke/webhook"
 	"net"
 	"os"
-	"os/signal"
 	"runtime"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"syscall"
 	"time"
 )
 
 //
 func main() {
-	if len(os.Args) != 2 {
+	args, err := config.ParseFlags(os.Args[1:])
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+	// the archive_command and the restore_command pipe the archive through these,
+	// nothing else can be written to stdout
+	if len(args) != 0 && (args[0] == "encrypt" || args[0] == "decrypt") {
+		os.Exit(cryptCommand(args))
+	}
+	// the config file can be left out when the environment and the flags give
+	// every option that is needed
+	path := ""
+	if len(args) != 0 && !commands[args[0]] {
+		path, args = args[0], args[1:]
+	}
+	if path == "" && len(args) == 0 && len(config.Flags) == 0 && !configuredByEnv() {
 		fmt.Println("missing required config file!")
 		os.Exit(1)
 	}
-	config.Init(os.Args[1])
+
+	// the config is checked without starting the node
+	if len(args) != 0 && args[0] == "check-config" {
+		os.Exit(checkConfig(path))
+	}
+	config.Init(path)
+
+	// the passwords that refer to a secret are looked up before anything uses them
+	watcher, err := secrets.Resolve(&config.Conf)
+	if err != nil {
+		config.Log.Fatal("%v", err)
+		config.Log.Close()
+		os.Exit(1)
+	}
+
+	// the plugins are started before anything looks up what they serve, a plugin
+	// whose node exits without stopping it exits by itself
+	stopPlugins, err := plugin.Load(config.Conf)
+	if err != nil {
+		config.Log.Fatal("%v", err)
+		config.Log.Close()
+		os.Exit(1)
+	}
+	defer stopPlugins()
+
+	// the archive and migration commands run against the local database, and then exit
+	if len(args) != 0 {
+		if err := archiveCommand(args); err != nil {
+			config.Log.Fatal("%v", err)
+			config.Log.Close()
+			os.Exit(1)
+		}
+		return
+	}
 
 	config.ConfigurePGConf("0.0.0.0", config.Conf.PGPort)
 
@@ -37,87 +108,273 @@ func main() {
 		os.Exit(1)
 	}
 
-	location := fmt.Sprintf("%v:%d", config.Conf.AdvertiseIp, config.Conf.AdvertisePort)
+	location := config.Conf.AdvertiseAddress()
+
+	// with a discovery backend the other nodes are looked up instead of configured
+	var registry discovery.Registry
+	if config.Conf.DiscoveryBackend != "" {
+		registry, err = discovery.New(config.Conf)
+		if err != nil {
+			panic(err)
+		}
+		if err := registry.Register(discovery.Node{Role: config.Conf.Role, Address: location}); err != nil {
+			panic(err)
+		}
+		defer registry.Deregister()
+		if err := discovery.Apply(&config.Conf, discovery.Wait(registry, config.Conf.DiscoveryExpect)); err != nil {
+			panic(err)
+		}
+	}
+
 	me, err := state.NewLocalState(config.Conf.Role, location, config.Conf.DataDir, store)
 	if err != nil {
 		panic(err)
 	}
+	if registry != nil {
+		published := make(chan struct{})
+		defer close(published)
+		go discovery.Publish(registry, me, published)
+	}
 
-	me.ExposeRPCEndpoint("tcp", location)
+	state.SetTransport(config.Conf.RPCTransport)
+	state.EnableAuth(config.Conf.AuthSecrets()...)
+	state.SetCallPolicy(state.CallPolicy{
+		Retries: config.Conf.RPCRetries,
+		Delay:   time.Duration(config.Conf.RPCRetryDelay) * time.Millisecond,
+	})
 
-	var other state.State
-	var host string
-	switch config.Conf.Role {
-	case "primary":
-		location := config.Conf.Secondary
-		other = state.NewRemoteState("tcp", location, time.Second)
-		host, _, err = net.SplitHostPort(location)
+	if config.Conf.TLSCert != "" {
+		certificates, err := state.NewCertificates(config.Conf.TLSCert, config.Conf.TLSKey, config.Conf.TLSCA)
 		if err != nil {
 			panic(err)
 		}
-	case "secondary":
-		location := config.Conf.Primary
-		other = state.NewRemoteState("tcp", location, time.Second)
-		host, _, err = net.SplitHostPort(location)
+		state.EnableTLS(certificates)
+	}
+
+	// chaos mode stays inert until the config or the admin api turns it on
+	monkey, err := chaos.New(config.Conf)
+	if err != nil {
+		panic(err)
+	}
+	state.SetInjector(monkey)
+
+	me.ExposeRPCEndpoint("tcp", location)
+
+	api := admin.New(me)
+	api.SetChaos(monkey)
+	if config.Conf.AdminListen != "" {
+		if _, err := api.Listen(config.Conf.AdminListen); err != nil {
+			panic(err)
+		}
+	}
+
+	if len(config.Conf.WebhookURLs()) != 0 {
+		defer events.Subscribe(webhook.New(config.Conf).Handle)()
+	}
+	if alerter := alert.New(config.Conf); alerter.Enabled() {
+		defer events.Subscribe(alerter.Handle)()
+	}
+
+	// the monitor does not need to monitor anything, it just acts as a secondary
+	// mode of communication in network splits
+	var others []state.State
+	var hosts []string
+	for _, address := range config.Conf.Others(location) {
+		others = append(others, state.NewRemoteState("tcp", address, config.Conf.CallTimeout()))
+		host, _, err := net.SplitHostPort(address)
 		if err != nil {
 			panic(err)
 		}
-	default:
-		// nothing as the monitor does not need to monitor anything
-		// the monitor just acts as a secondary mode of communication in network
-		// splits
+		hosts = append(hosts, host)
 	}
+	api.SetCluster(others, config.Conf.DatabasePort())
 
-	mon := state.NewRemoteState("tcp", config.Conf.Monitor, time.Second)
+	if config.Conf.ProxyListen != "" && len(others) != 0 {
+		nodes := append([]state.State{me}, others...)
+		if _, err := proxy.New(nodes, config.Conf.DatabasePort()).Listen(config.Conf.ProxyListen); err != nil {
+			panic(err)
+		}
+	}
+
+	arbiter, err := monitor.NewArbiter(config.Conf)
+	if err != nil {
+		panic(err)
+	}
 
 	var perform monitor.Performer
+	var decide monitor.Decider
+	var databases sync.WaitGroup
 	finished := make(chan error)
-	if other != nil {
+	ready := make(chan monitor.Decider, 1)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
 
-		perform = monitor.NewPerformer(me, other, config.Conf)
+	go watcher.Watch(ctx, time.Duration(config.Conf.SecretsRefresh)*time.Second)
 
-		if err := perform.Initialize(); err != nil {
+	if config.Conf.StatsdAddress != "" {
+		sink, err := metrics.NewStatsd(config.Conf.StatsdAddress, config.Conf.StatsdFormat == "datadog")
+		if err != nil {
 			panic(err)
 		}
+		defer sink.Close()
+		go metrics.Push(ctx, sink, time.Duration(config.Conf.StatsdInterval)*time.Second)
+	}
 
-		if err := config.ConfigureHBAConf(host); err != nil {
+	if config.Conf.TraceEndpoint != "" {
+		exporter := trace.NewOTLP(config.Conf.TraceEndpoint, config.Conf.TraceService, location, 5*time.Second)
+		trace.SetExporter(exporter)
+		go exporter.Run(ctx, 5*time.Second)
+	}
+
+	// until the decider is looping systemd's start timeout is what catches a
+	// node that hangs
+	var looping atomic.Value
+	go systemd.RunWatchdog(func() time.Time {
+		if decide, ok := looping.Load().(monitor.Decider); ok && !decide.LastLoop().IsZero() {
+			return decide.LastLoop()
+		}
+		return time.Now()
+	}, ctx.Done())
+
+	if len(others) != 0 {
+
+		floating, err := vip.New(config.Conf)
+		if err != nil {
+			panic(err)
+		}
+		record, err := dns.New(config.Conf)
+		if err != nil {
 			panic(err)
 		}
+		if record != nil {
+			monitor.RegisterHook(record)
+		}
+		if bouncer := pgbouncer.New(config.Conf); bouncer != nil {
+			monitor.RegisterHook(bouncer)
+		}
+		// the key the node copies the data with is generated the first time it starts
+		keys, err := sshkeys.New(config.Conf)
+		if err != nil {
+			panic(err)
+		}
+		if keys != nil {
+			monitor.RegisterKeyring(keys)
+		}
 
-		if err := config.ConfigurePGConf("0.0.0.0", config.Conf.PGPort); err != nil {
+		switch config.Conf.Database {
+		case "mysql":
+			perform = monitor.NewMySQLPerformer(me, others, floating, config.Conf)
+		case "redis":
+			perform = monitor.NewRedisPerformer(me, others, floating, config.Conf)
+		case "script":
+			perform = monitor.NewScriptPerformer(me, others, floating, config.Conf)
+		case "postgres":
+			perform = monitor.NewPerformer(me, others, floating, config.Conf)
+		default:
+			if perform, err = monitor.NewServicePerformer(me, others, floating, config.Conf); err != nil {
+				panic(err)
+			}
+		}
+		if rotator, ok := perform.(secrets.Rotator); ok {
+			watcher.Subscribe(config.Conf, rotator)
+		}
+
+		if err := perform.Initialize(); err != nil {
 			panic(err)
 		}
 
+		if config.Conf.Database == "postgres" {
+			if err := config.ConfigureHBAConf(hosts...); err != nil {
+				panic(err)
+			}
+
+			if err := config.ConfigurePGConf("0.0.0.0", config.Conf.PGPort); err != nil {
+				panic(err)
+			}
+		}
+
+		if reporter, ok := perform.(monitor.SyncReporter); ok {
+			api.SetSyncReporter(reporter)
+		}
 		if err := perform.Start(); err != nil {
 			panic(err)
 		}
 
 		go func() {
-			decide := monitor.NewDecider(me, other, mon, perform)
-			decide.Loop(time.Second * 2)
+			decide, err := monitor.NewDeciderContext(ctx, me, others, arbiter, perform, config.Conf)
+			if err != nil {
+				config.Log.Fatal("the cluster could not be checked %v", err)
+				finished <- err
+				return
+			}
+			api.SetDecider(decide)
+			looping.Store(decide)
+			go monkey.Run(ctx, me, decide, config.Conf.Interval())
+			ready <- decide
+			// the node counts as started once it has decided what it is
+			systemd.Notify(systemd.Ready)
+			if err := decide.Loop(ctx, config.Conf.Interval()); err != nil && err != context.Canceled {
+				finished <- err
+			}
 		}()
 
+		if config.Conf.ArchiveSchedule != "" || config.Conf.VerifyBackupSchedule != "" {
+			scheduler, err := archive.NewScheduler(config.Conf, me, others)
+			if err != nil {
+				panic(err)
+			}
+			go scheduler.Run(ctx)
+		}
+
 		go func() {
 			err := perform.Loop()
 			if err != nil {
 				finished <- err
 			}
-			// how do I stop the decide loop?
-			close(finished)
+			cancel()
 		}()
+
+		// the other databases of the node are decided on by themselves, they only
+		// share the arbiter
+		for _, conf := range config.Conf.Databases()[1:] {
+			databases.Add(1)
+			go func(conf config.Config) {
+				defer databases.Done()
+				if err := runDatabase(ctx, conf, arbiter, watcher); err != nil && ctx.Err() == nil {
+					finished <- fmt.Errorf("instance '%v' %v", conf.Instance, err)
+				}
+			}(conf)
+		}
+	}
+
+	api.SetReloader(func() error {
+		return reload(path, location, others, arbiter, monkey, &looping)
+	})
+	api.SetReplacer(func(old, address string) error {
+		return replace(old, address, location, others, arbiter, &looping)
+	})
+
+	// the monitor has nothing to decide, it is ready once it listens
+	if len(others) == 0 {
+		systemd.Notify(systemd.Ready)
 	}
 
 	// signal Handle
-	signals := make(chan os.Signal, 1)
-	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM, os.Kill, syscall.SIGQUIT, syscall.SIGALRM)
+	signals := notifySignals()
 
 	// Block until a signal is received.
 	for {
 		select {
+		case decide = <-ready:
 		case err := <-finished:
-			// the performer is finished, something triggered a stop.
+			// the performer or the decider is finished, something triggered a stop.
 			if err != nil {
-				panic(err)
+				config.Log.Fatal("shutting down %v", err)
+				if perform != nil {
+					perform.Stop()
+				}
+				config.Log.Close()
+				os.Exit(1)
 			}
 			config.Log.Info("the database was shut down")
 			return
@@ -125,14 +382,26 @@ func main() {
 			switch signal {
 			case syscall.SIGINT, os.Kill, syscall.SIGQUIT, syscall.SIGTERM:
 				config.Log.Info("shutting down")
-				if perform != nil {
-					// stop the database, then wait for it to be stopped
+				systemd.Notify(systemd.Stopping)
+				cancel()
+				switch {
+				case decide != nil:
+					// finish the current decision, stop the database and let the
+					// other nodes know that this node is gone
+					config.Log.Info("shutting down the decider")
+					if err := decide.Shutdown(); err != nil {
+						config.Log.Error("the decider did not shut down cleanly %v", err)
+					}
+				case perform != nil:
 					config.Log.Info("shutting down the database")
 					perform.Stop()
-					perform = nil
-					config.Log.Info("waiting for the database")
-				} else {
-					return
+				}
+				databases.Wait()
+				return
+			case syscall.SIGHUP:
+				config.Log.Info("reloading the config")
+				if err := reload(path, location, others, arbiter, monkey, &looping); err != nil {
+					config.Log.Error("the config was not reloaded %v", err)
 				}
 			case syscall.SIGALRM:
 				config.Log.Info("Printing Stack Trace")
@@ -143,3 +412,318 @@ func main() {
 		}
 	}
 }
+
+// reload applies the options of the config file that can be changed while the node
+// runs, the other nodes are pointed at their new addresses
+func reload(path, location string, others []state.State, arbiter monitor.Arbiter, monkey *chaos.Monkey, looping *atomic.Value) error {
+	previous := config.Conf
+	conf, err := config.Reload(path)
+	if err != nil {
+		return err
+	}
+	if err := monkey.Reload(conf); err != nil {
+		return err
+	}
+
+	state.SetCallPolicy(state.CallPolicy{
+		Retries: conf.RPCRetries,
+		Delay:   time.Duration(conf.RPCRetryDelay) * time.Millisecond,
+	})
+	state.EnableAuth(conf.AuthSecrets()...)
+	for i, address := range conf.Others(location) {
+		if relocator, ok := others[i].(state.Relocator); ok {
+			relocator.Relocate(address, conf.CallTimeout())
+		}
+	}
+	monitor.RelocateMonitors(arbiter, conf.Monitors(), conf.CallTimeout())
+
+	if strings.Join(previous.Others(location), ",") != strings.Join(conf.Others(location), ",") {
+		if err := allowReplication(conf, location); err != nil {
+			return err
+		}
+	}
+
+	if decide, ok := looping.Load().(monitor.Decider); ok {
+		decide.Reload(conf)
+	}
+	return nil
+}
+
+// replace points this node at the node that replaced the dead one at old. A new
+// database node is synced by the active node, and only decided on once it has
+// caught up.
+func replace(old, address, location string, others []state.State, arbiter monitor.Arbiter, looping *atomic.Value) error {
+	if old == location {
+		return fmt.Errorf("a node can not replace itself")
+	}
+	if _, _, err := net.SplitHostPort(address); err != nil {
+		return err
+	}
+	conf := config.Conf
+	monitorMoved := false
+	for _, node := range conf.Monitors() {
+		monitorMoved = monitorMoved || node == old
+	}
+	if !conf.ReplacePeer(old, address) {
+		return fmt.Errorf("'%v' is not a member of the cluster", old)
+	}
+	config.Conf = conf
+	config.Log.Info("'%v' is replaced by '%v'", old, address)
+
+	if monitorMoved {
+		monitor.RelocateMonitors(arbiter, conf.Monitors(), conf.CallTimeout())
+		return nil
+	}
+	for _, other := range others {
+		if other.Location() != old {
+			continue
+		}
+		if relocator, ok := other.(state.Relocator); ok {
+			relocator.Relocate(address, conf.CallTimeout())
+		}
+	}
+	if err := allowReplication(conf, location); err != nil {
+		return err
+	}
+	if decide, ok := looping.Load().(monitor.Decider); ok {
+		return decide.Join(address)
+	}
+	return nil
+}
+
+// runDatabase runs one of the [instance] databases of the node with a state, a
+// performer and a decider of its own, until ctx is done. The monitor arbiter is
+// shared with the database of the [config] section, the other arbiters keep a
+// leader key for every database and are created for each.
+func runDatabase(ctx context.Context, conf config.Config, arbiter monitor.Arbiter, watcher *secrets.Watcher) error {
+	log := conf.Logging()
+	location := conf.AdvertiseAddress()
+	store, err := scribble.New(conf.StatusDir, config.Log)
+	if err != nil {
+		return err
+	}
+	me, err := state.NewLocalState(conf.Role, location, conf.DataDir, store)
+	if err != nil {
+		return err
+	}
+	if _, err := me.ExposeRPCEndpoint("tcp", location); err != nil {
+		return err
+	}
+
+	var others []state.State
+	var hosts []string
+	for _, address := range conf.Others(location) {
+		others = append(others, state.NewRemoteState("tcp", address, conf.CallTimeout()))
+		host, _, err := net.SplitHostPort(address)
+		if err != nil {
+			return err
+		}
+		hosts = append(hosts, host)
+	}
+	if conf.Arbiter != "" && conf.Arbiter != "monitor" {
+		if arbiter, err = monitor.NewArbiter(conf); err != nil {
+			return err
+		}
+	}
+
+	var perform monitor.Performer
+	switch conf.Database {
+	case "mysql":
+		perform = monitor.NewMySQLPerformer(me, others, vip.None, conf)
+	case "redis":
+		perform = monitor.NewRedisPerformer(me, others, vip.None, conf)
+	case "script":
+		perform = monitor.NewScriptPerformer(me, others, vip.None, conf)
+	case "postgres":
+		perform = monitor.NewPerformer(me, others, vip.None, conf)
+	default:
+		if perform, err = monitor.NewServicePerformer(me, others, vip.None, conf); err != nil {
+			return err
+		}
+	}
+	if rotator, ok := perform.(secrets.Rotator); ok {
+		watcher.Subscribe(conf, rotator)
+	}
+	if err := perform.Initialize(); err != nil {
+		return err
+	}
+	if conf.Database == "postgres" {
+		if err := conf.ConfigureHBAConf(hosts...); err != nil {
+			return err
+		}
+		if err := conf.ConfigurePGConf("0.0.0.0", conf.PGPort); err != nil {
+			return err
+		}
+	}
+	if err := perform.Start(); err != nil {
+		return err
+	}
+	go func() {
+		if err := perform.Loop(); err != nil {
+			log.Error("the database stopped %v", err)
+		}
+	}()
+
+	decide, err := monitor.NewDeciderContext(ctx, me, others, arbiter, perform, conf)
+	if err != nil {
+		perform.Stop()
+		return err
+	}
+	err = decide.Loop(ctx, conf.Interval())
+	log.Info("shutting down the decider")
+	if shutdownErr := decide.Shutdown(); shutdownErr != nil {
+		log.Error("the decider did not shut down cleanly %v", shutdownErr)
+	}
+	if err == context.Canceled {
+		return nil
+	}
+	return err
+}
+
+// lets the other nodes replicate from the local postgres after they moved
+func allowReplication(conf config.Config, location string) error {
+	if conf.Database != "postgres" || len(conf.Others(location)) == 0 {
+		return nil
+	}
+	var hosts []string
+	for _, address := range conf.Others(location) {
+		host, _, err := net.SplitHostPort(address)
+		if err != nil {
+			return err
+		}
+		hosts = append(hosts, host)
+	}
+	if err := config.ConfigureHBAConf(hosts...); err != nil {
+		return err
+	}
+	return monitor.ReloadPostgres(conf)
+}
+
+// the commands that can be given after the config file, or instead of it
+var commands = map[string]bool{"check-config": true, "base-backup": true, "restore": true, "verify-backup": true, "prune": true, "clone": true, "migrate": true, "cutover": true}
+
+// configuredByEnv tells if any option is set in the environment
+func configuredByEnv() bool {
+	for _, variable := range os.Environ() {
+		if strings.HasPrefix(variable, "YOKE_") {
+			return true
+		}
+	}
+	return false
+}
+
+// checkConfig prints every problem with the config file at path, and with what its
+// options point at. It returns the exit status of the command.
+func checkConfig(path string) int {
+	errs := config.Check(path)
+	if len(errs) == 0 {
+		errs = config.Conf.CheckEnvironment(config.Conf.CallTimeout())
+	}
+	for _, err := range errs {
+		fmt.Println(err)
+	}
+	if len(errs) != 0 {
+		return 1
+	}
+	fmt.Printf("'%v' is fine\n", path)
+	return 0
+}
+
+// encrypts or decrypts stdin to stdout for the archive, see crypt:
+//
+//	encrypt <key>     encrypts with a data key that is wrapped with key
+//	decrypt <key>     decrypts what was encrypted with key, and nothing else
+func cryptCommand(args []string) int {
+	var err error
+	switch {
+	case args[0] == "encrypt" && len(args) == 2:
+		err = crypt.Encrypt(args[1], os.Stdin, os.Stdout)
+	case args[0] == "decrypt" && len(args) == 2:
+		err = crypt.Decrypt(args[1], os.Stdin, os.Stdout)
+	default:
+		err = fmt.Errorf("%v needs the key the data key is wrapped with", args[0])
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v failed: %v\n", args[0], err)
+		return 1
+	}
+	return 0
+}
+
+// runs one of the archive and migration commands:
+//
+//	base-backup       takes a base backup of the running database and archives it
+//	restore <time>    restores the database into the empty data_dir, as it was at
+//	                  the given time (e.g. '2006-01-02 15:04:05')
+//	verify-backup     restores the last base backup into a scratch database and
+//	                  runs the verify_queries against it
+//	prune             removes the base backups and the WAL that fall outside of
+//	                  the retention of the archive
+//	clone <dir> [<host:port>]
+//	                  starts a disposable copy of the database in the empty dir on
+//	                  this host, of the database at host:port (e.g. a backup node)
+//	                  or of the last base backup and the archived WAL
+//	migrate           migrates the databases of the [migrate] source into the
+//	                  cluster, or reports how far they got
+//	cutover           makes the source read only and ends the migration once the
+//	                  cluster caught up with it
+func archiveCommand(args []string) error {
+	switch args[0] {
+	case "base-backup":
+		_, err := archive.BaseBackup(config.Conf)
+		return err
+	case "restore":
+		if len(args) != 2 {
+			return fmt.Errorf("restore needs the time to recover to")
+		}
+		target, err := time.ParseInLocation("2006-01-02 15:04:05", args[1], time.Local)
+		if err != nil {
+			return err
+		}
+		if err := archive.Restore(config.Conf, target); err != nil {
+			return err
+		}
+		config.Log.Info("the database will be recovered to %v the next time it starts", target)
+		return nil
+	case "prune":
+		return archive.Prune(config.Conf, time.Now())
+	case "clone":
+		if len(args) != 2 && len(args) != 3 {
+			return fmt.Errorf("clone needs the directory to clone into, and can take the database to clone")
+		}
+		from := ""
+		if len(args) == 3 {
+			from = args[2]
+		}
+		if err := archive.Clone(config.Conf, args[1], from); err != nil {
+			return err
+		}
+		config.Log.Info("the clone in '%v' is running on port %v", args[1], config.Conf.PGPort)
+		return nil
+	case "migrate":
+		progress, err := migrate.Start(config.Conf)
+		if err != nil {
+			return err
+		}
+		for _, each := range progress {
+			fmt.Println(each)
+		}
+		return nil
+	case "cutover":
+		if err := migrate.Cutover(config.Conf); err != nil {
+			return err
+		}
+		config.Log.Info("the migration is done, the clients can be pointed at the cluster")
+		return nil
+	case "verify-backup":
+		verification, err := archive.VerifyBackup(config.Conf)
+		if err != nil {
+			return err
+		}
+		for _, result := range verification.Results {
+			fmt.Println(result)
+		}
+		return nil
+	}
+	return fmt.Errorf("unknown command '%v'", args[0])
+}
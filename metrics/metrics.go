@@ -0,0 +1,240 @@
+// Copyright (c) 2015 Pagoda Box Inc
+//
+// This Source Code Form is subject to the terms of the Mozilla Public License, v.
+// 2.0. If a copy of the MPL was not distributed with this file, You can obtain one
+// at http://mozilla.org/MPL/2.0/.
+//
+
+// metrics keeps track of counters and gauges describing what a node is doing, and
+// exposes them in the prometheus text format or pushes them to a sink, like statsd.
+package metrics
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+type (
+	// Counter is a value that only ever goes up
+	Counter struct {
+		*metric
+	}
+
+	// Gauge is a value that can go up and down
+	Gauge struct {
+		*metric
+	}
+
+	// Timer sums up how long something took and counts how often it happened, it
+	// is written as a prometheus summary without quantiles
+	Timer struct {
+		*metric
+	}
+
+	metric struct {
+		sync.Mutex
+		name   string
+		help   string
+		kind   string
+		label  string
+		values map[string]float64
+		counts map[string]float64 // how many times each value of a timer was observed
+		fn     func() map[string]float64
+	}
+)
+
+var (
+	registryLock sync.Mutex
+	registry     = map[string]*metric{}
+)
+
+func register(name, help, kind, label string, fn func() map[string]float64) *metric {
+	m := &metric{
+		name:   name,
+		help:   help,
+		kind:   kind,
+		label:  label,
+		values: map[string]float64{},
+		counts: map[string]float64{},
+		fn:     fn,
+	}
+	registryLock.Lock()
+	defer registryLock.Unlock()
+	registry[name] = m
+	return m
+}
+
+// NewCounter creates a counter, label is the name of the label the values are
+// split by and may be left empty
+func NewCounter(name, help, label string) Counter {
+	return Counter{register(name, help, "counter", label, nil)}
+}
+
+// NewGauge creates a gauge, label is the name of the label the values are split
+// by and may be left empty
+func NewGauge(name, help, label string) Gauge {
+	return Gauge{register(name, help, "gauge", label, nil)}
+}
+
+// NewGaugeFunc creates a gauge whose values are collected by calling fn every time
+// the metrics are written. Registering a name a second time replaces the first.
+func NewGaugeFunc(name, help, label string, fn func() map[string]float64) {
+	register(name, help, "gauge", label, fn)
+}
+
+// NewTimer creates a timer, label is the name of the label the values are split
+// by and may be left empty
+func NewTimer(name, help, label string) Timer {
+	return Timer{register(name, help, "summary", label, nil)}
+}
+
+// Inc increments the counter for the given label value
+func (counter Counter) Inc(label string) {
+	counter.Add(label, 1)
+}
+
+// Add increases the counter for the given label value
+func (counter Counter) Add(label string, value float64) {
+	counter.Lock()
+	counter.values[label] += value
+	counter.Unlock()
+	count(counter.name, Tag{Name: counter.label, Value: label}, value)
+}
+
+// Set sets the value of the gauge for the given label value
+func (gauge Gauge) Set(label string, value float64) {
+	gauge.Lock()
+	gauge.values[label] = value
+	gauge.Unlock()
+	set(gauge.name, Tag{Name: gauge.label, Value: label}, value)
+}
+
+// Observe records how long something took, for the given label value
+func (timer Timer) Observe(label string, took time.Duration) {
+	timer.Lock()
+	timer.values[label] += took.Seconds()
+	timer.counts[label]++
+	timer.Unlock()
+	timing(timer.name, Tag{Name: timer.label, Value: label}, took)
+}
+
+// Values returns a copy of the current values of every registered metric, keyed
+// by metric name and then label value. The value of a timer is how many seconds
+// it summed up.
+func Values() map[string]map[string]float64 {
+	registryLock.Lock()
+	metrics := make([]*metric, 0, len(registry))
+	for _, m := range registry {
+		metrics = append(metrics, m)
+	}
+	registryLock.Unlock()
+
+	values := map[string]map[string]float64{}
+	for _, m := range metrics {
+		values[m.name] = m.collect()
+	}
+	return values
+}
+
+func (m *metric) collect() map[string]float64 {
+	if m.fn != nil {
+		return m.fn()
+	}
+	m.Lock()
+	defer m.Unlock()
+	return copyValues(m.values)
+}
+
+func (m *metric) collectCounts() map[string]float64 {
+	m.Lock()
+	defer m.Unlock()
+	return copyValues(m.counts)
+}
+
+func copyValues(values map[string]float64) map[string]float64 {
+	copied := make(map[string]float64, len(values))
+	for label, value := range values {
+		copied[label] = value
+	}
+	return copied
+}
+
+// Write writes every registered metric to out in the prometheus text format
+func Write(out io.Writer) error {
+	registryLock.Lock()
+	names := make([]string, 0, len(registry))
+	for name := range registry {
+		names = append(names, name)
+	}
+	metrics := make([]*metric, 0, len(registry))
+	sort.Strings(names)
+	for _, name := range names {
+		metrics = append(metrics, registry[name])
+	}
+	registryLock.Unlock()
+
+	for _, m := range metrics {
+		if _, err := fmt.Fprintf(out, "# HELP %s %s\n# TYPE %s %s\n", m.name, m.help, m.name, m.kind); err != nil {
+			return err
+		}
+
+		if m.kind == "summary" {
+			if err := m.write(out, "_sum", m.collect()); err != nil {
+				return err
+			}
+			if err := m.write(out, "_count", m.collectCounts()); err != nil {
+				return err
+			}
+			continue
+		}
+		if err := m.write(out, "", m.collect()); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// writes the values of the metric, suffix is added to its name
+func (m *metric) write(out io.Writer, suffix string, values map[string]float64) error {
+	labels := make([]string, 0, len(values))
+	for label := range values {
+		labels = append(labels, label)
+	}
+	sort.Strings(labels)
+	for _, label := range labels {
+		name := m.name + suffix
+		if m.label != "" {
+			name = fmt.Sprintf("%s{%s=%s}", name, m.label, strconv.Quote(label))
+		}
+		if _, err := fmt.Fprintf(out, "%s %s\n", name, format(values[label])); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func format(value float64) string {
+	switch {
+	case math.IsNaN(value):
+		return "NaN"
+	case math.IsInf(value, 1):
+		return "+Inf"
+	case math.IsInf(value, -1):
+		return "-Inf"
+	}
+	return strconv.FormatFloat(value, 'g', -1, 64)
+}
+
+// Handler serves the metrics in the prometheus text format
+func Handler() http.Handler {
+	return http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+		res.Header().Set("Content-Type", "text/plain; version=0.0.4")
+		Write(res)
+	})
+}
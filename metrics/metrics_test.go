@@ -0,0 +1,118 @@
+// Copyright (c) 2015 Pagoda Box Inc
+//
+// This Source Code Form is subject to the terms of the Mozilla Public License, v.
+// 2.0. If a copy of the MPL was not distributed with this file, You can obtain one
+// at http://mozilla.org/MPL/2.0/.
+//
+
+package metrics_test
+
+import (
+	"bytes"
+	"context"
+	"github.com/nanopack/yoke/metrics"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestWrite(test *testing.T) {
+	counter := metrics.NewCounter("test_total", "A test counter.", "role")
+	gauge := metrics.NewGauge("test_gauge", "A test gauge.", "")
+	metrics.NewGaugeFunc("test_func", "A test gauge func.", "peer", func() map[string]float64 {
+		return map[string]float64{"b": 2, "a": 1}
+	})
+
+	counter.Inc("active")
+	counter.Inc("active")
+	counter.Inc("backup")
+	gauge.Set("", 0.5)
+
+	out := &bytes.Buffer{}
+	if err := metrics.Write(out); err != nil {
+		test.Log(err)
+		test.FailNow()
+	}
+
+	expected := []string{
+		"# TYPE test_total counter\n",
+		"test_total{role=\"active\"} 2\n",
+		"test_total{role=\"backup\"} 1\n",
+		"# TYPE test_gauge gauge\n",
+		"test_gauge 0.5\n",
+		"test_func{peer=\"a\"} 1\ntest_func{peer=\"b\"} 2\n",
+	}
+	for _, line := range expected {
+		if !strings.Contains(out.String(), line) {
+			test.Logf("missing '%v' in:\n%v", line, out.String())
+			test.Fail()
+		}
+	}
+
+	if metrics.Values()["test_total"]["active"] != 2 {
+		test.Log("wrong value was returned")
+		test.Fail()
+	}
+}
+
+func TestTimer(test *testing.T) {
+	timer := metrics.NewTimer("test_seconds", "A test timer.", "")
+	timer.Observe("", time.Second)
+	timer.Observe("", 500*time.Millisecond)
+
+	out := &bytes.Buffer{}
+	if err := metrics.Write(out); err != nil {
+		test.Log(err)
+		test.FailNow()
+	}
+	for _, line := range []string{"# TYPE test_seconds summary\n", "test_seconds_sum 1.5\n", "test_seconds_count 2\n"} {
+		if !strings.Contains(out.String(), line) {
+			test.Logf("missing '%v' in:\n%v", line, out.String())
+			test.Fail()
+		}
+	}
+}
+
+func TestStatsd(test *testing.T) {
+	agent, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		test.Log(err)
+		test.FailNow()
+	}
+	defer agent.Close()
+	received := func() string {
+		agent.SetReadDeadline(time.Now().Add(time.Second))
+		buffer := make([]byte, 512)
+		n, _, err := agent.ReadFrom(buffer)
+		if err != nil {
+			test.Log(err)
+			test.FailNow()
+		}
+		return string(buffer[:n])
+	}
+
+	for _, datadog := range []bool{false, true} {
+		sink, err := metrics.NewStatsd(agent.LocalAddr().String(), datadog)
+		if err != nil {
+			test.Log(err)
+			test.FailNow()
+		}
+		ctx, cancel := context.WithCancel(context.Background())
+		go metrics.Push(ctx, sink, time.Hour)
+		// the sink is added by the push
+		time.Sleep(10 * time.Millisecond)
+
+		metrics.NewCounter("statsd_total", "A test counter.", "peer").Inc("10.0.0.2:4400")
+		expected := "statsd_total.10_0_0_2_4400:1|c"
+		if datadog {
+			expected = "statsd_total:1|c|#peer:10.0.0.2:4400"
+		}
+		if line := received(); line != expected {
+			test.Logf("expected '%v', not '%v'", expected, line)
+			test.Fail()
+		}
+		cancel()
+		sink.Close()
+	}
+}
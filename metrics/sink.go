@@ -0,0 +1,108 @@
+// Copyright (c) 2015 Pagoda Box Inc
+//
+// This Source Code Form is subject to the terms of the Mozilla Public License, v.
+// 2.0. If a copy of the MPL was not distributed with this file, You can obtain one
+// at http://mozilla.org/MPL/2.0/.
+//
+
+package metrics
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+type (
+	// Sink is sent the metrics as they change, instead of having them scraped.
+	// Counters are sent what they were increased by, timers every time they were
+	// observed, and gauges their values every interval of the push.
+	Sink interface {
+		Count(name string, tag Tag, delta float64)
+		Gauge(name string, tag Tag, value float64)
+		Timing(name string, tag Tag, took time.Duration)
+	}
+
+	// Tag is the label of a value, it is empty for a metric without a label
+	Tag struct {
+		Name  string
+		Value string
+	}
+)
+
+var (
+	sinksLock sync.RWMutex
+	sinks     = map[int]Sink{}
+	nextSink  int
+)
+
+// Push sends the metrics to sink until ctx is done, the gauges every interval
+func Push(ctx context.Context, sink Sink, interval time.Duration) {
+	sinksLock.Lock()
+	id := nextSink
+	nextSink++
+	sinks[id] = sink
+	sinksLock.Unlock()
+	defer func() {
+		sinksLock.Lock()
+		delete(sinks, id)
+		sinksLock.Unlock()
+	}()
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			pushGauges(sink)
+		}
+	}
+}
+
+func pushGauges(sink Sink) {
+	registryLock.Lock()
+	gauges := []*metric{}
+	for _, m := range registry {
+		if m.kind == "gauge" {
+			gauges = append(gauges, m)
+		}
+	}
+	registryLock.Unlock()
+
+	for _, m := range gauges {
+		for label, value := range m.collect() {
+			sink.Gauge(m.name, Tag{Name: m.label, Value: label}, value)
+		}
+	}
+}
+
+func current() []Sink {
+	sinksLock.RLock()
+	defer sinksLock.RUnlock()
+	current := make([]Sink, 0, len(sinks))
+	for _, sink := range sinks {
+		current = append(current, sink)
+	}
+	return current
+}
+
+func count(name string, tag Tag, delta float64) {
+	for _, sink := range current() {
+		sink.Count(name, tag, delta)
+	}
+}
+
+// gauges are pushed every interval, a gauge that was set is sent right away too
+func set(name string, tag Tag, value float64) {
+	for _, sink := range current() {
+		sink.Gauge(name, tag, value)
+	}
+}
+
+func timing(name string, tag Tag, took time.Duration) {
+	for _, sink := range current() {
+		sink.Timing(name, tag, took)
+	}
+}
@@ -0,0 +1,73 @@
+// Copyright (c) 2015 Pagoda Box Inc
+//
+// This Source Code Form is subject to the terms of the Mozilla Public License, v.
+// 2.0. If a copy of the MPL was not distributed with this file, You can obtain one
+// at http://mozilla.org/MPL/2.0/.
+//
+
+package metrics
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Statsd is a sink that sends the metrics to a statsd agent over udp. Plain statsd
+// has no tags, so the label value is put at the end of the name of the metric, the
+// dogstatsd format of datadog sends it as a tag.
+type Statsd struct {
+	conn    net.Conn
+	datadog bool
+}
+
+// the characters that mean something in the statsd line format, in a name and in
+// a dogstatsd tag
+var (
+	unsafeName = strings.NewReplacer(":", "_", "|", "_", "@", "_", "#", "_", ",", "_", ".", "_", " ", "_")
+	unsafeTag  = strings.NewReplacer("|", "_", "#", "_", ",", "_", " ", "_")
+)
+
+// NewStatsd creates a sink for the statsd agent at address, datadog sends the
+// labels as dogstatsd tags
+func NewStatsd(address string, datadog bool) (*Statsd, error) {
+	conn, err := net.Dial("udp", address)
+	if err != nil {
+		return nil, err
+	}
+	return &Statsd{conn: conn, datadog: datadog}, nil
+}
+
+func (statsd *Statsd) Count(name string, tag Tag, delta float64) {
+	statsd.send(name, tag, strconv.FormatFloat(delta, 'f', -1, 64), "c")
+}
+
+func (statsd *Statsd) Gauge(name string, tag Tag, value float64) {
+	statsd.send(name, tag, strconv.FormatFloat(value, 'f', -1, 64), "g")
+}
+
+func (statsd *Statsd) Timing(name string, tag Tag, took time.Duration) {
+	statsd.send(name, tag, strconv.FormatFloat(took.Seconds()*1000, 'f', -1, 64), "ms")
+}
+
+// Close closes the connection to the agent
+func (statsd *Statsd) Close() error {
+	return statsd.conn.Close()
+}
+
+// a lost line is only a gap in a graph, so the error is not reported
+func (statsd *Statsd) send(name string, tag Tag, value, kind string) {
+	statsd.conn.Write([]byte(statsd.line(name, tag, value, kind)))
+}
+
+func (statsd *Statsd) line(name string, tag Tag, value, kind string) string {
+	switch {
+	case tag.Name == "":
+		return fmt.Sprintf("%s:%s|%s", name, value, kind)
+	case statsd.datadog:
+		return fmt.Sprintf("%s:%s|%s|#%s:%s", name, value, kind, tag.Name, unsafeTag.Replace(tag.Value))
+	}
+	return fmt.Sprintf("%s.%s:%s|%s", name, unsafeName.Replace(tag.Value), value, kind)
+}
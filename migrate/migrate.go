@@ -0,0 +1,450 @@
+// Copyright (c) 2015 Pagoda Box Inc
+//
+// This Source Code Form is subject to the terms of the Mozilla Public License, v.
+// 2.0. If a copy of the MPL was not distributed with this file, You can obtain one
+// at http://mozilla.org/MPL/2.0/.
+//
+
+// migrate moves the databases of a postgres that yoke does not manage into the
+// cluster with logical replication, while the clients keep using the old one. The
+// roles and the schema are copied over first, then the active node subscribes to
+// a publication of every table of the source, which copies the data and streams
+// every change after. At the cutover the source is made read only, the cluster
+// catches up with the last of its changes and takes over the sequences, and the
+// clients can be pointed at the cluster. The subscription lives in the database of
+// the active node, so a backup that takes over carries on with it.
+package migrate
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"github.com/lib/pq"
+	"github.com/nanopack/yoke/config"
+	"os/exec"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// Name is the name of the publication on the source and of the subscriptions in
+// the cluster
+const Name = "yoke_migration"
+
+var (
+	NoSource     = errors.New("there is no [migrate] source configured")
+	NotActive    = errors.New("the migration only runs on the active node")
+	NotMigrating = errors.New("no database is being migrated")
+)
+
+// Progress is how far the migration of a database got
+type Progress struct {
+	Database string
+	Tables   int   // the tables that are migrated
+	Ready    int   // the tables that were copied and get every change streamed
+	Lag      int64 // bytes of WAL of the source the cluster did not apply yet
+}
+
+// Caught checks if every table was copied and the cluster has every change of
+// the source
+func (progress Progress) Caught() bool {
+	return progress.Ready == progress.Tables && progress.Lag <= 0
+}
+
+func (progress Progress) String() string {
+	return fmt.Sprintf("%v: %v of %v tables ready, %v bytes behind", progress.Database, progress.Ready, progress.Tables, progress.Lag)
+}
+
+// Start sets up the migration of the databases of the source that are not being
+// migrated yet, and returns the progress of all of them
+func Start(conf config.Config) ([]Progress, error) {
+	databases, err := databases(conf)
+	if err != nil {
+		return nil, err
+	}
+	if err := active(conf); err != nil {
+		return nil, err
+	}
+
+	rolesCopied := false
+	for _, database := range databases {
+		migrating, err := subscribed(conf, database)
+		if err != nil {
+			return nil, err
+		}
+		if migrating {
+			continue
+		}
+		if !rolesCopied {
+			if err := copyRoles(conf); err != nil {
+				return nil, err
+			}
+			rolesCopied = true
+		}
+		if err := subscribe(conf, database); err != nil {
+			return nil, err
+		}
+	}
+	return Status(conf)
+}
+
+// Status returns how far the migration of every database got
+func Status(conf config.Config) ([]Progress, error) {
+	databases, err := databases(conf)
+	if err != nil {
+		return nil, err
+	}
+	progress := []Progress{}
+	for _, database := range databases {
+		migrating, err := subscribed(conf, database)
+		if err != nil {
+			return nil, err
+		}
+		if !migrating {
+			continue
+		}
+		each, err := status(conf, database)
+		if err != nil {
+			return nil, err
+		}
+		progress = append(progress, each)
+	}
+	if len(progress) == 0 {
+		return nil, NotMigrating
+	}
+	return progress, nil
+}
+
+// Cutover makes the source read only, waits for the cluster to catch up with the
+// last of its changes, takes over its sequences and ends the migration. The
+// clients can't write from the moment the source is read only until they are
+// pointed at the cluster. The source is left read only.
+func Cutover(conf config.Config) error {
+	progress, err := Status(conf)
+	if err != nil {
+		return err
+	}
+	for _, each := range progress {
+		if each.Ready != each.Tables {
+			return fmt.Errorf("the tables of '%v' are still being copied, %v of %v are ready", each.Database, each.Ready, each.Tables)
+		}
+	}
+
+	for _, each := range progress {
+		config.Log.Info("[migrate] making '%v' read only on the source", each.Database)
+		if err := readOnly(conf, each.Database); err != nil {
+			return err
+		}
+	}
+
+	timeout := time.Duration(conf.MigrateTimeout) * time.Second
+	deadline := time.Now().Add(timeout)
+	for _, each := range progress {
+		for !each.Caught() {
+			if time.Now().After(deadline) {
+				return fmt.Errorf("'%v' did not catch up within %v, %v bytes are left", each.Database, timeout, each.Lag)
+			}
+			<-time.After(time.Second)
+			if each, err = status(conf, each.Database); err != nil {
+				return err
+			}
+		}
+	}
+
+	for _, each := range progress {
+		if err := copySequences(conf, each.Database); err != nil {
+			return err
+		}
+		if err := unsubscribe(conf, each.Database); err != nil {
+			return err
+		}
+		config.Log.Info("[migrate] '%v' was migrated", each.Database)
+	}
+	return nil
+}
+
+// databases are the databases of the source that are migrated, all of them when
+// none were configured
+func databases(conf config.Config) ([]string, error) {
+	if conf.MigrateSource == "" {
+		return nil, NoSource
+	}
+	if conf.MigrateDatabases != "" {
+		databases := []string{}
+		for _, database := range strings.Split(conf.MigrateDatabases, ",") {
+			databases = append(databases, strings.TrimSpace(database))
+		}
+		return databases, nil
+	}
+
+	db, err := openSource(conf, "postgres")
+	if err != nil {
+		return nil, err
+	}
+	defer db.Close()
+	rows, err := db.Query("select datname from pg_database where not datistemplate and datallowconn order by datname")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	databases := []string{}
+	for rows.Next() {
+		var database string
+		if err := rows.Scan(&database); err != nil {
+			return nil, err
+		}
+		databases = append(databases, database)
+	}
+	return databases, rows.Err()
+}
+
+// active makes sure this is the node that takes writes, the subscriptions can't be
+// created anywhere else
+func active(conf config.Config) error {
+	db, err := openLocal(conf, "postgres")
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+	var recovering bool
+	if err := db.QueryRow("select pg_is_in_recovery()").Scan(&recovering); err != nil {
+		return err
+	}
+	if recovering {
+		return NotActive
+	}
+	return nil
+}
+
+// subscribed checks if the database is being migrated
+func subscribed(conf config.Config, database string) (bool, error) {
+	db, err := openLocal(conf, "postgres")
+	if err != nil {
+		return false, err
+	}
+	defer db.Close()
+	var count int
+	err = db.QueryRow("select count(*) from pg_subscription s join pg_database d on d.oid = s.subdbid where s.subname = $1 and d.datname = $2", Name, database).Scan(&count)
+	return count != 0, err
+}
+
+// copyRoles creates the roles of the source in the cluster, the ones that exist
+// already are left as they are
+func copyRoles(conf config.Config) error {
+	config.Log.Info("[migrate] copying the roles of the source")
+	roles, err := exec.Command("pg_dumpall", "--roles-only", "-d", conf.MigrateSource).Output()
+	if err != nil {
+		return fmt.Errorf("pg_dumpall failed: %v", err)
+	}
+	// the roles that exist already fail to be created
+	return psql(conf, "postgres", roles, false)
+}
+
+// subscribe copies the schema of the database over and subscribes the cluster to
+// every change of its tables
+func subscribe(conf config.Config, database string) error {
+	source, err := openSource(conf, database)
+	if err != nil {
+		return err
+	}
+	defer source.Close()
+	var level string
+	if err := source.QueryRow("show wal_level").Scan(&level); err != nil {
+		return err
+	}
+	if level != "logical" {
+		return fmt.Errorf("the source needs wal_level=logical to be migrated, it has '%v'", level)
+	}
+
+	local, err := openLocal(conf, "postgres")
+	if err != nil {
+		return err
+	}
+	defer local.Close()
+	var exists int
+	if err := local.QueryRow("select count(*) from pg_database where datname = $1", database).Scan(&exists); err != nil {
+		return err
+	}
+	if exists == 0 {
+		if _, err := local.Exec("create database " + pq.QuoteIdentifier(database)); err != nil {
+			return err
+		}
+	}
+
+	config.Log.Info("[migrate] copying the schema of '%v'", database)
+	schema, err := exec.Command("pg_dump", "--schema-only", "--no-publications", "--no-subscriptions", "-d", sourceConninfo(conf, database)).Output()
+	if err != nil {
+		return fmt.Errorf("pg_dump of '%v' failed: %v", database, err)
+	}
+	if err := psql(conf, database, schema, true); err != nil {
+		return err
+	}
+
+	if err := source.QueryRow("select count(*) from pg_publication where pubname = $1", Name).Scan(&exists); err != nil {
+		return err
+	}
+	if exists == 0 {
+		if _, err := source.Exec("create publication " + Name + " for all tables"); err != nil {
+			return err
+		}
+	}
+
+	slot, err := slotName(source)
+	if err != nil {
+		return err
+	}
+	db, err := openLocal(conf, database)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+	config.Log.Info("[migrate] subscribing '%v' to the source", database)
+	_, err = db.Exec(fmt.Sprintf("create subscription %s connection %s publication %s with (slot_name = %s)",
+		Name, pq.QuoteLiteral(sourceConninfo(conf, database)), Name, pq.QuoteIdentifier(slot)))
+	return err
+}
+
+// status is how far the migration of the database got
+func status(conf config.Config, database string) (Progress, error) {
+	progress := Progress{Database: database}
+	db, err := openLocal(conf, database)
+	if err != nil {
+		return progress, err
+	}
+	defer db.Close()
+	err = db.QueryRow("select count(*), count(*) filter (where r.srsubstate = 'r') from pg_subscription_rel r join pg_subscription s on s.oid = r.srsubid where s.subname = $1", Name).Scan(&progress.Tables, &progress.Ready)
+	if err != nil {
+		return progress, err
+	}
+
+	source, err := openSource(conf, database)
+	if err != nil {
+		return progress, err
+	}
+	defer source.Close()
+	slot, err := slotName(source)
+	if err != nil {
+		return progress, err
+	}
+	err = source.QueryRow("select coalesce(pg_wal_lsn_diff(pg_current_wal_lsn(), confirmed_flush_lsn), -1)::bigint from pg_replication_slots where slot_name = $1", slot).Scan(&progress.Lag)
+	if err == sql.ErrNoRows {
+		return progress, fmt.Errorf("the source has no replication slot '%v' for '%v'", slot, database)
+	}
+	return progress, err
+}
+
+// readOnly has the source refuse every write to the database, and disconnects the
+// clients that are connected to it so they reconnect read only
+func readOnly(conf config.Config, database string) error {
+	db, err := openSource(conf, "postgres")
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+	if _, err := db.Exec("alter database " + pq.QuoteIdentifier(database) + " set default_transaction_read_only = on"); err != nil {
+		return err
+	}
+	_, err = db.Exec("select pg_terminate_backend(pid) from pg_stat_activity where datname = $1 and backend_type = 'client backend' and pid <> pg_backend_pid()", database)
+	return err
+}
+
+// copySequences sets the sequences of the database in the cluster to where they are
+// on the source, logical replication leaves them alone
+func copySequences(conf config.Config, database string) error {
+	source, err := openSource(conf, database)
+	if err != nil {
+		return err
+	}
+	defer source.Close()
+	rows, err := source.Query("select schemaname, sequencename, last_value from pg_sequences where last_value is not null")
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	db, err := openLocal(conf, database)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+	for rows.Next() {
+		var schema, sequence string
+		var value int64
+		if err := rows.Scan(&schema, &sequence, &value); err != nil {
+			return err
+		}
+		if _, err := db.Exec("select setval(format('%I.%I', $1::text, $2::text), $3)", schema, sequence, value); err != nil {
+			return err
+		}
+	}
+	return rows.Err()
+}
+
+// unsubscribe ends the migration of the database, dropping the subscription drops
+// its replication slot on the source
+func unsubscribe(conf config.Config, database string) error {
+	db, err := openLocal(conf, database)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+	if _, err := db.Exec("drop subscription " + Name); err != nil {
+		return err
+	}
+
+	source, err := openSource(conf, database)
+	if err != nil {
+		return err
+	}
+	defer source.Close()
+	_, err = source.Exec("drop publication if exists " + Name)
+	return err
+}
+
+// the replication slot names are unique on the source, the database names don't
+// have to fit into one
+var unslotted = regexp.MustCompile(`[^a-z0-9_]`)
+
+// slotName is the name of the replication slot of the database source is
+// connected to
+func slotName(source *sql.DB) (string, error) {
+	var oid int64
+	if err := source.QueryRow("select oid from pg_database where datname = current_database()").Scan(&oid); err != nil {
+		return "", err
+	}
+	return unslotted.ReplaceAllString(fmt.Sprintf("%s_%d", Name, oid), "_"), nil
+}
+
+// psql runs the sql on the database of the local postgres, and stops at the first
+// error when it is told to
+func psql(conf config.Config, database string, script []byte, stopOnError bool) error {
+	args := []string{"-h", "localhost", "-p", fmt.Sprintf("%d", conf.PGPort), "-U", conf.SystemUser, "-d", database, "-X", "-q"}
+	if stopOnError {
+		args = append(args, "-v", "ON_ERROR_STOP=1")
+	}
+	cmd := exec.Command("psql", args...)
+	cmd.Stdin = strings.NewReader(string(script))
+	out, err := cmd.CombinedOutput()
+	if err != nil && stopOnError {
+		return fmt.Errorf("psql failed: %v %s", err, strings.TrimSpace(string(out)))
+	}
+	return nil
+}
+
+// the conninfo of the database on the source
+func sourceConninfo(conf config.Config, database string) string {
+	return conf.MigrateSource + " dbname=" + quoteConninfo(database)
+}
+
+func openSource(conf config.Config, database string) (*sql.DB, error) {
+	return sql.Open("postgres", sourceConninfo(conf, database))
+}
+
+// opens a connection to the local postgres as the system user, like the performer
+func openLocal(conf config.Config, database string) (*sql.DB, error) {
+	return sql.Open("postgres", fmt.Sprintf("user=%s dbname=%s sslmode=disable host=localhost port=%d", conf.SystemUser, quoteConninfo(database), conf.PGPort))
+}
+
+func quoteConninfo(value string) string {
+	return "'" + strings.NewReplacer(`\`, `\\`, `'`, `\'`).Replace(value) + "'"
+}
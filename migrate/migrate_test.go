@@ -0,0 +1,43 @@
+// Copyright (c) 2015 Pagoda Box Inc
+//
+// This Source Code Form is subject to the terms of the Mozilla Public License, v.
+// 2.0. If a copy of the MPL was not distributed with this file, You can obtain one
+// at http://mozilla.org/MPL/2.0/.
+//
+
+package migrate_test
+
+import (
+	"github.com/nanopack/yoke/config"
+	"github.com/nanopack/yoke/migrate"
+	"testing"
+)
+
+func TestProgress(test *testing.T) {
+	for _, progress := range []struct {
+		progress migrate.Progress
+		caught   bool
+	}{
+		{migrate.Progress{Tables: 3, Ready: 3, Lag: 0}, true},
+		{migrate.Progress{Tables: 3, Ready: 2, Lag: 0}, false},
+		{migrate.Progress{Tables: 3, Ready: 3, Lag: 8192}, false},
+		// nothing to migrate yet
+		{migrate.Progress{Tables: 0, Ready: 0, Lag: 0}, true},
+	} {
+		if progress.progress.Caught() != progress.caught {
+			test.Logf("%v should have caught up %v", progress.progress, progress.caught)
+			test.Fail()
+		}
+	}
+
+	conf := config.Defaults
+	conf.MigrateSource = ""
+	if _, err := migrate.Start(conf); err != migrate.NoSource {
+		test.Logf("there is nothing to migrate without a source (%v)", err)
+		test.Fail()
+	}
+	if err := migrate.Cutover(conf); err != migrate.NoSource {
+		test.Logf("there is nothing to cut over without a source (%v)", err)
+		test.Fail()
+	}
+}
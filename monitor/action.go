@@ -9,24 +9,30 @@ package monitor
 
 import (
 	"bufio"
+	"context"
 	"database/sql"
 	"errors"
 	"fmt"
 	"github.com/hoisie/mustache"
 	_ "github.com/lib/pq"
 	"github.com/nanopack/yoke/config"
+	"github.com/nanopack/yoke/events"
 	"github.com/nanopack/yoke/state"
+	"github.com/nanopack/yoke/vip"
 	"io"
 	"net"
 	"os"
 	"os/exec"
+	"strconv"
 	"sync"
-	"syscall"
 	"time"
 )
 
 var (
-	Done = errors.New("done")
+	Done     = errors.New("done")
+	NoSource = errors.New("there is no active node to replicate from")
+	// backups must transition to single before they can become active
+	BackupActive = errors.New("a backup can not transition to active")
 )
 
 type (
@@ -35,20 +41,45 @@ type (
 		TransitionToBackup()
 		TransitionToSingle()
 		Stop()
+		Position() (uint64, error)
+		ReplayDelay() (time.Duration, error)
 		Initialize() error
 		Start() error
 		Loop() error
 	}
 
+	// the database specific half of a transition, the performer itself drives
+	// postgres
+	database interface {
+		Single() error
+		Active() error
+		Backup() error
+		stop() error
+		// rotate has a backup replicate with the replication password that was
+		// just taken over
+		rotate() error
+	}
+
 	performer struct {
 		sync.Mutex
-		step   map[string]bool
-		me     state.State
-		other  state.State
-		err    chan error
-		done   chan interface{}
-		cmd    *exec.Cmd
-		config config.Config
+		step     map[string]bool
+		me       state.State
+		others   []state.State
+		err      chan error
+		done     chan interface{}
+		cmd      *exec.Cmd
+		vip      vip.VIP
+		strategy syncStrategy
+		hooks    []Hook
+		sources  []state.State // the nodes of the cluster this is the DR cluster of
+		database database
+		version  config.PGVersion // of the running postgres, 0 until it was asked
+		progress progress         // the copy of the data that is running
+		config   config.Config
+		log      config.Logger
+
+		compressOnce sync.Once
+		compressions []string // the rsync of the node can take, asked once
 	}
 )
 
@@ -63,33 +94,52 @@ func NewPrefix(prefix string) io.Writer {
 	return write
 }
 
-func NewPerformer(me state.State, other state.State, config config.Config) *performer {
+func NewPerformer(me state.State, others []state.State, floating vip.VIP, config config.Config) *performer {
 	perform := performer{
 		config: config,
+		log:    config.Logging(),
+		vip:    floating,
 		step: map[string]bool{
 			"trigger": true, // this should only be there if the trigger file exists
 		},
-		me:    me,
-		other: other,
-		err:   make(chan error),
-		done:  make(chan interface{}),
+		me:       me,
+		others:   others,
+		strategy: newSyncStrategy(config),
+		hooks:    newHooks(config),
+		sources:  newSources(config),
+		err:      make(chan error),
+		done:     make(chan interface{}),
 	}
+	perform.database = &perform
 
 	return &perform
 }
 
 func (performer *performer) Loop() error {
-	config.Log.Info("Waiting for error")
+	performer.log.Info("Waiting for error")
 	return <-performer.err
 }
 
 func (performer *performer) Stop() {
-	config.Log.Info("going to stop")
+	performer.log.Info("going to stop")
 	performer.Lock()
 	defer performer.Unlock()
-	config.Log.Info("stopping")
-	performer.stop()
-	config.Log.Info("stopped")
+	performer.log.Info("stopping")
+
+	// stopping is what keeps the cluster safe, so a failing hook can't prevent it
+	from := ""
+	if len(performer.hooks) != 0 {
+		from, _ = performer.me.GetDBRole()
+		if err := performer.before("stop", from); err != nil {
+			performer.log.Error("[action] pre transition hook failed, stopping anyway (%v)", err)
+		}
+	}
+	err := performer.database.stop()
+	performer.removeVip()
+	transitions.Inc("stopped")
+	events.Publish(events.Event{Type: events.Stopped})
+	performer.after("stop", from, err)
+	performer.log.Info("stopped")
 }
 
 func (performer *performer) TransitionToSingle() {
@@ -106,11 +156,36 @@ func (performer *performer) TransitionToSingle() {
 	if role == "single" {
 		return
 	}
+	if err := performer.before("single", role); err != nil {
+		performer.log.Error("[action] pre transition hook failed, not going single (%v)", err)
+		events.Publish(events.Event{Type: events.TransitionFailed, DBRole: "single", Error: err.Error()})
+		return
+	}
+
+	// a backup that goes single is taking over from the active node, which has to
+	// be fenced off first so there are never two writable databases
+	if role == "backup" {
+		if err := performer.fence(); err != nil {
+			performer.log.Error("[action] fencing failed, not taking over (%v)", err)
+			events.Publish(events.Event{Type: events.TransitionFailed, DBRole: "single", Error: err.Error()})
+			performer.after("single", role, err)
+			return
+		}
+	}
 
-	err = performer.Single()
+	events.Publish(events.Event{Type: events.SingleStarted, DBRole: role})
+	err = performer.database.Single()
+	performer.after("single", role, err)
 	if err != nil {
+		events.Publish(events.Event{Type: events.TransitionFailed, DBRole: "single", Error: err.Error()})
 		performer.err <- err
+		return
 	}
+	if role == "active" {
+		// the active node only goes single when it has no backup left
+		events.Publish(events.Event{Type: events.SyncLost, DBRole: "single"})
+	}
+	events.Publish(events.Event{Type: events.SingleCompleted, DBRole: "single"})
 }
 
 func (performer *performer) TransitionToActive() {
@@ -126,14 +201,25 @@ func (performer *performer) TransitionToActive() {
 	case "active":
 		return
 	case "backup":
-		// backups must transition to single before they can become active.
-		panic("something went seriously wrong, backups cannot transition to active.")
+		events.Publish(events.Event{Type: events.TransitionFailed, DBRole: "active", Error: BackupActive.Error()})
+		performer.err <- BackupActive
+		return
+	}
+	if err := performer.before("active", role); err != nil {
+		performer.log.Error("[action] pre transition hook failed, not going active (%v)", err)
+		events.Publish(events.Event{Type: events.TransitionFailed, DBRole: "active", Error: err.Error()})
+		return
 	}
 
-	err = performer.Active()
+	events.Publish(events.Event{Type: events.PromotionStarted, DBRole: role})
+	err = performer.database.Active()
+	performer.after("active", role, err)
 	if err != nil {
+		events.Publish(events.Event{Type: events.TransitionFailed, DBRole: "active", Error: err.Error()})
 		performer.err <- err
+		return
 	}
+	events.Publish(events.Event{Type: events.PromotionCompleted, DBRole: "active"})
 }
 
 func (performer *performer) TransitionToBackup() {
@@ -148,17 +234,27 @@ func (performer *performer) TransitionToBackup() {
 	if role == "backup" {
 		return
 	}
+	if err := performer.before("backup", role); err != nil {
+		performer.log.Error("[action] pre transition hook failed, not going backup (%v)", err)
+		events.Publish(events.Event{Type: events.TransitionFailed, DBRole: "backup", Error: err.Error()})
+		return
+	}
 
-	err = performer.Backup()
+	events.Publish(events.Event{Type: events.DemotionStarted, DBRole: role})
+	err = performer.database.Backup()
+	performer.after("backup", role, err)
 	if err != nil {
+		events.Publish(events.Event{Type: events.TransitionFailed, DBRole: "backup", Error: err.Error()})
 		performer.err <- err
+		return
 	}
+	events.Publish(events.Event{Type: events.DemotionCompleted, DBRole: "backup"})
 }
 
 func (performer *performer) stop() error {
 	if performer.step["started"] {
 		fmt.Println("sending signal")
-		err := performer.cmd.Process.Signal(syscall.SIGINT)
+		err := interrupt(performer.cmd, performer.config)
 		if err != nil {
 			return err
 		}
@@ -174,7 +270,7 @@ func (performer *performer) Initialize() error {
 	_, err := os.Stat(performer.config.DataDir)
 	switch {
 	case os.IsNotExist(err):
-		config.Log.Info("creating database")
+		performer.log.Info("creating database")
 		init := exec.Command("initdb", performer.config.DataDir)
 		init.Stdout = NewPrefix("[initdb.stdout]")
 		init.Stderr = NewPrefix("[initdb.stderr]")
@@ -182,47 +278,76 @@ func (performer *performer) Initialize() error {
 			return err
 		}
 	default:
-		config.Log.Info("database has already been created... skipping.")
+		performer.log.Info("database has already been created... skipping.")
 	}
 	return err
 }
 
 func (performer *performer) Start() error {
-	config.Log.Info("going to start")
+	performer.log.Info("going to start")
 	performer.Lock()
 	defer performer.Unlock()
-	config.Log.Info("starting")
-	return performer.startDB()
+	performer.log.Info("starting")
+	if err := performer.startDB(); err != nil {
+		return err
+	}
+	if err := performer.detectVersion(); err != nil {
+		return err
+	}
+	// a database that was made read only while the node was alone stays that way
+	// through a restart
+	if err := performer.readOnly(false); err != nil {
+		performer.log.Warn("[action] could not make sure the database takes writes (%v)", err)
+	}
+	return nil
 }
 
 // The Single state.
 func (performer *performer) Single() error {
-	config.Log.Info("transitioning to Single")
+	performer.log.Info("transitioning to Single")
 
-	// disable syncronus transaction commits.
-	if err := performer.setSync(false, nil); err != nil {
-		return err
+	if performer.tracking() {
+		if err := performer.track(); err != nil {
+			return err
+		}
+	} else {
+		// disable syncronus transaction commits.
+		if err := performer.setSync(false, nil); err != nil {
+			return err
+		}
+
+		if err := performer.replicate(false); err != nil {
+			return err
+		}
 	}
 
-	if err := performer.replicate(false); err != nil {
+	// the first node of a cluster is single, it sets up the role the backups
+	// that join it replicate with
+	if err := performer.replicationRole(nil); err != nil {
+		return err
+	}
+	if err := performer.configureRole("single", nil); err != nil {
 		return err
 	}
 
-	config.Log.Info("[action] running DB as single")
+	performer.log.Info("[action] running DB as single")
 
 	performer.addVip()
 	performer.roleChangeCommand("single")
-	performer.me.SetDBRole("single")
+	if err := setDBRole(performer.log, performer.me, state.Single); err != nil {
+		return err
+	}
+	transitions.Inc("single")
 
 	return nil
 }
 
 func (performer *performer) sync(command string) error {
-	sc := exec.Command("bash", "-c", command)
-	sc.Stdout = NewPrefix("[pre-sync.stdout]")
+	sc := config.Shell(context.Background(), command)
+	sc.Stdout = performer.progress.watch(NewPrefix("[pre-sync.stdout]"))
 	sc.Stderr = NewPrefix("[pre-sync.stderr]")
-	config.Log.Info("[action] running pre-sync")
-	config.Log.Debug("[action] pre-sync command(%s)", command)
+	performer.log.Info("[action] running pre-sync")
+	performer.log.Debug("[action] pre-sync command(%s)", command)
 
 	return sc.Run()
 }
@@ -233,6 +358,20 @@ func (performer *performer) replicate(enabled bool) error {
 	}
 	performer.step["trigger"] = enabled
 
+	// postgres 12 and later are promoted by asking them to, and follow the node
+	// they stream from while their standby.signal is there
+	if performer.pgVersion().Promotion() == "pg_promote" {
+		if enabled {
+			return nil
+		}
+		if err := performer.pgPromote(); err != nil {
+			// the next transition tries again
+			performer.step["trigger"] = true
+			return err
+		}
+		return nil
+	}
+
 	trigger := performer.config.StatusDir + "/i-am-primary"
 	switch enabled {
 	case true:
@@ -249,9 +388,49 @@ func (performer *performer) replicate(enabled bool) error {
 	}
 }
 
+// pgPromote has a postgres that is streaming take writes, and waits for it to
+// finish the recovery
+func (performer *performer) pgPromote() error {
+	db, err := performer.pgConnect()
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+	var recovering bool
+	if err := db.QueryRow("select pg_is_in_recovery()").Scan(&recovering); err != nil || !recovering {
+		return err
+	}
+	performer.log.Info("[action] promoting the database")
+	var promoted bool
+	if err := db.QueryRow("select pg_promote(true, 60)").Scan(&promoted); err != nil {
+		return err
+	}
+	if !promoted {
+		return fmt.Errorf("the database was not promoted within 60 seconds")
+	}
+	return nil
+}
+
 func (performer *performer) pgConnect() (*sql.DB, error) {
 	fmt.Println("opening new connection to db")
-	return sql.Open("postgres", fmt.Sprintf("user=%s database=postgres sslmode=disable host=localhost port=%d", performer.config.SystemUser, performer.config.PGPort))
+	return openPostgres(performer.config)
+}
+
+// opens a connection to the local postgres as the system user
+func openPostgres(conf config.Config) (*sql.DB, error) {
+	return sql.Open("postgres", fmt.Sprintf("user=%s database=postgres sslmode=disable host=localhost port=%d", conf.SystemUser, conf.PGPort))
+}
+
+// ReloadPostgres has the local postgres read its config files again, for when
+// pg_hba.conf was rewritten while it runs
+func ReloadPostgres(conf config.Config) error {
+	db, err := openPostgres(conf)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+	_, err = db.Exec("select pg_reload_conf()")
+	return err
 }
 
 func (performer *performer) setSync(enabled bool, db *sql.DB) error {
@@ -263,139 +442,318 @@ func (performer *performer) setSync(enabled bool, db *sql.DB) error {
 		}
 		defer db.Close()
 	}
-	var sync string
+	switch performer.config.SyncMode {
+	case "off":
+		// durability is never traded for availability
+		enabled = false
+	case "strict":
+		// commits keep waiting for a backup even when none are left
+		enabled = true
+	}
+
+	var sync, standbys string
 	switch enabled {
 	case true:
 		sync = "on"
+		standbys = "*"
 	default:
 		sync = "off"
+		standbys = ""
 	}
+	performer.log.Info("[action] setting synchronous replication %v", sync)
 	_, err := db.Exec(fmt.Sprintf(
 		`BEGIN;
 SET LOCAL synchronous_commit=off;
 ALTER USER %v SET synchronous_commit=%v;
 COMMIT;`, performer.config.SystemUser, sync))
+	if err != nil {
+		return err
+	}
+
+	// without any standby names commits don't wait for a backup to confirm them,
+	// alter system can't be run inside of a transaction
+	if _, err := db.Exec(fmt.Sprintf("ALTER SYSTEM SET synchronous_standby_names = '%v'", standbys)); err != nil {
+		return err
+	}
+	_, err = db.Exec("select pg_reload_conf()")
 	return err
 
 }
 
 // The Active state.
 func (performer *performer) Active() error {
-	config.Log.Info("transitioning to Active")
-	if err := performer.replicate(false); err != nil {
+	performer.log.Info("transitioning to Active")
+	// the writable node of a DR cluster streams from the source cluster, it has
+	// no commits of its own to wait for the backups with
+	tracking := performer.tracking()
+	if tracking {
+		if err := performer.track(); err != nil {
+			return err
+		}
+	} else if err := performer.replicate(false); err != nil {
 		return err
 	}
 
-	// do an initial copy of files which might be corrupt because they are not consistant
-	// this will be fixed later. we do this now so that a majority of the data will make it across without
-	// having to pause the Durablility (ACID compliance) of postgres
-	config.Log.Debug("[action] pre-backup started")
-	dataDir, err := performer.other.GetDataDir()
+	db, err := performer.pgConnect()
 	if err != nil {
 		return err
 	}
-	location := performer.other.Location()
-	ip, _, err := net.SplitHostPort(location)
+	defer db.Close()
+
+	if err := performer.replicationRole(db); err != nil {
+		return err
+	}
+	if err := performer.configureRole("active", db); err != nil {
+		return err
+	}
+
+	pending := []state.State{}
+	streaming := []state.State{}
+	for _, other := range performer.others {
+		// a node that rewound itself onto this one, or pulled its own copy, is
+		// already replicating from it and does not need a copy of the data
+		if performer.streaming(db, other) {
+			performer.log.With(config.Fields{"peer": other.Location()}).Info("[action] '%v' is already streaming, skipping sync", other.Location())
+			streaming = append(streaming, other)
+			continue
+		}
+		pending = append(pending, other)
+	}
+
+	syncs, err := performer.strategy.push(performer, db, pending)
 	if err != nil {
 		return err
 	}
-	sync := mustache.Render(performer.config.SyncCommand, map[string]string{"local_dir": performer.config.DataDir, "slave_ip": ip, "slave_dir": dataDir})
 
-	if err := performer.sync(sync); err != nil {
+	// if we were unsucessfull at setting the sync flag on the other nodes
+	// then we need to start all over
+	synced := 0
+	for _, other := range append(syncs, streaming...) {
+		if other.SetSynced(true) == nil {
+			synced++
+		}
+	}
+	if synced == 0 {
+		// something went wrong, we are the master still, so lets wait for the slaves to reconnect
+		return nil
+	}
+
+	// enable syncronus transaction commits.
+	if !tracking {
+		if err := performer.setSync(true, db); err != nil {
+			return err
+		}
+	}
+
+	performer.addVip()
+	performer.roleChangeCommand("master")
+
+	if err := setDBRole(performer.log, performer.me, state.Active); err != nil {
 		return err
 	}
+	transitions.Inc("active")
+	return nil
+}
 
-	db, err := performer.pgConnect()
+// checks if other is connected to this node as a streaming replica
+func (performer *performer) streaming(db *sql.DB, other state.State) bool {
+	ip, _, err := net.SplitHostPort(other.Location())
 	if err != nil {
-		return err
+		return false
 	}
-	defer db.Close()
+	var count int
+	err = db.QueryRow("select count(*) from pg_stat_replication where client_addr = $1 and state = 'streaming'", ip).Scan(&count)
+	return err == nil && count != 0
+}
 
-	// this informs postgres to make the files on disk consistant for copying,
-	// all changes are kept in memory from this point on
-	_, err = db.Exec("select pg_start_backup('replication')")
+// rewind brings the data of a node that used to be writable back in line with the
+// node that is active now, so it can replicate from it without a full copy. The
+// database has to be stopped for this.
+func (performer *performer) rewind() error {
+	source, err := performer.writable()
+	if err != nil {
+		return err
+	}
+	ip, _, err := net.SplitHostPort(source.Location())
 	if err != nil {
 		return err
 	}
 
-	config.Log.Debug("[action] backup started")
-
-	if err := performer.sync(sync); err != nil {
-		// stop the backup, if it fails, there is nothing we can do so we return the original error
-		db.Exec("select pg_stop_backup()")
+	if err := performer.stop(); err != nil {
+		return err
+	}
 
-		// something went wrong, we are the master still, so lets wait for the slave to reconnect
-		return nil
+	performer.log.With(config.Fields{"peer": source.Location()}).Info("[action] rewinding onto '%v'", source.Location())
+	rewind := exec.Command("pg_rewind",
+		"--target-pgdata="+performer.config.DataDir,
+		fmt.Sprintf("--source-server=host=%s port=%d user=%s dbname=postgres", ip, performer.config.PGPort, performer.config.SystemUser))
+	rewind.Stdout = NewPrefix("[pg_rewind.stdout]")
+	rewind.Stderr = NewPrefix("[pg_rewind.stderr]")
+	if err := rewind.Run(); err != nil {
+		return err
 	}
 
-	// connect to DB and tell it to stop backup
-	if _, err = db.Exec("select pg_stop_backup()"); err != nil {
+	if err := performer.config.CreateRecovery(ip, performer.config.PGPort); err != nil {
 		return err
 	}
+	// the data is already in line with the active node, so there is nothing to
+	// wait for before starting to replicate
+	return performer.me.SetSynced(true)
+}
 
-	config.Log.Debug("[action] backup complete")
+// finds the node to stream from, the nearest node up the cascade of this node that
+// is a backup with a copy of the data, or the node running the writable database
+func (performer *performer) source() (state.State, error) {
+	if performer.config.Cascade != "" {
+		if upstream := performer.upstream(); upstream != nil {
+			return upstream, nil
+		}
+	}
+	return performer.writable()
+}
 
-	// if we were unsucessfull at setting the sync flag on the other node
-	// then we need to start all over
-	if performer.other.SetSynced(true) != nil {
-		return nil
+// finds the node that is running the writable database
+func (performer *performer) writable() (state.State, error) {
+	for _, other := range performer.others {
+		if role, err := other.GetDBRole(); err == nil && (role == "active" || role == "single") {
+			return other, nil
+		}
 	}
+	return nil, NoSource
+}
 
-	// enable syncronus transaction commits.
-	if err := performer.setSync(true, db); err != nil {
-		return err
+// builds the command that syncs the data from this node over to other
+func (performer *performer) syncCommand(other state.State) (string, error) {
+	return performer.renderSync(performer.config.SyncCommand, other, performer.config.SyncRateLimit, "")
+}
+
+// renders a sync command template for a copy over to other, filesFrom is the list
+// of files a stream of the copy sends
+func (performer *performer) renderSync(template string, other state.State, rateLimit int, filesFrom string) (string, error) {
+	dataDir, err := other.GetDataDir()
+	if err != nil {
+		return "", err
 	}
+	ip, _, err := net.SplitHostPort(other.Location())
+	if err != nil {
+		return "", err
+	}
+	return mustache.Render(template, map[string]string{
+		"local_dir":  performer.config.DataDir,
+		"slave_ip":   ip,
+		"slave_dir":  dataDir,
+		"rate_limit": strconv.Itoa(rateLimit),
+		"files_from": filesFrom,
+		"ssh":        performer.config.SSHCommand(),
+		"compress":   performer.syncCompression(other).RsyncArgs(),
+	}), nil
+}
 
-	performer.addVip()
-	performer.roleChangeCommand("master")
+// Position returns how far along the WAL stream the local database is. Backups
+// report what they have received, actives report what they have written.
+func (performer *performer) Position() (uint64, error) {
+	db, err := performer.pgConnect()
+	if err != nil {
+		return 0, err
+	}
+	defer db.Close()
 
-	performer.me.SetDBRole("active")
-	return nil
+	current, received, replayed := performer.pgVersion().LSNFunctions()
+	var location string
+	err = db.QueryRow(fmt.Sprintf(`select case when pg_is_in_recovery()
+  then coalesce(%s, %s, '0/0')::text
+  else %s::text end`, received, replayed, current)).Scan(&location)
+	if err != nil {
+		return 0, err
+	}
+	return parseLocation(location)
+}
+
+// ReplayDelay returns how far behind in time the local database is with replaying
+// what it received from the active node. Databases that are not replicating, or
+// that have replayed everything they received, are not behind at all.
+func (performer *performer) ReplayDelay() (time.Duration, error) {
+	db, err := performer.pgConnect()
+	if err != nil {
+		return 0, err
+	}
+	defer db.Close()
+
+	_, received, replayed := performer.pgVersion().LSNFunctions()
+	var seconds float64
+	err = db.QueryRow(fmt.Sprintf(`select case when not pg_is_in_recovery()
+  or %s = %s then 0
+  else coalesce(extract(epoch from now() - pg_last_xact_replay_timestamp()), 0) end`, received, replayed)).Scan(&seconds)
+	if err != nil {
+		return 0, err
+	}
+	return time.Duration(seconds * float64(time.Second)), nil
+}
+
+// converts a postgres xlog location ('16/B374D848') into a comparable number
+func parseLocation(location string) (uint64, error) {
+	var high, low uint32
+	if _, err := fmt.Sscanf(location, "%X/%X", &high, &low); err != nil {
+		return 0, err
+	}
+	return uint64(high)<<32 | uint64(low), nil
 }
 
 // The Backup state.
 func (performer *performer) Backup() error {
-	config.Log.Info("transitioning to Backup")
+	performer.log.Info("transitioning to Backup")
 	performer.removeVip()
 
-	// TODO figure out if the recover.conf file needs to be regenerated.
-
-	// wait for master server to be running
-	for {
-		ready, err := performer.me.HasSynced()
-		if err != nil {
-			return err
+	// a node that was writable has diverged from the new active node, rewinding
+	// is a lot faster than copying all of the data over again
+	if role, err := performer.me.GetDBRole(); err == nil && performer.config.Rewind {
+		// a node that was stopped or lost track of its role rewinds on what it was
+		// before it was restarted
+		if history, ok := performer.me.(historian); ok && (role == "dead" || role == "initialized") {
+			role = history.History().DBRole
 		}
-		if ready {
-			break
+		switch role {
+		case "active", "single", "demoted":
+			if err := performer.rewind(); err != nil {
+				performer.log.Info("[action] rewind failed, waiting for a full sync (%v)", err)
+			}
 		}
-		time.Sleep(time.Second)
 	}
 
-	config.Log.Debug("[action] starting database")
+	// TODO figure out if the recover.conf file needs to be regenerated.
+
+	if err := performer.strategy.pull(performer); err != nil {
+		return err
+	}
+
+	performer.log.Debug("[action] starting database")
 	performer.startDB()
+	if err := performer.configureRole("backup", nil); err != nil {
+		return err
+	}
 	performer.roleChangeCommand("backup")
-	return performer.me.SetDBRole("backup")
+	transitions.Inc("backup")
+	return setDBRole(performer.log, performer.me, state.Backup)
 }
 
 // this will kill the database that is running. reguardless of its current state
 func (performer *performer) killDB() {
-	config.Log.Debug("[action] KillingDB")
+	performer.log.Debug("[action] KillingDB")
 
 	if performer.cmd == nil {
-		config.Log.Debug("[action] nothing to kill")
+		performer.log.Debug("[action] nothing to kill")
 		return
 	}
 
-	err := performer.cmd.Process.Signal(syscall.SIGINT)
+	err := interrupt(performer.cmd, performer.config)
 	if err != nil {
-		config.Log.Error("[action] Kill Signal error: %s", err.Error())
+		performer.log.Error("[action] Kill Signal error: %s", err.Error())
 	}
 }
 
 func (performer *performer) startDB() error {
 	if !performer.step["started"] {
-		config.Log.Info("[action] starting db")
+		performer.log.Info("[action] starting db")
 		cmd := exec.Command("postgres", "-D", performer.config.DataDir)
 		cmd.Stdout = NewPrefix("[postgres.stdout]")
 		cmd.Stderr = NewPrefix("[postgres.stderr]")
@@ -404,7 +762,10 @@ func (performer *performer) startDB() error {
 			return err
 		}
 		performer.cmd = cmd
-		go performer.reportExit()
+		// the database can be stopped and started again, so every run needs its own
+		// done channel
+		performer.done = make(chan interface{})
+		go performer.reportExit(performer.done)
 
 		// wait for postgres to exit, or for it to start correctly
 		for {
@@ -419,13 +780,13 @@ func (performer *performer) startDB() error {
 			}
 			<-time.After(time.Second)
 		}
-		config.Log.Info("[action] db started")
+		performer.log.Info("[action] db started")
 		performer.step["started"] = true
 	}
 	return nil
 }
 
-func (performer *performer) reportExit() {
+func (performer *performer) reportExit(done chan interface{}) {
 	err := performer.cmd.Wait()
 	performer.cmd = nil
 	fmt.Println("it exited", err)
@@ -433,47 +794,71 @@ func (performer *performer) reportExit() {
 	if err != nil {
 		performer.err <- err
 	}
-	close(performer.done)
+	close(done)
 }
 
 func (performer *performer) roleChangeCommand(role string) {
 	if performer.config.RoleChangeCommand != "" {
-		rcc := exec.Command("bash", "-c", fmt.Sprintf("%s %s", performer.config.RoleChangeCommand, role))
+		rcc := config.Shell(context.Background(), fmt.Sprintf("%s %s", performer.config.RoleChangeCommand, role))
 		rcc.Stdout = NewPrefix("[RoleChangeCommand.stdout]")
 		rcc.Stderr = NewPrefix("[RoleChangeCommand.stderr]")
 		if err := rcc.Run(); err != nil {
-			config.Log.Error("[action] RoleChangeCommand failed.")
-			config.Log.Debug("[RoleChangeCommand.error] message: %s", err.Error())
+			performer.log.Error("[action] RoleChangeCommand failed.")
+			performer.log.Debug("[RoleChangeCommand.error] message: %s", err.Error())
 		}
 	}
 }
 
-func (performer *performer) addVip() {
-	if performer.vipable() {
-		config.Log.Info("[action] Adding VIP")
-		vAddCmd := exec.Command("bash", "-c", fmt.Sprintf("%s %s", performer.config.VipAddCommand, performer.config.Vip))
-		vAddCmd.Stdout = NewPrefix("[VIPAddCommand.stdout]")
-		vAddCmd.Stderr = NewPrefix("[VIPAddCommand.stderr]")
-		if err := vAddCmd.Run(); err != nil {
-			config.Log.Error("[action] VIPAddCommand failed.")
-			config.Log.Debug("[VIPAddCommand.error] message: %s", err.Error())
+// fence runs the fencing command against every other node that could still be
+// running a writable database. Nodes that report themselves as backups are left
+// alone.
+func (performer *performer) fence() error {
+	if performer.config.FenceCommand == "" {
+		return nil
+	}
+	for _, other := range performer.others {
+		if role, err := other.GetDBRole(); err == nil && role == "backup" {
+			continue
+		}
+		ip, _, err := net.SplitHostPort(other.Location())
+		if err != nil {
+			return err
+		}
+		command := mustache.Render(performer.config.FenceCommand, map[string]string{"node": other.Location(), "node_ip": ip})
+
+		ctx, cancel := context.WithTimeout(context.Background(), time.Duration(performer.config.FenceTimeout)*time.Second)
+		fc := config.Shell(ctx, command)
+		fc.Stdout = NewPrefix("[FenceCommand.stdout]")
+		fc.Stderr = NewPrefix("[FenceCommand.stderr]")
+		performer.log.With(config.Fields{"peer": other.Location()}).Info("[action] fencing '%v'", other.Location())
+		performer.log.Debug("[action] fence command(%s)", command)
+		err = fc.Run()
+		cancel()
+		if err != nil {
+			fences.Inc("failed")
+			return fmt.Errorf("fencing '%v': %v", other.Location(), err)
 		}
+		fences.Inc("succeeded")
 	}
+	return nil
 }
 
-func (performer *performer) removeVip() {
-	if performer.vipable() {
-		config.Log.Info("[action] Removing VIP")
-		vRemoveCmd := exec.Command("bash", "-c", fmt.Sprintf("%s %s", performer.config.VipRemoveCommand, performer.config.Vip))
-		vRemoveCmd.Stdout = NewPrefix("[VIPRemoveCommand.stdout]")
-		vRemoveCmd.Stderr = NewPrefix("[VIPRemoveCommand.stderr]")
-		if err := vRemoveCmd.Run(); err != nil {
-			config.Log.Error("[action] VIPRemoveCommand failed.")
-			config.Log.Debug("[VIPRemoveCommand.error] message: %s", err.Error())
-		}
+func (performer *performer) addVip() {
+	if performer.vip == vip.None {
+		return
+	}
+	performer.log.Info("[action] Adding VIP")
+	if err := performer.vip.Add(); err != nil {
+		performer.log.Error("[action] adding the VIP failed (%v)", err)
 	}
 }
 
-func (performer *performer) vipable() bool {
-	return performer.config.Vip != "" && performer.config.VipAddCommand != "" && performer.config.VipRemoveCommand != ""
+func (performer *performer) removeVip() {
+	if performer.vip == vip.None {
+		return
+	}
+	performer.log.Info("[action] Removing VIP")
+	if err := performer.vip.Remove(); err != nil {
+		performer.log.Error("[action] removing the VIP failed (%v)", err)
+	}
 }
@@ -8,11 +8,17 @@
 package monitor
 
 import (
+	"errors"
 	"github.com/golang/mock/gomock"
 	"github.com/nanopack/yoke/config"
+	"github.com/nanopack/yoke/state"
 	"github.com/nanopack/yoke/state/mock"
+	"github.com/nanopack/yoke/vip"
+	"io/ioutil"
 	"os"
+	"path/filepath"
 	"testing"
+	"time"
 )
 
 func TestSingle(test *testing.T) {
@@ -96,7 +102,7 @@ func start(me, other *mock_state.MockState, test *testing.T) *performer {
 		SystemUser:  config.SystemUser(),
 	}
 
-	perform := NewPerformer(me, other, config.Conf)
+	perform := NewPerformer(me, []state.State{other}, vip.None, config.Conf)
 
 	// ignore all errors that come across this way
 	go func() {
@@ -126,3 +132,162 @@ func start(me, other *mock_state.MockState, test *testing.T) *performer {
 
 	return perform
 }
+
+func TestSetDBRoleRetries(test *testing.T) {
+	ctrl := gomock.NewController(test)
+	defer ctrl.Finish()
+
+	delay := roleRetryDelay
+	roleRetryDelay = 0
+	defer func() { roleRetryDelay = delay }()
+
+	me := mock_state.NewMockState(ctrl)
+	gomock.InOrder(
+		me.EXPECT().SetDBRole("backup").Return(errors.New("store is down")),
+		me.EXPECT().SetDBRole("backup").Return(nil),
+	)
+	if err := setDBRole(config.Log, me, state.Backup); err != nil {
+		test.Log(err)
+		test.FailNow()
+	}
+
+	me.EXPECT().SetDBRole("single").Return(errors.New("store is down")).Times(roleAttempts)
+	if err := setDBRole(config.Log, me, state.Single); err == nil {
+		test.Log("a role that was never stored was reported as recorded")
+		test.Fail()
+	}
+}
+
+// stands in for the database half of the transitions and records what it was
+// asked to do
+type recorded struct {
+	calls []string
+	err   error
+}
+
+func (database *recorded) Single() error { return database.record("single") }
+func (database *recorded) Active() error { return database.record("active") }
+func (database *recorded) Backup() error { return database.record("backup") }
+func (database *recorded) stop() error   { return database.record("stop") }
+func (database *recorded) rotate() error { return database.record("rotate") }
+
+func (database *recorded) record(call string) error {
+	database.calls = append(database.calls, call)
+	return database.err
+}
+
+// a performer that drives a recorded database instead of postgres
+func faked(me state.State, others []state.State, conf config.Config) (*performer, *recorded) {
+	perform := NewPerformer(me, others, vip.None, conf)
+	database := &recorded{}
+	perform.database = database
+	go func() {
+		for range perform.err {
+		}
+	}()
+	return perform, database
+}
+
+func fencing(me, other *mock_state.MockState, command string, timeout int) (*performer, *recorded) {
+	return faked(me, []state.State{other}, config.Config{FenceCommand: command, FenceTimeout: timeout})
+}
+
+func TestFenceFailureBlocksTakeover(test *testing.T) {
+	ctrl := gomock.NewController(test)
+	defer ctrl.Finish()
+
+	me := mock_state.NewMockState(ctrl)
+	other := mock_state.NewMockState(ctrl)
+	perform, database := fencing(me, other, "false", 5)
+
+	me.EXPECT().GetDBRole().Return("backup", nil)
+	other.EXPECT().GetDBRole().Return("active", nil)
+	other.EXPECT().Location().Return("127.0.0.1:4400").AnyTimes()
+
+	perform.TransitionToSingle()
+	if len(database.calls) != 0 {
+		test.Log("a backup took over although the active node could not be fenced", database.calls)
+		test.Fail()
+	}
+}
+
+func TestFenceSkipsBackups(test *testing.T) {
+	ctrl := gomock.NewController(test)
+	defer ctrl.Finish()
+
+	dir, err := ioutil.TempDir("", "fence")
+	if err != nil {
+		test.Log(err)
+		test.FailNow()
+	}
+	defer os.RemoveAll(dir)
+	fenced := filepath.Join(dir, "fenced")
+	me := mock_state.NewMockState(ctrl)
+	other := mock_state.NewMockState(ctrl)
+	perform, database := fencing(me, other, "touch "+fenced, 5)
+
+	me.EXPECT().GetDBRole().Return("backup", nil)
+	other.EXPECT().GetDBRole().Return("backup", nil)
+	other.EXPECT().Location().Return("127.0.0.1:4400").AnyTimes()
+
+	perform.TransitionToSingle()
+	if _, err := os.Stat(fenced); err == nil {
+		test.Log("a node that reported itself as a backup was fenced")
+		test.Fail()
+	}
+	if len(database.calls) != 1 || database.calls[0] != "single" {
+		test.Log("the backup did not take over", database.calls)
+		test.Fail()
+	}
+}
+
+func TestFenceRendersNode(test *testing.T) {
+	ctrl := gomock.NewController(test)
+	defer ctrl.Finish()
+
+	dir, err := ioutil.TempDir("", "fence")
+	if err != nil {
+		test.Log(err)
+		test.FailNow()
+	}
+	defer os.RemoveAll(dir)
+	fenced := filepath.Join(dir, "fenced")
+	me := mock_state.NewMockState(ctrl)
+	other := mock_state.NewMockState(ctrl)
+	perform, _ := fencing(me, other, "echo -n {{node_ip}} > "+fenced, 5)
+
+	other.EXPECT().GetDBRole().Return("", errors.New("unreachable"))
+	other.EXPECT().Location().Return("127.0.0.1:4400").AnyTimes()
+
+	if err := perform.fence(); err != nil {
+		test.Log(err)
+		test.FailNow()
+	}
+	ip, err := ioutil.ReadFile(fenced)
+	if err != nil || string(ip) != "127.0.0.1" {
+		test.Log("the unreachable node was not fenced", string(ip), err)
+		test.Fail()
+	}
+}
+
+func TestFenceTimeout(test *testing.T) {
+	ctrl := gomock.NewController(test)
+	defer ctrl.Finish()
+
+	me := mock_state.NewMockState(ctrl)
+	other := mock_state.NewMockState(ctrl)
+	perform, _ := fencing(me, other, "sleep 30", 1)
+
+	other.EXPECT().GetDBRole().Return("active", nil)
+	other.EXPECT().Location().Return("127.0.0.1:4400").AnyTimes()
+
+	started := time.Now()
+	if err := perform.fence(); err == nil {
+		test.Log("a fence command that hung was reported as done")
+		test.Fail()
+	}
+	if took := time.Since(started); took > 10*time.Second {
+		test.Log("the fence command was not killed after its timeout", took)
+		test.Fail()
+	}
+}
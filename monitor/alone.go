@@ -0,0 +1,138 @@
+// Copyright (c) 2015 Pagoda Box Inc
+//
+// This Source Code Form is subject to the terms of the Mozilla Public License, v.
+// 2.0. If a copy of the MPL was not distributed with this file, You can obtain one
+// at http://mozilla.org/MPL/2.0/.
+//
+
+// alone.go is what a node that isn't single does when it can't reach any other node
+// or the arbiter, as set by the unavailable policy. By default ('stop') it stops its
+// database. 'read_only' keeps the database running but refuses writes, 'serve'
+// keeps it running as it is with a warning, and 'power_off' stops the database and
+// runs the fence self command, which powers the machine off.
+
+package monitor
+
+import (
+	"context"
+	"errors"
+	"github.com/nanopack/yoke/config"
+	"github.com/nanopack/yoke/events"
+	"github.com/nanopack/yoke/state"
+	"time"
+)
+
+var ReadOnlyUnsupported = errors.New("the database can't be made read only")
+
+// how long the fence self command may take
+var fenceSelfTimeout = 30 * time.Second
+
+// a performer that can keep its database running without taking writes, the
+// postgres and mysql performers can
+type writeProtector interface {
+	ReadOnly(enabled bool) error
+}
+
+func servesAlone(decider *decider, s *situation) (bool, error) {
+	return decider.alone == "serve", nil
+}
+
+func readOnlyAlone(decider *decider, s *situation) (bool, error) {
+	if decider.alone != "read_only" {
+		return false, nil
+	}
+	_, ok := decider.plan.Performer.(writeProtector)
+	return ok, nil
+}
+
+func serveAlone(decider *decider, s *situation) error {
+	decider.log.Warn("no one here, still serving as '%v'", s.current)
+	events.Publish(events.Event{Type: events.ClusterUnavailable, DBRole: string(s.current)})
+	return ClusterUnaviable
+}
+
+// makes the database refuse writes, it is stopped when it can't be
+func goReadOnly(decider *decider, s *situation) error {
+	if decider.readOnly || decider.applied == state.Dead {
+		return ClusterUnaviable
+	}
+	events.Publish(events.Event{Type: events.ClusterUnavailable, DBRole: string(s.current)})
+	if decider.plan.planning() {
+		return ClusterUnaviable
+	}
+	if err := decider.plan.Performer.(writeProtector).ReadOnly(true); err != nil {
+		decider.log.Error("could not go read only, stopping (%v)", err)
+		decider.apply(state.Dead)
+		return ClusterUnaviable
+	}
+	decider.log.Warn("no one here, the database is read only")
+	decider.readOnly = true
+	return ClusterUnaviable
+}
+
+// lets the database take writes again once the node is no longer alone
+func (decider *decider) writable() {
+	if !decider.readOnly {
+		return
+	}
+	decider.log.Info("the cluster is back, the database takes writes again")
+	if err := decider.plan.Performer.(writeProtector).ReadOnly(false); err != nil {
+		decider.log.Error("could not let the database take writes again (%v)", err)
+		return
+	}
+	decider.readOnly = false
+}
+
+// powers the machine off once its database was stopped
+func (decider *decider) fenceSelf() {
+	if decider.alone != "power_off" || decider.fenced || decider.plan.planning() {
+		return
+	}
+	decider.log.Error("no one here, powering off")
+	ctx, cancel := context.WithTimeout(context.Background(), fenceSelfTimeout)
+	defer cancel()
+	cmd := config.Shell(ctx, decider.fenceCmd)
+	cmd.Stdout = NewPrefix("[FenceSelfCommand.stdout]")
+	cmd.Stderr = NewPrefix("[FenceSelfCommand.stderr]")
+	if err := cmd.Run(); err != nil {
+		decider.log.Error("the fence self command failed (%v)", err)
+		return
+	}
+	decider.fenced = true
+}
+
+// ReadOnly makes postgres turn down writes, or take them again. The setting outlives
+// a restart of postgres, so it is undone when the performer starts it.
+func (performer *performer) ReadOnly(enabled bool) error {
+	if performer.database != database(performer) {
+		return ReadOnlyUnsupported
+	}
+	performer.Lock()
+	defer performer.Unlock()
+	return performer.readOnly(enabled)
+}
+
+func (performer *performer) readOnly(enabled bool) error {
+	db, err := performer.pgConnect()
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	setting := "alter system reset default_transaction_read_only"
+	if enabled {
+		setting = "alter system set default_transaction_read_only = on"
+	}
+	if _, err := db.Exec(setting); err != nil {
+		return err
+	}
+	_, err = db.Exec("select pg_reload_conf()")
+	return err
+}
+
+// ReadOnly turns read_only on or off, Single and Active turn it off too
+func (performer *mysqlPerformer) ReadOnly(enabled bool) error {
+	performer.Lock()
+	defer performer.Unlock()
+	return performer.readOnly(enabled)
+}
@@ -0,0 +1,86 @@
+// Copyright (c) 2015 Pagoda Box Inc
+//
+// This Source Code Form is subject to the terms of the Mozilla Public License, v.
+// 2.0. If a copy of the MPL was not distributed with this file, You can obtain one
+// at http://mozilla.org/MPL/2.0/.
+//
+
+package monitor
+
+import (
+	"context"
+	"fmt"
+	"github.com/nanopack/yoke/config"
+	"github.com/nanopack/yoke/state"
+	"sync"
+)
+
+type (
+	// Arbiter is the tie breaker the decider falls back to when it can not talk
+	// to the other node directly. By default this is the yoke monitor process, but
+	// anything that can report the role of a node (etcd, consul, raft) can back it.
+	Arbiter interface {
+		// blocks until the arbiter can be consulted
+		Ready()
+		// returns a view of the node at location as it is seen by the arbiter
+		Bounce(location string) state.State
+	}
+
+	// an arbiter that keeps a record of the state of this node, instead of asking
+	// the node for it. It reports until ctx is done, and once more afterwards.
+	reporter interface {
+		Report(ctx context.Context, me state.State)
+	}
+
+	// an arbiter that decides which backup may take over, only the one that wins
+	// the campaign does
+	elector interface {
+		Campaign(location string) (bool, error)
+	}
+
+	// ArbiterFactory creates an arbiter from the node configuration
+	ArbiterFactory func(config.Config) (Arbiter, error)
+)
+
+var (
+	arbiterLock sync.Mutex
+	arbiters    = map[string]ArbiterFactory{}
+)
+
+func init() {
+	RegisterArbiter("monitor", newMonitorArbiter)
+}
+
+// RegisterArbiter makes an arbiter backend available under name, so it can be
+// selected with the 'arbiter' config option
+func RegisterArbiter(name string, factory ArbiterFactory) {
+	arbiterLock.Lock()
+	defer arbiterLock.Unlock()
+	arbiters[name] = factory
+}
+
+// NewArbiter creates the arbiter backend that was selected in the config
+func NewArbiter(conf config.Config) (Arbiter, error) {
+	name := conf.Arbiter
+	if name == "" {
+		name = "monitor"
+	}
+
+	arbiterLock.Lock()
+	factory, ok := arbiters[name]
+	arbiterLock.Unlock()
+	if !ok {
+		return nil, fmt.Errorf("unknown arbiter backend '%v'", name)
+	}
+	return factory(conf)
+}
+
+// the monitor arbiter bounces requests off of the dedicated yoke monitor node, or
+// off of any of them when there are several
+func newMonitorArbiter(conf config.Config) (Arbiter, error) {
+	monitors := conf.Monitors()
+	if len(monitors) > 1 {
+		return newMonitorSet(monitors, conf.CallTimeout(), conf.Logging()), nil
+	}
+	return state.NewRemoteState("tcp", conf.Monitor, conf.CallTimeout()), nil
+}
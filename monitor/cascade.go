@@ -0,0 +1,142 @@
+// Copyright (c) 2015 Pagoda Box Inc
+//
+// This Source Code Form is subject to the terms of the Mozilla Public License, v.
+// 2.0. If a copy of the MPL was not distributed with this file, You can obtain one
+// at http://mozilla.org/MPL/2.0/.
+//
+
+// cascade.go lets a backup stream from another backup instead of the active node,
+// so that a datacenter only needs one node streaming over the WAN. The backups keep
+// checking that they stream from the right node, and are pointed somewhere else when
+// a failover or a node that went away changed that.
+
+package monitor
+
+import (
+	"github.com/nanopack/yoke/config"
+	"github.com/nanopack/yoke/state"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"regexp"
+)
+
+// the node a recovery.conf or postgresql.auto.conf streams from
+var conninfoHost = regexp.MustCompile(`(?m)^primary_conninfo = 'host=(\S+)`)
+
+// a performer that can point a backup at another node to stream from, the postgres
+// performer can
+type reparenter interface {
+	Reparent() error
+}
+
+// points this node at the node it should stream from, on every recheck
+func (decider *decider) reparent() {
+	reparent, ok := decider.plan.Performer.(reparenter)
+	if !ok || decider.plan.planning() {
+		return
+	}
+	if err := reparent.Reparent(); err != nil {
+		decider.log.Error("[cascade] could not point the backup at its upstream (%v)", err)
+	}
+}
+
+// finds the nearest node up the cascade of this node that is a backup with a copy
+// of the data, nil when there is none and this node streams from the active node
+func (performer *performer) upstream() state.State {
+	seen := map[string]bool{}
+	for location := performer.config.Upstream(performer.me.Location()); location != "" && !seen[location]; location = performer.config.Upstream(location) {
+		seen[location] = true
+		for _, other := range performer.others {
+			if other.Location() != location {
+				continue
+			}
+			role, err := other.GetDBRole()
+			if err != nil || role != "backup" {
+				break
+			}
+			if synced, err := other.HasSynced(); err == nil && synced {
+				return other
+			}
+		}
+	}
+	return nil
+}
+
+// Reparent restarts a backup streaming from the node it should stream from, when
+// that isn't the node it streams from now. postgres only reads where to stream
+// from when it starts. Only the backups of a cluster with a cascade, or of a DR
+// cluster, are pointed elsewhere, the others are left as they were set up. The
+// writable node of a DR cluster follows the writable node of the source cluster.
+func (performer *performer) Reparent() error {
+	if performer.database != database(performer) || (performer.config.Cascade == "" && len(performer.sources) == 0) {
+		return nil
+	}
+	performer.Lock()
+	defer performer.Unlock()
+
+	role, err := performer.me.GetDBRole()
+	if err != nil || !performer.step["started"] {
+		return err
+	}
+	if (role == "active" || role == "single") && performer.tracking() {
+		return performer.track()
+	}
+	if role != "backup" {
+		return nil
+	}
+	if synced, err := performer.me.HasSynced(); err != nil || !synced {
+		return err
+	}
+	source, err := performer.source()
+	if err == NoSource {
+		// in the middle of a failover, the backup waits for the new active node
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	ip, _, err := net.SplitHostPort(source.Location())
+	if err != nil {
+		return err
+	}
+	// a backup without a recovery.conf was not set up to stream by yoke
+	current, err := streamingFrom(performer.config.DataDir)
+	if err != nil || current == "" || current == ip {
+		return err
+	}
+
+	log := performer.log.With(config.Fields{"peer": source.Location()})
+	log.Info("[cascade] streaming from '%v' instead of '%v'", source.Location(), current)
+	if err := performer.stop(); err != nil {
+		return err
+	}
+	if err := performer.config.CreateRecovery(ip, performer.config.PGPort); err != nil {
+		return err
+	}
+	return performer.startDB()
+}
+
+// the host the recovery.conf in dir streams from, empty when there is none. Postgres
+// 12 and later stream with the primary_conninfo of the postgresql.auto.conf while
+// there is a standby.signal.
+func streamingFrom(dir string) (string, error) {
+	contents, err := ioutil.ReadFile(filepath.Join(dir, "recovery.conf"))
+	if os.IsNotExist(err) {
+		if _, signal := os.Stat(filepath.Join(dir, "standby.signal")); signal != nil {
+			return "", nil
+		}
+		contents, err = ioutil.ReadFile(filepath.Join(dir, "postgresql.auto.conf"))
+	}
+	if os.IsNotExist(err) {
+		return "", nil
+	}
+	if err != nil {
+		return "", err
+	}
+	if match := conninfoHost.FindSubmatch(contents); match != nil {
+		return string(match[1]), nil
+	}
+	return "", nil
+}
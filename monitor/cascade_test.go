@@ -0,0 +1,81 @@
+// Copyright (c) 2015 Pagoda Box Inc
+//
+// This Source Code Form is subject to the terms of the Mozilla Public License, v.
+// 2.0. If a copy of the MPL was not distributed with this file, You can obtain one
+// at http://mozilla.org/MPL/2.0/.
+//
+
+package monitor
+
+import (
+	"errors"
+	"github.com/golang/mock/gomock"
+	"github.com/nanopack/yoke/config"
+	"github.com/nanopack/yoke/state"
+	"github.com/nanopack/yoke/state/mock"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestSourceCascades(test *testing.T) {
+	ctrl := gomock.NewController(test)
+	defer ctrl.Finish()
+
+	me := mock_state.NewMockState(ctrl)
+	active := mock_state.NewMockState(ctrl)
+	far := mock_state.NewMockState(ctrl)
+	near := mock_state.NewMockState(ctrl)
+	me.EXPECT().Location().Return("10.0.0.4:4400").AnyTimes()
+	active.EXPECT().Location().Return("10.0.0.1:4400").AnyTimes()
+	far.EXPECT().Location().Return("10.0.0.2:4400").AnyTimes()
+	near.EXPECT().Location().Return("10.0.0.3:4400").AnyTimes()
+	perform := &performer{
+		me:     me,
+		others: []state.State{active, far, near},
+		config: config.Config{Cascade: "10.0.0.4:4400=10.0.0.3:4400, 10.0.0.3:4400=10.0.0.2:4400"},
+	}
+
+	// the upstream of this node is down, so it streams from the one above it
+	near.EXPECT().GetDBRole().Return("dead", nil)
+	far.EXPECT().GetDBRole().Return("backup", nil)
+	far.EXPECT().HasSynced().Return(true, nil)
+	if source, err := perform.source(); err != nil || source != far {
+		test.Logf("should have streamed from the next node up the chain, not %v (%v)", source, err)
+		test.Fail()
+	}
+
+	// neither of them can be streamed from, so it streams from the active node
+	near.EXPECT().GetDBRole().Return("backup", nil)
+	near.EXPECT().HasSynced().Return(false, nil)
+	far.EXPECT().GetDBRole().Return("", errors.New("connection refused"))
+	active.EXPECT().GetDBRole().Return("active", nil)
+	if source, err := perform.source(); err != nil || source != active {
+		test.Logf("should have streamed from the active node, not %v (%v)", source, err)
+		test.Fail()
+	}
+}
+
+func TestStreamingFrom(test *testing.T) {
+	dir, err := ioutil.TempDir("", "yoke-cascade")
+	if err != nil {
+		test.Log(err)
+		test.FailNow()
+	}
+	defer os.RemoveAll(dir)
+
+	if host, err := streamingFrom(dir); err != nil || host != "" {
+		test.Logf("a data directory without a recovery.conf streams from nowhere, not '%v' (%v)", host, err)
+		test.Fail()
+	}
+	recovery := "standby_mode = on\nprimary_conninfo = 'host=10.0.0.2 port=5432 application_name=backup'\n"
+	if err := ioutil.WriteFile(filepath.Join(dir, "recovery.conf"), []byte(recovery), 0644); err != nil {
+		test.Log(err)
+		test.FailNow()
+	}
+	if host, err := streamingFrom(dir); err != nil || host != "10.0.0.2" {
+		test.Logf("should have streamed from 10.0.0.2, not '%v' (%v)", host, err)
+		test.Fail()
+	}
+}
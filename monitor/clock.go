@@ -0,0 +1,51 @@
+// Copyright (c) 2015 Pagoda Box Inc
+//
+// This Source Code Form is subject to the terms of the Mozilla Public License, v.
+// 2.0. If a copy of the MPL was not distributed with this file, You can obtain one
+// at http://mozilla.org/MPL/2.0/.
+//
+
+// clock.go lets the time the decider reasons with be replaced, so that a test can
+// move it on instead of sleeping through a grace period or a suspicion. The loop
+// still waits on the wall clock, only what the decider reasons about, and what it
+// records, takes its time from here.
+
+package monitor
+
+import (
+	"sync"
+	"time"
+)
+
+// Clock tells the time the deciders of this process reason with
+type Clock interface {
+	Now() time.Time
+}
+
+type wallClock struct{}
+
+func (wallClock) Now() time.Time {
+	return time.Now()
+}
+
+var (
+	clock     Clock = wallClock{}
+	clockLock sync.RWMutex
+)
+
+// SetClock changes the clock every decider of this process reasons with, nil goes
+// back to the wall clock
+func SetClock(c Clock) {
+	clockLock.Lock()
+	defer clockLock.Unlock()
+	if c == nil {
+		c = wallClock{}
+	}
+	clock = c
+}
+
+func now() time.Time {
+	clockLock.RLock()
+	defer clockLock.RUnlock()
+	return clock.Now()
+}
@@ -0,0 +1,64 @@
+// Copyright (c) 2015 Pagoda Box Inc
+//
+// This Source Code Form is subject to the terms of the Mozilla Public License, v.
+// 2.0. If a copy of the MPL was not distributed with this file, You can obtain one
+// at http://mozilla.org/MPL/2.0/.
+//
+
+// compression.go works out how a copy of the data is compressed. Every node tells
+// the others the compressions its rsync can take along with its summary, and a
+// copy to a node is compressed with the sync_compression if that node can take it,
+// with the best one both ends have otherwise.
+
+package monitor
+
+import (
+	"github.com/nanopack/yoke/config"
+	"github.com/nanopack/yoke/state"
+)
+
+// a performer that can tell the others how the data can be copied to it
+type compressor interface {
+	Compressions() []string
+}
+
+// Compressions are the compressions the rsync of the node can take, none when the
+// data isn't copied with rsync
+func (performer *performer) Compressions() []string {
+	if performer.config.SyncStrategy != "rsync" {
+		return nil
+	}
+	performer.compressOnce.Do(func() {
+		performer.compressions = config.RsyncCompressions()
+	})
+	return performer.compressions
+}
+
+// syncCompression is how a copy of the data over to other is compressed. A node
+// that doesn't say what it can take has an older yoke, and an rsync with zlib.
+func (performer *performer) syncCompression(other state.State) config.Compression {
+	theirs := []string{"gzip"}
+	if summary, ok := other.(summarizer); ok {
+		if gossip, err := summary.GetSummary(); err == nil && len(gossip.Compressions) != 0 {
+			theirs = gossip.Compressions
+		}
+	}
+	mine := map[string]bool{}
+	for _, name := range performer.Compressions() {
+		mine[name] = true
+	}
+	both := []string{}
+	for _, name := range theirs {
+		if mine[name] {
+			both = append(both, name)
+		}
+	}
+	return config.NegotiateCompression(performer.config.SyncCompression, performer.config.SyncCompressionLevel, both)
+}
+
+// compressions fills in the compressions of this node for its summary
+func (decider *decider) compressions(summary *state.Summary) {
+	if compressor, ok := decider.performer.(compressor); ok {
+		summary.Compressions = compressor.Compressions()
+	}
+}
@@ -8,159 +8,1171 @@
 package monitor
 
 import (
+	"context"
 	"errors"
+	"fmt"
 	"github.com/nanopack/yoke/config"
+	"github.com/nanopack/yoke/events"
 	"github.com/nanopack/yoke/state"
+	"github.com/nanopack/yoke/trace"
+	"math"
+	"strconv"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
 var (
-	ClusterUnaviable = errors.New("none of the nodes in the cluster are available")
+	ClusterUnaviable  = errors.New("none of the nodes in the cluster are available")
+	NotActive         = errors.New("this node is not the active node")
+	SwitchoverTimeout = errors.New("no backup caught up in time to switch over")
+	ShutDown          = errors.New("the decider has been shut down")
+	SplitBrain        = errors.New("another node is running as the active node too")
+	UnknownPeer       = errors.New("there is no such node in the cluster")
+	RoleNotRecorded   = errors.New("the transition did not record the db role it moved to")
+	NotBackup         = errors.New("this node is not a backup")
 )
 
 type (
 	Looper interface {
-		Loop(time.Duration) error
+		Loop(context.Context, time.Duration) error
+	}
+
+	// Decider watches the cluster and decides what this node should be doing
+	Decider interface {
+		Looper
+		Demote() error
+		Promote() error
+		Pause()
+		Resume()
+		Paused() bool
+		DryRun(bool)
+		Planned() (bool, *Plan)
+		Maintenance(bool) error
+		InMaintenance() bool
+		PromoteDR() error
+		Switchover(time.Duration) error
+		ReCheck() error
+		Force() error
+		Shutdown() error
+		LastLoop() time.Time
+		State() ClusterState
+		LastError() error
+		Reload(config.Config)
+		Join(location string) error
+		Upgrade() error
+		MajorUpgrade(stage string, timeout time.Duration) error
+		Decided() Decision
+		History() []HistoryEntry
 	}
 
 	decider struct {
 		sync.Mutex
 
 		me        state.State
-		other     state.State
-		monitor   state.State
+		others    []state.State
+		arbiter   Arbiter
 		performer Performer
+		plan      *planner
+		retry     RetryPolicy
+		quorum    Quorum
+		maxLag    int64
+		maxDelay  time.Duration
+		grace     time.Duration // how long a backup waits for the active node to come back
+		policy    string        // what to do when another node is active too
+		fromData  bool          // if the primary and secondary are told apart by what they last ran as
+		probing   bool          // if the peers are checked directly and through the arbiter at once
+		upgrade   string        // the command that upgrades the database while it is stopped
+		upTimeout time.Duration
+		drain     time.Duration // how long a switchover lets the clients finish, 0 doesn't drain
+		terminate bool          // if the clients that didn't finish by then are disconnected
+		alone     string        // what the node does when it can't reach anyone
+		fenceCmd  string        // the command that powers the machine off
+		readOnly  bool          // if the database was made read only because the node was alone
+		fenced    bool          // if the fence self command powered the machine off
+		frozen    bool          // if a major upgrade has the database turn down writes
+		paused    bool
+		log       config.Logger
+		shutdown  bool
+
+		// stops the arbiter from reporting the state of this node
+		stopReporting context.CancelFunc
+
+		// the health checks, and how many times in a row they failed
+		health      []HealthCheck
+		failures    int
+		maxFailures int
+		unhealthy   bool
+		// keeps the WAL from filling the disk, nil while it is off
+		guard *walGuard
+
+		// if the cluster is the DR cluster of another one, how long the source
+		// cluster has to be gone before it promotes itself (0 never), and when its
+		// writable node found it gone (zero while it is there)
+		dr           bool
+		promoteAfter time.Duration
+		sourceLost   time.Time
+
+		// the later epoch another node took writes in while this one still did, that
+		// was already reported
+		outdated uint64
+
+		// how long the promotion lease lasts (0 takes writes without one), if this
+		// node holds it, and when it runs out by the clock of this node. The lease is
+		// the one of the database of the config when the nodes run several.
+		leaseTTL     time.Duration
+		leased       bool
+		leaseExpires time.Time
+		cluster      string
+
+		// how each of the other nodes is being watched, in the same order
+		watching []*watched
+		// how often the other nodes are sent a heartbeat (0 never), and what wakes
+		// the loop up when one of them got a node suspected
+		beat time.Duration
+		wake chan struct{}
+
+		// unix nano time of the last time the arbiter answered a bounce
+		lastBounce int64
+		// unix nano time of the last time the loop came around
+		lastLoop int64
+		// how long the loop waits between checks, in nanoseconds
+		interval int64
+		// how the waits between the checks are jittered and adapted
+		pace *pace
+		// the row of the transition table the last recheck went by
+		decision atomic.Value
+		// the last transition the table handed to the performer, it is not made
+		// again while the node is in it. Anything else that moves the node forgets it.
+		applied state.DBRole
+		// every decision and transition, and what they were made on
+		history *history
+		// when this node, as a backup, first found no active node. It is zero while
+		// there is one.
+		orphaned time.Time
+		// what the last recheck made of the cluster, and what it failed with
+		believed atomic.Value
+		lastErr  atomic.Value
+		// the span of the recheck in flight, nil while tracing is off
+		span *trace.Span
+	}
+
+	// RetryPolicy controls how often the first check of the cluster is retried
+	RetryPolicy struct {
+		MaxAttempts int           // 0 retries forever
+		Delay       time.Duration // how long to wait before the first retry
+		MaxDelay    time.Duration // 0 lets the delay grow without limit
+	}
+
+	// Quorum is how many of the peers of a node have to be ready before it makes
+	// its first decision. 0 waits for all of them, -1 for a majority of the cluster.
+	Quorum int
+
+	// anything that can be waited on before the first decision
+	readier interface {
+		Ready()
+	}
+
+	// a state that remembers what the node knew before it was restarted, only the
+	// local state does
+	historian interface {
+		History() state.History
+		RememberPeer(location, dbRole string) error
+	}
+
+	// a state that knows if the cluster is in maintenance, the local state and
+	// the remote states do. Only the local state can be changed.
+	maintainer interface {
+		GetMaintenance() (state.Maintenance, error)
+		SetMaintenance(state.Maintenance) error
+	}
+
+	// a state that gossips how its node sees the cluster, the local state and the
+	// remote states do. Only the local state can be changed.
+	summarizer interface {
+		GetSummary() (state.Summary, error)
+		SetSummary(state.Summary) error
+	}
+
+	// a performer that can let the clients of its database finish before it is
+	// stopped, the postgres performer can
+	drainer interface {
+		Drain(timeout time.Duration, terminate bool) error
+	}
+
+	// a logger that can attach a field to every line it writes from now on, the
+	// config.LineLogger can
+	fielder interface {
+		Set(key, value string)
+	}
+
+	// peer is what a single recheck learned about another node in the cluster
+	peer struct {
+		view   state.State
+		dbRole state.DBRole
 	}
 )
 
-func NewDecider(me, other, monitor state.State, performer Performer) Looper {
-	decider := decider{
+// NewDecider waits for the cluster to be ready and makes the first decision about
+// what this node should be doing. others contains every other node in the cluster
+// that runs a database. The first decision is retried according to the retry policy
+// in the config, the last error is returned once it runs out of attempts. With the
+// default policy it waits for as long as it takes, see NewDeciderContext.
+func NewDecider(me state.State, others []state.State, arbiter Arbiter, performer Performer, conf config.Config) (Decider, error) {
+	return NewDeciderContext(context.Background(), me, others, arbiter, performer, conf)
+}
+
+// NewDeciderContext is NewDecider that gives up once ctx is done, and returns the
+// error of ctx then. ctx only bounds the first decision, the decider that is
+// returned runs until it is shut down.
+func NewDeciderContext(ctx context.Context, me state.State, others []state.State, arbiter Arbiter, performer Performer, conf config.Config) (Decider, error) {
+	// every automatic transition goes through the planner, so that it can be
+	// dry run
+	plan := &planner{Performer: performer, enabled: conf.DryRun, log: conf.Logging()}
+	decider := &decider{
 		me:        me,
-		other:     other,
-		monitor:   monitor,
-		performer: performer,
+		others:    others,
+		arbiter:   arbiter,
+		performer: plan,
+		plan:      plan,
+		retry:     NewRetryPolicy(conf),
+		quorum:    NewQuorum(conf),
+		maxLag:    int64(conf.MaxAllowedLagBytes),
+		maxDelay:  time.Duration(conf.MaxAllowedLagSeconds) * time.Second,
+		grace:     time.Duration(conf.FailoverDelay) * time.Second,
+		policy:    conf.SplitBrainPolicy,
+		fromData:  conf.StartupRole == "data",
+		probing:   conf.ConcurrentProbes,
+		upgrade:   conf.UpgradeCommand,
+		upTimeout: time.Duration(conf.UpgradeTimeout) * time.Second,
+		drain:     time.Duration(conf.DrainTimeout) * time.Second,
+		terminate: conf.DrainTerminate,
+		alone:     conf.UnavailablePolicy,
+		fenceCmd:  conf.FenceSelfCommand,
+		log:       conf.Logging(),
+
+		health:      newHealthChecks(conf),
+		maxFailures: conf.HealthFailures,
+		guard:       newWALGuard(conf),
+		history:     newHistory(conf.HistoryFile, conf.Logging()),
+		pace:        newPace(conf),
+
+		dr:           len(conf.DRSources()) != 0,
+		promoteAfter: time.Duration(conf.DRPromoteAfter) * time.Second,
+
+		leaseTTL: time.Duration(conf.PromotionLease) * time.Second,
+		cluster:  conf.Instance,
+
+		beat: time.Duration(conf.HeartbeatInterval) * time.Millisecond,
+		wake: make(chan struct{}, 1),
 	}
-	for {
-		// Really we only have to wait for a quorum, 2 out of 3 will allow everything to be ok.
-		// But in certain conditions, this node was a backup that was down, and the current active
-		// if offline, we need to wait for all 3 nodes.
-		// So really we are going to wait for all 3 nodes to make it simple
-		// me is already Ready. no need to call it
-		config.Log.Info("waiting for cluster to be ready")
-		other.Ready()
-		monitor.Ready()
-		config.Log.Info("cluster is ready")
+	for range others {
+		decider.watching = append(decider.watching, &watched{detector: NewFailureDetector(conf)})
+	}
+	// the arbiter keeps the record of the node until the decider is shut down, or
+	// fails to start
+	reporting, stopArbiter := context.WithCancel(context.Background())
+	stopGauges := report(decider)
+	stopReporting := func() {
+		stopArbiter()
+		stopGauges()
+	}
+	decider.stopReporting = stopReporting
+	if reporter, ok := arbiter.(reporter); ok {
+		go reporter.Report(reporting, me)
+	}
+
+	// the peers are only waited on once, a retry keeps counting the ones that got
+	// ready during the attempts before
+	ready, needed := decider.waitForPeers()
+	var err error
+	for attempt := 1; ; attempt++ {
+		if err = decider.start(ctx, ready, &needed); err == nil {
+			return decider, nil
+		}
+		if ctx.Err() != nil || decider.retry.MaxAttempts != 0 && attempt >= decider.retry.MaxAttempts {
+			stopReporting()
+			return nil, err
+		}
+		delay := decider.retry.Backoff(attempt)
+		decider.log.Info("first check of the cluster failed (%v), retrying in %v", err, delay)
+		select {
+		case <-ctx.Done():
+			stopReporting()
+			return nil, ctx.Err()
+		case <-time.After(delay):
+		}
+	}
+}
+
+// asks every peer if it is ready, the returned channel gets a value for each one
+// that is, along with how many of them have to be
+func (decider *decider) waitForPeers() (chan struct{}, int) {
+	// Really we only have to wait for a quorum, 2 out of 3 will allow everything to be ok.
+	// But in certain conditions, this node was a backup that was down, and the current active
+	// if offline, we need to wait for all 3 nodes.
+	// So by default we wait for all the nodes to make it simple, the quorum policy in
+	// the config can lower that. me is already Ready. no need to call it
+	peers := make([]readier, 0, len(decider.others)+1)
+	for _, other := range decider.others {
+		peers = append(peers, other)
+	}
+	peers = append(peers, decider.arbiter)
 
-		err := decider.reCheck()
-		switch err {
-		case ClusterUnaviable: // we try again.
-		case nil: // the cluster was successfully rechecked
-			return decider
-		default: // another kind of error occured
-			panic(err)
+	needed := decider.quorum.Peers(len(peers))
+	decider.log.Info("waiting for %v of %v peers to be ready", needed, len(peers))
+	ready := make(chan struct{}, len(peers))
+	// a peer that never gets ready is left waiting on, its Ready can't be given up
+	for _, peer := range peers {
+		go func(peer readier) {
+			peer.Ready()
+			ready <- struct{}{}
+		}(peer)
+	}
+	return ready, needed
+}
+
+// waits until needed more peers are ready and makes the first decision, unless
+// ctx is done first
+func (decider *decider) start(ctx context.Context, ready chan struct{}, needed *int) error {
+	for ; *needed > 0; *needed-- {
+		select {
+		case <-ready:
+		case <-ctx.Done():
+			return ctx.Err()
 		}
 	}
+	decider.log.Info("cluster is ready")
+
+	return decider.ReCheck()
+}
+
+const (
+	QuorumAll      Quorum = 0
+	QuorumMajority Quorum = -1
+)
+
+// NewQuorum reads the startup quorum policy out of the config
+func NewQuorum(conf config.Config) Quorum {
+	switch conf.StartupQuorum {
+	case "", "all":
+		return QuorumAll
+	case "majority":
+		return QuorumMajority
+	}
+	count, err := strconv.Atoi(conf.StartupQuorum)
+	if err != nil || count < 1 {
+		return QuorumAll
+	}
+	return Quorum(count)
+}
+
+// Peers returns how many out of total peers have to be ready. The cluster is made
+// up of this node and its peers.
+func (quorum Quorum) Peers(total int) int {
+	switch {
+	case quorum == QuorumAll:
+		return total
+	case quorum == QuorumMajority:
+		// a majority of the cluster, not counting this node
+		return (total + 1) / 2
+	case int(quorum) > total:
+		return total
+	}
+	return int(quorum)
+}
+
+// NewRetryPolicy reads the startup retry policy out of the config
+func NewRetryPolicy(conf config.Config) RetryPolicy {
+	return RetryPolicy{
+		MaxAttempts: conf.StartupAttempts,
+		Delay:       time.Duration(conf.StartupRetryDelay) * time.Second,
+		MaxDelay:    time.Duration(conf.StartupMaxRetryDelay) * time.Second,
+	}
+}
+
+// Backoff returns how long to wait after the given attempt failed, the delay doubles
+// with every attempt until it reaches MaxDelay
+func (policy RetryPolicy) Backoff(attempt int) time.Duration {
+	delay := policy.Delay
+	for i := 1; i < attempt; i++ {
+		if policy.MaxDelay != 0 && delay >= policy.MaxDelay {
+			break
+		}
+		delay *= 2
+	}
+	if policy.MaxDelay != 0 && delay > policy.MaxDelay {
+		delay = policy.MaxDelay
+	}
+	return delay
 }
 
 // this is the main loop for monitoring the cluster and making any changes needed to
-// reflect changes in remote nodes in the cluster
-func (decider decider) Loop(check time.Duration) error {
-	timer := time.Tick(check)
-	for range timer {
-		err := decider.reCheck()
+// reflect changes in remote nodes in the cluster. It waits about check between the
+// rechecks, as paced by the config, and runs until ctx is done or the decider is
+// shut down. A heartbeat that got a node suspected has it recheck right away.
+func (decider *decider) Loop(ctx context.Context, check time.Duration) error {
+	atomic.StoreInt64(&decider.interval, int64(check))
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	if decider.beat > 0 {
+		go decider.heartbeats(ctx)
+	}
+
+	var err error
+	for {
+		atomic.StoreInt64(&decider.lastLoop, time.Now().UnixNano())
+		// the interval can be changed by a reload
+		wait := time.NewTimer(decider.pace.next(time.Duration(atomic.LoadInt64(&decider.interval)), err))
+		trigger := "recheck"
+		select {
+		case <-ctx.Done():
+			wait.Stop()
+			return ctx.Err()
+		case <-wait.C:
+		case <-decider.wake:
+			wait.Stop()
+			trigger = "heartbeat"
+		}
+
+		if decider.Paused() {
+			continue
+		}
+		decider.Lock()
+		err = decider.recheck(trigger)
+		decider.Unlock()
+		if err != nil && err != ShutDown {
+			recheckFailures.Inc("")
+		}
 		switch {
-		case err == ClusterUnaviable:
+		case err == ClusterUnaviable, err == SplitBrain, err == Unhealthy, err == NoLease:
+		case err == ShutDown:
+			return nil
 		case err != nil:
 			return err
 		default:
 		}
 	}
+}
+
+// Reload applies the options of conf that can be changed while the decider runs,
+// it waits for any recheck that is in flight. The failure detectors start over, so
+// a peer is not suspected on what was gathered with the old thresholds.
+func (decider *decider) Reload(conf config.Config) {
+	atomic.StoreInt64(&decider.interval, int64(conf.Interval()))
+
+	decider.Lock()
+	defer decider.Unlock()
+	decider.maxLag = int64(conf.MaxAllowedLagBytes)
+	decider.maxDelay = time.Duration(conf.MaxAllowedLagSeconds) * time.Second
+	decider.grace = time.Duration(conf.FailoverDelay) * time.Second
+	decider.health = newHealthChecks(conf)
+	decider.maxFailures = conf.HealthFailures
+	for _, watch := range decider.watching {
+		watch.Lock()
+		watch.detector = NewFailureDetector(conf)
+		watch.Unlock()
+	}
+	decider.log.Info("reloaded the config, checking every %v", conf.Interval())
+}
+
+// LastLoop returns when the loop last came around, a loop that is stuck in a
+// recheck stops moving it forward. It is now while a transition or an operator
+// action is in progress, they can take longer than the watchdog of the process, and
+// zero before the loop has started otherwise.
+func (decider *decider) LastLoop() time.Time {
+	if decider.plan.busy() {
+		return time.Now()
+	}
+	last := atomic.LoadInt64(&decider.lastLoop)
+	if last == 0 {
+		return time.Time{}
+	}
+	return time.Unix(0, last)
+}
+
+// Shutdown waits for any recheck that is in flight, stops the database and then
+// advertises this node as 'dead' so that a backup can take over without waiting
+// for this node to time out. The decider makes no more decisions afterwards.
+func (decider *decider) Shutdown() error {
+	decider.Lock()
+	defer decider.Unlock()
+
+	if decider.shutdown {
+		return nil
+	}
+	decider.shutdown = true
+
+	decider.log.Info("shutting down the decider")
+	decider.applied = ""
+	decider.plan.Performer.Stop()
+	err := setDBRole(decider.log, decider.me, state.Dead)
+	decider.releaseLease()
+	// the arbiter reports the node as dead once more before it stops
+	decider.stopReporting()
+	return decider.audit("shutdown", state.Dead, err)
+}
+
+// this is used to move a active node to a backup node, it fails when the node did
+// not end up as a backup
+func (decider *decider) Demote() error {
+	decider.Lock()
+	defer decider.Unlock()
+	defer decider.plan.working()()
+
+	decider.applied = ""
+	decider.plan.Performer.TransitionToBackup()
+	return decider.audit("demote", state.Backup, decider.recorded(state.Backup))
+}
+
+// this is used to move a backup node to an active node, it is the forced way of
+// promoting a node and ignores how far the node is lagging behind
+func (decider *decider) Promote() error {
+	decider.Lock()
+	defer decider.Unlock()
+	defer decider.plan.working()()
+
+	decider.applied = ""
+	if err := decider.acquireLease(); err != nil {
+		return decider.audit("promote", state.Active, err)
+	}
+	decider.takeEpoch()
+	// backups have to go through single before they can become active, the
+	// loop will move this node on to active once the other nodes follow it
+	if role, err := dbRole(decider.me); err == nil && role == state.Backup {
+		decider.plan.Performer.TransitionToSingle()
+		return decider.audit("promote", state.Single, decider.recorded(state.Single))
+	}
+	decider.plan.Performer.TransitionToActive()
+	return decider.audit("promote", state.Active, decider.recorded(state.Active))
+}
+
+// checks that the transition to role made it into the state of this node, so that
+// what it advertises does not silently differ from what it was moved to
+func (decider *decider) recorded(role state.DBRole) error {
+	current, err := dbRole(decider.me)
+	if err != nil {
+		return err
+	}
+	if current != role {
+		mismatch(decider.log, role, current)
+		return RoleNotRecorded
+	}
 	return nil
 }
 
-// this is used to move a active node to a backup node
-func (decider decider) Demote() {
+// Switchover hands the active role over to the most caught up backup. This node waits
+// up to timeout for a backup to catch up, stops its database so that nothing else
+// is written, and then advertises itself as 'demoted', which lets the backups elect
+// a new active node. Once the new active node has synced the data back over, this
+// node follows it as a backup.
+func (decider *decider) Switchover(timeout time.Duration) error {
 	decider.Lock()
 	defer decider.Unlock()
+	defer decider.plan.working()()
 
-	decider.performer.TransitionToBackup()
+	return decider.audit("switchover", state.Demoted, decider.switchover(timeout))
 }
 
-// this is used to move a backup node to an active node
-func (decider decider) Promote() {
+func (decider *decider) switchover(timeout time.Duration) error {
+	if decider.shutdown {
+		return ShutDown
+	}
+
+	role, err := dbRole(decider.me)
+	if err != nil {
+		return err
+	}
+	if role != state.Active {
+		return NotActive
+	}
+
+	decider.log.Info("switching over, waiting for a backup to catch up")
+	deadline := time.Now().Add(timeout)
+	for !decider.caughtUp() {
+		if time.Now().After(deadline) {
+			return SwitchoverTimeout
+		}
+		<-time.After(time.Second)
+	}
+
+	if drainer, ok := decider.plan.Performer.(drainer); ok && decider.drain > 0 {
+		decider.log.Info("switching over, draining the clients")
+		if err := drainer.Drain(decider.drain, decider.terminate); err != nil {
+			decider.log.Warn("switching over, the clients could not be drained (%v)", err)
+		}
+	}
+
+	// synchronous commits are on, so once the database is stopped everything it
+	// accepted has made it to the backup
+	decider.log.Info("switching over, handing over to the backups")
+	decider.applied = ""
+	decider.plan.Performer.Stop()
+	if err := decider.me.SetSynced(false); err != nil {
+		return err
+	}
+	return setDBRole(decider.log, decider.me, state.Demoted)
+}
+
+// checks if one of the synced backups has replicated everything this node has written
+func (decider *decider) caughtUp() bool {
+	position, err := decider.performer.Position()
+	if err != nil {
+		return false
+	}
+	decider.me.SetPosition(position)
+
+	for _, other := range decider.others {
+		if role, err := dbRole(other); err != nil || role != state.Backup {
+			continue
+		}
+		if synced, err := other.HasSynced(); err != nil || !synced {
+			continue
+		}
+		if behind, err := other.GetPosition(); err == nil && behind >= position {
+			location := other.Location()
+			decider.log.With(config.Fields{"peer": location}).Info("'%v' has caught up", location)
+			return true
+		}
+	}
+	return false
+}
+
+// Pause stops the loop from rechecking the cluster until Resume is called
+func (decider *decider) Pause() {
 	decider.Lock()
 	defer decider.Unlock()
 
-	decider.performer.TransitionToActive()
+	decider.log.Info("pausing automatic transitions")
+	decider.paused = true
 }
 
-// Checks the other node in the cluster, falling back to bouncing the check off of the monitor,
-// to see if the states between this node and the remote node match up
-func (decider decider) reCheck() error {
+// Resume lets the loop go back to rechecking the cluster
+func (decider *decider) Resume() {
 	decider.Lock()
 	defer decider.Unlock()
 
-	var otherDBRole string
-	var err error
-	config.Log.Info("checking other role")
-	otherDBRole, err = decider.other.GetDBRole()
+	decider.log.Info("resuming automatic transitions")
+	decider.paused = false
+}
+
+func (decider *decider) Paused() bool {
+	decider.Lock()
+	defer decider.Unlock()
+
+	return decider.paused
+}
+
+// DryRun makes the decider only plan the transitions it would make on its own,
+// instead of making them. Transitions asked for by an operator still happen.
+func (decider *decider) DryRun(enabled bool) {
+	if enabled {
+		decider.log.Info("dry running, transitions are only planned")
+	} else {
+		decider.log.Info("no longer dry running")
+	}
+	decider.plan.setEnabled(enabled)
+}
+
+// Planned returns if the decider is dry running, and the last transition it
+// planned while it was
+func (decider *decider) Planned() (bool, *Plan) {
+	return decider.plan.state()
+}
+
+// Maintenance puts the whole cluster in maintenance or takes it out again. The
+// other nodes pick the change up on their next recheck, while it is on every
+// decider keeps checking the cluster but only plans its transitions.
+func (decider *decider) Maintenance(enabled bool) error {
+	decider.Lock()
+	defer decider.Unlock()
+
+	local, ok := decider.me.(maintainer)
+	if !ok {
+		return state.NotSupported
+	}
+	if err := local.SetMaintenance(state.Maintenance{Enabled: enabled, Changed: now()}); err != nil {
+		return err
+	}
+	decider.maintain(enabled)
+	return nil
+}
+
+func (decider *decider) InMaintenance() bool {
+	return decider.plan.inMaintenance()
+}
+
+// picks up the newest maintenance switch from the other nodes, so that a change
+// made on any node reaches the whole cluster and outlives the node it was made on
+func (decider *decider) syncMaintenance() {
+	local, ok := decider.me.(maintainer)
+	if !ok {
+		return
+	}
+	current, err := local.GetMaintenance()
 	if err != nil {
-		config.Log.Info("checking other role (bounce)")
-		address := decider.other.Location()
-		otherDBRole, err = decider.monitor.Bounce(address).GetDBRole()
+		return
+	}
+	newest := current
+	for _, other := range decider.others {
+		remote, ok := other.(maintainer)
+		if !ok {
+			continue
+		}
+		if theirs, err := remote.GetMaintenance(); err == nil && theirs.Changed.After(newest.Changed) {
+			newest = theirs
+		}
+	}
+	if newest.Changed.After(current.Changed) {
+		if err := local.SetMaintenance(newest); err != nil {
+			decider.log.Error("failed to record the maintenance switch (%v)", err)
+		}
+	}
+	decider.maintain(newest.Enabled)
+}
+
+func (decider *decider) maintain(enabled bool) {
+	if enabled == decider.plan.inMaintenance() {
+		return
+	}
+	if enabled {
+		decider.log.Info("the cluster is in maintenance, transitions are only planned")
+	} else {
+		decider.log.Info("the cluster is out of maintenance")
+	}
+	decider.plan.setMaintenance(enabled)
+}
+
+// ReCheck checks the other nodes in the cluster, falling back to bouncing the checks off of the
+// arbiter, to see if the states between this node and the remote nodes match up. What
+// this node does about it is decided by the transition table.
+func (decider *decider) ReCheck() error {
+	decider.Lock()
+	defer decider.Unlock()
+
+	return decider.recheck("recheck")
+}
+
+// Force rechecks the cluster, and makes the transition that is decided on even when
+// it was already made. It is the way out for a performer that got out of step with
+// what the decider thinks it did.
+func (decider *decider) Force() error {
+	decider.Lock()
+	defer decider.Unlock()
+
+	decider.applied = ""
+	return decider.recheck("force")
+}
+
+func (decider *decider) recheck(trigger string) (err error) {
+	started := time.Now()
+	decider.span = trace.Start("recheck")
+	decider.span.Set("trigger", trigger)
+	defer func() {
+		decider.lastErr.Store(checkError{err})
+		recheckDuration.Observe("", time.Since(started))
+		decider.span.Finish(err)
+		decider.span = nil
+	}()
+	if decider.shutdown {
+		return ShutDown
+	}
+	decider.syncMaintenance()
+	decider.syncPromotion()
+
+	// keep the position this node advertises up to date, the other nodes use it
+	// to compare how far along each node is
+	if position, err := decider.performer.Position(); err == nil {
+		decider.me.SetPosition(position)
+	}
+
+	if err := decider.checkHealth(); err != nil {
+		return err
+	}
+	decider.guardWAL()
+	decider.reparent()
+	decider.renewLease()
+
+	summary, summarizing := decider.me.(summarizer)
+	reached := map[string]string{}
+	peers := make([]peer, 0, len(decider.others))
+	seen := make([]PeerState, 0, len(decider.others))
+	unknown := 0
+	for i, other := range decider.others {
+		checked, checkErr := decider.checkPeer(other)
+		how := reach(other, checked, checkErr)
+		if summarizing {
+			reached[other.Location()] = how
+		}
+		peer, err := decider.watch(other, decider.watching[i], checked, checkErr)
+		if decider.watching[i].joining {
+			peer, err = decider.join(decider.watching[i], other, peer, err)
+		}
 		if err != nil {
-			// this node can't talk to the other member of the cluster or the monitor
-			// if this node is not in single mode it needs to shut off
-			if role, err := decider.me.GetDBRole(); role != "single" || err != nil {
-				config.Log.Info("stopping, no one here")
-				decider.performer.Stop()
-				return ClusterUnaviable
-			}
-			return nil
+			unknown++
+			seen = append(seen, PeerState{Reached: how, view: other})
+			continue
 		}
+		seen = append(seen, PeerState{DBRole: peer.dbRole, Reached: how, view: other})
+		if history, ok := decider.me.(historian); ok {
+			history.RememberPeer(other.Location(), string(peer.dbRole))
+		}
+		peers = append(peers, peer)
 	}
 
-	config.Log.Info("other node is '%v'", otherDBRole)
+	if len(peers) == 0 {
+		clusterAvailable.Set("", 0)
+	} else {
+		clusterAvailable.Set("", 1)
+	}
 
-	// we need to handle multiple possible states that the remote node is in
-	switch otherDBRole {
-	case "single":
-		fallthrough
-	case "active":
+	situation := newSituation(decider.me, peers, unknown)
+	situation.trigger = trigger
+	if situation.running != nil {
+		decider.orphaned = time.Time{}
+	}
+	row, err := decider.decide(situation)
+	if err == nil {
+		err = decider.take(row, situation)
+	}
+	decider.syncEpoch()
+	decider.believe(situation, seen)
+	decider.trustKeys(seen)
+	if summarizing {
+		decision := decider.Decided()
+		gossip := state.Summary{Rule: decision.Rule, Decided: decision.Time, Peers: reached, Version: decider.version()}
+		decider.keys(&gossip)
+		decider.compressions(&gossip)
+		summary.SetSummary(gossip)
+	}
+	return err
+}
+
+// how a recheck got through to other, for the connectivity of the summary
+func reach(other state.State, checked peer, err error) string {
+	switch {
+	case err != nil:
+		return "unreachable"
+	case checked.view != other:
+		return "bounced"
+	default:
+		return "direct"
+	}
+}
+
+// checks with the arbiter that this node may take over, when the arbiter decides
+// that. Only one of the backups can win the campaign.
+func (decider *decider) campaign() bool {
+	elector, ok := decider.arbiter.(elector)
+	if !ok {
+		return true
+	}
+	won, err := elector.Campaign(decider.me.Location())
+	if err != nil {
+		decider.log.Warn("could not campaign to take over (%v)", err)
+		return false
+	}
+	if !won {
+		decider.log.Info("another node holds the leader key, not taking over")
+	}
+	return won
+}
+
+// splitBrain resolves this node and another both running as the active node,
+// according to the split brain policy. The monitor has to see the other node as
+// active too, otherwise the other node has already stepped down.
+func (decider *decider) splitBrain(other peer) error {
+	location := other.view.Location()
+	log := decider.log.With(config.Fields{"peer": location})
+	if role, err := dbRole(decider.arbiter.Bounce(location)); err == nil && role != state.Single && role != state.Active {
+		log.Info("'%v' claimed to be active, but the monitor sees it as '%v'", location, role)
+		return nil
+	}
+
+	policy := decider.policy
+	if policy == "" {
+		policy = "halt"
+	}
+	log.Error("'%v' is running as the active node too, resolving with '%v'", location, policy)
+	splitBrains.Inc(policy)
+	events.Publish(events.Event{Type: events.SplitBrain, Peer: location})
+
+	switch policy {
+	case "position":
+		mine, err := decider.performer.Position()
+		theirs, otherErr := other.view.GetPosition()
+		if err != nil || otherErr != nil {
+			log.Error("could not compare positions, halting")
+			break
+		}
+		if mine > theirs || (mine == theirs && decider.me.Location() < location) {
+			log.Info("this node is further along (%v >= %v), '%v' has to step down", mine, theirs, location)
+			return nil
+		}
 		decider.performer.TransitionToBackup()
-	case "dead":
-		DBrole, err := decider.me.GetDBRole()
+		return nil
+	case "primary":
+		role, err := decider.me.GetRole()
 		if err != nil {
 			return err
 		}
-		if DBrole == "backup" {
-			// if this node is not synced up to the previous master, then we must wait for the other node to
-			// come online
-			hasSynced, err := decider.me.HasSynced()
-			if err != nil {
-				return err
-			}
-			if !hasSynced {
-				decider.performer.Stop()
-				return ClusterUnaviable
+		if state.Role(role) == state.Primary {
+			log.Info("this node is the primary, '%v' has to step down", location)
+			return nil
+		}
+		decider.performer.TransitionToBackup()
+		return nil
+	}
+
+	decider.performer.Stop()
+	return SplitBrain
+}
+
+// hadNewestData checks what this node knew before it was restarted. It had the
+// newest data if the database was writable, or if it was a synced backup of the
+// peer that has now come back empty.
+func (decider *decider) hadNewestData(empty peer) bool {
+	history, ok := decider.me.(historian)
+	if !ok {
+		return false
+	}
+	last := history.History()
+	switch state.DBRole(last.DBRole) {
+	case state.Active, state.Single:
+		decider.log.Info("this node was '%v' before it was restarted, taking over", last.DBRole)
+		return true
+	case state.Backup:
+		location := empty.view.Location()
+		switch state.DBRole(last.Peers[location]) {
+		case state.Active, state.Single:
+			if last.Synced {
+				decider.log.With(config.Fields{"peer": location}).Info("'%v' came back empty, this node was its synced backup", location)
+				return true
 			}
 		}
+	}
+	return false
+}
 
-		decider.performer.TransitionToSingle()
-	case "initialized":
-		role, err := decider.me.GetRole()
+// clusterRole returns the role this node has when the other node came back empty.
+// When the roles go by data, a node that was writable before it was restarted is the
+// primary and a node that was a backup the secondary, whatever the config says.
+func (decider *decider) clusterRole(s *situation) (state.Role, error) {
+	if history, ok := decider.me.(historian); ok && decider.fromData {
+		last := history.History()
+		switch state.DBRole(last.DBRole) {
+		case state.Active, state.Single:
+			return state.Primary, nil
+		case state.Backup:
+			return state.Secondary, nil
+		}
+	}
+	return s.clusterRole()
+}
+
+// returned checks if this node is coming back as a stale active node. Only the first
+// decision after a restart can find that: the node was writable before, and running
+// has written since it took over. Two nodes that were both writable before they
+// were restarted have written nothing yet, and are still a split brain.
+func (decider *decider) returned(running peer) bool {
+	history, ok := decider.me.(historian)
+	if !ok || decider.Decided().Rule != "" {
+		return false
+	}
+	last := history.History()
+	switch state.DBRole(last.DBRole) {
+	case state.Active, state.Single:
+	default:
+		return false
+	}
+	if position, err := running.view.GetPosition(); err != nil || position == 0 {
+		return false
+	}
+	location := running.view.Location()
+	decider.log.With(config.Fields{"peer": location}).Info("this node was '%v' before it was restarted, but '%v' took over since, following it", last.DBRole, location)
+	return true
+}
+
+// checks the db role of a single node, bouncing the check off of the arbiter if
+// the node can't be reached directly, or at the same time when probing. The returned
+// view is whichever path worked.
+func (decider *decider) checkPeer(other state.State) (checked peer, err error) {
+	span := decider.span.Child("check peer")
+	if span != nil {
+		span.Set("peer", other.Location())
+	}
+	defer func() {
+		span.Set("db_role", string(checked.dbRole))
+		span.Finish(err)
+	}()
+
+	if decider.probing {
+		return decider.probe(span, other).reconcile()
+	}
+	decider.log.Info("checking other role")
+	role, err := traced(span, "direct", other)
+	if err == nil {
+		decider.log.Info("other node is '%v'", role)
+		return peer{view: other, dbRole: role}, nil
+	}
+
+	location := other.Location()
+	log := decider.log.With(config.Fields{"peer": location})
+	log.Info("checking other role (bounce)")
+	view := decider.arbiter.Bounce(location)
+	role, err = traced(span, "bounce", view)
+	if err != nil {
+		return peer{}, err
+	}
+	atomic.StoreInt64(&decider.lastBounce, time.Now().UnixNano())
+	log.Info("other node is '%v'", role)
+	return peer{view: view, dbRole: role}, nil
+}
+
+// elect decides if this node should be the one to take over from a dead active node.
+// The backup with the furthest replication position wins, ties are broken by the
+// location of the node so that every backup comes to the same conclusion.
+func (decider *decider) elect(backups []state.State) (bool, error) {
+	if len(backups) == 0 {
+		return true, nil
+	}
+
+	position, err := decider.me.GetPosition()
+	if err != nil {
+		return false, err
+	}
+	location := decider.me.Location()
+
+	for _, backup := range backups {
+		// a backup that never finished syncing can't be promoted, so it can't win
+		synced, err := backup.HasSynced()
 		if err != nil {
-			return err
+			return false, err
 		}
-		switch role {
-		case "primary":
-			decider.performer.TransitionToActive()
-		case "secondary":
-			decider.performer.TransitionToBackup()
+		if !synced {
+			continue
+		}
+
+		other, err := backup.GetPosition()
+		if err != nil {
+			return false, err
+		}
+		if other > position || (other == position && backup.Location() < location) {
+			decider.log.Info("'%v' is further along (%v > %v), not taking over", backup.Location(), other, position)
+			return false, nil
 		}
-	case "backup":
-		decider.performer.TransitionToActive()
 	}
-	return nil
+	decider.log.Info("elected to take over at position %v", position)
+	return true, nil
+}
+
+// records how far this node is behind the active node, so it can be checked if
+// the active node goes away. Nothing is measured when any lag is allowed.
+func (decider *decider) measureLag(active state.State) {
+	if decider.maxLag == 0 && decider.maxDelay == 0 {
+		return
+	}
+	if role, err := dbRole(decider.me); err != nil || role != state.Backup {
+		return
+	}
+	delay, err := decider.performer.ReplayDelay()
+	if err != nil {
+		return
+	}
+	bytes := int64(0)
+	ahead, err := active.GetPosition()
+	if err != nil {
+		return
+	}
+	if position, err := decider.me.GetPosition(); err == nil && ahead > position {
+		bytes = int64(ahead - position)
+	}
+	decider.me.SetLag(delay, bytes)
+}
+
+// checks if this node was further behind the active node than the config allows,
+// and tells the phase the lag it measured puts it in. Nothing was measured when
+// any lag is allowed.
+func (decider *decider) lagging() (bool, SyncPhase) {
+	if decider.maxLag == 0 && decider.maxDelay == 0 {
+		return false, ""
+	}
+	delay, bytes, err := decider.me.Lag()
+	if err != nil {
+		return false, ""
+	}
+	phase := PhaseOf(true, delay, bytes, decider.maxLag, decider.maxDelay)
+	if phase != CatchingUp {
+		return false, phase
+	}
+	if decider.maxLag != 0 && bytes > decider.maxLag {
+		decider.log.Info("still catching up, lagging %v bytes behind, refusing to take over", bytes)
+		return true, phase
+	}
+	if decider.maxDelay != 0 && delay > decider.maxDelay {
+		decider.log.Info("still catching up, lagging %v behind, refusing to take over", delay)
+		return true, phase
+	}
+	return false, phase
+}
+
+// reports how far behind this node each of the other nodes are, when this node
+// is the active one
+func (decider *decider) lag() map[string]float64 {
+	lag := map[string]float64{}
+	if role, err := dbRole(decider.me); err != nil || role != state.Active {
+		return lag
+	}
+	position, err := decider.me.GetPosition()
+	if err != nil {
+		return lag
+	}
+	for _, other := range decider.others {
+		if behind, err := other.GetPosition(); err == nil {
+			lag[other.Location()] = float64(position) - float64(behind)
+		}
+	}
+	return lag
+}
+
+func (decider *decider) sinceBounce() float64 {
+	last := atomic.LoadInt64(&decider.lastBounce)
+	if last == 0 {
+		return math.NaN()
+	}
+	return time.Since(time.Unix(0, last)).Seconds()
+}
+
+// how many times recording the db role is tried, and how long to wait in between
+var (
+	roleAttempts   = 3
+	roleRetryDelay = 100 * time.Millisecond
+)
+
+// records the db role of this node, and attaches it to every log line from then on
+// when the logger can do that. A store that fails is retried, the other nodes would
+// otherwise keep seeing the role the node is leaving.
+func setDBRole(log config.Logger, me state.State, role state.DBRole) error {
+	var err error
+	for attempt := 1; attempt <= roleAttempts; attempt++ {
+		if err = me.SetDBRole(string(role)); err == nil {
+			if fields, ok := log.(fielder); ok {
+				fields.Set("dbrole", string(role))
+			}
+			return nil
+		}
+		if attempt < roleAttempts {
+			log.Warn("could not record the db role '%v', retrying (%v)", role, err)
+			<-time.After(roleRetryDelay)
+		}
+	}
+	log.Error("could not record the db role '%v' (%v)", role, err)
+	events.Publish(events.Event{Type: events.RoleUnrecorded, DBRole: string(role), Error: err.Error()})
+	return err
+}
+
+// reports that this node was moved to role, while its state holds current
+func mismatch(log config.Logger, role, current state.DBRole) {
+	log.Error("this node was moved to '%v', but its state holds '%v'", role, current)
+	events.Publish(events.Event{Type: events.RoleMismatch, DBRole: string(current), Error: fmt.Sprintf("moved to '%v'", role)})
 }
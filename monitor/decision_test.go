@@ -8,14 +8,55 @@
 package monitor_test
 
 import (
+	"context"
 	"errors"
 	"github.com/golang/mock/gomock"
+	"github.com/nanopack/yoke/config"
 	"github.com/nanopack/yoke/monitor"
 	"github.com/nanopack/yoke/monitor/mock"
+	"github.com/nanopack/yoke/state"
 	"github.com/nanopack/yoke/state/mock"
+	"io/ioutil"
+	"os"
+	"sync"
 	"testing"
+	"time"
 )
 
+// every recheck refreshes the position this node advertises
+func expectPosition(me *mock_state.MockState, perform *mock_monitor.MockPerformer) {
+	perform.EXPECT().Position().Return(uint64(0), errors.New("not running")).AnyTimes()
+}
+
+// a state that remembers what it knew before a restart, like the local state does
+type restarted struct {
+	*mock_state.MockState
+	history state.History
+}
+
+func (me restarted) History() state.History {
+	return me.history
+}
+
+func (me restarted) RememberPeer(location, dbRole string) error {
+	return nil
+}
+
+// a state that takes part in passing the maintenance switch around
+type maintained struct {
+	*mock_state.MockState
+	maintenance state.Maintenance
+}
+
+func (other *maintained) GetMaintenance() (state.Maintenance, error) {
+	return other.maintenance, nil
+}
+
+func (other *maintained) SetMaintenance(maintenance state.Maintenance) error {
+	other.maintenance = maintenance
+	return nil
+}
+
 func TestPrimary(test *testing.T) {
 	ctrl := gomock.NewController(test)
 	defer ctrl.Finish()
@@ -27,12 +68,13 @@ func TestPrimary(test *testing.T) {
 
 	other.EXPECT().Ready()
 	arbiter.EXPECT().Ready()
+	expectPosition(me, perform)
 
 	other.EXPECT().GetDBRole().Return("initialized", nil)
 	me.EXPECT().GetRole().Return("primary", nil)
 	perform.EXPECT().TransitionToActive()
 
-	monitor.NewDecider(me, other, arbiter, perform)
+	monitor.NewDecider(me, []state.State{other}, arbiter, perform, config.Config{})
 }
 
 func TestSecondary(test *testing.T) {
@@ -46,12 +88,151 @@ func TestSecondary(test *testing.T) {
 
 	other.EXPECT().Ready()
 	arbiter.EXPECT().Ready()
+	expectPosition(me, perform)
+
+	other.EXPECT().GetDBRole().Return("initialized", nil)
+	me.EXPECT().GetRole().Return("secondary", nil)
+	perform.EXPECT().TransitionToBackup()
+
+	monitor.NewDecider(me, []state.State{other}, arbiter, perform, config.Config{})
+}
+
+func TestLastLoopDuringTransition(test *testing.T) {
+	ctrl := gomock.NewController(test)
+	defer ctrl.Finish()
+
+	me := mock_state.NewMockState(ctrl)
+	other := mock_state.NewMockState(ctrl)
+	arbiter := mock_state.NewMockState(ctrl)
+	perform := mock_monitor.NewMockPerformer(ctrl)
+
+	other.EXPECT().Ready()
+	arbiter.EXPECT().Ready()
+	expectPosition(me, perform)
+
+	other.EXPECT().GetDBRole().Return("initialized", nil)
+	me.EXPECT().GetRole().Return("secondary", nil)
+	me.EXPECT().GetDBRole().Return("backup", nil).AnyTimes()
+	perform.EXPECT().TransitionToBackup()
+
+	decider, err := monitor.NewDecider(me, []state.State{other}, arbiter, perform, config.Config{})
+	if err != nil {
+		test.Log(err)
+		test.FailNow()
+	}
+
+	// a demotion that takes longer than the watchdog, the sync of the data does
+	started := make(chan struct{})
+	release := make(chan struct{})
+	done := make(chan struct{})
+	perform.EXPECT().TransitionToBackup().Do(func() {
+		close(started)
+		<-release
+	})
+	go func() {
+		decider.Demote()
+		close(done)
+	}()
+	<-started
+	if since := time.Since(decider.LastLoop()); since > time.Second {
+		test.Logf("the loop should count as coming around during a transition, it was %v ago", since)
+		test.Fail()
+	}
+	close(release)
+	<-done
+	if !decider.LastLoop().IsZero() {
+		test.Log("the loop should only count as coming around while the transition runs")
+		test.Fail()
+	}
+}
+
+func TestRestartedSingle(test *testing.T) {
+	ctrl := gomock.NewController(test)
+	defer ctrl.Finish()
+
+	me := mock_state.NewMockState(ctrl)
+	other := mock_state.NewMockState(ctrl)
+	arbiter := mock_state.NewMockState(ctrl)
+	perform := mock_monitor.NewMockPerformer(ctrl)
+
+	other.EXPECT().Ready()
+	arbiter.EXPECT().Ready()
+	expectPosition(me, perform)
+
+	// the primary lost its data while this secondary was running on its own
+	other.EXPECT().GetDBRole().Return("initialized", nil)
+	other.EXPECT().Location().Return("127.0.0.1:4400").AnyTimes()
+	perform.EXPECT().TransitionToActive()
+
+	history := state.History{DBRole: "single"}
+	monitor.NewDecider(restarted{me, history}, []state.State{other}, arbiter, perform, config.Config{})
+}
+
+func TestRestartedBackupOfEmptyActive(test *testing.T) {
+	ctrl := gomock.NewController(test)
+	defer ctrl.Finish()
+
+	me := mock_state.NewMockState(ctrl)
+	other := mock_state.NewMockState(ctrl)
+	arbiter := mock_state.NewMockState(ctrl)
+	perform := mock_monitor.NewMockPerformer(ctrl)
+
+	other.EXPECT().Ready()
+	arbiter.EXPECT().Ready()
+	expectPosition(me, perform)
 
 	other.EXPECT().GetDBRole().Return("initialized", nil)
+	other.EXPECT().Location().Return("127.0.0.1:4400").AnyTimes()
+	perform.EXPECT().TransitionToActive()
+
+	history := state.History{DBRole: "backup", Synced: true, Peers: map[string]string{"127.0.0.1:4400": "active"}}
+	monitor.NewDecider(restarted{me, history}, []state.State{other}, arbiter, perform, config.Config{})
+}
+
+func TestRestartedBackupNotSynced(test *testing.T) {
+	ctrl := gomock.NewController(test)
+	defer ctrl.Finish()
+
+	me := mock_state.NewMockState(ctrl)
+	other := mock_state.NewMockState(ctrl)
+	arbiter := mock_state.NewMockState(ctrl)
+	perform := mock_monitor.NewMockPerformer(ctrl)
+
+	other.EXPECT().Ready()
+	arbiter.EXPECT().Ready()
+	expectPosition(me, perform)
+
+	// without a full copy of the data this node falls back to its configured role
+	other.EXPECT().GetDBRole().Return("initialized", nil)
+	other.EXPECT().Location().Return("127.0.0.1:4400").AnyTimes()
 	me.EXPECT().GetRole().Return("secondary", nil)
 	perform.EXPECT().TransitionToBackup()
 
-	monitor.NewDecider(me, other, arbiter, perform)
+	history := state.History{DBRole: "backup", Peers: map[string]string{"127.0.0.1:4400": "active"}}
+	monitor.NewDecider(restarted{me, history}, []state.State{other}, arbiter, perform, config.Config{})
+}
+
+func TestStartupRoleFromData(test *testing.T) {
+	ctrl := gomock.NewController(test)
+	defer ctrl.Finish()
+
+	me := mock_state.NewMockState(ctrl)
+	other := mock_state.NewMockState(ctrl)
+	arbiter := mock_state.NewMockState(ctrl)
+	perform := mock_monitor.NewMockPerformer(ctrl)
+
+	other.EXPECT().Ready()
+	arbiter.EXPECT().Ready()
+	expectPosition(me, perform)
+
+	// the configured primary was a backup since the last failover, so it isn't
+	// asked for its configured role and follows
+	other.EXPECT().GetDBRole().Return("initialized", nil)
+	other.EXPECT().Location().Return("127.0.0.1:4400").AnyTimes()
+	perform.EXPECT().TransitionToBackup()
+
+	history := state.History{DBRole: "backup", Peers: map[string]string{"127.0.0.1:4400": "active"}}
+	monitor.NewDecider(restarted{me, history}, []state.State{other}, arbiter, perform, config.Config{StartupRole: "data"})
 }
 
 func TestSingle(test *testing.T) {
@@ -65,11 +246,52 @@ func TestSingle(test *testing.T) {
 
 	other.EXPECT().Ready()
 	arbiter.EXPECT().Ready()
+	expectPosition(me, perform)
 
 	other.EXPECT().GetDBRole().Return("single", nil)
+	me.EXPECT().GetDBRole().Return("initialized", nil)
 	perform.EXPECT().TransitionToBackup()
 
-	monitor.NewDecider(me, other, arbiter, perform)
+	monitor.NewDecider(me, []state.State{other}, arbiter, perform, config.Config{})
+}
+
+func TestState(test *testing.T) {
+	ctrl := gomock.NewController(test)
+	defer ctrl.Finish()
+
+	me := mock_state.NewMockState(ctrl)
+	other := mock_state.NewMockState(ctrl)
+	arbiter := mock_state.NewMockState(ctrl)
+	perform := mock_monitor.NewMockPerformer(ctrl)
+
+	other.EXPECT().Ready()
+	arbiter.EXPECT().Ready()
+	expectPosition(me, perform)
+
+	other.EXPECT().GetDBRole().Return("single", nil)
+	me.EXPECT().GetDBRole().Return("initialized", nil)
+	perform.EXPECT().TransitionToBackup()
+
+	decider, err := monitor.NewDecider(me, []state.State{other}, arbiter, perform, config.Config{})
+	if err != nil {
+		test.Log(err)
+		test.FailNow()
+	}
+	if err := decider.LastError(); err != nil {
+		test.Logf("the first check failed (%v)", err)
+		test.Fail()
+	}
+
+	other.EXPECT().Location().Return("127.0.0.1:4400")
+	believed := decider.State()
+	if believed.DBRole != state.Initialized || believed.Decision.Rule != "follow" || believed.Unknown != 0 {
+		test.Logf("wrong state %+v", believed)
+		test.Fail()
+	}
+	if len(believed.Peers) != 1 || believed.Peers[0].Location != "127.0.0.1:4400" || believed.Peers[0].DBRole != state.Single || believed.Peers[0].Reached != "direct" {
+		test.Logf("wrong peers %+v", believed.Peers)
+		test.Fail()
+	}
 }
 
 func TestActive(test *testing.T) {
@@ -83,11 +305,13 @@ func TestActive(test *testing.T) {
 
 	other.EXPECT().Ready()
 	arbiter.EXPECT().Ready()
+	expectPosition(me, perform)
 
 	other.EXPECT().GetDBRole().Return("active", nil)
+	me.EXPECT().GetDBRole().Return("initialized", nil)
 	perform.EXPECT().TransitionToBackup()
 
-	monitor.NewDecider(me, other, arbiter, perform)
+	monitor.NewDecider(me, []state.State{other}, arbiter, perform, config.Config{})
 }
 
 func TestBackup(test *testing.T) {
@@ -101,11 +325,13 @@ func TestBackup(test *testing.T) {
 
 	other.EXPECT().Ready()
 	arbiter.EXPECT().Ready()
+	expectPosition(me, perform)
 
 	other.EXPECT().GetDBRole().Return("backup", nil)
+	me.EXPECT().GetDBRole().Return("active", nil)
 	perform.EXPECT().TransitionToActive()
 
-	monitor.NewDecider(me, other, arbiter, perform)
+	monitor.NewDecider(me, []state.State{other}, arbiter, perform, config.Config{})
 }
 
 func TestOtherDead(test *testing.T) {
@@ -120,6 +346,7 @@ func TestOtherDead(test *testing.T) {
 
 	other.EXPECT().Ready()
 	arbiter.EXPECT().Ready()
+	expectPosition(me, perform)
 
 	other.EXPECT().GetDBRole().Return("", errors.New("dead"))
 	other.EXPECT().Location().Return("127.0.0.1:1234")
@@ -130,7 +357,34 @@ func TestOtherDead(test *testing.T) {
 
 	perform.EXPECT().TransitionToSingle()
 
-	monitor.NewDecider(me, other, arbiter, perform)
+	monitor.NewDecider(me, []state.State{other}, arbiter, perform, config.Config{})
+}
+
+func TestConcurrentProbes(test *testing.T) {
+	ctrl := gomock.NewController(test)
+	defer ctrl.Finish()
+
+	me := mock_state.NewMockState(ctrl)
+	other := mock_state.NewMockState(ctrl)
+	bounce := mock_state.NewMockState(ctrl)
+	arbiter := mock_state.NewMockState(ctrl)
+	perform := mock_monitor.NewMockPerformer(ctrl)
+
+	other.EXPECT().Ready()
+	arbiter.EXPECT().Ready()
+	expectPosition(me, perform)
+
+	// the arbiter is asked even though the node answers, and the node is believed
+	// over the arbiter that can't reach it
+	other.EXPECT().GetDBRole().Return("active", nil)
+	other.EXPECT().Location().Return("127.0.0.1:1234")
+	arbiter.EXPECT().Bounce("127.0.0.1:1234").Return(bounce)
+	bounce.EXPECT().GetDBRole().Return("dead", nil)
+
+	me.EXPECT().GetDBRole().Return("initialized", nil)
+	perform.EXPECT().TransitionToBackup()
+
+	monitor.NewDecider(me, []state.State{other}, arbiter, perform, config.Config{ConcurrentProbes: true})
 }
 
 func TestOtherDeadButSingle(test *testing.T) {
@@ -145,6 +399,7 @@ func TestOtherDeadButSingle(test *testing.T) {
 
 	other.EXPECT().Ready()
 	arbiter.EXPECT().Ready()
+	expectPosition(me, perform)
 
 	other.EXPECT().GetDBRole().Return("", errors.New("dead"))
 	other.EXPECT().Location().Return("127.0.0.1:1234")
@@ -153,7 +408,7 @@ func TestOtherDeadButSingle(test *testing.T) {
 
 	me.EXPECT().GetDBRole().Return("single", nil)
 
-	monitor.NewDecider(me, other, arbiter, perform)
+	monitor.NewDecider(me, []state.State{other}, arbiter, perform, config.Config{})
 }
 
 func TestOtherDeadBackup(test *testing.T) {
@@ -168,6 +423,7 @@ func TestOtherDeadBackup(test *testing.T) {
 
 	other.EXPECT().Ready()
 	arbiter.EXPECT().Ready()
+	expectPosition(me, perform)
 
 	other.EXPECT().GetDBRole().Return("", errors.New("dead"))
 	other.EXPECT().Location().Return("127.0.0.1:1234")
@@ -179,7 +435,7 @@ func TestOtherDeadBackup(test *testing.T) {
 
 	perform.EXPECT().TransitionToSingle()
 
-	monitor.NewDecider(me, other, arbiter, perform)
+	monitor.NewDecider(me, []state.State{other}, arbiter, perform, config.Config{})
 }
 
 func TestOtherDeadBackupNotSync(test *testing.T) {
@@ -192,8 +448,9 @@ func TestOtherDeadBackupNotSync(test *testing.T) {
 	arbiter := mock_state.NewMockState(ctrl)
 	perform := mock_monitor.NewMockPerformer(ctrl)
 
-	other.EXPECT().Ready().Times(2)
-	arbiter.EXPECT().Ready().Times(2)
+	other.EXPECT().Ready()
+	arbiter.EXPECT().Ready()
+	expectPosition(me, perform)
 
 	other.EXPECT().GetDBRole().Return("", errors.New("dead")).Times(2)
 	other.EXPECT().Location().Return("127.0.0.1:1234").Times(2)
@@ -209,7 +466,39 @@ func TestOtherDeadBackupNotSync(test *testing.T) {
 
 	perform.EXPECT().TransitionToSingle()
 
-	monitor.NewDecider(me, other, arbiter, perform)
+	monitor.NewDecider(me, []state.State{other}, arbiter, perform, config.Config{})
+}
+
+func TestOtherDeadBackupLagging(test *testing.T) {
+	ctrl := gomock.NewController(test)
+	defer ctrl.Finish()
+
+	me := mock_state.NewMockState(ctrl)
+	other := mock_state.NewMockState(ctrl)
+	bounce := mock_state.NewMockState(ctrl)
+	arbiter := mock_state.NewMockState(ctrl)
+	perform := mock_monitor.NewMockPerformer(ctrl)
+
+	other.EXPECT().Ready()
+	arbiter.EXPECT().Ready()
+	expectPosition(me, perform)
+
+	other.EXPECT().GetDBRole().Return("", errors.New("dead"))
+	other.EXPECT().Location().Return("127.0.0.1:1234")
+	arbiter.EXPECT().Bounce("127.0.0.1:1234").Return(bounce)
+	bounce.EXPECT().GetDBRole().Return("dead", nil)
+
+	me.EXPECT().GetDBRole().Return("backup", nil)
+	me.EXPECT().HasSynced().Return(true, nil)
+
+	// this node was too far behind to take over on its own
+	me.EXPECT().Lag().Return(time.Duration(0), int64(500), nil)
+
+	_, err := monitor.NewDecider(me, []state.State{other}, arbiter, perform, config.Config{MaxAllowedLagBytes: 100})
+	if err != nil {
+		test.Log(err)
+		test.FailNow()
+	}
 }
 
 func TestOtherTemporaryDead(test *testing.T) {
@@ -222,8 +511,9 @@ func TestOtherTemporaryDead(test *testing.T) {
 	arbiter := mock_state.NewMockState(ctrl)
 	perform := mock_monitor.NewMockPerformer(ctrl)
 
-	other.EXPECT().Ready().Times(2)
-	arbiter.EXPECT().Ready().Times(2)
+	other.EXPECT().Ready()
+	arbiter.EXPECT().Ready()
+	expectPosition(me, perform)
 
 	other.EXPECT().GetDBRole().Return("", errors.New("dead")).Times(2)
 	other.EXPECT().Location().Return("127.0.0.1:1234").Times(2)
@@ -239,5 +529,1339 @@ func TestOtherTemporaryDead(test *testing.T) {
 
 	perform.EXPECT().TransitionToSingle()
 
-	monitor.NewDecider(me, other, arbiter, perform)
+	monitor.NewDecider(me, []state.State{other}, arbiter, perform, config.Config{})
+}
+
+func TestElectFurthestBackup(test *testing.T) {
+	ctrl := gomock.NewController(test)
+	defer ctrl.Finish()
+
+	me := mock_state.NewMockState(ctrl)
+	dead := mock_state.NewMockState(ctrl)
+	behind := mock_state.NewMockState(ctrl)
+	bounce := mock_state.NewMockState(ctrl)
+	arbiter := mock_state.NewMockState(ctrl)
+	perform := mock_monitor.NewMockPerformer(ctrl)
+
+	dead.EXPECT().Ready()
+	behind.EXPECT().Ready()
+	arbiter.EXPECT().Ready()
+	expectPosition(me, perform)
+
+	dead.EXPECT().GetDBRole().Return("", errors.New("dead"))
+	dead.EXPECT().Location().Return("127.0.0.1:1234")
+	arbiter.EXPECT().Bounce("127.0.0.1:1234").Return(bounce)
+	bounce.EXPECT().GetDBRole().Return("dead", nil)
+
+	behind.EXPECT().GetDBRole().Return("backup", nil)
+
+	me.EXPECT().GetDBRole().Return("backup", nil)
+	me.EXPECT().HasSynced().Return(true, nil)
+	me.EXPECT().Location().Return("127.0.0.1:2345")
+	me.EXPECT().GetPosition().Return(uint64(20), nil)
+
+	behind.EXPECT().HasSynced().Return(true, nil)
+	behind.EXPECT().GetPosition().Return(uint64(10), nil)
+
+	perform.EXPECT().TransitionToSingle()
+
+	monitor.NewDecider(me, []state.State{dead, behind}, arbiter, perform, config.Config{})
+}
+
+func TestElectWaitsForFurthestBackup(test *testing.T) {
+	ctrl := gomock.NewController(test)
+	defer ctrl.Finish()
+
+	me := mock_state.NewMockState(ctrl)
+	dead := mock_state.NewMockState(ctrl)
+	ahead := mock_state.NewMockState(ctrl)
+	bounce := mock_state.NewMockState(ctrl)
+	arbiter := mock_state.NewMockState(ctrl)
+	perform := mock_monitor.NewMockPerformer(ctrl)
+
+	dead.EXPECT().Ready()
+	ahead.EXPECT().Ready()
+	arbiter.EXPECT().Ready()
+	expectPosition(me, perform)
+
+	dead.EXPECT().GetDBRole().Return("", errors.New("dead"))
+	dead.EXPECT().Location().Return("127.0.0.1:1234")
+	arbiter.EXPECT().Bounce("127.0.0.1:1234").Return(bounce)
+	bounce.EXPECT().GetDBRole().Return("dead", nil)
+
+	ahead.EXPECT().GetDBRole().Return("backup", nil)
+
+	me.EXPECT().GetDBRole().Return("backup", nil)
+	me.EXPECT().HasSynced().Return(true, nil)
+	me.EXPECT().Location().Return("127.0.0.1:2345")
+	me.EXPECT().GetPosition().Return(uint64(10), nil)
+
+	ahead.EXPECT().HasSynced().Return(true, nil)
+	ahead.EXPECT().GetPosition().Return(uint64(20), nil)
+	ahead.EXPECT().Location().Return("127.0.0.1:3456").AnyTimes()
+
+	monitor.NewDecider(me, []state.State{dead, ahead}, arbiter, perform, config.Config{})
+}
+
+func TestSplitBrainHalts(test *testing.T) {
+	ctrl := gomock.NewController(test)
+	defer ctrl.Finish()
+
+	me := mock_state.NewMockState(ctrl)
+	other := mock_state.NewMockState(ctrl)
+	arbiter := mock_state.NewMockState(ctrl)
+	seen := mock_state.NewMockState(ctrl)
+	perform := mock_monitor.NewMockPerformer(ctrl)
+
+	other.EXPECT().Ready()
+	arbiter.EXPECT().Ready()
+	expectPosition(me, perform)
+
+	other.EXPECT().GetDBRole().Return("active", nil)
+	other.EXPECT().Location().Return("127.0.0.1:4401")
+	me.EXPECT().GetDBRole().Return("active", nil)
+	arbiter.EXPECT().Bounce("127.0.0.1:4401").Return(seen)
+	seen.EXPECT().GetDBRole().Return("active", nil)
+	perform.EXPECT().Stop()
+
+	_, err := monitor.NewDecider(me, []state.State{other}, arbiter, perform, config.Config{StartupAttempts: 1})
+	if err != monitor.SplitBrain {
+		test.Logf("wrong error was returned '%v'", err)
+		test.Fail()
+	}
+}
+
+func TestReturnedActiveFollows(test *testing.T) {
+	ctrl := gomock.NewController(test)
+	defer ctrl.Finish()
+
+	me := mock_state.NewMockState(ctrl)
+	other := mock_state.NewMockState(ctrl)
+	arbiter := mock_state.NewMockState(ctrl)
+	perform := mock_monitor.NewMockPerformer(ctrl)
+
+	other.EXPECT().Ready()
+	arbiter.EXPECT().Ready()
+	expectPosition(me, perform)
+
+	// this node crashed while it was active, and the backup took over since
+	other.EXPECT().GetDBRole().Return("single", nil)
+	other.EXPECT().Location().Return("127.0.0.1:4401").AnyTimes()
+	other.EXPECT().GetPosition().Return(uint64(64), nil)
+	me.EXPECT().GetDBRole().Return("active", nil)
+	perform.EXPECT().TransitionToBackup()
+
+	history := state.History{DBRole: "active"}
+	monitor.NewDecider(restarted{me, history}, []state.State{other}, arbiter, perform, config.Config{})
+}
+
+func TestReturnedTogetherIsSplitBrain(test *testing.T) {
+	ctrl := gomock.NewController(test)
+	defer ctrl.Finish()
+
+	me := mock_state.NewMockState(ctrl)
+	other := mock_state.NewMockState(ctrl)
+	arbiter := mock_state.NewMockState(ctrl)
+	seen := mock_state.NewMockState(ctrl)
+	perform := mock_monitor.NewMockPerformer(ctrl)
+
+	other.EXPECT().Ready()
+	arbiter.EXPECT().Ready()
+	expectPosition(me, perform)
+
+	// the other node came back writable too, and has not written anything yet
+	other.EXPECT().GetDBRole().Return("active", nil)
+	other.EXPECT().Location().Return("127.0.0.1:4401").AnyTimes()
+	other.EXPECT().GetPosition().Return(uint64(0), nil)
+	me.EXPECT().GetDBRole().Return("active", nil)
+	arbiter.EXPECT().Bounce("127.0.0.1:4401").Return(seen)
+	seen.EXPECT().GetDBRole().Return("active", nil)
+	perform.EXPECT().Stop()
+
+	history := state.History{DBRole: "active"}
+	_, err := monitor.NewDecider(restarted{me, history}, []state.State{other}, arbiter, perform, config.Config{StartupAttempts: 1})
+	if err != monitor.SplitBrain {
+		test.Logf("wrong error was returned '%v'", err)
+		test.Fail()
+	}
+}
+
+func TestSplitBrainPosition(test *testing.T) {
+	ctrl := gomock.NewController(test)
+	defer ctrl.Finish()
+
+	me := mock_state.NewMockState(ctrl)
+	other := mock_state.NewMockState(ctrl)
+	arbiter := mock_state.NewMockState(ctrl)
+	seen := mock_state.NewMockState(ctrl)
+	perform := mock_monitor.NewMockPerformer(ctrl)
+
+	other.EXPECT().Ready()
+	arbiter.EXPECT().Ready()
+	perform.EXPECT().Position().Return(uint64(10), nil).AnyTimes()
+	me.EXPECT().SetPosition(uint64(10))
+
+	// the other node has written further, so this node steps down
+	other.EXPECT().GetDBRole().Return("single", nil)
+	other.EXPECT().Location().Return("127.0.0.1:4401")
+	other.EXPECT().GetPosition().Return(uint64(20), nil)
+	me.EXPECT().GetDBRole().Return("active", nil)
+	arbiter.EXPECT().Bounce("127.0.0.1:4401").Return(seen)
+	seen.EXPECT().GetDBRole().Return("single", nil)
+	perform.EXPECT().TransitionToBackup()
+
+	_, err := monitor.NewDecider(me, []state.State{other}, arbiter, perform, config.Config{SplitBrainPolicy: "position"})
+	if err != nil {
+		test.Log(err)
+		test.FailNow()
+	}
+}
+
+func TestSplitBrainPrimary(test *testing.T) {
+	ctrl := gomock.NewController(test)
+	defer ctrl.Finish()
+
+	me := mock_state.NewMockState(ctrl)
+	other := mock_state.NewMockState(ctrl)
+	arbiter := mock_state.NewMockState(ctrl)
+	seen := mock_state.NewMockState(ctrl)
+	perform := mock_monitor.NewMockPerformer(ctrl)
+
+	other.EXPECT().Ready()
+	arbiter.EXPECT().Ready()
+	expectPosition(me, perform)
+
+	// the primary stays active and waits for the other node to step down
+	other.EXPECT().GetDBRole().Return("active", nil)
+	other.EXPECT().Location().Return("127.0.0.1:4401")
+	me.EXPECT().GetDBRole().Return("active", nil)
+	me.EXPECT().GetRole().Return("primary", nil)
+	arbiter.EXPECT().Bounce("127.0.0.1:4401").Return(seen)
+	seen.EXPECT().GetDBRole().Return("active", nil)
+
+	_, err := monitor.NewDecider(me, []state.State{other}, arbiter, perform, config.Config{SplitBrainPolicy: "primary"})
+	if err != nil {
+		test.Log(err)
+		test.FailNow()
+	}
+}
+
+func TestSplitBrainAlreadyResolved(test *testing.T) {
+	ctrl := gomock.NewController(test)
+	defer ctrl.Finish()
+
+	me := mock_state.NewMockState(ctrl)
+	other := mock_state.NewMockState(ctrl)
+	arbiter := mock_state.NewMockState(ctrl)
+	seen := mock_state.NewMockState(ctrl)
+	perform := mock_monitor.NewMockPerformer(ctrl)
+
+	other.EXPECT().Ready()
+	arbiter.EXPECT().Ready()
+	expectPosition(me, perform)
+
+	// the monitor sees that the other node has already stepped down
+	other.EXPECT().GetDBRole().Return("active", nil)
+	other.EXPECT().Location().Return("127.0.0.1:4401")
+	me.EXPECT().GetDBRole().Return("active", nil)
+	arbiter.EXPECT().Bounce("127.0.0.1:4401").Return(seen)
+	seen.EXPECT().GetDBRole().Return("backup", nil)
+
+	_, err := monitor.NewDecider(me, []state.State{other}, arbiter, perform, config.Config{})
+	if err != nil {
+		test.Log(err)
+		test.FailNow()
+	}
+}
+
+func TestDryRun(test *testing.T) {
+	ctrl := gomock.NewController(test)
+	defer ctrl.Finish()
+
+	me := mock_state.NewMockState(ctrl)
+	other := mock_state.NewMockState(ctrl)
+	arbiter := mock_state.NewMockState(ctrl)
+	perform := mock_monitor.NewMockPerformer(ctrl)
+
+	other.EXPECT().Ready()
+	arbiter.EXPECT().Ready()
+	expectPosition(me, perform)
+
+	// the performer is never asked to do anything
+	other.EXPECT().GetDBRole().Return("initialized", nil).Times(2)
+	me.EXPECT().GetRole().Return("primary", nil).Times(2)
+
+	decider, err := monitor.NewDecider(me, []state.State{other}, arbiter, perform, config.Config{DryRun: true})
+	if err != nil {
+		test.Log(err)
+		test.FailNow()
+	}
+	enabled, plan := decider.Planned()
+	if !enabled || plan == nil || plan.Transition != "active" {
+		test.Logf("wrong plan %v %v", enabled, plan)
+		test.Fail()
+	}
+
+	// once it is turned off the transition happens
+	decider.DryRun(false)
+	perform.EXPECT().TransitionToActive()
+	if err := decider.ReCheck(); err != nil {
+		test.Log(err)
+		test.FailNow()
+	}
+	if _, plan := decider.Planned(); plan != nil {
+		test.Logf("nothing should have been planned %v", plan)
+		test.Fail()
+	}
+}
+
+func TestMaintenance(test *testing.T) {
+	ctrl := gomock.NewController(test)
+	defer ctrl.Finish()
+
+	me := &maintained{MockState: mock_state.NewMockState(ctrl)}
+	other := &maintained{MockState: mock_state.NewMockState(ctrl)}
+	arbiter := mock_state.NewMockState(ctrl)
+	perform := mock_monitor.NewMockPerformer(ctrl)
+
+	other.EXPECT().Ready()
+	arbiter.EXPECT().Ready()
+	expectPosition(me.MockState, perform)
+	other.EXPECT().GetDBRole().Return("active", nil)
+	me.EXPECT().GetDBRole().Return("initialized", nil)
+	perform.EXPECT().TransitionToBackup()
+
+	decider, err := monitor.NewDecider(me, []state.State{other}, arbiter, perform, config.Config{})
+	if err != nil {
+		test.Log(err)
+		test.FailNow()
+	}
+
+	// maintenance was switched on at the other node, this node picks it up and
+	// only plans the transition
+	other.maintenance = state.Maintenance{Enabled: true, Changed: time.Now()}
+	other.EXPECT().GetDBRole().Return("single", nil)
+	me.EXPECT().GetDBRole().Return("initialized", nil)
+	if err := decider.ReCheck(); err != nil {
+		test.Log(err)
+		test.FailNow()
+	}
+	if !decider.InMaintenance() || !me.maintenance.Enabled {
+		test.Log("the node should have picked up the maintenance")
+		test.Fail()
+	}
+	if _, plan := decider.Planned(); plan == nil || plan.Transition != "backup" {
+		test.Logf("wrong plan %v", plan)
+		test.Fail()
+	}
+
+	// switching it off here wins over the older switch of the other node
+	if err := decider.Maintenance(false); err != nil {
+		test.Log(err)
+		test.FailNow()
+	}
+	other.EXPECT().GetDBRole().Return("single", nil)
+	me.EXPECT().GetDBRole().Return("initialized", nil)
+	perform.EXPECT().TransitionToBackup()
+	if err := decider.ReCheck(); err != nil {
+		test.Log(err)
+		test.FailNow()
+	}
+	if decider.InMaintenance() {
+		test.Log("the node should be out of maintenance")
+		test.Fail()
+	}
+}
+
+func TestSwitchover(test *testing.T) {
+	ctrl := gomock.NewController(test)
+	defer ctrl.Finish()
+
+	me := mock_state.NewMockState(ctrl)
+	other := mock_state.NewMockState(ctrl)
+	arbiter := mock_state.NewMockState(ctrl)
+	perform := mock_monitor.NewMockPerformer(ctrl)
+
+	other.EXPECT().Ready()
+	arbiter.EXPECT().Ready()
+
+	perform.EXPECT().Position().Return(uint64(0), errors.New("not running"))
+	other.EXPECT().GetDBRole().Return("backup", nil)
+	me.EXPECT().GetDBRole().Return("active", nil)
+	perform.EXPECT().TransitionToActive()
+
+	decider, _ := monitor.NewDecider(me, []state.State{other}, arbiter, perform, config.Config{})
+
+	me.EXPECT().GetDBRole().Return("active", nil)
+	perform.EXPECT().Position().Return(uint64(20), nil)
+	me.EXPECT().SetPosition(uint64(20)).Return(nil)
+	other.EXPECT().GetDBRole().Return("backup", nil)
+	other.EXPECT().HasSynced().Return(true, nil)
+	other.EXPECT().GetPosition().Return(uint64(20), nil)
+	other.EXPECT().Location().Return("127.0.0.1:1234")
+
+	perform.EXPECT().Stop()
+	me.EXPECT().SetSynced(false).Return(nil)
+	me.EXPECT().SetDBRole("demoted").Return(nil)
+
+	if err := decider.Switchover(time.Second); err != nil {
+		test.Log(err)
+		test.FailNow()
+	}
+}
+
+// a performer that records how it was drained
+type drainingPerformer struct {
+	*mock_monitor.MockPerformer
+	timeout   time.Duration
+	terminate bool
+}
+
+func (perform *drainingPerformer) Drain(timeout time.Duration, terminate bool) error {
+	perform.timeout, perform.terminate = timeout, terminate
+	return nil
+}
+
+func TestSwitchoverDrains(test *testing.T) {
+	ctrl := gomock.NewController(test)
+	defer ctrl.Finish()
+
+	me := mock_state.NewMockState(ctrl)
+	other := mock_state.NewMockState(ctrl)
+	arbiter := mock_state.NewMockState(ctrl)
+	perform := &drainingPerformer{MockPerformer: mock_monitor.NewMockPerformer(ctrl)}
+
+	other.EXPECT().Ready()
+	arbiter.EXPECT().Ready()
+	perform.EXPECT().Position().Return(uint64(0), errors.New("not running"))
+	other.EXPECT().GetDBRole().Return("backup", nil)
+	me.EXPECT().GetDBRole().Return("active", nil)
+	perform.EXPECT().TransitionToActive()
+
+	decider, _ := monitor.NewDecider(me, []state.State{other}, arbiter, perform, config.Config{DrainTimeout: 5, DrainTerminate: true})
+
+	me.EXPECT().GetDBRole().Return("active", nil)
+	perform.EXPECT().Position().Return(uint64(20), nil)
+	me.EXPECT().SetPosition(uint64(20)).Return(nil)
+	other.EXPECT().GetDBRole().Return("backup", nil)
+	other.EXPECT().HasSynced().Return(true, nil)
+	other.EXPECT().GetPosition().Return(uint64(20), nil)
+	other.EXPECT().Location().Return("127.0.0.1:1234")
+	perform.EXPECT().Stop().Do(func() {
+		if perform.timeout != 5*time.Second || !perform.terminate {
+			test.Logf("the clients should have been drained before the database was stopped, not %v %v", perform.timeout, perform.terminate)
+			test.Fail()
+		}
+	})
+	me.EXPECT().SetSynced(false).Return(nil)
+	me.EXPECT().SetDBRole("demoted").Return(nil)
+
+	if err := decider.Switchover(time.Second); err != nil {
+		test.Log(err)
+		test.FailNow()
+	}
+}
+
+// a state that takes part in passing the promotion of a DR cluster around
+type promoted struct {
+	*mock_state.MockState
+	promotion state.Promotion
+}
+
+func (other *promoted) GetPromotion() (state.Promotion, error) {
+	return other.promotion, nil
+}
+
+func (other *promoted) SetPromotion(promotion state.Promotion) error {
+	other.promotion = promotion
+	return nil
+}
+
+// a performer that tracks a source cluster
+type trackingPerformer struct {
+	*mock_monitor.MockPerformer
+	writable bool
+	promoted int
+}
+
+func (perform *trackingPerformer) SourceWritable() bool {
+	return perform.writable
+}
+
+func (perform *trackingPerformer) PromoteDR() error {
+	perform.promoted++
+	return nil
+}
+
+func TestPromoteDR(test *testing.T) {
+	ctrl := gomock.NewController(test)
+	defer ctrl.Finish()
+
+	me := &promoted{MockState: mock_state.NewMockState(ctrl)}
+	other := &promoted{MockState: mock_state.NewMockState(ctrl)}
+	arbiter := mock_state.NewMockState(ctrl)
+	perform := &trackingPerformer{MockPerformer: mock_monitor.NewMockPerformer(ctrl), writable: true}
+
+	other.EXPECT().Ready()
+	arbiter.EXPECT().Ready()
+	expectPosition(me.MockState, perform.MockPerformer)
+	other.EXPECT().GetDBRole().Return("backup", nil).AnyTimes()
+	me.EXPECT().GetDBRole().Return("active", nil).AnyTimes()
+	perform.EXPECT().TransitionToActive()
+
+	decider, err := monitor.NewDecider(me, []state.State{other}, arbiter, perform, config.Config{DRSource: "10.0.1.1:4400"})
+	if err != nil {
+		test.Log(err)
+		test.FailNow()
+	}
+	if perform.promoted != 0 || me.promotion.Promoted {
+		test.Log("the DR cluster should not have been promoted while the source cluster is there")
+		test.Fail()
+	}
+
+	// the DR cluster was promoted through the other node, the writable node picks
+	// it up and takes writes
+	other.promotion = state.Promotion{Promoted: true, Changed: time.Now()}
+	if err := decider.ReCheck(); err != nil {
+		test.Log(err)
+		test.FailNow()
+	}
+	if perform.promoted == 0 || !me.promotion.Promoted {
+		test.Log("the node should have picked up the promotion")
+		test.Fail()
+	}
+
+	// a cluster that isn't the DR cluster of another one can't be promoted
+	backup := &promoted{MockState: mock_state.NewMockState(ctrl)}
+	active := mock_state.NewMockState(ctrl)
+	followed := mock_monitor.NewMockPerformer(ctrl)
+	active.EXPECT().Ready()
+	arbiter.EXPECT().Ready()
+	expectPosition(backup.MockState, followed)
+	active.EXPECT().GetDBRole().Return("active", nil)
+	backup.EXPECT().GetDBRole().Return("initialized", nil)
+	followed.EXPECT().TransitionToBackup()
+	decider, _ = monitor.NewDecider(backup, []state.State{active}, arbiter, followed, config.Config{})
+	if err := decider.PromoteDR(); err != monitor.NotDR {
+		test.Logf("wrong error was returned '%v'", err)
+		test.Fail()
+	}
+}
+
+// an arbiter that hands the promotion lease to holder, until it can't be reached
+type leasing struct {
+	*mock_state.MockState
+	holder string
+	err    error
+}
+
+func (arbiter *leasing) AcquireLease(cluster, holder string, ttl time.Duration) (state.Lease, error) {
+	if arbiter.err != nil {
+		return state.Lease{}, arbiter.err
+	}
+	return state.Lease{Holder: arbiter.holder, Expires: time.Now().Add(ttl)}, nil
+}
+
+func (arbiter *leasing) ReleaseLease(cluster, holder string) error {
+	return nil
+}
+
+func TestPromotionLease(test *testing.T) {
+	for _, holder := range []string{"10.0.0.3:4400", "10.0.0.2:4400"} {
+		ctrl := gomock.NewController(test)
+		me := mock_state.NewMockState(ctrl)
+		other := mock_state.NewMockState(ctrl)
+		bounce := mock_state.NewMockState(ctrl)
+		arbiter := &leasing{MockState: mock_state.NewMockState(ctrl), holder: holder}
+		perform := mock_monitor.NewMockPerformer(ctrl)
+
+		other.EXPECT().Ready()
+		arbiter.EXPECT().Ready()
+		expectPosition(me, perform)
+		other.EXPECT().GetDBRole().Return("", errors.New("dead"))
+		other.EXPECT().Location().Return("127.0.0.1:1234")
+		arbiter.EXPECT().Bounce("127.0.0.1:1234").Return(bounce)
+		bounce.EXPECT().GetDBRole().Return("dead", nil)
+		me.EXPECT().GetDBRole().Return("backup", nil).AnyTimes()
+		me.EXPECT().HasSynced().Return(true, nil)
+		me.EXPECT().Location().Return("10.0.0.2:4400").AnyTimes()
+
+		// the backup only takes over while it holds the lease
+		if holder == "10.0.0.2:4400" {
+			perform.EXPECT().TransitionToSingle()
+		}
+		_, err := monitor.NewDecider(me, []state.State{other}, arbiter, perform, config.Config{PromotionLease: 10, StartupAttempts: 1})
+		if holder != "10.0.0.2:4400" && err != monitor.NoLease {
+			test.Logf("should not have taken over while '%v' holds the lease (%v)", holder, err)
+			test.Fail()
+		}
+		if holder == "10.0.0.2:4400" && err != nil {
+			test.Logf("should have taken over with the lease (%v)", err)
+			test.Fail()
+		}
+		ctrl.Finish()
+	}
+}
+
+// a clock that only moves when the test moves it
+type stepClock struct {
+	sync.Mutex
+	now time.Time
+}
+
+func (clock *stepClock) Now() time.Time {
+	clock.Lock()
+	defer clock.Unlock()
+	return clock.now
+}
+
+func (clock *stepClock) Advance(by time.Duration) {
+	clock.Lock()
+	defer clock.Unlock()
+	clock.now = clock.now.Add(by)
+}
+
+func TestPromotionLeaseRunsOut(test *testing.T) {
+	ctrl := gomock.NewController(test)
+	defer ctrl.Finish()
+	clock := &stepClock{now: time.Now()}
+	monitor.SetClock(clock)
+	defer monitor.SetClock(nil)
+
+	me := mock_state.NewMockState(ctrl)
+	other := mock_state.NewMockState(ctrl)
+	bounce := mock_state.NewMockState(ctrl)
+	arbiter := &leasing{MockState: mock_state.NewMockState(ctrl), holder: "10.0.0.2:4400"}
+	perform := mock_monitor.NewMockPerformer(ctrl)
+
+	role := "backup"
+	other.EXPECT().Ready()
+	arbiter.EXPECT().Ready()
+	expectPosition(me, perform)
+	other.EXPECT().GetDBRole().Return("", errors.New("dead")).AnyTimes()
+	other.EXPECT().Location().Return("127.0.0.1:1234").AnyTimes()
+	arbiter.EXPECT().Bounce("127.0.0.1:1234").Return(bounce).AnyTimes()
+	bounce.EXPECT().GetDBRole().Return("dead", nil).AnyTimes()
+	me.EXPECT().GetDBRole().DoAndReturn(func() (string, error) { return role, nil }).AnyTimes()
+	me.EXPECT().HasSynced().Return(true, nil).AnyTimes()
+	me.EXPECT().Location().Return("10.0.0.2:4400").AnyTimes()
+
+	perform.EXPECT().TransitionToSingle().Do(func() { role = "single" })
+	decider, err := monitor.NewDecider(me, []state.State{other}, arbiter, perform, config.Config{PromotionLease: 20, StartupAttempts: 1})
+	if err != nil {
+		test.Log(err)
+		test.FailNow()
+	}
+
+	// the arbiter goes away, the node keeps taking writes while the lease lasts
+	arbiter.err = errors.New("unreachable")
+	clock.Advance(10 * time.Second)
+	decider.ReCheck()
+
+	// and stops before it runs out
+	perform.EXPECT().Stop().Do(func() { role = "dead" })
+	clock.Advance(6 * time.Second)
+	decider.ReCheck()
+}
+
+func TestSwitchoverNotActive(test *testing.T) {
+	ctrl := gomock.NewController(test)
+	defer ctrl.Finish()
+
+	me := mock_state.NewMockState(ctrl)
+	other := mock_state.NewMockState(ctrl)
+	arbiter := mock_state.NewMockState(ctrl)
+	perform := mock_monitor.NewMockPerformer(ctrl)
+
+	other.EXPECT().Ready()
+	arbiter.EXPECT().Ready()
+	expectPosition(me, perform)
+
+	other.EXPECT().GetDBRole().Return("active", nil)
+	me.EXPECT().GetDBRole().Return("initialized", nil)
+	perform.EXPECT().TransitionToBackup()
+
+	decider, _ := monitor.NewDecider(me, []state.State{other}, arbiter, perform, config.Config{})
+
+	me.EXPECT().GetDBRole().Return("backup", nil)
+	if err := decider.Switchover(time.Second); err != monitor.NotActive {
+		test.Logf("wrong error was returned '%v'", err)
+		test.Fail()
+	}
+}
+
+func TestDemotedFollowsNewActive(test *testing.T) {
+	ctrl := gomock.NewController(test)
+	defer ctrl.Finish()
+
+	me := mock_state.NewMockState(ctrl)
+	other := mock_state.NewMockState(ctrl)
+	arbiter := mock_state.NewMockState(ctrl)
+	perform := mock_monitor.NewMockPerformer(ctrl)
+
+	other.EXPECT().Ready()
+	arbiter.EXPECT().Ready()
+	expectPosition(me, perform)
+
+	// the backup has not taken over yet, so the demoted node waits
+	other.EXPECT().GetDBRole().Return("backup", nil)
+	me.EXPECT().GetDBRole().Return("demoted", nil)
+
+	decider, _ := monitor.NewDecider(me, []state.State{other}, arbiter, perform, config.Config{})
+
+	other.EXPECT().GetDBRole().Return("single", nil)
+	me.EXPECT().GetDBRole().Return("demoted", nil)
+	perform.EXPECT().TransitionToBackup()
+
+	if err := decider.ReCheck(); err != nil {
+		test.Log(err)
+		test.FailNow()
+	}
+}
+
+func TestSingleSyncsDemoted(test *testing.T) {
+	ctrl := gomock.NewController(test)
+	defer ctrl.Finish()
+
+	me := mock_state.NewMockState(ctrl)
+	other := mock_state.NewMockState(ctrl)
+	arbiter := mock_state.NewMockState(ctrl)
+	perform := mock_monitor.NewMockPerformer(ctrl)
+
+	other.EXPECT().Ready()
+	arbiter.EXPECT().Ready()
+	expectPosition(me, perform)
+
+	other.EXPECT().GetDBRole().Return("demoted", nil)
+	me.EXPECT().GetDBRole().Return("single", nil)
+	perform.EXPECT().TransitionToActive()
+
+	monitor.NewDecider(me, []state.State{other}, arbiter, perform, config.Config{})
+}
+
+func TestPeerFailures(test *testing.T) {
+	ctrl := gomock.NewController(test)
+	defer ctrl.Finish()
+
+	me := mock_state.NewMockState(ctrl)
+	other := mock_state.NewMockState(ctrl)
+	bounce := mock_state.NewMockState(ctrl)
+	arbiter := mock_state.NewMockState(ctrl)
+	perform := mock_monitor.NewMockPerformer(ctrl)
+
+	other.EXPECT().Ready()
+	arbiter.EXPECT().Ready()
+	expectPosition(me, perform)
+
+	other.EXPECT().GetDBRole().Return("single", nil)
+	me.EXPECT().GetDBRole().Return("initialized", nil)
+	perform.EXPECT().TransitionToBackup()
+
+	decider, err := monitor.NewDecider(me, []state.State{other}, arbiter, perform, config.Config{PeerFailures: 2})
+	if err != nil {
+		test.Log(err)
+		test.FailNow()
+	}
+
+	// the first failed check still treats the other node as single
+	other.EXPECT().GetDBRole().Return("", errors.New("dead")).Times(2)
+	other.EXPECT().Location().Return("127.0.0.1:1234").AnyTimes()
+	arbiter.EXPECT().Bounce("127.0.0.1:1234").Return(bounce).Times(2)
+	bounce.EXPECT().GetDBRole().Return("", errors.New("dead")).Times(2)
+	me.EXPECT().GetDBRole().Return("backup", nil)
+	if err := decider.ReCheck(); err != nil {
+		test.Log(err)
+		test.FailNow()
+	}
+
+	// the second one gives up on it
+	me.EXPECT().GetDBRole().Return("backup", nil)
+	perform.EXPECT().Stop()
+	if err := decider.ReCheck(); err != monitor.ClusterUnaviable {
+		test.Logf("wrong error %v", err)
+		test.Fail()
+	}
+}
+
+// a performer that records how it was made read only
+type protectedPerformer struct {
+	*mock_monitor.MockPerformer
+	readOnly []bool
+}
+
+func (perform *protectedPerformer) ReadOnly(enabled bool) error {
+	perform.readOnly = append(perform.readOnly, enabled)
+	return nil
+}
+
+func TestReadOnlyAlone(test *testing.T) {
+	ctrl := gomock.NewController(test)
+	defer ctrl.Finish()
+
+	me := mock_state.NewMockState(ctrl)
+	other := mock_state.NewMockState(ctrl)
+	bounce := mock_state.NewMockState(ctrl)
+	arbiter := mock_state.NewMockState(ctrl)
+	perform := &protectedPerformer{MockPerformer: mock_monitor.NewMockPerformer(ctrl)}
+
+	other.EXPECT().Ready()
+	arbiter.EXPECT().Ready()
+	expectPosition(me, perform.MockPerformer)
+	other.EXPECT().GetDBRole().Return("backup", nil)
+	me.EXPECT().GetDBRole().Return("active", nil)
+	perform.EXPECT().TransitionToActive()
+
+	decider, err := monitor.NewDecider(me, []state.State{other}, arbiter, perform, config.Config{UnavailablePolicy: "read_only"})
+	if err != nil {
+		test.Log(err)
+		test.FailNow()
+	}
+
+	// the database keeps running, but turns down writes
+	other.EXPECT().GetDBRole().Return("", errors.New("dead")).Times(2)
+	other.EXPECT().Location().Return("127.0.0.1:1234").AnyTimes()
+	arbiter.EXPECT().Bounce("127.0.0.1:1234").Return(bounce).Times(2)
+	bounce.EXPECT().GetDBRole().Return("", errors.New("dead")).Times(2)
+	me.EXPECT().GetDBRole().Return("active", nil).Times(2)
+	for i := 0; i < 2; i++ {
+		if err := decider.ReCheck(); err != monitor.ClusterUnaviable {
+			test.Logf("wrong error %v", err)
+			test.Fail()
+		}
+	}
+
+	// and takes writes again once the backup is back
+	other.EXPECT().GetDBRole().Return("backup", nil)
+	me.EXPECT().GetDBRole().Return("active", nil)
+	if err := decider.ReCheck(); err != nil {
+		test.Log(err)
+		test.Fail()
+	}
+	if len(perform.readOnly) != 2 || !perform.readOnly[0] || perform.readOnly[1] {
+		test.Logf("the database should have been made read only once and then writable, not %v", perform.readOnly)
+		test.Fail()
+	}
+}
+
+func TestServeAlone(test *testing.T) {
+	ctrl := gomock.NewController(test)
+	defer ctrl.Finish()
+
+	me := mock_state.NewMockState(ctrl)
+	other := mock_state.NewMockState(ctrl)
+	bounce := mock_state.NewMockState(ctrl)
+	arbiter := mock_state.NewMockState(ctrl)
+	perform := mock_monitor.NewMockPerformer(ctrl)
+
+	other.EXPECT().Ready()
+	arbiter.EXPECT().Ready()
+	expectPosition(me, perform)
+
+	// the database is never stopped
+	other.EXPECT().GetDBRole().Return("", errors.New("dead"))
+	other.EXPECT().Location().Return("127.0.0.1:1234")
+	arbiter.EXPECT().Bounce("127.0.0.1:1234").Return(bounce)
+	bounce.EXPECT().GetDBRole().Return("", errors.New("dead"))
+	me.EXPECT().GetDBRole().Return("active", nil)
+
+	_, err := monitor.NewDecider(me, []state.State{other}, arbiter, perform, config.Config{StartupAttempts: 1, UnavailablePolicy: "serve"})
+	if err != monitor.ClusterUnaviable {
+		test.Logf("wrong error was returned '%v'", err)
+		test.Fail()
+	}
+}
+
+func TestStartupAttempts(test *testing.T) {
+	ctrl := gomock.NewController(test)
+	defer ctrl.Finish()
+
+	me := mock_state.NewMockState(ctrl)
+	other := mock_state.NewMockState(ctrl)
+	bounce := mock_state.NewMockState(ctrl)
+	arbiter := mock_state.NewMockState(ctrl)
+	perform := mock_monitor.NewMockPerformer(ctrl)
+
+	// the peers are asked if they are ready once, not on every attempt
+	other.EXPECT().Ready()
+	arbiter.EXPECT().Ready()
+	expectPosition(me, perform)
+
+	other.EXPECT().GetDBRole().Return("", errors.New("dead")).Times(2)
+	other.EXPECT().Location().Return("127.0.0.1:1234").Times(2)
+	arbiter.EXPECT().Bounce("127.0.0.1:1234").Return(bounce).Times(2)
+	bounce.EXPECT().GetDBRole().Return("", errors.New("dead")).Times(2)
+
+	// the database is only stopped once
+	me.EXPECT().GetDBRole().Return("active", nil).Times(2)
+	perform.EXPECT().Stop()
+
+	_, err := monitor.NewDecider(me, []state.State{other}, arbiter, perform, config.Config{StartupAttempts: 2})
+	if err != monitor.ClusterUnaviable {
+		test.Logf("wrong error was returned '%v'", err)
+		test.Fail()
+	}
+}
+
+func TestBackoff(test *testing.T) {
+	policy := monitor.RetryPolicy{Delay: time.Second, MaxDelay: 5 * time.Second}
+	for attempt, expected := range []time.Duration{time.Second, 2 * time.Second, 4 * time.Second, 5 * time.Second, 5 * time.Second} {
+		if delay := policy.Backoff(attempt + 1); delay != expected {
+			test.Logf("attempt %v waited %v instead of %v", attempt+1, delay, expected)
+			test.Fail()
+		}
+	}
+}
+
+func TestLoopStops(test *testing.T) {
+	ctrl := gomock.NewController(test)
+	defer ctrl.Finish()
+
+	me := mock_state.NewMockState(ctrl)
+	other := mock_state.NewMockState(ctrl)
+	arbiter := mock_state.NewMockState(ctrl)
+	perform := mock_monitor.NewMockPerformer(ctrl)
+
+	other.EXPECT().Ready()
+	arbiter.EXPECT().Ready()
+	expectPosition(me, perform)
+
+	other.EXPECT().GetDBRole().Return("active", nil).AnyTimes()
+	me.EXPECT().GetDBRole().Return("backup", nil).AnyTimes()
+	perform.EXPECT().TransitionToBackup().AnyTimes()
+
+	decider, err := monitor.NewDecider(me, []state.State{other}, arbiter, perform, config.Config{})
+	if err != nil {
+		test.Log(err)
+		test.FailNow()
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() {
+		done <- decider.Loop(ctx, time.Millisecond)
+	}()
+	<-time.After(10 * time.Millisecond)
+	cancel()
+
+	select {
+	case err := <-done:
+		if err != context.Canceled {
+			test.Logf("wrong error was returned '%v'", err)
+			test.Fail()
+		}
+	case <-time.After(time.Second):
+		test.Log("the loop did not stop")
+		test.Fail()
+	}
+}
+
+// a remote state that can be sent heartbeats
+type beating struct {
+	*mock_state.MockState
+	err error
+}
+
+func (other beating) Ping() error {
+	return other.err
+}
+
+func TestHeartbeatRechecks(test *testing.T) {
+	ctrl := gomock.NewController(test)
+	defer ctrl.Finish()
+
+	me := mock_state.NewMockState(ctrl)
+	other := beating{MockState: mock_state.NewMockState(ctrl), err: errors.New("connection refused")}
+	bounce := mock_state.NewMockState(ctrl)
+	arbiter := mock_state.NewMockState(ctrl)
+	perform := mock_monitor.NewMockPerformer(ctrl)
+
+	other.EXPECT().Ready()
+	arbiter.EXPECT().Ready()
+	expectPosition(me, perform)
+	me.EXPECT().GetDBRole().Return("backup", nil).AnyTimes()
+	other.EXPECT().GetDBRole().Return("active", nil)
+	perform.EXPECT().TransitionToBackup()
+	decider, err := monitor.NewDecider(me, []state.State{other}, arbiter, perform, config.Config{HeartbeatInterval: 1})
+	if err != nil {
+		test.Log(err)
+		test.FailNow()
+	}
+
+	// the active node stops answering its heartbeats, the backup takes over long
+	// before the next check
+	took := make(chan struct{})
+	other.EXPECT().GetDBRole().Return("", errors.New("dead"))
+	other.EXPECT().Location().Return("127.0.0.1:1234")
+	arbiter.EXPECT().Bounce("127.0.0.1:1234").Return(bounce)
+	bounce.EXPECT().GetDBRole().Return("dead", nil)
+	me.EXPECT().HasSynced().Return(true, nil)
+	perform.EXPECT().TransitionToSingle().Do(func() { close(took) })
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go decider.Loop(ctx, time.Hour)
+	select {
+	case <-took:
+	case <-time.After(5 * time.Second):
+		test.Log("the backup did not take over after the heartbeats failed")
+		test.Fail()
+	}
+}
+
+func TestShutdown(test *testing.T) {
+	ctrl := gomock.NewController(test)
+	defer ctrl.Finish()
+
+	me := mock_state.NewMockState(ctrl)
+	other := mock_state.NewMockState(ctrl)
+	arbiter := mock_state.NewMockState(ctrl)
+	perform := mock_monitor.NewMockPerformer(ctrl)
+
+	other.EXPECT().Ready()
+	arbiter.EXPECT().Ready()
+	expectPosition(me, perform)
+
+	other.EXPECT().GetDBRole().Return("active", nil)
+	me.EXPECT().GetDBRole().Return("initialized", nil)
+	perform.EXPECT().TransitionToBackup()
+
+	decider, err := monitor.NewDecider(me, []state.State{other}, arbiter, perform, config.Config{})
+	if err != nil {
+		test.Log(err)
+		test.FailNow()
+	}
+
+	perform.EXPECT().Stop()
+	me.EXPECT().SetDBRole("dead").Return(nil)
+	if err := decider.Shutdown(); err != nil {
+		test.Log(err)
+		test.FailNow()
+	}
+
+	// nothing else should happen once the decider is shut down
+	if err := decider.ReCheck(); err != monitor.ShutDown {
+		test.Logf("wrong error was returned '%v'", err)
+		test.Fail()
+	}
+	if err := decider.Loop(context.Background(), time.Millisecond); err != nil {
+		test.Log(err)
+		test.Fail()
+	}
+}
+
+func TestQuorum(test *testing.T) {
+	cases := []struct {
+		quorum monitor.Quorum
+		total  int
+		peers  int
+	}{
+		{monitor.QuorumAll, 2, 2},
+		{monitor.QuorumMajority, 2, 1},
+		{monitor.QuorumMajority, 4, 2},
+		{monitor.Quorum(1), 2, 1},
+		{monitor.Quorum(5), 2, 2},
+	}
+	for _, c := range cases {
+		if peers := c.quorum.Peers(c.total); peers != c.peers {
+			test.Logf("quorum %v of %v needs %v peers, got %v", c.quorum, c.total, c.peers, peers)
+			test.Fail()
+		}
+	}
+}
+
+func TestStartupMajority(test *testing.T) {
+	ctrl := gomock.NewController(test)
+	defer ctrl.Finish()
+
+	me := mock_state.NewMockState(ctrl)
+	other := mock_state.NewMockState(ctrl)
+	arbiter := mock_state.NewMockState(ctrl)
+	perform := mock_monitor.NewMockPerformer(ctrl)
+
+	// the monitor is being rebuilt and never comes up, the decider may be done
+	// before it was even asked
+	rebuilt := make(chan struct{})
+	defer close(rebuilt)
+	arbiter.EXPECT().Ready().Do(func() { <-rebuilt }).MaxTimes(1)
+	other.EXPECT().Ready()
+	expectPosition(me, perform)
+
+	other.EXPECT().GetDBRole().Return("initialized", nil)
+	me.EXPECT().GetRole().Return("primary", nil)
+	perform.EXPECT().TransitionToActive()
+
+	_, err := monitor.NewDecider(me, []state.State{other}, arbiter, perform, config.Config{StartupQuorum: "majority"})
+	if err != nil {
+		test.Log(err)
+		test.FailNow()
+	}
+}
+
+func TestJoin(test *testing.T) {
+	ctrl := gomock.NewController(test)
+	defer ctrl.Finish()
+
+	me := mock_state.NewMockState(ctrl)
+	other := mock_state.NewMockState(ctrl)
+	bounce := mock_state.NewMockState(ctrl)
+	arbiter := mock_state.NewMockState(ctrl)
+	perform := mock_monitor.NewMockPerformer(ctrl)
+
+	other.EXPECT().Ready()
+	arbiter.EXPECT().Ready()
+	perform.EXPECT().Position().Return(uint64(64<<20), nil).AnyTimes()
+	me.EXPECT().SetPosition(uint64(64 << 20)).AnyTimes()
+	other.EXPECT().Location().Return("127.0.0.1:1234").AnyTimes()
+
+	// the old node is dead
+	other.EXPECT().GetDBRole().Return("", errors.New("dead"))
+	arbiter.EXPECT().Bounce("127.0.0.1:1234").Return(bounce)
+	bounce.EXPECT().GetDBRole().Return("dead", nil)
+	me.EXPECT().GetDBRole().Return("active", nil)
+	perform.EXPECT().TransitionToSingle()
+
+	decider, err := monitor.NewDecider(me, []state.State{other}, arbiter, perform, config.Config{})
+	if err != nil {
+		test.Log(err)
+		test.FailNow()
+	}
+	if err := decider.Join("127.0.0.1:9999"); err != monitor.UnknownPeer {
+		test.Logf("wrong error %v", err)
+		test.Fail()
+	}
+	if err := decider.Join("127.0.0.1:1234"); err != nil {
+		test.Log(err)
+		test.FailNow()
+	}
+
+	// its replacement is still too far behind to be counted on
+	other.EXPECT().GetDBRole().Return("backup", nil)
+	other.EXPECT().HasSynced().Return(true, nil)
+	other.EXPECT().GetPosition().Return(uint64(0), nil)
+	me.EXPECT().GetDBRole().Return("single", nil)
+	if err := decider.ReCheck(); err != nil {
+		test.Log(err)
+		test.FailNow()
+	}
+
+	// once it caught up it is admitted
+	other.EXPECT().GetDBRole().Return("backup", nil)
+	other.EXPECT().HasSynced().Return(true, nil)
+	other.EXPECT().GetPosition().Return(uint64(64<<20), nil)
+	me.EXPECT().GetDBRole().Return("single", nil)
+	perform.EXPECT().TransitionToActive()
+	if err := decider.ReCheck(); err != nil {
+		test.Log(err)
+		test.FailNow()
+	}
+}
+
+func TestUpgrade(test *testing.T) {
+	ctrl := gomock.NewController(test)
+	defer ctrl.Finish()
+
+	me := mock_state.NewMockState(ctrl)
+	other := mock_state.NewMockState(ctrl)
+	arbiter := mock_state.NewMockState(ctrl)
+	perform := mock_monitor.NewMockPerformer(ctrl)
+
+	other.EXPECT().Ready()
+	arbiter.EXPECT().Ready()
+	expectPosition(me, perform)
+
+	other.EXPECT().GetDBRole().Return("backup", nil)
+	me.EXPECT().GetDBRole().Return("active", nil)
+	perform.EXPECT().TransitionToActive()
+
+	decider, _ := monitor.NewDecider(me, []state.State{other}, arbiter, perform, config.Config{})
+
+	// the active node has to switch over before it can be upgraded
+	me.EXPECT().GetDBRole().Return("active", nil)
+	if err := decider.Upgrade(); err != monitor.NotBackup {
+		test.Logf("wrong error was returned '%v'", err)
+		test.Fail()
+	}
+
+	me.EXPECT().GetDBRole().Return("backup", nil)
+	gomock.InOrder(
+		me.EXPECT().SetDBRole("upgrading").Return(nil),
+		perform.EXPECT().Stop(),
+		perform.EXPECT().Start().Return(nil),
+		me.EXPECT().SetDBRole("backup").Return(nil),
+	)
+	if err := decider.Upgrade(); err != nil {
+		test.Log(err)
+		test.Fail()
+	}
+}
+
+func TestTransitionsAreNotRepeated(test *testing.T) {
+	ctrl := gomock.NewController(test)
+	defer ctrl.Finish()
+
+	me := mock_state.NewMockState(ctrl)
+	other := mock_state.NewMockState(ctrl)
+	arbiter := mock_state.NewMockState(ctrl)
+	perform := mock_monitor.NewMockPerformer(ctrl)
+
+	other.EXPECT().Ready()
+	arbiter.EXPECT().Ready()
+	expectPosition(me, perform)
+
+	other.EXPECT().GetDBRole().Return("active", nil)
+	me.EXPECT().GetDBRole().Return("initialized", nil)
+	perform.EXPECT().TransitionToBackup()
+
+	decider, _ := monitor.NewDecider(me, []state.State{other}, arbiter, perform, config.Config{})
+
+	// the node is a backup already
+	other.EXPECT().GetDBRole().Return("active", nil)
+	me.EXPECT().GetDBRole().Return("backup", nil)
+	if err := decider.ReCheck(); err != nil {
+		test.Log(err)
+		test.FailNow()
+	}
+
+	// unless it is forced to
+	other.EXPECT().GetDBRole().Return("active", nil)
+	me.EXPECT().GetDBRole().Return("backup", nil)
+	perform.EXPECT().TransitionToBackup()
+	if err := decider.Force(); err != nil {
+		test.Log(err)
+		test.FailNow()
+	}
+
+	// a node that is no longer in the target is moved again
+	other.EXPECT().GetDBRole().Return("active", nil)
+	me.EXPECT().GetDBRole().Return("initialized", nil)
+	perform.EXPECT().TransitionToBackup()
+	if err := decider.ReCheck(); err != nil {
+		test.Log(err)
+		test.FailNow()
+	}
+}
+
+func TestHistoryOutlivesRestart(test *testing.T) {
+	ctrl := gomock.NewController(test)
+	defer ctrl.Finish()
+
+	dir, err := ioutil.TempDir("", "history")
+	if err != nil {
+		test.Log(err)
+		test.FailNow()
+	}
+	defer os.RemoveAll(dir)
+	conf := config.Config{HistoryFile: dir + "/history.log"}
+
+	me := mock_state.NewMockState(ctrl)
+	other := mock_state.NewMockState(ctrl)
+	arbiter := mock_state.NewMockState(ctrl)
+	perform := mock_monitor.NewMockPerformer(ctrl)
+
+	other.EXPECT().Ready().Times(2)
+	arbiter.EXPECT().Ready().Times(2)
+	expectPosition(me, perform)
+
+	other.EXPECT().GetDBRole().Return("active", nil)
+	me.EXPECT().GetDBRole().Return("initialized", nil)
+	perform.EXPECT().TransitionToBackup()
+	monitor.NewDecider(me, []state.State{other}, arbiter, perform, conf)
+
+	// the restarted decider adds to what it recorded before
+	other.EXPECT().GetDBRole().Return("active", nil)
+	me.EXPECT().GetDBRole().Return("backup", nil)
+	perform.EXPECT().TransitionToBackup()
+	decider, _ := monitor.NewDecider(me, []state.State{other}, arbiter, perform, conf)
+
+	history := decider.History()
+	if len(history) != 2 {
+		test.Logf("expected both decisions in the history, got %+v", history)
+		test.FailNow()
+	}
+	first := history[0]
+	if first.Trigger != "recheck" || first.Rule != "follow" || first.From != state.Initialized || first.To != state.Backup || first.Outcome != monitor.Transitioned {
+		test.Logf("wrong entry %+v", first)
+		test.Fail()
+	}
+	if len(first.Peers) != 1 || first.Peers[0] != state.Active {
+		test.Logf("the roles of the peers were not recorded %+v", first)
+		test.Fail()
+	}
+}
+
+func TestFailoverDelay(test *testing.T) {
+	ctrl := gomock.NewController(test)
+	defer ctrl.Finish()
+
+	me := mock_state.NewMockState(ctrl)
+	other := mock_state.NewMockState(ctrl)
+	bounce := mock_state.NewMockState(ctrl)
+	arbiter := mock_state.NewMockState(ctrl)
+	perform := mock_monitor.NewMockPerformer(ctrl)
+
+	other.EXPECT().Ready()
+	arbiter.EXPECT().Ready()
+	expectPosition(me, perform)
+
+	// the active node looks dead, even through the monitor
+	other.EXPECT().GetDBRole().Return("", errors.New("dead")).Times(2)
+	other.EXPECT().Location().Return("127.0.0.1:1234").Times(2)
+	arbiter.EXPECT().Bounce("127.0.0.1:1234").Return(bounce).Times(2)
+	bounce.EXPECT().GetDBRole().Return("dead", nil).Times(2)
+	me.EXPECT().GetDBRole().Return("backup", nil).Times(2)
+	me.EXPECT().HasSynced().Return(true, nil).Times(2)
+
+	// the backup does not take over while the active node may still come back
+	decider, err := monitor.NewDecider(me, []state.State{other}, arbiter, perform, config.Config{FailoverDelay: 60})
+	if err != nil {
+		test.Log(err)
+		test.FailNow()
+	}
+	if err := decider.ReCheck(); err != nil {
+		test.Log(err)
+		test.FailNow()
+	}
+	if decision := decider.Decided(); decision.Rule != "grace period" {
+		test.Logf("the backup went by '%v' instead of waiting", decision.Rule)
+		test.Fail()
+	}
+
+	// it takes over once the delay is reloaded away
+	decider.Reload(config.Config{CheckInterval: 1})
+	other.EXPECT().GetDBRole().Return("", errors.New("dead"))
+	other.EXPECT().Location().Return("127.0.0.1:1234")
+	arbiter.EXPECT().Bounce("127.0.0.1:1234").Return(bounce)
+	bounce.EXPECT().GetDBRole().Return("dead", nil)
+	me.EXPECT().GetDBRole().Return("backup", nil)
+	me.EXPECT().HasSynced().Return(true, nil)
+	perform.EXPECT().TransitionToSingle()
+	if err := decider.ReCheck(); err != nil {
+		test.Log(err)
+		test.Fail()
+	}
+}
+
+func TestStartGivesUp(test *testing.T) {
+	ctrl := gomock.NewController(test)
+	defer ctrl.Finish()
+
+	me := mock_state.NewMockState(ctrl)
+	other := mock_state.NewMockState(ctrl)
+	arbiter := mock_state.NewMockState(ctrl)
+	perform := mock_monitor.NewMockPerformer(ctrl)
+
+	// the other node never comes up
+	never := make(chan struct{})
+	defer close(never)
+	other.EXPECT().Ready().Do(func() { <-never })
+	arbiter.EXPECT().Ready().MaxTimes(1)
+	expectPosition(me, perform)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	decider, err := monitor.NewDeciderContext(ctx, me, []state.State{other}, arbiter, perform, config.Config{})
+	if err != context.DeadlineExceeded || decider != nil {
+		test.Logf("the decider was started with '%v'", err)
+		test.Fail()
+	}
 }
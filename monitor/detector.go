@@ -0,0 +1,163 @@
+// Copyright (c) 2015 Pagoda Box Inc
+//
+// This Source Code Form is subject to the terms of the Mozilla Public License, v.
+// 2.0. If a copy of the MPL was not distributed with this file, You can obtain one
+// at http://mozilla.org/MPL/2.0/.
+//
+
+package monitor
+
+import (
+	"github.com/nanopack/yoke/config"
+	"github.com/nanopack/yoke/state"
+	"math"
+	"sync"
+	"time"
+)
+
+type (
+	// FailureDetector decides when a node that can't be checked, directly or
+	// through the arbiter, should be given up on. Until then the decider keeps
+	// treating it as whatever it was last seen as.
+	FailureDetector interface {
+		Success(now time.Time)
+		Failure(now time.Time)
+		Suspect(now time.Time) bool
+	}
+
+	// suspects a node once enough checks in a row failed, and it hasn't answered
+	// for long enough
+	countDetector struct {
+		failures    int
+		maxFailures int
+		timeout     time.Duration
+		last        time.Time
+	}
+
+	// suspects a node once the time since it last answered is unlikely, given how
+	// regularly it answered before. See "The phi accrual failure detector" by
+	// Hayashibara et al.
+	phiDetector struct {
+		threshold float64
+		intervals []float64 // seconds between successful checks, the newest last
+		last      time.Time
+		failed    bool
+	}
+
+	// the detector of a node, and what it was last seen as. A node that replaced
+	// a dead one is joining until it has caught up. The heartbeats feed the
+	// detector in between the checks, so it is only used with the lock held.
+	watched struct {
+		sync.Mutex
+		detector FailureDetector
+		peer     peer
+		seen     bool
+		joining  bool
+	}
+)
+
+// how many intervals the phi detector keeps
+const phiSamples = 100
+
+// NewFailureDetector creates a detector for a single node, according to the config
+func NewFailureDetector(conf config.Config) FailureDetector {
+	if conf.FailureDetector == "phi" {
+		return &phiDetector{threshold: float64(conf.PhiThreshold)}
+	}
+	return &countDetector{
+		maxFailures: conf.PeerFailures,
+		timeout:     time.Duration(conf.PeerTimeout) * time.Second,
+	}
+}
+
+func (detector *countDetector) Success(now time.Time) {
+	detector.failures = 0
+	detector.last = now
+}
+
+func (detector *countDetector) Failure(now time.Time) {
+	detector.failures++
+}
+
+func (detector *countDetector) Suspect(now time.Time) bool {
+	if detector.failures == 0 {
+		return false
+	}
+	return detector.failures >= detector.maxFailures && now.Sub(detector.last) >= detector.timeout
+}
+
+func (detector *phiDetector) Success(now time.Time) {
+	if !detector.last.IsZero() {
+		detector.intervals = append(detector.intervals, now.Sub(detector.last).Seconds())
+		if len(detector.intervals) > phiSamples {
+			detector.intervals = detector.intervals[1:]
+		}
+	}
+	detector.last = now
+	detector.failed = false
+}
+
+func (detector *phiDetector) Failure(now time.Time) {
+	detector.failed = true
+}
+
+// without enough history there is nothing to base the suspicion on, so a node is
+// suspected as soon as a check fails
+func (detector *phiDetector) Suspect(now time.Time) bool {
+	if !detector.failed {
+		return false
+	}
+	if len(detector.intervals) < 2 {
+		return true
+	}
+	return detector.phi(now) >= detector.threshold
+}
+
+// phi is -log10 of the probability that an answer still comes this late, using a
+// normal distribution of the intervals seen so far
+func (detector *phiDetector) phi(now time.Time) float64 {
+	mean := 0.0
+	for _, interval := range detector.intervals {
+		mean += interval
+	}
+	mean /= float64(len(detector.intervals))
+	variance := 0.0
+	for _, interval := range detector.intervals {
+		variance += (interval - mean) * (interval - mean)
+	}
+	deviation := math.Sqrt(variance / float64(len(detector.intervals)))
+	// the checks run on a ticker, so the intervals hardly vary at all. Without a
+	// floor on the deviation a single late answer would already be suspicious.
+	if deviation < mean/4 {
+		deviation = mean / 4
+	}
+
+	// the logistic approximation of the normal distribution's cdf
+	y := (now.Sub(detector.last).Seconds() - mean) / deviation
+	e := math.Exp(-y * (1.5976 + 0.070566*y*y))
+	if y > 0 {
+		return -math.Log10(e / (1 + e))
+	}
+	return -math.Log10(1 - 1/(1+e))
+}
+
+// watch records the outcome of checking a node. When the check failed the node is
+// still returned as it was last seen, until its detector suspects it.
+func (decider *decider) watch(other state.State, watch *watched, checked peer, err error) (peer, error) {
+	watch.Lock()
+	defer watch.Unlock()
+	now := now()
+	if err == nil {
+		watch.detector.Success(now)
+		watch.peer = checked
+		watch.seen = true
+		return checked, nil
+	}
+	watch.detector.Failure(now)
+	if !watch.seen || watch.detector.Suspect(now) {
+		return peer{}, err
+	}
+	location := other.Location()
+	decider.log.With(config.Fields{"peer": location}).Info("'%v' could not be checked (%v), still treating it as '%v'", location, err, watch.peer.dbRole)
+	return watch.peer, nil
+}
@@ -0,0 +1,67 @@
+// Copyright (c) 2015 Pagoda Box Inc
+//
+// This Source Code Form is subject to the terms of the Mozilla Public License, v.
+// 2.0. If a copy of the MPL was not distributed with this file, You can obtain one
+// at http://mozilla.org/MPL/2.0/.
+//
+
+package monitor
+
+import (
+	"github.com/nanopack/yoke/config"
+	"testing"
+	"time"
+)
+
+func TestCountDetector(test *testing.T) {
+	detector := NewFailureDetector(config.Config{PeerFailures: 2, PeerTimeout: 10})
+	now := time.Now()
+	detector.Success(now)
+
+	detector.Failure(now.Add(time.Second))
+	if detector.Suspect(now.Add(time.Second)) {
+		test.Log("a single failure should not be suspected")
+		test.Fail()
+	}
+	detector.Failure(now.Add(2 * time.Second))
+	if detector.Suspect(now.Add(2 * time.Second)) {
+		test.Log("the node should not be suspected before the timeout")
+		test.Fail()
+	}
+	detector.Failure(now.Add(11 * time.Second))
+	if !detector.Suspect(now.Add(11 * time.Second)) {
+		test.Log("the node should have been suspected")
+		test.Fail()
+	}
+
+	detector.Success(now.Add(12 * time.Second))
+	if detector.Suspect(now.Add(12 * time.Second)) {
+		test.Log("an answer should clear the suspicion")
+		test.Fail()
+	}
+}
+
+func TestPhiDetector(test *testing.T) {
+	detector := NewFailureDetector(config.Config{FailureDetector: "phi", PhiThreshold: 8})
+	now := time.Now()
+
+	detector.Failure(now)
+	if !detector.Suspect(now) {
+		test.Log("without any history a failure should be suspected")
+		test.Fail()
+	}
+
+	for i := 0; i < 10; i++ {
+		now = now.Add(time.Second)
+		detector.Success(now)
+	}
+	detector.Failure(now.Add(1500 * time.Millisecond))
+	if detector.Suspect(now.Add(1500 * time.Millisecond)) {
+		test.Log("a slightly late answer should not be suspected")
+		test.Fail()
+	}
+	if !detector.Suspect(now.Add(10 * time.Second)) {
+		test.Log("a node that stopped answering should be suspected")
+		test.Fail()
+	}
+}
@@ -0,0 +1,239 @@
+// Copyright (c) 2015 Pagoda Box Inc
+//
+// This Source Code Form is subject to the terms of the Mozilla Public License, v.
+// 2.0. If a copy of the MPL was not distributed with this file, You can obtain one
+// at http://mozilla.org/MPL/2.0/.
+//
+
+// dr.go lets a yoke cluster in another region be the DR cluster of this one. Its
+// nodes are decided on as usual, but the node it makes writable keeps streaming
+// from the writable node of the source cluster instead of taking writes, and its
+// backups stream from it. It only takes writes once the DR cluster is promoted,
+// with 'yokeadm dr promote' on any of its nodes, or on its own once the source
+// cluster has been gone for as long as the dr promote_after allows. A promotion is
+// passed on from node to node like the maintenance switch, and isn't undone.
+
+package monitor
+
+import (
+	"errors"
+	"github.com/nanopack/yoke/config"
+	"github.com/nanopack/yoke/events"
+	"github.com/nanopack/yoke/state"
+	"net"
+	"time"
+)
+
+var NotDR = errors.New("this node is not in a DR cluster")
+
+type (
+	// a state that knows if its DR cluster was promoted, the local state and the
+	// remote states do. Only the local state can be changed.
+	promoter interface {
+		GetPromotion() (state.Promotion, error)
+		SetPromotion(state.Promotion) error
+	}
+
+	// a performer that can track the writable node of another cluster, the
+	// postgres performer can
+	tracker interface {
+		SourceWritable() bool
+		PromoteDR() error
+	}
+)
+
+// the remote states of the nodes of the source cluster
+func newSources(conf config.Config) []state.State {
+	sources := []state.State{}
+	for _, address := range conf.DRSources() {
+		sources = append(sources, state.NewRemoteState("tcp", address, conf.CallTimeout()))
+	}
+	return sources
+}
+
+// PromoteDR makes the DR cluster stop tracking the source cluster and take writes.
+// The other nodes pick the promotion up on their next recheck, the node that is
+// writable in the DR cluster promotes its database then.
+func (decider *decider) PromoteDR() error {
+	decider.Lock()
+	defer decider.Unlock()
+	defer decider.plan.working()()
+	return decider.promote("by hand")
+}
+
+func (decider *decider) promote(why string) error {
+	local, ok := decider.me.(promoter)
+	if !decider.dr || !ok {
+		return NotDR
+	}
+	current, err := local.GetPromotion()
+	if err != nil {
+		return err
+	}
+	if !current.Promoted {
+		decider.log.Warn("[dr] promoting the DR cluster %v", why)
+		if err := local.SetPromotion(state.Promotion{Promoted: true, Changed: now()}); err != nil {
+			return err
+		}
+		events.Publish(events.Event{Type: events.DRPromoted, Error: why})
+	}
+	return decider.promoteDatabase()
+}
+
+// picks up the promotion from the other nodes of the DR cluster, and promotes it on
+// its own once the source cluster had no writable node for long enough
+func (decider *decider) syncPromotion() {
+	local, ok := decider.me.(promoter)
+	if !decider.dr || !ok {
+		return
+	}
+	current, err := local.GetPromotion()
+	if err != nil {
+		return
+	}
+	newest := current
+	for _, other := range decider.others {
+		if remote, ok := other.(promoter); ok {
+			if theirs, err := remote.GetPromotion(); err == nil && theirs.Changed.After(newest.Changed) {
+				newest = theirs
+			}
+		}
+	}
+	if newest != current {
+		if err := local.SetPromotion(newest); err != nil {
+			decider.log.Error("failed to record the promotion of the DR cluster (%v)", err)
+		}
+	}
+	if newest.Promoted {
+		if err := decider.promoteDatabase(); err != nil {
+			decider.log.Error("[dr] could not promote the database (%v)", err)
+		}
+		return
+	}
+
+	// only the node that is writable in the DR cluster watches the source cluster,
+	// a backup that takes over starts over
+	track, ok := decider.plan.Performer.(tracker)
+	role, err := dbRole(decider.me)
+	if !ok || err != nil || (role != state.Active && role != state.Single) || track.SourceWritable() {
+		decider.sourceLost = time.Time{}
+		return
+	}
+	if decider.sourceLost.IsZero() {
+		decider.log.Warn("[dr] the source cluster has no writable node")
+		decider.sourceLost = now()
+	}
+	if decider.promoteAfter <= 0 || now().Sub(decider.sourceLost) < decider.promoteAfter {
+		return
+	}
+	if err := decider.promote("after the source cluster was gone for " + decider.promoteAfter.String()); err != nil {
+		decider.log.Error("[dr] could not promote the DR cluster (%v)", err)
+	}
+}
+
+// has the database of this node take writes, when it is the writable node of the
+// DR cluster
+func (decider *decider) promoteDatabase() error {
+	track, ok := decider.plan.Performer.(tracker)
+	if !ok {
+		return nil
+	}
+	role, err := dbRole(decider.me)
+	if err != nil || (role != state.Active && role != state.Single) {
+		return err
+	}
+	if decider.plan.planning() {
+		decider.log.Info("[plan] would promote the database of the DR cluster")
+		return nil
+	}
+	return track.PromoteDR()
+}
+
+// if the node is the writable node of a DR cluster that was not promoted, it
+// streams from the source cluster instead of taking writes
+func (performer *performer) tracking() bool {
+	if len(performer.sources) == 0 {
+		return false
+	}
+	local, ok := performer.me.(promoter)
+	if !ok {
+		return true
+	}
+	promotion, err := local.GetPromotion()
+	return err != nil || !promotion.Promoted
+}
+
+// finds the node of the source cluster that runs the writable database
+func (performer *performer) sourceNode() (state.State, error) {
+	for _, source := range performer.sources {
+		if role, err := source.GetDBRole(); err == nil && (role == "active" || role == "single") {
+			return source, nil
+		}
+	}
+	return nil, NoSource
+}
+
+// SourceWritable is whether the source cluster has a writable node to stream from
+func (performer *performer) SourceWritable() bool {
+	_, err := performer.sourceNode()
+	return err == nil
+}
+
+// track has the database stream from the writable node of the source cluster. A
+// source cluster that has no writable node leaves it streaming from where it did,
+// it may come back.
+func (performer *performer) track() error {
+	source, err := performer.sourceNode()
+	if err == NoSource {
+		performer.log.Warn("[dr] the source cluster has no writable node, still streaming from where it was")
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	ip, _, err := net.SplitHostPort(source.Location())
+	if err != nil {
+		return err
+	}
+	// the trigger file would have the database take writes
+	if err := performer.replicate(true); err != nil {
+		return err
+	}
+	current, err := streamingFrom(performer.config.DataDir)
+	if err != nil || (current == ip && performer.step["started"]) {
+		return err
+	}
+
+	performer.log.With(config.Fields{"peer": source.Location()}).Info("[dr] streaming from '%v' in the source cluster", source.Location())
+	if err := performer.stop(); err != nil {
+		return err
+	}
+	if err := performer.config.CreateRecovery(ip, performer.config.PGPort); err != nil {
+		return err
+	}
+	return performer.startDB()
+}
+
+// PromoteDR has the database of the writable node of the DR cluster stop streaming
+// from the source cluster and take writes. Commits wait for a backup again while
+// the node is active.
+func (performer *performer) PromoteDR() error {
+	if performer.database != database(performer) || len(performer.sources) == 0 {
+		return nil
+	}
+	performer.Lock()
+	defer performer.Unlock()
+
+	if !performer.step["trigger"] {
+		return nil
+	}
+	role, err := performer.me.GetDBRole()
+	if err != nil {
+		return err
+	}
+	performer.log.Info("[dr] the database takes writes")
+	if err := performer.replicate(false); err != nil {
+		return err
+	}
+	return performer.setSync(role == "active", nil)
+}
@@ -0,0 +1,112 @@
+// Copyright (c) 2015 Pagoda Box Inc
+//
+// This Source Code Form is subject to the terms of the Mozilla Public License, v.
+// 2.0. If a copy of the MPL was not distributed with this file, You can obtain one
+// at http://mozilla.org/MPL/2.0/.
+//
+
+// drain.go lets the clients of the active node finish what they are doing before a
+// switchover stops its database, instead of cutting their transactions off.
+
+package monitor
+
+import (
+	"database/sql"
+	"github.com/lib/pq"
+	"strconv"
+	"time"
+)
+
+// how often a drain checks if the transactions in flight have finished
+var drainInterval = 250 * time.Millisecond
+
+// the databases that take connections, the ones a drain closes
+const openDatabases = "select datname, datconnlimit from pg_database where datallowconn and not datistemplate"
+
+// the sessions of clients, other than the one of the performer itself
+const clients = "from pg_stat_activity where datname is not null and usename is not null and pid <> pg_backend_pid()"
+
+// Drain keeps new clients off of the database and waits up to timeout for the
+// transactions in flight to finish. The clients that are still in one after that
+// are disconnected when terminate is set. The databases take connections again
+// before Drain returns, the connection limits are replicated and would otherwise
+// hold the new active node too. Only postgres is drained, the other databases are
+// stopped right away.
+func (performer *performer) Drain(timeout time.Duration, terminate bool) error {
+	if performer.database != database(performer) {
+		return nil
+	}
+	db, err := performer.pgConnect()
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	// superusers are not held to the connection limit, which leaves the
+	// performer a way in
+	limits, err := connectionLimits(db)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		for name, limit := range limits {
+			if err := setConnectionLimit(db, name, limit); err != nil {
+				performer.log.Error("[drain] could not let clients back into '%v' (%v)", name, err)
+			}
+		}
+	}()
+	for name := range limits {
+		if err := setConnectionLimit(db, name, 0); err != nil {
+			return err
+		}
+	}
+
+	performer.log.Info("[drain] waiting up to %v for the transactions in flight to finish", timeout)
+	deadline := time.Now().Add(timeout)
+	for {
+		var inFlight int
+		if err := db.QueryRow("select count(*) " + clients + " and xact_start is not null").Scan(&inFlight); err != nil {
+			return err
+		}
+		if inFlight == 0 {
+			performer.log.Info("[drain] drained")
+			return nil
+		}
+		if time.Now().After(deadline) {
+			performer.log.Warn("[drain] %v transaction(s) are still in flight", inFlight)
+			break
+		}
+		<-time.After(drainInterval)
+	}
+
+	if terminate {
+		performer.log.Info("[drain] disconnecting the clients that are left")
+		_, err = db.Exec("select pg_terminate_backend(pid) " + clients)
+	}
+	return err
+}
+
+// the connection limit of every database that takes connections
+func connectionLimits(db *sql.DB) (map[string]int, error) {
+	rows, err := db.Query(openDatabases)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	limits := map[string]int{}
+	for rows.Next() {
+		var name string
+		var limit int
+		if err := rows.Scan(&name, &limit); err != nil {
+			return nil, err
+		}
+		limits[name] = limit
+	}
+	return limits, rows.Err()
+}
+
+func setConnectionLimit(db *sql.DB, name string, limit int) error {
+	_, err := db.Exec("alter database " + pq.QuoteIdentifier(name) + " connection limit " + strconv.Itoa(limit))
+	return err
+}
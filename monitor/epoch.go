@@ -0,0 +1,102 @@
+// Copyright (c) 2015 Pagoda Box Inc
+//
+// This Source Code Form is subject to the terms of the Mozilla Public License, v.
+// 2.0. If a copy of the MPL was not distributed with this file, You can obtain one
+// at http://mozilla.org/MPL/2.0/.
+//
+
+// epoch.go numbers the times a node of the cluster started taking writes. The admin
+// api hands the epoch out with the writable node and the events carry it, so that a
+// client that polls more than one node during a failover keeps the newest answer,
+// and a node that still takes writes after another one took over can tell.
+
+package monitor
+
+import (
+	"fmt"
+	"github.com/nanopack/yoke/events"
+	"github.com/nanopack/yoke/state"
+)
+
+// a state that knows the epoch of the cluster, the local state and the remote
+// states do. Only the local state can be changed.
+type epocher interface {
+	GetEpoch() (state.Epoch, error)
+	SetEpoch(state.Epoch) error
+}
+
+// takeEpoch starts a new epoch before this node starts taking writes, one past the
+// highest epoch it knows of. A node that already takes writes in its own epoch,
+// like a single node that goes on to active, stays in it.
+func (decider *decider) takeEpoch() {
+	local, ok := decider.me.(epocher)
+	if !ok || decider.plan.planning() {
+		return
+	}
+	current, err := local.GetEpoch()
+	if err != nil {
+		return
+	}
+	if role, err := dbRole(decider.me); err == nil && (role == state.Active || role == state.Single) && current.Location == decider.me.Location() {
+		return
+	}
+	epoch := state.Epoch{Number: decider.newestEpoch(current).Number + 1, Location: decider.me.Location()}
+	decider.log.Info("taking writes in epoch %v", epoch.Number)
+	if err := local.SetEpoch(epoch); err != nil {
+		decider.log.Error("failed to record the epoch (%v)", err)
+		return
+	}
+	events.SetEpoch(epoch.Number)
+}
+
+// syncEpoch picks up the highest epoch the other nodes have seen. A node that takes
+// writes keeps its own epoch, and reports once that another node took writes in a
+// later one, which makes this node the out of date one.
+func (decider *decider) syncEpoch() {
+	local, ok := decider.me.(epocher)
+	if !ok || decider.plan.planning() {
+		return
+	}
+	current, err := local.GetEpoch()
+	if err != nil {
+		return
+	}
+	role, err := dbRole(decider.me)
+	if err != nil {
+		return
+	}
+	newest := decider.newestEpoch(current)
+	writable := role == state.Active || role == state.Single
+	switch {
+	case writable && current.Location != decider.me.Location():
+		// it was writable before it was restarted, or was made so by hand
+		decider.takeEpoch()
+		return
+	case writable:
+		if newest.Number > current.Number && newest.Number != decider.outdated {
+			decider.log.Error("'%v' took writes in epoch %v, after this node did in epoch %v", newest.Location, newest.Number, current.Number)
+			events.Publish(events.Event{Type: events.SplitBrain, DBRole: string(role), Peer: newest.Location, Error: fmt.Sprintf("took writes in the later epoch %v", newest.Number)})
+			decider.outdated = newest.Number
+		}
+	case newest != current:
+		if err := local.SetEpoch(newest); err != nil {
+			decider.log.Error("failed to record the epoch (%v)", err)
+			return
+		}
+		current = newest
+	}
+	events.SetEpoch(current.Number)
+}
+
+// the highest of current and the epochs the other nodes have seen
+func (decider *decider) newestEpoch(current state.Epoch) state.Epoch {
+	newest := current
+	for _, other := range decider.others {
+		if remote, ok := other.(epocher); ok {
+			if theirs, err := remote.GetEpoch(); err == nil && theirs.Number > newest.Number {
+				newest = theirs
+			}
+		}
+	}
+	return newest
+}
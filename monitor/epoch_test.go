@@ -0,0 +1,75 @@
+// Copyright (c) 2015 Pagoda Box Inc
+//
+// This Source Code Form is subject to the terms of the Mozilla Public License, v.
+// 2.0. If a copy of the MPL was not distributed with this file, You can obtain one
+// at http://mozilla.org/MPL/2.0/.
+//
+
+package monitor
+
+import (
+	"github.com/golang/mock/gomock"
+	"github.com/nanopack/yoke/config"
+	"github.com/nanopack/yoke/state"
+	"github.com/nanopack/yoke/state/mock"
+	"testing"
+)
+
+// a state that keeps an epoch
+type epoched struct {
+	*mock_state.MockState
+	epoch state.Epoch
+}
+
+func (node *epoched) GetEpoch() (state.Epoch, error) {
+	return node.epoch, nil
+}
+
+func (node *epoched) SetEpoch(epoch state.Epoch) error {
+	node.epoch = epoch
+	return nil
+}
+
+func TestEpoch(test *testing.T) {
+	ctrl := gomock.NewController(test)
+	defer ctrl.Finish()
+
+	me := &epoched{MockState: mock_state.NewMockState(ctrl), epoch: state.Epoch{Number: 2, Location: "10.0.0.2:4400"}}
+	other := &epoched{MockState: mock_state.NewMockState(ctrl), epoch: state.Epoch{Number: 3, Location: "10.0.0.1:4400"}}
+	me.EXPECT().Location().Return("10.0.0.2:4400").AnyTimes()
+	decider := &decider{me: me, others: []state.State{other}, plan: &planner{}, log: config.Log}
+
+	// a backup picks up the epoch the active node took writes in
+	me.EXPECT().GetDBRole().Return("backup", nil)
+	decider.syncEpoch()
+	if me.epoch.Number != 3 || me.epoch.Location != "10.0.0.1:4400" {
+		test.Logf("should have picked up epoch 3, not %+v", me.epoch)
+		test.Fail()
+	}
+
+	// and starts the next one when it is promoted
+	me.EXPECT().GetDBRole().Return("backup", nil)
+	decider.takeEpoch()
+	if me.epoch.Number != 4 || me.epoch.Location != "10.0.0.2:4400" {
+		test.Logf("should have taken epoch 4, not %+v", me.epoch)
+		test.Fail()
+	}
+
+	// going on from single to active is no new epoch
+	me.EXPECT().GetDBRole().Return("single", nil)
+	decider.takeEpoch()
+	if me.epoch.Number != 4 {
+		test.Logf("should have stayed in epoch 4, not %+v", me.epoch)
+		test.Fail()
+	}
+
+	// another node took over since, this node keeps its epoch and reports it once
+	other.epoch = state.Epoch{Number: 5, Location: "10.0.0.3:4400"}
+	me.EXPECT().GetDBRole().Return("active", nil).Times(2)
+	decider.syncEpoch()
+	decider.syncEpoch()
+	if me.epoch.Number != 4 || decider.outdated != 5 {
+		test.Logf("should have stayed in epoch 4 and found epoch 5, not %+v (%v)", me.epoch, decider.outdated)
+		test.Fail()
+	}
+}
@@ -0,0 +1,238 @@
+// Copyright (c) 2015 Pagoda Box Inc
+//
+// This Source Code Form is subject to the terms of the Mozilla Public License, v.
+// 2.0. If a copy of the MPL was not distributed with this file, You can obtain one
+// at http://mozilla.org/MPL/2.0/.
+//
+
+// etcd.go arbitrates the cluster through etcd v3 instead of the dedicated monitor
+// node. Every node keeps a record of its state under a lease, a node whose lease
+// ran out is seen as 'dead'. The node running as active holds the leader key, a
+// backup has to win it before it takes over.
+
+package monitor
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"github.com/nanopack/yoke/config"
+	"github.com/nanopack/yoke/state"
+	"net/http"
+	"sync"
+	"time"
+)
+
+type (
+	etcdArbiter struct {
+		sync.Mutex
+		endpoint string
+		prefix   string
+		ttl      time.Duration
+		client   *http.Client
+		lease    string
+		log      config.Logger
+	}
+
+	etcdKV struct {
+		Key   string `json:"key"`
+		Value string `json:"value"`
+	}
+)
+
+func init() {
+	RegisterArbiter("etcd", newEtcdArbiter)
+}
+
+func newEtcdArbiter(conf config.Config) (Arbiter, error) {
+	if conf.EtcdEndpoint == "" {
+		return nil, fmt.Errorf("the 'etcd' arbiter needs an endpoint")
+	}
+	return &etcdArbiter{
+		endpoint: conf.EtcdEndpoint,
+		prefix:   conf.EtcdPrefix,
+		ttl:      time.Duration(conf.EtcdTTL) * time.Second,
+		client:   &http.Client{Timeout: conf.CallTimeout()},
+		log:      conf.Logging(),
+	}, nil
+}
+
+// Ready blocks until etcd answers
+func (arbiter *etcdArbiter) Ready() {
+	for {
+		if _, _, err := arbiter.get("leader"); err == nil {
+			return
+		}
+		<-time.After(time.Second)
+	}
+}
+
+func (arbiter *etcdArbiter) Bounce(location string) state.State {
+	return recordView{records: arbiter, location: location}
+}
+
+// Report keeps the record of me up to date until ctx is done. The
+// node holds the leader key while it is running as the active node, and lets go
+// of it as soon as it stops.
+func (arbiter *etcdArbiter) Report(ctx context.Context, me state.State) {
+	location := me.Location()
+	for {
+		if err := arbiter.report(me); err != nil {
+			arbiter.log.Warn("[etcd] could not report the state of this node (%v)", err)
+		} else if role, err := dbRole(me); err == nil {
+			switch role {
+			case state.Active, state.Single:
+				arbiter.Campaign(location)
+			default:
+				arbiter.resign(location)
+			}
+		}
+		if ctx.Err() != nil {
+			return
+		}
+		select {
+		case <-ctx.Done():
+		case <-time.After(arbiter.ttl / 3):
+		}
+	}
+}
+
+// Campaign tries to take the leader key for location, it returns if location
+// holds it afterwards
+func (arbiter *etcdArbiter) Campaign(location string) (bool, error) {
+	lease, err := arbiter.currentLease()
+	if err != nil {
+		return false, err
+	}
+	key := arbiter.key("leader")
+	txn := map[string]interface{}{
+		"compare": []map[string]interface{}{{"key": key, "target": "CREATE", "create_revision": "0"}},
+		"success": []map[string]interface{}{{"request_put": map[string]interface{}{"key": key, "value": encode(location), "lease": lease}}},
+	}
+	if err := arbiter.post("/v3/kv/txn", txn, nil); err != nil {
+		return false, err
+	}
+	leader, _, err := arbiter.get("leader")
+	return err == nil && leader == location, err
+}
+
+// gives up the leader key, if location holds it
+func (arbiter *etcdArbiter) resign(location string) error {
+	key := arbiter.key("leader")
+	txn := map[string]interface{}{
+		"compare": []map[string]interface{}{{"key": key, "target": "VALUE", "value": encode(location)}},
+		"success": []map[string]interface{}{{"request_delete_range": map[string]interface{}{"key": key}}},
+	}
+	return arbiter.post("/v3/kv/txn", txn, nil)
+}
+
+func (arbiter *etcdArbiter) report(me state.State) error {
+	record, err := NewRecord(me)
+	if err != nil {
+		return err
+	}
+	value, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+
+	lease, err := arbiter.keepAlive()
+	if err != nil {
+		return err
+	}
+	put := map[string]interface{}{"key": arbiter.key("nodes/" + me.Location()), "value": base64.StdEncoding.EncodeToString(value), "lease": lease}
+	return arbiter.post("/v3/kv/put", put, nil)
+}
+
+// refreshes the lease of this node, a new one is granted when it ran out
+func (arbiter *etcdArbiter) keepAlive() (string, error) {
+	arbiter.Lock()
+	defer arbiter.Unlock()
+	if arbiter.lease != "" {
+		reply := struct {
+			Result struct {
+				TTL string `json:"TTL"`
+			} `json:"result"`
+		}{}
+		err := arbiter.post("/v3/lease/keepalive", map[string]string{"ID": arbiter.lease}, &reply)
+		// a lease that has run out is kept alive with a TTL of 0
+		if err == nil && reply.Result.TTL != "" && reply.Result.TTL != "0" {
+			return arbiter.lease, nil
+		}
+	}
+
+	reply := struct {
+		ID string `json:"ID"`
+	}{}
+	if err := arbiter.post("/v3/lease/grant", map[string]interface{}{"TTL": int64(arbiter.ttl / time.Second)}, &reply); err != nil {
+		return "", err
+	}
+	arbiter.lease = reply.ID
+	return arbiter.lease, nil
+}
+
+func (arbiter *etcdArbiter) currentLease() (string, error) {
+	arbiter.Lock()
+	lease := arbiter.lease
+	arbiter.Unlock()
+	if lease == "" {
+		return arbiter.keepAlive()
+	}
+	return lease, nil
+}
+
+// reads a key under the prefix, ok is false when it does not exist
+func (arbiter *etcdArbiter) get(name string) (value string, ok bool, err error) {
+	reply := struct {
+		KVs []etcdKV `json:"kvs"`
+	}{}
+	if err := arbiter.post("/v3/kv/range", map[string]string{"key": arbiter.key(name)}, &reply); err != nil {
+		return "", false, err
+	}
+	if len(reply.KVs) == 0 {
+		return "", false, nil
+	}
+	decoded, err := base64.StdEncoding.DecodeString(reply.KVs[0].Value)
+	return string(decoded), true, err
+}
+
+// the keys of the json api are base64 encoded
+func (arbiter *etcdArbiter) key(name string) string {
+	return encode(arbiter.prefix + "/" + name)
+}
+
+func encode(value string) string {
+	return base64.StdEncoding.EncodeToString([]byte(value))
+}
+
+func (arbiter *etcdArbiter) post(path string, body interface{}, out interface{}) error {
+	payload, err := json.Marshal(body)
+	if err != nil {
+		return err
+	}
+	res, err := arbiter.client.Post(arbiter.endpoint+path, "application/json", bytes.NewReader(payload))
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return fmt.Errorf("unexpected response %v", res.Status)
+	}
+	if out == nil {
+		return nil
+	}
+	return json.NewDecoder(res.Body).Decode(out)
+}
+
+// the record of the node at location, a node without one is dead
+func (arbiter *etcdArbiter) record(location string) (NodeRecord, error) {
+	record := NodeRecord{DBRole: string(state.Dead)}
+	value, ok, err := arbiter.get("nodes/" + location)
+	if err != nil || !ok {
+		return record, err
+	}
+	err = json.Unmarshal([]byte(value), &record)
+	return record, err
+}
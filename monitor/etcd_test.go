@@ -0,0 +1,118 @@
+// Copyright (c) 2015 Pagoda Box Inc
+//
+// This Source Code Form is subject to the terms of the Mozilla Public License, v.
+// 2.0. If a copy of the MPL was not distributed with this file, You can obtain one
+// at http://mozilla.org/MPL/2.0/.
+//
+
+package monitor
+
+import (
+	"encoding/json"
+	"github.com/golang/mock/gomock"
+	"github.com/nanopack/yoke/config"
+	"github.com/nanopack/yoke/state/mock"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+// just enough of the etcd v3 json api, leases never run out
+type fakeEtcd struct {
+	sync.Mutex
+	keys map[string]string
+}
+
+func (etcd *fakeEtcd) ServeHTTP(res http.ResponseWriter, req *http.Request) {
+	etcd.Lock()
+	defer etcd.Unlock()
+	body := map[string]interface{}{}
+	json.NewDecoder(req.Body).Decode(&body)
+	switch req.URL.Path {
+	case "/v3/lease/grant":
+		json.NewEncoder(res).Encode(map[string]string{"ID": "1", "TTL": "10"})
+	case "/v3/lease/keepalive":
+		json.NewEncoder(res).Encode(map[string]interface{}{"result": map[string]string{"ID": "1", "TTL": "10"}})
+	case "/v3/kv/put":
+		etcd.keys[body["key"].(string)] = body["value"].(string)
+	case "/v3/kv/range":
+		kvs := []etcdKV{}
+		if value, ok := etcd.keys[body["key"].(string)]; ok {
+			kvs = append(kvs, etcdKV{Key: body["key"].(string), Value: value})
+		}
+		json.NewEncoder(res).Encode(map[string]interface{}{"kvs": kvs})
+	case "/v3/kv/txn":
+		compare := body["compare"].([]interface{})[0].(map[string]interface{})
+		value, exists := etcd.keys[compare["key"].(string)]
+		succeeded := (compare["target"] == "CREATE" && !exists) || (compare["target"] == "VALUE" && exists && value == compare["value"])
+		if succeeded {
+			op := body["success"].([]interface{})[0].(map[string]interface{})
+			if put, ok := op["request_put"].(map[string]interface{}); ok {
+				etcd.keys[put["key"].(string)] = put["value"].(string)
+			}
+			if remove, ok := op["request_delete_range"].(map[string]interface{}); ok {
+				delete(etcd.keys, remove["key"].(string))
+			}
+		}
+		json.NewEncoder(res).Encode(map[string]bool{"succeeded": succeeded})
+	}
+}
+
+func TestEtcdArbiter(test *testing.T) {
+	ctrl := gomock.NewController(test)
+	defer ctrl.Finish()
+
+	server := httptest.NewServer(&fakeEtcd{keys: map[string]string{}})
+	defer server.Close()
+	arbiter, err := newEtcdArbiter(config.Config{EtcdEndpoint: server.URL, EtcdPrefix: "/yoke", EtcdTTL: 10, RPCTimeout: 1000})
+	if err != nil {
+		test.Log(err)
+		test.FailNow()
+	}
+	etcd := arbiter.(*etcdArbiter)
+
+	// a node that never reported is dead
+	if role, err := etcd.Bounce("10.0.0.1:4400").GetDBRole(); err != nil || role != "dead" {
+		test.Logf("wrong role %v %v", role, err)
+		test.Fail()
+	}
+
+	me := mock_state.NewMockState(ctrl)
+	me.EXPECT().Location().Return("10.0.0.1:4400").AnyTimes()
+	me.EXPECT().GetRole().Return("primary", nil)
+	me.EXPECT().GetDBRole().Return("active", nil)
+	me.EXPECT().HasSynced().Return(false, nil)
+	me.EXPECT().GetPosition().Return(uint64(42), nil)
+	me.EXPECT().GetDataDir().Return("/data", nil)
+	me.EXPECT().Lag().Return(time.Duration(0), int64(0), nil)
+	if err := etcd.report(me); err != nil {
+		test.Log(err)
+		test.FailNow()
+	}
+	view := etcd.Bounce("10.0.0.1:4400")
+	if role, err := view.GetDBRole(); err != nil || role != "active" {
+		test.Logf("wrong role %v %v", role, err)
+		test.Fail()
+	}
+	if position, err := view.GetPosition(); err != nil || position != 42 {
+		test.Logf("wrong position %v %v", position, err)
+		test.Fail()
+	}
+
+	// only one node can hold the leader key
+	if won, err := etcd.Campaign("10.0.0.1:4400"); err != nil || !won {
+		test.Logf("the first campaign should have been won %v", err)
+		test.Fail()
+	}
+	if won, _ := etcd.Campaign("10.0.0.2:4400"); won {
+		test.Log("the key should already have been held")
+		test.Fail()
+	}
+	etcd.resign("10.0.0.1:4400")
+	if won, err := etcd.Campaign("10.0.0.2:4400"); err != nil || !won {
+		test.Logf("the key should have been free again %v", err)
+		test.Fail()
+	}
+}
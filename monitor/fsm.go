@@ -0,0 +1,424 @@
+// Copyright (c) 2015 Pagoda Box Inc
+//
+// This Source Code Form is subject to the terms of the Mozilla Public License, v.
+// 2.0. If a copy of the MPL was not distributed with this file, You can obtain one
+// at http://mozilla.org/MPL/2.0/.
+//
+
+// fsm.go is the state machine the decider goes by. A recheck gathers what the other
+// nodes are doing into a situation, and the first row of the transition table that
+// applies to the situation and to the state this node is in decides what it does.
+
+package monitor
+
+import (
+	"fmt"
+	"github.com/nanopack/yoke/events"
+	"github.com/nanopack/yoke/state"
+	"github.com/nanopack/yoke/trace"
+	"time"
+)
+
+type (
+	// Decision is the row of the transition table the decider last went by
+	Decision struct {
+		Rule string       `json:"rule"`
+		From state.DBRole `json:"from,omitempty"` // empty when the row did not depend on it
+		To   state.DBRole `json:"to,omitempty"`   // empty when the node stayed as it was
+		Time time.Time    `json:"time"`
+	}
+
+	// transition is a row of the transition table. when looks at what the other
+	// nodes are doing, from at the state this node is in, and guard can ask the
+	// nodes for more. A row without a target leaves the node as it is, a row with
+	// 'dead' as its target stops the database.
+	transition struct {
+		name  string
+		when  func(*situation) bool
+		from  []state.DBRole
+		guard func(*decider, *situation) (bool, error)
+		to    state.DBRole
+		then  func(*decider, *situation) error
+	}
+
+	// situation is what a recheck learned about the other nodes. The state of this
+	// node and its role are only read once a row needs them, and only once.
+	situation struct {
+		me        state.State
+		peers     []peer
+		unknown   int           // how many nodes could not be checked at all
+		running   *peer         // the first node that runs a writable database
+		empty     *peer         // the first node that came back without its data
+		backups   []state.State // the nodes that follow the active node
+		following int           // the backups and the nodes that handed over
+		trigger   string        // what the recheck was started by
+		synced    *bool         // if this node has synced, once a row asked
+		phase     SyncPhase     // how far along this node is following, once a row asked
+
+		current  state.DBRole
+		err      error
+		read     bool
+		role     state.Role
+		roleErr  error
+		roleRead bool
+	}
+)
+
+// the transition table, the first row that applies decides what this node does
+var transitionTable = []transition{
+	// this node can't talk to the other members of the cluster or the arbiter, if
+	// this node is not running as single it needs to shut off, unless the
+	// unavailable policy says otherwise
+	{name: "alone", when: alone, from: []state.DBRole{state.Single}},
+	{name: "serve alone", when: alone, guard: servesAlone, then: serveAlone},
+	{name: "read only", when: alone, guard: readOnlyAlone, then: goReadOnly},
+	{name: "unreachable", when: alone, to: state.Dead, then: unreachable},
+
+	// the states that other nodes are already running in take priority. A node that
+	// was writable before it was restarted, and finds that another node took over
+	// and has written since, is rewound or synced into a backup of it
+	{name: "returned", when: running, from: []state.DBRole{state.Single, state.Active}, guard: returned, to: state.Backup, then: measureLag},
+	{name: "split brain", when: running, from: []state.DBRole{state.Single, state.Active}, then: resolveSplitBrain},
+	{name: "follow", when: running, to: state.Backup, then: measureLag},
+
+	// there is a node that we can't see at all, it might be running as active so
+	// it isn't safe to take over until we know what it is doing
+	{name: "wait for unchecked", when: unchecked, then: waitForUnchecked},
+
+	// a node that comes back without its data can't be followed if this node had
+	// the newest copy of it before it was restarted
+	{name: "restore empty", when: empty, guard: hadNewestData, to: state.Active},
+	{name: "primary of empty", when: empty, guard: isPrimary, to: state.Active},
+	{name: "secondary of empty", when: empty, guard: isSecondary, to: state.Backup},
+	{name: "wait with empty", when: empty},
+
+	// every node that is left is either a backup, a node that handed over the
+	// active role and is waiting to follow whoever takes over, or is dead
+	{name: "handed over", from: []state.DBRole{state.Demoted}},
+	// the upgrade could not start the database again, it is left for an operator
+	{name: "upgrading", from: []state.DBRole{state.Upgrading}},
+	// if this node is not synced up to the previous master, then we must wait for
+	// the other node to come online
+	{name: "not synced", from: []state.DBRole{state.Backup}, guard: notSynced, to: state.Dead, then: unavailable},
+	// a backup that was too far behind the active node would lose too much data
+	// by taking over, it has to be promoted by hand
+	{name: "lagging", from: []state.DBRole{state.Backup}, guard: lagging},
+	// the active node may only be rebooting, or cut off for a moment, so it is
+	// given the failover delay to come back before a backup takes over
+	{name: "grace period", from: []state.DBRole{state.Backup}, guard: inGrace},
+	// there is no active node left, so the most caught up backup takes over
+	{name: "take over", from: []state.DBRole{state.Backup}, guard: elected, to: state.Single},
+	{name: "not elected", from: []state.DBRole{state.Backup}},
+	{name: "followed", when: followed, from: []state.DBRole{state.Initialized, state.Single, state.Active, state.Dead}, to: state.Active},
+	{name: "no backup", from: []state.DBRole{state.Initialized, state.Single, state.Active, state.Dead}, to: state.Single},
+}
+
+// newSituation sorts the peers a recheck could see by what they are doing
+func newSituation(me state.State, peers []peer, unknown int) *situation {
+	s := &situation{me: me, peers: peers, unknown: unknown}
+	for i := range peers {
+		peer := &peers[i]
+		switch peer.dbRole {
+		case state.Single, state.Active:
+			if s.running == nil {
+				s.running = peer
+			}
+		case state.Initialized:
+			if s.empty == nil {
+				s.empty = peer
+			}
+		case state.Backup:
+			s.backups = append(s.backups, peer.view)
+			s.following++
+		case state.Demoted:
+			s.following++
+		}
+	}
+	return s
+}
+
+// state returns the state this node is in
+func (s *situation) state() (state.DBRole, error) {
+	if !s.read {
+		s.current, s.err = dbRole(s.me)
+		s.read = true
+	}
+	return s.current, s.err
+}
+
+// clusterRole returns the role this node was given in the config
+func (s *situation) clusterRole() (state.Role, error) {
+	if !s.roleRead {
+		role, err := s.me.GetRole()
+		s.role, s.roleErr = state.Role(role), err
+		s.roleRead = true
+	}
+	return s.role, s.roleErr
+}
+
+// decide goes down the transition table and returns the first row that applies.
+// A state that could not be read keeps every row that depends on it from applying.
+func (decider *decider) decide(s *situation) (*transition, error) {
+	for i := range transitionTable {
+		row := &transitionTable[i]
+		if row.when != nil && !row.when(s) {
+			continue
+		}
+		if len(row.from) != 0 {
+			current, err := s.state()
+			if err != nil || !in(current, row.from) {
+				continue
+			}
+		}
+		if row.guard != nil {
+			ok, err := row.guard(decider, s)
+			if err != nil {
+				return nil, err
+			}
+			if !ok {
+				continue
+			}
+		}
+		return row, nil
+	}
+	current, err := s.state()
+	if err != nil {
+		return nil, err
+	}
+	return nil, fmt.Errorf("there is no transition out of '%v'", current)
+}
+
+// take makes the transition of row, and records it as the decision. The decision
+// only goes into the history when it made a transition, or differs from the last one.
+func (decider *decider) take(row *transition, s *situation) error {
+	decision := Decision{Rule: row.name, To: row.to, Time: now()}
+	if s.read {
+		decision.From = s.current
+	}
+	last := decider.Decided()
+	decider.decision.Store(decision)
+	if row.name != "read only" {
+		decider.writable()
+	}
+
+	outcome := Stayed
+	var err error
+	if row.to != "" && decider.repeats(row.to, s) {
+		decider.log.Debug("already transitioned to '%v', not transitioning again", row.to)
+	} else if row.to != "" {
+		err = decider.apply(row.to)
+		switch {
+		case err != nil:
+		case decider.plan.planning():
+			outcome = Planned
+		default:
+			outcome = Transitioned
+		}
+	}
+	if row.then != nil && err == nil {
+		err = row.then(decider, s)
+	}
+
+	if outcome != Stayed || last.Rule != decision.Rule || last.From != decision.From {
+		entry := HistoryEntry{
+			Time:    decision.Time,
+			Trigger: s.trigger,
+			Rule:    row.name,
+			From:    decision.From,
+			To:      row.to,
+			Unknown: s.unknown,
+			Synced:  s.synced,
+			Phase:   s.phase,
+			Outcome: outcome,
+		}
+		for _, peer := range s.peers {
+			entry.Peers = append(entry.Peers, peer.dbRole)
+		}
+		if err != nil {
+			entry.Outcome, entry.Error = Failed, err.Error()
+		}
+		decider.history.add(entry)
+	}
+	return err
+}
+
+// a transition is only made again once the node is no longer in its target, a node
+// that was stopped stays stopped until it is moved somewhere else
+func (decider *decider) repeats(to state.DBRole, s *situation) bool {
+	if to != decider.applied {
+		return false
+	}
+	if to == state.Dead {
+		return true
+	}
+	current, err := s.state()
+	if err != nil {
+		return false
+	}
+	if current != to {
+		// the transition was made, but it never got to record its role
+		mismatch(decider.log, to, current)
+		return false
+	}
+	return true
+}
+
+// hands the transition to the performer. A transition that is only planned has
+// not been made, so it is not remembered. A node doesn't start taking writes
+// without the promotion lease, when there is one.
+func (decider *decider) apply(to state.DBRole) error {
+	span := decider.span.Child("transition")
+	span.Set("to", string(to))
+	defer span.Finish(nil)
+
+	if to == state.Active || to == state.Single {
+		if err := decider.acquireLease(); err != nil {
+			return err
+		}
+		decider.takeEpoch()
+	}
+	switch to {
+	case state.Active:
+		decider.performer.TransitionToActive()
+	case state.Backup:
+		decider.performer.TransitionToBackup()
+	case state.Single:
+		decider.performer.TransitionToSingle()
+	case state.Dead:
+		decider.performer.Stop()
+	}
+	if decider.plan.planning() {
+		decider.applied = ""
+		return nil
+	}
+	decider.applied = to
+	return nil
+}
+
+// Decided returns the row of the transition table the decider last went by, it is
+// empty before the first decision
+func (decider *decider) Decided() Decision {
+	decision, _ := decider.decision.Load().(Decision)
+	return decision
+}
+
+func in(current state.DBRole, states []state.DBRole) bool {
+	for _, candidate := range states {
+		if current == candidate {
+			return true
+		}
+	}
+	return false
+}
+
+// returns the state the database of node is in
+func dbRole(node state.State) (state.DBRole, error) {
+	role, err := node.GetDBRole()
+	return state.DBRole(role), err
+}
+
+// asks node for its db role in a span of its own inside of parent
+func traced(parent *trace.Span, name string, node state.State) (state.DBRole, error) {
+	span := parent.Child(name)
+	role, err := dbRole(node)
+	span.Set("db_role", string(role))
+	span.Finish(err)
+	return role, err
+}
+
+func alone(s *situation) bool {
+	return len(s.peers) == 0
+}
+
+func running(s *situation) bool {
+	return s.running != nil
+}
+
+func unchecked(s *situation) bool {
+	return s.unknown != 0
+}
+
+func empty(s *situation) bool {
+	return s.empty != nil
+}
+
+func followed(s *situation) bool {
+	return s.following != 0
+}
+
+func returned(decider *decider, s *situation) (bool, error) {
+	return decider.returned(*s.running), nil
+}
+
+func hadNewestData(decider *decider, s *situation) (bool, error) {
+	return decider.hadNewestData(*s.empty), nil
+}
+
+func isPrimary(decider *decider, s *situation) (bool, error) {
+	role, err := decider.clusterRole(s)
+	return role == state.Primary, err
+}
+
+func isSecondary(decider *decider, s *situation) (bool, error) {
+	role, err := decider.clusterRole(s)
+	return role == state.Secondary, err
+}
+
+func notSynced(decider *decider, s *situation) (bool, error) {
+	synced, err := decider.me.HasSynced()
+	if err == nil {
+		s.synced = &synced
+	}
+	if err == nil && !synced {
+		s.phase = Seeding
+	}
+	return !synced, err
+}
+
+func lagging(decider *decider, s *situation) (bool, error) {
+	lagging, phase := decider.lagging()
+	s.phase = phase
+	return lagging, nil
+}
+
+func inGrace(decider *decider, s *situation) (bool, error) {
+	if decider.grace == 0 {
+		return false, nil
+	}
+	if decider.orphaned.IsZero() {
+		decider.orphaned = now()
+		decider.log.Info("there is no active node, waiting %v for it to come back before taking over", decider.grace)
+	}
+	return now().Sub(decider.orphaned) < decider.grace, nil
+}
+
+// only one of the backups can win the campaign
+func elected(decider *decider, s *situation) (bool, error) {
+	won, err := decider.elect(s.backups)
+	return won && decider.campaign(), err
+}
+
+func unreachable(decider *decider, s *situation) error {
+	decider.log.Info("stopped, no one here")
+	events.Publish(events.Event{Type: events.ClusterUnavailable, DBRole: string(s.current)})
+	decider.fenceSelf()
+	return ClusterUnaviable
+}
+
+func unavailable(decider *decider, s *situation) error {
+	return ClusterUnaviable
+}
+
+func resolveSplitBrain(decider *decider, s *situation) error {
+	return decider.splitBrain(*s.running)
+}
+
+func measureLag(decider *decider, s *situation) error {
+	decider.measureLag(s.running.view)
+	return nil
+}
+
+func waitForUnchecked(decider *decider, s *situation) error {
+	decider.log.Info("%v node(s) could not be checked, waiting", s.unknown)
+	return nil
+}
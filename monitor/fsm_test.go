@@ -0,0 +1,70 @@
+// Copyright (c) 2015 Pagoda Box Inc
+//
+// This Source Code Form is subject to the terms of the Mozilla Public License, v.
+// 2.0. If a copy of the MPL was not distributed with this file, You can obtain one
+// at http://mozilla.org/MPL/2.0/.
+//
+
+package monitor
+
+import (
+	"errors"
+	"github.com/golang/mock/gomock"
+	"github.com/nanopack/yoke/state"
+	"github.com/nanopack/yoke/state/mock"
+	"testing"
+)
+
+func TestTransitionTable(test *testing.T) {
+	cases := []struct {
+		rule    string
+		mine    state.DBRole
+		err     error
+		peers   []state.DBRole
+		unknown int
+	}{
+		{rule: "alone", mine: state.Single},
+		{rule: "unreachable", mine: state.Backup},
+		{rule: "unreachable", err: errors.New("no state")},
+		{rule: "split brain", mine: state.Active, peers: []state.DBRole{state.Single}},
+		{rule: "follow", mine: state.Initialized, peers: []state.DBRole{state.Dead, state.Active}},
+		{rule: "wait for unchecked", peers: []state.DBRole{state.Backup}, unknown: 1},
+		{rule: "handed over", mine: state.Demoted, peers: []state.DBRole{state.Backup}},
+		{rule: "followed", mine: state.Single, peers: []state.DBRole{state.Demoted}},
+		{rule: "no backup", mine: state.Active, peers: []state.DBRole{state.Dead}},
+	}
+
+	for _, expected := range cases {
+		ctrl := gomock.NewController(test)
+		me := mock_state.NewMockState(ctrl)
+		// the state of this node is only read when a row depends on it
+		if expected.unknown == 0 {
+			me.EXPECT().GetDBRole().Return(string(expected.mine), expected.err)
+		}
+		peers := []peer{}
+		for _, role := range expected.peers {
+			peers = append(peers, peer{view: mock_state.NewMockState(ctrl), dbRole: role})
+		}
+
+		decider := &decider{me: me}
+		row, err := decider.decide(newSituation(me, peers, expected.unknown))
+		if err != nil || row.name != expected.rule {
+			test.Logf("expected '%v' for %+v, got %+v (%v)", expected.rule, expected, row, err)
+			test.Fail()
+		}
+		ctrl.Finish()
+	}
+}
+
+func TestNoTransition(test *testing.T) {
+	ctrl := gomock.NewController(test)
+	defer ctrl.Finish()
+
+	me := mock_state.NewMockState(ctrl)
+	me.EXPECT().GetDBRole().Return("bogus", nil)
+	decider := &decider{me: me}
+	if row, err := decider.decide(newSituation(me, []peer{{dbRole: state.Dead}}, 0)); err == nil {
+		test.Logf("a state that is not in the table went by '%v'", row.name)
+		test.Fail()
+	}
+}
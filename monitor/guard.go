@@ -0,0 +1,197 @@
+// Copyright (c) 2015 Pagoda Box Inc
+//
+// This Source Code Form is subject to the terms of the Mozilla Public License, v.
+// 2.0. If a copy of the MPL was not distributed with this file, You can obtain one
+// at http://mozilla.org/MPL/2.0/.
+//
+
+// guard.go keeps the WAL of the active node from filling its disk while the backup
+// is down. Postgres holds on to the WAL a replication slot or a failing archive
+// command still needs, which grows without end once no one takes it. Running the
+// disk of the active node full is a worse outage than losing a backup, so past the
+// limit the guard gives up what the WAL was kept for, as the wal_guard policy says.
+
+package monitor
+
+import (
+	"fmt"
+	"github.com/nanopack/yoke/config"
+	"github.com/nanopack/yoke/events"
+	"github.com/nanopack/yoke/state"
+)
+
+// walGuard watches the WAL and the disk of the data directory on the recheck of
+// the active node
+type walGuard struct {
+	conf    config.Config
+	dir     string
+	warn    uint64 // the WAL size that is alerted on, 0 doesn't alert
+	limit   uint64 // the WAL size past which the policy is applied, 0 doesn't apply it on size
+	minFree uint64 // the free space below which the policy is applied, 0 doesn't apply it on space
+	policy  string // 'alert', 'drop_slots' or 'stop_archiving'
+	log     config.Logger
+
+	warned    bool // if the WAL was alerted on, it is again once it was back under warn
+	noArchive bool // if the guard switched the archive command off
+}
+
+// the guard from the [wal_guard] section of the config, nil when it is off or the
+// database isn't postgres
+func newWALGuard(conf config.Config) *walGuard {
+	postgres := conf.Database == "" || conf.Database == "postgres"
+	if !postgres || (conf.WALGuardWarnMB <= 0 && conf.WALGuardLimitMB <= 0 && conf.WALGuardMinFreeMB <= 0) {
+		return nil
+	}
+	return &walGuard{
+		conf:    conf,
+		dir:     conf.DataDir,
+		warn:    uint64(conf.WALGuardWarnMB) * megabyte,
+		limit:   uint64(conf.WALGuardLimitMB) * megabyte,
+		minFree: uint64(conf.WALGuardMinFreeMB) * megabyte,
+		policy:  conf.WALGuardPolicy,
+		log:     conf.Logging(),
+	}
+}
+
+// guardWAL runs the guard while the node takes writes. It never fails the recheck,
+// a guard that can't look at the WAL only logs why.
+func (decider *decider) guardWAL() {
+	if decider.guard == nil {
+		return
+	}
+	role, err := dbRole(decider.me)
+	if err != nil || (role != state.Active && role != state.Single) {
+		return
+	}
+	if err := decider.guard.check(role, decider.plan.planning()); err != nil {
+		decider.log.Error("[wal_guard] could not check the WAL (%v)", err)
+	}
+}
+
+// check alerts once the WAL grew past warn, and applies the policy once it grew
+// past the limit or the disk is nearly full while no backup is streaming from the
+// node. A dry run only logs what the policy would have done.
+func (guard *walGuard) check(role state.DBRole, dryRun bool) error {
+	size, err := walSize(guard.dir)
+	if err != nil {
+		return err
+	}
+	free, err := freeDisk(guard.dir)
+	if err != nil {
+		return err
+	}
+	walBytes.Set("", float64(size))
+	diskFreeBytes.Set("", float64(free))
+
+	usage := fmt.Sprintf("the WAL takes up %vMB, %vMB are free", size/megabyte, free/megabyte)
+	switch {
+	case guard.warn > 0 && size > guard.warn && !guard.warned:
+		guard.log.Warn("[wal_guard] %v", usage)
+		events.Publish(events.Event{Type: events.WALBloat, DBRole: string(role), Error: usage})
+		guard.warned = true
+	case guard.warn == 0 || size <= guard.warn:
+		guard.warned = false
+	}
+
+	full := (guard.limit > 0 && size > guard.limit) || (guard.minFree > 0 && free < guard.minFree)
+	if !full {
+		// a backup that is back takes the WAL again, it is archived again too
+		if guard.noArchive && (guard.warn == 0 || size <= guard.warn) {
+			return guard.resumeArchiving(dryRun)
+		}
+		return nil
+	}
+	if guard.policy == "alert" {
+		return nil
+	}
+
+	db, err := openPostgres(guard.conf)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	// a backup that streams is taking the WAL, it is behind and not gone
+	var streaming int
+	if err := db.QueryRow("select count(*) from pg_stat_replication").Scan(&streaming); err != nil {
+		return err
+	}
+	if streaming > 0 {
+		guard.log.Warn("[wal_guard] %v, but a backup is streaming, leaving the WAL alone", usage)
+		return nil
+	}
+
+	switch guard.policy {
+	case "drop_slots":
+		rows, err := db.Query("select slot_name from pg_replication_slots where not active")
+		if err != nil {
+			return err
+		}
+		slots := []string{}
+		for rows.Next() {
+			var slot string
+			if err := rows.Scan(&slot); err != nil {
+				rows.Close()
+				return err
+			}
+			slots = append(slots, slot)
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return err
+		}
+		for _, slot := range slots {
+			if dryRun {
+				guard.log.Info("[plan] would drop the replication slot '%v' (%v)", slot, usage)
+				continue
+			}
+			guard.log.Error("[wal_guard] %v, dropping the replication slot '%v'", usage, slot)
+			if _, err := db.Exec("select pg_drop_replication_slot($1)", slot); err != nil {
+				return err
+			}
+			events.Publish(events.Event{Type: events.WALDiscarded, DBRole: string(role), Error: fmt.Sprintf("dropped the replication slot '%v', %v", slot, usage)})
+		}
+	case "stop_archiving":
+		if guard.noArchive {
+			return nil
+		}
+		if dryRun {
+			guard.log.Info("[plan] would stop archiving the WAL (%v)", usage)
+			return nil
+		}
+		// a command that succeeds lets postgres recycle the WAL it was holding for the
+		// archive, archive_mode itself can't change without a restart
+		guard.log.Error("[wal_guard] %v, no longer archiving the WAL", usage)
+		if _, err := db.Exec("alter system set archive_command = 'true'"); err != nil {
+			return err
+		}
+		if _, err := db.Exec("select pg_reload_conf()"); err != nil {
+			return err
+		}
+		guard.noArchive = true
+		events.Publish(events.Event{Type: events.WALDiscarded, DBRole: string(role), Error: "stopped archiving the WAL, " + usage})
+	}
+	return nil
+}
+
+// puts the archive command from the config back once the WAL is under warn again
+func (guard *walGuard) resumeArchiving(dryRun bool) error {
+	if dryRun {
+		guard.log.Info("[plan] would archive the WAL again")
+		return nil
+	}
+	db, err := openPostgres(guard.conf)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+	if _, err := db.Exec("alter system reset archive_command"); err != nil {
+		return err
+	}
+	if _, err := db.Exec("select pg_reload_conf()"); err != nil {
+		return err
+	}
+	guard.log.Info("[wal_guard] the WAL is back under the limit, archiving it again")
+	guard.noArchive = false
+	return nil
+}
@@ -0,0 +1,242 @@
+// Copyright (c) 2015 Pagoda Box Inc
+//
+// This Source Code Form is subject to the terms of the Mozilla Public License, v.
+// 2.0. If a copy of the MPL was not distributed with this file, You can obtain one
+// at http://mozilla.org/MPL/2.0/.
+//
+
+package monitor
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/nanopack/yoke/config"
+	"github.com/nanopack/yoke/events"
+	"github.com/nanopack/yoke/state"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+var Unhealthy = errors.New("this node failed its health checks")
+
+type (
+	// HealthCheck is an active check of the local database. GetDBRole answering is
+	// not enough to know that the database can serve traffic, a node that keeps
+	// failing one of its checks is treated as failed.
+	HealthCheck interface {
+		Name() string
+		Check() error
+	}
+
+	// runs 'SELECT 1' against postgres
+	queryCheck struct {
+		conf    config.Config
+		timeout time.Duration
+	}
+
+	// checks the free space on the disk of the data directory
+	diskCheck struct {
+		dir     string
+		minFree uint64
+	}
+
+	// checks how much space the WAL takes up in the data directory
+	walCheck struct {
+		dir     string
+		maxSize uint64
+	}
+
+	// checks how many of the postgres connections are in use
+	connectionCheck struct {
+		conf    config.Config
+		timeout time.Duration
+		maxUsed int
+	}
+)
+
+var (
+	healthLock   sync.Mutex
+	healthChecks []HealthCheck
+)
+
+const megabyte = 1024 * 1024
+
+// RegisterHealthCheck adds a check that every decider runs on its recheck, after
+// the checks from the config
+func RegisterHealthCheck(check HealthCheck) {
+	healthLock.Lock()
+	defer healthLock.Unlock()
+	healthChecks = append(healthChecks, check)
+}
+
+// the checks a decider runs, the ones from the config first
+func newHealthChecks(conf config.Config) []HealthCheck {
+	healthLock.Lock()
+	defer healthLock.Unlock()
+	timeout := time.Duration(conf.HealthTimeout) * time.Second
+	postgres := conf.Database == "" || conf.Database == "postgres"
+	all := []HealthCheck{}
+	if conf.HealthQuery && postgres {
+		all = append(all, queryCheck{conf: conf, timeout: timeout})
+	}
+	if conf.HealthMinFreeDiskMB > 0 {
+		all = append(all, diskCheck{dir: conf.DataDir, minFree: uint64(conf.HealthMinFreeDiskMB) * megabyte})
+	}
+	if conf.HealthMaxWALSizeMB > 0 && postgres {
+		all = append(all, walCheck{dir: conf.DataDir, maxSize: uint64(conf.HealthMaxWALSizeMB) * megabyte})
+	}
+	if conf.HealthMaxConnections > 0 && postgres {
+		all = append(all, connectionCheck{conf: conf, timeout: timeout, maxUsed: conf.HealthMaxConnections})
+	}
+	return append(all, healthChecks...)
+}
+
+func (check queryCheck) Name() string {
+	return "query"
+}
+
+func (check queryCheck) Check() error {
+	db, err := openPostgres(check.conf)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), check.timeout)
+	defer cancel()
+	var one int
+	return db.QueryRowContext(ctx, "SELECT 1").Scan(&one)
+}
+
+func (check diskCheck) Name() string {
+	return "disk"
+}
+
+func (check diskCheck) Check() error {
+	free, err := freeDisk(check.dir)
+	if err != nil {
+		return err
+	}
+	if free < check.minFree {
+		return fmt.Errorf("only %vMB free in '%v'", free/megabyte, check.dir)
+	}
+	return nil
+}
+
+func (check walCheck) Name() string {
+	return "wal"
+}
+
+func (check walCheck) Check() error {
+	size, err := walSize(check.dir)
+	if err != nil {
+		return err
+	}
+	if size > check.maxSize {
+		return fmt.Errorf("the WAL takes up %vMB", size/megabyte)
+	}
+	return nil
+}
+
+// the space the WAL takes up in the data directory dir, it is in pg_wal since
+// postgres 10, and in pg_xlog before that
+func walSize(dir string) (uint64, error) {
+	for _, name := range []string{"pg_wal", "pg_xlog"} {
+		wal := filepath.Join(dir, name)
+		if _, err := os.Stat(wal); err != nil {
+			continue
+		}
+		size := uint64(0)
+		err := filepath.Walk(wal, func(path string, info os.FileInfo, err error) error {
+			if err != nil {
+				return err
+			}
+			if info.Mode().IsRegular() {
+				size += uint64(info.Size())
+			}
+			return nil
+		})
+		return size, err
+	}
+	return 0, nil
+}
+
+func (check connectionCheck) Name() string {
+	return "connections"
+}
+
+func (check connectionCheck) Check() error {
+	db, err := openPostgres(check.conf)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), check.timeout)
+	defer cancel()
+	var used int
+	err = db.QueryRowContext(ctx, "SELECT count(*) * 100 / current_setting('max_connections')::int FROM pg_stat_activity").Scan(&used)
+	if err != nil {
+		return err
+	}
+	if used > check.maxUsed {
+		return fmt.Errorf("%v%% of the connections are in use", used)
+	}
+	return nil
+}
+
+// checkHealth runs the health checks while the database is supposed to be serving.
+// Once they have failed often enough in a row the database is stopped and the node
+// advertises itself as 'dead', so that a backup takes over. The node stays down
+// until it is moved by hand, or restarted.
+func (decider *decider) checkHealth() error {
+	if len(decider.health) == 0 {
+		return nil
+	}
+	role, err := dbRole(decider.me)
+	if err != nil {
+		return err
+	}
+	switch role {
+	case state.Active, state.Single, state.Backup:
+	case state.Dead:
+		if decider.unhealthy {
+			return Unhealthy
+		}
+		return nil
+	default:
+		return nil
+	}
+	decider.unhealthy = false
+
+	for _, check := range decider.health {
+		if err := check.Check(); err != nil {
+			decider.failures++
+			decider.log.Warn("[health] %v check failed %v time(s) in a row (%v)", check.Name(), decider.failures, err)
+			healthFailures.Inc(check.Name())
+			if decider.failures < decider.maxFailures {
+				return nil
+			}
+			decider.failures = 0
+			if decider.plan.planning() {
+				decider.performer.Stop()
+				return nil
+			}
+			decider.log.Error("[health] the node is unhealthy, stopping so a backup can take over")
+			events.Publish(events.Event{Type: events.Unhealthy, DBRole: string(role), Error: err.Error()})
+			decider.unhealthy = true
+			decider.applied = ""
+			decider.performer.Stop()
+			decider.history.add(HistoryEntry{Time: now(), Trigger: "health", From: role, To: state.Dead, Outcome: Transitioned, Error: err.Error()})
+			if err := setDBRole(decider.log, decider.me, state.Dead); err != nil {
+				return err
+			}
+			return Unhealthy
+		}
+	}
+	decider.failures = 0
+	return nil
+}
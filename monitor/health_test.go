@@ -0,0 +1,100 @@
+// Copyright (c) 2015 Pagoda Box Inc
+//
+// This Source Code Form is subject to the terms of the Mozilla Public License, v.
+// 2.0. If a copy of the MPL was not distributed with this file, You can obtain one
+// at http://mozilla.org/MPL/2.0/.
+//
+
+package monitor
+
+import (
+	"github.com/nanopack/yoke/config"
+	"github.com/nanopack/yoke/events"
+	"github.com/nanopack/yoke/state"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestDiskCheck(test *testing.T) {
+	dir, err := ioutil.TempDir("", "yoke-health")
+	if err != nil {
+		test.Log(err)
+		test.FailNow()
+	}
+	defer os.RemoveAll(dir)
+
+	if err := (diskCheck{dir: dir, minFree: 1}).Check(); err != nil {
+		test.Logf("the disk should have had a byte free %v", err)
+		test.Fail()
+	}
+	if err := (diskCheck{dir: dir, minFree: 1 << 62}).Check(); err == nil {
+		test.Log("the disk should not have had that much free")
+		test.Fail()
+	}
+}
+
+func TestWALCheck(test *testing.T) {
+	dir, err := ioutil.TempDir("", "yoke-health")
+	if err != nil {
+		test.Log(err)
+		test.FailNow()
+	}
+	defer os.RemoveAll(dir)
+
+	os.Mkdir(filepath.Join(dir, "pg_xlog"), 0700)
+	if err := ioutil.WriteFile(filepath.Join(dir, "pg_xlog", "000000010000000000000001"), make([]byte, 2048), 0600); err != nil {
+		test.Log(err)
+		test.FailNow()
+	}
+
+	if err := (walCheck{dir: dir, maxSize: 4096}).Check(); err != nil {
+		test.Logf("the WAL should have fit %v", err)
+		test.Fail()
+	}
+	if err := (walCheck{dir: dir, maxSize: 1024}).Check(); err == nil {
+		test.Log("the WAL should have been too big")
+		test.Fail()
+	}
+}
+
+func TestWALGuardWarns(test *testing.T) {
+	dir, err := ioutil.TempDir("", "yoke-health")
+	if err != nil {
+		test.Log(err)
+		test.FailNow()
+	}
+	defer os.RemoveAll(dir)
+
+	os.Mkdir(filepath.Join(dir, "pg_wal"), 0700)
+	if err := ioutil.WriteFile(filepath.Join(dir, "pg_wal", "000000010000000000000001"), make([]byte, 2048), 0600); err != nil {
+		test.Log(err)
+		test.FailNow()
+	}
+
+	bloats := 0
+	unsubscribe := events.Subscribe(func(event events.Event) {
+		if event.Type == events.WALBloat {
+			bloats++
+		}
+	})
+	// with the 'alert' policy the guard never touches the database
+	guard := &walGuard{dir: dir, warn: 1024, limit: 1024, policy: "alert", log: config.Log}
+	for i := 0; i < 3; i++ {
+		if err := guard.check(state.Active, false); err != nil {
+			test.Log(err)
+			test.FailNow()
+		}
+	}
+	guard.warn = 4096
+	guard.check(state.Active, false)
+	guard.warn = 1024
+	guard.check(state.Active, false)
+	unsubscribe()
+
+	if bloats != 2 {
+		test.Logf("the WAL should have been alerted on once each time it grew past warn, not %v times", bloats)
+		test.Fail()
+	}
+}
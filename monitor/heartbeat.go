@@ -0,0 +1,78 @@
+// Copyright (c) 2015 Pagoda Box Inc
+//
+// This Source Code Form is subject to the terms of the Mozilla Public License, v.
+// 2.0. If a copy of the MPL was not distributed with this file, You can obtain one
+// at http://mozilla.org/MPL/2.0/.
+//
+
+// heartbeat.go asks the other nodes if they answer in between the checks, at the
+// heartbeat_interval_ms. A heartbeat is a single call that isn't retried, it only
+// feeds the failure detector of the node. Once a heartbeat has the detector suspect
+// a node, the loop checks the cluster right away instead of waiting for the next
+// check, so how soon a dead node is noticed is no longer bound to the check_interval.
+
+package monitor
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// a state that can be asked once if it answers, the remote states can
+type pinger interface {
+	Ping() error
+}
+
+// sends the heartbeats until ctx is done
+func (decider *decider) heartbeats(ctx context.Context) {
+	ticker := time.NewTicker(decider.beat)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		// a node that times out doesn't hold up the heartbeats of the others
+		var wg sync.WaitGroup
+		for i, other := range decider.others {
+			ping, ok := other.(pinger)
+			if !ok {
+				continue
+			}
+			wg.Add(1)
+			go func(watch *watched, ping pinger) {
+				defer wg.Done()
+				if watch.beat(ping.Ping()) {
+					decider.wakeUp()
+				}
+			}(decider.watching[i], ping)
+		}
+		wg.Wait()
+	}
+}
+
+// has the loop check the cluster now, unless it is about to already
+func (decider *decider) wakeUp() {
+	select {
+	case decider.wake <- struct{}{}:
+	default:
+	}
+}
+
+// beat feeds the outcome of a heartbeat to the detector, it returns true when the
+// heartbeat got the node suspected just now
+func (watch *watched) beat(err error) bool {
+	watch.Lock()
+	defer watch.Unlock()
+	now := now()
+	if err == nil {
+		watch.detector.Success(now)
+		return false
+	}
+	suspected := watch.detector.Suspect(now)
+	watch.detector.Failure(now)
+	return watch.seen && !suspected && watch.detector.Suspect(now)
+}
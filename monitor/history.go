@@ -0,0 +1,129 @@
+// Copyright (c) 2015 Pagoda Box Inc
+//
+// This Source Code Form is subject to the terms of the Mozilla Public License, v.
+// 2.0. If a copy of the MPL was not distributed with this file, You can obtain one
+// at http://mozilla.org/MPL/2.0/.
+//
+
+// history.go keeps the decisions and transitions of the decider. They are appended
+// to a local log as json lines, so that they outlive the node, and the newest of
+// them are kept in memory for the admin api.
+
+package monitor
+
+import (
+	"bufio"
+	"encoding/json"
+	"github.com/nanopack/yoke/config"
+	"github.com/nanopack/yoke/state"
+	"os"
+	"sync"
+	"time"
+)
+
+// how many entries are kept in memory, the log itself is never cut short
+const historyLength = 500
+
+// the outcomes of an entry in the history
+const (
+	Transitioned = "transitioned" // the node was moved to the target
+	Planned      = "planned"      // the transition was only planned
+	Stayed       = "stayed"       // the node was left as it was
+	Failed       = "failed"       // the decision or the transition failed
+)
+
+type (
+	// HistoryEntry is a decision or a transition of the decider, with what it was
+	// made on
+	HistoryEntry struct {
+		Time    time.Time      `json:"time"`
+		Trigger string         `json:"trigger"`        // 'recheck', 'force' or the operator action
+		Rule    string         `json:"rule,omitempty"` // the row of the transition table
+		From    state.DBRole   `json:"from,omitempty"`
+		To      state.DBRole   `json:"to,omitempty"`
+		Peers   []state.DBRole `json:"peers,omitempty"`   // the db roles of the nodes that could be checked
+		Unknown int            `json:"unknown,omitempty"` // how many nodes could not be checked
+		Synced  *bool          `json:"synced,omitempty"`  // only when the decision depended on it
+		Phase   SyncPhase      `json:"phase,omitempty"`   // as with synced
+		Outcome string         `json:"outcome"`
+		Error   string         `json:"error,omitempty"`
+	}
+
+	history struct {
+		sync.Mutex
+		file    *os.File
+		entries []HistoryEntry
+		log     config.Logger
+	}
+)
+
+// newHistory opens the log at path and reads the newest entries back from it. The
+// history is only kept in memory when the path is empty or the log can't be opened.
+func newHistory(path string, log config.Logger) *history {
+	h := &history{log: log}
+	if path == "" {
+		return h
+	}
+	if existing, err := os.Open(path); err == nil {
+		scanner := bufio.NewScanner(existing)
+		for scanner.Scan() {
+			entry := HistoryEntry{}
+			if json.Unmarshal(scanner.Bytes(), &entry) == nil {
+				h.keep(entry)
+			}
+		}
+		existing.Close()
+	}
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+	if err != nil {
+		log.Error("could not open the history '%v', it is only kept in memory (%v)", path, err)
+		return h
+	}
+	h.file = file
+	return h
+}
+
+// add appends entry to the log
+func (h *history) add(entry HistoryEntry) {
+	h.Lock()
+	defer h.Unlock()
+
+	h.keep(entry)
+	if h.file == nil {
+		return
+	}
+	line, err := json.Marshal(entry)
+	if err == nil {
+		_, err = h.file.Write(append(line, '\n'))
+	}
+	if err == nil {
+		err = h.file.Sync()
+	}
+	if err != nil {
+		h.log.Error("could not write to the history (%v)", err)
+	}
+}
+
+func (h *history) keep(entry HistoryEntry) {
+	h.entries = append(h.entries, entry)
+	if len(h.entries) > historyLength {
+		h.entries = h.entries[len(h.entries)-historyLength:]
+	}
+}
+
+// History returns the newest decisions and transitions of the decider, oldest first
+func (decider *decider) History() []HistoryEntry {
+	decider.history.Lock()
+	defer decider.history.Unlock()
+	return append([]HistoryEntry{}, decider.history.entries...)
+}
+
+// records an action an operator asked for, and how it went
+func (decider *decider) audit(trigger string, to state.DBRole, err error) error {
+	entry := HistoryEntry{Time: now(), Trigger: trigger, To: to, Outcome: Transitioned}
+	if err != nil {
+		entry.Outcome, entry.Error = Failed, err.Error()
+	}
+	decider.history.add(entry)
+	return err
+}
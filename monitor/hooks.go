@@ -0,0 +1,114 @@
+// Copyright (c) 2015 Pagoda Box Inc
+//
+// This Source Code Form is subject to the terms of the Mozilla Public License, v.
+// 2.0. If a copy of the MPL was not distributed with this file, You can obtain one
+// at http://mozilla.org/MPL/2.0/.
+//
+
+package monitor
+
+import (
+	"context"
+	"fmt"
+	"github.com/hoisie/mustache"
+	"github.com/nanopack/yoke/config"
+	"sync"
+	"time"
+)
+
+type (
+	// Hook is run around every transition the performer makes (to "active",
+	// "backup", "single" or "stop"), from is the db role the node is leaving. A
+	// Before that returns an error aborts the transition, except for stopping
+	// which always goes ahead.
+	Hook interface {
+		Before(transition, from string) error
+		After(transition, from string, err error)
+	}
+
+	// runs the pre and post transition commands from the config
+	commandHook struct {
+		pre     string
+		post    string
+		timeout time.Duration
+		log     config.Logger
+	}
+)
+
+var (
+	hookLock sync.Mutex
+	hooks    []Hook
+)
+
+// RegisterHook adds a hook that every performer runs around its transitions, after
+// the commands from the config
+func RegisterHook(hook Hook) {
+	hookLock.Lock()
+	defer hookLock.Unlock()
+	hooks = append(hooks, hook)
+}
+
+// the hooks a performer runs, the config commands first
+func newHooks(conf config.Config) []Hook {
+	hookLock.Lock()
+	defer hookLock.Unlock()
+	all := []Hook{}
+	if conf.PreHookCommand != "" || conf.PostHookCommand != "" {
+		all = append(all, commandHook{
+			pre:     conf.PreHookCommand,
+			post:    conf.PostHookCommand,
+			timeout: time.Duration(conf.HookTimeout) * time.Second,
+			log:     conf.Logging(),
+		})
+	}
+	return append(all, hooks...)
+}
+
+func (hook commandHook) Before(transition, from string) error {
+	return hook.run("PreHookCommand", hook.pre, transition, from, "")
+}
+
+func (hook commandHook) After(transition, from string, err error) {
+	result := "succeeded"
+	if err != nil {
+		result = "failed"
+	}
+	if err := hook.run("PostHookCommand", hook.post, transition, from, result); err != nil {
+		hook.log.Error("[action] post transition command failed (%v)", err)
+	}
+}
+
+func (hook commandHook) run(name, command, transition, from, result string) error {
+	if command == "" {
+		return nil
+	}
+	command = mustache.Render(command, map[string]string{"transition": transition, "from": from, "result": result})
+
+	ctx, cancel := context.WithTimeout(context.Background(), hook.timeout)
+	defer cancel()
+	cmd := config.Shell(ctx, command)
+	cmd.Stdout = NewPrefix("[" + name + ".stdout]")
+	cmd.Stderr = NewPrefix("[" + name + ".stderr]")
+	hook.log.Debug("[action] %v(%s)", name, command)
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("%v: %v", name, err)
+	}
+	return nil
+}
+
+// runs every Before hook, stopping at the first one that fails
+func (performer *performer) before(transition, from string) error {
+	for _, hook := range performer.hooks {
+		if err := hook.Before(transition, from); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// runs every After hook
+func (performer *performer) after(transition, from string, err error) {
+	for _, hook := range performer.hooks {
+		hook.After(transition, from, err)
+	}
+}
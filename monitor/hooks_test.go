@@ -0,0 +1,155 @@
+// Copyright (c) 2015 Pagoda Box Inc
+//
+// This Source Code Form is subject to the terms of the Mozilla Public License, v.
+// 2.0. If a copy of the MPL was not distributed with this file, You can obtain one
+// at http://mozilla.org/MPL/2.0/.
+//
+
+package monitor
+
+import (
+	"errors"
+	"github.com/golang/mock/gomock"
+	"github.com/nanopack/yoke/config"
+	"github.com/nanopack/yoke/state/mock"
+	"testing"
+)
+
+// remembers what it was run around and can refuse every transition
+type hooked struct {
+	refuse error
+	before []string
+	after  []string
+	errs   []error
+}
+
+func (hook *hooked) Before(transition, from string) error {
+	hook.before = append(hook.before, transition+" from "+from)
+	return hook.refuse
+}
+
+func (hook *hooked) After(transition, from string, err error) {
+	hook.after = append(hook.after, transition+" from "+from)
+	hook.errs = append(hook.errs, err)
+}
+
+// registers hook for the performers created until the returned func is called
+func register(hook Hook) func() {
+	hookLock.Lock()
+	registered := hooks
+	hookLock.Unlock()
+	RegisterHook(hook)
+	return func() {
+		hookLock.Lock()
+		hooks = registered
+		hookLock.Unlock()
+	}
+}
+
+func TestHookAbortsTransitions(test *testing.T) {
+	ctrl := gomock.NewController(test)
+	defer ctrl.Finish()
+
+	hook := &hooked{refuse: errors.New("not now")}
+	defer register(hook)()
+
+	me := mock_state.NewMockState(ctrl)
+	perform, database := faked(me, nil, config.Config{})
+
+	me.EXPECT().GetDBRole().Return("single", nil)
+	perform.TransitionToActive()
+	me.EXPECT().GetDBRole().Return("active", nil)
+	perform.TransitionToBackup()
+	me.EXPECT().GetDBRole().Return("backup", nil)
+	perform.TransitionToSingle()
+
+	if len(database.calls) != 0 {
+		test.Log("a transition went ahead although its pre hook failed", database.calls)
+		test.Fail()
+	}
+	if len(hook.before) != 3 {
+		test.Log("the pre hook was not run before every transition", hook.before)
+		test.Fail()
+	}
+	if len(hook.after) != 0 {
+		test.Log("post hooks ran for transitions that never started", hook.after)
+		test.Fail()
+	}
+}
+
+func TestHookCanNotPreventStop(test *testing.T) {
+	ctrl := gomock.NewController(test)
+	defer ctrl.Finish()
+
+	hook := &hooked{refuse: errors.New("not now")}
+	defer register(hook)()
+
+	me := mock_state.NewMockState(ctrl)
+	perform, database := faked(me, nil, config.Config{})
+
+	me.EXPECT().GetDBRole().Return("active", nil)
+	perform.Stop()
+
+	if len(database.calls) != 1 || database.calls[0] != "stop" {
+		test.Log("a failing pre hook kept the database from stopping", database.calls)
+		test.Fail()
+	}
+	if len(hook.after) != 1 || hook.after[0] != "stop from active" {
+		test.Log("the post hook was not run after stopping", hook.after)
+		test.Fail()
+	}
+}
+
+func TestHookAfterGetsError(test *testing.T) {
+	ctrl := gomock.NewController(test)
+	defer ctrl.Finish()
+
+	hook := &hooked{}
+	defer register(hook)()
+
+	me := mock_state.NewMockState(ctrl)
+	perform, database := faked(me, nil, config.Config{})
+	database.err = errors.New("promotion failed")
+
+	me.EXPECT().GetDBRole().Return("single", nil)
+	perform.TransitionToActive()
+
+	if len(hook.after) != 1 || hook.after[0] != "active from single" {
+		test.Log("the post hook was not run after the transition", hook.after)
+		test.FailNow()
+	}
+	if hook.errs[0] != database.err {
+		test.Log("the post hook did not get the error of the transition", hook.errs[0])
+		test.Fail()
+	}
+
+	database.err = nil
+	me.EXPECT().GetDBRole().Return("active", nil)
+	perform.TransitionToBackup()
+	if len(hook.errs) != 2 || hook.errs[1] != nil {
+		test.Log("the post hook got an error for a transition that succeeded", hook.errs)
+		test.Fail()
+	}
+}
+
+func TestPreHookCommand(test *testing.T) {
+	ctrl := gomock.NewController(test)
+	defer ctrl.Finish()
+
+	me := mock_state.NewMockState(ctrl)
+	perform, database := faked(me, nil, config.Config{PreHookCommand: "test {{transition}} != active", HookTimeout: 5})
+
+	me.EXPECT().GetDBRole().Return("single", nil)
+	perform.TransitionToActive()
+	if len(database.calls) != 0 {
+		test.Log("a transition went ahead although the PreHookCommand failed", database.calls)
+		test.Fail()
+	}
+
+	me.EXPECT().GetDBRole().Return("single", nil)
+	perform.TransitionToBackup()
+	if len(database.calls) != 1 || database.calls[0] != "backup" {
+		test.Log("a transition the PreHookCommand allowed did not go ahead", database.calls)
+		test.Fail()
+	}
+}
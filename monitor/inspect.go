@@ -0,0 +1,76 @@
+// Copyright (c) 2015 Pagoda Box Inc
+//
+// This Source Code Form is subject to the terms of the Mozilla Public License, v.
+// 2.0. If a copy of the MPL was not distributed with this file, You can obtain one
+// at http://mozilla.org/MPL/2.0/.
+//
+
+// inspect.go keeps what the last recheck believed about the cluster, so that a
+// program embedding the decider, or the admin api, can look at it without waiting
+// for a recheck that is in flight.
+
+package monitor
+
+import (
+	"github.com/nanopack/yoke/state"
+	"time"
+)
+
+type (
+	// ClusterState is what the last recheck made of the cluster
+	ClusterState struct {
+		Checked  time.Time    `json:"checked"`
+		Trigger  string       `json:"trigger"`
+		DBRole   state.DBRole `json:"db_role,omitempty"` // only when a row of the table read it
+		Peers    []PeerState  `json:"peers"`
+		Unknown  int          `json:"unknown"` // how many of the peers could not be checked
+		Decision Decision     `json:"decision"`
+	}
+
+	// PeerState is what the last recheck made of one of the other nodes
+	PeerState struct {
+		Location string       `json:"location"`
+		DBRole   state.DBRole `json:"db_role,omitempty"` // empty when it could not be checked
+		Reached  string       `json:"reached"`           // 'direct', 'bounced' or 'unreachable'
+
+		view state.State
+	}
+
+	// atomic.Value can't hold a nil error
+	checkError struct {
+		err error
+	}
+)
+
+// State returns what the last recheck made of the cluster, it is empty before the
+// first one
+func (decider *decider) State() ClusterState {
+	believed, _ := decider.believed.Load().(ClusterState)
+	believed.Peers = append([]PeerState{}, believed.Peers...)
+	for i := range believed.Peers {
+		believed.Peers[i].Location = believed.Peers[i].view.Location()
+	}
+	return believed
+}
+
+// LastError returns what the last recheck failed with, it is nil when it went
+// through
+func (decider *decider) LastError() error {
+	last, _ := decider.lastErr.Load().(checkError)
+	return last.err
+}
+
+// remembers what a recheck made of the cluster
+func (decider *decider) believe(s *situation, peers []PeerState) {
+	believed := ClusterState{
+		Checked:  now(),
+		Trigger:  s.trigger,
+		Peers:    peers,
+		Unknown:  s.unknown,
+		Decision: decider.Decided(),
+	}
+	if s.read {
+		believed.DBRole = s.current
+	}
+	decider.believed.Store(believed)
+}
@@ -0,0 +1,75 @@
+// Copyright (c) 2015 Pagoda Box Inc
+//
+// This Source Code Form is subject to the terms of the Mozilla Public License, v.
+// 2.0. If a copy of the MPL was not distributed with this file, You can obtain one
+// at http://mozilla.org/MPL/2.0/.
+//
+
+// keyring.go passes the ssh keys of the node on to the other nodes along with its
+// summary, and trusts the keys they pass on, so that any of them can copy the data
+// to the others when it takes over.
+
+package monitor
+
+import (
+	"sync"
+
+	"github.com/nanopack/yoke/config"
+	"github.com/nanopack/yoke/state"
+)
+
+// Keyring is the ssh keys of the node, see sshkeys
+type Keyring interface {
+	// Keys are the public key of the node and the host key of its sshd
+	Keys() (key, hostKey string)
+	// Trust authorizes the key of the node at location and pins its host key
+	Trust(location, key, hostKey string) error
+}
+
+var (
+	keyringLock sync.Mutex
+	keyring     Keyring
+)
+
+// RegisterKeyring has the deciders exchange the keys of keys with the other nodes
+func RegisterKeyring(keys Keyring) {
+	keyringLock.Lock()
+	defer keyringLock.Unlock()
+	keyring = keys
+}
+
+func registeredKeyring() Keyring {
+	keyringLock.Lock()
+	defer keyringLock.Unlock()
+	return keyring
+}
+
+// keys fills in the keys of this node for its summary
+func (decider *decider) keys(summary *state.Summary) {
+	if keys := registeredKeyring(); keys != nil {
+		summary.SSHKey, summary.HostKey = keys.Keys()
+	}
+}
+
+// trustKeys trusts the keys of the nodes a recheck reached directly, from their
+// summaries
+func (decider *decider) trustKeys(seen []PeerState) {
+	keys := registeredKeyring()
+	if keys == nil {
+		return
+	}
+	for _, peer := range seen {
+		summary, ok := peer.view.(summarizer)
+		if !ok || peer.Reached != "direct" {
+			continue
+		}
+		other, err := summary.GetSummary()
+		if err != nil || (other.SSHKey == "" && other.HostKey == "") {
+			continue
+		}
+		location := peer.view.Location()
+		if err := keys.Trust(location, other.SSHKey, other.HostKey); err != nil {
+			decider.log.With(config.Fields{"peer": location}).Warn("[ssh] the keys of '%v' are not trusted (%v)", location, err)
+		}
+	}
+}
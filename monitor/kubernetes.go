@@ -0,0 +1,211 @@
+// Copyright (c) 2015 Pagoda Box Inc
+//
+// This Source Code Form is subject to the terms of the Mozilla Public License, v.
+// 2.0. If a copy of the MPL was not distributed with this file, You can obtain one
+// at http://mozilla.org/MPL/2.0/.
+//
+
+// kubernetes.go arbitrates the cluster through Lease objects, so that a
+// StatefulSet can run without a monitor pod. Every node renews a lease of its own
+// with its record in an annotation, a node whose lease ran out is seen as 'dead'.
+// The active node holds the leader lease, a backup has to win it before it takes
+// over.
+
+package monitor
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/nanopack/yoke/config"
+	"github.com/nanopack/yoke/kube"
+	"github.com/nanopack/yoke/state"
+	"strings"
+	"time"
+)
+
+// the annotation the record of a node is kept in
+const recordAnnotation = "yoke.nanopack.io/record"
+
+type kubeArbiter struct {
+	client *kube.Client
+	prefix string
+	ttl    time.Duration
+	log    config.Logger
+}
+
+func init() {
+	RegisterArbiter("kubernetes", newKubeArbiter)
+}
+
+func newKubeArbiter(conf config.Config) (Arbiter, error) {
+	client, err := kube.InCluster(conf.KubeAPI, conf.KubeNamespace)
+	if err != nil {
+		return nil, err
+	}
+	return &kubeArbiter{client: client, prefix: conf.KubeLeasePrefix, ttl: time.Duration(conf.KubeLeaseTTL) * time.Second, log: conf.Logging()}, nil
+}
+
+// Ready blocks until the api server answers
+func (arbiter *kubeArbiter) Ready() {
+	for {
+		if _, err := arbiter.client.GetLease(arbiter.leaderLease()); err == nil || kube.IsNotFound(err) {
+			return
+		}
+		<-time.After(time.Second)
+	}
+}
+
+func (arbiter *kubeArbiter) Bounce(location string) state.State {
+	return recordView{records: arbiter, location: location}
+}
+
+// Report renews the lease of me until ctx is done. The node holds
+// the leader lease while it is running as the active node, and lets go of it as
+// soon as it stops.
+func (arbiter *kubeArbiter) Report(ctx context.Context, me state.State) {
+	location := me.Location()
+	for {
+		if err := arbiter.report(me); err != nil {
+			arbiter.log.Warn("[kubernetes] could not report the state of this node (%v)", err)
+		} else if role, err := dbRole(me); err == nil {
+			switch role {
+			case state.Active, state.Single:
+				arbiter.Campaign(location)
+			default:
+				arbiter.resign(location)
+			}
+		}
+		if ctx.Err() != nil {
+			return
+		}
+		select {
+		case <-ctx.Done():
+		case <-time.After(arbiter.ttl / 3):
+		}
+	}
+}
+
+// Campaign tries to take the leader lease for location, it returns if location
+// holds it afterwards
+func (arbiter *kubeArbiter) Campaign(location string) (bool, error) {
+	lease, err := arbiter.client.GetLease(arbiter.leaderLease())
+	if kube.IsNotFound(err) {
+		err = arbiter.client.CreateLease(arbiter.newLease(arbiter.leaderLease(), location))
+		if kube.IsConflict(err) {
+			// someone else created it first
+			return false, nil
+		}
+		return err == nil, err
+	}
+	if err != nil {
+		return false, err
+	}
+	if lease.Spec.HolderIdentity != location && lease.Spec.HolderIdentity != "" && !arbiter.expired(lease) {
+		return false, nil
+	}
+
+	lease.Spec.HolderIdentity = location
+	lease.Spec.LeaseDurationSeconds = arbiter.seconds()
+	lease.Spec.RenewTime = time.Now().UTC().Format(kube.MicroTime)
+	err = arbiter.client.UpdateLease(lease)
+	if kube.IsConflict(err) {
+		// the lease changed since it was read, someone else got to it first
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// gives up the leader lease, if location holds it
+func (arbiter *kubeArbiter) resign(location string) error {
+	lease, err := arbiter.client.GetLease(arbiter.leaderLease())
+	if kube.IsNotFound(err) {
+		return nil
+	}
+	if err != nil || lease.Spec.HolderIdentity != location {
+		return err
+	}
+	lease.Spec.HolderIdentity = ""
+	return arbiter.client.UpdateLease(lease)
+}
+
+func (arbiter *kubeArbiter) report(me state.State) error {
+	record, err := NewRecord(me)
+	if err != nil {
+		return err
+	}
+	value, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+
+	name := arbiter.nodeLease(me.Location())
+	lease, err := arbiter.client.GetLease(name)
+	if kube.IsNotFound(err) {
+		lease = arbiter.newLease(name, me.Location())
+		lease.Metadata.Annotations = map[string]string{recordAnnotation: string(value)}
+		return arbiter.client.CreateLease(lease)
+	}
+	if err != nil {
+		return err
+	}
+	if lease.Metadata.Annotations == nil {
+		lease.Metadata.Annotations = map[string]string{}
+	}
+	lease.Metadata.Annotations[recordAnnotation] = string(value)
+	lease.Spec.HolderIdentity = me.Location()
+	lease.Spec.LeaseDurationSeconds = arbiter.seconds()
+	lease.Spec.RenewTime = time.Now().UTC().Format(kube.MicroTime)
+	return arbiter.client.UpdateLease(lease)
+}
+
+// the record of the node at location, a node without a lease, or whose lease ran
+// out, is dead
+func (arbiter *kubeArbiter) record(location string) (NodeRecord, error) {
+	record := NodeRecord{DBRole: string(state.Dead)}
+	lease, err := arbiter.client.GetLease(arbiter.nodeLease(location))
+	if kube.IsNotFound(err) {
+		return record, nil
+	}
+	if err != nil || arbiter.expired(lease) {
+		return record, err
+	}
+	value, ok := lease.Metadata.Annotations[recordAnnotation]
+	if !ok {
+		return record, nil
+	}
+	err = json.Unmarshal([]byte(value), &record)
+	return record, err
+}
+
+func (arbiter *kubeArbiter) newLease(name, holder string) kube.Lease {
+	return kube.Lease{
+		Metadata: kube.Metadata{Name: name},
+		Spec: kube.LeaseSpec{
+			HolderIdentity:       holder,
+			LeaseDurationSeconds: arbiter.seconds(),
+			RenewTime:            time.Now().UTC().Format(kube.MicroTime),
+		},
+	}
+}
+
+// a lease that was not renewed within its duration has run out
+func (arbiter *kubeArbiter) expired(lease kube.Lease) bool {
+	renewed, err := time.Parse(time.RFC3339Nano, lease.Spec.RenewTime)
+	if err != nil {
+		return true
+	}
+	return time.Since(renewed) > time.Duration(lease.Spec.LeaseDurationSeconds)*time.Second
+}
+
+func (arbiter *kubeArbiter) seconds() int {
+	return int(arbiter.ttl / time.Second)
+}
+
+func (arbiter *kubeArbiter) leaderLease() string {
+	return arbiter.prefix + "-leader"
+}
+
+// the names of objects can not hold the dots and colons of a location
+func (arbiter *kubeArbiter) nodeLease(location string) string {
+	return arbiter.prefix + "-node-" + strings.NewReplacer(".", "-", ":", "-").Replace(location)
+}
@@ -0,0 +1,133 @@
+// Copyright (c) 2015 Pagoda Box Inc
+//
+// This Source Code Form is subject to the terms of the Mozilla Public License, v.
+// 2.0. If a copy of the MPL was not distributed with this file, You can obtain one
+// at http://mozilla.org/MPL/2.0/.
+//
+
+package monitor
+
+import (
+	"encoding/json"
+	"github.com/golang/mock/gomock"
+	"github.com/nanopack/yoke/kube"
+	"github.com/nanopack/yoke/state/mock"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// just enough of the lease api, with the optimistic locking of updates
+type fakeLeases struct {
+	sync.Mutex
+	leases  map[string]kube.Lease
+	version int
+}
+
+func (api *fakeLeases) ServeHTTP(res http.ResponseWriter, req *http.Request) {
+	api.Lock()
+	defer api.Unlock()
+	name := strings.TrimPrefix(req.URL.Path, "/apis/coordination.k8s.io/v1/namespaces/default/leases")
+	name = strings.TrimPrefix(name, "/")
+	lease := kube.Lease{}
+	json.NewDecoder(req.Body).Decode(&lease)
+	switch req.Method {
+	case "GET":
+		current, ok := api.leases[name]
+		if !ok {
+			res.WriteHeader(http.StatusNotFound)
+			return
+		}
+		json.NewEncoder(res).Encode(current)
+	case "POST":
+		if _, ok := api.leases[lease.Metadata.Name]; ok {
+			res.WriteHeader(http.StatusConflict)
+			return
+		}
+		api.store(lease)
+		res.WriteHeader(http.StatusCreated)
+	case "PUT":
+		if api.leases[name].Metadata.ResourceVersion != lease.Metadata.ResourceVersion {
+			res.WriteHeader(http.StatusConflict)
+			return
+		}
+		api.store(lease)
+	}
+}
+
+func (api *fakeLeases) store(lease kube.Lease) {
+	api.version++
+	lease.Metadata.ResourceVersion = strconv.Itoa(api.version)
+	api.leases[lease.Metadata.Name] = lease
+}
+
+func TestKubeArbiter(test *testing.T) {
+	ctrl := gomock.NewController(test)
+	defer ctrl.Finish()
+
+	api := &fakeLeases{leases: map[string]kube.Lease{}}
+	server := httptest.NewServer(api)
+	defer server.Close()
+	arbiter := &kubeArbiter{client: kube.New(server.URL, "default", "token", http.DefaultClient), prefix: "yoke", ttl: 10 * time.Second}
+
+	// a node that never reported is dead
+	if role, err := arbiter.Bounce("10.0.0.1:4400").GetDBRole(); err != nil || role != "dead" {
+		test.Logf("wrong role %v %v", role, err)
+		test.Fail()
+	}
+
+	me := mock_state.NewMockState(ctrl)
+	me.EXPECT().Location().Return("10.0.0.1:4400").AnyTimes()
+	me.EXPECT().GetRole().Return("primary", nil).Times(2)
+	me.EXPECT().GetDBRole().Return("active", nil).Times(2)
+	me.EXPECT().HasSynced().Return(false, nil).Times(2)
+	me.EXPECT().GetPosition().Return(uint64(42), nil).Times(2)
+	me.EXPECT().GetDataDir().Return("/data", nil).Times(2)
+	me.EXPECT().Lag().Return(time.Duration(0), int64(0), nil).Times(2)
+	// the first report creates the lease, the second renews it
+	for i := 0; i < 2; i++ {
+		if err := arbiter.report(me); err != nil {
+			test.Log(err)
+			test.FailNow()
+		}
+	}
+	view := arbiter.Bounce("10.0.0.1:4400")
+	if position, err := view.GetPosition(); err != nil || position != 42 {
+		test.Logf("wrong position %v %v", position, err)
+		test.Fail()
+	}
+
+	// a lease that was not renewed in time is dead
+	api.Lock()
+	lease := api.leases["yoke-node-10-0-0-1-4400"]
+	lease.Spec.RenewTime = time.Now().Add(-time.Minute).UTC().Format(kube.MicroTime)
+	api.leases[lease.Metadata.Name] = lease
+	api.Unlock()
+	if role, err := view.GetDBRole(); err != nil || role != "dead" {
+		test.Logf("wrong role %v %v", role, err)
+		test.Fail()
+	}
+
+	// only one node can hold the leader lease
+	if won, err := arbiter.Campaign("10.0.0.1:4400"); err != nil || !won {
+		test.Logf("the first campaign should have been won %v", err)
+		test.Fail()
+	}
+	if won, _ := arbiter.Campaign("10.0.0.2:4400"); won {
+		test.Log("the lease should already have been held")
+		test.Fail()
+	}
+	if won, err := arbiter.Campaign("10.0.0.1:4400"); err != nil || !won {
+		test.Logf("the holder should have renewed the lease %v", err)
+		test.Fail()
+	}
+	arbiter.resign("10.0.0.1:4400")
+	if won, err := arbiter.Campaign("10.0.0.2:4400"); err != nil || !won {
+		test.Logf("the lease should have been free again %v", err)
+		test.Fail()
+	}
+}
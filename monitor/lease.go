@@ -0,0 +1,235 @@
+// Copyright (c) 2015 Pagoda Box Inc
+//
+// This Source Code Form is subject to the terms of the Mozilla Public License, v.
+// 2.0. If a copy of the MPL was not distributed with this file, You can obtain one
+// at http://mozilla.org/MPL/2.0/.
+//
+
+// lease.go makes taking writes mutually exclusive. With a promotion_lease set, a
+// node only becomes active or single once it holds the lease of the arbiter, and
+// renews it on every recheck for as long as it takes writes. A node that finds
+// another node holding the lease it held stops its database. The monitor hands the
+// lease out itself, one for each database of the nodes, the etcd, kubernetes and
+// objectstore arbiters hand out their leader key. A node that could not renew the
+// lease stops its database before the lease runs out, by its own clock.
+
+package monitor
+
+import (
+	"errors"
+	"github.com/nanopack/yoke/events"
+	"github.com/nanopack/yoke/state"
+	"time"
+)
+
+var NoLease = errors.New("this node does not hold the promotion lease")
+
+// a node that could not renew the lease stops its database a quarter of the ttl
+// before the lease runs out, so that a clock that runs slower than the one of the
+// arbiter does not keep it writable after another node took the lease
+const leaseMargin = 4
+
+// an arbiter that hands out the lease on the promotion of the cluster. The lease
+// as it is after the call is returned, an empty holder is no one.
+type leaser interface {
+	AcquireLease(cluster, holder string, ttl time.Duration) (state.Lease, error)
+	ReleaseLease(cluster, holder string) error
+}
+
+// acquireLease takes the lease before this node starts taking writes, it fails
+// with NoLease when it can't
+func (decider *decider) acquireLease() error {
+	if decider.leaseTTL == 0 || decider.plan.planning() {
+		return nil
+	}
+	arbiter, ok := decider.arbiter.(leaser)
+	if !ok {
+		return nil
+	}
+	location := decider.me.Location()
+	asked := now()
+	lease, err := arbiter.AcquireLease(decider.cluster, location, decider.leaseTTL)
+	switch {
+	case isProtocolError(err):
+		decider.log.Error("the arbiter is too old to hand out the promotion lease, not taking writes (%v)", err)
+		return NoLease
+	case err != nil:
+		decider.log.Warn("could not take the promotion lease, not taking writes (%v)", err)
+		return NoLease
+	case lease.Holder != location && lease.Holder != "":
+		decider.log.Info("'%v' holds the promotion lease, not taking writes", lease.Holder)
+		return NoLease
+	case lease.Holder != location:
+		decider.log.Info("the promotion lease isn't handed out yet, not taking writes")
+		return NoLease
+	}
+	decider.leased = true
+	decider.leaseExpires = decider.runsOut(asked, lease)
+	return nil
+}
+
+// runsOut works out when the lease that was asked for at asked runs out, by the
+// clock of this node. The expiry the arbiter answered with only counts when it is
+// sooner than the ttl, the clock of the arbiter may be off.
+func (decider *decider) runsOut(asked time.Time, lease state.Lease) time.Time {
+	ttl := decider.leaseTTL
+	if left := lease.Expires.Sub(now()); !lease.Expires.IsZero() && left < ttl {
+		ttl = left
+	}
+	return asked.Add(ttl)
+}
+
+func isProtocolError(err error) bool {
+	_, ok := err.(state.ProtocolError)
+	return ok
+}
+
+// renewLease keeps the lease while the node takes writes and gives it back once it
+// doesn't. A node that lost the lease to another node is stopped, and so is a node
+// that could not renew it before it runs out.
+func (decider *decider) renewLease() {
+	arbiter, ok := decider.arbiter.(leaser)
+	if decider.leaseTTL == 0 || !ok || decider.plan.planning() {
+		return
+	}
+	role, err := dbRole(decider.me)
+	if err != nil {
+		return
+	}
+	if role != state.Active && role != state.Single {
+		decider.releaseLease()
+		return
+	}
+	location := decider.me.Location()
+	asked := now()
+	lease, err := arbiter.AcquireLease(decider.cluster, location, decider.leaseTTL)
+	if err == nil && lease.Holder == location {
+		decider.leased = true
+		decider.leaseExpires = decider.runsOut(asked, lease)
+		return
+	}
+	if err == nil && lease.Holder != "" {
+		decider.log.Error("'%v' took the promotion lease, stopping the database", lease.Holder)
+		events.Publish(events.Event{Type: events.SplitBrain, DBRole: string(role), Peer: lease.Holder, Error: "took the promotion lease"})
+		decider.leased = false
+		decider.apply(state.Dead)
+		return
+	}
+	if err == nil {
+		err = errors.New("it isn't handed out yet")
+	}
+	if asked.Before(decider.leaseExpires.Add(-decider.leaseTTL / leaseMargin)) {
+		decider.log.Warn("could not renew the promotion lease (%v)", err)
+		return
+	}
+	decider.log.Error("could not renew the promotion lease before it runs out, stopping the database (%v)", err)
+	decider.leased = false
+	decider.apply(state.Dead)
+}
+
+// gives the lease back, when this node took it
+func (decider *decider) releaseLease() {
+	arbiter, ok := decider.arbiter.(leaser)
+	if !ok || !decider.leased {
+		return
+	}
+	if err := arbiter.ReleaseLease(decider.cluster, decider.me.Location()); err != nil {
+		decider.log.Warn("could not give the promotion lease back (%v)", err)
+		return
+	}
+	decider.leased = false
+}
+
+// AcquireLease takes the lease on a majority of the monitors. A node that only got
+// some of them gives those back when another node holds the others, so that two
+// nodes can't split the monitors between them for good.
+func (set *monitorSet) AcquireLease(cluster, holder string, ttl time.Duration) (state.Lease, error) {
+	granted := 0
+	other := state.Lease{}
+	var err error
+	for _, monitor := range set.monitors {
+		arbiter, ok := monitor.(leaser)
+		if !ok {
+			continue
+		}
+		lease, acquireErr := arbiter.AcquireLease(cluster, holder, ttl)
+		switch {
+		case acquireErr != nil:
+			err = acquireErr
+		case lease.Holder == holder:
+			granted++
+		case lease.Holder != "":
+			other = lease
+		}
+	}
+	if granted > len(set.monitors)/2 {
+		return state.Lease{Holder: holder, Expires: now().Add(ttl)}, nil
+	}
+	if other.Holder != "" {
+		set.ReleaseLease(cluster, holder)
+		return other, nil
+	}
+	return state.Lease{}, err
+}
+
+// ReleaseLease gives the lease back on every monitor
+func (set *monitorSet) ReleaseLease(cluster, holder string) error {
+	var err error
+	for _, monitor := range set.monitors {
+		if arbiter, ok := monitor.(leaser); ok {
+			if releaseErr := arbiter.ReleaseLease(cluster, holder); releaseErr != nil {
+				err = releaseErr
+			}
+		}
+	}
+	return err
+}
+
+// AcquireLease takes the leader key for holder, it is renewed with the record of
+// the node. The ttl of the etcd lease applies, and each database has a prefix of
+// its own.
+func (arbiter *etcdArbiter) AcquireLease(cluster, holder string, ttl time.Duration) (state.Lease, error) {
+	if _, err := arbiter.Campaign(holder); err != nil {
+		return state.Lease{}, err
+	}
+	leader, _, err := arbiter.get("leader")
+	return state.Lease{Holder: leader, Expires: now().Add(arbiter.ttl)}, err
+}
+
+func (arbiter *etcdArbiter) ReleaseLease(cluster, holder string) error {
+	return arbiter.resign(holder)
+}
+
+// AcquireLease takes the leader lease for holder, it is renewed with the record of
+// the node. The lease_ttl of the kubernetes section applies.
+func (arbiter *kubeArbiter) AcquireLease(cluster, holder string, ttl time.Duration) (state.Lease, error) {
+	if _, err := arbiter.Campaign(holder); err != nil {
+		return state.Lease{}, err
+	}
+	lease, err := arbiter.client.GetLease(arbiter.leaderLease())
+	if err != nil {
+		return state.Lease{}, err
+	}
+	return state.Lease{Holder: lease.Spec.HolderIdentity, Expires: now().Add(arbiter.ttl)}, nil
+}
+
+func (arbiter *kubeArbiter) ReleaseLease(cluster, holder string) error {
+	return arbiter.resign(holder)
+}
+
+// AcquireLease takes the leader object for holder, it is renewed with the record of
+// the node. The ttl of the objectstore section applies.
+func (arbiter *objectArbiter) AcquireLease(cluster, holder string, ttl time.Duration) (state.Lease, error) {
+	if _, err := arbiter.Campaign(holder); err != nil {
+		return state.Lease{}, err
+	}
+	leader, _, err := arbiter.read(arbiter.leaderKey())
+	if err != nil || arbiter.expired(leader) {
+		return state.Lease{}, err
+	}
+	return state.Lease{Holder: leader.Holder, Expires: leader.Renewed.Add(arbiter.ttl)}, nil
+}
+
+func (arbiter *objectArbiter) ReleaseLease(cluster, holder string) error {
+	return arbiter.resign(holder)
+}
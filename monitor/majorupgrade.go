@@ -0,0 +1,436 @@
+// Copyright (c) 2015 Pagoda Box Inc
+//
+// This Source Code Form is subject to the terms of the Mozilla Public License, v.
+// 2.0. If a copy of the MPL was not distributed with this file, You can obtain one
+// at http://mozilla.org/MPL/2.0/.
+//
+
+// majorupgrade.go moves a postgres cluster to a new major version with pg_upgrade.
+// Physical replication does not work across major versions, so the upgrade is made
+// in stages an operator steps through one at a time:
+//
+//	check    a backup checks that pg_upgrade can upgrade its data
+//	freeze   the active node stops taking writes
+//	upgrade  a backup catches up, and upgrades its data with pg_upgrade
+//	stop     every other node stops its database
+//	promote  the upgraded backup starts on the new version and takes over
+//	reseed   the other nodes take a new copy of its data as backups
+//
+// Until the upgrade stage the active node can be thawed to back out.
+
+package monitor
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"github.com/nanopack/yoke/config"
+	"github.com/nanopack/yoke/events"
+	"github.com/nanopack/yoke/state"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+var (
+	NotUpgradable = errors.New("the database of this node can't be upgraded with pg_upgrade")
+	NotFrozen     = errors.New("the active node has to be frozen first")
+	NotCaughtUp   = errors.New("the backup did not catch up with the frozen active node in time")
+	StillRunning  = errors.New("another node still runs its database as the active node")
+	UnknownStage  = errors.New("there is no such stage of a major upgrade")
+)
+
+// UpgradeStages are the stages of a major upgrade in the order they are run, and
+// 'thaw', which backs out of it
+var UpgradeStages = []string{"check", "freeze", "upgrade", "stop", "promote", "reseed", "thaw"}
+
+// a performer that can move its database to a new major version, the postgres
+// performer can
+type majorUpgrader interface {
+	writeProtector
+	// CheckUpgrade has pg_upgrade check that the running database can be upgraded
+	CheckUpgrade() error
+	// UpgradeData has the database take writes, stops it and upgrades its data
+	UpgradeData() error
+	// Reseed has the database take a new copy of the data of the active node on the
+	// new version the next time it becomes a backup
+	Reseed() error
+}
+
+// MajorUpgrade runs a stage of a major upgrade on this node, timeout is how long
+// the upgrade stage waits for the backup to catch up
+func (decider *decider) MajorUpgrade(stage string, timeout time.Duration) error {
+	decider.Lock()
+	defer decider.Unlock()
+	defer decider.plan.working()()
+
+	if decider.shutdown {
+		return ShutDown
+	}
+	upgrader, ok := decider.plan.Performer.(majorUpgrader)
+	if !ok {
+		return NotUpgradable
+	}
+	role, err := dbRole(decider.me)
+	if err != nil {
+		return err
+	}
+
+	trigger := "major upgrade " + stage
+	switch stage {
+	case "check":
+		if role != state.Backup {
+			return NotBackup
+		}
+		return decider.audit(trigger, role, upgrader.CheckUpgrade())
+	case "freeze":
+		if role != state.Active {
+			return NotActive
+		}
+		return decider.audit(trigger, role, decider.freeze(upgrader))
+	case "thaw":
+		if role != state.Active {
+			return NotActive
+		}
+		return decider.audit(trigger, role, decider.thaw(upgrader))
+	case "upgrade":
+		if role != state.Backup {
+			return NotBackup
+		}
+		return decider.audit(trigger, state.Upgrading, decider.upgradeData(upgrader, timeout))
+	case "stop":
+		if role != state.Backup && role != state.Active {
+			return NotBackup
+		}
+		if role == state.Active && !decider.frozen {
+			return NotFrozen
+		}
+		return decider.audit(trigger, state.Upgrading, decider.stopForUpgrade())
+	case "promote":
+		if role != state.Upgrading {
+			return NotUpgradable
+		}
+		return decider.audit(trigger, state.Single, decider.promoteUpgraded())
+	case "reseed":
+		if role != state.Upgrading {
+			return NotUpgradable
+		}
+		return decider.audit(trigger, state.Backup, decider.reseed(upgrader))
+	}
+	return UnknownStage
+}
+
+// freeze has the active node turn down writes so its backup can catch up with all
+// of them, and stops it from going single while the backup is upgraded
+func (decider *decider) freeze(upgrader majorUpgrader) error {
+	if err := upgrader.ReadOnly(true); err != nil {
+		return err
+	}
+	decider.log.Info("[upgrade] frozen, pausing automatic transitions")
+	decider.frozen = true
+	decider.paused = true
+
+	// the backup is caught up once it replayed up to here
+	position, err := decider.performer.Position()
+	if err != nil {
+		return err
+	}
+	return decider.me.SetPosition(position)
+}
+
+func (decider *decider) thaw(upgrader majorUpgrader) error {
+	if err := upgrader.ReadOnly(false); err != nil {
+		return err
+	}
+	decider.log.Info("[upgrade] thawed, resuming automatic transitions")
+	decider.frozen = false
+	decider.paused = false
+	return nil
+}
+
+// upgradeData waits for the backup to replay everything the frozen active node
+// wrote, and upgrades its data. The database is left stopped until it is promoted.
+func (decider *decider) upgradeData(upgrader majorUpgrader, timeout time.Duration) error {
+	var frozen state.State
+	for _, other := range decider.others {
+		if role, err := dbRole(other); err == nil && role == state.Active {
+			frozen = other
+		}
+	}
+	if frozen == nil {
+		return NotFrozen
+	}
+	target, err := frozen.GetPosition()
+	if err != nil {
+		return err
+	}
+	deadline := time.Now().Add(timeout)
+	for {
+		position, err := decider.performer.Position()
+		if err == nil && position >= target {
+			break
+		}
+		if time.Now().After(deadline) {
+			return NotCaughtUp
+		}
+		<-time.After(time.Second)
+	}
+
+	decider.log.Info("[upgrade] caught up, upgrading the data with pg_upgrade")
+	if err := setDBRole(decider.log, decider.me, state.Upgrading); err != nil {
+		return err
+	}
+	events.Publish(events.Event{Type: events.UpgradeStarted, DBRole: string(state.Upgrading)})
+	decider.applied = ""
+	decider.paused = true
+	if err := upgrader.UpgradeData(); err != nil {
+		events.Publish(events.Event{Type: events.TransitionFailed, DBRole: string(state.Upgrading), Error: err.Error()})
+		return err
+	}
+	// the upgrade command puts the new binaries in place of the old ones
+	return decider.runUpgrade()
+}
+
+// stopForUpgrade stops a node that is going to be reseeded from the upgraded one
+func (decider *decider) stopForUpgrade() error {
+	decider.log.Info("[upgrade] stopping the database to be reseeded on the new version")
+	if err := setDBRole(decider.log, decider.me, state.Upgrading); err != nil {
+		return err
+	}
+	events.Publish(events.Event{Type: events.UpgradeStarted, DBRole: string(state.Upgrading)})
+	decider.applied = ""
+	decider.paused = true
+	decider.frozen = false
+	decider.plan.Performer.Stop()
+	return nil
+}
+
+// promoteUpgraded starts the upgraded database and has it take over, once no other
+// node runs as the active node any more
+func (decider *decider) promoteUpgraded() error {
+	for _, other := range decider.others {
+		role, err := dbRole(other)
+		if err != nil {
+			return fmt.Errorf("'%v' could not be checked (%v)", other.Location(), err)
+		}
+		if role == state.Active || role == state.Single {
+			return StillRunning
+		}
+	}
+
+	decider.log.Info("[upgrade] starting the upgraded database")
+	if err := decider.plan.Performer.Start(); err != nil {
+		return err
+	}
+	if err := decider.acquireLease(); err != nil {
+		return err
+	}
+	decider.takeEpoch()
+	decider.paused = false
+	decider.plan.Performer.TransitionToSingle()
+	if err := decider.recorded(state.Single); err != nil {
+		return err
+	}
+	events.Publish(events.Event{Type: events.UpgradeCompleted, DBRole: string(state.Single)})
+	return nil
+}
+
+// reseed has a stopped node follow the upgraded one, with a new copy of its data
+func (decider *decider) reseed(upgrader majorUpgrader) error {
+	if err := decider.runUpgrade(); err != nil {
+		return err
+	}
+	if err := upgrader.Reseed(); err != nil {
+		return err
+	}
+	decider.log.Info("[upgrade] following the upgraded node")
+	decider.paused = false
+	decider.plan.Performer.TransitionToBackup()
+	if err := decider.recorded(state.Backup); err != nil {
+		return err
+	}
+	events.Publish(events.Event{Type: events.UpgradeCompleted, DBRole: string(state.Backup)})
+	return nil
+}
+
+// CheckUpgrade creates an empty data_dir for the new version and has pg_upgrade
+// check the running database against it
+func (performer *performer) CheckUpgrade() error {
+	if performer.database != database(performer) || performer.config.UpgradeNewBinDir == "" {
+		return NotUpgradable
+	}
+	performer.Lock()
+	defer performer.Unlock()
+
+	if err := performer.initUpgrade(); err != nil {
+		return err
+	}
+	check := performer.config.PGUpgrade(true)
+	check.Stdout = NewPrefix("[pg_upgrade.stdout]")
+	check.Stderr = NewPrefix("[pg_upgrade.stderr]")
+	return check.Run()
+}
+
+// UpgradeData upgrades the data of the database with pg_upgrade, and puts the
+// upgraded data_dir in place of the old one, which is kept next to it
+func (performer *performer) UpgradeData() error {
+	if performer.database != database(performer) || performer.config.UpgradeNewBinDir == "" {
+		return NotUpgradable
+	}
+	performer.Lock()
+	defer performer.Unlock()
+
+	if err := performer.initUpgrade(); err != nil {
+		return err
+	}
+	// pg_upgrade only takes a database that was shut down after it took writes
+	if err := performer.finishRecovery(); err != nil {
+		return err
+	}
+	if err := performer.stop(); err != nil {
+		return err
+	}
+
+	upgrade := performer.config.PGUpgrade(false)
+	upgrade.Stdout = NewPrefix("[pg_upgrade.stdout]")
+	upgrade.Stderr = NewPrefix("[pg_upgrade.stderr]")
+	if err := upgrade.Run(); err != nil {
+		return err
+	}
+
+	conf := performer.config
+	if err := os.Rename(strings.TrimSuffix(conf.DataDir, "/"), strings.TrimSuffix(conf.OldDataDir(), "/")); err != nil {
+		return err
+	}
+	if err := os.Rename(strings.TrimSuffix(conf.UpgradeDataDir(), "/"), strings.TrimSuffix(conf.DataDir, "/")); err != nil {
+		return err
+	}
+	performer.log.Info("[action] upgraded, the data of the old version is kept in '%v'", conf.OldDataDir())
+
+	// the new data_dir comes with the config files of initdb
+	hosts := []string{}
+	for _, other := range performer.others {
+		if host, _, err := net.SplitHostPort(other.Location()); err == nil {
+			hosts = append(hosts, host)
+		}
+	}
+	if err := conf.ConfigureHBAConf(hosts...); err != nil {
+		return err
+	}
+	if err := conf.ConfigurePGConf("0.0.0.0", conf.PGPort); err != nil {
+		return err
+	}
+	performer.version = 0
+	return nil
+}
+
+// Reseed stops the database, it is copied over from the active node on the version
+// of the new binaries when it becomes a backup
+func (performer *performer) Reseed() error {
+	if performer.database != database(performer) || performer.config.UpgradeNewBinDir == "" {
+		return NotUpgradable
+	}
+	performer.Lock()
+	defer performer.Unlock()
+
+	if err := performer.stop(); err != nil {
+		return err
+	}
+	version, err := binaryVersion(performer.config.UpgradeNewBinDir)
+	if err != nil {
+		return err
+	}
+	performer.version = version
+	return performer.me.SetSynced(false)
+}
+
+// initUpgrade creates the empty data_dir pg_upgrade upgrades into, with the same
+// encoding, locale and checksums as the running database
+func (performer *performer) initUpgrade() error {
+	conf := performer.config
+	if _, err := os.Stat(conf.OldDataDir()); err == nil {
+		return fmt.Errorf("'%v' is left from an earlier upgrade, it has to be removed first", conf.OldDataDir())
+	}
+	version, err := binaryVersion(conf.UpgradeNewBinDir)
+	if err != nil {
+		return err
+	}
+	if version.Major() <= performer.pgVersion().Major() {
+		return fmt.Errorf("postgres %v of the new_bin_dir is not newer than %v", version, performer.pgVersion())
+	}
+
+	db, err := performer.pgConnect()
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+	args, err := initdbArgs(db)
+	if err != nil {
+		return err
+	}
+
+	if err := os.RemoveAll(conf.UpgradeDataDir()); err != nil {
+		return err
+	}
+	args = append(args, "-D", conf.UpgradeDataDir(), "-U", conf.SystemUser)
+	init := exec.Command(filepath.Join(conf.UpgradeNewBinDir, "initdb"), args...)
+	init.Stdout = NewPrefix("[initdb.stdout]")
+	init.Stderr = NewPrefix("[initdb.stderr]")
+	return init.Run()
+}
+
+// the options of initdb that make a data_dir pg_upgrade can upgrade db into
+func initdbArgs(db *sql.DB) ([]string, error) {
+	var encoding, collate, ctype, checksums string
+	if err := db.QueryRow("select pg_encoding_to_char(encoding), datcollate, datctype from pg_database where datname = 'template1'").Scan(&encoding, &collate, &ctype); err != nil {
+		return nil, err
+	}
+	if err := db.QueryRow("show data_checksums").Scan(&checksums); err != nil {
+		return nil, err
+	}
+	args := []string{"--encoding=" + encoding, "--lc-collate=" + collate, "--lc-ctype=" + ctype}
+	if checksums == "on" {
+		args = append(args, "--data-checksums")
+	}
+	return args, nil
+}
+
+// finishRecovery promotes a backup and waits for it to take writes
+func (performer *performer) finishRecovery() error {
+	if err := performer.replicate(false); err != nil {
+		return err
+	}
+	db, err := performer.pgConnect()
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+	for tries := 0; tries < 60; tries++ {
+		var recovering bool
+		if err := db.QueryRow("select pg_is_in_recovery()").Scan(&recovering); err != nil {
+			return err
+		}
+		if !recovering {
+			return nil
+		}
+		<-time.After(time.Second)
+	}
+	return fmt.Errorf("the database did not finish its recovery within 60 seconds")
+}
+
+// binaryVersion asks the postgres in bin for its version
+func binaryVersion(bin string) (config.PGVersion, error) {
+	out, err := exec.Command(filepath.Join(bin, "postgres"), "--version").Output()
+	if err != nil {
+		return 0, err
+	}
+	// postgres (PostgreSQL) 15.4
+	fields := strings.Fields(string(out))
+	if len(fields) < 3 {
+		return 0, fmt.Errorf("'%s' is not the version of postgres", strings.TrimSpace(string(out)))
+	}
+	return config.ParsePGVersion(fields[2])
+}
@@ -0,0 +1,80 @@
+// Copyright (c) 2015 Pagoda Box Inc
+//
+// This Source Code Form is subject to the terms of the Mozilla Public License, v.
+// 2.0. If a copy of the MPL was not distributed with this file, You can obtain one
+// at http://mozilla.org/MPL/2.0/.
+//
+
+package monitor
+
+import (
+	"github.com/nanopack/yoke/metrics"
+	"sync"
+)
+
+var (
+	transitions      = metrics.NewCounter("yoke_transitions_total", "Number of role transitions this node has made.", "role")
+	recheckFailures  = metrics.NewCounter("yoke_recheck_failures_total", "Number of rechecks of the cluster that failed.", "")
+	verifications    = metrics.NewCounter("yoke_sync_verifications_total", "Number of copies of the data this node took that were verified, by how they turned out.", "result")
+	fences           = metrics.NewCounter("yoke_fences_total", "Number of times this node fenced another node before taking over.", "result")
+	splitBrains      = metrics.NewCounter("yoke_split_brains_total", "Number of times another node was found running as the active node too.", "policy")
+	healthFailures   = metrics.NewCounter("yoke_health_check_failures_total", "Number of health checks of the local database that failed.", "check")
+	recheckDuration  = metrics.NewTimer("yoke_recheck_seconds", "How long the rechecks of the cluster took.", "")
+	clusterAvailable = metrics.NewGauge("yoke_cluster_available", "Whether this node could reach the rest of the cluster on the last recheck.", "")
+	walBytes         = metrics.NewGauge("yoke_wal_size_bytes", "How much space the WAL takes up in the data directory, only reported with the wal guard on.", "")
+	diskFreeBytes    = metrics.NewGauge("yoke_disk_free_bytes", "How much space is left on the disk of the data directory, only reported with the wal guard on.", "")
+)
+
+// the deciders running in the process, one for every [instance], the gauges below
+// are collected from all of them
+var (
+	reportingLock sync.Mutex
+	reporting     = map[*decider]bool{}
+)
+
+func init() {
+	metrics.NewGaugeFunc("yoke_replication_lag_bytes", "How far each backup is behind this node, only reported on the active node.", "peer", replicationLag)
+	metrics.NewGaugeFunc("yoke_seconds_since_last_bounce", "Seconds since the arbiter last answered a bounced check.", "instance", secondsSinceBounce)
+}
+
+// report has the gauges collect from the decider until the returned func is called
+func report(decider *decider) func() {
+	reportingLock.Lock()
+	defer reportingLock.Unlock()
+	reporting[decider] = true
+	return func() {
+		reportingLock.Lock()
+		defer reportingLock.Unlock()
+		delete(reporting, decider)
+	}
+}
+
+func reported() []*decider {
+	reportingLock.Lock()
+	defer reportingLock.Unlock()
+	deciders := make([]*decider, 0, len(reporting))
+	for decider := range reporting {
+		deciders = append(deciders, decider)
+	}
+	return deciders
+}
+
+// the peers of the instances never share an address, so their lag can go in one
+// map
+func replicationLag() map[string]float64 {
+	lag := map[string]float64{}
+	for _, decider := range reported() {
+		for peer, bytes := range decider.lag() {
+			lag[peer] = bytes
+		}
+	}
+	return lag
+}
+
+func secondsSinceBounce() map[string]float64 {
+	since := map[string]float64{}
+	for _, decider := range reported() {
+		since[decider.cluster] = decider.sinceBounce()
+	}
+	return since
+}
@@ -0,0 +1,41 @@
+// Copyright (c) 2015 Pagoda Box Inc
+//
+// This Source Code Form is subject to the terms of the Mozilla Public License, v.
+// 2.0. If a copy of the MPL was not distributed with this file, You can obtain one
+// at http://mozilla.org/MPL/2.0/.
+//
+
+package monitor
+
+import (
+	"math"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestGaugesOfEveryInstance(test *testing.T) {
+	first := &decider{cluster: "first"}
+	second := &decider{cluster: "second"}
+	atomic.StoreInt64(&second.lastBounce, time.Now().Add(-time.Minute).UnixNano())
+
+	stopFirst := report(first)
+	stopSecond := report(second)
+	defer stopSecond()
+
+	since := secondsSinceBounce()
+	if value, ok := since["first"]; !ok || !math.IsNaN(value) {
+		test.Logf("the first instance was not reported %v", since)
+		test.Fail()
+	}
+	if value, ok := since["second"]; !ok || value < 60 {
+		test.Logf("the second instance was not reported %v", since)
+		test.Fail()
+	}
+
+	stopFirst()
+	if _, ok := secondsSinceBounce()["first"]; ok {
+		test.Log("an instance was still reported after its decider stopped")
+		test.Fail()
+	}
+}
@@ -1,10 +1,14 @@
 // Automatically generated by MockGen. DO NOT EDIT!
-// Source: github.com/nanopack/yoke/monitor (interfaces: Performer)
+// Source: github.com/nanopack/yoke/monitor (interfaces: Performer,Decider)
 
 package mock_monitor
 
 import (
+	context "context"
 	gomock "github.com/golang/mock/gomock"
+	config "github.com/nanopack/yoke/config"
+	monitor "github.com/nanopack/yoke/monitor"
+	time "time"
 )
 
 // Mock of Performer interface
@@ -48,6 +52,28 @@ func (_mr *_MockPerformerRecorder) Loop() *gomock.Call {
 	return _mr.mock.ctrl.RecordCall(_mr.mock, "Loop")
 }
 
+func (_m *MockPerformer) Position() (uint64, error) {
+	ret := _m.ctrl.Call(_m, "Position")
+	ret0, _ := ret[0].(uint64)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+func (_mr *_MockPerformerRecorder) Position() *gomock.Call {
+	return _mr.mock.ctrl.RecordCall(_mr.mock, "Position")
+}
+
+func (_m *MockPerformer) ReplayDelay() (time.Duration, error) {
+	ret := _m.ctrl.Call(_m, "ReplayDelay")
+	ret0, _ := ret[0].(time.Duration)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+func (_mr *_MockPerformerRecorder) ReplayDelay() *gomock.Call {
+	return _mr.mock.ctrl.RecordCall(_mr.mock, "ReplayDelay")
+}
+
 func (_m *MockPerformer) Start() error {
 	ret := _m.ctrl.Call(_m, "Start")
 	ret0, _ := ret[0].(error)
@@ -89,3 +115,257 @@ func (_m *MockPerformer) TransitionToSingle() {
 func (_mr *_MockPerformerRecorder) TransitionToSingle() *gomock.Call {
 	return _mr.mock.ctrl.RecordCall(_mr.mock, "TransitionToSingle")
 }
+
+// Mock of Decider interface
+type MockDecider struct {
+	ctrl     *gomock.Controller
+	recorder *_MockDeciderRecorder
+}
+
+// Recorder for MockDecider (not exported)
+type _MockDeciderRecorder struct {
+	mock *MockDecider
+}
+
+func NewMockDecider(ctrl *gomock.Controller) *MockDecider {
+	mock := &MockDecider{ctrl: ctrl}
+	mock.recorder = &_MockDeciderRecorder{mock}
+	return mock
+}
+
+func (_m *MockDecider) EXPECT() *_MockDeciderRecorder {
+	return _m.recorder
+}
+
+func (_m *MockDecider) Decided() monitor.Decision {
+	ret := _m.ctrl.Call(_m, "Decided")
+	ret0, _ := ret[0].(monitor.Decision)
+	return ret0
+}
+
+func (_mr *_MockDeciderRecorder) Decided() *gomock.Call {
+	return _mr.mock.ctrl.RecordCall(_mr.mock, "Decided")
+}
+
+func (_m *MockDecider) Demote() error {
+	ret := _m.ctrl.Call(_m, "Demote")
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+func (_mr *_MockDeciderRecorder) Demote() *gomock.Call {
+	return _mr.mock.ctrl.RecordCall(_mr.mock, "Demote")
+}
+
+func (_m *MockDecider) DryRun(_param0 bool) {
+	_m.ctrl.Call(_m, "DryRun", _param0)
+}
+
+func (_mr *_MockDeciderRecorder) DryRun(arg0 interface{}) *gomock.Call {
+	return _mr.mock.ctrl.RecordCall(_mr.mock, "DryRun", arg0)
+}
+
+func (_m *MockDecider) Force() error {
+	ret := _m.ctrl.Call(_m, "Force")
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+func (_mr *_MockDeciderRecorder) Force() *gomock.Call {
+	return _mr.mock.ctrl.RecordCall(_mr.mock, "Force")
+}
+
+func (_m *MockDecider) History() []monitor.HistoryEntry {
+	ret := _m.ctrl.Call(_m, "History")
+	ret0, _ := ret[0].([]monitor.HistoryEntry)
+	return ret0
+}
+
+func (_mr *_MockDeciderRecorder) History() *gomock.Call {
+	return _mr.mock.ctrl.RecordCall(_mr.mock, "History")
+}
+
+func (_m *MockDecider) InMaintenance() bool {
+	ret := _m.ctrl.Call(_m, "InMaintenance")
+	ret0, _ := ret[0].(bool)
+	return ret0
+}
+
+func (_mr *_MockDeciderRecorder) InMaintenance() *gomock.Call {
+	return _mr.mock.ctrl.RecordCall(_mr.mock, "InMaintenance")
+}
+
+func (_m *MockDecider) Join(_param0 string) error {
+	ret := _m.ctrl.Call(_m, "Join", _param0)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+func (_mr *_MockDeciderRecorder) Join(arg0 interface{}) *gomock.Call {
+	return _mr.mock.ctrl.RecordCall(_mr.mock, "Join", arg0)
+}
+
+func (_m *MockDecider) LastError() error {
+	ret := _m.ctrl.Call(_m, "LastError")
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+func (_mr *_MockDeciderRecorder) LastError() *gomock.Call {
+	return _mr.mock.ctrl.RecordCall(_mr.mock, "LastError")
+}
+
+func (_m *MockDecider) LastLoop() time.Time {
+	ret := _m.ctrl.Call(_m, "LastLoop")
+	ret0, _ := ret[0].(time.Time)
+	return ret0
+}
+
+func (_mr *_MockDeciderRecorder) LastLoop() *gomock.Call {
+	return _mr.mock.ctrl.RecordCall(_mr.mock, "LastLoop")
+}
+
+func (_m *MockDecider) Loop(_param0 context.Context, _param1 time.Duration) error {
+	ret := _m.ctrl.Call(_m, "Loop", _param0, _param1)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+func (_mr *_MockDeciderRecorder) Loop(arg0, arg1 interface{}) *gomock.Call {
+	return _mr.mock.ctrl.RecordCall(_mr.mock, "Loop", arg0, arg1)
+}
+
+func (_m *MockDecider) Maintenance(_param0 bool) error {
+	ret := _m.ctrl.Call(_m, "Maintenance", _param0)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+func (_mr *_MockDeciderRecorder) Maintenance(arg0 interface{}) *gomock.Call {
+	return _mr.mock.ctrl.RecordCall(_mr.mock, "Maintenance", arg0)
+}
+
+func (_m *MockDecider) MajorUpgrade(_param0 string, _param1 time.Duration) error {
+	ret := _m.ctrl.Call(_m, "MajorUpgrade", _param0, _param1)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+func (_mr *_MockDeciderRecorder) MajorUpgrade(arg0, arg1 interface{}) *gomock.Call {
+	return _mr.mock.ctrl.RecordCall(_mr.mock, "MajorUpgrade", arg0, arg1)
+}
+
+func (_m *MockDecider) Pause() {
+	_m.ctrl.Call(_m, "Pause")
+}
+
+func (_mr *_MockDeciderRecorder) Pause() *gomock.Call {
+	return _mr.mock.ctrl.RecordCall(_mr.mock, "Pause")
+}
+
+func (_m *MockDecider) Paused() bool {
+	ret := _m.ctrl.Call(_m, "Paused")
+	ret0, _ := ret[0].(bool)
+	return ret0
+}
+
+func (_mr *_MockDeciderRecorder) Paused() *gomock.Call {
+	return _mr.mock.ctrl.RecordCall(_mr.mock, "Paused")
+}
+
+func (_m *MockDecider) Planned() (bool, *monitor.Plan) {
+	ret := _m.ctrl.Call(_m, "Planned")
+	ret0, _ := ret[0].(bool)
+	ret1, _ := ret[1].(*monitor.Plan)
+	return ret0, ret1
+}
+
+func (_mr *_MockDeciderRecorder) Planned() *gomock.Call {
+	return _mr.mock.ctrl.RecordCall(_mr.mock, "Planned")
+}
+
+func (_m *MockDecider) Promote() error {
+	ret := _m.ctrl.Call(_m, "Promote")
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+func (_mr *_MockDeciderRecorder) Promote() *gomock.Call {
+	return _mr.mock.ctrl.RecordCall(_mr.mock, "Promote")
+}
+
+func (_m *MockDecider) PromoteDR() error {
+	ret := _m.ctrl.Call(_m, "PromoteDR")
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+func (_mr *_MockDeciderRecorder) PromoteDR() *gomock.Call {
+	return _mr.mock.ctrl.RecordCall(_mr.mock, "PromoteDR")
+}
+
+func (_m *MockDecider) ReCheck() error {
+	ret := _m.ctrl.Call(_m, "ReCheck")
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+func (_mr *_MockDeciderRecorder) ReCheck() *gomock.Call {
+	return _mr.mock.ctrl.RecordCall(_mr.mock, "ReCheck")
+}
+
+func (_m *MockDecider) Reload(_param0 config.Config) {
+	_m.ctrl.Call(_m, "Reload", _param0)
+}
+
+func (_mr *_MockDeciderRecorder) Reload(arg0 interface{}) *gomock.Call {
+	return _mr.mock.ctrl.RecordCall(_mr.mock, "Reload", arg0)
+}
+
+func (_m *MockDecider) Resume() {
+	_m.ctrl.Call(_m, "Resume")
+}
+
+func (_mr *_MockDeciderRecorder) Resume() *gomock.Call {
+	return _mr.mock.ctrl.RecordCall(_mr.mock, "Resume")
+}
+
+func (_m *MockDecider) Shutdown() error {
+	ret := _m.ctrl.Call(_m, "Shutdown")
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+func (_mr *_MockDeciderRecorder) Shutdown() *gomock.Call {
+	return _mr.mock.ctrl.RecordCall(_mr.mock, "Shutdown")
+}
+
+func (_m *MockDecider) State() monitor.ClusterState {
+	ret := _m.ctrl.Call(_m, "State")
+	ret0, _ := ret[0].(monitor.ClusterState)
+	return ret0
+}
+
+func (_mr *_MockDeciderRecorder) State() *gomock.Call {
+	return _mr.mock.ctrl.RecordCall(_mr.mock, "State")
+}
+
+func (_m *MockDecider) Switchover(_param0 time.Duration) error {
+	ret := _m.ctrl.Call(_m, "Switchover", _param0)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+func (_mr *_MockDeciderRecorder) Switchover(arg0 interface{}) *gomock.Call {
+	return _mr.mock.ctrl.RecordCall(_mr.mock, "Switchover", arg0)
+}
+
+func (_m *MockDecider) Upgrade() error {
+	ret := _m.ctrl.Call(_m, "Upgrade")
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+func (_mr *_MockDeciderRecorder) Upgrade() *gomock.Call {
+	return _mr.mock.ctrl.RecordCall(_mr.mock, "Upgrade")
+}
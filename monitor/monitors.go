@@ -0,0 +1,196 @@
+// Copyright (c) 2015 Pagoda Box Inc
+//
+// This Source Code Form is subject to the terms of the Mozilla Public License, v.
+// 2.0. If a copy of the MPL was not distributed with this file, You can obtain one
+// at http://mozilla.org/MPL/2.0/.
+//
+
+// monitors.go arbitrates the cluster through several monitor nodes, so that the
+// cluster keeps its tie breaker when one of them is down. A bounced check goes
+// through whichever monitor answers, starting with the one that answered last.
+
+package monitor
+
+import (
+	"errors"
+	"github.com/nanopack/yoke/config"
+	"github.com/nanopack/yoke/state"
+	"sync/atomic"
+	"time"
+)
+
+// returned for a monitor that saw the node as dead, so the next one is asked
+var deadThrough = errors.New("the node is dead as seen through this monitor")
+
+type (
+	// several monitors arbitrating the cluster together
+	monitorSet struct {
+		monitors []state.State
+		// the monitor that answered last, it is asked first
+		preferred int32
+		log       config.Logger
+	}
+
+	// the view of a node through any of the monitors
+	monitorView struct {
+		set      *monitorSet
+		location string
+	}
+)
+
+func newMonitorSet(monitors []string, timeout time.Duration, log config.Logger) *monitorSet {
+	set := &monitorSet{log: log}
+	for _, location := range monitors {
+		set.monitors = append(set.monitors, state.NewRemoteState("tcp", location, timeout))
+	}
+	return set
+}
+
+// Ready blocks until a majority of the monitors can be consulted. The monitors that
+// are down keep being waited on in the background.
+func (set *monitorSet) Ready() {
+	ready := make(chan struct{}, len(set.monitors))
+	for _, monitor := range set.monitors {
+		go func(monitor state.State) {
+			monitor.Ready()
+			ready <- struct{}{}
+		}(monitor)
+	}
+	for i := 0; i < len(set.monitors)/2+1; i++ {
+		<-ready
+	}
+}
+
+func (set *monitorSet) Bounce(location string) state.State {
+	return monitorView{set: set, location: location}
+}
+
+// relocate points the monitors at new locations, in the same order
+func (set *monitorSet) relocate(locations []string, timeout time.Duration) {
+	for i, monitor := range set.monitors {
+		if relocator, ok := monitor.(state.Relocator); ok && i < len(locations) {
+			relocator.Relocate(locations[i], timeout)
+		}
+	}
+}
+
+// asks each monitor in turn until one of them gets through with call, the error of
+// the last monitor is returned when none does
+func (view monitorView) try(call func(state.State) error) error {
+	first := int(atomic.LoadInt32(&view.set.preferred))
+	var err error
+	for i := range view.set.monitors {
+		next := (first + i) % len(view.set.monitors)
+		if err = call(view.set.monitors[next].Bounce(view.location)); err == nil {
+			atomic.StoreInt32(&view.set.preferred, int32(next))
+			return nil
+		}
+		view.set.log.Debug("monitor '%v' could not be bounced off of (%v)", view.set.monitors[next].Location(), err)
+	}
+	return err
+}
+
+func (view monitorView) Ready() {
+	for view.try(func(bounced state.State) error {
+		_, err := bounced.GetDBRole()
+		return err
+	}) != nil {
+		<-time.After(time.Second)
+	}
+}
+
+func (view monitorView) GetDataDir() (dataDir string, err error) {
+	err = view.try(func(bounced state.State) error {
+		dataDir, err = bounced.GetDataDir()
+		return err
+	})
+	return dataDir, err
+}
+
+func (view monitorView) GetRole() (role string, err error) {
+	err = view.try(func(bounced state.State) error {
+		role, err = bounced.GetRole()
+		return err
+	})
+	return role, err
+}
+
+// a node is only dead when none of the monitors can reach it, it may just be cut
+// off from one of them
+func (view monitorView) GetDBRole() (dbRole string, err error) {
+	dead := false
+	err = view.try(func(bounced state.State) error {
+		if dbRole, err = bounced.GetDBRole(); err == nil && state.DBRole(dbRole) == state.Dead {
+			dead = true
+			return deadThrough
+		}
+		return err
+	})
+	if err != nil && dead {
+		return string(state.Dead), nil
+	}
+	return dbRole, err
+}
+
+func (view monitorView) SetDBRole(string) error {
+	return state.NotSupported
+}
+
+func (view monitorView) HasSynced() (synced bool, err error) {
+	err = view.try(func(bounced state.State) error {
+		synced, err = bounced.HasSynced()
+		return err
+	})
+	return synced, err
+}
+
+func (view monitorView) SetSynced(synced bool) error {
+	return view.try(func(bounced state.State) error {
+		return bounced.SetSynced(synced)
+	})
+}
+
+func (view monitorView) GetPosition() (position uint64, err error) {
+	err = view.try(func(bounced state.State) error {
+		position, err = bounced.GetPosition()
+		return err
+	})
+	return position, err
+}
+
+func (view monitorView) SetPosition(uint64) error {
+	return state.NotSupported
+}
+
+func (view monitorView) Lag() (delay time.Duration, bytes int64, err error) {
+	err = view.try(func(bounced state.State) error {
+		delay, bytes, err = bounced.Lag()
+		return err
+	})
+	return delay, bytes, err
+}
+
+func (view monitorView) SetLag(time.Duration, int64) error {
+	return state.NotSupported
+}
+
+func (view monitorView) Location() string {
+	return view.location
+}
+
+func (view monitorView) Bounce(location string) state.State {
+	return nil
+}
+
+// RelocateMonitors points the monitor arbiter at the monitors, a single monitor or
+// several of them in the same order. Other arbiters are left alone.
+func RelocateMonitors(arbiter Arbiter, monitors []string, timeout time.Duration) {
+	switch arbiter := arbiter.(type) {
+	case *monitorSet:
+		arbiter.relocate(monitors, timeout)
+	case state.Relocator:
+		if len(monitors) == 1 {
+			arbiter.Relocate(monitors[0], timeout)
+		}
+	}
+}
@@ -0,0 +1,55 @@
+// Copyright (c) 2015 Pagoda Box Inc
+//
+// This Source Code Form is subject to the terms of the Mozilla Public License, v.
+// 2.0. If a copy of the MPL was not distributed with this file, You can obtain one
+// at http://mozilla.org/MPL/2.0/.
+//
+
+package monitor
+
+import (
+	"errors"
+	"github.com/golang/mock/gomock"
+	"github.com/nanopack/yoke/config"
+	"github.com/nanopack/yoke/state"
+	"github.com/nanopack/yoke/state/mock"
+	"testing"
+)
+
+func TestMonitorSet(test *testing.T) {
+	ctrl := gomock.NewController(test)
+	defer ctrl.Finish()
+
+	down := mock_state.NewMockState(ctrl)
+	up := mock_state.NewMockState(ctrl)
+	throughDown := mock_state.NewMockState(ctrl)
+	throughUp := mock_state.NewMockState(ctrl)
+	set := &monitorSet{monitors: []state.State{down, up}, log: config.Log}
+
+	// the first monitor is down, so the check goes through the second
+	down.EXPECT().Bounce("10.0.0.2:4400").Return(throughDown)
+	throughDown.EXPECT().GetDBRole().Return("", errors.New("connection refused"))
+	down.EXPECT().Location().Return("10.0.0.4:4400")
+	up.EXPECT().Bounce("10.0.0.2:4400").Return(throughUp)
+	throughUp.EXPECT().GetDBRole().Return("backup", nil)
+
+	view := set.Bounce("10.0.0.2:4400")
+	if role, err := view.GetDBRole(); err != nil || role != "backup" {
+		test.Logf("wrong role through the monitors '%v' (%v)", role, err)
+		test.FailNow()
+	}
+
+	// the monitor that answered is asked first from then on, a node it sees as
+	// dead is only dead once no other monitor can reach it either
+	up.EXPECT().Bounce("10.0.0.2:4400").Return(throughUp)
+	throughUp.EXPECT().GetDBRole().Return("dead", nil)
+	up.EXPECT().Location().Return("10.0.0.5:4400")
+	down.EXPECT().Bounce("10.0.0.2:4400").Return(throughDown)
+	throughDown.EXPECT().GetDBRole().Return("", errors.New("connection refused"))
+	down.EXPECT().Location().Return("10.0.0.4:4400")
+
+	if role, err := view.GetDBRole(); err != nil || role != "dead" {
+		test.Logf("wrong role through the monitors '%v' (%v)", role, err)
+		test.Fail()
+	}
+}
@@ -0,0 +1,333 @@
+// Copyright (c) 2015 Pagoda Box Inc
+//
+// This Source Code Form is subject to the terms of the Mozilla Public License, v.
+// 2.0. If a copy of the MPL was not distributed with this file, You can obtain one
+// at http://mozilla.org/MPL/2.0/.
+//
+
+// mysql.go drives MySQL or MariaDB GTID replication instead of postgres. The
+// database server is started by something else (systemd, a container runtime),
+// yoke only decides which node is writable and where the others replicate from.
+
+package monitor
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"github.com/nanopack/yoke/config"
+	"github.com/nanopack/yoke/state"
+	"github.com/nanopack/yoke/vip"
+	"net"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var NotReplicating = errors.New("the replica is not running")
+
+type (
+	mysqlPerformer struct {
+		*performer
+		mariadb bool
+	}
+)
+
+// how long the server has to come up, and how often a backup checks if it has
+// caught up with the active node
+var (
+	mysqlStartTimeout = 60 * time.Second
+	mysqlSyncInterval = time.Second
+)
+
+// NewMySQLPerformer creates a performer that moves a MySQL or MariaDB server
+// between roles by changing where it replicates from and whether it is writable.
+func NewMySQLPerformer(me state.State, others []state.State, floating vip.VIP, config config.Config) *mysqlPerformer {
+	perform := &mysqlPerformer{performer: NewPerformer(me, others, floating, config)}
+	perform.database = perform
+	return perform
+}
+
+// Initialize waits for the server to accept connections
+func (performer *mysqlPerformer) Initialize() error {
+	deadline := time.Now().Add(mysqlStartTimeout)
+	for {
+		version, err := performer.query("SELECT VERSION()")
+		if err == nil {
+			performer.mariadb = strings.Contains(strings.ToLower(version), "mariadb")
+			performer.log.Info("[mysql] connected to '%v'", version)
+			return nil
+		}
+		if time.Now().After(deadline) {
+			return err
+		}
+		<-time.After(time.Second)
+	}
+}
+
+// Start keeps the server read only until the decider has decided what it is
+func (performer *mysqlPerformer) Start() error {
+	performer.Lock()
+	defer performer.Unlock()
+	return performer.readOnly(true)
+}
+
+// The Single state.
+func (performer *mysqlPerformer) Single() error {
+	performer.log.Info("transitioning to Single")
+	if err := performer.promote(false); err != nil {
+		return err
+	}
+
+	performer.addVip()
+	performer.roleChangeCommand("single")
+	if err := setDBRole(performer.log, performer.me, state.Single); err != nil {
+		return err
+	}
+	transitions.Inc("single")
+	return nil
+}
+
+// The Active state.
+func (performer *mysqlPerformer) Active() error {
+	performer.log.Info("transitioning to Active")
+	if err := performer.promote(performer.config.SyncMode != "off"); err != nil {
+		return err
+	}
+
+	performer.addVip()
+	performer.roleChangeCommand("master")
+	if err := setDBRole(performer.log, performer.me, state.Active); err != nil {
+		return err
+	}
+	transitions.Inc("active")
+	return nil
+}
+
+// The Backup state.
+func (performer *mysqlPerformer) Backup() error {
+	performer.log.Info("transitioning to Backup")
+	performer.removeVip()
+
+	source, err := performer.source()
+	if err != nil {
+		return err
+	}
+	host, _, err := net.SplitHostPort(source.Location())
+	if err != nil {
+		return err
+	}
+
+	if err := performer.readOnly(true); err != nil {
+		return err
+	}
+	position := "MASTER_AUTO_POSITION=1"
+	if performer.mariadb {
+		position = "MASTER_USE_GTID=slave_pos"
+	}
+	performer.log.With(config.Fields{"peer": source.Location()}).Info("[mysql] replicating from '%v'", source.Location())
+	steps := []string{
+		"STOP SLAVE",
+		fmt.Sprintf("CHANGE MASTER TO MASTER_HOST=%v, MASTER_PORT=%v, MASTER_USER=%v, MASTER_PASSWORD=%v, %v",
+			quote(host), performer.config.MySQLPort, quote(performer.config.ReplicationUser), quote(performer.config.ReplicationPassword), position),
+	}
+	for _, step := range steps {
+		if _, err := performer.query(step); err != nil {
+			return err
+		}
+	}
+	performer.setOptional("rpl_semi_sync_slave_enabled", onOff(performer.config.SyncMode != "off"))
+	if _, err := performer.query("START SLAVE"); err != nil {
+		return err
+	}
+
+	performer.roleChangeCommand("backup")
+	transitions.Inc("backup")
+	if err := setDBRole(performer.log, performer.me, state.Backup); err != nil {
+		return err
+	}
+	go performer.waitForSync()
+	return nil
+}
+
+// stop can't shut the server down, so it stops it from taking writes instead
+func (performer *mysqlPerformer) stop() error {
+	if err := performer.readOnly(true); err != nil {
+		return err
+	}
+	_, err := performer.query("STOP SLAVE")
+	return err
+}
+
+// Position returns how many transactions the server has applied, counted over
+// every GTID it has seen
+func (performer *mysqlPerformer) Position() (uint64, error) {
+	query := "SELECT @@GLOBAL.gtid_executed"
+	if performer.mariadb {
+		query = "SELECT @@GLOBAL.gtid_current_pos"
+	}
+	set, err := performer.query(query)
+	if err != nil {
+		return 0, err
+	}
+	return countGTIDs(set, performer.mariadb)
+}
+
+// ReplayDelay returns how far the server is behind the node it replicates from.
+// A server that is not a replica is not behind at all.
+func (performer *mysqlPerformer) ReplayDelay() (time.Duration, error) {
+	status, err := performer.replicaStatus()
+	if err != nil || len(status) == 0 {
+		return 0, err
+	}
+	seconds, err := strconv.Atoi(status["Seconds_Behind_Master"])
+	if err != nil {
+		return 0, NotReplicating
+	}
+	return time.Duration(seconds) * time.Second, nil
+}
+
+// stops replicating and makes the server writable, waiting for a backup to
+// acknowledge every commit when semiSync is set
+func (performer *mysqlPerformer) promote(semiSync bool) error {
+	for _, step := range []string{"STOP SLAVE", "RESET SLAVE ALL"} {
+		if _, err := performer.query(step); err != nil {
+			return err
+		}
+	}
+	performer.setOptional("rpl_semi_sync_master_enabled", onOff(semiSync))
+	if semiSync && performer.config.SyncMode == "strict" {
+		// a year, so commits never fall back to asynchronous replication
+		performer.setOptional("rpl_semi_sync_master_timeout", "31536000000")
+	}
+	return performer.readOnly(false)
+}
+
+// marks the backup as synced once it has caught up with the active node, for as
+// long as it stays a backup
+func (performer *mysqlPerformer) waitForSync() {
+	for {
+		<-time.After(mysqlSyncInterval)
+		if role, err := performer.me.GetDBRole(); err != nil || role != "backup" {
+			return
+		}
+		status, err := performer.replicaStatus()
+		if err != nil {
+			continue
+		}
+		if status["Slave_IO_Running"] == "Yes" && status["Slave_SQL_Running"] == "Yes" && status["Seconds_Behind_Master"] == "0" {
+			performer.log.Info("[mysql] caught up with the active node")
+			performer.me.SetSynced(true)
+			return
+		}
+	}
+}
+
+func (performer *mysqlPerformer) readOnly(enabled bool) error {
+	steps := []string{"SET GLOBAL read_only=" + onOff(enabled)}
+	if !performer.mariadb {
+		// super_read_only also keeps out users with the SUPER privilege, it has to
+		// be turned on after read_only and off before it
+		if enabled {
+			steps = append(steps, "SET GLOBAL super_read_only=ON")
+		} else {
+			steps = append([]string{"SET GLOBAL super_read_only=OFF"}, steps...)
+		}
+	}
+	for _, step := range steps {
+		if _, err := performer.query(step); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// semi-sync is a plugin that not every server has loaded, so its variables are
+// set on a best effort basis
+func (performer *mysqlPerformer) setOptional(variable, value string) {
+	if _, err := performer.query(fmt.Sprintf("SET GLOBAL %v=%v", variable, value)); err != nil {
+		performer.log.Warn("[mysql] could not set %v (%v)", variable, err)
+	}
+}
+
+// the fields of SHOW SLAVE STATUS, empty when the server is not a replica
+func (performer *mysqlPerformer) replicaStatus() (map[string]string, error) {
+	out, err := performer.run("--vertical", "-e", "SHOW SLAVE STATUS")
+	if err != nil {
+		return nil, err
+	}
+	status := map[string]string{}
+	scan := bufio.NewScanner(strings.NewReader(out))
+	for scan.Scan() {
+		pair := strings.SplitN(scan.Text(), ":", 2)
+		if len(pair) == 2 {
+			status[strings.TrimSpace(pair[0])] = strings.TrimSpace(pair[1])
+		}
+	}
+	return status, nil
+}
+
+func (performer *mysqlPerformer) query(query string) (string, error) {
+	return performer.run("--batch", "--skip-column-names", "-e", query)
+}
+
+// runs the mysql client against the local server
+func (performer *mysqlPerformer) run(args ...string) (string, error) {
+	args = append([]string{"-h", "127.0.0.1", "-P", strconv.Itoa(performer.config.MySQLPort), "-u", performer.config.MySQLUser}, args...)
+	cmd := exec.Command("mysql", args...)
+	cmd.Env = append(os.Environ(), "MYSQL_PWD="+performer.config.MySQLPassword)
+	cmd.Stderr = NewPrefix("[mysql.stderr]")
+	out, err := cmd.Output()
+	return strings.TrimSpace(string(out)), err
+}
+
+// counts the transactions in a GTID set, either a MySQL set of uuid:1-5:7-9
+// ranges, or the MariaDB domain-server-sequence of every replication domain
+func countGTIDs(set string, mariadb bool) (uint64, error) {
+	total := uint64(0)
+	for _, gtid := range strings.Split(set, ",") {
+		gtid = strings.TrimSpace(gtid)
+		if gtid == "" {
+			continue
+		}
+		if mariadb {
+			parts := strings.Split(gtid, "-")
+			sequence, err := strconv.ParseUint(parts[len(parts)-1], 10, 64)
+			if err != nil {
+				return 0, err
+			}
+			total += sequence
+			continue
+		}
+		ranges := strings.Split(gtid, ":")
+		for _, interval := range ranges[1:] {
+			bounds := strings.SplitN(interval, "-", 2)
+			start, err := strconv.ParseUint(bounds[0], 10, 64)
+			if err != nil {
+				return 0, err
+			}
+			end := start
+			if len(bounds) == 2 {
+				if end, err = strconv.ParseUint(bounds[1], 10, 64); err != nil {
+					return 0, err
+				}
+			}
+			total += end - start + 1
+		}
+	}
+	return total, nil
+}
+
+func onOff(enabled bool) string {
+	if enabled {
+		return "ON"
+	}
+	return "OFF"
+}
+
+// quotes a string for use in a statement
+func quote(value string) string {
+	return "'" + strings.NewReplacer(`\`, `\\`, `'`, `\'`).Replace(value) + "'"
+}
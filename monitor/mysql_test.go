@@ -0,0 +1,45 @@
+// Copyright (c) 2015 Pagoda Box Inc
+//
+// This Source Code Form is subject to the terms of the Mozilla Public License, v.
+// 2.0. If a copy of the MPL was not distributed with this file, You can obtain one
+// at http://mozilla.org/MPL/2.0/.
+//
+
+package monitor
+
+import (
+	"testing"
+)
+
+func TestCountGTIDs(test *testing.T) {
+	sets := []struct {
+		set     string
+		mariadb bool
+		count   uint64
+	}{
+		{"", false, 0},
+		{"3E11FA47-71CA-11E1-9E33-C80AA9429562:1-5", false, 5},
+		{"3E11FA47-71CA-11E1-9E33-C80AA9429562:1-5:11-18,\n3E11FA47-71CA-11E1-9E33-C80AA9429563:7", false, 14},
+		{"0-1-100", true, 100},
+		{"0-1-100,1-2-5", true, 105},
+	}
+	for _, set := range sets {
+		count, err := countGTIDs(set.set, set.mariadb)
+		if err != nil || count != set.count {
+			test.Logf("'%v' should have counted %v, got %v %v", set.set, set.count, count, err)
+			test.Fail()
+		}
+	}
+
+	if _, err := countGTIDs("uuid:a-b", false); err == nil {
+		test.Log("a broken set should not have been counted")
+		test.Fail()
+	}
+}
+
+func TestQuote(test *testing.T) {
+	if quoted := quote(`it's a \ test`); quoted != `'it\'s a \\ test'` {
+		test.Logf("wrong quoting %v", quoted)
+		test.Fail()
+	}
+}
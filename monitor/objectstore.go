@@ -0,0 +1,202 @@
+// Copyright (c) 2015 Pagoda Box Inc
+//
+// This Source Code Form is subject to the terms of the Mozilla Public License, v.
+// 2.0. If a copy of the MPL was not distributed with this file, You can obtain one
+// at http://mozilla.org/MPL/2.0/.
+//
+
+// objectstore.go arbitrates the cluster through a bucket of an S3 compatible object
+// store, so that a cluster of two nodes needs no third host. Every node keeps
+// rewriting an object with its record, a node whose object was not rewritten in
+// time is seen as 'dead'. The active node holds the leader object, a backup has to
+// take it over with a conditional write before it takes over.
+
+package monitor
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/nanopack/yoke/config"
+	"github.com/nanopack/yoke/objstore"
+	"github.com/nanopack/yoke/state"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+type (
+	objectArbiter struct {
+		client *objstore.Client
+		prefix string
+		ttl    time.Duration
+		log    config.Logger
+	}
+
+	// what is kept in the object of a node or in the leader object
+	lockObject struct {
+		Holder  string      `json:"holder"`
+		Renewed time.Time   `json:"renewed"`
+		TTL     int         `json:"ttl"` // seconds the object outlives the node that stopped renewing it
+		Record  *NodeRecord `json:"record,omitempty"`
+	}
+)
+
+func init() {
+	RegisterArbiter("objectstore", newObjectArbiter)
+}
+
+func newObjectArbiter(conf config.Config) (Arbiter, error) {
+	if conf.ObjectBucket == "" {
+		return nil, fmt.Errorf("the 'objectstore' arbiter needs a bucket")
+	}
+	switch conf.ObjectDialect {
+	case objstore.S3, objstore.GCS:
+	default:
+		return nil, fmt.Errorf("unknown object store dialect '%v'", conf.ObjectDialect)
+	}
+	accessKey, secretKey := conf.ObjectAccessKey, conf.ObjectSecretKey
+	if accessKey == "" {
+		accessKey, secretKey = os.Getenv("AWS_ACCESS_KEY_ID"), os.Getenv("AWS_SECRET_ACCESS_KEY")
+	}
+	client := objstore.New(conf.ObjectEndpoint, conf.ObjectBucket, conf.ObjectRegion, conf.ObjectDialect, accessKey, secretKey, &http.Client{Timeout: conf.CallTimeout()})
+	return &objectArbiter{client: client, prefix: conf.ObjectPrefix, ttl: time.Duration(conf.ObjectTTL) * time.Second, log: conf.Logging()}, nil
+}
+
+// Ready blocks until the store answers
+func (arbiter *objectArbiter) Ready() {
+	for {
+		if _, err := arbiter.client.Get(arbiter.leaderKey()); err == nil || objstore.IsNotFound(err) {
+			return
+		}
+		<-time.After(time.Second)
+	}
+}
+
+func (arbiter *objectArbiter) Bounce(location string) state.State {
+	return recordView{records: arbiter, location: location}
+}
+
+// Report rewrites the object of me until ctx is done. The node holds
+// the leader object while it is running as the active node, and lets go of it as
+// soon as it stops.
+func (arbiter *objectArbiter) Report(ctx context.Context, me state.State) {
+	location := me.Location()
+	for {
+		if err := arbiter.report(me); err != nil {
+			arbiter.log.Warn("[objectstore] could not report the state of this node (%v)", err)
+		} else if role, err := dbRole(me); err == nil {
+			switch role {
+			case state.Active, state.Single:
+				arbiter.Campaign(location)
+			default:
+				arbiter.resign(location)
+			}
+		}
+		if ctx.Err() != nil {
+			return
+		}
+		select {
+		case <-ctx.Done():
+		case <-time.After(arbiter.ttl / 3):
+		}
+	}
+}
+
+// Campaign tries to take the leader object for location, it returns if location
+// holds it afterwards
+func (arbiter *objectArbiter) Campaign(location string) (bool, error) {
+	leader, version, err := arbiter.read(arbiter.leaderKey())
+	if objstore.IsNotFound(err) {
+		version = ""
+	} else if err != nil {
+		return false, err
+	} else if leader.Holder != location && leader.Holder != "" && !arbiter.expired(leader) {
+		return false, nil
+	}
+
+	err = arbiter.write(arbiter.leaderKey(), arbiter.newLock(location), version)
+	if objstore.IsConflict(err) {
+		// the object changed since it was read, someone else got to it first
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// gives up the leader object, if location holds it
+func (arbiter *objectArbiter) resign(location string) error {
+	leader, version, err := arbiter.read(arbiter.leaderKey())
+	if objstore.IsNotFound(err) {
+		return nil
+	}
+	if err != nil || leader.Holder != location {
+		return err
+	}
+	return arbiter.write(arbiter.leaderKey(), arbiter.newLock(""), version)
+}
+
+func (arbiter *objectArbiter) report(me state.State) error {
+	record, err := NewRecord(me)
+	if err != nil {
+		return err
+	}
+	key := arbiter.nodeKey(me.Location())
+	_, version, err := arbiter.read(key)
+	if err != nil && !objstore.IsNotFound(err) {
+		return err
+	}
+	lock := arbiter.newLock(me.Location())
+	lock.Record = &record
+	return arbiter.write(key, lock, version)
+}
+
+// the record of the node at location, a node without an object, or whose object
+// was not renewed in time, is dead
+func (arbiter *objectArbiter) record(location string) (NodeRecord, error) {
+	record := NodeRecord{DBRole: string(state.Dead)}
+	lock, _, err := arbiter.read(arbiter.nodeKey(location))
+	if objstore.IsNotFound(err) {
+		return record, nil
+	}
+	if err != nil || arbiter.expired(lock) || lock.Record == nil {
+		return record, err
+	}
+	return *lock.Record, nil
+}
+
+func (arbiter *objectArbiter) read(key string) (lockObject, string, error) {
+	lock := lockObject{}
+	object, err := arbiter.client.Get(key)
+	if err != nil {
+		return lock, "", err
+	}
+	return lock, object.Version, json.Unmarshal(object.Body, &lock)
+}
+
+func (arbiter *objectArbiter) write(key string, lock lockObject, version string) error {
+	body, err := json.Marshal(lock)
+	if err != nil {
+		return err
+	}
+	return arbiter.client.Put(key, body, version)
+}
+
+func (arbiter *objectArbiter) newLock(holder string) lockObject {
+	return lockObject{Holder: holder, Renewed: time.Now().UTC(), TTL: int(arbiter.ttl / time.Second)}
+}
+
+// an object that was not renewed within its ttl has run out
+func (arbiter *objectArbiter) expired(lock lockObject) bool {
+	return time.Since(lock.Renewed) > time.Duration(lock.TTL)*time.Second
+}
+
+func (arbiter *objectArbiter) leaderKey() string {
+	return arbiter.prefix + "/leader"
+}
+
+// the dots and colons of a location are kept out of the keys, so that they need
+// no escaping
+func (arbiter *objectArbiter) nodeKey(location string) string {
+	return arbiter.prefix + "/node-" + strings.NewReplacer(".", "-", ":", "-").Replace(location)
+}
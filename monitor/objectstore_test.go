@@ -0,0 +1,121 @@
+// Copyright (c) 2015 Pagoda Box Inc
+//
+// This Source Code Form is subject to the terms of the Mozilla Public License, v.
+// 2.0. If a copy of the MPL was not distributed with this file, You can obtain one
+// at http://mozilla.org/MPL/2.0/.
+//
+
+package monitor
+
+import (
+	"encoding/json"
+	"github.com/golang/mock/gomock"
+	"github.com/nanopack/yoke/objstore"
+	"github.com/nanopack/yoke/state/mock"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+)
+
+// just enough of a bucket, with generation preconditions on writes
+type fakeBucket struct {
+	sync.Mutex
+	objects     map[string][]byte
+	generations map[string]int
+}
+
+func (bucket *fakeBucket) ServeHTTP(res http.ResponseWriter, req *http.Request) {
+	bucket.Lock()
+	defer bucket.Unlock()
+	generation := strconv.Itoa(bucket.generations[req.URL.Path])
+	switch req.Method {
+	case "GET":
+		object, ok := bucket.objects[req.URL.Path]
+		if !ok {
+			res.WriteHeader(http.StatusNotFound)
+			return
+		}
+		res.Header().Set("x-goog-generation", generation)
+		res.Write(object)
+	case "PUT":
+		if req.Header.Get("x-goog-if-generation-match") != generation {
+			res.WriteHeader(http.StatusPreconditionFailed)
+			return
+		}
+		bucket.objects[req.URL.Path], _ = ioutil.ReadAll(req.Body)
+		bucket.generations[req.URL.Path]++
+	}
+}
+
+func TestObjectArbiter(test *testing.T) {
+	ctrl := gomock.NewController(test)
+	defer ctrl.Finish()
+
+	bucket := &fakeBucket{objects: map[string][]byte{}, generations: map[string]int{}}
+	server := httptest.NewServer(bucket)
+	defer server.Close()
+	client := objstore.New(server.URL, "witness", "auto", objstore.GCS, "key", "secret", http.DefaultClient)
+	arbiter := &objectArbiter{client: client, prefix: "yoke", ttl: 10 * time.Second}
+
+	// a node that never reported is dead
+	if role, err := arbiter.Bounce("10.0.0.1:4400").GetDBRole(); err != nil || role != "dead" {
+		test.Logf("wrong role %v %v", role, err)
+		test.Fail()
+	}
+
+	me := mock_state.NewMockState(ctrl)
+	me.EXPECT().Location().Return("10.0.0.1:4400").AnyTimes()
+	me.EXPECT().GetRole().Return("primary", nil).Times(2)
+	me.EXPECT().GetDBRole().Return("active", nil).Times(2)
+	me.EXPECT().HasSynced().Return(false, nil).Times(2)
+	me.EXPECT().GetPosition().Return(uint64(42), nil).Times(2)
+	me.EXPECT().GetDataDir().Return("/data", nil).Times(2)
+	me.EXPECT().Lag().Return(time.Duration(0), int64(0), nil).Times(2)
+	// the first report creates the object, the second renews it
+	for i := 0; i < 2; i++ {
+		if err := arbiter.report(me); err != nil {
+			test.Log(err)
+			test.FailNow()
+		}
+	}
+	view := arbiter.Bounce("10.0.0.1:4400")
+	if position, err := view.GetPosition(); err != nil || position != 42 {
+		test.Logf("wrong position %v %v", position, err)
+		test.Fail()
+	}
+
+	// an object that was not renewed in time is dead
+	bucket.Lock()
+	lock := lockObject{}
+	json.Unmarshal(bucket.objects["/witness/yoke/node-10-0-0-1-4400"], &lock)
+	lock.Renewed = time.Now().Add(-time.Minute)
+	bucket.objects["/witness/yoke/node-10-0-0-1-4400"], _ = json.Marshal(lock)
+	bucket.Unlock()
+	if role, err := view.GetDBRole(); err != nil || role != "dead" {
+		test.Logf("wrong role %v %v", role, err)
+		test.Fail()
+	}
+
+	// only one node can hold the leader object
+	if won, err := arbiter.Campaign("10.0.0.1:4400"); err != nil || !won {
+		test.Logf("the first campaign should have been won %v", err)
+		test.Fail()
+	}
+	if won, _ := arbiter.Campaign("10.0.0.2:4400"); won {
+		test.Log("the leader object should already have been held")
+		test.Fail()
+	}
+	if won, err := arbiter.Campaign("10.0.0.1:4400"); err != nil || !won {
+		test.Logf("the holder should have renewed the leader object %v", err)
+		test.Fail()
+	}
+	arbiter.resign("10.0.0.1:4400")
+	if won, err := arbiter.Campaign("10.0.0.2:4400"); err != nil || !won {
+		test.Logf("the leader object should have been free again %v", err)
+		test.Fail()
+	}
+}
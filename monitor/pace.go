@@ -0,0 +1,61 @@
+// Copyright (c) 2015 Pagoda Box Inc
+//
+// This Source Code Form is subject to the terms of the Mozilla Public License, v.
+// 2.0. If a copy of the MPL was not distributed with this file, You can obtain one
+// at http://mozilla.org/MPL/2.0/.
+//
+
+// pace.go decides how long the loop waits before the next recheck. Every wait is
+// moved by a random jitter, so that nodes that were started together don't all
+// check in with the monitor at the same moment. An adaptive pace checks more often
+// while the rechecks fail, and backs off to the check interval once they go through.
+
+package monitor
+
+import (
+	"github.com/nanopack/yoke/config"
+	"math/rand"
+	"time"
+)
+
+type pace struct {
+	jitter   int64         // percent of the wait that it is moved by, either way
+	adaptive bool          // if failing rechecks shorten the wait
+	min      time.Duration // the shortest an adaptive wait gets
+	current  time.Duration // the wait of an adaptive pace, zero before the first one
+}
+
+func newPace(conf config.Config) *pace {
+	return &pace{
+		jitter:   int64(conf.CheckJitter),
+		adaptive: conf.AdaptiveChecks,
+		min:      time.Duration(conf.MinCheckInterval) * time.Millisecond,
+	}
+}
+
+// next returns how long to wait before the next recheck, after a recheck that
+// failed with err. interval is the longest the wait gets, before the jitter.
+func (pace *pace) next(interval time.Duration, err error) time.Duration {
+	wait := interval
+	if pace.adaptive {
+		switch {
+		case pace.current == 0:
+			pace.current = interval
+		case err != nil:
+			pace.current /= 2
+		default:
+			pace.current *= 2
+		}
+		if pace.current < pace.min {
+			pace.current = pace.min
+		}
+		if pace.current > interval {
+			pace.current = interval
+		}
+		wait = pace.current
+	}
+	if spread := int64(wait) * pace.jitter / 100; spread > 0 {
+		wait += time.Duration(rand.Int63n(2*spread+1) - spread)
+	}
+	return wait
+}
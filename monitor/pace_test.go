@@ -0,0 +1,61 @@
+// Copyright (c) 2015 Pagoda Box Inc
+//
+// This Source Code Form is subject to the terms of the Mozilla Public License, v.
+// 2.0. If a copy of the MPL was not distributed with this file, You can obtain one
+// at http://mozilla.org/MPL/2.0/.
+//
+
+package monitor
+
+import (
+	"errors"
+	"github.com/nanopack/yoke/config"
+	"testing"
+	"time"
+)
+
+func TestJitter(test *testing.T) {
+	pace := newPace(config.Config{CheckJitter: 10})
+	for i := 0; i < 100; i++ {
+		if wait := pace.next(time.Second, nil); wait < 900*time.Millisecond || wait > 1100*time.Millisecond {
+			test.Logf("the wait was jittered too far (%v)", wait)
+			test.FailNow()
+		}
+	}
+
+	fixed := newPace(config.Config{})
+	if wait := fixed.next(time.Second, nil); wait != time.Second {
+		test.Logf("a pace without jitter moved the wait (%v)", wait)
+		test.Fail()
+	}
+}
+
+func TestAdaptivePace(test *testing.T) {
+	pace := newPace(config.Config{AdaptiveChecks: true, MinCheckInterval: 250})
+	failed := errors.New("failed")
+	waits := []time.Duration{}
+	for _, err := range []error{nil, failed, failed, failed, nil, nil, nil} {
+		waits = append(waits, pace.next(2*time.Second, err))
+	}
+	expected := []time.Duration{
+		2 * time.Second,
+		time.Second,
+		500 * time.Millisecond,
+		250 * time.Millisecond, // never shorter than the minimum
+		500 * time.Millisecond,
+		time.Second,
+		2 * time.Second,
+	}
+	for i := range expected {
+		if waits[i] != expected[i] {
+			test.Logf("wrong waits %v", waits)
+			test.FailNow()
+		}
+	}
+
+	// a reload that shortens the interval caps the wait right away
+	if wait := pace.next(time.Second, nil); wait != time.Second {
+		test.Logf("the wait outlasted the interval (%v)", wait)
+		test.Fail()
+	}
+}
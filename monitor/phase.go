@@ -0,0 +1,43 @@
+// Copyright (c) 2015 Pagoda Box Inc
+//
+// This Source Code Form is subject to the terms of the Mozilla Public License, v.
+// 2.0. If a copy of the MPL was not distributed with this file, You can obtain one
+// at http://mozilla.org/MPL/2.0/.
+//
+
+// phase.go tells how far along a backup is with following the active node, so a
+// backup that is days behind isn't mistaken for one that is seconds behind.
+
+package monitor
+
+import "time"
+
+// SyncPhase is how far along a backup is with following the active node
+type SyncPhase string
+
+const (
+	Seeding    SyncPhase = "seeding"     // its copy of the data is still being taken, it can't take over
+	CatchingUp SyncPhase = "catching-up" // it replays the WAL, but is further behind than a backup may be
+	Streaming  SyncPhase = "streaming"   // it follows the active node within the lag a backup may have
+	Synced     SyncPhase = "synced"      // it replayed everything the active node wrote
+)
+
+// PhaseOf works out the phase of a backup from whether its copy of the data was
+// taken and how far behind the active node it is. A backup is catching up while it
+// is more than maxLag bytes behind, or one WAL segment when no maxLag is set, or
+// more than maxDelay behind when that is set. maxLag and maxDelay are the
+// max_allowed_lag_bytes and max_allowed_lag_seconds of the cluster.
+func PhaseOf(synced bool, delay time.Duration, bytes, maxLag int64, maxDelay time.Duration) SyncPhase {
+	if maxLag == 0 {
+		maxLag = joinLag
+	}
+	switch {
+	case !synced:
+		return Seeding
+	case bytes > maxLag, maxDelay != 0 && delay > maxDelay:
+		return CatchingUp
+	case bytes > 0:
+		return Streaming
+	}
+	return Synced
+}
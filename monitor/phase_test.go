@@ -0,0 +1,39 @@
+// Copyright (c) 2015 Pagoda Box Inc
+//
+// This Source Code Form is subject to the terms of the Mozilla Public License, v.
+// 2.0. If a copy of the MPL was not distributed with this file, You can obtain one
+// at http://mozilla.org/MPL/2.0/.
+//
+
+package monitor
+
+import (
+	"testing"
+	"time"
+)
+
+func TestPhaseOf(test *testing.T) {
+	for _, check := range []struct {
+		synced   bool
+		delay    time.Duration
+		bytes    int64
+		maxLag   int64
+		maxDelay time.Duration
+		phase    SyncPhase
+	}{
+		{false, 0, 0, 0, 0, Seeding},
+		{true, 0, 0, 0, 0, Synced},
+		{true, time.Second, 1024, 0, 0, Streaming},
+		// a WAL segment is allowed when no lag is set
+		{true, 0, joinLag + 1, 0, 0, CatchingUp},
+		{true, 0, 2048, 1024, 0, CatchingUp},
+		// days behind with only a few bytes left to replay
+		{true, 48 * time.Hour, 10, 0, time.Minute, CatchingUp},
+		{true, 48 * time.Hour, 10, 0, 0, Streaming},
+	} {
+		if phase := PhaseOf(check.synced, check.delay, check.bytes, check.maxLag, check.maxDelay); phase != check.phase {
+			test.Logf("%+v should have been '%v' not '%v'", check, check.phase, phase)
+			test.Fail()
+		}
+	}
+}
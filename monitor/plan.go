@@ -0,0 +1,129 @@
+// Copyright (c) 2015 Pagoda Box Inc
+//
+// This Source Code Form is subject to the terms of the Mozilla Public License, v.
+// 2.0. If a copy of the MPL was not distributed with this file, You can obtain one
+// at http://mozilla.org/MPL/2.0/.
+//
+
+package monitor
+
+import (
+	"github.com/nanopack/yoke/config"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+type (
+	// Plan is a transition the decider would have made, had it not been dry running
+	Plan struct {
+		Transition string    `json:"transition"`
+		Time       time.Time `json:"time"`
+	}
+
+	// planner stands between the decider and the performer. While it is dry running,
+	// or the cluster is in maintenance, the transitions the decider asks for are
+	// only recorded, everything else still goes through to the performer.
+	planner struct {
+		Performer
+		sync.Mutex
+		enabled     bool
+		maintenance bool
+		last        *Plan
+		log         config.Logger
+
+		// the transitions and operator actions in progress, see working
+		inFlight int32
+	}
+)
+
+func (planner *planner) TransitionToActive() {
+	defer planner.working()()
+	if !planner.plan("active") {
+		planner.Performer.TransitionToActive()
+	}
+}
+
+func (planner *planner) TransitionToBackup() {
+	defer planner.working()()
+	if !planner.plan("backup") {
+		planner.Performer.TransitionToBackup()
+	}
+}
+
+func (planner *planner) TransitionToSingle() {
+	defer planner.working()()
+	if !planner.plan("single") {
+		planner.Performer.TransitionToSingle()
+	}
+}
+
+func (planner *planner) Stop() {
+	defer planner.working()()
+	if !planner.plan("stop") {
+		planner.Performer.Stop()
+	}
+}
+
+// working counts a transition or an operator action as in progress until the func
+// it returns is called. A transition can take hours while it syncs the data, the
+// loop counts as coming around while one is in progress.
+func (planner *planner) working() func() {
+	atomic.AddInt32(&planner.inFlight, 1)
+	return func() { atomic.AddInt32(&planner.inFlight, -1) }
+}
+
+// if a transition or an operator action is in progress
+func (planner *planner) busy() bool {
+	return atomic.LoadInt32(&planner.inFlight) > 0
+}
+
+// records the transition when dry running, returning if it was only planned
+func (planner *planner) plan(transition string) bool {
+	planner.Lock()
+	defer planner.Unlock()
+	if !planner.enabled && !planner.maintenance {
+		return false
+	}
+	// the same plan is made on every recheck, it is only worth logging once
+	if planner.last == nil || planner.last.Transition != transition {
+		planner.log.Info("[plan] would transition to '%v'", transition)
+	}
+	planner.last = &Plan{Transition: transition, Time: time.Now()}
+	return true
+}
+
+func (planner *planner) setEnabled(enabled bool) {
+	planner.Lock()
+	defer planner.Unlock()
+	planner.enabled = enabled
+	planner.last = nil
+}
+
+func (planner *planner) setMaintenance(maintenance bool) {
+	planner.Lock()
+	defer planner.Unlock()
+	if planner.maintenance != maintenance {
+		planner.maintenance = maintenance
+		planner.last = nil
+	}
+}
+
+func (planner *planner) inMaintenance() bool {
+	planner.Lock()
+	defer planner.Unlock()
+	return planner.maintenance
+}
+
+// if transitions are only being planned at the moment
+func (planner *planner) planning() bool {
+	planner.Lock()
+	defer planner.Unlock()
+	return planner.enabled || planner.maintenance
+}
+
+func (planner *planner) state() (bool, *Plan) {
+	planner.Lock()
+	defer planner.Unlock()
+	return planner.enabled, planner.last
+}
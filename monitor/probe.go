@@ -0,0 +1,90 @@
+// Copyright (c) 2015 Pagoda Box Inc
+//
+// This Source Code Form is subject to the terms of the Mozilla Public License, v.
+// 2.0. If a copy of the MPL was not distributed with this file, You can obtain one
+// at http://mozilla.org/MPL/2.0/.
+//
+
+// probe.go checks another node directly and through the arbiter at the same time,
+// instead of only bouncing the check once the direct one gave up. A recheck then
+// waits for the slower of the two instead of for both of them, which halves how
+// long it takes when the node is timing out, at the price of asking the arbiter on
+// every recheck.
+
+package monitor
+
+import (
+	"fmt"
+	"github.com/nanopack/yoke/config"
+	"github.com/nanopack/yoke/state"
+	"github.com/nanopack/yoke/trace"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+type (
+	// probe is what the direct and the bounced check of a node each came back with
+	probe struct {
+		direct  sighting
+		bounced sighting
+	}
+
+	// sighting is what a single path to a node came back with
+	sighting struct {
+		view   state.State
+		dbRole state.DBRole
+		err    error
+	}
+)
+
+// probes other directly and through the arbiter at once, span is the check of other
+func (decider *decider) probe(span *trace.Span, other state.State) probe {
+	location := other.Location()
+	p := probe{direct: sighting{view: other}, bounced: sighting{view: decider.arbiter.Bounce(location)}}
+
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		p.direct.dbRole, p.direct.err = traced(span, "direct", p.direct.view)
+	}()
+	go func() {
+		defer wg.Done()
+		p.bounced.dbRole, p.bounced.err = traced(span, "bounce", p.bounced.view)
+	}()
+	wg.Wait()
+
+	if p.bounced.err == nil {
+		atomic.StoreInt64(&decider.lastBounce, time.Now().UnixNano())
+	}
+	if disagreement := p.disagreement(); disagreement != "" {
+		decider.log.With(config.Fields{"peer": location}).Warn("%v", disagreement)
+	}
+	return p
+}
+
+// reconcile decides what the node is seen as. The node itself knows best, the
+// arbiter is only gone by when the node could not be reached directly.
+func (p probe) reconcile() (peer, error) {
+	if p.direct.err == nil {
+		return peer{view: p.direct.view, dbRole: p.direct.dbRole}, nil
+	}
+	if p.bounced.err == nil {
+		return peer{view: p.bounced.view, dbRole: p.bounced.dbRole}, nil
+	}
+	return peer{}, p.bounced.err
+}
+
+// disagreement describes how the two paths to the node disagree, it is empty when
+// they don't. A node that the arbiter sees as dead, while it answers this node, is
+// cut off from the arbiter.
+func (p probe) disagreement() string {
+	if p.direct.err != nil || p.bounced.err != nil || p.direct.dbRole == p.bounced.dbRole {
+		return ""
+	}
+	if p.bounced.dbRole == state.Dead {
+		return fmt.Sprintf("the node answers as '%v', but the arbiter can't reach it", p.direct.dbRole)
+	}
+	return fmt.Sprintf("the node answers as '%v', but the arbiter sees it as '%v'", p.direct.dbRole, p.bounced.dbRole)
+}
@@ -0,0 +1,41 @@
+// Copyright (c) 2015 Pagoda Box Inc
+//
+// This Source Code Form is subject to the terms of the Mozilla Public License, v.
+// 2.0. If a copy of the MPL was not distributed with this file, You can obtain one
+// at http://mozilla.org/MPL/2.0/.
+//
+
+package monitor
+
+import (
+	"errors"
+	"github.com/nanopack/yoke/state"
+	"testing"
+)
+
+func TestReconcile(test *testing.T) {
+	down := errors.New("timed out")
+	cases := []struct {
+		probe    probe
+		dbRole   state.DBRole
+		failed   bool
+		disagree bool
+	}{
+		{probe{direct: sighting{dbRole: state.Active}, bounced: sighting{dbRole: state.Active}}, state.Active, false, false},
+		{probe{direct: sighting{dbRole: state.Active}, bounced: sighting{dbRole: state.Dead}}, state.Active, false, true},
+		{probe{direct: sighting{err: down}, bounced: sighting{dbRole: state.Dead}}, state.Dead, false, false},
+		{probe{direct: sighting{dbRole: state.Backup}, bounced: sighting{err: down}}, state.Backup, false, false},
+		{probe{direct: sighting{err: down}, bounced: sighting{err: down}}, "", true, false},
+	}
+	for i, c := range cases {
+		checked, err := c.probe.reconcile()
+		if checked.dbRole != c.dbRole || (err != nil) != c.failed {
+			test.Logf("case %v: wrong reconciliation '%v' (%v)", i, checked.dbRole, err)
+			test.Fail()
+		}
+		if (c.probe.disagreement() != "") != c.disagree {
+			test.Logf("case %v: wrong disagreement '%v'", i, c.probe.disagreement())
+			test.Fail()
+		}
+	}
+}
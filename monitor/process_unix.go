@@ -0,0 +1,31 @@
+// Copyright (c) 2015 Pagoda Box Inc
+//
+// This Source Code Form is subject to the terms of the Mozilla Public License, v.
+// 2.0. If a copy of the MPL was not distributed with this file, You can obtain one
+// at http://mozilla.org/MPL/2.0/.
+//
+
+//go:build !windows
+
+package monitor
+
+import (
+	"github.com/nanopack/yoke/config"
+	"os/exec"
+	"syscall"
+)
+
+// interrupt has the postgres started by cmd shut down, which it does on SIGINT
+// after it rolled back the running transactions
+func interrupt(cmd *exec.Cmd, conf config.Config) error {
+	return cmd.Process.Signal(syscall.SIGINT)
+}
+
+// the space left on the disk of dir for the user postgres runs as
+func freeDisk(dir string) (uint64, error) {
+	var stat syscall.Statfs_t
+	if err := syscall.Statfs(dir, &stat); err != nil {
+		return 0, err
+	}
+	return stat.Bavail * uint64(stat.Bsize), nil
+}
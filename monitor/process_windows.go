@@ -0,0 +1,42 @@
+// Copyright (c) 2015 Pagoda Box Inc
+//
+// This Source Code Form is subject to the terms of the Mozilla Public License, v.
+// 2.0. If a copy of the MPL was not distributed with this file, You can obtain one
+// at http://mozilla.org/MPL/2.0/.
+//
+
+//go:build windows
+
+package monitor
+
+import (
+	"github.com/nanopack/yoke/config"
+	"os/exec"
+	"syscall"
+	"unsafe"
+)
+
+var getDiskFreeSpace = syscall.NewLazyDLL("kernel32.dll").NewProc("GetDiskFreeSpaceExW")
+
+// interrupt has the postgres started by cmd shut down. windows has no SIGINT to
+// send it, pg_ctl asks it for the same fast shutdown through its signal pipe.
+func interrupt(cmd *exec.Cmd, conf config.Config) error {
+	stop := exec.Command("pg_ctl", "stop", "-D", conf.DataDir, "-m", "fast", "-W")
+	stop.Stdout = NewPrefix("[pg_ctl.stdout]")
+	stop.Stderr = NewPrefix("[pg_ctl.stderr]")
+	return stop.Run()
+}
+
+// the space left on the disk of dir for the user postgres runs as
+func freeDisk(dir string) (uint64, error) {
+	path, err := syscall.UTF16PtrFromString(dir)
+	if err != nil {
+		return 0, err
+	}
+	var available, total, free uint64
+	ok, _, err := getDiskFreeSpace.Call(uintptr(unsafe.Pointer(path)), uintptr(unsafe.Pointer(&available)), uintptr(unsafe.Pointer(&total)), uintptr(unsafe.Pointer(&free)))
+	if ok == 0 {
+		return 0, err
+	}
+	return available, nil
+}
@@ -0,0 +1,167 @@
+// Copyright (c) 2015 Pagoda Box Inc
+//
+// This Source Code Form is subject to the terms of the Mozilla Public License, v.
+// 2.0. If a copy of the MPL was not distributed with this file, You can obtain one
+// at http://mozilla.org/MPL/2.0/.
+//
+
+// progress.go follows how far along the copy of the data is, from the progress
+// that pg_basebackup --progress and rsync --info=progress2 print while they run.
+
+package monitor
+
+import (
+	"io"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+type (
+	// SyncProgress is how far along the copy of the data is that the node takes from
+	// another node, or sends over to a backup
+	SyncProgress struct {
+		Peer       string    `json:"peer"`                  // the node the data is copied from or to
+		Bytes      int64     `json:"bytes"`                 // copied so far
+		Total      int64     `json:"total,omitempty"`       // 0 until the copy reported it
+		Rate       float64   `json:"bytes_per_second"`      // since the copy started
+		ETASeconds float64   `json:"eta_seconds,omitempty"` // 0 until the total is known
+		Attempt    int       `json:"attempt"`               // the first copy is attempt 1
+		Started    time.Time `json:"started"`
+	}
+
+	// SyncReporter is a performer that reports the copy of the data it runs, the
+	// postgres performer does. It does not wait on a transition that is running.
+	SyncReporter interface {
+		Syncing() *SyncProgress
+	}
+
+	// progress keeps the copy that is running, if there is one, and how far each of
+	// its streams got
+	progress struct {
+		sync.Mutex
+		current *SyncProgress
+		streams map[int][2]int64
+	}
+
+	// progressWriter reads the progress out of what a stream of a copy prints,
+	// every other line is passed on
+	progressWriter struct {
+		progress *progress
+		stream   int
+		out      io.Writer
+		partial  []byte
+	}
+)
+
+var (
+	// '  123456/654321 kB (18%), 0/1 tablespace' of pg_basebackup
+	basebackupProgress = regexp.MustCompile(`^\s*(\d+)/(\d+) kB \(\d+%\)`)
+	// '  1,234,567  45%   12.34MB/s    0:01:23' of rsync --info=progress2
+	rsyncProgress = regexp.MustCompile(`^\s*([\d,]+)\s+(\d+)%\s`)
+)
+
+// start starts following a new attempt to copy the data from or to peer
+func (progress *progress) start(peer string, attempt int) {
+	progress.Lock()
+	defer progress.Unlock()
+	progress.current = &SyncProgress{Peer: peer, Attempt: attempt, Started: now()}
+	progress.streams = map[int][2]int64{}
+}
+
+// update records how much a stream copied, and how much it has to copy if it is
+// known. The copy is as far along as all of its streams together.
+func (progress *progress) update(stream int, bytes, total int64) {
+	progress.Lock()
+	defer progress.Unlock()
+	current := progress.current
+	if current == nil {
+		return
+	}
+	progress.streams[stream] = [2]int64{bytes, total}
+	current.Bytes, current.Total = 0, 0
+	for _, copied := range progress.streams {
+		current.Bytes += copied[0]
+		current.Total += copied[1]
+	}
+	bytes, total = current.Bytes, current.Total
+	if elapsed := now().Sub(current.Started).Seconds(); elapsed > 0 {
+		current.Rate = float64(bytes) / elapsed
+	}
+	current.ETASeconds = 0
+	if total > bytes && current.Rate > 0 {
+		current.ETASeconds = float64(total-bytes) / current.Rate
+	}
+}
+
+// done stops following the copy
+func (progress *progress) done() {
+	progress.Lock()
+	defer progress.Unlock()
+	progress.current = nil
+}
+
+func (progress *progress) get() *SyncProgress {
+	progress.Lock()
+	defer progress.Unlock()
+	if progress.current == nil {
+		return nil
+	}
+	current := *progress.current
+	return &current
+}
+
+// watch returns a writer for the output of a copy that updates the progress, and
+// passes everything else on to out
+func (progress *progress) watch(out io.Writer) io.Writer {
+	return progress.watchStream(0, out)
+}
+
+// watchStream is watch for one of several streams the copy is split into
+func (progress *progress) watchStream(stream int, out io.Writer) io.Writer {
+	return &progressWriter{progress: progress, stream: stream, out: out}
+}
+
+// both print their progress over and over on the same line with a '\r'
+func (writer *progressWriter) Write(data []byte) (int, error) {
+	writer.partial = append(writer.partial, data...)
+	for {
+		end := strings.IndexAny(string(writer.partial), "\r\n")
+		if end == -1 {
+			return len(data), nil
+		}
+		line := string(writer.partial[:end])
+		writer.partial = writer.partial[end+1:]
+		if !writer.parse(line) && strings.TrimSpace(line) != "" {
+			writer.out.Write([]byte(line + "\n"))
+		}
+	}
+}
+
+func (writer *progressWriter) parse(line string) bool {
+	if match := basebackupProgress.FindStringSubmatch(line); match != nil {
+		done, _ := strconv.ParseInt(match[1], 10, 64)
+		total, _ := strconv.ParseInt(match[2], 10, 64)
+		writer.progress.update(writer.stream, done*1024, total*1024)
+		return true
+	}
+	if match := rsyncProgress.FindStringSubmatch(line); match != nil {
+		done, _ := strconv.ParseInt(strings.Replace(match[1], ",", "", -1), 10, 64)
+		percent, _ := strconv.ParseInt(match[2], 10, 64)
+		total := int64(0)
+		if percent > 0 {
+			total = done * 100 / percent
+		}
+		writer.progress.update(writer.stream, done, total)
+		return true
+	}
+	return false
+}
+
+// Syncing is the copy of the data the performer is taking or sending, nil while
+// there is none
+func (performer *performer) Syncing() *SyncProgress {
+	return performer.progress.get()
+}
@@ -0,0 +1,48 @@
+// Copyright (c) 2015 Pagoda Box Inc
+//
+// This Source Code Form is subject to the terms of the Mozilla Public License, v.
+// 2.0. If a copy of the MPL was not distributed with this file, You can obtain one
+// at http://mozilla.org/MPL/2.0/.
+//
+
+package monitor
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestSyncProgress(test *testing.T) {
+	tracker := &progress{}
+	out := &bytes.Buffer{}
+	writer := tracker.watch(out)
+	if tracker.get() != nil {
+		test.Log("there should be no progress before a copy started")
+		test.Fail()
+	}
+
+	tracker.start("10.0.0.1:4400", 2)
+	writer.Write([]byte("waiting for checkpoint\n     0/1000 kB (0%), 0/1 tablespace\r   250/1000 kB (25%), 0/1 tablespace\r"))
+	current := tracker.get()
+	if current == nil || current.Peer != "10.0.0.1:4400" || current.Attempt != 2 || current.Bytes != 250*1024 || current.Total != 1000*1024 {
+		test.Logf("the progress of pg_basebackup was not read %+v", current)
+		test.FailNow()
+	}
+	if out.String() != "waiting for checkpoint\n" {
+		test.Logf("only the lines that aren't progress should be passed on '%v'", out.String())
+		test.Fail()
+	}
+
+	// rsync only reports the percentage, the total is worked out from it
+	writer.Write([]byte("  5,000,000  50%   12.34MB/s    0:00:01 (xfr#1, to-chk=3/10)\r"))
+	if current = tracker.get(); current.Bytes != 5000000 || current.Total != 10000000 {
+		test.Logf("the progress of rsync was not read %+v", current)
+		test.Fail()
+	}
+
+	tracker.done()
+	if tracker.get() != nil {
+		test.Log("the progress should be gone once the copy is done")
+		test.Fail()
+	}
+}
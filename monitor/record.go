@@ -0,0 +1,202 @@
+// Copyright (c) 2015 Pagoda Box Inc
+//
+// This Source Code Form is subject to the terms of the Mozilla Public License, v.
+// 2.0. If a copy of the MPL was not distributed with this file, You can obtain one
+// at http://mozilla.org/MPL/2.0/.
+//
+
+// record.go is shared by the arbiters that keep a record of every node in an
+// outside store (etcd, kubernetes) instead of asking the node itself. An arbiter
+// that is built outside of yoke (see the plugin package) only has to keep the
+// records, as a RecordStore.
+
+package monitor
+
+import (
+	"context"
+	"github.com/nanopack/yoke/config"
+	"github.com/nanopack/yoke/state"
+	"time"
+)
+
+type (
+	// NodeRecord is what a node records about itself
+	NodeRecord struct {
+		Role     string
+		DBRole   string
+		Synced   bool
+		Position uint64
+		DataDir  string
+		Lag      state.LagReport
+	}
+
+	// a store of records, a node without one is returned as 'dead'
+	recorder interface {
+		record(location string) (NodeRecord, error)
+	}
+
+	// the view of a node through its record
+	recordView struct {
+		records  recorder
+		location string
+	}
+
+	// RecordStore keeps the record of every node outside of the cluster. Every node
+	// keeps its own record up to date, and holds the leader key of the store while
+	// it runs as the active node.
+	RecordStore interface {
+		// returns nil once the store can be used
+		Ready() error
+		// returns the record of the node at location, an empty one when it has none
+		Record(location string) (NodeRecord, error)
+		Report(location string, record NodeRecord) error
+		// takes the leader key for location, and returns if location holds it
+		Campaign(location string) (bool, error)
+		// gives up the leader key, if location holds it
+		Resign(location string) error
+	}
+
+	// an arbiter that is backed by a record store, it works the way the etcd
+	// arbiter does
+	storeArbiter struct {
+		store    RecordStore
+		interval time.Duration
+		log      config.Logger
+	}
+)
+
+// NewRecord collects the record of me
+func NewRecord(me state.State) (NodeRecord, error) {
+	record := NodeRecord{}
+	var err error
+	if record.Role, err = me.GetRole(); err != nil {
+		return record, err
+	}
+	if record.DBRole, err = me.GetDBRole(); err != nil {
+		return record, err
+	}
+	if record.Synced, err = me.HasSynced(); err != nil {
+		return record, err
+	}
+	if record.Position, err = me.GetPosition(); err != nil {
+		return record, err
+	}
+	if record.DataDir, err = me.GetDataDir(); err != nil {
+		return record, err
+	}
+	record.Lag.Time, record.Lag.Bytes, err = me.Lag()
+	return record, err
+}
+
+func (view recordView) Ready() {}
+
+func (view recordView) GetDataDir() (string, error) {
+	record, err := view.records.record(view.location)
+	return record.DataDir, err
+}
+
+func (view recordView) GetRole() (string, error) {
+	record, err := view.records.record(view.location)
+	return record.Role, err
+}
+
+func (view recordView) GetDBRole() (string, error) {
+	record, err := view.records.record(view.location)
+	return record.DBRole, err
+}
+
+func (view recordView) SetDBRole(string) error {
+	return state.NotSupported
+}
+
+func (view recordView) HasSynced() (bool, error) {
+	record, err := view.records.record(view.location)
+	return record.Synced, err
+}
+
+func (view recordView) SetSynced(bool) error {
+	return state.NotSupported
+}
+
+func (view recordView) GetPosition() (uint64, error) {
+	record, err := view.records.record(view.location)
+	return record.Position, err
+}
+
+func (view recordView) SetPosition(uint64) error {
+	return state.NotSupported
+}
+
+func (view recordView) Lag() (time.Duration, int64, error) {
+	record, err := view.records.record(view.location)
+	return record.Lag.Time, record.Lag.Bytes, err
+}
+
+func (view recordView) SetLag(time.Duration, int64) error {
+	return state.NotSupported
+}
+
+func (view recordView) Location() string {
+	return view.location
+}
+
+func (view recordView) Bounce(string) state.State {
+	return nil
+}
+
+// NewStoreArbiter creates an arbiter that is backed by store, the node reports its
+// record to it every interval
+func NewStoreArbiter(store RecordStore, interval time.Duration, log config.Logger) Arbiter {
+	return &storeArbiter{store: store, interval: interval, log: log}
+}
+
+// Ready blocks until the store can be used
+func (arbiter *storeArbiter) Ready() {
+	for arbiter.store.Ready() != nil {
+		<-time.After(time.Second)
+	}
+}
+
+func (arbiter *storeArbiter) Bounce(location string) state.State {
+	return recordView{records: arbiter, location: location}
+}
+
+// Report keeps the record of me up to date until ctx is done, and
+// holds the leader key while the node runs as the active node
+func (arbiter *storeArbiter) Report(ctx context.Context, me state.State) {
+	location := me.Location()
+	for {
+		if record, err := NewRecord(me); err != nil {
+			arbiter.log.Warn("[arbiter] could not collect the state of this node (%v)", err)
+		} else if err := arbiter.store.Report(location, record); err != nil {
+			arbiter.log.Warn("[arbiter] could not report the state of this node (%v)", err)
+		} else {
+			switch state.DBRole(record.DBRole) {
+			case state.Active, state.Single:
+				arbiter.Campaign(location)
+			default:
+				arbiter.store.Resign(location)
+			}
+		}
+		if ctx.Err() != nil {
+			return
+		}
+		select {
+		case <-ctx.Done():
+		case <-time.After(arbiter.interval):
+		}
+	}
+}
+
+func (arbiter *storeArbiter) Campaign(location string) (bool, error) {
+	return arbiter.store.Campaign(location)
+}
+
+// the record of the node at location, a node without one is dead
+func (arbiter *storeArbiter) record(location string) (NodeRecord, error) {
+	record, err := arbiter.store.Record(location)
+	if err == nil && record.DBRole == "" {
+		record.DBRole = string(state.Dead)
+	}
+	return record, err
+}
@@ -0,0 +1,282 @@
+// Copyright (c) 2015 Pagoda Box Inc
+//
+// This Source Code Form is subject to the terms of the Mozilla Public License, v.
+// 2.0. If a copy of the MPL was not distributed with this file, You can obtain one
+// at http://mozilla.org/MPL/2.0/.
+//
+
+// redis.go drives Redis replication instead of postgres, so the decider and the
+// monitor can stand in for sentinel. Like with mysql the server is started by
+// something else, yoke only moves it between roles.
+
+package monitor
+
+import (
+	"bufio"
+	"fmt"
+	"github.com/nanopack/yoke/config"
+	"github.com/nanopack/yoke/state"
+	"github.com/nanopack/yoke/vip"
+	"net"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type (
+	redisPerformer struct {
+		*performer
+	}
+)
+
+// writes are refused while fewer replicas than this are connected, which is how a
+// stopped master keeps clients from writing to it
+const refuseWrites = "1000000"
+
+// how long the server has to come up, and how often a backup checks if it has
+// finished syncing with the active node
+var (
+	redisStartTimeout = 60 * time.Second
+	redisSyncInterval = time.Second
+)
+
+// NewRedisPerformer creates a performer that moves a Redis server between roles
+// with REPLICAOF
+func NewRedisPerformer(me state.State, others []state.State, floating vip.VIP, config config.Config) *redisPerformer {
+	perform := &redisPerformer{performer: NewPerformer(me, others, floating, config)}
+	perform.database = perform
+	return perform
+}
+
+// Initialize waits for the server to answer
+func (performer *redisPerformer) Initialize() error {
+	deadline := time.Now().Add(redisStartTimeout)
+	for {
+		reply, err := performer.command("PING")
+		if err == nil && reply == "PONG" {
+			return nil
+		}
+		if err == nil {
+			err = fmt.Errorf("unexpected reply to PING '%v'", reply)
+		}
+		if time.Now().After(deadline) {
+			return err
+		}
+		<-time.After(time.Second)
+	}
+}
+
+// Start refuses writes until the decider has decided what the server is
+func (performer *redisPerformer) Start() error {
+	performer.Lock()
+	defer performer.Unlock()
+	return performer.execute("CONFIG", "SET", "min-replicas-to-write", refuseWrites)
+}
+
+// The Single state.
+func (performer *redisPerformer) Single() error {
+	performer.log.Info("transitioning to Single")
+	if err := performer.promote(false); err != nil {
+		return err
+	}
+
+	performer.addVip()
+	performer.roleChangeCommand("single")
+	if err := setDBRole(performer.log, performer.me, state.Single); err != nil {
+		return err
+	}
+	transitions.Inc("single")
+	return nil
+}
+
+// The Active state.
+func (performer *redisPerformer) Active() error {
+	performer.log.Info("transitioning to Active")
+	if err := performer.promote(performer.config.SyncMode == "strict"); err != nil {
+		return err
+	}
+
+	performer.addVip()
+	performer.roleChangeCommand("master")
+	if err := setDBRole(performer.log, performer.me, state.Active); err != nil {
+		return err
+	}
+	transitions.Inc("active")
+	return nil
+}
+
+// The Backup state.
+func (performer *redisPerformer) Backup() error {
+	performer.log.Info("transitioning to Backup")
+	performer.removeVip()
+
+	source, err := performer.source()
+	if err != nil {
+		return err
+	}
+	host, _, err := net.SplitHostPort(source.Location())
+	if err != nil {
+		return err
+	}
+
+	performer.log.With(config.Fields{"peer": source.Location()}).Info("[redis] replicating from '%v'", source.Location())
+	if performer.config.RedisPassword != "" {
+		if err := performer.execute("CONFIG", "SET", "masterauth", performer.config.RedisPassword); err != nil {
+			return err
+		}
+	}
+	if err := performer.execute("REPLICAOF", host, strconv.Itoa(performer.config.RedisPort)); err != nil {
+		return err
+	}
+	// a replica never takes writes, so it does not need to refuse them
+	if err := performer.execute("CONFIG", "SET", "min-replicas-to-write", "0"); err != nil {
+		return err
+	}
+
+	performer.roleChangeCommand("backup")
+	transitions.Inc("backup")
+	if err := setDBRole(performer.log, performer.me, state.Backup); err != nil {
+		return err
+	}
+	go performer.waitForSync()
+	return nil
+}
+
+// stop keeps the server running but refuses every write, and stops it from
+// replicating
+func (performer *redisPerformer) stop() error {
+	if err := performer.execute("REPLICAOF", "NO", "ONE"); err != nil {
+		return err
+	}
+	return performer.execute("CONFIG", "SET", "min-replicas-to-write", refuseWrites)
+}
+
+// Position returns the replication offset of the server, masters report what
+// they have written and replicas what they have received
+func (performer *redisPerformer) Position() (uint64, error) {
+	info, err := performer.replication()
+	if err != nil {
+		return 0, err
+	}
+	return replicationOffset(info)
+}
+
+// ReplayDelay is not something redis reports, a replica is either connected to
+// its master or it is not replicating at all
+func (performer *redisPerformer) ReplayDelay() (time.Duration, error) {
+	info, err := performer.replication()
+	if err != nil {
+		return 0, err
+	}
+	if info["role"] == "slave" && info["master_link_status"] != "up" {
+		return 0, NotReplicating
+	}
+	return 0, nil
+}
+
+// stops replicating and takes writes, only while a replica is connected when
+// waitForReplica is set
+func (performer *redisPerformer) promote(waitForReplica bool) error {
+	if err := performer.execute("REPLICAOF", "NO", "ONE"); err != nil {
+		return err
+	}
+	replicas := "0"
+	if waitForReplica {
+		replicas = "1"
+	}
+	return performer.execute("CONFIG", "SET", "min-replicas-to-write", replicas)
+}
+
+// marks the backup as synced once the initial sync with the active node has
+// finished, for as long as it stays a backup
+func (performer *redisPerformer) waitForSync() {
+	for {
+		<-time.After(redisSyncInterval)
+		if role, err := performer.me.GetDBRole(); err != nil || role != "backup" {
+			return
+		}
+		info, err := performer.replication()
+		if err != nil {
+			continue
+		}
+		if info["master_link_status"] == "up" && info["master_sync_in_progress"] == "0" {
+			performer.log.Info("[redis] finished syncing with the active node")
+			performer.me.SetSynced(true)
+			return
+		}
+	}
+}
+
+// the fields of INFO replication
+func (performer *redisPerformer) replication() (map[string]string, error) {
+	out, err := performer.command("INFO", "replication")
+	if err != nil {
+		return nil, err
+	}
+	return parseReplication(out)
+}
+
+// the fields of the reply to INFO replication, a reply without any is the error
+// the server answered with
+func parseReplication(out string) (map[string]string, error) {
+	info := map[string]string{}
+	scan := bufio.NewScanner(strings.NewReader(out))
+	for scan.Scan() {
+		pair := strings.SplitN(strings.TrimSpace(scan.Text()), ":", 2)
+		if len(pair) == 2 {
+			info[pair[0]] = pair[1]
+		}
+	}
+	if len(info) == 0 {
+		return nil, fmt.Errorf("redis: %v", out)
+	}
+	return info, nil
+}
+
+// the replication offset from the fields of INFO replication
+func replicationOffset(info map[string]string) (uint64, error) {
+	offset, ok := info["master_repl_offset"]
+	if !ok {
+		return 0, fmt.Errorf("redis: INFO replication has no master_repl_offset")
+	}
+	return strconv.ParseUint(offset, 10, 64)
+}
+
+// runs a command the server acknowledges with OK
+func (performer *redisPerformer) execute(args ...string) error {
+	reply, err := performer.command(args...)
+	if err != nil {
+		return err
+	}
+	return acknowledged(reply)
+}
+
+// redis-cli exits cleanly when the server answers with an error, and those start
+// with whatever code the server picked (ERR, NOAUTH, WRONGPASS...), so anything
+// that isn't OK is one
+func acknowledged(reply string) error {
+	if reply == "OK" || strings.HasPrefix(reply, "OK ") {
+		return nil
+	}
+	return fmt.Errorf("redis: %v", reply)
+}
+
+// runs a command against the local server with redis-cli
+func (performer *redisPerformer) command(args ...string) (string, error) {
+	args = append([]string{"-h", "127.0.0.1", "-p", strconv.Itoa(performer.config.RedisPort)}, args...)
+	cmd := exec.Command("redis-cli", args...)
+	cmd.Env = append(os.Environ(), "REDISCLI_AUTH="+performer.config.RedisPassword)
+	cmd.Stderr = NewPrefix("[redis-cli.stderr]")
+	out, err := cmd.Output()
+	if err != nil {
+		return "", err
+	}
+	reply := strings.TrimSpace(string(out))
+	// redis-cli exits cleanly when the server answers with an error
+	if strings.HasPrefix(reply, "ERR") || strings.HasPrefix(reply, "(error)") {
+		return "", fmt.Errorf("redis: %v", reply)
+	}
+	return reply, nil
+}
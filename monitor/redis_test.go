@@ -0,0 +1,130 @@
+// Copyright (c) 2015 Pagoda Box Inc
+//
+// This Source Code Form is subject to the terms of the Mozilla Public License, v.
+// 2.0. If a copy of the MPL was not distributed with this file, You can obtain one
+// at http://mozilla.org/MPL/2.0/.
+//
+
+package monitor
+
+import (
+	"github.com/nanopack/yoke/config"
+	"github.com/nanopack/yoke/vip"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+const replicationInfo = "# Replication\r\n" +
+	"role:slave\r\n" +
+	"master_host:10.0.0.1\r\n" +
+	"master_port:6379\r\n" +
+	"master_link_status:up\r\n" +
+	"master_sync_in_progress:0\r\n" +
+	"slave_repl_offset:1402\r\n" +
+	"master_replid:8c6e8a7b4d1f0e2a3b5c6d7e8f9a0b1c2d3e4f5a\r\n" +
+	"master_repl_offset:1402\r\n"
+
+func TestParseReplication(test *testing.T) {
+	info, err := parseReplication(replicationInfo)
+	if err != nil {
+		test.Log(err)
+		test.FailNow()
+	}
+	if info["role"] != "slave" || info["master_link_status"] != "up" || info["master_sync_in_progress"] != "0" {
+		test.Logf("wrong fields %v", info)
+		test.Fail()
+	}
+	if offset, err := replicationOffset(info); err != nil || offset != 1402 {
+		test.Logf("wrong offset %v %v", offset, err)
+		test.Fail()
+	}
+
+	if _, err := replicationOffset(map[string]string{"role": "master"}); err == nil {
+		test.Log("a missing offset should not have been read as 0")
+		test.Fail()
+	}
+	if _, err := replicationOffset(map[string]string{"master_repl_offset": "-1"}); err == nil {
+		test.Log("a broken offset should not have been parsed")
+		test.Fail()
+	}
+
+	for _, reply := range []string{"NOAUTH Authentication required.", "WRONGPASS invalid username-password pair or user is disabled.", ""} {
+		if _, err := parseReplication(reply); err == nil {
+			test.Logf("'%v' should have been an error", reply)
+			test.Fail()
+		}
+	}
+}
+
+func TestAcknowledged(test *testing.T) {
+	for _, reply := range []string{"OK", "OK Already connected to specified master"} {
+		if err := acknowledged(reply); err != nil {
+			test.Logf("'%v' should have been accepted: %v", reply, err)
+			test.Fail()
+		}
+	}
+	for _, reply := range []string{
+		"ERR Unknown option or number of arguments for CONFIG SET",
+		"(error) ERR wrong number of arguments",
+		"NOAUTH Authentication required.",
+		"WRONGPASS invalid username-password pair or user is disabled.",
+		"READONLY You can't write against a read only replica.",
+		"",
+	} {
+		if err := acknowledged(reply); err == nil {
+			test.Logf("'%v' should have been an error", reply)
+			test.Fail()
+		}
+	}
+}
+
+// puts a redis-cli on the path that answers with reply and exits with status
+func fakeRedisCLI(test *testing.T, reply string, status string) func() {
+	dir, err := ioutil.TempDir("", "redis")
+	if err != nil {
+		test.Log(err)
+		test.FailNow()
+	}
+	script := "#!/bin/sh\nprintf '%s\\n' '" + reply + "'\nexit " + status + "\n"
+	if err := ioutil.WriteFile(filepath.Join(dir, "redis-cli"), []byte(script), 0755); err != nil {
+		test.Log(err)
+		test.FailNow()
+	}
+	path := os.Getenv("PATH")
+	os.Setenv("PATH", dir+string(os.PathListSeparator)+path)
+	return func() {
+		os.Setenv("PATH", path)
+		os.RemoveAll(dir)
+	}
+}
+
+func TestRedisCommandErrors(test *testing.T) {
+	performer := NewRedisPerformer(nil, nil, vip.None, config.Config{RedisPort: 6379})
+
+	restore := fakeRedisCLI(test, "NOAUTH Authentication required.", "0")
+	if err := performer.stop(); err == nil {
+		test.Log("a refused REPLICAOF should have failed the stop")
+		test.Fail()
+	}
+	if _, err := performer.Position(); err == nil {
+		test.Log("a refused INFO should not have had a position")
+		test.Fail()
+	}
+	restore()
+
+	restore = fakeRedisCLI(test, "Could not connect to Redis at 127.0.0.1:6379: Connection refused", "1")
+	if _, err := performer.command("PING"); err == nil {
+		test.Log("redis-cli failing should have been an error")
+		test.Fail()
+	}
+	restore()
+
+	restore = fakeRedisCLI(test, "OK", "0")
+	if err := performer.stop(); err != nil {
+		test.Log(err)
+		test.Fail()
+	}
+	restore()
+}
@@ -0,0 +1,110 @@
+// Copyright (c) 2015 Pagoda Box Inc
+//
+// This Source Code Form is subject to the terms of the Mozilla Public License, v.
+// 2.0. If a copy of the MPL was not distributed with this file, You can obtain one
+// at http://mozilla.org/MPL/2.0/.
+//
+
+// replace.go admits a node that replaced a dead one. Until the new node has a copy
+// of the data and has caught up with this node, the decider treats it as dead, so
+// an active node keeps running as single instead of waiting on it to confirm
+// commits, and a backup never hands over to it.
+
+package monitor
+
+import (
+	"time"
+
+	"github.com/nanopack/yoke/config"
+	"github.com/nanopack/yoke/events"
+	"github.com/nanopack/yoke/state"
+)
+
+// how far behind a joining node may be when no max_allowed_lag_bytes is set, one
+// WAL segment
+const joinLag = 16 * 1024 * 1024
+
+// Join has the decider treat the node at location as a replacement of a dead node,
+// it is only decided on once it has caught up
+func (decider *decider) Join(location string) error {
+	decider.Lock()
+	defer decider.Unlock()
+
+	for i, other := range decider.others {
+		if other.Location() != location {
+			continue
+		}
+		// nothing that was seen of the dead node applies to its replacement
+		watch := decider.watching[i]
+		watch.Lock()
+		watch.joining = true
+		watch.seen = false
+		watch.peer = peer{}
+		watch.Unlock()
+		decider.log.With(config.Fields{"peer": location}).Info("'%v' is joining, it is treated as dead until it has caught up", location)
+		return nil
+	}
+	return UnknownPeer
+}
+
+// decides what a joining node is seen as. A node that is waiting for its copy of
+// the data is seen as it is, so that the active node syncs it, anything else is
+// dead until it has caught up.
+func (decider *decider) join(watch *watched, other state.State, checked peer, err error) (peer, error) {
+	dead := peer{view: other, dbRole: state.Dead}
+	if err != nil {
+		return dead, nil
+	}
+	if checked.dbRole == state.Initialized {
+		return checked, nil
+	}
+	if !decider.joined(checked) {
+		return dead, nil
+	}
+
+	watch.joining = false
+	location := other.Location()
+	decider.log.With(config.Fields{"peer": location}).Info("'%v' has caught up and is admitted", location)
+	events.Publish(events.Event{Type: events.Admitted, Peer: location, DBRole: string(checked.dbRole)})
+	return checked, nil
+}
+
+// a joining node has caught up once it is a synced backup that is streaming from
+// this node, not further behind it than a backup may be to take over
+func (decider *decider) joined(checked peer) bool {
+	if checked.dbRole != state.Backup {
+		return false
+	}
+	synced, err := checked.view.HasSynced()
+	if err != nil {
+		return false
+	}
+	mine, err := decider.performer.Position()
+	if err != nil {
+		return false
+	}
+	theirs, err := checked.view.GetPosition()
+	if err != nil {
+		return false
+	}
+	// the position of this node is newer than the lag the backup measured, only the
+	// delay is taken from it
+	delay := time.Duration(0)
+	if decider.maxDelay != 0 {
+		if delay, _, err = checked.view.Lag(); err != nil {
+			return false
+		}
+	}
+	bytes := int64(0)
+	if mine > theirs {
+		bytes = int64(mine - theirs)
+	}
+	switch PhaseOf(synced, delay, bytes, decider.maxLag, decider.maxDelay) {
+	case Seeding:
+		return false
+	case CatchingUp:
+		decider.log.With(config.Fields{"peer": checked.view.Location()}).Info("'%v' is still catching up, %v bytes behind", checked.view.Location(), bytes)
+		return false
+	}
+	return true
+}
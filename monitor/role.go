@@ -0,0 +1,44 @@
+// Copyright (c) 2015 Pagoda Box Inc
+//
+// This Source Code Form is subject to the terms of the Mozilla Public License, v.
+// 2.0. If a copy of the MPL was not distributed with this file, You can obtain one
+// at http://mozilla.org/MPL/2.0/.
+//
+
+package monitor
+
+import (
+	"database/sql"
+	"github.com/lib/pq"
+)
+
+// replicationRole creates the role the backups replicate with, or gives it the
+// replication_password when it already exists. It runs on the writable node, the
+// backups get the role with their copy of the data. Without a replication_password
+// the backups replicate as the system user and there is nothing to create.
+func (performer *performer) replicationRole(db *sql.DB) error {
+	if performer.config.ReplicationPassword == "" {
+		return nil
+	}
+	if db == nil {
+		var err error
+		db, err = performer.pgConnect()
+		if err != nil {
+			return err
+		}
+		defer db.Close()
+	}
+
+	role := performer.config.ReplicationUser
+	var exists bool
+	if err := db.QueryRow("select exists(select 1 from pg_roles where rolname = $1)", role).Scan(&exists); err != nil {
+		return err
+	}
+	statement := "create role "
+	if exists {
+		statement = "alter role "
+	}
+	// the password can not be a parameter of a utility statement
+	_, err := db.Exec(statement + pq.QuoteIdentifier(role) + " with replication login password " + pq.QuoteLiteral(performer.config.ReplicationPassword))
+	return err
+}
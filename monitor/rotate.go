@@ -0,0 +1,95 @@
+// Copyright (c) 2015 Pagoda Box Inc
+//
+// This Source Code Form is subject to the terms of the Mozilla Public License, v.
+// 2.0. If a copy of the MPL was not distributed with this file, You can obtain one
+// at http://mozilla.org/MPL/2.0/.
+//
+
+package monitor
+
+import (
+	"fmt"
+	"github.com/lib/pq"
+	"github.com/nanopack/yoke/config"
+	"net"
+)
+
+// Rotate takes over the passwords of conf when they were rotated. The writable
+// postgres node gives the replication role the new password, and a backup
+// replicates with it right away. The other roles pick it up with their next
+// transition.
+func (performer *performer) Rotate(conf config.Config) error {
+	performer.Lock()
+	defer performer.Unlock()
+	performer.config.MySQLPassword = conf.MySQLPassword
+	performer.config.ReplicationPassword = conf.ReplicationPassword
+	performer.config.RedisPassword = conf.RedisPassword
+
+	role, err := performer.me.GetDBRole()
+	if err != nil {
+		return err
+	}
+	switch role {
+	case "active", "single":
+		if performer.database != performer {
+			return nil
+		}
+		performer.log.Info("[action] setting the rotated replication password")
+		return performer.replicationRole(nil)
+	case "backup":
+		performer.log.Info("[action] replicating with the rotated password")
+		return performer.database.rotate()
+	}
+	return nil
+}
+
+// postgres 13 and later take a new primary_conninfo with a reload, the older ones
+// only read it when they start
+func (performer *performer) rotate() error {
+	source, err := performer.source()
+	if err != nil {
+		return err
+	}
+	ip, _, err := net.SplitHostPort(source.Location())
+	if err != nil {
+		return err
+	}
+	if performer.pgVersion().AtLeast(13) {
+		db, err := performer.pgConnect()
+		if err != nil {
+			return err
+		}
+		defer db.Close()
+		if _, err := db.Exec("alter system set primary_conninfo = " + pq.QuoteLiteral(performer.config.Conninfo(ip, performer.config.PGPort))); err != nil {
+			return err
+		}
+		_, err = db.Exec("select pg_reload_conf()")
+		return err
+	}
+	if err := performer.config.CreateRecovery(ip, performer.config.PGPort); err != nil {
+		return err
+	}
+	if err := performer.stop(); err != nil {
+		return err
+	}
+	return performer.startDB()
+}
+
+func (performer *mysqlPerformer) rotate() error {
+	for _, step := range []string{
+		"STOP SLAVE",
+		fmt.Sprintf("CHANGE MASTER TO MASTER_PASSWORD=%v", quote(performer.config.ReplicationPassword)),
+		"START SLAVE",
+	} {
+		if _, err := performer.query(step); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// the replica authenticates with the masterauth the next time it connects
+func (performer *redisPerformer) rotate() error {
+	_, err := performer.command("CONFIG", "SET", "masterauth", performer.config.RedisPassword)
+	return err
+}
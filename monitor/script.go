@@ -0,0 +1,177 @@
+// Copyright (c) 2015 Pagoda Box Inc
+//
+// This Source Code Form is subject to the terms of the Mozilla Public License, v.
+// 2.0. If a copy of the MPL was not distributed with this file, You can obtain one
+// at http://mozilla.org/MPL/2.0/.
+//
+
+// script.go moves any service between roles with the commands of the [script]
+// section, so that anything with a primary and replicas (an ldap server, a message
+// broker, an app of its own) can be failed over by yoke. The service is started by
+// something else, yoke only runs the command of every transition.
+
+package monitor
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"github.com/hoisie/mustache"
+	"github.com/nanopack/yoke/config"
+	"github.com/nanopack/yoke/state"
+	"github.com/nanopack/yoke/vip"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type (
+	scriptPerformer struct {
+		*performer
+	}
+)
+
+// NewScriptPerformer creates a performer that moves a service between roles with
+// the commands of the config
+func NewScriptPerformer(me state.State, others []state.State, floating vip.VIP, config config.Config) *scriptPerformer {
+	perform := &scriptPerformer{performer: NewPerformer(me, others, floating, config)}
+	perform.database = perform
+	return perform
+}
+
+// Initialize has nothing to wait for, the commands wait for the service themselves
+func (performer *scriptPerformer) Initialize() error {
+	return nil
+}
+
+// Start has the service stop taking writes until the decider has decided what it is
+func (performer *scriptPerformer) Start() error {
+	performer.Lock()
+	defer performer.Unlock()
+	return performer.run("stop", performer.config.ScriptStop, nil)
+}
+
+// The Single state.
+func (performer *scriptPerformer) Single() error {
+	performer.log.Info("transitioning to Single")
+	if err := performer.run("to_single", performer.config.ScriptToSingle, nil); err != nil {
+		return err
+	}
+
+	performer.addVip()
+	performer.roleChangeCommand("single")
+	if err := setDBRole(performer.log, performer.me, state.Single); err != nil {
+		return err
+	}
+	transitions.Inc("single")
+	return nil
+}
+
+// The Active state.
+func (performer *scriptPerformer) Active() error {
+	performer.log.Info("transitioning to Active")
+	if err := performer.run("to_active", performer.config.ScriptToActive, nil); err != nil {
+		return err
+	}
+
+	performer.addVip()
+	performer.roleChangeCommand("master")
+	if err := setDBRole(performer.log, performer.me, state.Active); err != nil {
+		return err
+	}
+	transitions.Inc("active")
+	return nil
+}
+
+// The Backup state. The backup is synced once to_backup_of succeeded, the command
+// only exits once the service follows the peer.
+func (performer *scriptPerformer) Backup() error {
+	performer.log.Info("transitioning to Backup")
+	performer.removeVip()
+
+	source, err := performer.source()
+	if err != nil {
+		return err
+	}
+	host, _, err := net.SplitHostPort(source.Location())
+	if err != nil {
+		return err
+	}
+
+	performer.log.With(config.Fields{"peer": source.Location()}).Info("[script] following '%v'", source.Location())
+	peer := map[string]string{"peer_ip": host, "peer_port": strconv.Itoa(performer.config.ScriptPort)}
+	if err := performer.run("to_backup_of", performer.config.ScriptToBackupOf, peer); err != nil {
+		return err
+	}
+
+	performer.roleChangeCommand("backup")
+	transitions.Inc("backup")
+	if err := setDBRole(performer.log, performer.me, state.Backup); err != nil {
+		return err
+	}
+	return performer.me.SetSynced(true)
+}
+
+// stop has the service stop taking writes, and stop following its peer
+func (performer *scriptPerformer) stop() error {
+	return performer.run("stop", performer.config.ScriptStop, nil)
+}
+
+// the scripts are not given the replication password
+func (performer *scriptPerformer) rotate() error {
+	return nil
+}
+
+// Position is what the position command prints, the backup that is the furthest
+// along takes over. Without a command every backup is as far along as the others.
+func (performer *scriptPerformer) Position() (uint64, error) {
+	if performer.config.ScriptPosition == "" {
+		return 0, nil
+	}
+	out, err := performer.output("position", performer.config.ScriptPosition, nil)
+	if err != nil {
+		return 0, err
+	}
+	return strconv.ParseUint(strings.TrimSpace(out), 10, 64)
+}
+
+// ReplayDelay is not something the commands report
+func (performer *scriptPerformer) ReplayDelay() (time.Duration, error) {
+	return 0, nil
+}
+
+// run runs one of the commands, rendered with the variables of the node and the
+// ones that are given
+func (performer *scriptPerformer) run(name, command string, variables map[string]string) error {
+	_, err := performer.output(name, command, variables)
+	return err
+}
+
+// output runs one of the commands, and returns what it printed
+func (performer *scriptPerformer) output(name, command string, variables map[string]string) (string, error) {
+	if command == "" {
+		return "", nil
+	}
+	values := map[string]string{
+		"ip":   performer.config.AdvertiseIp,
+		"port": strconv.Itoa(performer.config.ScriptPort),
+		"role": performer.config.Role,
+	}
+	for variable, value := range variables {
+		values[variable] = value
+	}
+	command = mustache.Render(command, values)
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(performer.config.ScriptTimeout)*time.Second)
+	defer cancel()
+	cmd := config.Shell(ctx, command)
+	out := &bytes.Buffer{}
+	cmd.Stdout = out
+	cmd.Stderr = NewPrefix("[script." + name + ".stderr]")
+	performer.log.Debug("[script] %v(%s)", name, command)
+	if err := cmd.Run(); err != nil {
+		return "", fmt.Errorf("[script] %v failed: %v", name, err)
+	}
+	return out.String(), nil
+}
@@ -0,0 +1,47 @@
+// Copyright (c) 2015 Pagoda Box Inc
+//
+// This Source Code Form is subject to the terms of the Mozilla Public License, v.
+// 2.0. If a copy of the MPL was not distributed with this file, You can obtain one
+// at http://mozilla.org/MPL/2.0/.
+//
+
+package monitor
+
+import (
+	"github.com/nanopack/yoke/config"
+	"testing"
+)
+
+func TestScriptCommands(test *testing.T) {
+	perform := &scriptPerformer{performer: &performer{
+		config: config.Config{AdvertiseIp: "10.0.0.2", ScriptPort: 389, ScriptTimeout: 5, ScriptPosition: "echo 42"},
+		log:    config.Log,
+	}}
+
+	// the peer is only known to to_backup_of
+	out, err := perform.output("to_backup_of", "echo {{peer_ip}}:{{peer_port}} {{ip}}:{{port}}", map[string]string{"peer_ip": "10.0.0.1", "peer_port": "389"})
+	if err != nil || out != "10.0.0.1:389 10.0.0.2:389\n" {
+		test.Logf("the command was not rendered with the peer '%v' (%v)", out, err)
+		test.Fail()
+	}
+	if position, err := perform.Position(); err != nil || position != 42 {
+		test.Logf("the position should have been what the command printed, not %v (%v)", position, err)
+		test.Fail()
+	}
+
+	// a failed command fails the transition
+	if err := perform.run("to_active", "exit 3", nil); err == nil {
+		test.Log("a command that failed should have failed the transition")
+		test.Fail()
+	}
+	perform.config.ScriptTimeout = 1
+	if err := perform.run("to_single", "sleep 5", nil); err == nil {
+		test.Log("a command that ran past the timeout should have failed")
+		test.Fail()
+	}
+	// stopping is optional
+	if err := perform.stop(); err != nil {
+		test.Log(err)
+		test.Fail()
+	}
+}
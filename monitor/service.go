@@ -0,0 +1,171 @@
+// Copyright (c) 2015 Pagoda Box Inc
+//
+// This Source Code Form is subject to the terms of the Mozilla Public License, v.
+// 2.0. If a copy of the MPL was not distributed with this file, You can obtain one
+// at http://mozilla.org/MPL/2.0/.
+//
+
+// service.go moves a service that yoke was not built with between roles, the
+// service is registered under the name the database option selects. The plugin
+// package registers the services of the plugins it starts.
+
+package monitor
+
+import (
+	"fmt"
+	"github.com/nanopack/yoke/config"
+	"github.com/nanopack/yoke/state"
+	"github.com/nanopack/yoke/vip"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+)
+
+type (
+	// Service is the service half of a transition, the performer takes care of the
+	// vip, the hooks and the db role of the node
+	Service interface {
+		// waits for the service to be there
+		Initialize() error
+		// has the service stop taking writes until the decider has decided what
+		// it is
+		Start() error
+		Single() error
+		Active() error
+		// has the service follow the one at peer ('host:port'), it returns once
+		// the service is synced
+		Backup(peer string) error
+		Stop() error
+		// the backup that is the furthest along takes over
+		Position() (uint64, error)
+		ReplayDelay() (time.Duration, error)
+	}
+
+	// ServiceFactory creates a service from the node configuration
+	ServiceFactory func(config.Config) (Service, error)
+
+	servicePerformer struct {
+		*performer
+		service Service
+	}
+)
+
+var (
+	serviceLock sync.Mutex
+	services    = map[string]ServiceFactory{}
+)
+
+// RegisterService makes a service available under name, so it can be selected
+// with the 'database' config option
+func RegisterService(name string, factory ServiceFactory) {
+	serviceLock.Lock()
+	defer serviceLock.Unlock()
+	services[name] = factory
+}
+
+// NewServicePerformer creates a performer that moves the service that was selected
+// in the config between roles
+func NewServicePerformer(me state.State, others []state.State, floating vip.VIP, config config.Config) (*servicePerformer, error) {
+	serviceLock.Lock()
+	factory, ok := services[config.Database]
+	serviceLock.Unlock()
+	if !ok {
+		return nil, fmt.Errorf("unknown database '%v'", config.Database)
+	}
+	service, err := factory(config)
+	if err != nil {
+		return nil, err
+	}
+
+	perform := &servicePerformer{performer: NewPerformer(me, others, floating, config), service: service}
+	perform.database = perform
+	return perform, nil
+}
+
+func (performer *servicePerformer) Initialize() error {
+	return performer.service.Initialize()
+}
+
+func (performer *servicePerformer) Start() error {
+	performer.Lock()
+	defer performer.Unlock()
+	return performer.service.Start()
+}
+
+// The Single state.
+func (performer *servicePerformer) Single() error {
+	performer.log.Info("transitioning to Single")
+	if err := performer.service.Single(); err != nil {
+		return err
+	}
+
+	performer.addVip()
+	performer.roleChangeCommand("single")
+	if err := setDBRole(performer.log, performer.me, state.Single); err != nil {
+		return err
+	}
+	transitions.Inc("single")
+	return nil
+}
+
+// The Active state.
+func (performer *servicePerformer) Active() error {
+	performer.log.Info("transitioning to Active")
+	if err := performer.service.Active(); err != nil {
+		return err
+	}
+
+	performer.addVip()
+	performer.roleChangeCommand("master")
+	if err := setDBRole(performer.log, performer.me, state.Active); err != nil {
+		return err
+	}
+	transitions.Inc("active")
+	return nil
+}
+
+// The Backup state.
+func (performer *servicePerformer) Backup() error {
+	performer.log.Info("transitioning to Backup")
+	performer.removeVip()
+
+	source, err := performer.source()
+	if err != nil {
+		return err
+	}
+	host, _, err := net.SplitHostPort(source.Location())
+	if err != nil {
+		return err
+	}
+
+	peer := net.JoinHostPort(host, strconv.Itoa(performer.config.DatabasePort()))
+	performer.log.With(config.Fields{"peer": source.Location()}).Info("[service] following '%v'", peer)
+	if err := performer.service.Backup(peer); err != nil {
+		return err
+	}
+
+	performer.roleChangeCommand("backup")
+	transitions.Inc("backup")
+	if err := setDBRole(performer.log, performer.me, state.Backup); err != nil {
+		return err
+	}
+	return performer.me.SetSynced(true)
+}
+
+func (performer *servicePerformer) stop() error {
+	return performer.service.Stop()
+}
+
+// the service is not given the replication password
+func (performer *servicePerformer) rotate() error {
+	return nil
+}
+
+func (performer *servicePerformer) Position() (uint64, error) {
+	return performer.service.Position()
+}
+
+func (performer *servicePerformer) ReplayDelay() (time.Duration, error) {
+	return performer.service.ReplayDelay()
+}
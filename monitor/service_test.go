@@ -0,0 +1,193 @@
+// Copyright (c) 2015 Pagoda Box Inc
+//
+// This Source Code Form is subject to the terms of the Mozilla Public License, v.
+// 2.0. If a copy of the MPL was not distributed with this file, You can obtain one
+// at http://mozilla.org/MPL/2.0/.
+//
+
+package monitor
+
+import (
+	"errors"
+	"github.com/golang/mock/gomock"
+	"github.com/nanopack/yoke/config"
+	"github.com/nanopack/yoke/state"
+	"github.com/nanopack/yoke/state/mock"
+	"github.com/nanopack/yoke/vip"
+	"sync"
+	"testing"
+	"time"
+)
+
+// a service that records what it was asked to do, fail makes the named call fail
+type fakeService struct {
+	sync.Mutex
+	calls []string
+	fail  map[string]error
+}
+
+func (service *fakeService) record(call string) error {
+	service.Lock()
+	defer service.Unlock()
+	service.calls = append(service.calls, call)
+	return service.fail[call]
+}
+
+func (service *fakeService) recorded() []string {
+	service.Lock()
+	defer service.Unlock()
+	return append([]string{}, service.calls...)
+}
+
+func (service *fakeService) Initialize() error { return service.record("initialize") }
+func (service *fakeService) Start() error      { return service.record("start") }
+func (service *fakeService) Single() error     { return service.record("single") }
+func (service *fakeService) Active() error     { return service.record("active") }
+func (service *fakeService) Stop() error       { return service.record("stop") }
+
+func (service *fakeService) Backup(peer string) error {
+	return service.record("backup " + peer)
+}
+
+func (service *fakeService) Position() (uint64, error) {
+	return 42, service.record("position")
+}
+
+func (service *fakeService) ReplayDelay() (time.Duration, error) {
+	return 0, service.record("replay delay")
+}
+
+// a performer for a fake service, along with the errors it ran into
+func serving(test *testing.T, me, other *mock_state.MockState, service *fakeService) (*servicePerformer, chan error) {
+	RegisterService("fake", func(config.Config) (Service, error) {
+		return service, nil
+	})
+	perform, err := NewServicePerformer(me, []state.State{other}, vip.None, config.Config{Database: "fake", PGPort: 5432})
+	if err != nil {
+		test.Log(err)
+		test.FailNow()
+	}
+	errs := make(chan error, 10)
+	go func() {
+		for err := range perform.err {
+			errs <- err
+		}
+	}()
+	return perform, errs
+}
+
+func TestServiceTransitions(test *testing.T) {
+	ctrl := gomock.NewController(test)
+	defer ctrl.Finish()
+
+	me := mock_state.NewMockState(ctrl)
+	other := mock_state.NewMockState(ctrl)
+	service := &fakeService{}
+	perform, errs := serving(test, me, other, service)
+
+	if err := perform.Initialize(); err != nil {
+		test.Log(err)
+		test.FailNow()
+	}
+	if err := perform.Start(); err != nil {
+		test.Log(err)
+		test.FailNow()
+	}
+
+	// the other node went away, the backup takes over on its own
+	me.EXPECT().GetDBRole().Return("backup", nil)
+	me.EXPECT().SetDBRole("single").Return(nil)
+	perform.TransitionToSingle()
+
+	// the other node came back
+	me.EXPECT().GetDBRole().Return("single", nil)
+	me.EXPECT().SetDBRole("active").Return(nil)
+	perform.TransitionToActive()
+
+	// the other node took over, the service follows it on the port of the service
+	me.EXPECT().GetDBRole().Return("active", nil)
+	other.EXPECT().GetDBRole().Return("active", nil)
+	other.EXPECT().Location().Return("127.0.0.2:4400").AnyTimes()
+	me.EXPECT().SetDBRole("backup").Return(nil)
+	me.EXPECT().SetSynced(true).Return(nil)
+	perform.TransitionToBackup()
+
+	perform.Stop()
+
+	if position, err := perform.Position(); err != nil || position != 42 {
+		test.Logf("the position of the service was not passed on %v %v", position, err)
+		test.Fail()
+	}
+
+	expected := []string{"initialize", "start", "single", "active", "backup 127.0.0.2:5432", "stop", "position"}
+	calls := service.recorded()
+	if len(calls) != len(expected) {
+		test.Logf("the service was asked %v instead of %v", calls, expected)
+		test.FailNow()
+	}
+	for i := range expected {
+		if calls[i] != expected[i] {
+			test.Logf("the service was asked %v instead of %v", calls, expected)
+			test.FailNow()
+		}
+	}
+	select {
+	case err := <-errs:
+		test.Log(err)
+		test.Fail()
+	default:
+	}
+}
+
+func TestServiceTransitionFails(test *testing.T) {
+	ctrl := gomock.NewController(test)
+	defer ctrl.Finish()
+
+	me := mock_state.NewMockState(ctrl)
+	other := mock_state.NewMockState(ctrl)
+	failed := errors.New("promotion failed")
+	service := &fakeService{fail: map[string]error{"active": failed}}
+	perform, errs := serving(test, me, other, service)
+
+	// the role is not recorded when the service did not get there
+	me.EXPECT().GetDBRole().Return("single", nil)
+	perform.TransitionToActive()
+	select {
+	case err := <-errs:
+		if err != failed {
+			test.Logf("wrong error %v", err)
+			test.Fail()
+		}
+	case <-time.After(time.Second):
+		test.Log("the failed transition was not reported")
+		test.Fail()
+	}
+
+	// there is nothing to follow
+	me.EXPECT().GetDBRole().Return("single", nil)
+	other.EXPECT().GetDBRole().Return("backup", nil)
+	perform.TransitionToBackup()
+	select {
+	case err := <-errs:
+		if err != NoSource {
+			test.Logf("wrong error %v", err)
+			test.Fail()
+		}
+	case <-time.After(time.Second):
+		test.Log("a backup without a node to follow was not reported")
+		test.Fail()
+	}
+
+	calls := service.recorded()
+	if len(calls) != 1 || calls[0] != "active" {
+		test.Logf("the service was asked %v", calls)
+		test.Fail()
+	}
+}
+
+func TestUnknownService(test *testing.T) {
+	if _, err := NewServicePerformer(nil, nil, vip.None, config.Config{Database: "unknown"}); err == nil {
+		test.Log("a service that was never registered should not have a performer")
+		test.Fail()
+	}
+}
@@ -0,0 +1,54 @@
+// Copyright (c) 2015 Pagoda Box Inc
+//
+// This Source Code Form is subject to the terms of the Mozilla Public License, v.
+// 2.0. If a copy of the MPL was not distributed with this file, You can obtain one
+// at http://mozilla.org/MPL/2.0/.
+//
+
+package monitor
+
+import (
+	"database/sql"
+	"strings"
+)
+
+// configureRole writes the parameters of the [postgres] sections for role and has
+// postgres reload them. The parameters that only change with a restart are logged,
+// they take effect the next time postgres starts.
+func (performer *performer) configureRole(role string, db *sql.DB) error {
+	if len(performer.config.PGParameters) == 0 {
+		return nil
+	}
+	if err := performer.config.ConfigureRole(role); err != nil {
+		return err
+	}
+	if db == nil {
+		var err error
+		db, err = performer.pgConnect()
+		if err != nil {
+			return err
+		}
+		defer db.Close()
+	}
+	if _, err := db.Exec("select pg_reload_conf()"); err != nil {
+		return err
+	}
+
+	rows, err := db.Query("select name from pg_settings where pending_restart")
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	var pending []string
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			return err
+		}
+		pending = append(pending, name)
+	}
+	if len(pending) != 0 {
+		performer.log.Warn("[action] the %v settings change when postgres is restarted (%v)", role, strings.Join(pending, ", "))
+	}
+	return rows.Err()
+}
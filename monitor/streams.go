@@ -0,0 +1,136 @@
+// Copyright (c) 2015 Pagoda Box Inc
+//
+// This Source Code Form is subject to the terms of the Mozilla Public License, v.
+// 2.0. If a copy of the MPL was not distributed with this file, You can obtain one
+// at http://mozilla.org/MPL/2.0/.
+//
+
+// streams.go splits the first copy of the data over to a backup into several
+// rsyncs that run at once, a single one can't fill a fast link. Only the files of
+// the databases are split up, they are nearly all of the data, and the sync
+// command copies everything else afterwards as it always does.
+
+package monitor
+
+import (
+	"context"
+	"fmt"
+	"github.com/nanopack/yoke/config"
+	"github.com/nanopack/yoke/state"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// a file of the data directory, and how big it is
+type dataFile struct {
+	path string // relative to the data directory
+	size int64
+}
+
+// syncStreams copies the database files over to other in sync_streams runs of the
+// sync_stream_command at once, which share the sync_rate_limit
+func (performer *performer) syncStreams(other state.State) error {
+	streams, err := splitDataDir(performer.config.DataDir, performer.config.SyncStreams)
+	if err != nil {
+		return err
+	}
+	// rsync takes a limit of 0 as no limit at all
+	rateLimit := performer.config.SyncRateLimit / len(streams)
+	if performer.config.SyncRateLimit > 0 && rateLimit < 1 {
+		rateLimit = 1
+	}
+
+	// the streams are retried on their own, one that broke off doesn't start the
+	// others over
+	performer.progress.start(other.Location(), 1)
+	defer performer.progress.done()
+	errs := make(chan error, len(streams))
+	for i, files := range streams {
+		go func(stream int, files []string) {
+			errs <- performer.syncStream(other, stream, files, rateLimit)
+		}(i, files)
+	}
+	for range streams {
+		if streamErr := <-errs; streamErr != nil && err == nil {
+			err = streamErr
+		}
+	}
+	return err
+}
+
+// syncStream runs the sync_stream_command for one of the streams
+func (performer *performer) syncStream(other state.State, stream int, files []string, rateLimit int) error {
+	list, err := ioutil.TempFile("", "yoke-sync")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(list.Name())
+	_, err = list.WriteString(strings.Join(files, "\n") + "\n")
+	list.Close()
+	if err != nil {
+		return err
+	}
+
+	command, err := performer.renderSync(performer.config.SyncStreamCommand, other, rateLimit, list.Name())
+	if err != nil {
+		return err
+	}
+	performer.log.Info("[action] syncing %d files to '%v' in stream %d", len(files), other.Location(), stream)
+	return performer.retry(other.Location(), func() error {
+		sc := config.Shell(context.Background(), command)
+		sc.Stdout = performer.progress.watchStream(stream, NewPrefix(fmt.Sprintf("[sync-%d.stdout]", stream)))
+		sc.Stderr = NewPrefix(fmt.Sprintf("[sync-%d.stderr]", stream))
+		return sc.Run()
+	})
+}
+
+// splitDataDir deals the files of the databases in dir out to at most streams
+// lists, so that each of them has about as much data to copy
+func splitDataDir(dir string, streams int) ([][]string, error) {
+	files := []dataFile{}
+	base := filepath.Join(dir, "base")
+	err := filepath.Walk(base, func(path string, info os.FileInfo, err error) error {
+		// a table that was dropped while the directory is walked is not copied
+		if os.IsNotExist(err) {
+			return nil
+		}
+		if err != nil || !info.Mode().IsRegular() {
+			return err
+		}
+		relative, err := filepath.Rel(dir, path)
+		if err != nil {
+			return err
+		}
+		files = append(files, dataFile{path: filepath.ToSlash(relative), size: info.Size()})
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	// the biggest files are dealt out first, each to the list with the least data
+	sort.Slice(files, func(i, j int) bool { return files[i].size > files[j].size })
+	lists := make([][]string, streams)
+	sizes := make([]int64, streams)
+	for _, file := range files {
+		smallest := 0
+		for i := range sizes {
+			if sizes[i] < sizes[smallest] {
+				smallest = i
+			}
+		}
+		lists[smallest] = append(lists[smallest], file.path)
+		sizes[smallest] += file.size
+	}
+
+	split := [][]string{}
+	for _, list := range lists {
+		if len(list) != 0 {
+			split = append(split, list)
+		}
+	}
+	return split, nil
+}
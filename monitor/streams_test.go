@@ -0,0 +1,43 @@
+// Copyright (c) 2015 Pagoda Box Inc
+//
+// This Source Code Form is subject to the terms of the Mozilla Public License, v.
+// 2.0. If a copy of the MPL was not distributed with this file, You can obtain one
+// at http://mozilla.org/MPL/2.0/.
+//
+
+package monitor
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestSplitDataDir(test *testing.T) {
+	dir, err := ioutil.TempDir("", "yoke-data")
+	if err != nil {
+		test.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	os.MkdirAll(filepath.Join(dir, "base", "1"), 0700)
+	for name, size := range map[string]int{"base/1/100": 600, "base/1/101": 300, "base/1/102": 200, "base/1/103": 100, "PG_VERSION": 3} {
+		ioutil.WriteFile(filepath.Join(dir, name), make([]byte, size), 0600)
+	}
+
+	streams, err := splitDataDir(dir, 2)
+	if err != nil {
+		test.Fatal(err)
+	}
+	// 600 on its own, and 300+200+100 together
+	if len(streams) != 2 || len(streams[0]) != 1 || streams[0][0] != "base/1/100" || len(streams[1]) != 3 {
+		test.Logf("the files should have been split evenly by size %v", streams)
+		test.Fail()
+	}
+
+	// a stream without files isn't run
+	if streams, _ = splitDataDir(dir, 8); len(streams) != 4 {
+		test.Logf("there should only be as many streams as files %v", streams)
+		test.Fail()
+	}
+}
@@ -0,0 +1,261 @@
+// Copyright (c) 2015 Pagoda Box Inc
+//
+// This Source Code Form is subject to the terms of the Mozilla Public License, v.
+// 2.0. If a copy of the MPL was not distributed with this file, You can obtain one
+// at http://mozilla.org/MPL/2.0/.
+//
+
+package monitor
+
+import (
+	"database/sql"
+	"fmt"
+	"github.com/nanopack/yoke/config"
+	"github.com/nanopack/yoke/events"
+	"github.com/nanopack/yoke/state"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"time"
+)
+
+type (
+	// a syncStrategy is how the data of the active node makes it over to a backup
+	syncStrategy interface {
+		// push runs on the node that is becoming active, it returns the backups
+		// that now have a copy of the data
+		push(performer *performer, db *sql.DB, others []state.State) ([]state.State, error)
+		// pull runs on the node that is becoming a backup, it returns once there is
+		// a copy of the data on this node
+		pull(performer *performer) error
+	}
+
+	// rsync has the active node copy its data directory over to the backups with
+	// the sync_command while postgres is in backup mode
+	rsyncStrategy struct{}
+
+	// basebackup has the backup take a copy from the active node with
+	// pg_basebackup, streaming the WAL that is written while the copy is made
+	basebackupStrategy struct{}
+)
+
+func newSyncStrategy(conf config.Config) syncStrategy {
+	switch conf.SyncStrategy {
+	case "pg_basebackup":
+		return basebackupStrategy{}
+	default:
+		return rsyncStrategy{}
+	}
+}
+
+func (rsyncStrategy) push(performer *performer, db *sql.DB, others []state.State) ([]state.State, error) {
+	// do an initial copy of files which might be corrupt because they are not consistant
+	// this will be fixed later. we do this now so that a majority of the data will make it across without
+	// having to pause the Durablility (ACID compliance) of postgres
+	performer.log.Debug("[action] pre-backup started")
+	syncs := map[state.State]string{}
+	for _, other := range others {
+		sync, err := performer.syncCommand(other)
+		if err != nil {
+			// this node can't be reached right now, it will be synced the next time around
+			performer.log.Info("[action] skipping sync to '%v' (%v)", other.Location(), err)
+			continue
+		}
+		if performer.config.SyncStreams > 1 {
+			err = performer.syncStreams(other)
+		} else {
+			err = performer.retrySync(other.Location(), func() error { return performer.sync(sync) })
+		}
+		if err != nil {
+			return nil, err
+		}
+		syncs[other] = sync
+	}
+
+	// this informs postgres to make the files on disk consistant for copying,
+	// all changes are kept in memory from this point on
+	if _, err := db.Exec("select pg_start_backup('replication')"); err != nil {
+		return nil, err
+	}
+
+	performer.log.Debug("[action] backup started")
+
+	synced := []state.State{}
+	for other, sync := range syncs {
+		if err := performer.retrySync(other.Location(), func() error { return performer.sync(sync) }); err != nil {
+			// this backup will have to be synced again later
+			performer.log.Info("[action] sync to '%v' failed (%v)", other.Location(), err)
+			continue
+		}
+		synced = append(synced, other)
+	}
+
+	// connect to DB and tell it to stop backup
+	if _, err := db.Exec("select pg_stop_backup()"); err != nil {
+		return nil, err
+	}
+
+	performer.log.Debug("[action] backup complete")
+	return synced, nil
+}
+
+func (rsyncStrategy) pull(performer *performer) error {
+	// wait for master server to be running
+	for {
+		ready, err := performer.me.HasSynced()
+		if err != nil {
+			return err
+		}
+		if ready {
+			return nil
+		}
+		time.Sleep(time.Second)
+	}
+}
+
+// the backups pull their own copy, they are picked up by the active node once
+// they are streaming from it
+func (basebackupStrategy) push(performer *performer, db *sql.DB, others []state.State) ([]state.State, error) {
+	return nil, nil
+}
+
+func (basebackupStrategy) pull(performer *performer) error {
+	// this node was already brought in line with the active node
+	if synced, err := performer.me.HasSynced(); err != nil || synced {
+		return err
+	}
+
+	source, err := performer.source()
+	if err != nil {
+		return err
+	}
+	ip, _, err := net.SplitHostPort(source.Location())
+	if err != nil {
+		return err
+	}
+
+	if err := performer.stop(); err != nil {
+		return err
+	}
+
+	// the version is read from the data directory before it is emptied
+	version := performer.pgVersion()
+	walMethod := version.WALMethod("stream")
+
+	args := []string{
+		"-h", ip,
+		"-p", fmt.Sprintf("%d", performer.config.PGPort),
+		"-U", performer.config.ReplicationRole(),
+		"-D", performer.config.DataDir,
+		walMethod,
+		"--checkpoint=fast",
+		"--progress"}
+	if performer.config.SyncRateLimit != 0 {
+		args = append(args, fmt.Sprintf("--max-rate=%dk", performer.config.SyncRateLimit))
+	}
+	// the source compresses the copy before it sends it, postgres decompresses it
+	// again as it writes the data directory
+	compression := config.Compression{Name: performer.config.SyncCompression, Level: performer.config.SyncCompressionLevel}
+	if flag := version.BackupCompression(compression, "server"); flag != "" {
+		args = append(args, flag)
+	}
+
+	// a copy that broke off can't be picked up again, every attempt starts over
+	err = performer.retrySync(source.Location(), func() error {
+		// pg_basebackup only writes into an empty data directory
+		performer.log.Info("[action] clearing the data directory for a copy from '%v'", source.Location())
+		if err := performer.clearDataDir(); err != nil {
+			return err
+		}
+
+		performer.log.Info("[action] copying the data over from '%v'", source.Location())
+		backup := exec.Command("pg_basebackup", args...)
+		if performer.config.ReplicationPassword != "" {
+			backup.Env = append(os.Environ(), "PGPASSWORD="+performer.config.ReplicationPassword)
+		}
+		backup.Stdout = NewPrefix("[pg_basebackup.stdout]")
+		backup.Stderr = performer.progress.watch(NewPrefix("[pg_basebackup.stderr]"))
+		return backup.Run()
+	})
+	if err != nil {
+		return err
+	}
+	if performer.config.VerifySync {
+		if err := performer.verifyCopy(source.Location()); err != nil {
+			return err
+		}
+	}
+
+	if err := performer.config.CreateRecovery(ip, performer.config.PGPort); err != nil {
+		return err
+	}
+	return performer.me.SetSynced(true)
+}
+
+// retrySync runs the copy of the data from or to peer until it succeeds or
+// failed sync_retries more times. The sync_command is expected to pick up where
+// the attempt before it left off (rsync --partial does).
+func (performer *performer) retrySync(peer string, run func() error) error {
+	defer performer.progress.done()
+	attempt := 0
+	return performer.retry(peer, func() error {
+		attempt++
+		performer.progress.start(peer, attempt)
+		return run()
+	})
+}
+
+// retry runs a copy of the data from or to peer until it succeeds or failed
+// sync_retries more times
+func (performer *performer) retry(peer string, run func() error) error {
+	var err error
+	for attempt := 1; attempt <= performer.config.SyncRetries+1; attempt++ {
+		if attempt > 1 {
+			performer.log.Info("[action] copying the data with '%v' failed, trying again in %ds (%v)", peer, performer.config.SyncRetryDelay, err)
+			<-time.After(time.Duration(performer.config.SyncRetryDelay) * time.Second)
+		}
+		if err = run(); err == nil {
+			return nil
+		}
+	}
+	return err
+}
+
+// clearDataDir removes everything in the data directory, but not the directory
+func (performer *performer) clearDataDir() error {
+	entries, err := filepath.Glob(filepath.Join(performer.config.DataDir, "*"))
+	if err != nil {
+		return err
+	}
+	for _, entry := range entries {
+		if err := os.RemoveAll(entry); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// verifyCopy has pg_verifybackup check the copy pg_basebackup just took against
+// its backup_manifest, before the recovery settings change any of its files. A
+// copy that does not verify is thrown away, so the node is never synced with it
+// and can't be promoted.
+func (performer *performer) verifyCopy(source string) error {
+	performer.log.Info("[action] verifying the copy from '%v'", source)
+	verify := exec.Command("pg_verifybackup", performer.config.DataDir)
+	verify.Stdout = NewPrefix("[pg_verifybackup.stdout]")
+	verify.Stderr = NewPrefix("[pg_verifybackup.stderr]")
+	err := verify.Run()
+	if err == nil {
+		verifications.Inc("verified")
+		return nil
+	}
+
+	verifications.Inc("unverified")
+	performer.log.Error("[action] the copy from '%v' did not verify, throwing it away (%v)", source, err)
+	events.Publish(events.Event{Type: events.SyncUnverified, Peer: source, Error: err.Error()})
+	if clearErr := performer.clearDataDir(); clearErr != nil {
+		return clearErr
+	}
+	return fmt.Errorf("the copy from '%v' did not verify (%v)", source, err)
+}
@@ -0,0 +1,91 @@
+// Copyright (c) 2015 Pagoda Box Inc
+//
+// This Source Code Form is subject to the terms of the Mozilla Public License, v.
+// 2.0. If a copy of the MPL was not distributed with this file, You can obtain one
+// at http://mozilla.org/MPL/2.0/.
+//
+
+// upgrade.go restarts the database of a backup on new binaries. A rolling upgrade
+// of the cluster upgrades every backup, switches over to one of them, and then
+// upgrades the node that used to be active once it follows as a backup.
+
+package monitor
+
+import (
+	"context"
+	"github.com/nanopack/yoke/config"
+	"github.com/nanopack/yoke/events"
+	"github.com/nanopack/yoke/state"
+)
+
+// Upgrade stops the database of this node, runs the upgrade command and starts the
+// database again, so that it runs on whatever binaries the command installed. Only
+// a backup can be upgraded, the active node has to switch over first. While its
+// database is down the node advertises itself as 'upgrading', so the active node
+// goes single instead of waiting on it to confirm commits and no other backup
+// counts on it. The decider makes no decisions until the node is a backup again.
+// The database is started again when the command fails, and the error is returned.
+func (decider *decider) Upgrade() error {
+	decider.Lock()
+	defer decider.Unlock()
+	defer decider.plan.working()()
+
+	return decider.audit("upgrade", state.Backup, decider.upgradeDatabase())
+}
+
+func (decider *decider) upgradeDatabase() error {
+	if decider.shutdown {
+		return ShutDown
+	}
+	role, err := dbRole(decider.me)
+	if err != nil {
+		return err
+	}
+	if role != state.Backup {
+		return NotBackup
+	}
+
+	decider.log.Info("upgrading, stopping the database")
+	if err := setDBRole(decider.log, decider.me, state.Upgrading); err != nil {
+		return err
+	}
+	events.Publish(events.Event{Type: events.UpgradeStarted, DBRole: string(state.Upgrading)})
+	decider.applied = ""
+	decider.plan.Performer.Stop()
+
+	upgradeErr := decider.runUpgrade()
+	if upgradeErr != nil {
+		decider.log.Error("the upgrade command failed, starting the database as it was (%v)", upgradeErr)
+		events.Publish(events.Event{Type: events.TransitionFailed, DBRole: string(state.Upgrading), Error: upgradeErr.Error()})
+	}
+
+	// the recovery config is still there, so the database goes back to following
+	// the active node
+	if err := decider.plan.Performer.Start(); err != nil {
+		return err
+	}
+	if err := setDBRole(decider.log, decider.me, state.Backup); err != nil {
+		return err
+	}
+	if upgradeErr != nil {
+		return upgradeErr
+	}
+	decider.log.Info("upgraded, following the active node again")
+	events.Publish(events.Event{Type: events.UpgradeCompleted, DBRole: string(state.Backup)})
+	return nil
+}
+
+// runs the upgrade command, nothing has to be run when the new binaries were
+// installed beforehand and only have to be picked up by a restart
+func (decider *decider) runUpgrade() error {
+	if decider.upgrade == "" {
+		return nil
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), decider.upTimeout)
+	defer cancel()
+	uc := config.Shell(ctx, decider.upgrade)
+	uc.Stdout = NewPrefix("[UpgradeCommand.stdout]")
+	uc.Stderr = NewPrefix("[UpgradeCommand.stderr]")
+	decider.log.Debug("[action] upgrade command(%s)", decider.upgrade)
+	return uc.Run()
+}
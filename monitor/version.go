@@ -0,0 +1,86 @@
+// Copyright (c) 2015 Pagoda Box Inc
+//
+// This Source Code Form is subject to the terms of the Mozilla Public License, v.
+// 2.0. If a copy of the MPL was not distributed with this file, You can obtain one
+// at http://mozilla.org/MPL/2.0/.
+//
+
+package monitor
+
+import (
+	"fmt"
+	"github.com/nanopack/yoke/config"
+)
+
+// a performer that knows the version of its database, for the status of the node
+type versioned interface {
+	Version() string
+}
+
+// detectVersion asks the running postgres for its version, and refuses the ones
+// yoke can't run. A server that is a different major version than the data_dir
+// was created with would not have started, but the binaries can be swapped
+// underneath a running one.
+func (performer *performer) detectVersion() error {
+	db, err := performer.pgConnect()
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+	var number string
+	if err := db.QueryRow("show server_version_num").Scan(&number); err != nil {
+		return err
+	}
+	version, err := config.ParsePGVersion(number)
+	if err != nil {
+		return err
+	}
+	if err := version.Supported(); err != nil {
+		return err
+	}
+	if data, err := performer.config.DataVersion(); err == nil && data.Major() != version.Major() {
+		return fmt.Errorf("the data_dir was created by postgres %v but the server is %v, it has to be upgraded with pg_upgrade first", data, version)
+	}
+	// the rsync strategy copies the data_dir during an exclusive backup, which
+	// postgres 15 no longer has
+	if version.AtLeast(15) && performer.config.SyncStrategy != "pg_basebackup" {
+		return fmt.Errorf("postgres %v can only be synced with sync_strategy=pg_basebackup", version)
+	}
+	if performer.config.VerifySync && !version.AtLeast(13) {
+		return fmt.Errorf("postgres %v can't verify its copies, verify_sync needs pg_verifybackup of postgres 13 or later", version)
+	}
+	if version.Major() > config.TestedPGVersion {
+		performer.log.Warn("[action] postgres %v is newer than %v, the newest version yoke was tested with", version, config.TestedPGVersion)
+	}
+	performer.log.Info("[action] running postgres %v, promoting with %v", version, version.Promotion())
+	performer.version = version
+	return nil
+}
+
+// pgVersion is the version of the database, until it was asked the one the data_dir
+// was created with
+func (performer *performer) pgVersion() config.PGVersion {
+	if performer.version != 0 {
+		return performer.version
+	}
+	if version, err := performer.config.DataVersion(); err == nil {
+		return version
+	}
+	return config.MinPGVersion
+}
+
+// Version is the version of postgres the node runs, empty until it was started
+func (performer *performer) Version() string {
+	if performer.version == 0 {
+		return ""
+	}
+	return performer.version.String()
+}
+
+// the version of the database of the node, for its summary
+func (decider *decider) version() string {
+	if versioned, ok := decider.performer.(versioned); ok {
+		return versioned.Version()
+	}
+	return ""
+}
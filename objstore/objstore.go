@@ -0,0 +1,193 @@
+// Copyright (c) 2015 Pagoda Box Inc
+//
+// This Source Code Form is subject to the terms of the Mozilla Public License, v.
+// 2.0. If a copy of the MPL was not distributed with this file, You can obtain one
+// at http://mozilla.org/MPL/2.0/.
+//
+
+// objstore is a small client for the parts of an S3 compatible object store yoke
+// uses to arbitrate a cluster without a monitor: reading an object, and replacing
+// it only if nobody else did in the meantime. Requests are signed with AWS
+// signature version 4, which S3 and the XML api of Google Cloud Storage (with HMAC
+// keys) both accept.
+package objstore
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// the dialects of conditional writes the client speaks
+const (
+	S3  = "s3"  // If-Match and If-None-Match on the ETag of the object
+	GCS = "gcs" // x-goog-if-generation-match on the generation of the object
+)
+
+type (
+	// Client reads and writes the objects of a single bucket
+	Client struct {
+		endpoint  string
+		bucket    string
+		region    string
+		dialect   string
+		accessKey string
+		secretKey string
+		client    *http.Client
+	}
+
+	// Object is an object as it was read, its version has to be given back to
+	// replace it
+	Object struct {
+		Body    []byte
+		Version string
+	}
+
+	// Error is a reply from the store that was not successful
+	Error struct {
+		Code    int
+		Message string
+	}
+)
+
+// New creates a client for the bucket at endpoint (e.g. 'https://s3.us-east-1.amazonaws.com'),
+// the objects are addressed by path so the bucket name does not have to resolve
+func New(endpoint, bucket, region, dialect, accessKey, secretKey string, client *http.Client) *Client {
+	return &Client{
+		endpoint:  strings.TrimSuffix(endpoint, "/"),
+		bucket:    bucket,
+		region:    region,
+		dialect:   dialect,
+		accessKey: accessKey,
+		secretKey: secretKey,
+		client:    client,
+	}
+}
+
+func (err Error) Error() string {
+	return fmt.Sprintf("object store: %v (%v)", err.Message, err.Code)
+}
+
+// IsNotFound checks if the store replied that the object does not exist
+func IsNotFound(err error) bool {
+	failed, ok := err.(Error)
+	return ok && failed.Code == http.StatusNotFound
+}
+
+// IsConflict checks if the store refused a write because the object was changed
+// by someone else since it was read
+func IsConflict(err error) bool {
+	failed, ok := err.(Error)
+	return ok && (failed.Code == http.StatusPreconditionFailed || failed.Code == http.StatusConflict)
+}
+
+// Get reads the object at key
+func (client *Client) Get(key string) (Object, error) {
+	res, err := client.do("GET", key, nil, nil)
+	if err != nil {
+		return Object{}, err
+	}
+	defer res.Body.Close()
+	body, err := ioutil.ReadAll(res.Body)
+	return Object{Body: body, Version: client.version(res)}, err
+}
+
+// Put writes body to key, but only if the object is still at version. An empty
+// version only writes an object that does not exist yet. A write that lost to
+// someone else fails with a conflict.
+func (client *Client) Put(key string, body []byte, version string) error {
+	headers := map[string]string{}
+	switch {
+	case client.dialect == GCS && version == "":
+		headers["x-goog-if-generation-match"] = "0"
+	case client.dialect == GCS:
+		headers["x-goog-if-generation-match"] = version
+	case version == "":
+		headers["If-None-Match"] = "*"
+	default:
+		headers["If-Match"] = version
+	}
+	res, err := client.do("PUT", key, body, headers)
+	if err != nil {
+		return err
+	}
+	return res.Body.Close()
+}
+
+func (client *Client) version(res *http.Response) string {
+	if client.dialect == GCS {
+		return res.Header.Get("x-goog-generation")
+	}
+	return res.Header.Get("ETag")
+}
+
+func (client *Client) do(method, key string, body []byte, headers map[string]string) (*http.Response, error) {
+	path := "/" + client.bucket + "/" + key
+	req, err := http.NewRequest(method, client.endpoint+(&url.URL{Path: path}).EscapedPath(), bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	for name, value := range headers {
+		req.Header.Set(name, value)
+	}
+	client.sign(req, body, time.Now().UTC())
+
+	res, err := client.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if res.StatusCode < 200 || res.StatusCode > 299 {
+		defer res.Body.Close()
+		return nil, Error{Code: res.StatusCode, Message: res.Status}
+	}
+	return res, nil
+}
+
+// sign adds the AWS signature version 4 headers to req
+func (client *Client) sign(req *http.Request, body []byte, now time.Time) {
+	stamp := now.Format("20060102T150405Z")
+	day := now.Format("20060102")
+	payload := hash(body)
+	req.Header.Set("x-amz-date", stamp)
+	req.Header.Set("x-amz-content-sha256", payload)
+
+	signed := "host;x-amz-content-sha256;x-amz-date"
+	canonical := strings.Join([]string{
+		req.Method,
+		req.URL.EscapedPath(),
+		req.URL.RawQuery,
+		"host:" + req.URL.Host,
+		"x-amz-content-sha256:" + payload,
+		"x-amz-date:" + stamp,
+		"",
+		signed,
+		payload,
+	}, "\n")
+	scope := day + "/" + client.region + "/s3/aws4_request"
+	toSign := "AWS4-HMAC-SHA256\n" + stamp + "\n" + scope + "\n" + hash([]byte(canonical))
+
+	key := mac([]byte("AWS4"+client.secretKey), day)
+	key = mac(key, client.region)
+	key = mac(key, "s3")
+	key = mac(key, "aws4_request")
+	signature := hex.EncodeToString(mac(key, toSign))
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%v/%v, SignedHeaders=%v, Signature=%v", client.accessKey, scope, signed, signature))
+}
+
+func hash(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+func mac(key []byte, data string) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write([]byte(data))
+	return h.Sum(nil)
+}
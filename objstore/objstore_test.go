@@ -0,0 +1,83 @@
+// Copyright (c) 2015 Pagoda Box Inc
+//
+// This Source Code Form is subject to the terms of the Mozilla Public License, v.
+// 2.0. If a copy of the MPL was not distributed with this file, You can obtain one
+// at http://mozilla.org/MPL/2.0/.
+//
+
+package objstore_test
+
+import (
+	"github.com/nanopack/yoke/objstore"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestConditionalWrites(test *testing.T) {
+	objects := map[string]string{}
+	versions := map[string]int{}
+	server := httptest.NewServer(http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+		if !strings.HasPrefix(req.Header.Get("Authorization"), "AWS4-HMAC-SHA256 Credential=key/") || req.Header.Get("x-amz-date") == "" {
+			res.WriteHeader(http.StatusForbidden)
+			return
+		}
+		version := strconv.Itoa(versions[req.URL.Path])
+		switch req.Method {
+		case "GET":
+			body, ok := objects[req.URL.Path]
+			if !ok {
+				res.WriteHeader(http.StatusNotFound)
+				return
+			}
+			res.Header().Set("ETag", version)
+			res.Write([]byte(body))
+		case "PUT":
+			_, exists := objects[req.URL.Path]
+			if (req.Header.Get("If-None-Match") == "*" && exists) || (req.Header.Get("If-Match") != "" && req.Header.Get("If-Match") != version) {
+				res.WriteHeader(http.StatusPreconditionFailed)
+				return
+			}
+			body, _ := ioutil.ReadAll(req.Body)
+			objects[req.URL.Path] = string(body)
+			versions[req.URL.Path]++
+		}
+	}))
+	defer server.Close()
+
+	client := objstore.New(server.URL, "bucket", "us-east-1", objstore.S3, "key", "secret", http.DefaultClient)
+	if _, err := client.Get("yoke/leader"); !objstore.IsNotFound(err) {
+		test.Logf("expected the object to be missing (%v)", err)
+		test.FailNow()
+	}
+	if err := client.Put("yoke/leader", []byte("a"), ""); err != nil {
+		test.Log(err)
+		test.FailNow()
+	}
+	if err := client.Put("yoke/leader", []byte("b"), ""); !objstore.IsConflict(err) {
+		test.Logf("an object that exists was created again (%v)", err)
+		test.Fail()
+	}
+
+	object, err := client.Get("yoke/leader")
+	if err != nil || string(object.Body) != "a" {
+		test.Logf("wrong object '%s' (%v)", object.Body, err)
+		test.FailNow()
+	}
+	if err := client.Put("yoke/leader", []byte("c"), object.Version); err != nil {
+		test.Log(err)
+		test.FailNow()
+	}
+	// the version that was read is gone now
+	if err := client.Put("yoke/leader", []byte("d"), object.Version); !objstore.IsConflict(err) {
+		test.Logf("an object that changed was replaced (%v)", err)
+		test.Fail()
+	}
+	if objects["/bucket/yoke/leader"] != "c" {
+		test.Logf("wrong object was stored '%v'", objects["/bucket/yoke/leader"])
+		test.Fail()
+	}
+}
@@ -0,0 +1,197 @@
+// Copyright (c) 2015 Pagoda Box Inc
+//
+// This Source Code Form is subject to the terms of the Mozilla Public License, v.
+// 2.0. If a copy of the MPL was not distributed with this file, You can obtain one
+// at http://mozilla.org/MPL/2.0/.
+//
+
+// pgbouncer holds the clients of a pgbouncer while a node takes over, so that
+// they see a short pause instead of connection errors. pgbouncer is paused before
+// the node becomes writable, pointed at the node once it is, and resumed.
+package pgbouncer
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	_ "github.com/lib/pq"
+	"github.com/nanopack/yoke/config"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Bouncer is run around every transition of the performer, see
+// monitor.RegisterHook
+type Bouncer struct {
+	sync.Mutex
+	admin    string // how to connect to the admin console
+	database string // the database that is paused and pointed at the node, all of them when empty
+	file     string // the config file with the [databases] section, nothing is rewritten when empty
+	host     string
+	port     int
+	timeout  time.Duration
+	log      config.Logger
+	paused   bool
+}
+
+var (
+	hostField = regexp.MustCompile(`\bhost=\S*`)
+	portField = regexp.MustCompile(`\bport=\S*`)
+)
+
+// New creates the bouncer from the [pgbouncer] section of the config, it is nil
+// when no admin host is set
+func New(conf config.Config) *Bouncer {
+	if conf.PgbouncerHost == "" {
+		return nil
+	}
+	admin := fmt.Sprintf("host=%s port=%d user=%s dbname=pgbouncer sslmode=disable connect_timeout=%d",
+		conf.PgbouncerHost, conf.PgbouncerPort, conf.PgbouncerUser, conf.PgbouncerTimeout)
+	if conf.PgbouncerPassword != "" {
+		admin += fmt.Sprintf(" password='%s'", strings.NewReplacer(`\`, `\\`, `'`, `\'`).Replace(conf.PgbouncerPassword))
+	}
+	return &Bouncer{
+		admin:    admin,
+		database: conf.PgbouncerDatabase,
+		file:     conf.PgbouncerConfig,
+		host:     conf.AdvertiseIp,
+		port:     conf.DatabasePort(),
+		timeout:  time.Duration(conf.PgbouncerTimeout) * time.Second,
+		log:      conf.Logging(),
+	}
+}
+
+// Before pauses pgbouncer when this node is about to become writable. A pgbouncer
+// that can't be paused doesn't hold the transition up.
+func (bouncer *Bouncer) Before(transition, from string) error {
+	if transition != "active" && transition != "single" {
+		return nil
+	}
+	bouncer.Lock()
+	defer bouncer.Unlock()
+
+	// PAUSE waits for the queries in flight to finish
+	bouncer.log.Info("[pgbouncer] pausing")
+	if err := bouncer.command("PAUSE"); err != nil {
+		bouncer.log.Error("[pgbouncer] could not pause, clients may see errors (%v)", err)
+		// a pause that timed out is still going on, it is called off
+		bouncer.command("RESUME")
+		return nil
+	}
+	bouncer.paused = true
+	return nil
+}
+
+// After points pgbouncer at this node once it is writable, and resumes it whether
+// the transition worked or not
+func (bouncer *Bouncer) After(transition, from string, err error) {
+	if transition != "active" && transition != "single" {
+		return
+	}
+	bouncer.Lock()
+	defer bouncer.Unlock()
+
+	if err == nil && bouncer.file != "" {
+		bouncer.log.Info("[pgbouncer] pointing '%v' at %v:%v", bouncer.file, bouncer.host, bouncer.port)
+		if err := bouncer.rewrite(); err != nil {
+			bouncer.log.Error("[pgbouncer] could not rewrite '%v' (%v)", bouncer.file, err)
+		} else if err := bouncer.command("RELOAD"); err != nil {
+			bouncer.log.Error("[pgbouncer] could not reload (%v)", err)
+		}
+	}
+	if bouncer.paused {
+		bouncer.log.Info("[pgbouncer] resuming")
+		if err := bouncer.command("RESUME"); err != nil {
+			bouncer.log.Error("[pgbouncer] could not resume (%v)", err)
+		}
+		bouncer.paused = false
+	}
+}
+
+// runs a command on the admin console, on the database when one was set
+func (bouncer *Bouncer) command(command string) error {
+	if bouncer.database != "" && command != "RELOAD" {
+		command += " " + bouncer.database
+	}
+	db, err := sql.Open("postgres", bouncer.admin)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	ctx := context.Background()
+	if bouncer.timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, bouncer.timeout)
+		defer cancel()
+	}
+	// the admin console only takes simple queries, which is what an exec without
+	// arguments sends
+	_, err = db.ExecContext(ctx, command)
+	return err
+}
+
+// points the entries of the [databases] section at this node, the file is
+// replaced at once so pgbouncer never reads half of it
+func (bouncer *Bouncer) rewrite() error {
+	contents, err := ioutil.ReadFile(bouncer.file)
+	if err != nil {
+		return err
+	}
+	lines := strings.Split(string(contents), "\n")
+	section := ""
+	for i, line := range lines {
+		trimmed := strings.TrimSpace(line)
+		if strings.HasPrefix(trimmed, "[") {
+			section = strings.Trim(trimmed, "[] ")
+			continue
+		}
+		if section != "databases" || trimmed == "" || strings.HasPrefix(trimmed, ";") || strings.HasPrefix(trimmed, "#") {
+			continue
+		}
+		split := strings.SplitN(line, "=", 2)
+		if len(split) != 2 || (bouncer.database != "" && strings.TrimSpace(split[0]) != bouncer.database) {
+			continue
+		}
+		lines[i] = split[0] + "=" + bouncer.point(split[1])
+	}
+
+	temp, err := ioutil.TempFile(filepath.Dir(bouncer.file), ".pgbouncer")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(temp.Name())
+	if info, err := os.Stat(bouncer.file); err == nil {
+		temp.Chmod(info.Mode())
+	}
+	if _, err := temp.WriteString(strings.Join(lines, "\n")); err != nil {
+		temp.Close()
+		return err
+	}
+	if err := temp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(temp.Name(), bouncer.file)
+}
+
+// replaces the host and port of a connection string, adding them when it has none
+func (bouncer *Bouncer) point(connection string) string {
+	host := fmt.Sprintf("host=%v", bouncer.host)
+	port := fmt.Sprintf("port=%v", bouncer.port)
+	if hostField.MatchString(connection) {
+		connection = hostField.ReplaceAllString(connection, host)
+	} else {
+		connection = strings.TrimRight(connection, " ") + " " + host
+	}
+	if portField.MatchString(connection) {
+		connection = portField.ReplaceAllString(connection, port)
+	} else {
+		connection = strings.TrimRight(connection, " ") + " " + port
+	}
+	return connection
+}
@@ -0,0 +1,87 @@
+// Copyright (c) 2015 Pagoda Box Inc
+//
+// This Source Code Form is subject to the terms of the Mozilla Public License, v.
+// 2.0. If a copy of the MPL was not distributed with this file, You can obtain one
+// at http://mozilla.org/MPL/2.0/.
+//
+
+package pgbouncer_test
+
+import (
+	"github.com/nanopack/yoke/config"
+	"github.com/nanopack/yoke/pgbouncer"
+	"io/ioutil"
+	"net"
+	"os"
+	"testing"
+)
+
+const databases = `[databases]
+; the application
+app = host=10.0.0.1 port=5432 dbname=app
+reports = dbname=reports
+
+[pgbouncer]
+listen_port = 6432
+`
+
+func TestRewrite(test *testing.T) {
+	file, err := ioutil.TempFile("", "pgbouncer")
+	if err != nil {
+		test.Log(err)
+		test.FailNow()
+	}
+	file.WriteString(databases)
+	file.Close()
+	defer os.Remove(file.Name())
+
+	// nothing listens for the admin console, pgbouncer can't be paused but the
+	// node still takes over
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		test.Log(err)
+		test.FailNow()
+	}
+	port := listener.Addr().(*net.TCPAddr).Port
+	listener.Close()
+
+	bouncer := pgbouncer.New(config.Config{
+		PgbouncerHost:    "127.0.0.1",
+		PgbouncerPort:    port,
+		PgbouncerUser:    "pgbouncer",
+		PgbouncerConfig:  file.Name(),
+		PgbouncerTimeout: 1,
+		AdvertiseIp:      "10.0.0.2",
+		PGPort:           5433,
+	})
+	if err := bouncer.Before("single", "backup"); err != nil {
+		test.Logf("a pgbouncer that can't be paused should not hold the transition up (%v)", err)
+		test.Fail()
+	}
+	bouncer.After("single", "backup", nil)
+
+	out, err := ioutil.ReadFile(file.Name())
+	if err != nil {
+		test.Log(err)
+		test.FailNow()
+	}
+	expected := `[databases]
+; the application
+app = host=10.0.0.2 port=5433 dbname=app
+reports = dbname=reports host=10.0.0.2 port=5433
+
+[pgbouncer]
+listen_port = 6432
+`
+	if string(out) != expected {
+		test.Logf("wrong config file %q", out)
+		test.Fail()
+	}
+}
+
+func TestNew(test *testing.T) {
+	if bouncer := pgbouncer.New(config.Config{}); bouncer != nil {
+		test.Log("no admin host should create no bouncer")
+		test.Fail()
+	}
+}
@@ -0,0 +1,498 @@
+// Copyright (c) 2015 Pagoda Box Inc
+//
+// This Source Code Form is subject to the terms of the Mozilla Public License, v.
+// 2.0. If a copy of the MPL was not distributed with this file, You can obtain one
+// at http://mozilla.org/MPL/2.0/.
+//
+
+package plugin
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"github.com/nanopack/yoke/config"
+	"github.com/nanopack/yoke/events"
+	"github.com/nanopack/yoke/monitor"
+	"github.com/nanopack/yoke/plugin/pluginpb"
+	"github.com/nanopack/yoke/storage"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/status"
+	"io"
+	"net"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	// how long a plugin gets to print its address
+	startTimeout = 10 * time.Second
+	// how long a plugin gets to exit once its stdin is closed, before it is killed
+	stopTimeout = 5 * time.Second
+)
+
+type (
+	// Client is a plugin program the node started
+	Client struct {
+		plugin  config.Plugin
+		kinds   []string
+		cmd     *exec.Cmd
+		stdin   io.Closer
+		exited  chan struct{}
+		conn    *grpc.ClientConn
+		client  pluginpb.PluginClient
+		log     config.Logger
+		closing chan struct{}
+
+		// the storages that were opened, by destination and endpoint
+		storageLock sync.Mutex
+		storages    map[[2]string]uint64
+	}
+
+	remoteService struct {
+		*Client
+		id uint64
+	}
+
+	remoteStore struct {
+		*Client
+		id uint64
+	}
+
+	remoteStorage struct {
+		*Client
+		id uint64
+	}
+)
+
+// Load starts the program of every plugin of conf, and registers what it serves
+// under the name of the plugin. stop unregisters the notifiers and stops the
+// programs again.
+func Load(conf config.Config) (stop func(), err error) {
+	var clients []*Client
+	var unsubscribe []func()
+	stop = func() {
+		for _, done := range unsubscribe {
+			done()
+		}
+		for _, client := range clients {
+			client.Close()
+		}
+	}
+
+	for _, plugin := range conf.Plugins() {
+		client, err := Start(plugin, conf.Logging())
+		if err != nil {
+			stop()
+			return nil, fmt.Errorf("the plugin '%v' did not start (%v)", plugin.Name, err)
+		}
+		clients = append(clients, client)
+		done, err := client.Register(conf)
+		if err != nil {
+			stop()
+			return nil, fmt.Errorf("the plugin '%v' could not be registered (%v)", plugin.Name, err)
+		}
+		unsubscribe = append(unsubscribe, done)
+	}
+	return stop, nil
+}
+
+// Start starts the program of plugin, and connects to it once it is listening
+func Start(plugin config.Plugin, log config.Logger) (*Client, error) {
+	client := &Client{plugin: plugin, exited: make(chan struct{}), closing: make(chan struct{}), storages: map[[2]string]uint64{}}
+	client.log = log.With(config.Fields{"plugin": plugin.Name})
+	client.cmd = exec.Command(plugin.Path, plugin.Args...)
+	client.cmd.Env = append(os.Environ(), cookieKey+"="+cookieValue)
+	client.cmd.Stderr = monitor.NewPrefix("[plugin." + plugin.Name + "]")
+	stdin, err := client.cmd.StdinPipe()
+	if err != nil {
+		return nil, err
+	}
+	client.stdin = stdin
+	stdout, err := client.cmd.StdoutPipe()
+	if err != nil {
+		return nil, err
+	}
+	if err := client.cmd.Start(); err != nil {
+		return nil, err
+	}
+	go client.wait()
+
+	lines := bufio.NewReader(stdout)
+	handshake := make(chan string, 1)
+	go func() {
+		line, _ := lines.ReadString('\n')
+		handshake <- strings.TrimSpace(line)
+	}()
+
+	var line string
+	select {
+	case line = <-handshake:
+	case <-time.After(startTimeout):
+		client.Close()
+		return nil, fmt.Errorf("it did not print its address within %v", startTimeout)
+	}
+	// 'yoke-plugin|1|unix|/tmp/yoke-plugin123/plugin.sock|service,storage'
+	fields := strings.Split(line, "|")
+	if len(fields) != 5 || fields[0] != "yoke-plugin" {
+		client.Close()
+		return nil, fmt.Errorf("it printed '%v' instead of its address", line)
+	}
+	if version, err := strconv.Atoi(fields[1]); err != nil || version != Protocol {
+		client.Close()
+		return nil, fmt.Errorf("it speaks version '%v' of the plugin protocol, yoke speaks %v", fields[1], Protocol)
+	}
+	if fields[4] != "" {
+		client.kinds = strings.Split(fields[4], ",")
+	}
+	// the plugin logs to stdout from now on as well
+	go io.Copy(monitor.NewPrefix("[plugin."+plugin.Name+"]"), lines)
+
+	network := fields[2]
+	client.conn, err = grpc.Dial(fields[3],
+		grpc.WithTransportCredentials(insecure.NewCredentials()), // only the node can reach the socket
+		grpc.WithContextDialer(func(ctx context.Context, address string) (net.Conn, error) {
+			return (&net.Dialer{}).DialContext(ctx, network, address)
+		}))
+	if err != nil {
+		client.Close()
+		return nil, err
+	}
+	client.client = pluginpb.NewPluginClient(client.conn)
+	client.log.Info("[plugin] started '%v', it serves %v", plugin.Path, client.kinds)
+	return client, nil
+}
+
+// waits for the program to exit, the calls to a plugin that exited fail
+func (client *Client) wait() {
+	err := client.cmd.Wait()
+	select {
+	case <-client.closing:
+	default:
+		client.log.Error("[plugin] '%v' exited (%v)", client.plugin.Path, err)
+	}
+	close(client.exited)
+}
+
+// Close stops the program of the plugin
+func (client *Client) Close() error {
+	close(client.closing)
+	if client.conn != nil {
+		client.conn.Close()
+	}
+	client.stdin.Close()
+	select {
+	case <-client.exited:
+	case <-time.After(stopTimeout):
+		client.cmd.Process.Kill()
+		<-client.exited
+	}
+	return nil
+}
+
+// Kinds returns the kinds of plugins the program serves
+func (client *Client) Kinds() []string {
+	return client.kinds
+}
+
+// Register makes what the plugin serves available under its name. A notifier is
+// subscribed to the events right away, done unsubscribes it.
+func (client *Client) Register(conf config.Config) (done func(), err error) {
+	done = func() {}
+	name := client.plugin.Name
+	for _, kind := range client.kinds {
+		switch kind {
+		case Service:
+			monitor.RegisterService(name, func(conf config.Config) (monitor.Service, error) {
+				id, err := client.open(Service, conf, "", "")
+				return remoteService{client, id}, err
+			})
+		case Arbiter:
+			monitor.RegisterArbiter(name, func(conf config.Config) (monitor.Arbiter, error) {
+				id, err := client.open(Arbiter, conf, "", "")
+				if err != nil {
+					return nil, err
+				}
+				return monitor.NewStoreArbiter(remoteStore{client, id}, conf.Interval(), conf.Logging()), nil
+			})
+		case Storage:
+			storage.Register(name, func(destination, endpoint string) (storage.Driver, error) {
+				return client.storage(conf, destination, endpoint)
+			})
+		case Notifier:
+			id, err := client.open(Notifier, conf, "", "")
+			if err != nil {
+				return done, err
+			}
+			done = events.Subscribe(func(event events.Event) {
+				client.notify(id, event)
+			})
+		default:
+			client.log.Warn("[plugin] '%v' serves a %v, yoke does not know what that is", client.plugin.Path, kind)
+		}
+	}
+	return done, nil
+}
+
+// open opens the kind of plugin for the database of conf
+func (client *Client) open(kind string, conf config.Config, destination, endpoint string) (uint64, error) {
+	var id uint64
+	err := client.call(true, func(ctx context.Context, plugin pluginpb.PluginClient) error {
+		reply, err := plugin.Open(ctx, &pluginpb.OpenRequest{
+			Kind: kind,
+			Node: &pluginpb.Node{
+				Name:     client.plugin.Name,
+				Instance: conf.Instance,
+				Role:     conf.Role,
+				Location: conf.AdvertiseAddress(),
+				DataDir:  conf.DataDir,
+				Port:     int32(client.plugin.Port),
+				Options:  client.plugin.Options,
+			},
+			Destination: destination,
+			Endpoint:    endpoint,
+		})
+		id = reply.GetId()
+		return err
+	})
+	return id, err
+}
+
+// storage opens the storage of destination once, every archive command asks for it
+func (client *Client) storage(conf config.Config, destination, endpoint string) (storage.Driver, error) {
+	client.storageLock.Lock()
+	defer client.storageLock.Unlock()
+	key := [2]string{destination, endpoint}
+	if id, ok := client.storages[key]; ok {
+		return remoteStorage{client, id}, nil
+	}
+	id, err := client.open(Storage, conf, destination, endpoint)
+	if err != nil {
+		return nil, err
+	}
+	client.storages[key] = id
+	return remoteStorage{client, id}, nil
+}
+
+// an event that can not be passed on is only logged, the node goes on without it
+func (client *Client) notify(id uint64, event events.Event) {
+	err := client.call(true, func(ctx context.Context, plugin pluginpb.PluginClient) error {
+		_, err := plugin.Notify(ctx, &pluginpb.Event{
+			Id:         id,
+			Type:       string(event.Type),
+			TimeUnixNs: unixNano(event.Time),
+			Node:       event.Node,
+			Role:       event.Role,
+			DbRole:     event.DBRole,
+			Peer:       event.Peer,
+			Error:      event.Error,
+			Epoch:      event.Epoch,
+		})
+		return err
+	})
+	if err != nil {
+		client.log.Warn("[plugin] could not pass on the %v event (%v)", event.Type, err)
+	}
+}
+
+// call makes a call to the plugin, with the timeout of the plugin when timed. The
+// error the plugin returned is returned as it is.
+func (client *Client) call(timed bool, do func(context.Context, pluginpb.PluginClient) error) error {
+	ctx, cancel := context.Background(), func() {}
+	if timed {
+		ctx, cancel = context.WithTimeout(ctx, time.Duration(client.plugin.Timeout)*time.Second)
+	}
+	defer cancel()
+	if err := do(ctx, client.client); err != nil {
+		return errors.New(status.Convert(err).Message())
+	}
+	return nil
+}
+
+func (service remoteService) handle() *pluginpb.Handle {
+	return &pluginpb.Handle{Id: service.id}
+}
+
+func (service remoteService) Initialize() error {
+	return service.call(true, func(ctx context.Context, plugin pluginpb.PluginClient) error {
+		_, err := plugin.Initialize(ctx, service.handle())
+		return err
+	})
+}
+
+func (service remoteService) Start() error {
+	return service.call(true, func(ctx context.Context, plugin pluginpb.PluginClient) error {
+		_, err := plugin.Start(ctx, service.handle())
+		return err
+	})
+}
+
+func (service remoteService) Single() error {
+	return service.call(true, func(ctx context.Context, plugin pluginpb.PluginClient) error {
+		_, err := plugin.Single(ctx, service.handle())
+		return err
+	})
+}
+
+func (service remoteService) Active() error {
+	return service.call(true, func(ctx context.Context, plugin pluginpb.PluginClient) error {
+		_, err := plugin.Active(ctx, service.handle())
+		return err
+	})
+}
+
+func (service remoteService) Backup(peer string) error {
+	return service.call(true, func(ctx context.Context, plugin pluginpb.PluginClient) error {
+		_, err := plugin.Backup(ctx, &pluginpb.BackupRequest{Id: service.id, Peer: peer})
+		return err
+	})
+}
+
+func (service remoteService) Stop() error {
+	return service.call(true, func(ctx context.Context, plugin pluginpb.PluginClient) error {
+		_, err := plugin.Stop(ctx, service.handle())
+		return err
+	})
+}
+
+func (service remoteService) Position() (position uint64, err error) {
+	err = service.call(true, func(ctx context.Context, plugin pluginpb.PluginClient) error {
+		reply, err := plugin.Position(ctx, service.handle())
+		position = reply.GetPosition()
+		return err
+	})
+	return position, err
+}
+
+func (service remoteService) ReplayDelay() (delay time.Duration, err error) {
+	err = service.call(true, func(ctx context.Context, plugin pluginpb.PluginClient) error {
+		reply, err := plugin.ReplayDelay(ctx, service.handle())
+		delay = time.Duration(reply.GetNs())
+		return err
+	})
+	return delay, err
+}
+
+func (store remoteStore) Ready() error {
+	return store.call(true, func(ctx context.Context, plugin pluginpb.PluginClient) error {
+		_, err := plugin.Ready(ctx, &pluginpb.Handle{Id: store.id})
+		return err
+	})
+}
+
+func (store remoteStore) Record(location string) (record monitor.NodeRecord, err error) {
+	err = store.call(true, func(ctx context.Context, plugin pluginpb.PluginClient) error {
+		reply, err := plugin.Record(ctx, &pluginpb.NodeRequest{Id: store.id, Location: location})
+		record = fromRecord(reply)
+		return err
+	})
+	return record, err
+}
+
+func (store remoteStore) Report(location string, record monitor.NodeRecord) error {
+	return store.call(true, func(ctx context.Context, plugin pluginpb.PluginClient) error {
+		_, err := plugin.Report(ctx, &pluginpb.ReportRequest{Id: store.id, Location: location, Record: toRecord(record)})
+		return err
+	})
+}
+
+func (store remoteStore) Campaign(location string) (elected bool, err error) {
+	err = store.call(true, func(ctx context.Context, plugin pluginpb.PluginClient) error {
+		reply, err := plugin.Campaign(ctx, &pluginpb.NodeRequest{Id: store.id, Location: location})
+		elected = reply.GetElected()
+		return err
+	})
+	return elected, err
+}
+
+func (store remoteStore) Resign(location string) error {
+	return store.call(true, func(ctx context.Context, plugin pluginpb.PluginClient) error {
+		_, err := plugin.Resign(ctx, &pluginpb.NodeRequest{Id: store.id, Location: location})
+		return err
+	})
+}
+
+// Put returns the command of the plugin, or one that fails so that postgres keeps
+// the segment when the plugin can't be asked for it
+func (driver remoteStorage) Put(local, remote string) string {
+	var command string
+	err := driver.call(true, func(ctx context.Context, plugin pluginpb.PluginClient) error {
+		reply, err := plugin.Put(ctx, &pluginpb.Transfer{Id: driver.id, Local: local, Remote: remote})
+		command = reply.GetCommand()
+		return err
+	})
+	if err != nil {
+		driver.log.Error("[plugin] could not get the command to put '%v' (%v)", remote, err)
+		return "exit 1"
+	}
+	return command
+}
+
+func (driver remoteStorage) Get(remote, local string) string {
+	var command string
+	err := driver.call(true, func(ctx context.Context, plugin pluginpb.PluginClient) error {
+		reply, err := plugin.Get(ctx, &pluginpb.Transfer{Id: driver.id, Local: local, Remote: remote})
+		command = reply.GetCommand()
+		return err
+	})
+	if err != nil {
+		driver.log.Error("[plugin] could not get the command to get '%v' (%v)", remote, err)
+		return "exit 1"
+	}
+	return command
+}
+
+// Upload is not timed, a base backup takes as long as it takes
+func (driver remoteStorage) Upload(local, remote string) error {
+	return driver.call(false, func(ctx context.Context, plugin pluginpb.PluginClient) error {
+		_, err := plugin.Upload(ctx, &pluginpb.Transfer{Id: driver.id, Local: local, Remote: remote})
+		return err
+	})
+}
+
+func (driver remoteStorage) Download(remote, local string) error {
+	return driver.call(false, func(ctx context.Context, plugin pluginpb.PluginClient) error {
+		_, err := plugin.Download(ctx, &pluginpb.Transfer{Id: driver.id, Local: local, Remote: remote})
+		return err
+	})
+}
+
+func (driver remoteStorage) Read(remote string) (data []byte, err error) {
+	err = driver.call(true, func(ctx context.Context, plugin pluginpb.PluginClient) error {
+		reply, err := plugin.Read(ctx, &pluginpb.Transfer{Id: driver.id, Remote: remote})
+		data = reply.GetData()
+		return err
+	})
+	return data, err
+}
+
+func (driver remoteStorage) List(remote string) (entries []storage.Entry, err error) {
+	err = driver.call(true, func(ctx context.Context, plugin pluginpb.PluginClient) error {
+		reply, err := plugin.List(ctx, &pluginpb.Transfer{Id: driver.id, Remote: remote})
+		for _, entry := range reply.GetEntries() {
+			entries = append(entries, storage.Entry{Name: entry.GetName(), Dir: entry.GetDir(), Modified: fromUnixNano(entry.GetModifiedUnixNs())})
+		}
+		return err
+	})
+	return entries, err
+}
+
+func (driver remoteStorage) Remove(remote string) error {
+	return driver.call(true, func(ctx context.Context, plugin pluginpb.PluginClient) error {
+		_, err := plugin.Remove(ctx, &pluginpb.Transfer{Id: driver.id, Remote: remote})
+		return err
+	})
+}
+
+func (driver remoteStorage) RemoveAll(remote string, names []string) error {
+	return driver.call(true, func(ctx context.Context, plugin pluginpb.PluginClient) error {
+		_, err := plugin.RemoveAll(ctx, &pluginpb.Transfer{Id: driver.id, Remote: remote, Names: names})
+		return err
+	})
+}
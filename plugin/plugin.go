@@ -0,0 +1,436 @@
+// Copyright (c) 2015 Pagoda Box Inc
+//
+// This Source Code Form is subject to the terms of the Mozilla Public License, v.
+// 2.0. If a copy of the MPL was not distributed with this file, You can obtain one
+// at http://mozilla.org/MPL/2.0/.
+//
+
+// Package plugin extends a released yoke with services, arbiters, storages and
+// notifiers that are built out of tree. A plugin is a program of its own that the
+// node starts for every [plugin.<name>] section of the config. It listens on a
+// socket, and prints a single line to stdout once it does:
+//
+//	yoke-plugin|1|unix|/tmp/yoke-plugin123/plugin.sock|service,storage
+//
+// that is the protocol version, the network and the address it serves the Plugin
+// service of pluginpb on over grpc, and the kinds of plugins it serves. Everything
+// it writes afterwards ends up in the log of the node. The plugin exits when its
+// stdin is closed, the node closes it when it stops.
+//
+// What a plugin serves is registered under the name of its section, so that
+// 'database', 'arbiter' and the [archive] 'storage' can select it like the ones
+// yoke was built with. A Go plugin only has to call Serve:
+//
+//	func main() {
+//		plugin.Serve(plugin.Plugins{
+//			Service: func(node plugin.Node) (monitor.Service, error) {
+//				return newLDAP(node.Port, node.Options["suffix"]), nil
+//			},
+//		})
+//	}
+package plugin
+
+import (
+	"context"
+	"fmt"
+	"github.com/nanopack/yoke/events"
+	"github.com/nanopack/yoke/monitor"
+	"github.com/nanopack/yoke/plugin/pluginpb"
+	"github.com/nanopack/yoke/state"
+	"github.com/nanopack/yoke/storage"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"io"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// the version of the protocol between the node and its plugins
+const Protocol = 1
+
+// the kinds of plugins a plugin program can serve
+const (
+	Service  = "service"
+	Arbiter  = "arbiter"
+	Storage  = "storage"
+	Notifier = "notifier"
+)
+
+// a plugin is only served when the node started it, the variable tells a plugin
+// that was started by hand apart
+const (
+	cookieKey   = "YOKE_PLUGIN_COOKIE"
+	cookieValue = "c3a1f1a4e9d84d1e8f0b7a5b2d6e4c90"
+)
+
+type (
+	// Node is the node a plugin is opened for
+	Node struct {
+		Name     string // the name of the [plugin.<name>] section
+		Instance string // the [instance.<name>] it is opened for, empty for [config]
+		Role     string
+		Location string
+		DataDir  string
+		Port     int
+		Options  map[string]string // every option of the [plugin.<name>] section
+	}
+
+	// Plugins are the plugins a program serves, it does not serve the kinds that
+	// are left nil. Each is opened for every database of the node that uses it.
+	Plugins struct {
+		Service  func(node Node) (monitor.Service, error)
+		Arbiter  func(node Node) (monitor.RecordStore, error)
+		Storage  func(node Node, destination, endpoint string) (storage.Driver, error)
+		Notifier func(node Node) (events.Handler, error)
+	}
+
+	// serves the plugins to the node
+	server struct {
+		pluginpb.UnimplementedPluginServer
+		sync.Mutex
+		plugins Plugins
+		next    uint64
+		opened  map[uint64]interface{}
+	}
+)
+
+// kinds returns the kinds of plugins that are served
+func (plugins Plugins) kinds() []string {
+	kinds := []string{}
+	if plugins.Service != nil {
+		kinds = append(kinds, Service)
+	}
+	if plugins.Arbiter != nil {
+		kinds = append(kinds, Arbiter)
+	}
+	if plugins.Storage != nil {
+		kinds = append(kinds, Storage)
+	}
+	if plugins.Notifier != nil {
+		kinds = append(kinds, Notifier)
+	}
+	return kinds
+}
+
+// Serve serves plugins to the node that started the program, until the node stops.
+// A program that was not started by a node exits.
+func Serve(plugins Plugins) {
+	if os.Getenv(cookieKey) != cookieValue {
+		fmt.Fprintln(os.Stderr, "this is a yoke plugin, yoke starts it for a [plugin.<name>] section of its config")
+		os.Exit(1)
+	}
+	dir, err := ioutil.TempDir("", "yoke-plugin")
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "the plugin could not be served: %v\n", err)
+		os.Exit(1)
+	}
+	defer os.RemoveAll(dir)
+	socket := filepath.Join(dir, "plugin.sock")
+	listener, err := net.Listen("unix", socket)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "the plugin could not be served: %v\n", err)
+		os.Exit(1)
+	}
+
+	grpcServer := grpc.NewServer()
+	pluginpb.RegisterPluginServer(grpcServer, &server{plugins: plugins, opened: map[uint64]interface{}{}})
+	// the node is gone once it closed stdin
+	go func() {
+		io.Copy(ioutil.Discard, os.Stdin)
+		grpcServer.Stop()
+	}()
+	fmt.Printf("yoke-plugin|%d|unix|%s|%s\n", Protocol, socket, strings.Join(plugins.kinds(), ","))
+	grpcServer.Serve(listener)
+}
+
+func (server *server) Open(ctx context.Context, req *pluginpb.OpenRequest) (*pluginpb.Handle, error) {
+	pb := req.GetNode()
+	node := Node{
+		Name:     pb.GetName(),
+		Instance: pb.GetInstance(),
+		Role:     pb.GetRole(),
+		Location: pb.GetLocation(),
+		DataDir:  pb.GetDataDir(),
+		Port:     int(pb.GetPort()),
+		Options:  pb.GetOptions(),
+	}
+
+	var opened interface{}
+	var err error
+	switch {
+	case req.Kind == Service && server.plugins.Service != nil:
+		opened, err = server.plugins.Service(node)
+	case req.Kind == Arbiter && server.plugins.Arbiter != nil:
+		opened, err = server.plugins.Arbiter(node)
+	case req.Kind == Storage && server.plugins.Storage != nil:
+		opened, err = server.plugins.Storage(node, req.Destination, req.Endpoint)
+	case req.Kind == Notifier && server.plugins.Notifier != nil:
+		opened, err = server.plugins.Notifier(node)
+	default:
+		return nil, status.Errorf(codes.Unimplemented, "the plugin does not serve a %v", req.Kind)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	server.Lock()
+	defer server.Unlock()
+	server.next++
+	server.opened[server.next] = opened
+	return &pluginpb.Handle{Id: server.next}, nil
+}
+
+// lookup returns what was opened as id
+func (server *server) lookup(id uint64) interface{} {
+	server.Lock()
+	defer server.Unlock()
+	return server.opened[id]
+}
+
+func (server *server) service(id uint64) (monitor.Service, error) {
+	if service, ok := server.lookup(id).(monitor.Service); ok {
+		return service, nil
+	}
+	return nil, status.Errorf(codes.NotFound, "no service was opened as %d", id)
+}
+
+func (server *server) store(id uint64) (monitor.RecordStore, error) {
+	if store, ok := server.lookup(id).(monitor.RecordStore); ok {
+		return store, nil
+	}
+	return nil, status.Errorf(codes.NotFound, "no arbiter was opened as %d", id)
+}
+
+func (server *server) driver(id uint64) (storage.Driver, error) {
+	if driver, ok := server.lookup(id).(storage.Driver); ok {
+		return driver, nil
+	}
+	return nil, status.Errorf(codes.NotFound, "no storage was opened as %d", id)
+}
+
+// the service calls that take nothing and return nothing but an error
+func (server *server) transition(id uint64, transition func(monitor.Service) error) (*pluginpb.Empty, error) {
+	service, err := server.service(id)
+	if err != nil {
+		return nil, err
+	}
+	return &pluginpb.Empty{}, transition(service)
+}
+
+func (server *server) Initialize(ctx context.Context, req *pluginpb.Handle) (*pluginpb.Empty, error) {
+	return server.transition(req.Id, monitor.Service.Initialize)
+}
+
+func (server *server) Start(ctx context.Context, req *pluginpb.Handle) (*pluginpb.Empty, error) {
+	return server.transition(req.Id, monitor.Service.Start)
+}
+
+func (server *server) Single(ctx context.Context, req *pluginpb.Handle) (*pluginpb.Empty, error) {
+	return server.transition(req.Id, monitor.Service.Single)
+}
+
+func (server *server) Active(ctx context.Context, req *pluginpb.Handle) (*pluginpb.Empty, error) {
+	return server.transition(req.Id, monitor.Service.Active)
+}
+
+func (server *server) Stop(ctx context.Context, req *pluginpb.Handle) (*pluginpb.Empty, error) {
+	return server.transition(req.Id, monitor.Service.Stop)
+}
+
+func (server *server) Backup(ctx context.Context, req *pluginpb.BackupRequest) (*pluginpb.Empty, error) {
+	return server.transition(req.Id, func(service monitor.Service) error {
+		return service.Backup(req.Peer)
+	})
+}
+
+func (server *server) Position(ctx context.Context, req *pluginpb.Handle) (*pluginpb.Offset, error) {
+	service, err := server.service(req.Id)
+	if err != nil {
+		return nil, err
+	}
+	position, err := service.Position()
+	return &pluginpb.Offset{Position: position}, err
+}
+
+func (server *server) ReplayDelay(ctx context.Context, req *pluginpb.Handle) (*pluginpb.Duration, error) {
+	service, err := server.service(req.Id)
+	if err != nil {
+		return nil, err
+	}
+	delay, err := service.ReplayDelay()
+	return &pluginpb.Duration{Ns: int64(delay)}, err
+}
+
+func (server *server) Ready(ctx context.Context, req *pluginpb.Handle) (*pluginpb.Empty, error) {
+	store, err := server.store(req.Id)
+	if err != nil {
+		return nil, err
+	}
+	return &pluginpb.Empty{}, store.Ready()
+}
+
+func (server *server) Record(ctx context.Context, req *pluginpb.NodeRequest) (*pluginpb.NodeRecord, error) {
+	store, err := server.store(req.Id)
+	if err != nil {
+		return nil, err
+	}
+	record, err := store.Record(req.Location)
+	return toRecord(record), err
+}
+
+func (server *server) Report(ctx context.Context, req *pluginpb.ReportRequest) (*pluginpb.Empty, error) {
+	store, err := server.store(req.Id)
+	if err != nil {
+		return nil, err
+	}
+	return &pluginpb.Empty{}, store.Report(req.Location, fromRecord(req.Record))
+}
+
+func (server *server) Campaign(ctx context.Context, req *pluginpb.NodeRequest) (*pluginpb.Elected, error) {
+	store, err := server.store(req.Id)
+	if err != nil {
+		return nil, err
+	}
+	elected, err := store.Campaign(req.Location)
+	return &pluginpb.Elected{Elected: elected}, err
+}
+
+func (server *server) Resign(ctx context.Context, req *pluginpb.NodeRequest) (*pluginpb.Empty, error) {
+	store, err := server.store(req.Id)
+	if err != nil {
+		return nil, err
+	}
+	return &pluginpb.Empty{}, store.Resign(req.Location)
+}
+
+func (server *server) Put(ctx context.Context, req *pluginpb.Transfer) (*pluginpb.Command, error) {
+	driver, err := server.driver(req.Id)
+	if err != nil {
+		return nil, err
+	}
+	return &pluginpb.Command{Command: driver.Put(req.Local, req.Remote)}, nil
+}
+
+func (server *server) Get(ctx context.Context, req *pluginpb.Transfer) (*pluginpb.Command, error) {
+	driver, err := server.driver(req.Id)
+	if err != nil {
+		return nil, err
+	}
+	return &pluginpb.Command{Command: driver.Get(req.Remote, req.Local)}, nil
+}
+
+func (server *server) Upload(ctx context.Context, req *pluginpb.Transfer) (*pluginpb.Empty, error) {
+	driver, err := server.driver(req.Id)
+	if err != nil {
+		return nil, err
+	}
+	return &pluginpb.Empty{}, driver.Upload(req.Local, req.Remote)
+}
+
+func (server *server) Download(ctx context.Context, req *pluginpb.Transfer) (*pluginpb.Empty, error) {
+	driver, err := server.driver(req.Id)
+	if err != nil {
+		return nil, err
+	}
+	return &pluginpb.Empty{}, driver.Download(req.Remote, req.Local)
+}
+
+func (server *server) Read(ctx context.Context, req *pluginpb.Transfer) (*pluginpb.Content, error) {
+	driver, err := server.driver(req.Id)
+	if err != nil {
+		return nil, err
+	}
+	data, err := driver.Read(req.Remote)
+	return &pluginpb.Content{Data: data}, err
+}
+
+func (server *server) List(ctx context.Context, req *pluginpb.Transfer) (*pluginpb.Entries, error) {
+	driver, err := server.driver(req.Id)
+	if err != nil {
+		return nil, err
+	}
+	entries, err := driver.List(req.Remote)
+	reply := &pluginpb.Entries{}
+	for _, entry := range entries {
+		reply.Entries = append(reply.Entries, &pluginpb.Entry{Name: entry.Name, Dir: entry.Dir, ModifiedUnixNs: unixNano(entry.Modified)})
+	}
+	return reply, err
+}
+
+func (server *server) Remove(ctx context.Context, req *pluginpb.Transfer) (*pluginpb.Empty, error) {
+	driver, err := server.driver(req.Id)
+	if err != nil {
+		return nil, err
+	}
+	return &pluginpb.Empty{}, driver.Remove(req.Remote)
+}
+
+func (server *server) RemoveAll(ctx context.Context, req *pluginpb.Transfer) (*pluginpb.Empty, error) {
+	driver, err := server.driver(req.Id)
+	if err != nil {
+		return nil, err
+	}
+	return &pluginpb.Empty{}, driver.RemoveAll(req.Remote, req.Names)
+}
+
+func (server *server) Notify(ctx context.Context, req *pluginpb.Event) (*pluginpb.Empty, error) {
+	handler, ok := server.lookup(req.Id).(events.Handler)
+	if !ok {
+		return nil, status.Errorf(codes.NotFound, "no notifier was opened as %d", req.Id)
+	}
+	handler(events.Event{
+		Type:   events.Type(req.Type),
+		Time:   fromUnixNano(req.TimeUnixNs),
+		Node:   req.Node,
+		Role:   req.Role,
+		DBRole: req.DbRole,
+		Peer:   req.Peer,
+		Error:  req.Error,
+		Epoch:  req.Epoch,
+	})
+	return &pluginpb.Empty{}, nil
+}
+
+func toRecord(record monitor.NodeRecord) *pluginpb.NodeRecord {
+	return &pluginpb.NodeRecord{
+		Role:     record.Role,
+		DbRole:   record.DBRole,
+		Synced:   record.Synced,
+		Position: record.Position,
+		DataDir:  record.DataDir,
+		LagNs:    int64(record.Lag.Time),
+		LagBytes: record.Lag.Bytes,
+	}
+}
+
+func fromRecord(record *pluginpb.NodeRecord) monitor.NodeRecord {
+	return monitor.NodeRecord{
+		Role:     record.GetRole(),
+		DBRole:   record.GetDbRole(),
+		Synced:   record.GetSynced(),
+		Position: record.GetPosition(),
+		DataDir:  record.GetDataDir(),
+		Lag:      state.LagReport{Time: time.Duration(record.GetLagNs()), Bytes: record.GetLagBytes()},
+	}
+}
+
+// a zero time is sent as 0
+func unixNano(t time.Time) int64 {
+	if t.IsZero() {
+		return 0
+	}
+	return t.UnixNano()
+}
+
+func fromUnixNano(ns int64) time.Time {
+	if ns == 0 {
+		return time.Time{}
+	}
+	return time.Unix(0, ns)
+}
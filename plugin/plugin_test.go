@@ -0,0 +1,205 @@
+// Copyright (c) 2015 Pagoda Box Inc
+//
+// This Source Code Form is subject to the terms of the Mozilla Public License, v.
+// 2.0. If a copy of the MPL was not distributed with this file, You can obtain one
+// at http://mozilla.org/MPL/2.0/.
+//
+
+package plugin_test
+
+import (
+	"errors"
+	"github.com/nanopack/yoke/config"
+	"github.com/nanopack/yoke/events"
+	"github.com/nanopack/yoke/monitor"
+	"github.com/nanopack/yoke/plugin"
+	"github.com/nanopack/yoke/storage"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+type (
+	// a service that is as far along as the port it was opened for
+	fakeService struct {
+		port int
+	}
+
+	// a store that only knows the node it was told about
+	fakeStore struct {
+		records map[string]monitor.NodeRecord
+	}
+
+	// a storage that hands out echo commands
+	fakeStorage struct {
+		destination string
+	}
+)
+
+// the test binary is the plugin as well, when the test starts it as one
+func TestMain(m *testing.M) {
+	if os.Getenv("YOKE_PLUGIN_COOKIE") != "" {
+		plugin.Serve(plugin.Plugins{
+			Service: func(node plugin.Node) (monitor.Service, error) {
+				return fakeService{port: node.Port}, nil
+			},
+			Arbiter: func(node plugin.Node) (monitor.RecordStore, error) {
+				return &fakeStore{records: map[string]monitor.NodeRecord{}}, nil
+			},
+			Storage: func(node plugin.Node, destination, endpoint string) (storage.Driver, error) {
+				return fakeStorage{destination: destination + "/" + node.Options["bucket"]}, nil
+			},
+			// writes the type of every event to the events file
+			Notifier: func(node plugin.Node) (events.Handler, error) {
+				return func(event events.Event) {
+					file, err := os.OpenFile(node.Options["events"], os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+					if err != nil {
+						return
+					}
+					defer file.Close()
+					file.WriteString(string(event.Type) + "\n")
+				}, nil
+			},
+		})
+		os.Exit(0)
+	}
+	os.Exit(m.Run())
+}
+
+func TestPlugin(t *testing.T) {
+	executable, err := os.Executable()
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir, err := ioutil.TempDir("", "yoke-plugin-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	conf := config.Conf
+	conf.AdvertiseIp = "10.0.0.1"
+	conf.AdvertisePort = 4400
+	client, err := plugin.Start(config.Plugin{
+		Name:    "fake",
+		Path:    executable,
+		Port:    7,
+		Timeout: 5,
+		Options: map[string]string{"bucket": "wal", "events": filepath.Join(dir, "events")},
+	}, config.Log)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+
+	if kinds := client.Kinds(); !reflect.DeepEqual(kinds, []string{plugin.Service, plugin.Arbiter, plugin.Storage, plugin.Notifier}) {
+		t.Fatalf("the plugin serves %v", kinds)
+	}
+	unsubscribe, err := client.Register(conf)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	driver, err := storage.New("fake", "/archive", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if command := driver.Put("%p", "wal/%f"); command != "echo /archive/wal %p wal/%f" {
+		t.Errorf("the plugin put with '%v'", command)
+	}
+	if err := driver.Remove("base"); err == nil || !strings.Contains(err.Error(), "can not remove base") {
+		t.Errorf("the error of the plugin was not passed on: %v", err)
+	}
+	entries, err := driver.List("wal")
+	if err != nil || len(entries) != 1 || entries[0].Name != "000000010000000000000001" || entries[0].Modified.Unix() != 1000 {
+		t.Errorf("the plugin listed %v %v", entries, err)
+	}
+
+	conf.Arbiter = "fake"
+	arbiter, err := monitor.NewArbiter(conf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	arbiter.Ready()
+	if role, err := arbiter.Bounce("10.0.0.2:4400").GetDBRole(); err != nil || role != "dead" {
+		t.Errorf("a node without a record is '%v' %v", role, err)
+	}
+
+	// unsubscribing waits for the events that were published
+	events.Publish(events.Event{Type: events.Stopped})
+	unsubscribe()
+	if notified, err := ioutil.ReadFile(filepath.Join(dir, "events")); err != nil || string(notified) != "stopped\n" {
+		t.Errorf("the plugin was notified of '%s' %v", notified, err)
+	}
+}
+
+func (service fakeService) Initialize() error        { return nil }
+func (service fakeService) Start() error             { return nil }
+func (service fakeService) Single() error            { return nil }
+func (service fakeService) Active() error            { return nil }
+func (service fakeService) Backup(peer string) error { return nil }
+func (service fakeService) Stop() error              { return nil }
+
+func (service fakeService) Position() (uint64, error) {
+	return uint64(service.port), nil
+}
+
+func (service fakeService) ReplayDelay() (time.Duration, error) {
+	return 0, nil
+}
+
+func (store *fakeStore) Ready() error {
+	return nil
+}
+
+func (store *fakeStore) Record(location string) (monitor.NodeRecord, error) {
+	return store.records[location], nil
+}
+
+func (store *fakeStore) Report(location string, record monitor.NodeRecord) error {
+	store.records[location] = record
+	return nil
+}
+
+func (store *fakeStore) Campaign(location string) (bool, error) {
+	return true, nil
+}
+
+func (store *fakeStore) Resign(location string) error {
+	return nil
+}
+
+func (driver fakeStorage) Put(local, remote string) string {
+	return "echo " + driver.destination + " " + local + " " + remote
+}
+
+func (driver fakeStorage) Get(remote, local string) string {
+	return "echo " + driver.destination + " " + remote + " " + local
+}
+
+func (driver fakeStorage) Upload(local, remote string) error {
+	return nil
+}
+
+func (driver fakeStorage) Download(remote, local string) error {
+	return nil
+}
+
+func (driver fakeStorage) Read(remote string) ([]byte, error) {
+	return nil, nil
+}
+
+func (driver fakeStorage) List(remote string) ([]storage.Entry, error) {
+	return []storage.Entry{{Name: "000000010000000000000001", Modified: time.Unix(1000, 0)}}, nil
+}
+
+func (driver fakeStorage) Remove(remote string) error {
+	return errors.New("can not remove " + remote)
+}
+
+func (driver fakeStorage) RemoveAll(remote string, names []string) error {
+	return nil
+}
@@ -0,0 +1,949 @@
+// Copyright (c) 2015 Pagoda Box Inc
+//
+// This Source Code Form is subject to the terms of the Mozilla Public License, v.
+// 2.0. If a copy of the MPL was not distributed with this file, You can obtain one
+// at http://mozilla.org/MPL/2.0/.
+//
+
+// The protocol between a node and the plugins it starts. A plugin is a program of
+// its own, in any language, that serves the Plugin service on the address it
+// prints when it starts (see the plugin package). What a plugin serves is opened
+// for a node first, the calls after that name what was opened by its handle.
+
+// Code generated by protoc-gen-go-grpc. DO NOT EDIT.
+// versions:
+// - protoc-gen-go-grpc v1.3.0
+// - protoc             (unknown)
+// source: plugin.proto
+
+package pluginpb
+
+import (
+	context "context"
+	grpc "google.golang.org/grpc"
+	codes "google.golang.org/grpc/codes"
+	status "google.golang.org/grpc/status"
+)
+
+// This is a compile-time assertion to ensure that this generated file
+// is compatible with the grpc package it is being compiled against.
+// Requires gRPC-Go v1.32.0 or later.
+const _ = grpc.SupportPackageIsVersion7
+
+const (
+	Plugin_Open_FullMethodName        = "/yoke.plugin.Plugin/Open"
+	Plugin_Initialize_FullMethodName  = "/yoke.plugin.Plugin/Initialize"
+	Plugin_Start_FullMethodName       = "/yoke.plugin.Plugin/Start"
+	Plugin_Single_FullMethodName      = "/yoke.plugin.Plugin/Single"
+	Plugin_Active_FullMethodName      = "/yoke.plugin.Plugin/Active"
+	Plugin_Backup_FullMethodName      = "/yoke.plugin.Plugin/Backup"
+	Plugin_Stop_FullMethodName        = "/yoke.plugin.Plugin/Stop"
+	Plugin_Position_FullMethodName    = "/yoke.plugin.Plugin/Position"
+	Plugin_ReplayDelay_FullMethodName = "/yoke.plugin.Plugin/ReplayDelay"
+	Plugin_Ready_FullMethodName       = "/yoke.plugin.Plugin/Ready"
+	Plugin_Record_FullMethodName      = "/yoke.plugin.Plugin/Record"
+	Plugin_Report_FullMethodName      = "/yoke.plugin.Plugin/Report"
+	Plugin_Campaign_FullMethodName    = "/yoke.plugin.Plugin/Campaign"
+	Plugin_Resign_FullMethodName      = "/yoke.plugin.Plugin/Resign"
+	Plugin_Put_FullMethodName         = "/yoke.plugin.Plugin/Put"
+	Plugin_Get_FullMethodName         = "/yoke.plugin.Plugin/Get"
+	Plugin_Upload_FullMethodName      = "/yoke.plugin.Plugin/Upload"
+	Plugin_Download_FullMethodName    = "/yoke.plugin.Plugin/Download"
+	Plugin_Read_FullMethodName        = "/yoke.plugin.Plugin/Read"
+	Plugin_List_FullMethodName        = "/yoke.plugin.Plugin/List"
+	Plugin_Remove_FullMethodName      = "/yoke.plugin.Plugin/Remove"
+	Plugin_RemoveAll_FullMethodName   = "/yoke.plugin.Plugin/RemoveAll"
+	Plugin_Notify_FullMethodName      = "/yoke.plugin.Plugin/Notify"
+)
+
+// PluginClient is the client API for Plugin service.
+//
+// For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
+type PluginClient interface {
+	// opens a service, an arbiter, a storage or a notifier for the node
+	Open(ctx context.Context, in *OpenRequest, opts ...grpc.CallOption) (*Handle, error)
+	// the service the node moves between roles, see monitor.Service
+	Initialize(ctx context.Context, in *Handle, opts ...grpc.CallOption) (*Empty, error)
+	Start(ctx context.Context, in *Handle, opts ...grpc.CallOption) (*Empty, error)
+	Single(ctx context.Context, in *Handle, opts ...grpc.CallOption) (*Empty, error)
+	Active(ctx context.Context, in *Handle, opts ...grpc.CallOption) (*Empty, error)
+	// returns once the service follows the peer and is synced
+	Backup(ctx context.Context, in *BackupRequest, opts ...grpc.CallOption) (*Empty, error)
+	Stop(ctx context.Context, in *Handle, opts ...grpc.CallOption) (*Empty, error)
+	Position(ctx context.Context, in *Handle, opts ...grpc.CallOption) (*Offset, error)
+	ReplayDelay(ctx context.Context, in *Handle, opts ...grpc.CallOption) (*Duration, error)
+	// the records of the nodes an arbiter keeps, see monitor.RecordStore
+	Ready(ctx context.Context, in *Handle, opts ...grpc.CallOption) (*Empty, error)
+	// a node without a record gets an empty one
+	Record(ctx context.Context, in *NodeRequest, opts ...grpc.CallOption) (*NodeRecord, error)
+	Report(ctx context.Context, in *ReportRequest, opts ...grpc.CallOption) (*Empty, error)
+	Campaign(ctx context.Context, in *NodeRequest, opts ...grpc.CallOption) (*Elected, error)
+	Resign(ctx context.Context, in *NodeRequest, opts ...grpc.CallOption) (*Empty, error)
+	// the storage of the archive, see storage.Driver
+	Put(ctx context.Context, in *Transfer, opts ...grpc.CallOption) (*Command, error)
+	Get(ctx context.Context, in *Transfer, opts ...grpc.CallOption) (*Command, error)
+	Upload(ctx context.Context, in *Transfer, opts ...grpc.CallOption) (*Empty, error)
+	Download(ctx context.Context, in *Transfer, opts ...grpc.CallOption) (*Empty, error)
+	Read(ctx context.Context, in *Transfer, opts ...grpc.CallOption) (*Content, error)
+	List(ctx context.Context, in *Transfer, opts ...grpc.CallOption) (*Entries, error)
+	Remove(ctx context.Context, in *Transfer, opts ...grpc.CallOption) (*Empty, error)
+	RemoveAll(ctx context.Context, in *Transfer, opts ...grpc.CallOption) (*Empty, error)
+	// every event of the node, in order, see events.Event
+	Notify(ctx context.Context, in *Event, opts ...grpc.CallOption) (*Empty, error)
+}
+
+type pluginClient struct {
+	cc grpc.ClientConnInterface
+}
+
+func NewPluginClient(cc grpc.ClientConnInterface) PluginClient {
+	return &pluginClient{cc}
+}
+
+func (c *pluginClient) Open(ctx context.Context, in *OpenRequest, opts ...grpc.CallOption) (*Handle, error) {
+	out := new(Handle)
+	err := c.cc.Invoke(ctx, Plugin_Open_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *pluginClient) Initialize(ctx context.Context, in *Handle, opts ...grpc.CallOption) (*Empty, error) {
+	out := new(Empty)
+	err := c.cc.Invoke(ctx, Plugin_Initialize_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *pluginClient) Start(ctx context.Context, in *Handle, opts ...grpc.CallOption) (*Empty, error) {
+	out := new(Empty)
+	err := c.cc.Invoke(ctx, Plugin_Start_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *pluginClient) Single(ctx context.Context, in *Handle, opts ...grpc.CallOption) (*Empty, error) {
+	out := new(Empty)
+	err := c.cc.Invoke(ctx, Plugin_Single_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *pluginClient) Active(ctx context.Context, in *Handle, opts ...grpc.CallOption) (*Empty, error) {
+	out := new(Empty)
+	err := c.cc.Invoke(ctx, Plugin_Active_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *pluginClient) Backup(ctx context.Context, in *BackupRequest, opts ...grpc.CallOption) (*Empty, error) {
+	out := new(Empty)
+	err := c.cc.Invoke(ctx, Plugin_Backup_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *pluginClient) Stop(ctx context.Context, in *Handle, opts ...grpc.CallOption) (*Empty, error) {
+	out := new(Empty)
+	err := c.cc.Invoke(ctx, Plugin_Stop_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *pluginClient) Position(ctx context.Context, in *Handle, opts ...grpc.CallOption) (*Offset, error) {
+	out := new(Offset)
+	err := c.cc.Invoke(ctx, Plugin_Position_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *pluginClient) ReplayDelay(ctx context.Context, in *Handle, opts ...grpc.CallOption) (*Duration, error) {
+	out := new(Duration)
+	err := c.cc.Invoke(ctx, Plugin_ReplayDelay_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *pluginClient) Ready(ctx context.Context, in *Handle, opts ...grpc.CallOption) (*Empty, error) {
+	out := new(Empty)
+	err := c.cc.Invoke(ctx, Plugin_Ready_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *pluginClient) Record(ctx context.Context, in *NodeRequest, opts ...grpc.CallOption) (*NodeRecord, error) {
+	out := new(NodeRecord)
+	err := c.cc.Invoke(ctx, Plugin_Record_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *pluginClient) Report(ctx context.Context, in *ReportRequest, opts ...grpc.CallOption) (*Empty, error) {
+	out := new(Empty)
+	err := c.cc.Invoke(ctx, Plugin_Report_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *pluginClient) Campaign(ctx context.Context, in *NodeRequest, opts ...grpc.CallOption) (*Elected, error) {
+	out := new(Elected)
+	err := c.cc.Invoke(ctx, Plugin_Campaign_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *pluginClient) Resign(ctx context.Context, in *NodeRequest, opts ...grpc.CallOption) (*Empty, error) {
+	out := new(Empty)
+	err := c.cc.Invoke(ctx, Plugin_Resign_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *pluginClient) Put(ctx context.Context, in *Transfer, opts ...grpc.CallOption) (*Command, error) {
+	out := new(Command)
+	err := c.cc.Invoke(ctx, Plugin_Put_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *pluginClient) Get(ctx context.Context, in *Transfer, opts ...grpc.CallOption) (*Command, error) {
+	out := new(Command)
+	err := c.cc.Invoke(ctx, Plugin_Get_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *pluginClient) Upload(ctx context.Context, in *Transfer, opts ...grpc.CallOption) (*Empty, error) {
+	out := new(Empty)
+	err := c.cc.Invoke(ctx, Plugin_Upload_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *pluginClient) Download(ctx context.Context, in *Transfer, opts ...grpc.CallOption) (*Empty, error) {
+	out := new(Empty)
+	err := c.cc.Invoke(ctx, Plugin_Download_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *pluginClient) Read(ctx context.Context, in *Transfer, opts ...grpc.CallOption) (*Content, error) {
+	out := new(Content)
+	err := c.cc.Invoke(ctx, Plugin_Read_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *pluginClient) List(ctx context.Context, in *Transfer, opts ...grpc.CallOption) (*Entries, error) {
+	out := new(Entries)
+	err := c.cc.Invoke(ctx, Plugin_List_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *pluginClient) Remove(ctx context.Context, in *Transfer, opts ...grpc.CallOption) (*Empty, error) {
+	out := new(Empty)
+	err := c.cc.Invoke(ctx, Plugin_Remove_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *pluginClient) RemoveAll(ctx context.Context, in *Transfer, opts ...grpc.CallOption) (*Empty, error) {
+	out := new(Empty)
+	err := c.cc.Invoke(ctx, Plugin_RemoveAll_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *pluginClient) Notify(ctx context.Context, in *Event, opts ...grpc.CallOption) (*Empty, error) {
+	out := new(Empty)
+	err := c.cc.Invoke(ctx, Plugin_Notify_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// PluginServer is the server API for Plugin service.
+// All implementations must embed UnimplementedPluginServer
+// for forward compatibility
+type PluginServer interface {
+	// opens a service, an arbiter, a storage or a notifier for the node
+	Open(context.Context, *OpenRequest) (*Handle, error)
+	// the service the node moves between roles, see monitor.Service
+	Initialize(context.Context, *Handle) (*Empty, error)
+	Start(context.Context, *Handle) (*Empty, error)
+	Single(context.Context, *Handle) (*Empty, error)
+	Active(context.Context, *Handle) (*Empty, error)
+	// returns once the service follows the peer and is synced
+	Backup(context.Context, *BackupRequest) (*Empty, error)
+	Stop(context.Context, *Handle) (*Empty, error)
+	Position(context.Context, *Handle) (*Offset, error)
+	ReplayDelay(context.Context, *Handle) (*Duration, error)
+	// the records of the nodes an arbiter keeps, see monitor.RecordStore
+	Ready(context.Context, *Handle) (*Empty, error)
+	// a node without a record gets an empty one
+	Record(context.Context, *NodeRequest) (*NodeRecord, error)
+	Report(context.Context, *ReportRequest) (*Empty, error)
+	Campaign(context.Context, *NodeRequest) (*Elected, error)
+	Resign(context.Context, *NodeRequest) (*Empty, error)
+	// the storage of the archive, see storage.Driver
+	Put(context.Context, *Transfer) (*Command, error)
+	Get(context.Context, *Transfer) (*Command, error)
+	Upload(context.Context, *Transfer) (*Empty, error)
+	Download(context.Context, *Transfer) (*Empty, error)
+	Read(context.Context, *Transfer) (*Content, error)
+	List(context.Context, *Transfer) (*Entries, error)
+	Remove(context.Context, *Transfer) (*Empty, error)
+	RemoveAll(context.Context, *Transfer) (*Empty, error)
+	// every event of the node, in order, see events.Event
+	Notify(context.Context, *Event) (*Empty, error)
+	mustEmbedUnimplementedPluginServer()
+}
+
+// UnimplementedPluginServer must be embedded to have forward compatible implementations.
+type UnimplementedPluginServer struct {
+}
+
+func (UnimplementedPluginServer) Open(context.Context, *OpenRequest) (*Handle, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Open not implemented")
+}
+func (UnimplementedPluginServer) Initialize(context.Context, *Handle) (*Empty, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Initialize not implemented")
+}
+func (UnimplementedPluginServer) Start(context.Context, *Handle) (*Empty, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Start not implemented")
+}
+func (UnimplementedPluginServer) Single(context.Context, *Handle) (*Empty, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Single not implemented")
+}
+func (UnimplementedPluginServer) Active(context.Context, *Handle) (*Empty, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Active not implemented")
+}
+func (UnimplementedPluginServer) Backup(context.Context, *BackupRequest) (*Empty, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Backup not implemented")
+}
+func (UnimplementedPluginServer) Stop(context.Context, *Handle) (*Empty, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Stop not implemented")
+}
+func (UnimplementedPluginServer) Position(context.Context, *Handle) (*Offset, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Position not implemented")
+}
+func (UnimplementedPluginServer) ReplayDelay(context.Context, *Handle) (*Duration, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method ReplayDelay not implemented")
+}
+func (UnimplementedPluginServer) Ready(context.Context, *Handle) (*Empty, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Ready not implemented")
+}
+func (UnimplementedPluginServer) Record(context.Context, *NodeRequest) (*NodeRecord, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Record not implemented")
+}
+func (UnimplementedPluginServer) Report(context.Context, *ReportRequest) (*Empty, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Report not implemented")
+}
+func (UnimplementedPluginServer) Campaign(context.Context, *NodeRequest) (*Elected, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Campaign not implemented")
+}
+func (UnimplementedPluginServer) Resign(context.Context, *NodeRequest) (*Empty, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Resign not implemented")
+}
+func (UnimplementedPluginServer) Put(context.Context, *Transfer) (*Command, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Put not implemented")
+}
+func (UnimplementedPluginServer) Get(context.Context, *Transfer) (*Command, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Get not implemented")
+}
+func (UnimplementedPluginServer) Upload(context.Context, *Transfer) (*Empty, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Upload not implemented")
+}
+func (UnimplementedPluginServer) Download(context.Context, *Transfer) (*Empty, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Download not implemented")
+}
+func (UnimplementedPluginServer) Read(context.Context, *Transfer) (*Content, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Read not implemented")
+}
+func (UnimplementedPluginServer) List(context.Context, *Transfer) (*Entries, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method List not implemented")
+}
+func (UnimplementedPluginServer) Remove(context.Context, *Transfer) (*Empty, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Remove not implemented")
+}
+func (UnimplementedPluginServer) RemoveAll(context.Context, *Transfer) (*Empty, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method RemoveAll not implemented")
+}
+func (UnimplementedPluginServer) Notify(context.Context, *Event) (*Empty, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Notify not implemented")
+}
+func (UnimplementedPluginServer) mustEmbedUnimplementedPluginServer() {}
+
+// UnsafePluginServer may be embedded to opt out of forward compatibility for this service.
+// Use of this interface is not recommended, as added methods to PluginServer will
+// result in compilation errors.
+type UnsafePluginServer interface {
+	mustEmbedUnimplementedPluginServer()
+}
+
+func RegisterPluginServer(s grpc.ServiceRegistrar, srv PluginServer) {
+	s.RegisterService(&Plugin_ServiceDesc, srv)
+}
+
+func _Plugin_Open_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(OpenRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(PluginServer).Open(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Plugin_Open_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(PluginServer).Open(ctx, req.(*OpenRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Plugin_Initialize_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(Handle)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(PluginServer).Initialize(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Plugin_Initialize_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(PluginServer).Initialize(ctx, req.(*Handle))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Plugin_Start_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(Handle)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(PluginServer).Start(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Plugin_Start_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(PluginServer).Start(ctx, req.(*Handle))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Plugin_Single_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(Handle)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(PluginServer).Single(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Plugin_Single_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(PluginServer).Single(ctx, req.(*Handle))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Plugin_Active_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(Handle)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(PluginServer).Active(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Plugin_Active_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(PluginServer).Active(ctx, req.(*Handle))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Plugin_Backup_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(BackupRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(PluginServer).Backup(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Plugin_Backup_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(PluginServer).Backup(ctx, req.(*BackupRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Plugin_Stop_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(Handle)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(PluginServer).Stop(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Plugin_Stop_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(PluginServer).Stop(ctx, req.(*Handle))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Plugin_Position_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(Handle)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(PluginServer).Position(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Plugin_Position_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(PluginServer).Position(ctx, req.(*Handle))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Plugin_ReplayDelay_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(Handle)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(PluginServer).ReplayDelay(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Plugin_ReplayDelay_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(PluginServer).ReplayDelay(ctx, req.(*Handle))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Plugin_Ready_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(Handle)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(PluginServer).Ready(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Plugin_Ready_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(PluginServer).Ready(ctx, req.(*Handle))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Plugin_Record_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(NodeRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(PluginServer).Record(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Plugin_Record_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(PluginServer).Record(ctx, req.(*NodeRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Plugin_Report_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ReportRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(PluginServer).Report(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Plugin_Report_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(PluginServer).Report(ctx, req.(*ReportRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Plugin_Campaign_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(NodeRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(PluginServer).Campaign(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Plugin_Campaign_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(PluginServer).Campaign(ctx, req.(*NodeRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Plugin_Resign_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(NodeRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(PluginServer).Resign(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Plugin_Resign_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(PluginServer).Resign(ctx, req.(*NodeRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Plugin_Put_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(Transfer)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(PluginServer).Put(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Plugin_Put_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(PluginServer).Put(ctx, req.(*Transfer))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Plugin_Get_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(Transfer)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(PluginServer).Get(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Plugin_Get_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(PluginServer).Get(ctx, req.(*Transfer))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Plugin_Upload_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(Transfer)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(PluginServer).Upload(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Plugin_Upload_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(PluginServer).Upload(ctx, req.(*Transfer))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Plugin_Download_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(Transfer)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(PluginServer).Download(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Plugin_Download_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(PluginServer).Download(ctx, req.(*Transfer))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Plugin_Read_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(Transfer)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(PluginServer).Read(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Plugin_Read_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(PluginServer).Read(ctx, req.(*Transfer))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Plugin_List_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(Transfer)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(PluginServer).List(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Plugin_List_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(PluginServer).List(ctx, req.(*Transfer))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Plugin_Remove_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(Transfer)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(PluginServer).Remove(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Plugin_Remove_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(PluginServer).Remove(ctx, req.(*Transfer))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Plugin_RemoveAll_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(Transfer)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(PluginServer).RemoveAll(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Plugin_RemoveAll_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(PluginServer).RemoveAll(ctx, req.(*Transfer))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Plugin_Notify_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(Event)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(PluginServer).Notify(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Plugin_Notify_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(PluginServer).Notify(ctx, req.(*Event))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+// Plugin_ServiceDesc is the grpc.ServiceDesc for Plugin service.
+// It's only intended for direct use with grpc.RegisterService,
+// and not to be introspected or modified (even as a copy)
+var Plugin_ServiceDesc = grpc.ServiceDesc{
+	ServiceName: "yoke.plugin.Plugin",
+	HandlerType: (*PluginServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "Open",
+			Handler:    _Plugin_Open_Handler,
+		},
+		{
+			MethodName: "Initialize",
+			Handler:    _Plugin_Initialize_Handler,
+		},
+		{
+			MethodName: "Start",
+			Handler:    _Plugin_Start_Handler,
+		},
+		{
+			MethodName: "Single",
+			Handler:    _Plugin_Single_Handler,
+		},
+		{
+			MethodName: "Active",
+			Handler:    _Plugin_Active_Handler,
+		},
+		{
+			MethodName: "Backup",
+			Handler:    _Plugin_Backup_Handler,
+		},
+		{
+			MethodName: "Stop",
+			Handler:    _Plugin_Stop_Handler,
+		},
+		{
+			MethodName: "Position",
+			Handler:    _Plugin_Position_Handler,
+		},
+		{
+			MethodName: "ReplayDelay",
+			Handler:    _Plugin_ReplayDelay_Handler,
+		},
+		{
+			MethodName: "Ready",
+			Handler:    _Plugin_Ready_Handler,
+		},
+		{
+			MethodName: "Record",
+			Handler:    _Plugin_Record_Handler,
+		},
+		{
+			MethodName: "Report",
+			Handler:    _Plugin_Report_Handler,
+		},
+		{
+			MethodName: "Campaign",
+			Handler:    _Plugin_Campaign_Handler,
+		},
+		{
+			MethodName: "Resign",
+			Handler:    _Plugin_Resign_Handler,
+		},
+		{
+			MethodName: "Put",
+			Handler:    _Plugin_Put_Handler,
+		},
+		{
+			MethodName: "Get",
+			Handler:    _Plugin_Get_Handler,
+		},
+		{
+			MethodName: "Upload",
+			Handler:    _Plugin_Upload_Handler,
+		},
+		{
+			MethodName: "Download",
+			Handler:    _Plugin_Download_Handler,
+		},
+		{
+			MethodName: "Read",
+			Handler:    _Plugin_Read_Handler,
+		},
+		{
+			MethodName: "List",
+			Handler:    _Plugin_List_Handler,
+		},
+		{
+			MethodName: "Remove",
+			Handler:    _Plugin_Remove_Handler,
+		},
+		{
+			MethodName: "RemoveAll",
+			Handler:    _Plugin_RemoveAll_Handler,
+		},
+		{
+			MethodName: "Notify",
+			Handler:    _Plugin_Notify_Handler,
+		},
+	},
+	Streams:  []grpc.StreamDesc{},
+	Metadata: "plugin.proto",
+}
@@ -0,0 +1,188 @@
+// Copyright (c) 2015 Pagoda Box Inc
+//
+// This Source Code Form is subject to the terms of the Mozilla Public License, v.
+// 2.0. If a copy of the MPL was not distributed with this file, You can obtain one
+// at http://mozilla.org/MPL/2.0/.
+//
+
+// proxy listens on a stable port and forwards client connections to whichever
+// node of the cluster is currently running the writable database. Open
+// connections are reset when the writable database moves to another node.
+package proxy
+
+import (
+	"github.com/nanopack/yoke/config"
+	"github.com/nanopack/yoke/state"
+	"io"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// RefreshInterval is how often the proxy looks for the writable database
+var RefreshInterval = time.Second
+
+type (
+	Proxy struct {
+		sync.Mutex
+		nodes  []state.State
+		port   int
+		target string
+		conns  map[net.Conn]bool
+	}
+
+	listener struct {
+		net.Listener
+		stop chan struct{}
+		once sync.Once
+	}
+)
+
+// New creates a proxy that forwards to the postgres port of whichever of nodes is
+// active, or running single
+func New(nodes []state.State, pgPort int) *Proxy {
+	return &Proxy{
+		nodes: nodes,
+		port:  pgPort,
+		conns: map[net.Conn]bool{},
+	}
+}
+
+// Listen starts accepting client connections on address
+func (proxy *Proxy) Listen(address string) (io.Closer, error) {
+	inner, err := net.Listen("tcp", address)
+	if err != nil {
+		return nil, err
+	}
+	listen := &listener{Listener: inner, stop: make(chan struct{})}
+
+	proxy.Refresh()
+	go func() {
+		ticker := time.NewTicker(RefreshInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				proxy.Refresh()
+			case <-listen.stop:
+				return
+			}
+		}
+	}()
+
+	go func() {
+		for {
+			conn, err := inner.Accept()
+			if err != nil {
+				return
+			}
+			go proxy.handle(conn)
+		}
+	}()
+	return listen, nil
+}
+
+// Target returns the address connections are currently forwarded to, it is empty
+// when there is no writable database in the cluster
+func (proxy *Proxy) Target() string {
+	proxy.Lock()
+	defer proxy.Unlock()
+	return proxy.target
+}
+
+// Refresh looks up which node is running the writable database. When it has
+// moved, every open connection is reset so clients reconnect to the new node. A
+// node that can't be asked keeps the connections to it, unless another node is
+// writable now.
+func (proxy *Proxy) Refresh() {
+	current := proxy.Target()
+	target := ""
+	unsure := false
+	for _, node := range proxy.nodes {
+		host, _, err := net.SplitHostPort(node.Location())
+		if err != nil {
+			continue
+		}
+		address := net.JoinHostPort(host, strconv.Itoa(proxy.port))
+		role, err := node.GetDBRole()
+		if err != nil {
+			// a single timeout is no reason to reset every connection
+			unsure = unsure || address == current
+			continue
+		}
+		if state.DBRole(role) == state.Active || state.DBRole(role) == state.Single {
+			target = address
+			break
+		}
+	}
+	if target == "" && unsure {
+		target = current
+	}
+
+	proxy.Lock()
+	defer proxy.Unlock()
+	if target == proxy.target {
+		return
+	}
+	config.Log.Info("[proxy] forwarding to '%v' instead of '%v'", target, proxy.target)
+	proxy.target = target
+	for conn := range proxy.conns {
+		conn.Close()
+	}
+	proxy.conns = map[net.Conn]bool{}
+}
+
+func (proxy *Proxy) handle(client net.Conn) {
+	target := proxy.Target()
+	if target == "" {
+		config.Log.Warn("[proxy] there is no writable database, dropping connection")
+		client.Close()
+		return
+	}
+	server, err := net.DialTimeout("tcp", target, time.Second*5)
+	if err != nil {
+		config.Log.Error("[proxy] unable to reach '%v' (%v)", target, err)
+		client.Close()
+		return
+	}
+
+	proxy.Lock()
+	if proxy.target != target {
+		// the database moved while this connection was being set up
+		proxy.Unlock()
+		client.Close()
+		server.Close()
+		return
+	}
+	proxy.conns[client] = true
+	proxy.conns[server] = true
+	proxy.Unlock()
+
+	done := make(chan struct{}, 2)
+	go pipe(server, client, done)
+	go pipe(client, server, done)
+	<-done
+	client.Close()
+	server.Close()
+	<-done
+
+	proxy.Lock()
+	delete(proxy.conns, client)
+	delete(proxy.conns, server)
+	proxy.Unlock()
+}
+
+func pipe(dst, src net.Conn, done chan struct{}) {
+	io.Copy(dst, src)
+	done <- struct{}{}
+}
+
+// Close stops accepting connections and stops following the cluster, open
+// connections are left alone
+func (listen *listener) Close() error {
+	listen.once.Do(func() {
+		close(listen.stop)
+	})
+	return listen.Listener.Close()
+}
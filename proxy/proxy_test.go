@@ -0,0 +1,179 @@
+// Copyright (c) 2015 Pagoda Box Inc
+//
+// This Source Code Form is subject to the terms of the Mozilla Public License, v.
+// 2.0. If a copy of the MPL was not distributed with this file, You can obtain one
+// at http://mozilla.org/MPL/2.0/.
+//
+
+package proxy_test
+
+import (
+	"bufio"
+	"errors"
+	"github.com/golang/mock/gomock"
+	"github.com/nanopack/yoke/proxy"
+	"github.com/nanopack/yoke/state"
+	"github.com/nanopack/yoke/state/mock"
+	"io"
+	"net"
+	"strconv"
+	"testing"
+	"time"
+)
+
+// the tests refresh the proxy themselves
+func init() {
+	proxy.RefreshInterval = time.Hour
+}
+
+func TestForward(test *testing.T) {
+	ctrl := gomock.NewController(test)
+	defer ctrl.Finish()
+
+	// stands in for postgres on the active node
+	database, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		test.Log(err)
+		test.FailNow()
+	}
+	defer database.Close()
+	go func() {
+		for {
+			conn, err := database.Accept()
+			if err != nil {
+				return
+			}
+			go io.Copy(conn, conn)
+		}
+	}()
+	port := database.Addr().(*net.TCPAddr).Port
+
+	me := mock_state.NewMockState(ctrl)
+	other := mock_state.NewMockState(ctrl)
+	me.EXPECT().Location().Return("127.0.0.1:4400").AnyTimes()
+	other.EXPECT().Location().Return("127.0.0.2:4400").AnyTimes()
+
+	forward := proxy.New([]state.State{me, other}, port)
+
+	me.EXPECT().GetDBRole().Return("active", nil)
+	listener, err := forward.Listen("127.0.0.1:0")
+	if err != nil {
+		test.Log(err)
+		test.FailNow()
+	}
+	defer listener.Close()
+
+	if forward.Target() != database.Addr().String() {
+		test.Logf("wrong target '%v'", forward.Target())
+		test.FailNow()
+	}
+
+	client, err := net.Dial("tcp", listener.(net.Listener).Addr().String())
+	if err != nil {
+		test.Log(err)
+		test.FailNow()
+	}
+	defer client.Close()
+	client.SetDeadline(time.Now().Add(time.Second))
+
+	if _, err := client.Write([]byte("ping\n")); err != nil {
+		test.Log(err)
+		test.FailNow()
+	}
+	line, err := bufio.NewReader(client).ReadString('\n')
+	if err != nil || line != "ping\n" {
+		test.Logf("connection was not forwarded '%v' %v", line, err)
+		test.FailNow()
+	}
+
+	// the active node went away, open connections have to be reset
+	me.EXPECT().GetDBRole().Return("backup", nil)
+	other.EXPECT().GetDBRole().Return("backup", nil)
+	forward.Refresh()
+	if forward.Target() != "" {
+		test.Logf("there should be no target '%v'", forward.Target())
+		test.FailNow()
+	}
+
+	if _, err := client.Read(make([]byte, 1)); err == nil {
+		test.Log("the connection should have been reset")
+		test.FailNow()
+	}
+}
+
+func TestFlakyNode(test *testing.T) {
+	ctrl := gomock.NewController(test)
+	defer ctrl.Finish()
+
+	database, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		test.Log(err)
+		test.FailNow()
+	}
+	defer database.Close()
+	go func() {
+		for {
+			conn, err := database.Accept()
+			if err != nil {
+				return
+			}
+			go io.Copy(conn, conn)
+		}
+	}()
+	port := database.Addr().(*net.TCPAddr).Port
+
+	me := mock_state.NewMockState(ctrl)
+	other := mock_state.NewMockState(ctrl)
+	me.EXPECT().Location().Return("127.0.0.1:4400").AnyTimes()
+	other.EXPECT().Location().Return("127.0.0.2:4400").AnyTimes()
+
+	forward := proxy.New([]state.State{me, other}, port)
+
+	me.EXPECT().GetDBRole().Return("active", nil)
+	listener, err := forward.Listen("127.0.0.1:0")
+	if err != nil {
+		test.Log(err)
+		test.FailNow()
+	}
+	defer listener.Close()
+
+	client, err := net.Dial("tcp", listener.(net.Listener).Addr().String())
+	if err != nil {
+		test.Log(err)
+		test.FailNow()
+	}
+	defer client.Close()
+	client.SetDeadline(time.Now().Add(time.Second))
+	reader := bufio.NewReader(client)
+
+	// the active node timed out once, nothing else took over
+	me.EXPECT().GetDBRole().Return("", errors.New("i/o timeout"))
+	other.EXPECT().GetDBRole().Return("backup", nil)
+	forward.Refresh()
+	if forward.Target() != database.Addr().String() {
+		test.Logf("the target should have been kept, not '%v'", forward.Target())
+		test.FailNow()
+	}
+	if _, err := client.Write([]byte("ping\n")); err != nil {
+		test.Log(err)
+		test.FailNow()
+	}
+	line, err := reader.ReadString('\n')
+	if err != nil || line != "ping\n" {
+		test.Logf("the connection should have been left alone '%v' %v", line, err)
+		test.FailNow()
+	}
+
+	// the active node still can't be asked, but the other one took over
+	me.EXPECT().GetDBRole().Return("", errors.New("i/o timeout"))
+	other.EXPECT().GetDBRole().Return("single", nil)
+	forward.Refresh()
+	if forward.Target() != net.JoinHostPort("127.0.0.2", strconv.Itoa(port)) {
+		test.Logf("the target should have moved to the other node, not '%v'", forward.Target())
+		test.FailNow()
+	}
+	if _, err := reader.ReadByte(); err == nil {
+		test.Log("the connection should have been reset")
+		test.FailNow()
+	}
+}
@@ -0,0 +1,38 @@
+// Copyright (c) 2015 Pagoda Box Inc
+//
+// This Source Code Form is subject to the terms of the Mozilla Public License, v.
+// 2.0. If a copy of the MPL was not distributed with this file, You can obtain one
+// at http://mozilla.org/MPL/2.0/.
+//
+
+package secrets
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/nanopack/yoke/config"
+	"strings"
+	"time"
+)
+
+// awsSecret reads a secret of aws secrets manager with the aws cli, which finds
+// its credentials the way it always does (environment, profile or instance role).
+// With a key the secret is json and the value of the key is returned.
+func awsSecret(id, key string) (string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	out, err := config.Shell(ctx, fmt.Sprintf("aws secretsmanager get-secret-value --secret-id '%s' --query SecretString --output text", strings.Replace(id, "'", `'\''`, -1))).Output()
+	if err != nil {
+		return "", fmt.Errorf("aws secretsmanager get-secret-value failed for '%v' (%v)", id, err)
+	}
+	secret := strings.TrimSpace(string(out))
+	if key == "" {
+		return secret, nil
+	}
+	fields := map[string]json.RawMessage{}
+	if err := json.Unmarshal([]byte(secret), &fields); err != nil {
+		return "", fmt.Errorf("the secret '%v' is not json, so it has no '%v'", id, key)
+	}
+	return fieldOf(fields, key)
+}
@@ -0,0 +1,261 @@
+// Copyright (c) 2015 Pagoda Box Inc
+//
+// This Source Code Form is subject to the terms of the Mozilla Public License, v.
+// 2.0. If a copy of the MPL was not distributed with this file, You can obtain one
+// at http://mozilla.org/MPL/2.0/.
+//
+
+// secrets looks up the passwords of the config that are not written into it, but
+// refer to where they are kept:
+//
+//	file:/etc/yoke/replication     the contents of a file
+//	vault:secret/data/yoke#repl    a field of a vault secret (kv version 1 or 2)
+//	aws:yoke/replication#password  an aws secrets manager secret, or a key of its json
+//
+// The secrets are looked up again every refresh_interval, and the databases that
+// use them are told when they were rotated.
+package secrets
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/nanopack/yoke/config"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+type (
+	// Rotator is a database that picks up new passwords while it runs
+	Rotator interface {
+		// Rotate takes over the passwords of conf
+		Rotate(conf config.Config) error
+	}
+
+	// Watcher keeps the passwords that refer to a secret up to date
+	Watcher struct {
+		sync.Mutex
+		references  map[string]string // the option a secret is for, and where it is kept
+		values      map[string]string
+		subscribers []subscriber
+		address     string
+		token       string
+		client      *http.Client
+		log         config.Logger
+	}
+
+	// a database and the config it was started with
+	subscriber struct {
+		conf    config.Config
+		rotator Rotator
+	}
+)
+
+// the password options that can refer to a secret
+func passwords(conf *config.Config) map[string]*string {
+	return map[string]*string{
+		"[mysql] password":             &conf.MySQLPassword,
+		"[mysql] replication_password": &conf.ReplicationPassword,
+		"[redis] password":             &conf.RedisPassword,
+	}
+}
+
+// IsReference checks if a password refers to a secret instead of being one
+func IsReference(password string) bool {
+	for _, scheme := range []string{"file:", "vault:", "aws:"} {
+		if strings.HasPrefix(password, scheme) {
+			return true
+		}
+	}
+	return false
+}
+
+// Resolve looks up every password of conf that refers to a secret and puts the
+// secret in its place. The returned watcher keeps them up to date.
+func Resolve(conf *config.Config) (*Watcher, error) {
+	watcher := &Watcher{
+		references: map[string]string{},
+		values:     map[string]string{},
+		address:    strings.TrimSuffix(conf.VaultAddress, "/"),
+		client:     &http.Client{Timeout: 10 * time.Second},
+		log:        conf.Logging(),
+	}
+	for option, password := range passwords(conf) {
+		if IsReference(*password) {
+			watcher.references[option] = *password
+		}
+	}
+	if len(watcher.references) == 0 {
+		return watcher, nil
+	}
+
+	watcher.token = conf.VaultToken
+	if strings.HasPrefix(watcher.token, "file:") {
+		token, err := watcher.lookup(watcher.token)
+		if err != nil {
+			return nil, fmt.Errorf("the vault token could not be read (%v)", err)
+		}
+		watcher.token = token
+	}
+	for option, reference := range watcher.references {
+		value, err := watcher.lookup(reference)
+		if err != nil {
+			return nil, fmt.Errorf("the %v could not be looked up (%v)", option, err)
+		}
+		watcher.values[option] = value
+	}
+	watcher.Apply(conf)
+	return watcher, nil
+}
+
+// Apply puts the secrets as they were last looked up into conf
+func (watcher *Watcher) Apply(conf *config.Config) {
+	watcher.Lock()
+	defer watcher.Unlock()
+	for option, password := range passwords(conf) {
+		if value, ok := watcher.values[option]; ok {
+			*password = value
+		}
+	}
+}
+
+// Subscribe has rotator told about the secrets that change, conf is the config
+// it was started with
+func (watcher *Watcher) Subscribe(conf config.Config, rotator Rotator) {
+	watcher.Lock()
+	defer watcher.Unlock()
+	watcher.subscribers = append(watcher.subscribers, subscriber{conf: conf, rotator: rotator})
+}
+
+// Watch looks the secrets up again every interval until ctx is done. When any
+// of them changed the subscribed databases rotate to the new ones.
+func (watcher *Watcher) Watch(ctx context.Context, interval time.Duration) {
+	if len(watcher.references) == 0 {
+		return
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			watcher.refresh()
+		}
+	}
+}
+
+// refresh looks up every secret, the old ones are kept until all of them could be
+// looked up
+func (watcher *Watcher) refresh() {
+	values := map[string]string{}
+	for option, reference := range watcher.references {
+		value, err := watcher.lookup(reference)
+		if err != nil {
+			watcher.log.Error("[secrets] the %v could not be looked up, keeping the one I have (%v)", option, err)
+			return
+		}
+		values[option] = value
+	}
+
+	watcher.Lock()
+	changed := []string{}
+	for option, value := range values {
+		if watcher.values[option] != value {
+			changed = append(changed, option)
+		}
+	}
+	watcher.values = values
+	subscribers := watcher.subscribers
+	watcher.Unlock()
+	if len(changed) == 0 {
+		return
+	}
+
+	watcher.log.Info("[secrets] %v rotated", strings.Join(changed, ", "))
+	for _, subscribed := range subscribers {
+		conf := subscribed.conf
+		watcher.Apply(&conf)
+		if err := subscribed.rotator.Rotate(conf); err != nil {
+			conf.Logging().Error("[secrets] the database did not take the new passwords (%v)", err)
+		}
+	}
+}
+
+// lookup reads the secret a reference points at
+func (watcher *Watcher) lookup(reference string) (string, error) {
+	scheme, path := reference, ""
+	if i := strings.Index(reference, ":"); i != -1 {
+		scheme, path = reference[:i], reference[i+1:]
+	}
+	if scheme == "file" {
+		contents, err := ioutil.ReadFile(path)
+		if err != nil {
+			return "", err
+		}
+		return strings.TrimSpace(string(contents)), nil
+	}
+
+	key := ""
+	if i := strings.LastIndex(path, "#"); i != -1 {
+		path, key = path[:i], path[i+1:]
+	}
+	switch scheme {
+	case "vault":
+		return watcher.vault(path, key)
+	case "aws":
+		return awsSecret(path, key)
+	}
+	return "", fmt.Errorf("'%v' is not a secret I know how to look up", reference)
+}
+
+// vault reads a field of the secret at path through the http api of vault
+func (watcher *Watcher) vault(path, field string) (string, error) {
+	if field == "" {
+		return "", fmt.Errorf("the field of '%v' is missing (e.g. 'vault:%v#password')", path, path)
+	}
+	req, err := http.NewRequest("GET", watcher.address+"/v1/"+strings.TrimPrefix(path, "/"), nil)
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("X-Vault-Token", watcher.token)
+	res, err := watcher.client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("vault replied %v for '%v'", res.Status, path)
+	}
+
+	// version 2 of the kv engine nests the fields of the secret a level deeper
+	var secret struct {
+		Data map[string]json.RawMessage `json:"data"`
+	}
+	if err := json.NewDecoder(res.Body).Decode(&secret); err != nil {
+		return "", err
+	}
+	fields := secret.Data
+	if nested, ok := secret.Data["data"]; ok {
+		if err := json.Unmarshal(nested, &fields); err != nil {
+			return "", err
+		}
+	}
+	return fieldOf(fields, field)
+}
+
+// fieldOf returns a field of a json secret, which has to be a string
+func fieldOf(fields map[string]json.RawMessage, field string) (string, error) {
+	raw, ok := fields[field]
+	if !ok {
+		return "", fmt.Errorf("the secret has no '%v'", field)
+	}
+	var value string
+	if err := json.Unmarshal(raw, &value); err != nil {
+		return "", fmt.Errorf("'%v' of the secret is not a string", field)
+	}
+	return value, nil
+}
@@ -0,0 +1,89 @@
+// Copyright (c) 2015 Pagoda Box Inc
+//
+// This Source Code Form is subject to the terms of the Mozilla Public License, v.
+// 2.0. If a copy of the MPL was not distributed with this file, You can obtain one
+// at http://mozilla.org/MPL/2.0/.
+//
+
+package secrets_test
+
+import (
+	"context"
+	"github.com/nanopack/yoke/config"
+	"github.com/nanopack/yoke/secrets"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+type rotated chan config.Config
+
+func (rotate rotated) Rotate(conf config.Config) error {
+	rotate <- conf
+	return nil
+}
+
+func TestResolveAndRotate(test *testing.T) {
+	vault := httptest.NewServer(http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+		if req.Header.Get("X-Vault-Token") != "token" || req.URL.Path != "/v1/secret/data/yoke" {
+			res.WriteHeader(http.StatusForbidden)
+			return
+		}
+		res.Write([]byte(`{"data":{"data":{"redis":"from-vault"},"metadata":{"version":1}}}`))
+	}))
+	defer vault.Close()
+
+	dir, err := ioutil.TempDir("", "secrets")
+	if err != nil {
+		test.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	file := filepath.Join(dir, "replication")
+	if err := ioutil.WriteFile(file, []byte("first\n"), 0600); err != nil {
+		test.Fatal(err)
+	}
+
+	conf := config.Defaults
+	conf.MySQLPassword = "plain"
+	conf.ReplicationPassword = "file:" + file
+	conf.RedisPassword = "vault:secret/data/yoke#redis"
+	conf.VaultAddress = vault.URL
+	conf.VaultToken = "token"
+	watcher, err := secrets.Resolve(&conf)
+	if err != nil {
+		test.Fatal(err)
+	}
+	if conf.MySQLPassword != "plain" || conf.ReplicationPassword != "first" || conf.RedisPassword != "from-vault" {
+		test.Fatalf("the secrets were not looked up %+v", conf)
+	}
+
+	rotate := make(rotated, 1)
+	watcher.Subscribe(conf, rotate)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go watcher.Watch(ctx, 10*time.Millisecond)
+
+	if err := ioutil.WriteFile(file, []byte("second\n"), 0600); err != nil {
+		test.Fatal(err)
+	}
+	select {
+	case conf := <-rotate:
+		if conf.ReplicationPassword != "second" || conf.RedisPassword != "from-vault" {
+			test.Fatalf("the rotated passwords are wrong %+v", conf)
+		}
+	case <-time.After(time.Second):
+		test.Fatal("the rotation was not noticed")
+	}
+}
+
+func TestMissingSecret(test *testing.T) {
+	conf := config.Defaults
+	conf.ReplicationPassword = "file:/does/not/exist"
+	if _, err := secrets.Resolve(&conf); err == nil {
+		test.Fatal("a secret that can not be read has to keep the node from starting")
+	}
+}
@@ -0,0 +1,49 @@
+// Copyright (c) 2015 Pagoda Box Inc
+//
+// This Source Code Form is subject to the terms of the Mozilla Public License, v.
+// 2.0. If a copy of the MPL was not distributed with this file, You can obtain one
+// at http://mozilla.org/MPL/2.0/.
+//
+
+//go:build windows
+
+package main
+
+import (
+	"golang.org/x/sys/windows/svc"
+	"os"
+	"os/signal"
+	"syscall"
+)
+
+// notifySignals returns the channel the signals yoke acts on are relayed to. A
+// yoke that runs as a windows service has the service manager stop it, which is
+// relayed as SIGTERM; there is no SIGHUP to reload it with, 'POST /reload' does.
+func notifySignals() chan os.Signal {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	if service, err := svc.IsWindowsService(); err == nil && service {
+		go svc.Run("yoke", serviceHandler(signals))
+	}
+	return signals
+}
+
+// serviceHandler answers the service manager for as long as yoke runs
+type serviceHandler chan os.Signal
+
+// Execute reports the service as running right away, the database is started and
+// decided on the same way as without the service manager. A stop waits for yoke to
+// shut the database down, the service stops once the process exits.
+func (signals serviceHandler) Execute(args []string, requests <-chan svc.ChangeRequest, status chan<- svc.Status) (bool, uint32) {
+	status <- svc.Status{State: svc.Running, Accepts: svc.AcceptStop | svc.AcceptShutdown}
+	for request := range requests {
+		switch request.Cmd {
+		case svc.Interrogate:
+			status <- request.CurrentStatus
+		case svc.Stop, svc.Shutdown:
+			status <- svc.Status{State: svc.StopPending}
+			signals <- syscall.SIGTERM
+		}
+	}
+	return false, 0
+}
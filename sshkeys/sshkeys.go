@@ -0,0 +1,246 @@
+// Copyright (c) 2015 Pagoda Box Inc
+//
+// This Source Code Form is subject to the terms of the Mozilla Public License, v.
+// 2.0. If a copy of the MPL was not distributed with this file, You can obtain one
+// at http://mozilla.org/MPL/2.0/.
+//
+
+// sshkeys lets the nodes copy the data to each other over ssh without the ssh
+// setup of the account yoke runs as. Each node generates a key of its own when it
+// first starts, and tells the other nodes about it and about the host key of its
+// sshd along with its summary. Every node authorizes the keys of the others, and
+// pins them and their host keys the first time it learns them, a key that changes
+// after that is refused until an operator removes the pinned one. An authorized key
+// only runs rrsync on the data_dir, from the address of the node it belongs to.
+package sshkeys
+
+import (
+	"bufio"
+	"fmt"
+	"github.com/nanopack/yoke/config"
+	"io/ioutil"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Keyring is the key of the node and the host key of its sshd, and the keys of the
+// other nodes it trusts, see monitor.RegisterKeyring
+type Keyring struct {
+	sync.Mutex
+	key        string               // the public key of this node
+	hostKey    string               // the public host key of its sshd
+	port       int                  // the other nodes run their sshd on
+	known      string               // the known_hosts the host keys are pinned in
+	authorized string               // the authorized_keys the keys are added to
+	dataDir    string               // the only directory the other nodes can copy to
+	trusted    map[string][2]string // the keys each node was last trusted with, by location
+	log        config.Logger
+}
+
+// changed is the error of a key that is not the one that was pinned, it stays
+// refused until an operator removes the pinned one
+type changed struct {
+	error
+}
+
+// New reads the keys of the node from the [ssh] section of the config, the key the
+// node copies the data with is generated if there is none yet. It is nil unless
+// the ssh is managed.
+func New(conf config.Config) (*Keyring, error) {
+	if !conf.SSHManage {
+		return nil, nil
+	}
+	file := conf.SSHKeyFile()
+	if _, err := os.Stat(file); os.IsNotExist(err) {
+		if err := os.MkdirAll(filepath.Dir(file), 0700); err != nil {
+			return nil, err
+		}
+		comment := "yoke@" + conf.AdvertiseAddress()
+		if out, err := exec.Command("ssh-keygen", "-q", "-t", "ed25519", "-N", "", "-C", comment, "-f", file).CombinedOutput(); err != nil {
+			return nil, fmt.Errorf("ssh-keygen failed (%v) %s", err, out)
+		}
+		conf.Logging().Info("[ssh] generated the key '%v'", file)
+	}
+	key, err := readKey(file + ".pub")
+	if err != nil {
+		return nil, err
+	}
+	hostKey, err := readKey(conf.SSHHostKey)
+	if err != nil {
+		return nil, err
+	}
+	return &Keyring{
+		key:        key,
+		hostKey:    hostKey,
+		port:       conf.SSHPort,
+		known:      conf.KnownHostsFile(),
+		authorized: conf.AuthorizedKeysFile(),
+		dataDir:    conf.DataDir,
+		trusted:    map[string][2]string{},
+		log:        conf.Logging(),
+	}, nil
+}
+
+// Keys are the public key of the node and the host key of its sshd, which go out
+// to the other nodes
+func (keyring *Keyring) Keys() (key, hostKey string) {
+	return keyring.key, keyring.hostKey
+}
+
+// Trust authorizes key for the node at location and pins its hostKey. Either can be
+// empty when the node doesn't manage its ssh. Keys that were trusted before are
+// not looked at again, keys that could not be written are tried again the next
+// time.
+func (keyring *Keyring) Trust(location, key, hostKey string) error {
+	keyring.Lock()
+	defer keyring.Unlock()
+	keys := [2]string{key, hostKey}
+	if keyring.trusted[location] == keys {
+		return nil
+	}
+	err := keyring.trust(location, key, hostKey)
+	if _, refused := err.(changed); err == nil || refused {
+		// a changed key is only reported once
+		keyring.trusted[location] = keys
+	}
+	return err
+}
+
+func (keyring *Keyring) trust(location, key, hostKey string) error {
+	address, _, err := net.SplitHostPort(location)
+	if err != nil {
+		return err
+	}
+	host := address
+	if keyring.port != 22 {
+		host = fmt.Sprintf("[%s]:%d", host, keyring.port)
+	}
+	if hostKey != "" {
+		if err := keyring.pin(host, hostKey); err != nil {
+			return err
+		}
+	}
+	if key != "" {
+		return keyring.authorize(location, address, key)
+	}
+	return nil
+}
+
+// pin adds the host key of host to the known_hosts, unless the host has one there
+func (keyring *Keyring) pin(host, hostKey string) error {
+	if err := checkKey(hostKey); err != nil {
+		return fmt.Errorf("the host key of '%v' %v", host, err)
+	}
+	lines, err := readLines(keyring.known)
+	if err != nil {
+		return err
+	}
+	for _, line := range lines {
+		fields := strings.Fields(line)
+		if len(fields) < 3 || fields[0] != host {
+			continue
+		}
+		if fields[1]+" "+fields[2] != hostKey {
+			return changed{fmt.Errorf("the host key of '%v' changed, it is refused until the pinned one is removed from '%v'", host, keyring.known)}
+		}
+		return nil
+	}
+	keyring.log.Info("[ssh] pinning the host key of '%v'", host)
+	return writeLines(keyring.known, append(lines, host+" "+hostKey))
+}
+
+// authorize adds the key of the node at location to the authorized_keys, unless
+// the node has another key there, the keys yoke did not add are left alone. The
+// key can only run rrsync on the data_dir, from the address of the node.
+func (keyring *Keyring) authorize(location, address, key string) error {
+	if err := checkKey(key); err != nil {
+		return fmt.Errorf("the key of '%v' %v", location, err)
+	}
+	lines, err := readLines(keyring.authorized)
+	if err != nil {
+		return err
+	}
+	comment := "yoke@" + location
+	authorized := fmt.Sprintf("restrict,from=%q,command=%q %s %s", address, "rrsync "+keyring.dataDir, key, comment)
+	kept := make([]string, 0, len(lines)+1)
+	for _, line := range lines {
+		if line == authorized {
+			return nil
+		}
+		fields := strings.Fields(line)
+		if len(fields) < 3 || fields[len(fields)-1] != comment {
+			kept = append(kept, line)
+			continue
+		}
+		// the key was pinned the first time, the line is only rewritten when the
+		// options changed
+		if fields[len(fields)-3]+" "+fields[len(fields)-2] != key {
+			return changed{fmt.Errorf("the key of '%v' changed, it is refused until the authorized one is removed from '%v'", location, keyring.authorized)}
+		}
+	}
+	keyring.log.Info("[ssh] authorizing the key of '%v'", location)
+	return writeLines(keyring.authorized, append(kept, authorized))
+}
+
+// readKey reads a public key without its comment, 'ssh-ed25519 AAAA...'
+func readKey(file string) (string, error) {
+	contents, err := ioutil.ReadFile(file)
+	if err != nil {
+		return "", err
+	}
+	fields := strings.Fields(string(contents))
+	if len(fields) < 2 {
+		return "", fmt.Errorf("'%v' is not a public key", file)
+	}
+	key := fields[0] + " " + fields[1]
+	return key, checkKey(key)
+}
+
+// checkKey makes sure what another node sent is a key and nothing else, it ends up
+// in the authorized_keys
+func checkKey(key string) error {
+	fields := strings.Fields(key)
+	if len(fields) != 2 || (!strings.HasPrefix(fields[0], "ssh-") && !strings.HasPrefix(fields[0], "ecdsa-")) {
+		return fmt.Errorf("is not a public key %v", strconv.Quote(key))
+	}
+	for _, c := range fields[1] {
+		if !strings.ContainsRune("ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz0123456789+/=", c) {
+			return fmt.Errorf("is not a public key %v", strconv.Quote(key))
+		}
+	}
+	return nil
+}
+
+func readLines(file string) ([]string, error) {
+	opened, err := os.Open(file)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer opened.Close()
+	lines := []string{}
+	scanner := bufio.NewScanner(opened)
+	for scanner.Scan() {
+		lines = append(lines, scanner.Text())
+	}
+	return lines, scanner.Err()
+}
+
+// writeLines replaces file at once, sshd never reads half of it
+func writeLines(file string, lines []string) error {
+	if err := os.MkdirAll(filepath.Dir(file), 0700); err != nil {
+		return err
+	}
+	temp := file + ".yoke"
+	if err := ioutil.WriteFile(temp, []byte(strings.Join(lines, "\n")+"\n"), 0600); err != nil {
+		return err
+	}
+	return os.Rename(temp, file)
+}
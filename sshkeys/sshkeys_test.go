@@ -0,0 +1,115 @@
+// Copyright (c) 2015 Pagoda Box Inc
+//
+// This Source Code Form is subject to the terms of the Mozilla Public License, v.
+// 2.0. If a copy of the MPL was not distributed with this file, You can obtain one
+// at http://mozilla.org/MPL/2.0/.
+//
+
+package sshkeys_test
+
+import (
+	"github.com/nanopack/yoke/config"
+	"github.com/nanopack/yoke/sshkeys"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+const (
+	key      = "ssh-ed25519 AAAAC3NzaC1lZDI1NTE5AAAAIKey1"
+	hostKey  = "ssh-ed25519 AAAAC3NzaC1lZDI1NTE5AAAAIHost1"
+	otherKey = "ssh-ed25519 AAAAC3NzaC1lZDI1NTE5AAAAIHost2"
+)
+
+func TestTrust(test *testing.T) {
+	dir, err := ioutil.TempDir("", "sshkeys")
+	if err != nil {
+		test.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	// the key is there already, it isn't generated again
+	conf := config.Defaults
+	conf.SSHManage = true
+	conf.SSHDir = dir
+	conf.DataDir = "/data"
+	conf.SSHHostKey = filepath.Join(dir, "host.pub")
+	conf.SSHAuthorizedKeys = filepath.Join(dir, "authorized_keys")
+	ioutil.WriteFile(conf.SSHKeyFile(), []byte("private"), 0600)
+	ioutil.WriteFile(conf.SSHKeyFile()+".pub", []byte(key+" yoke@10.0.0.1:4400\n"), 0600)
+	ioutil.WriteFile(conf.SSHHostKey, []byte(hostKey+" root@db1\n"), 0600)
+	ioutil.WriteFile(conf.SSHAuthorizedKeys, []byte("ssh-rsa AAAAB3Nza operator@laptop\n"), 0600)
+
+	keyring, err := sshkeys.New(conf)
+	if err != nil {
+		test.Fatal(err)
+	}
+	if mine, host := keyring.Keys(); mine != key || host != hostKey {
+		test.Logf("the keys were not read '%v' '%v'", mine, host)
+		test.Fail()
+	}
+
+	if err := keyring.Trust("10.0.0.2:4400", key, hostKey); err != nil {
+		test.Fatal(err)
+	}
+	known, _ := ioutil.ReadFile(conf.KnownHostsFile())
+	if string(known) != "10.0.0.2 "+hostKey+"\n" {
+		test.Logf("the host key was not pinned '%s'", known)
+		test.Fail()
+	}
+	authorized, _ := ioutil.ReadFile(conf.SSHAuthorizedKeys)
+	if !strings.HasPrefix(string(authorized), "ssh-rsa AAAAB3Nza operator@laptop\n") || !strings.Contains(string(authorized), `restrict,from="10.0.0.2",command="rrsync /data" `+key+" yoke@10.0.0.2:4400\n") {
+		test.Logf("the key was not authorized next to the others '%s'", authorized)
+		test.Fail()
+	}
+
+	// a host key that changed is refused once, and stays pinned as it was
+	if err := keyring.Trust("10.0.0.2:4400", key, otherKey); err == nil {
+		test.Log("a changed host key should have been refused")
+		test.Fail()
+	}
+	if err := keyring.Trust("10.0.0.2:4400", key, otherKey); err != nil {
+		test.Logf("a refused host key should only be reported once %v", err)
+		test.Fail()
+	}
+	if known, _ := ioutil.ReadFile(conf.KnownHostsFile()); string(known) != "10.0.0.2 "+hostKey+"\n" {
+		test.Logf("the pinned host key should have been kept '%s'", known)
+		test.Fail()
+	}
+
+	// so is a key that changed, whoever answers for the node can't swap it
+	if err := keyring.Trust("10.0.0.2:4400", otherKey, hostKey); err == nil {
+		test.Log("a changed key should have been refused")
+		test.Fail()
+	}
+	if again, _ := ioutil.ReadFile(conf.SSHAuthorizedKeys); string(again) != string(authorized) {
+		test.Logf("the authorized key should have been kept '%s'", again)
+		test.Fail()
+	}
+
+	// keys that could not be written are tried again
+	os.Rename(conf.SSHAuthorizedKeys, conf.SSHAuthorizedKeys+".moved")
+	os.Mkdir(conf.SSHAuthorizedKeys, 0700)
+	if err := keyring.Trust("10.0.0.4:4400", key, ""); err == nil {
+		test.Log("an authorized_keys that can't be written should have failed")
+		test.Fail()
+	}
+	os.Remove(conf.SSHAuthorizedKeys)
+	os.Rename(conf.SSHAuthorizedKeys+".moved", conf.SSHAuthorizedKeys)
+	if err := keyring.Trust("10.0.0.4:4400", key, ""); err != nil {
+		test.Log(err)
+		test.Fail()
+	}
+	if again, _ := ioutil.ReadFile(conf.SSHAuthorizedKeys); !strings.Contains(string(again), "yoke@10.0.0.4:4400") {
+		test.Logf("the key should have been authorized once it could be '%s'", again)
+		test.Fail()
+	}
+
+	// what another node sends can't add options to the authorized_keys
+	if err := keyring.Trust("10.0.0.3:4400", `command="sh" `+key, ""); err == nil {
+		test.Log("a key with options should have been refused")
+		test.Fail()
+	}
+}
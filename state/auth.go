@@ -0,0 +1,139 @@
+// Copyright (c) 2015 Pagoda Box Inc
+//
+// This Source Code Form is subject to the terms of the Mozilla Public License, v.
+// 2.0. If a copy of the MPL was not distributed with this file, You can obtain one
+// at http://mozilla.org/MPL/2.0/.
+//
+
+package state
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"errors"
+	"io"
+	"net"
+	"sync"
+	"time"
+)
+
+var (
+	Unauthorized = errors.New("the other side does not share the cluster secret")
+
+	// the shared secrets every rpc connection has to prove it knows, the first is
+	// used to authenticate this node, any of them are accepted from another node
+	secrets     [][]byte
+	secretsLock sync.RWMutex
+)
+
+// how long the other side of a connection has to authenticate
+var authTimeout = 5 * time.Second
+
+const nonceSize = 32
+
+// EnableAuth requires every rpc connection this process makes or accepts to prove
+// that it knows one of the shared secrets. This node authenticates itself with the
+// first secret and accepts all of them, which lets a new secret be rolled out one
+// node at a time. Passing no secrets turns authentication off.
+func EnableAuth(shared ...string) {
+	secretsLock.Lock()
+	defer secretsLock.Unlock()
+	secrets = nil
+	for _, secret := range shared {
+		secrets = append(secrets, []byte(secret))
+	}
+}
+
+func currentSecrets() [][]byte {
+	secretsLock.RLock()
+	defer secretsLock.RUnlock()
+	return secrets
+}
+
+// authenticates a connection that was accepted. The listener challenges the
+// dialer with a nonce, the dialer answers with the hmac of it under one of the
+// shared secrets and a nonce of its own, which the listener answers in turn. The
+// listener only answers once the dialer proved it knows a secret, so it can't be
+// used to sign the challenge of another node. The secrets are the ones of the
+// moment, so a reload that rotates them applies to the next connection.
+func authenticateAccepted(conn net.Conn) error {
+	shared := currentSecrets()
+	if len(shared) == 0 {
+		return nil
+	}
+	conn.SetDeadline(time.Now().Add(authTimeout))
+	defer conn.SetDeadline(time.Time{})
+
+	nonce := make([]byte, nonceSize)
+	if _, err := rand.Read(nonce); err != nil {
+		return err
+	}
+	if _, err := conn.Write(nonce); err != nil {
+		return err
+	}
+	answer := make([]byte, sha256.Size+nonceSize)
+	if _, err := io.ReadFull(conn, answer); err != nil {
+		return err
+	}
+	if !signed(shared, dialerSide, nonce, answer[:sha256.Size]) {
+		return Unauthorized
+	}
+	_, err := conn.Write(sign(shared[0], listenerSide, answer[sha256.Size:]))
+	return err
+}
+
+// authenticates a connection that was dialed, it answers the challenge of the
+// listener and challenges it back
+func authenticateDialed(conn net.Conn) error {
+	shared := currentSecrets()
+	if len(shared) == 0 {
+		return nil
+	}
+	conn.SetDeadline(time.Now().Add(authTimeout))
+	defer conn.SetDeadline(time.Time{})
+
+	challenge := make([]byte, nonceSize)
+	if _, err := io.ReadFull(conn, challenge); err != nil {
+		return err
+	}
+	nonce := make([]byte, nonceSize)
+	if _, err := rand.Read(nonce); err != nil {
+		return err
+	}
+	if _, err := conn.Write(append(sign(shared[0], dialerSide, challenge), nonce...)); err != nil {
+		return err
+	}
+	answer := make([]byte, sha256.Size)
+	if _, err := io.ReadFull(conn, answer); err != nil {
+		return err
+	}
+	if !signed(shared, listenerSide, nonce, answer) {
+		return Unauthorized
+	}
+	return nil
+}
+
+// the sides sign their answers differently, so that the answer of one side can't
+// be passed off as the answer of the other
+const (
+	dialerSide   = "dialer"
+	listenerSide = "listener"
+)
+
+// if answer is the hmac of nonce, signed by side, under one of the shared secrets
+func signed(shared [][]byte, side string, nonce, answer []byte) bool {
+	for _, secret := range shared {
+		if hmac.Equal(answer, sign(secret, side, nonce)) {
+			return true
+		}
+	}
+	return false
+}
+
+func sign(secret []byte, side string, nonce []byte) []byte {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(side))
+	mac.Write(nonce)
+	return mac.Sum(nil)
+}
@@ -0,0 +1,115 @@
+// Copyright (c) 2015 Pagoda Box Inc
+//
+// This Source Code Form is subject to the terms of the Mozilla Public License, v.
+// 2.0. If a copy of the MPL was not distributed with this file, You can obtain one
+// at http://mozilla.org/MPL/2.0/.
+//
+
+package state_test
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"github.com/golang/mock/gomock"
+	"github.com/nanopack/yoke/state"
+	"github.com/nanopack/yoke/state/mock"
+	"io"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestAuth(test *testing.T) {
+	ctrl := gomock.NewController(test)
+	defer ctrl.Finish()
+
+	store := mock_state.NewMockStore(ctrl)
+	store.EXPECT().Read("states", "something", gomock.Any()).Return(fakeErr)
+	store.EXPECT().Write("states", "something", gomock.Any()).Return(nil)
+	local, err := state.NewLocalState("something", "wherever", "//here", store)
+	if err != nil {
+		test.Log(err)
+		test.FailNow()
+	}
+
+	// the server is halfway through a rotation and accepts both secrets
+	state.EnableAuth("new", "old")
+	defer state.EnableAuth()
+	listen, err := local.ExposeRPCEndpoint("tcp", "127.0.0.1:1241")
+	if err != nil {
+		test.Log(err)
+		test.FailNow()
+	}
+	defer listen.Close()
+
+	client := state.NewRemoteState("tcp", "127.0.0.1:1241", time.Second)
+	if _, err := client.GetRole(); err != nil {
+		test.Log(err)
+		test.Fail()
+	}
+	for secret, accepted := range map[string]bool{"new": true, "old": true, "rogue": false} {
+		if accepts("127.0.0.1:1241", secret) != accepted {
+			test.Logf("the secret '%v' should have been accepted: %v", secret, accepted)
+			test.Fail()
+		}
+	}
+
+	// the rotation is done with a reload, the endpoint that is already exposed
+	// turns the old secret away from then on
+	state.EnableAuth("new")
+	if accepts("127.0.0.1:1241", "old") || !accepts("127.0.0.1:1241", "new") {
+		test.Log("the endpoint should have switched to the secrets of the reload")
+		test.Fail()
+	}
+}
+
+// if the listener at address takes a dialer that answers its challenge with secret
+func accepts(address, secret string) bool {
+	conn, err := net.Dial("tcp", address)
+	if err != nil {
+		return false
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(time.Second))
+	challenge := make([]byte, 32)
+	if _, err := io.ReadFull(conn, challenge); err != nil {
+		return false
+	}
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte("dialer"))
+	mac.Write(challenge)
+	conn.Write(append(mac.Sum(nil), make([]byte, 32)...))
+	_, err = io.ReadFull(conn, make([]byte, sha256.Size))
+	return err == nil
+}
+
+// a process that took the address of a node does not know the secret, so the
+// node that dialed it gives up on it
+func TestAuthListener(test *testing.T) {
+	listen, err := net.Listen("tcp", "127.0.0.1:1242")
+	if err != nil {
+		test.Log(err)
+		test.FailNow()
+	}
+	defer listen.Close()
+	go func() {
+		for {
+			conn, err := listen.Accept()
+			if err != nil {
+				return
+			}
+			// challenges the dialer, and answers without the secret
+			conn.Write(make([]byte, 32))
+			io.ReadFull(conn, make([]byte, 64))
+			conn.Write(make([]byte, 32))
+		}
+	}()
+
+	state.EnableAuth("secret")
+	defer state.EnableAuth()
+	client := state.NewRemoteState("tcp", "127.0.0.1:1242", time.Second)
+	if _, err := client.GetDBRole(); err != state.Unauthorized {
+		test.Logf("a listener without the secret should have been refused (%v)", err)
+		test.Fail()
+	}
+}
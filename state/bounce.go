@@ -31,6 +31,20 @@ type (
 		In      bool
 	}
 
+	BounceUint64 struct {
+		Address string
+		Method  string
+		Timeout time.Duration
+		In      string
+	}
+
+	BounceLag struct {
+		Address string
+		Method  string
+		Timeout time.Duration
+		In      string
+	}
+
 	BounceNil struct {
 		Address string
 		Method  string
@@ -53,7 +67,7 @@ func (wrap *StateRPC) BounceString(bounce BounceString, reply *string) error {
 	var next string
 	err := call("tcp", bounce.Address, bounce.Timeout, bounce.Method, bounce.In, &next)
 	if err == Timeout {
-		*reply = "dead"
+		*reply = string(Dead)
 		return nil
 	}
 	*reply = next
@@ -64,6 +78,14 @@ func (wrap *StateRPC) BounceBool(bounce BounceBool, reply *bool) error {
 	return call("tcp", bounce.Address, bounce.Timeout, bounce.Method, bounce.In, reply)
 }
 
+func (wrap *StateRPC) BounceUint64(bounce BounceUint64, reply *uint64) error {
+	return call("tcp", bounce.Address, bounce.Timeout, bounce.Method, bounce.In, reply)
+}
+
+func (wrap *StateRPC) BounceLag(bounce BounceLag, reply *LagReport) error {
+	return call("tcp", bounce.Address, bounce.Timeout, bounce.Method, bounce.In, reply)
+}
+
 func (wrap *StateRPC) BounceNil(bounce BounceNil, reply *Nil) error {
 	return call("tcp", bounce.Address, bounce.Timeout, bounce.Method, bounce.In, reply)
 }
@@ -108,6 +130,36 @@ func (bounce Bouncer) HasSynced() (bool, error) {
 	return synced, err
 }
 
+func (bounce Bouncer) GetPosition() (uint64, error) {
+	var position uint64
+	next := BounceUint64{
+		Address: bounce.location,
+		Timeout: bounce.bounce.timeout,
+		Method:  "StateRPC.GetPosition",
+	}
+	err := bounce.bounce.call("StateRPC.BounceUint64", next, &position)
+	return position, err
+}
+
+func (bounce Bouncer) SetPosition(position uint64) error {
+	return NotSupported
+}
+
+func (bounce Bouncer) Lag() (time.Duration, int64, error) {
+	var lag LagReport
+	next := BounceLag{
+		Address: bounce.location,
+		Timeout: bounce.bounce.timeout,
+		Method:  "StateRPC.Lag",
+	}
+	err := bounce.bounce.call("StateRPC.BounceLag", next, &lag)
+	return lag.Time, lag.Bytes, err
+}
+
+func (bounce Bouncer) SetLag(delay time.Duration, bytes int64) error {
+	return NotSupported
+}
+
 func (bounce Bouncer) Location() string {
 	return bounce.location
 }
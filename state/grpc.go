@@ -0,0 +1,560 @@
+// Copyright (c) 2015 Pagoda Box Inc
+//
+// This Source Code Form is subject to the terms of the Mozilla Public License, v.
+// 2.0. If a copy of the MPL was not distributed with this file, You can obtain one
+// at http://mozilla.org/MPL/2.0/.
+//
+
+// grpc.go is the grpc transport between the nodes and the monitor. It answers the
+// same questions as the net/rpc transport, with the messages of statepb, so that
+// tools in any language can ask a node about its state. Connections are secured
+// and authenticated the same way, with the [tls] certificates and the shared
+// secrets, and every call has a deadline.
+
+package state
+
+import (
+	"context"
+	"crypto/tls"
+	"github.com/nanopack/yoke/state/statepb"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/status"
+	"io"
+	"net"
+	"sync"
+	"time"
+)
+
+// the transports the nodes can talk to each other over, every node of a cluster
+// has to use the same one
+const (
+	NetRPC = "rpc"
+	GRPC   = "grpc"
+)
+
+var (
+	transport     = NetRPC
+	transportLock sync.RWMutex
+
+	// the connections to the other nodes, by location. grpc keeps them open and
+	// multiplexes the calls over them.
+	channels     = map[string]*grpc.ClientConn{}
+	channelsLock sync.Mutex
+)
+
+type (
+	// a state reachable over grpc, or one that is bounced off of it when bounce is
+	// set. Copies share where it is, like the copies of a remote state do.
+	grpcState struct {
+		*target
+		network string
+		bounce  string
+	}
+
+	stateGRPC struct {
+		statepb.UnimplementedStateServer
+		state *state
+	}
+
+	// a listener that only hands over the connections that authenticated
+	// themselves, without holding up the others while one of them does
+	authListener struct {
+		net.Listener
+		conns chan net.Conn
+		err   chan error
+	}
+)
+
+// SetTransport picks the transport the remote states created afterwards, and the
+// rpc endpoint, speak. Anything but GRPC is the net/rpc transport.
+func SetTransport(name string) {
+	transportLock.Lock()
+	defer transportLock.Unlock()
+	transport = name
+}
+
+func currentTransport() string {
+	transportLock.RLock()
+	defer transportLock.RUnlock()
+	return transport
+}
+
+func (local *state) exposeGRPC(network, location string) (io.Closer, error) {
+	listener, err := net.Listen(network, location)
+	if err != nil {
+		return nil, err
+	}
+	if certificates := currentCerts(); certificates != nil {
+		listener = tls.NewListener(listener, certificates.ServerConfig())
+	}
+
+	server := grpc.NewServer()
+	statepb.RegisterStateServer(server, &stateGRPC{state: local})
+	go server.Serve(newAuthListener(listener))
+	return listener, nil
+}
+
+func newAuthListener(listener net.Listener) *authListener {
+	auth := &authListener{Listener: listener, conns: make(chan net.Conn), err: make(chan error, 1)}
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				auth.err <- err
+				return
+			}
+			go func() {
+				if err := authenticateAccepted(conn); err != nil {
+					conn.Close()
+					return
+				}
+				auth.conns <- conn
+			}()
+		}
+	}()
+	return auth
+}
+
+func (auth *authListener) Accept() (net.Conn, error) {
+	select {
+	case conn := <-auth.conns:
+		return conn, nil
+	case err := <-auth.err:
+		return nil, err
+	}
+}
+
+// returns the client of the connection to location, the connection is made when
+// it is first needed
+func channel(network, location string) (statepb.StateClient, error) {
+	channelsLock.Lock()
+	defer channelsLock.Unlock()
+	conn, ok := channels[location]
+	if !ok {
+		var err error
+		conn, err = grpc.Dial(location,
+			grpc.WithTransportCredentials(insecure.NewCredentials()), // the dialer secures the connection itself
+			grpc.WithContextDialer(func(ctx context.Context, address string) (net.Conn, error) {
+				deadline, ok := ctx.Deadline()
+				if !ok {
+					deadline = time.Now().Add(authTimeout)
+				}
+				return connect(network, address, deadline)
+			}))
+		if err != nil {
+			return nil, err
+		}
+		channels[location] = conn
+	}
+	return statepb.NewStateClient(conn), nil
+}
+
+// a call that ran out of time timed out, like it does over net/rpc
+func fromStatus(err error) error {
+	if status.Code(err) == codes.DeadlineExceeded {
+		return Timeout
+	}
+	return err
+}
+
+// if a failed call might work when it is tried again, a call the other side
+// answered with an error is not retried
+func grpcRetryable(err error) bool {
+	return err == Timeout || status.Code(err) == codes.Unavailable
+}
+
+// makes the call with a deadline of the call timeout, retrying according to the
+// call policy
+func (c grpcState) call(name string, do func(context.Context, statepb.StateClient) error) error {
+	policy := currentCallPolicy()
+	delay := policy.Delay
+	err := c.try(name, do)
+	for retry := 0; retry < policy.Retries && grpcRetryable(err); retry++ {
+		<-time.After(delay)
+		delay *= 2
+		err = c.try(name, do)
+	}
+	return err
+}
+
+func (c grpcState) try(name string, do func(context.Context, statepb.StateClient) error) error {
+	location, timeout := c.current()
+	if err := inject(location); err != nil {
+		return err
+	}
+	client, err := channel(c.network, location)
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	version, err := c.negotiate(ctx, location, client)
+	if err != nil {
+		return err
+	}
+	if err := supports(location, version, name); err != nil {
+		return err
+	}
+	err = fromStatus(do(ctx, client))
+	if grpcRetryable(err) {
+		c.agreed(location, 0)
+	}
+	return err
+}
+
+// negotiates the version of the protocol with the node, like the net/rpc transport
+// does
+func (c grpcState) negotiate(ctx context.Context, location string, client statepb.StateClient) (int, error) {
+	if version := c.negotiated(); version != 0 {
+		return version, nil
+	}
+	theirs, err := client.Hello(ctx, &statepb.Versions{Version: ProtocolVersion, MinVersion: MinProtocolVersion})
+	if err != nil {
+		return 0, fromStatus(err)
+	}
+	version, err := agree(location, Hello{Version: int(theirs.Version), MinVersion: int(theirs.MinVersion)})
+	if err != nil {
+		return 0, err
+	}
+	c.agreed(location, version)
+	return version, nil
+}
+
+// the request a call starts with, it passes the call on when this state is bounced.
+// The bounced call gets half of the call timeout, so that a node that does not
+// answer it is reported as dead before the call itself runs out of time.
+func (c grpcState) request() *statepb.Request {
+	_, timeout := c.current()
+	return &statepb.Request{Bounce: c.bounce, BounceTimeoutMs: int64(timeout / 2 / time.Millisecond)}
+}
+
+func (c grpcState) Bounce(location string) State {
+	if c.bounce != "" {
+		return nil
+	}
+	return grpcState{target: c.target, network: c.network, bounce: location}
+}
+
+func (c grpcState) Location() string {
+	if c.bounce != "" {
+		return c.bounce
+	}
+	location, _ := c.current()
+	return location
+}
+
+func (c grpcState) Ready() {
+	for c.ping() != nil {
+		<-time.After(time.Second)
+	}
+}
+
+// asks once if the state can be reached
+func (c grpcState) ping() error {
+	return c.call("Ready", func(ctx context.Context, client statepb.StateClient) error {
+		_, err := client.Ready(ctx, c.request())
+		return err
+	})
+}
+
+// Ping asks once if the state can be reached, without retrying
+func (c grpcState) Ping() error {
+	return c.try("Ready", func(ctx context.Context, client statepb.StateClient) error {
+		_, err := client.Ready(ctx, c.request())
+		return err
+	})
+}
+
+func (c grpcState) GetDataDir() (dataDir string, err error) {
+	err = c.call("GetDataDir", func(ctx context.Context, client statepb.StateClient) error {
+		reply, err := client.GetDataDir(ctx, c.request())
+		dataDir = reply.GetValue()
+		return err
+	})
+	return dataDir, err
+}
+
+func (c grpcState) GetRole() (role string, err error) {
+	err = c.call("GetRole", func(ctx context.Context, client statepb.StateClient) error {
+		reply, err := client.GetRole(ctx, c.request())
+		role = reply.GetValue()
+		return err
+	})
+	return role, err
+}
+
+func (c grpcState) GetDBRole() (dbRole string, err error) {
+	err = c.call("GetDBRole", func(ctx context.Context, client statepb.StateClient) error {
+		reply, err := client.GetDBRole(ctx, c.request())
+		dbRole = reply.GetValue()
+		return err
+	})
+	return dbRole, err
+}
+
+func (c grpcState) SetDBRole(string) error {
+	return NotSupported
+}
+
+func (c grpcState) HasSynced() (synced bool, err error) {
+	err = c.call("HasSynced", func(ctx context.Context, client statepb.StateClient) error {
+		reply, err := client.HasSynced(ctx, c.request())
+		synced = reply.GetSynced()
+		return err
+	})
+	return synced, err
+}
+
+func (c grpcState) SetSynced(synced bool) error {
+	return c.call("SetSynced", func(ctx context.Context, client statepb.StateClient) error {
+		req := c.request()
+		_, err := client.SetSynced(ctx, &statepb.SetSyncedRequest{Bounce: req.Bounce, BounceTimeoutMs: req.BounceTimeoutMs, Synced: synced})
+		return err
+	})
+}
+
+func (c grpcState) GetPosition() (position uint64, err error) {
+	err = c.call("GetPosition", func(ctx context.Context, client statepb.StateClient) error {
+		reply, err := client.GetPosition(ctx, c.request())
+		position = reply.GetPosition()
+		return err
+	})
+	return position, err
+}
+
+func (c grpcState) SetPosition(uint64) error {
+	return NotSupported
+}
+
+func (c grpcState) Lag() (delay time.Duration, bytes int64, err error) {
+	err = c.call("Lag", func(ctx context.Context, client statepb.StateClient) error {
+		reply, err := client.Lag(ctx, c.request())
+		delay, bytes = time.Duration(reply.GetDelayNs()), reply.GetBytes()
+		return err
+	})
+	return delay, bytes, err
+}
+
+func (c grpcState) SetLag(time.Duration, int64) error {
+	return NotSupported
+}
+
+func (c grpcState) GetMaintenance() (maintenance Maintenance, err error) {
+	err = c.call("GetMaintenance", func(ctx context.Context, client statepb.StateClient) error {
+		reply, err := client.GetMaintenance(ctx, c.request())
+		maintenance = Maintenance{Enabled: reply.GetEnabled(), Changed: fromUnixNano(reply.GetChangedUnixNs())}
+		return err
+	})
+	return maintenance, err
+}
+
+func (c grpcState) SetMaintenance(Maintenance) error {
+	return NotSupported
+}
+
+func (c grpcState) GetPromotion() (promotion Promotion, err error) {
+	err = c.call("GetPromotion", func(ctx context.Context, client statepb.StateClient) error {
+		reply, err := client.GetPromotion(ctx, c.request())
+		promotion = Promotion{Promoted: reply.GetPromoted(), Changed: fromUnixNano(reply.GetChangedUnixNs())}
+		return err
+	})
+	return promotion, err
+}
+
+func (c grpcState) SetPromotion(Promotion) error {
+	return NotSupported
+}
+
+func (c grpcState) GetEpoch() (epoch Epoch, err error) {
+	err = c.call("GetEpoch", func(ctx context.Context, client statepb.StateClient) error {
+		reply, err := client.GetEpoch(ctx, c.request())
+		epoch = Epoch{Number: reply.GetNumber(), Location: reply.GetLocation()}
+		return err
+	})
+	return epoch, err
+}
+
+func (c grpcState) SetEpoch(Epoch) error {
+	return NotSupported
+}
+
+func (c grpcState) AcquireLease(cluster, holder string, ttl time.Duration) (lease Lease, err error) {
+	err = c.call("AcquireLease", func(ctx context.Context, client statepb.StateClient) error {
+		reply, err := client.AcquireLease(ctx, &statepb.LeaseRequest{Cluster: cluster, Holder: holder, TtlMs: int64(ttl / time.Millisecond)})
+		lease = Lease{Holder: reply.GetHolder(), Expires: fromUnixNano(reply.GetExpiresUnixNs())}
+		return err
+	})
+	return lease, err
+}
+
+func (c grpcState) ReleaseLease(cluster, holder string) error {
+	return c.call("ReleaseLease", func(ctx context.Context, client statepb.StateClient) error {
+		_, err := client.ReleaseLease(ctx, &statepb.LeaseRequest{Cluster: cluster, Holder: holder})
+		return err
+	})
+}
+
+func (c grpcState) GetSummary() (summary Summary, err error) {
+	err = c.call("GetSummary", func(ctx context.Context, client statepb.StateClient) error {
+		reply, err := client.GetSummary(ctx, c.request())
+		summary = Summary{Rule: reply.GetRule(), Decided: fromUnixNano(reply.GetDecidedUnixNs()), Peers: reply.GetPeers(), Version: reply.GetVersion(), SSHKey: reply.GetSshKey(), HostKey: reply.GetHostKey(), Compressions: reply.GetCompressions()}
+		return err
+	})
+	return summary, err
+}
+
+func (c grpcState) SetSummary(Summary) error {
+	return NotSupported
+}
+
+// the state a bounced call is passed on to, if the call is bounced
+func bounced(bounce string, timeoutMs int64) (grpcState, bool) {
+	next := grpcState{target: &target{location: bounce, timeout: time.Duration(timeoutMs) * time.Millisecond}, network: "tcp"}
+	return next, bounce != ""
+}
+
+func (wrap *stateGRPC) Hello(ctx context.Context, theirs *statepb.Versions) (*statepb.Versions, error) {
+	return &statepb.Versions{Version: ProtocolVersion, MinVersion: MinProtocolVersion}, nil
+}
+
+func (wrap *stateGRPC) Ready(ctx context.Context, req *statepb.Request) (*statepb.Empty, error) {
+	if next, ok := bounced(req.Bounce, req.BounceTimeoutMs); ok {
+		return &statepb.Empty{}, next.ping()
+	}
+	return &statepb.Empty{}, nil
+}
+
+func (wrap *stateGRPC) GetDataDir(ctx context.Context, req *statepb.Request) (*statepb.Value, error) {
+	if next, ok := bounced(req.Bounce, req.BounceTimeoutMs); ok {
+		dataDir, err := next.GetDataDir()
+		return &statepb.Value{Value: dataDir}, err
+	}
+	dataDir, err := wrap.state.GetDataDir()
+	return &statepb.Value{Value: dataDir}, err
+}
+
+func (wrap *stateGRPC) GetRole(ctx context.Context, req *statepb.Request) (*statepb.Value, error) {
+	if next, ok := bounced(req.Bounce, req.BounceTimeoutMs); ok {
+		role, err := next.GetRole()
+		return &statepb.Value{Value: role}, err
+	}
+	role, err := wrap.state.GetRole()
+	return &statepb.Value{Value: role}, err
+}
+
+// a node that the bounce can't reach in time is dead, as far as this node can tell
+func (wrap *stateGRPC) GetDBRole(ctx context.Context, req *statepb.Request) (*statepb.Value, error) {
+	if next, ok := bounced(req.Bounce, req.BounceTimeoutMs); ok {
+		dbRole, err := next.GetDBRole()
+		if err == Timeout {
+			dbRole, err = string(Dead), nil
+		}
+		return &statepb.Value{Value: dbRole}, err
+	}
+	dbRole, err := wrap.state.GetDBRole()
+	return &statepb.Value{Value: dbRole}, err
+}
+
+func (wrap *stateGRPC) HasSynced(ctx context.Context, req *statepb.Request) (*statepb.Synced, error) {
+	if next, ok := bounced(req.Bounce, req.BounceTimeoutMs); ok {
+		synced, err := next.HasSynced()
+		return &statepb.Synced{Synced: synced}, err
+	}
+	synced, err := wrap.state.HasSynced()
+	return &statepb.Synced{Synced: synced}, err
+}
+
+func (wrap *stateGRPC) SetSynced(ctx context.Context, req *statepb.SetSyncedRequest) (*statepb.Empty, error) {
+	if next, ok := bounced(req.Bounce, req.BounceTimeoutMs); ok {
+		return &statepb.Empty{}, next.SetSynced(req.Synced)
+	}
+	return &statepb.Empty{}, wrap.state.SetSynced(req.Synced)
+}
+
+func (wrap *stateGRPC) GetPosition(ctx context.Context, req *statepb.Request) (*statepb.Position, error) {
+	if next, ok := bounced(req.Bounce, req.BounceTimeoutMs); ok {
+		position, err := next.GetPosition()
+		return &statepb.Position{Position: position}, err
+	}
+	position, err := wrap.state.GetPosition()
+	return &statepb.Position{Position: position}, err
+}
+
+func (wrap *stateGRPC) Lag(ctx context.Context, req *statepb.Request) (*statepb.LagReport, error) {
+	if next, ok := bounced(req.Bounce, req.BounceTimeoutMs); ok {
+		delay, bytes, err := next.Lag()
+		return &statepb.LagReport{DelayNs: int64(delay), Bytes: bytes}, err
+	}
+	delay, bytes, err := wrap.state.Lag()
+	return &statepb.LagReport{DelayNs: int64(delay), Bytes: bytes}, err
+}
+
+func (wrap *stateGRPC) GetMaintenance(ctx context.Context, req *statepb.Request) (*statepb.Maintenance, error) {
+	maintenance, err := wrap.state.GetMaintenance()
+	if next, ok := bounced(req.Bounce, req.BounceTimeoutMs); ok {
+		maintenance, err = next.GetMaintenance()
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &statepb.Maintenance{Enabled: maintenance.Enabled, ChangedUnixNs: toUnixNano(maintenance.Changed)}, nil
+}
+
+func (wrap *stateGRPC) GetPromotion(ctx context.Context, req *statepb.Request) (*statepb.Promotion, error) {
+	promotion, err := wrap.state.GetPromotion()
+	if next, ok := bounced(req.Bounce, req.BounceTimeoutMs); ok {
+		promotion, err = next.GetPromotion()
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &statepb.Promotion{Promoted: promotion.Promoted, ChangedUnixNs: toUnixNano(promotion.Changed)}, nil
+}
+
+func (wrap *stateGRPC) GetEpoch(ctx context.Context, req *statepb.Request) (*statepb.Epoch, error) {
+	epoch, err := wrap.state.GetEpoch()
+	if next, ok := bounced(req.Bounce, req.BounceTimeoutMs); ok {
+		epoch, err = next.GetEpoch()
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &statepb.Epoch{Number: epoch.Number, Location: epoch.Location}, nil
+}
+
+func (wrap *stateGRPC) AcquireLease(ctx context.Context, req *statepb.LeaseRequest) (*statepb.Lease, error) {
+	lease, err := wrap.state.AcquireLease(req.Cluster, req.Holder, time.Duration(req.TtlMs)*time.Millisecond)
+	return &statepb.Lease{Holder: lease.Holder, ExpiresUnixNs: toUnixNano(lease.Expires)}, err
+}
+
+func (wrap *stateGRPC) ReleaseLease(ctx context.Context, req *statepb.LeaseRequest) (*statepb.Empty, error) {
+	return &statepb.Empty{}, wrap.state.ReleaseLease(req.Cluster, req.Holder)
+}
+
+func (wrap *stateGRPC) GetSummary(ctx context.Context, req *statepb.Request) (*statepb.Summary, error) {
+	summary, err := wrap.state.GetSummary()
+	if next, ok := bounced(req.Bounce, req.BounceTimeoutMs); ok {
+		summary, err = next.GetSummary()
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &statepb.Summary{Rule: summary.Rule, DecidedUnixNs: toUnixNano(summary.Decided), Peers: summary.Peers, Version: summary.Version, SshKey: summary.SSHKey, HostKey: summary.HostKey, Compressions: summary.Compressions}, nil
+}
+
+// a zero time is sent as zero, not as the nanoseconds before 1970 it would be
+func toUnixNano(t time.Time) int64 {
+	if t.IsZero() {
+		return 0
+	}
+	return t.UnixNano()
+}
+
+func fromUnixNano(nanos int64) time.Time {
+	if nanos == 0 {
+		return time.Time{}
+	}
+	return time.Unix(0, nanos)
+}
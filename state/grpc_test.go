@@ -0,0 +1,160 @@
+// Copyright (c) 2015 Pagoda Box Inc
+//
+// This Source Code Form is subject to the terms of the Mozilla Public License, v.
+// 2.0. If a copy of the MPL was not distributed with this file, You can obtain one
+// at http://mozilla.org/MPL/2.0/.
+//
+
+package state_test
+
+import (
+	"github.com/golang/mock/gomock"
+	"github.com/nanopack/yoke/state"
+	"github.com/nanopack/yoke/state/mock"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestGRPC(test *testing.T) {
+	ctrl := gomock.NewController(test)
+	defer ctrl.Finish()
+
+	state.SetTransport(state.GRPC)
+	defer state.SetTransport(state.NetRPC)
+
+	store := mock_state.NewMockStore(ctrl)
+	store.EXPECT().Read("states", "here", gomock.Any()).Return(fakeErr)
+	store.EXPECT().Write("states", "here", gomock.Any()).Return(nil)
+	monitor, err := state.NewLocalState("here", "right here", "//other", store)
+	if err != nil {
+		test.Log(err)
+		test.FailNow()
+	}
+	listen, err := monitor.ExposeRPCEndpoint("tcp", "127.0.0.1:1251")
+	if err != nil {
+		test.Log(err)
+		test.FailNow()
+	}
+	defer listen.Close()
+
+	store.EXPECT().Read("states", "something", gomock.Any()).Return(fakeErr)
+	store.EXPECT().Write("states", "something", gomock.Any()).Return(nil)
+	local, err := state.NewLocalState("something", "wherever", "//here", store)
+	if err != nil {
+		test.Log(err)
+		test.FailNow()
+	}
+	listen1, err := local.ExposeRPCEndpoint("tcp", "127.0.0.1:1252")
+	if err != nil {
+		test.Log(err)
+		test.FailNow()
+	}
+	defer listen1.Close()
+
+	client := state.NewRemoteState("tcp", "127.0.0.1:1252", time.Second)
+	store.EXPECT().Write("states", "something", gomock.Any()).Return(nil)
+	testState(client, store, test)
+
+	local.SetPosition(42)
+	local.SetLag(time.Second, 1024)
+	store.EXPECT().Write("states", "something", gomock.Any()).Return(nil)
+	local.SetSynced(false)
+
+	// the same calls work when they are bounced off of the other node
+	bounced := state.NewRemoteState("tcp", "127.0.0.1:1251", time.Second).Bounce("127.0.0.1:1252")
+	store.EXPECT().Write("states", "something", gomock.Any()).Return(nil)
+	testState(bounced, store, test)
+
+	position, err := bounced.GetPosition()
+	if err != nil || position != 42 {
+		test.Logf("wrong position was returned '%v' (%v)", position, err)
+		test.Fail()
+	}
+	delay, bytes, err := bounced.Lag()
+	if err != nil || delay != time.Second || bytes != 1024 {
+		test.Logf("wrong lag was returned '%v' '%v' (%v)", delay, bytes, err)
+		test.Fail()
+	}
+	// the promotion of a DR cluster is passed on like the maintenance switch
+	type promoter interface {
+		GetPromotion() (state.Promotion, error)
+		SetPromotion(state.Promotion) error
+	}
+	changed := time.Unix(0, 1500000000000000000)
+	store.EXPECT().Write("states", "something", gomock.Any()).Return(nil)
+	local.(promoter).SetPromotion(state.Promotion{Promoted: true, Changed: changed})
+	promotion, err := bounced.(promoter).GetPromotion()
+	if err != nil || !promotion.Promoted || !promotion.Changed.Equal(changed) {
+		test.Logf("wrong promotion was returned %+v (%v)", promotion, err)
+		test.Fail()
+	}
+	type epocher interface {
+		GetEpoch() (state.Epoch, error)
+		SetEpoch(state.Epoch) error
+	}
+	store.EXPECT().Write("states", "something", gomock.Any()).Return(nil)
+	local.(epocher).SetEpoch(state.Epoch{Number: 3, Location: "127.0.0.1:1251"})
+	epoch, err := bounced.(epocher).GetEpoch()
+	if err != nil || epoch.Number != 3 || epoch.Location != "127.0.0.1:1251" {
+		test.Logf("wrong epoch was returned %+v (%v)", epoch, err)
+		test.Fail()
+	}
+	if bounced.Location() != "127.0.0.1:1252" {
+		test.Logf("wrong location was returned '%v'", bounced.Location())
+		test.Fail()
+	}
+	if err := bounced.SetDBRole("backup"); err == nil {
+		test.Log("should not have been able to update the db state from remote")
+		test.Fail()
+	}
+
+	// a node that doesn't answer the bounce in time is dead
+	hung, err := net.Listen("tcp", "127.0.0.1:1254")
+	if err != nil {
+		test.Log(err)
+		test.FailNow()
+	}
+	defer hung.Close()
+	gone := state.NewRemoteState("tcp", "127.0.0.1:1251", 100*time.Millisecond).Bounce("127.0.0.1:1254")
+	if dbRole, err := gone.GetDBRole(); err != nil || dbRole != "dead" {
+		test.Logf("an unreachable node should have been dead '%v' (%v)", dbRole, err)
+		test.Fail()
+	}
+}
+
+func TestGRPCAuth(test *testing.T) {
+	ctrl := gomock.NewController(test)
+	defer ctrl.Finish()
+
+	state.SetTransport(state.GRPC)
+	defer state.SetTransport(state.NetRPC)
+
+	store := mock_state.NewMockStore(ctrl)
+	store.EXPECT().Read("states", "something", gomock.Any()).Return(fakeErr)
+	store.EXPECT().Write("states", "something", gomock.Any()).Return(nil)
+	local, err := state.NewLocalState("something", "wherever", "//here", store)
+	if err != nil {
+		test.Log(err)
+		test.FailNow()
+	}
+
+	state.EnableAuth("secret")
+	defer state.EnableAuth()
+	listen, err := local.ExposeRPCEndpoint("tcp", "127.0.0.1:1253")
+	if err != nil {
+		test.Log(err)
+		test.FailNow()
+	}
+	defer listen.Close()
+
+	client := state.NewRemoteState("tcp", "127.0.0.1:1253", time.Second)
+	if _, err := client.GetRole(); err != nil {
+		test.Log(err)
+		test.Fail()
+	}
+	if accepts("127.0.0.1:1253", "rogue") {
+		test.Log("a client with the wrong secret should have been turned away")
+		test.Fail()
+	}
+}
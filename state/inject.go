@@ -0,0 +1,42 @@
+// Copyright (c) 2015 Pagoda Box Inc
+//
+// This Source Code Form is subject to the terms of the Mozilla Public License, v.
+// 2.0. If a copy of the MPL was not distributed with this file, You can obtain one
+// at http://mozilla.org/MPL/2.0/.
+//
+
+package state
+
+import (
+	"sync"
+)
+
+// Injector is given every call a remote state of this process is about to make, so
+// that it can slow the call down or fail it, to test how the cluster copes. An
+// injected failure is seen as the call failing, and is retried like one.
+type Injector interface {
+	Inject(location string) error
+}
+
+var (
+	injector     Injector
+	injectorLock sync.RWMutex
+)
+
+// SetInjector changes what every remote state of this process injects into its
+// calls, nil injects nothing
+func SetInjector(i Injector) {
+	injectorLock.Lock()
+	defer injectorLock.Unlock()
+	injector = i
+}
+
+func inject(location string) error {
+	injectorLock.RLock()
+	i := injector
+	injectorLock.RUnlock()
+	if i == nil {
+		return nil
+	}
+	return i.Inject(location)
+}
@@ -6,6 +6,7 @@ package mock_state
 import (
 	gomock "github.com/golang/mock/gomock"
 	state "github.com/nanopack/yoke/state"
+	time "time"
 )
 
 // Mock of State interface
@@ -61,6 +62,17 @@ func (_mr *_MockStateRecorder) GetDataDir() *gomock.Call {
 	return _mr.mock.ctrl.RecordCall(_mr.mock, "GetDataDir")
 }
 
+func (_m *MockState) GetPosition() (uint64, error) {
+	ret := _m.ctrl.Call(_m, "GetPosition")
+	ret0, _ := ret[0].(uint64)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+func (_mr *_MockStateRecorder) GetPosition() *gomock.Call {
+	return _mr.mock.ctrl.RecordCall(_mr.mock, "GetPosition")
+}
+
 func (_m *MockState) GetRole() (string, error) {
 	ret := _m.ctrl.Call(_m, "GetRole")
 	ret0, _ := ret[0].(string)
@@ -83,6 +95,18 @@ func (_mr *_MockStateRecorder) HasSynced() *gomock.Call {
 	return _mr.mock.ctrl.RecordCall(_mr.mock, "HasSynced")
 }
 
+func (_m *MockState) Lag() (time.Duration, int64, error) {
+	ret := _m.ctrl.Call(_m, "Lag")
+	ret0, _ := ret[0].(time.Duration)
+	ret1, _ := ret[1].(int64)
+	ret2, _ := ret[2].(error)
+	return ret0, ret1, ret2
+}
+
+func (_mr *_MockStateRecorder) Lag() *gomock.Call {
+	return _mr.mock.ctrl.RecordCall(_mr.mock, "Lag")
+}
+
 func (_m *MockState) Location() string {
 	ret := _m.ctrl.Call(_m, "Location")
 	ret0, _ := ret[0].(string)
@@ -111,6 +135,26 @@ func (_mr *_MockStateRecorder) SetDBRole(arg0 interface{}) *gomock.Call {
 	return _mr.mock.ctrl.RecordCall(_mr.mock, "SetDBRole", arg0)
 }
 
+func (_m *MockState) SetLag(_param0 time.Duration, _param1 int64) error {
+	ret := _m.ctrl.Call(_m, "SetLag", _param0, _param1)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+func (_mr *_MockStateRecorder) SetLag(arg0, arg1 interface{}) *gomock.Call {
+	return _mr.mock.ctrl.RecordCall(_mr.mock, "SetLag", arg0, arg1)
+}
+
+func (_m *MockState) SetPosition(_param0 uint64) error {
+	ret := _m.ctrl.Call(_m, "SetPosition", _param0)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+func (_mr *_MockStateRecorder) SetPosition(arg0 interface{}) *gomock.Call {
+	return _mr.mock.ctrl.RecordCall(_mr.mock, "SetPosition", arg0)
+}
+
 func (_m *MockState) SetSynced(_param0 bool) error {
 	ret := _m.ctrl.Call(_m, "SetSynced", _param0)
 	ret0, _ := ret[0].(error)
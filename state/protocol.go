@@ -0,0 +1,135 @@
+// Copyright (c) 2015 Pagoda Box Inc
+//
+// This Source Code Form is subject to the terms of the Mozilla Public License, v.
+// 2.0. If a copy of the MPL was not distributed with this file, You can obtain one
+// at http://mozilla.org/MPL/2.0/.
+//
+
+// protocol.go negotiates the version of the protocol two nodes talk, so that a
+// cluster can be upgraded one node at a time. Before its first call to another node
+// a remote state says hello with the versions it speaks, and the newest version
+// both sides speak is used. Two nodes that have no version in common fail every
+// call with an error that says which of them has to be upgraded, and so does a call
+// that was added in a version the other node does not speak.
+
+package state
+
+import (
+	"fmt"
+	"net/rpc"
+	"strings"
+	"time"
+)
+
+// the versions of the protocol this node speaks. Version 1 is what the nodes spoke
+// before they could say hello, version 3 added the promotion, the epoch and the
+// promotion lease.
+const (
+	ProtocolVersion    = 3
+	MinProtocolVersion = 1
+)
+
+// the version of the protocol each call was added in, the calls that are not
+// listed are answered in every version
+var since = map[string]int{
+	"GetPromotion": 3,
+	"GetEpoch":     3,
+	"AcquireLease": 3,
+	"ReleaseLease": 3,
+}
+
+type (
+	// Hello is what each side of a connection tells the other about the versions
+	// of the protocol it speaks
+	Hello struct {
+		Version    int
+		MinVersion int
+	}
+
+	// ProtocolError is returned for a node that speaks no version of the protocol
+	// this node does, or that speaks too old a version for the call
+	ProtocolError struct {
+		Location string
+		Theirs   Hello
+		Call     string // the call that needs a newer version, when it was one
+	}
+)
+
+func (err ProtocolError) Error() string {
+	if err.Call != "" {
+		return fmt.Sprintf("the node at %v speaks version %v of the protocol, %v needs version %v, '%v' has to be upgraded", err.Location, err.Theirs.Version, err.Call, since[err.Call], err.Location)
+	}
+	if err.Theirs.Version < MinProtocolVersion {
+		return fmt.Sprintf("the node at %v speaks version %v of the protocol, this node needs at least %v, '%v' has to be upgraded", err.Location, err.Theirs.Version, MinProtocolVersion, err.Location)
+	}
+	return fmt.Sprintf("the node at %v needs at least version %v of the protocol, this node speaks up to %v, this node has to be upgraded", err.Location, err.Theirs.MinVersion, ProtocolVersion)
+}
+
+// supports fails a call that the node at location does not answer in the version of
+// the protocol agreed with it
+func supports(location string, version int, call string) error {
+	if version < since[call] {
+		return ProtocolError{Location: location, Theirs: Hello{Version: version}, Call: call}
+	}
+	return nil
+}
+
+// agree returns the newest version of the protocol both sides speak
+func agree(location string, theirs Hello) (int, error) {
+	version := ProtocolVersion
+	if theirs.Version < version {
+		version = theirs.Version
+	}
+	if version < MinProtocolVersion || version < theirs.MinVersion {
+		return 0, ProtocolError{Location: location, Theirs: theirs}
+	}
+	return version, nil
+}
+
+// negotiates the version of the protocol with the node, once, and returns it. A
+// node that can't be reached is asked again on the next call, and so is a node with
+// no version in common, as it may be upgraded in the meantime.
+func (c remoteState) negotiate(location string, timeout time.Duration) (int, error) {
+	if version := c.negotiated(); version != 0 {
+		return version, nil
+	}
+
+	theirs := Hello{}
+	err := call(c.network, location, timeout, "StateRPC.Hello", Hello{Version: ProtocolVersion, MinVersion: MinProtocolVersion}, &theirs)
+	if server, ok := err.(rpc.ServerError); ok && strings.Contains(string(server), "can't find method") {
+		// the node is older than the hello
+		theirs, err = Hello{Version: 1, MinVersion: 1}, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	version, err := agree(location, theirs)
+	if err != nil {
+		return 0, err
+	}
+	c.agreed(location, version)
+	return version, nil
+}
+
+// the version of the protocol agreed with the node, 0 until it is
+func (t *target) negotiated() int {
+	t.RLock()
+	defer t.RUnlock()
+	return t.protocol
+}
+
+// remembers the version agreed with the node at location, 0 forgets it so that it
+// is negotiated again. A node that went away may come back as another version.
+func (t *target) agreed(location string, version int) {
+	t.Lock()
+	defer t.Unlock()
+	if t.location == location {
+		t.protocol = version
+	}
+}
+
+// Hello answers with the versions of the protocol this node speaks
+func (wrap *StateRPC) Hello(theirs Hello, reply *Hello) error {
+	*reply = Hello{Version: ProtocolVersion, MinVersion: MinProtocolVersion}
+	return nil
+}
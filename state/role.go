@@ -0,0 +1,74 @@
+// Copyright (c) 2015 Pagoda Box Inc
+//
+// This Source Code Form is subject to the terms of the Mozilla Public License, v.
+// 2.0. If a copy of the MPL was not distributed with this file, You can obtain one
+// at http://mozilla.org/MPL/2.0/.
+//
+
+package state
+
+import "fmt"
+
+type (
+	// DBRole is a state the database of a node can be in, the nodes advertise it
+	// to each other through their state
+	DBRole string
+
+	// Role is the part a node was given in the cluster by its config
+	Role string
+)
+
+const (
+	Initialized DBRole = "initialized" // nothing has been decided yet, the data may be missing
+	Single      DBRole = "single"      // writable, without a backup
+	Active      DBRole = "active"      // writable, with a backup that confirms every commit
+	Backup      DBRole = "backup"      // following the active node
+	Demoted     DBRole = "demoted"     // handed the active role over, waiting to follow the node that takes over
+	Upgrading   DBRole = "upgrading"   // a backup whose database is down to be upgraded
+	Dead        DBRole = "dead"        // the database is stopped
+)
+
+const (
+	Primary   Role = "primary"
+	Secondary Role = "secondary"
+	Monitor   Role = "monitor"
+)
+
+// DBRoles returns every db role a node can be in
+func DBRoles() []DBRole {
+	return []DBRole{Initialized, Single, Active, Backup, Demoted, Upgrading, Dead}
+}
+
+// Roles returns every role a node can be given
+func Roles() []Role {
+	return []Role{Primary, Secondary, Monitor}
+}
+
+// Valid checks that role is one of DBRoles
+func (role DBRole) Valid() bool {
+	for _, known := range DBRoles() {
+		if role == known {
+			return true
+		}
+	}
+	return false
+}
+
+// Valid checks that role is one of Roles
+func (role Role) Valid() bool {
+	for _, known := range Roles() {
+		if role == known {
+			return true
+		}
+	}
+	return false
+}
+
+// ParseDBRole returns the db role named by role, or an error for a role that does
+// not exist
+func ParseDBRole(role string) (DBRole, error) {
+	if !DBRole(role).Valid() {
+		return "", fmt.Errorf("'%v' is not a db role", role)
+	}
+	return DBRole(role), nil
+}
@@ -8,10 +8,14 @@
 package state
 
 import (
+	"crypto/tls"
 	"errors"
 	"io"
 	"net"
 	"net/rpc"
+	"reflect"
+	"strings"
+	"sync"
 	"time"
 )
 
@@ -26,10 +30,24 @@ type (
 		err    error
 	}
 
+	// copies of a remote state share where it is, so that relocating one of
+	// them relocates all of them
 	remoteState struct {
+		*target
+		network string
+	}
+
+	target struct {
+		sync.RWMutex
 		timeout  time.Duration
 		location string
-		network  string
+		protocol int // the version of the protocol agreed with the node, 0 until it is
+	}
+
+	// Relocator is a state whose address and call timeout can be changed after it
+	// was created, the remote states are
+	Relocator interface {
+		Relocate(location string, timeout time.Duration)
 	}
 
 	StateRPC struct {
@@ -37,10 +55,40 @@ type (
 	}
 
 	Nil struct{}
+
+	// CallPolicy is how calls to a remote state are retried when they fail or time
+	// out. Every call is safe to repeat, and a call the other side answered with an
+	// error is not retried.
+	CallPolicy struct {
+		Retries int           // 0 never retries
+		Delay   time.Duration // how long to wait before the first retry, it doubles after every retry
+	}
+)
+
+var (
+	callPolicy     CallPolicy
+	callPolicyLock sync.RWMutex
 )
 
-// Starts the RPC listening server, enables remote communication with local state objects
+// SetCallPolicy changes how every remote state of this process retries its calls
+func SetCallPolicy(policy CallPolicy) {
+	callPolicyLock.Lock()
+	defer callPolicyLock.Unlock()
+	callPolicy = policy
+}
+
+func currentCallPolicy() CallPolicy {
+	callPolicyLock.RLock()
+	defer callPolicyLock.RUnlock()
+	return callPolicy
+}
+
+// Starts the RPC listening server, enables remote communication with local state
+// objects. It speaks the transport the process was set to.
 func (local *state) ExposeRPCEndpoint(network, location string) (io.Closer, error) {
+	if currentTransport() == GRPC {
+		return local.exposeGRPC(network, location)
+	}
 	wrap := StateRPC{
 		state: local,
 	}
@@ -55,42 +103,158 @@ func (local *state) ExposeRPCEndpoint(network, location string) (io.Closer, erro
 	if err != nil {
 		return nil, err
 	}
+	if certificates := currentCerts(); certificates != nil {
+		listener = tls.NewListener(listener, certificates.ServerConfig())
+	}
 
-	go server.Accept(listener)
+	go accept(server, listener)
 	return listener, nil
 }
 
-// Creates and returns a State that represents a state reachable over an rpc connection
+// Creates and returns a State that represents a state reachable over an rpc connection,
+// in the transport the process was set to
 func NewRemoteState(network, location string, timeout time.Duration) State {
+	if currentTransport() == GRPC {
+		return grpcState{target: &target{timeout: timeout, location: location}, network: network}
+	}
 	remote := remoteState{
-		timeout:  timeout,
-		network:  network,
-		location: location,
+		target:  &target{timeout: timeout, location: location},
+		network: network,
 	}
 	return remote
 }
 
+// makes a single call, giving up on it after timeout. The reply is decoded into a
+// copy of out, so that a call that is given up on can't write to out later.
 func call(network, location string, timeout time.Duration, method string, in interface{}, out interface{}) error {
+	reply := reflect.New(reflect.TypeOf(out).Elem())
 	res := make(chan error, 1)
 	go func() {
-		client, err := rpc.Dial(network, location)
+		// the deadline closes a hung connection, so the call can't linger
+		client, err := dial(network, location, time.Now().Add(timeout))
 		if err != nil {
 			res <- err
 			return
 		}
 		defer client.Close()
-		res <- client.Call(method, in, out)
+		res <- client.Call(method, in, reply.Interface())
 	}()
 	select {
 	case err := <-res:
+		if err == nil {
+			reflect.ValueOf(out).Elem().Set(reply.Elem())
+		}
 		return err
 	case <-time.After(timeout):
 		return Timeout
 	}
 }
 
+// connects to the rpc endpoint at location, over tls when it is enabled. The
+// connection is closed once the deadline has passed.
+func dial(network, location string, deadline time.Time) (*rpc.Client, error) {
+	conn, err := connect(network, location, deadline)
+	if err != nil {
+		return nil, err
+	}
+	conn.SetDeadline(deadline)
+	return rpc.NewClient(conn), nil
+}
+
+// opens an authenticated connection to location, over tls when it is enabled
+func connect(network, location string, deadline time.Time) (net.Conn, error) {
+	dialer := &net.Dialer{Deadline: deadline}
+	var conn net.Conn
+	var err error
+	if certificates := currentCerts(); certificates != nil {
+		conn, err = tls.DialWithDialer(dialer, network, location, certificates.ClientConfig(location))
+	} else {
+		conn, err = dialer.Dial(network, location)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := authenticateDialed(conn); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return conn, nil
+}
+
+// if a failed call might work when it is tried again
+func retryable(err error) bool {
+	switch err.(type) {
+	case nil, rpc.ServerError, ProtocolError:
+		return false
+	}
+	return err != Unauthorized
+}
+
+// serves every connection that can authenticate itself with one of the secrets
+func accept(server *rpc.Server, listener net.Listener) {
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		go func() {
+			if err := authenticateAccepted(conn); err != nil {
+				conn.Close()
+				return
+			}
+			server.ServeConn(conn)
+		}()
+	}
+}
+
+// calls the remote state, retrying according to the call policy
 func (c remoteState) call(method string, in interface{}, out interface{}) error {
-	return call(c.network, c.location, c.timeout, method, in, out)
+	policy := currentCallPolicy()
+	delay := policy.Delay
+	err := c.try(method, in, out)
+	for retry := 0; retry < policy.Retries && retryable(err); retry++ {
+		<-time.After(delay)
+		delay *= 2
+		err = c.try(method, in, out)
+	}
+	return err
+}
+
+// makes a single attempt at the call, negotiating the protocol first if it wasn't
+func (c remoteState) try(method string, in interface{}, out interface{}) error {
+	location, timeout := c.current()
+	if err := inject(location); err != nil {
+		return err
+	}
+	version, err := c.negotiate(location, timeout)
+	if err != nil {
+		return err
+	}
+	if err := supports(location, version, strings.TrimPrefix(method, "StateRPC.")); err != nil {
+		return err
+	}
+	err = call(c.network, location, timeout, method, in, out)
+	if retryable(err) {
+		c.agreed(location, 0)
+	}
+	return err
+}
+
+// Relocate points the remote state, and every copy of it, at location
+func (t *target) Relocate(location string, timeout time.Duration) {
+	t.Lock()
+	defer t.Unlock()
+	if t.location != location {
+		t.protocol = 0
+	}
+	t.location = location
+	t.timeout = timeout
+}
+
+func (t *target) current() (string, time.Duration) {
+	t.RLock()
+	defer t.RUnlock()
+	return t.location, t.timeout
 }
 
 func (c remoteState) Ready() {
@@ -99,6 +263,11 @@ func (c remoteState) Ready() {
 	}
 }
 
+// Ping asks once if the state can be reached, without retrying
+func (c remoteState) Ping() error {
+	return c.try("StateRPC.Ready", Nil{}, &Nil{})
+}
+
 func (c remoteState) SetSynced(synced bool) error {
 	var out bool
 	return c.call("StateRPC.SetSynced", synced, &out)
@@ -110,8 +279,29 @@ func (c remoteState) HasSynced() (bool, error) {
 	return synced, err
 }
 
+func (c remoteState) GetPosition() (uint64, error) {
+	var position uint64
+	err := c.call("StateRPC.GetPosition", "", &position)
+	return position, err
+}
+
+func (c remoteState) SetPosition(position uint64) error {
+	return NotSupported
+}
+
+func (c remoteState) Lag() (time.Duration, int64, error) {
+	var lag LagReport
+	err := c.call("StateRPC.Lag", "", &lag)
+	return lag.Time, lag.Bytes, err
+}
+
+func (c remoteState) SetLag(delay time.Duration, bytes int64) error {
+	return NotSupported
+}
+
 func (c remoteState) Location() string {
-	return c.location
+	location, _ := c.current()
+	return location
 }
 
 func (c remoteState) GetDataDir() (string, error) {
@@ -136,31 +326,131 @@ func (c remoteState) SetDBRole(role string) error {
 	return NotSupported
 }
 
+func (c remoteState) GetMaintenance() (Maintenance, error) {
+	var maintenance Maintenance
+	err := c.call("StateRPC.GetMaintenance", "", &maintenance)
+	return maintenance, err
+}
+
+func (c remoteState) SetMaintenance(maintenance Maintenance) error {
+	return NotSupported
+}
+
+func (c remoteState) GetPromotion() (Promotion, error) {
+	var promotion Promotion
+	err := c.call("StateRPC.GetPromotion", "", &promotion)
+	return promotion, err
+}
+
+func (c remoteState) SetPromotion(promotion Promotion) error {
+	return NotSupported
+}
+
+func (c remoteState) GetEpoch() (Epoch, error) {
+	var epoch Epoch
+	err := c.call("StateRPC.GetEpoch", "", &epoch)
+	return epoch, err
+}
+
+func (c remoteState) SetEpoch(epoch Epoch) error {
+	return NotSupported
+}
+
+func (c remoteState) AcquireLease(cluster, holder string, ttl time.Duration) (Lease, error) {
+	var lease Lease
+	err := c.call("StateRPC.AcquireLease", LeaseRequest{Cluster: cluster, Holder: holder, TTL: ttl}, &lease)
+	return lease, err
+}
+
+func (c remoteState) ReleaseLease(cluster, holder string) error {
+	var out Nil
+	return c.call("StateRPC.ReleaseLease", LeaseRequest{Cluster: cluster, Holder: holder}, &out)
+}
+
+func (c remoteState) GetSummary() (Summary, error) {
+	var summary Summary
+	err := c.call("StateRPC.GetSummary", "", &summary)
+	return summary, err
+}
+
+func (c remoteState) SetSummary(summary Summary) error {
+	return NotSupported
+}
+
 func (wrap *StateRPC) Ready(a Nil, b *Nil) error {
 	return nil
 }
 
 func (wrap *StateRPC) GetDataDir(arg string, reply *string) error {
-	*reply = wrap.state.DataDir
-	return nil
+	var err error
+	*reply, err = wrap.state.GetDataDir()
+	return err
 }
 
 func (wrap *StateRPC) GetRole(arg string, reply *string) error {
-	*reply = wrap.state.Role
-	return nil
+	var err error
+	*reply, err = wrap.state.GetRole()
+	return err
 }
 
 func (wrap *StateRPC) GetDBRole(arg string, reply *string) error {
-	*reply = wrap.state.DBRole
-	return nil
+	var err error
+	*reply, err = wrap.state.GetDBRole()
+	return err
 }
 
 func (wrap *StateRPC) HasSynced(arg bool, reply *bool) error {
-	*reply = wrap.state.synced
-	return nil
+	var err error
+	*reply, err = wrap.state.HasSynced()
+	return err
+}
+
+func (wrap *StateRPC) GetPosition(arg string, reply *uint64) error {
+	var err error
+	*reply, err = wrap.state.GetPosition()
+	return err
+}
+
+func (wrap *StateRPC) Lag(arg string, reply *LagReport) error {
+	delay, bytes, err := wrap.state.Lag()
+	*reply = LagReport{Time: delay, Bytes: bytes}
+	return err
 }
 
 func (wrap *StateRPC) SetSynced(sync bool, out *bool) error {
-	wrap.state.synced = sync
-	return nil
+	return wrap.state.SetSynced(sync)
+}
+
+func (wrap *StateRPC) GetMaintenance(arg string, reply *Maintenance) error {
+	var err error
+	*reply, err = wrap.state.GetMaintenance()
+	return err
+}
+
+func (wrap *StateRPC) GetPromotion(arg string, reply *Promotion) error {
+	var err error
+	*reply, err = wrap.state.GetPromotion()
+	return err
+}
+
+func (wrap *StateRPC) GetEpoch(arg string, reply *Epoch) error {
+	var err error
+	*reply, err = wrap.state.GetEpoch()
+	return err
+}
+
+func (wrap *StateRPC) AcquireLease(req LeaseRequest, reply *Lease) error {
+	lease, err := wrap.state.AcquireLease(req.Cluster, req.Holder, req.TTL)
+	*reply = lease
+	return err
+}
+
+func (wrap *StateRPC) ReleaseLease(req LeaseRequest, out *Nil) error {
+	return wrap.state.ReleaseLease(req.Cluster, req.Holder)
+}
+
+func (wrap *StateRPC) GetSummary(arg string, reply *Summary) error {
+	var err error
+	*reply, err = wrap.state.GetSummary()
+	return err
 }
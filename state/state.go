@@ -9,6 +9,8 @@ package state
 
 import (
 	"io"
+	"sync"
+	"time"
 )
 
 type (
@@ -20,6 +22,8 @@ type (
 	LocalState interface {
 		State
 		ExposeRPCEndpoint(string, string) (io.Closer, error)
+		History() History
+		RememberPeer(location, dbRole string) error
 	}
 
 	State interface {
@@ -30,43 +34,194 @@ type (
 		SetDBRole(string) error
 		HasSynced() (bool, error)
 		SetSynced(bool) error
+		GetPosition() (uint64, error)
+		SetPosition(uint64) error
+		Lag() (time.Duration, int64, error)
+		SetLag(time.Duration, int64) error
 		Location() string
 		Bounce(location string) State
 	}
 
+	// LagReport is how far a backup was behind the active node the last time it
+	// could see it
+	LagReport struct {
+		Time  time.Duration
+		Bytes int64
+	}
+
+	// History is what a node knew about the cluster the last time it ran, it is
+	// read back from the store when the node starts again
+	History struct {
+		DBRole string            // the last role the database ran as, before it was stopped
+		Synced bool              // if the node had been fully synced by the active node
+		Peers  map[string]string // the db role each peer was last seen in, by location
+	}
+
+	// Maintenance is a cluster wide switch that stops every decider from making
+	// automatic transitions. Nodes pass it on to each other, when they disagree the
+	// newest change wins.
+	Maintenance struct {
+		Enabled bool
+		Changed time.Time
+	}
+
+	// Promotion is the cluster wide switch that makes a DR cluster stop tracking
+	// the cluster it is the DR cluster of, and take writes itself. Nodes pass it on
+	// to each other like the maintenance switch, the newest change wins.
+	Promotion struct {
+		Promoted bool
+		Changed  time.Time
+	}
+
+	// Epoch counts the times a node of the cluster started taking writes, and names
+	// the node that did the last time. Nodes pass on the highest one they have seen,
+	// so that a client asking two nodes where to write can tell which answer is newer.
+	Epoch struct {
+		Number   uint64
+		Location string // the node that took writes in this epoch
+	}
+
+	// Lease is the lock on the promotion of the cluster, the monitor hands it to one
+	// node at a time. A node only starts taking writes while it holds the lease, and
+	// renews it for as long as it does.
+	Lease struct {
+		Holder  string // the node that holds the lease, empty while no one does
+		Expires time.Time
+	}
+
+	// LeaseRequest asks for the lease of cluster for holder, for ttl from now. The
+	// cluster is empty unless the nodes run several databases.
+	LeaseRequest struct {
+		Cluster string
+		Holder  string
+		TTL     time.Duration
+	}
+
+	// Summary is what a node tells the others about how it sees the cluster, so
+	// that any node can answer for all of them. It is not persisted.
+	Summary struct {
+		Rule         string            // the row of the transition table the node last went by
+		Decided      time.Time         // when it went by it
+		Peers        map[string]string // how each peer was reached by the last recheck, by location: 'direct', 'bounced' or 'unreachable'
+		Version      string            // of the database the node runs, empty when it doesn't know
+		SSHKey       string            // the public key the node copies the data over ssh with, with [ssh] manage
+		HostKey      string            // the public host key of the sshd of the node, which the others pin
+		Compressions []string          // the data can be copied to the node with, best first, empty when it doesn't say
+	}
+
 	state struct {
-		store   Store
-		synced  bool
-		Role    string
-		DBRole  string
-		Address string
-		DataDir string
+		// guards the fields below, the rpc server and the decider use the state at
+		// the same time
+		lock sync.Mutex
+
+		store      Store
+		synced     bool
+		position   uint64
+		lag        LagReport
+		summary    Summary
+		history    History
+		Role       string
+		DBRole     string
+		Address    string
+		DataDir    string
+		LastDBRole string
+		LastSynced bool
+		Peers      map[string]string
+		Maint      Maintenance
+		Promotion  Promotion
+		Epoch      Epoch
+
+		// the leases the node hands out as the monitor, one per cluster, and when it
+		// started. They are not persisted, so no lease is handed out until one could
+		// have run out since.
+		leases  map[string]Lease
+		started time.Time
 	}
 )
 
 var states = "states"
 
+// record is what is written to the store of a state, it has the exported fields of
+// the state and reads back into one
+type record struct {
+	Role       string
+	DBRole     string
+	Address    string
+	DataDir    string
+	LastDBRole string
+	LastSynced bool
+	Peers      map[string]string
+	Maint      Maintenance
+	Promotion  Promotion
+	Epoch      Epoch
+}
+
+// guards the lease, it is asked for by every node at once
+var leaseLock sync.Mutex
+
 // Creates and returns a state that represents a state on the local machine.
 func NewLocalState(role, location, dataDir string, store Store) (LocalState, error) {
-	newState := state{}
+	saved := record{}
 
 	// if we can't grab the previous state from the store, lets create a new one
-	if err := store.Read(states, role, &newState); err != nil {
-		newState = state{
+	if err := store.Read(states, role, &saved); err != nil {
+		saved = record{
 			DataDir: dataDir,
 			Role:    role,
-			DBRole:  "initialized",
-			synced:  false,
+			DBRole:  string(Initialized),
 			Address: location,
 		}
-		// something is wrong if we can't newState the new state, so return the error
-		if err = store.Write(states, role, &newState); err != nil {
+		// something is wrong if we can't save the new state, so return the error
+		if err = store.Write(states, role, &saved); err != nil {
 			return nil, err
 		}
 	}
-	newState.store = store
-	newState.synced = false
-	return &newState, nil
+	newState := &state{
+		store:      store,
+		started:    time.Now(),
+		Role:       saved.Role,
+		DBRole:     saved.DBRole,
+		Address:    saved.Address,
+		DataDir:    saved.DataDir,
+		LastDBRole: saved.LastDBRole,
+		LastSynced: saved.LastSynced,
+		Peers:      map[string]string{},
+		Maint:      saved.Maint,
+		Promotion:  saved.Promotion,
+		Epoch:      saved.Epoch,
+	}
+	// the history keeps the peers as they were, RememberPeer changes the live ones
+	newState.history = History{
+		DBRole: saved.LastDBRole,
+		Synced: saved.LastSynced,
+		Peers:  map[string]string{},
+	}
+	for location, dbRole := range saved.Peers {
+		newState.Peers[location] = dbRole
+		newState.history.Peers[location] = dbRole
+	}
+	return newState, nil
+}
+
+// save writes the persisted fields of the state to the store, the lock has to be
+// held. The peers are copied, so the store marshals a map no one writes to.
+func (state *state) save() error {
+	saved := record{
+		Role:       state.Role,
+		DBRole:     state.DBRole,
+		Address:    state.Address,
+		DataDir:    state.DataDir,
+		LastDBRole: state.LastDBRole,
+		LastSynced: state.LastSynced,
+		Peers:      make(map[string]string, len(state.Peers)),
+		Maint:      state.Maint,
+		Promotion:  state.Promotion,
+		Epoch:      state.Epoch,
+	}
+	for location, dbRole := range state.Peers {
+		saved.Peers[location] = dbRole
+	}
+	return state.store.Write(states, state.Role, &saved)
 }
 
 func (state *state) Ready() {}
@@ -77,11 +232,49 @@ func (state *state) Bounce(location string) State {
 }
 
 func (state *state) HasSynced() (bool, error) {
+	state.lock.Lock()
+	defer state.lock.Unlock()
 	return state.synced, nil
 }
 
 func (state *state) SetSynced(synced bool) error {
+	state.lock.Lock()
+	defer state.lock.Unlock()
 	state.synced = synced
+	if state.LastSynced == synced {
+		return nil
+	}
+	state.LastSynced = synced
+	return state.save()
+}
+
+func (state *state) GetPosition() (uint64, error) {
+	state.lock.Lock()
+	defer state.lock.Unlock()
+	return state.position, nil
+}
+
+// the position is how far along the replication stream the database is, it is not
+// persisted as it is only meaningful while the database is running
+func (state *state) SetPosition(position uint64) error {
+	state.lock.Lock()
+	defer state.lock.Unlock()
+	state.position = position
+	return nil
+}
+
+func (state *state) Lag() (time.Duration, int64, error) {
+	state.lock.Lock()
+	defer state.lock.Unlock()
+	return state.lag.Time, state.lag.Bytes, nil
+}
+
+// the lag is kept from the last time the active node was seen, so that it still
+// means something after the active node has gone away
+func (state *state) SetLag(delay time.Duration, bytes int64) error {
+	state.lock.Lock()
+	defer state.lock.Unlock()
+	state.lag = LagReport{Time: delay, Bytes: bytes}
 	return nil
 }
 
@@ -90,18 +283,141 @@ func (state *state) Location() string {
 }
 
 func (state *state) GetDataDir() (string, error) {
+	state.lock.Lock()
+	defer state.lock.Unlock()
 	return state.DataDir, nil
 }
 
 func (state *state) GetRole() (string, error) {
+	state.lock.Lock()
+	defer state.lock.Unlock()
 	return state.Role, nil
 }
 
 func (state *state) GetDBRole() (string, error) {
+	state.lock.Lock()
+	defer state.lock.Unlock()
 	return state.DBRole, nil
 }
 
+// SetDBRole records the db role the database is in, a role that is not one of
+// DBRoles is refused
 func (state *state) SetDBRole(role string) error {
+	state.lock.Lock()
+	defer state.lock.Unlock()
+	if _, err := ParseDBRole(role); err != nil {
+		return err
+	}
 	state.DBRole = role
-	return state.store.Write(states, state.Role, state)
+	if DBRole(role) != Dead {
+		state.LastDBRole = role
+	}
+	return state.save()
+}
+
+// History returns what the node knew when it last ran, it does not change while
+// the node is running
+func (state *state) History() History {
+	return state.history
+}
+
+// RememberPeer records the db role a peer was seen in, so that it is still known
+// after a restart
+func (state *state) RememberPeer(location, dbRole string) error {
+	state.lock.Lock()
+	defer state.lock.Unlock()
+	if state.Peers[location] == dbRole {
+		return nil
+	}
+	state.Peers[location] = dbRole
+	return state.save()
+}
+
+func (state *state) GetMaintenance() (Maintenance, error) {
+	state.lock.Lock()
+	defer state.lock.Unlock()
+	return state.Maint, nil
+}
+
+// the maintenance switch is persisted so that a node restarted during maintenance
+// does not start making transitions again
+func (state *state) SetMaintenance(maintenance Maintenance) error {
+	state.lock.Lock()
+	defer state.lock.Unlock()
+	state.Maint = maintenance
+	return state.save()
+}
+
+func (state *state) GetPromotion() (Promotion, error) {
+	state.lock.Lock()
+	defer state.lock.Unlock()
+	return state.Promotion, nil
+}
+
+// the promotion is persisted so that a node of a promoted DR cluster does not go
+// back to tracking the other cluster when it is restarted
+func (state *state) SetPromotion(promotion Promotion) error {
+	state.lock.Lock()
+	defer state.lock.Unlock()
+	state.Promotion = promotion
+	return state.save()
+}
+
+func (state *state) GetEpoch() (Epoch, error) {
+	state.lock.Lock()
+	defer state.lock.Unlock()
+	return state.Epoch, nil
+}
+
+// the epoch is persisted so that a restarted node doesn't count the takeovers of
+// the cluster from 0 again
+func (state *state) SetEpoch(epoch Epoch) error {
+	state.lock.Lock()
+	defer state.lock.Unlock()
+	state.Epoch = epoch
+	return state.save()
+}
+
+func (state *state) GetSummary() (Summary, error) {
+	state.lock.Lock()
+	defer state.lock.Unlock()
+	return state.summary, nil
+}
+
+// the summary only describes the running node, so it is not written to the store
+func (state *state) SetSummary(summary Summary) error {
+	state.lock.Lock()
+	defer state.lock.Unlock()
+	state.summary = summary
+	return nil
+}
+
+// AcquireLease hands the lease of cluster to holder for ttl, when it holds the lease
+// already or no one does. The lease as it is afterwards is returned, whoever holds it.
+func (state *state) AcquireLease(cluster, holder string, ttl time.Duration) (Lease, error) {
+	leaseLock.Lock()
+	defer leaseLock.Unlock()
+	if state.leases == nil {
+		state.leases = map[string]Lease{}
+	}
+	lease := state.leases[cluster]
+	now := time.Now()
+	free := lease.Holder == "" || now.After(lease.Expires)
+	if lease.Holder == holder || (free && now.Sub(state.started) >= ttl) {
+		lease = Lease{Holder: holder, Expires: now.Add(ttl)}
+	} else if free {
+		lease = Lease{}
+	}
+	state.leases[cluster] = lease
+	return lease, nil
+}
+
+// ReleaseLease gives the lease of cluster up, when holder holds it
+func (state *state) ReleaseLease(cluster, holder string) error {
+	leaseLock.Lock()
+	defer leaseLock.Unlock()
+	if state.leases[cluster].Holder == holder {
+		delete(state.leases, cluster)
+	}
+	return nil
 }
@@ -8,16 +8,47 @@
 package state_test
 
 import (
+	"encoding/json"
 	"errors"
 	"github.com/golang/mock/gomock"
 	"github.com/nanopack/yoke/state"
 	"github.com/nanopack/yoke/state/mock"
+	"net"
+	"net/rpc"
+	"sync"
 	"testing"
 	"time"
 )
 
 var fakeErr = errors.New("general")
 
+// a store that keeps what is written as json, like the store on disk
+type jsonStore struct {
+	sync.Mutex
+	data map[string][]byte
+}
+
+func (store *jsonStore) Read(table, key string, v interface{}) error {
+	store.Lock()
+	defer store.Unlock()
+	data, ok := store.data[table+"/"+key]
+	if !ok {
+		return fakeErr
+	}
+	return json.Unmarshal(data, v)
+}
+
+func (store *jsonStore) Write(table, key string, v interface{}) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	store.Lock()
+	defer store.Unlock()
+	store.data[table+"/"+key] = data
+	return nil
+}
+
 func TestLocal(test *testing.T) {
 	ctrl := gomock.NewController(test)
 	defer ctrl.Finish()
@@ -40,11 +71,17 @@ func TestLocal(test *testing.T) {
 		test.FailNow()
 	}
 
+	// becoming synced is remembered
+	store.EXPECT().Write("states", "something", gomock.Any()).Return(nil)
 	testState(local, store, test)
 
 	// now for specific tests to local
+	if err := local.SetDBRole("testing"); err == nil {
+		test.Log("an unknown db role should have been refused")
+		test.Fail()
+	}
 	store.EXPECT().Write("states", "something", gomock.Any())
-	err = local.SetDBRole("testing")
+	err = local.SetDBRole("backup")
 	if err != nil {
 		test.Log(err)
 		test.FailNow()
@@ -55,7 +92,7 @@ func TestLocal(test *testing.T) {
 		test.Log(err)
 		test.FailNow()
 	}
-	if dbRole != "testing" {
+	if dbRole != "backup" {
 		test.Log("wrong dbrole was returned")
 		test.Fail()
 	}
@@ -90,11 +127,84 @@ func TestRpc(test *testing.T) {
 
 	client := state.NewRemoteState("tcp", "127.0.0.1:1234", time.Second)
 
+	store.EXPECT().Write("states", "something", gomock.Any()).Return(nil)
 	testState(client, store, test)
 
+	local.SetPosition(42)
+	position, err := client.GetPosition()
+	if err != nil {
+		test.Log(err)
+		test.FailNow()
+	}
+	if position != 42 {
+		test.Logf("wrong position was returned '%v'", position)
+		test.Fail()
+	}
+
+	local.SetLag(time.Second, 1024)
+	delay, bytes, err := client.Lag()
+	if err != nil {
+		test.Log(err)
+		test.FailNow()
+	}
+	if delay != time.Second || bytes != 1024 {
+		test.Logf("wrong lag was returned '%v' '%v'", delay, bytes)
+		test.Fail()
+	}
+
+	// every node gossips how it sees the cluster
+	type summarizer interface {
+		GetSummary() (state.Summary, error)
+		SetSummary(state.Summary) error
+	}
+	local.(summarizer).SetSummary(state.Summary{Rule: "follow", Peers: map[string]string{"127.0.0.1:2345": "bounced"}})
+	summary, err := client.(summarizer).GetSummary()
+	if err != nil {
+		test.Log(err)
+		test.FailNow()
+	}
+	if summary.Rule != "follow" || summary.Peers["127.0.0.1:2345"] != "bounced" {
+		test.Logf("wrong summary was returned %+v", summary)
+		test.Fail()
+	}
+
+	// the lease is handed to one node at a time, and only once the monitor ran for
+	// as long as a lease lasts
+	type leaser interface {
+		AcquireLease(cluster, holder string, ttl time.Duration) (state.Lease, error)
+		ReleaseLease(cluster, holder string) error
+	}
+	if lease, err := client.(leaser).AcquireLease("", "10.0.0.1:4400", time.Hour); err != nil || lease.Holder != "" {
+		test.Logf("should not have handed out the lease yet %+v (%v)", lease, err)
+		test.Fail()
+	}
+	if lease, err := client.(leaser).AcquireLease("", "10.0.0.1:4400", time.Nanosecond); err != nil || lease.Holder != "10.0.0.1:4400" {
+		test.Logf("should have handed out the lease %+v (%v)", lease, err)
+		test.Fail()
+	}
+	// the holder renews it for as long as it likes
+	if lease, err := client.(leaser).AcquireLease("", "10.0.0.1:4400", time.Hour); err != nil || !lease.Expires.After(time.Now().Add(time.Minute)) {
+		test.Logf("should have renewed the lease %+v (%v)", lease, err)
+		test.Fail()
+	}
+	if lease, err := client.(leaser).AcquireLease("", "10.0.0.2:4400", time.Hour); err != nil || lease.Holder != "10.0.0.1:4400" {
+		test.Logf("the lease should have been held by the first node %+v (%v)", lease, err)
+		test.Fail()
+	}
+	// the other databases of the nodes have leases of their own
+	if lease, err := client.(leaser).AcquireLease("billing", "10.0.0.2:4401", time.Nanosecond); err != nil || lease.Holder != "10.0.0.2:4401" {
+		test.Logf("should have handed out the lease of the other database %+v (%v)", lease, err)
+		test.Fail()
+	}
+	client.(leaser).ReleaseLease("", "10.0.0.1:4400")
+	if lease, err := client.(leaser).AcquireLease("", "10.0.0.2:4400", time.Millisecond); err != nil || lease.Holder != "10.0.0.2:4400" {
+		test.Logf("should have handed the given back lease out again %+v (%v)", lease, err)
+		test.Fail()
+	}
+
 	// now for tests specific to remote states
 
-	err = client.SetDBRole("testing")
+	err = client.SetDBRole("backup")
 	if err == nil {
 		test.Log("should not have been able to update the db state from remote")
 		test.Fail()
@@ -135,6 +245,8 @@ func TestBounce(test *testing.T) {
 		test.FailNow()
 	}
 
+	// becoming synced is remembered, as is every change after it
+	store.EXPECT().Write("states", "something", gomock.Any()).Return(nil).Times(3)
 	testState(remote, store, test)
 	test.Logf("now for the remote")
 
@@ -154,9 +266,31 @@ func TestBounce(test *testing.T) {
 
 	testState(bounced, store, test)
 
+	remote.SetPosition(42)
+	position, err := bounced.GetPosition()
+	if err != nil {
+		test.Log(err)
+		test.FailNow()
+	}
+	if position != 42 {
+		test.Logf("wrong position was returned '%v'", position)
+		test.Fail()
+	}
+
+	local.SetLag(time.Second, 1024)
+	delay, bytes, err := client.Lag()
+	if err != nil {
+		test.Log(err)
+		test.FailNow()
+	}
+	if delay != time.Second || bytes != 1024 {
+		test.Logf("wrong lag was returned '%v' '%v'", delay, bytes)
+		test.Fail()
+	}
+
 	// now for tests specific to remote states
 
-	err = bounced.SetDBRole("testing")
+	err = bounced.SetDBRole("backup")
 	if err == nil {
 		test.Log("should not have been able to update the db state from remote")
 		test.Fail()
@@ -231,3 +365,193 @@ func testState(client state.State, store *mock_state.MockStore, test *testing.T)
 	// really doesn't do anything...
 	client.Ready()
 }
+
+func TestRetries(test *testing.T) {
+	// a node that accepts connections but never answers
+	listen, err := net.Listen("tcp", "127.0.0.1:1242")
+	if err != nil {
+		test.Log(err)
+		test.FailNow()
+	}
+	defer listen.Close()
+	accepted := make(chan net.Conn, 10)
+	go func() {
+		for {
+			conn, err := listen.Accept()
+			if err != nil {
+				return
+			}
+			accepted <- conn
+		}
+	}()
+
+	state.SetCallPolicy(state.CallPolicy{Retries: 2, Delay: 10 * time.Millisecond})
+	defer state.SetCallPolicy(state.CallPolicy{})
+	client := state.NewRemoteState("tcp", "127.0.0.1:1242", 100*time.Millisecond)
+	if _, err := client.GetDBRole(); err == nil {
+		test.Log("the call should have timed out")
+		test.Fail()
+	}
+	for i := 0; i < 3; i++ {
+		select {
+		case conn := <-accepted:
+			conn.Close()
+		case <-time.After(time.Second):
+			test.Logf("the call should have been tried 3 times, not %v", i)
+			test.FailNow()
+		}
+	}
+	if len(accepted) != 0 {
+		test.Log("the call was tried too often")
+		test.Fail()
+	}
+}
+
+func TestRelocate(test *testing.T) {
+	original := state.NewRemoteState("tcp", "127.0.0.1:1243", time.Second)
+	copied := original
+	original.(state.Relocator).Relocate("127.0.0.1:1244", time.Second)
+	if copied.Location() != "127.0.0.1:1244" {
+		test.Logf("the copy was not relocated %v", copied.Location())
+		test.Fail()
+	}
+}
+
+func TestDBRoles(test *testing.T) {
+	for _, role := range state.DBRoles() {
+		if parsed, err := state.ParseDBRole(string(role)); err != nil || parsed != role {
+			test.Logf("'%v' did not parse (%v)", role, err)
+			test.Fail()
+		}
+	}
+	if _, err := state.ParseDBRole("master"); err == nil {
+		test.Log("an unknown db role was parsed")
+		test.Fail()
+	}
+	if state.Role("slave").Valid() {
+		test.Log("an unknown role was valid")
+		test.Fail()
+	}
+}
+
+// a node that speaks the protocol from before the hello
+type oldNode struct{}
+
+func (node *oldNode) GetRole(arg string, reply *string) error {
+	*reply = "primary"
+	return nil
+}
+
+// a node that only speaks newer versions of the protocol
+type newNode struct {
+	oldNode
+}
+
+func (node *newNode) Hello(theirs state.Hello, reply *state.Hello) error {
+	*reply = state.Hello{Version: state.ProtocolVersion + 2, MinVersion: state.ProtocolVersion + 1}
+	return nil
+}
+
+func serveFake(test *testing.T, location string, node interface{}) net.Listener {
+	server := rpc.NewServer()
+	if err := server.RegisterName("StateRPC", node); err != nil {
+		test.Log(err)
+		test.FailNow()
+	}
+	listen, err := net.Listen("tcp", location)
+	if err != nil {
+		test.Log(err)
+		test.FailNow()
+	}
+	go server.Accept(listen)
+	return listen
+}
+
+func TestProtocol(test *testing.T) {
+	old := serveFake(test, "127.0.0.1:1245", &oldNode{})
+	defer old.Close()
+	client := state.NewRemoteState("tcp", "127.0.0.1:1245", time.Second)
+	if role, err := client.GetRole(); err != nil || role != "primary" {
+		test.Logf("a node from before the hello should still be understood '%v' (%v)", role, err)
+		test.Fail()
+	}
+
+	// a node from before the promotion lease says which of them has to be upgraded
+	type leaser interface {
+		AcquireLease(cluster, holder string, ttl time.Duration) (state.Lease, error)
+	}
+	_, err := client.(leaser).AcquireLease("", "10.0.0.1:4400", time.Minute)
+	if protocol, ok := err.(state.ProtocolError); !ok || protocol.Call != "AcquireLease" {
+		test.Logf("an old node should have been reported for the lease (%v)", err)
+		test.Fail()
+	}
+
+	newer := serveFake(test, "127.0.0.1:1246", &newNode{})
+	defer newer.Close()
+	client = state.NewRemoteState("tcp", "127.0.0.1:1246", time.Second)
+	_, err = client.GetRole()
+	if _, ok := err.(state.ProtocolError); !ok {
+		test.Logf("a node without a version in common should have been refused (%v)", err)
+		test.Fail()
+	}
+}
+
+func TestRestart(test *testing.T) {
+	store := &jsonStore{data: map[string][]byte{}}
+	local, err := state.NewLocalState("primary", "127.0.0.1:4400", "/data", store)
+	if err != nil {
+		test.Log(err)
+		test.FailNow()
+	}
+	local.SetDBRole("backup")
+	local.SetSynced(true)
+	local.RememberPeer("127.0.0.1:4401", "active")
+
+	restarted, err := state.NewLocalState("primary", "127.0.0.1:4400", "/data", store)
+	if err != nil {
+		test.Log(err)
+		test.FailNow()
+	}
+	// the peer comes back empty, the history still has it as it was
+	restarted.RememberPeer("127.0.0.1:4401", "initialized")
+	history := restarted.History()
+	if history.DBRole != "backup" || !history.Synced || history.Peers["127.0.0.1:4401"] != "active" {
+		test.Logf("the history changed while the node was running %+v", history)
+		test.Fail()
+	}
+
+	again, err := state.NewLocalState("primary", "127.0.0.1:4400", "/data", store)
+	if err != nil {
+		test.Log(err)
+		test.FailNow()
+	}
+	if peers := again.History().Peers; peers["127.0.0.1:4401"] != "initialized" {
+		test.Logf("the peer was not remembered %v", peers)
+		test.Fail()
+	}
+}
+
+// the rpc server and the decider write the state at the same time
+func TestConcurrentWrites(test *testing.T) {
+	store := &jsonStore{data: map[string][]byte{}}
+	local, err := state.NewLocalState("primary", "127.0.0.1:4400", "/data", store)
+	if err != nil {
+		test.Log(err)
+		test.FailNow()
+	}
+	var group sync.WaitGroup
+	group.Add(2)
+	go func() {
+		defer group.Done()
+		for i := 0; i < 100; i++ {
+			local.SetSynced(i%2 == 0)
+		}
+	}()
+	go func() {
+		defer group.Done()
+		for i := 0; i < 100; i++ {
+			local.RememberPeer("127.0.0.1:4401", []string{"active", "single"}[i%2])
+		}
+	}()
+	group.Wait()
+}
@@ -0,0 +1,647 @@
+// Copyright (c) 2015 Pagoda Box Inc
+//
+// This Source Code Form is subject to the terms of the Mozilla Public License, v.
+// 2.0. If a copy of the MPL was not distributed with this file, You can obtain one
+// at http://mozilla.org/MPL/2.0/.
+//
+
+// The state of a yoke node, as the nodes and the monitor ask each other for it
+// when the grpc transport is used. Every call can be bounced: a call with a bounce
+// address is made by the node that got it, on the node at that address, and its
+// reply is passed back.
+
+// Code generated by protoc-gen-go-grpc. DO NOT EDIT.
+// versions:
+// - protoc-gen-go-grpc v1.3.0
+// - protoc             (unknown)
+// source: state.proto
+
+package statepb
+
+import (
+	context "context"
+	grpc "google.golang.org/grpc"
+	codes "google.golang.org/grpc/codes"
+	status "google.golang.org/grpc/status"
+)
+
+// This is a compile-time assertion to ensure that this generated file
+// is compatible with the grpc package it is being compiled against.
+// Requires gRPC-Go v1.32.0 or later.
+const _ = grpc.SupportPackageIsVersion7
+
+const (
+	State_Hello_FullMethodName          = "/yoke.state.State/Hello"
+	State_Ready_FullMethodName          = "/yoke.state.State/Ready"
+	State_GetDataDir_FullMethodName     = "/yoke.state.State/GetDataDir"
+	State_GetRole_FullMethodName        = "/yoke.state.State/GetRole"
+	State_GetDBRole_FullMethodName      = "/yoke.state.State/GetDBRole"
+	State_HasSynced_FullMethodName      = "/yoke.state.State/HasSynced"
+	State_SetSynced_FullMethodName      = "/yoke.state.State/SetSynced"
+	State_GetPosition_FullMethodName    = "/yoke.state.State/GetPosition"
+	State_Lag_FullMethodName            = "/yoke.state.State/Lag"
+	State_GetMaintenance_FullMethodName = "/yoke.state.State/GetMaintenance"
+	State_GetSummary_FullMethodName     = "/yoke.state.State/GetSummary"
+	State_GetPromotion_FullMethodName   = "/yoke.state.State/GetPromotion"
+	State_GetEpoch_FullMethodName       = "/yoke.state.State/GetEpoch"
+	State_AcquireLease_FullMethodName   = "/yoke.state.State/AcquireLease"
+	State_ReleaseLease_FullMethodName   = "/yoke.state.State/ReleaseLease"
+)
+
+// StateClient is the client API for State service.
+//
+// For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
+type StateClient interface {
+	// tells the other side which versions of the protocol this side speaks, the
+	// newest version both sides speak is used
+	Hello(ctx context.Context, in *Versions, opts ...grpc.CallOption) (*Versions, error)
+	Ready(ctx context.Context, in *Request, opts ...grpc.CallOption) (*Empty, error)
+	GetDataDir(ctx context.Context, in *Request, opts ...grpc.CallOption) (*Value, error)
+	GetRole(ctx context.Context, in *Request, opts ...grpc.CallOption) (*Value, error)
+	// a node that can't be reached through a bounce is 'dead'
+	GetDBRole(ctx context.Context, in *Request, opts ...grpc.CallOption) (*Value, error)
+	HasSynced(ctx context.Context, in *Request, opts ...grpc.CallOption) (*Synced, error)
+	SetSynced(ctx context.Context, in *SetSyncedRequest, opts ...grpc.CallOption) (*Empty, error)
+	GetPosition(ctx context.Context, in *Request, opts ...grpc.CallOption) (*Position, error)
+	Lag(ctx context.Context, in *Request, opts ...grpc.CallOption) (*LagReport, error)
+	GetMaintenance(ctx context.Context, in *Request, opts ...grpc.CallOption) (*Maintenance, error)
+	GetSummary(ctx context.Context, in *Request, opts ...grpc.CallOption) (*Summary, error)
+	GetPromotion(ctx context.Context, in *Request, opts ...grpc.CallOption) (*Promotion, error)
+	GetEpoch(ctx context.Context, in *Request, opts ...grpc.CallOption) (*Epoch, error)
+	// the lease on the promotion of the cluster, handed out by the monitor
+	AcquireLease(ctx context.Context, in *LeaseRequest, opts ...grpc.CallOption) (*Lease, error)
+	ReleaseLease(ctx context.Context, in *LeaseRequest, opts ...grpc.CallOption) (*Empty, error)
+}
+
+type stateClient struct {
+	cc grpc.ClientConnInterface
+}
+
+func NewStateClient(cc grpc.ClientConnInterface) StateClient {
+	return &stateClient{cc}
+}
+
+func (c *stateClient) Hello(ctx context.Context, in *Versions, opts ...grpc.CallOption) (*Versions, error) {
+	out := new(Versions)
+	err := c.cc.Invoke(ctx, State_Hello_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *stateClient) Ready(ctx context.Context, in *Request, opts ...grpc.CallOption) (*Empty, error) {
+	out := new(Empty)
+	err := c.cc.Invoke(ctx, State_Ready_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *stateClient) GetDataDir(ctx context.Context, in *Request, opts ...grpc.CallOption) (*Value, error) {
+	out := new(Value)
+	err := c.cc.Invoke(ctx, State_GetDataDir_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *stateClient) GetRole(ctx context.Context, in *Request, opts ...grpc.CallOption) (*Value, error) {
+	out := new(Value)
+	err := c.cc.Invoke(ctx, State_GetRole_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *stateClient) GetDBRole(ctx context.Context, in *Request, opts ...grpc.CallOption) (*Value, error) {
+	out := new(Value)
+	err := c.cc.Invoke(ctx, State_GetDBRole_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *stateClient) HasSynced(ctx context.Context, in *Request, opts ...grpc.CallOption) (*Synced, error) {
+	out := new(Synced)
+	err := c.cc.Invoke(ctx, State_HasSynced_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *stateClient) SetSynced(ctx context.Context, in *SetSyncedRequest, opts ...grpc.CallOption) (*Empty, error) {
+	out := new(Empty)
+	err := c.cc.Invoke(ctx, State_SetSynced_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *stateClient) GetPosition(ctx context.Context, in *Request, opts ...grpc.CallOption) (*Position, error) {
+	out := new(Position)
+	err := c.cc.Invoke(ctx, State_GetPosition_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *stateClient) Lag(ctx context.Context, in *Request, opts ...grpc.CallOption) (*LagReport, error) {
+	out := new(LagReport)
+	err := c.cc.Invoke(ctx, State_Lag_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *stateClient) GetMaintenance(ctx context.Context, in *Request, opts ...grpc.CallOption) (*Maintenance, error) {
+	out := new(Maintenance)
+	err := c.cc.Invoke(ctx, State_GetMaintenance_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *stateClient) GetSummary(ctx context.Context, in *Request, opts ...grpc.CallOption) (*Summary, error) {
+	out := new(Summary)
+	err := c.cc.Invoke(ctx, State_GetSummary_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *stateClient) GetPromotion(ctx context.Context, in *Request, opts ...grpc.CallOption) (*Promotion, error) {
+	out := new(Promotion)
+	err := c.cc.Invoke(ctx, State_GetPromotion_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *stateClient) GetEpoch(ctx context.Context, in *Request, opts ...grpc.CallOption) (*Epoch, error) {
+	out := new(Epoch)
+	err := c.cc.Invoke(ctx, State_GetEpoch_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *stateClient) AcquireLease(ctx context.Context, in *LeaseRequest, opts ...grpc.CallOption) (*Lease, error) {
+	out := new(Lease)
+	err := c.cc.Invoke(ctx, State_AcquireLease_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *stateClient) ReleaseLease(ctx context.Context, in *LeaseRequest, opts ...grpc.CallOption) (*Empty, error) {
+	out := new(Empty)
+	err := c.cc.Invoke(ctx, State_ReleaseLease_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// StateServer is the server API for State service.
+// All implementations must embed UnimplementedStateServer
+// for forward compatibility
+type StateServer interface {
+	// tells the other side which versions of the protocol this side speaks, the
+	// newest version both sides speak is used
+	Hello(context.Context, *Versions) (*Versions, error)
+	Ready(context.Context, *Request) (*Empty, error)
+	GetDataDir(context.Context, *Request) (*Value, error)
+	GetRole(context.Context, *Request) (*Value, error)
+	// a node that can't be reached through a bounce is 'dead'
+	GetDBRole(context.Context, *Request) (*Value, error)
+	HasSynced(context.Context, *Request) (*Synced, error)
+	SetSynced(context.Context, *SetSyncedRequest) (*Empty, error)
+	GetPosition(context.Context, *Request) (*Position, error)
+	Lag(context.Context, *Request) (*LagReport, error)
+	GetMaintenance(context.Context, *Request) (*Maintenance, error)
+	GetSummary(context.Context, *Request) (*Summary, error)
+	GetPromotion(context.Context, *Request) (*Promotion, error)
+	GetEpoch(context.Context, *Request) (*Epoch, error)
+	// the lease on the promotion of the cluster, handed out by the monitor
+	AcquireLease(context.Context, *LeaseRequest) (*Lease, error)
+	ReleaseLease(context.Context, *LeaseRequest) (*Empty, error)
+	mustEmbedUnimplementedStateServer()
+}
+
+// UnimplementedStateServer must be embedded to have forward compatible implementations.
+type UnimplementedStateServer struct {
+}
+
+func (UnimplementedStateServer) Hello(context.Context, *Versions) (*Versions, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Hello not implemented")
+}
+func (UnimplementedStateServer) Ready(context.Context, *Request) (*Empty, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Ready not implemented")
+}
+func (UnimplementedStateServer) GetDataDir(context.Context, *Request) (*Value, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method GetDataDir not implemented")
+}
+func (UnimplementedStateServer) GetRole(context.Context, *Request) (*Value, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method GetRole not implemented")
+}
+func (UnimplementedStateServer) GetDBRole(context.Context, *Request) (*Value, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method GetDBRole not implemented")
+}
+func (UnimplementedStateServer) HasSynced(context.Context, *Request) (*Synced, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method HasSynced not implemented")
+}
+func (UnimplementedStateServer) SetSynced(context.Context, *SetSyncedRequest) (*Empty, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method SetSynced not implemented")
+}
+func (UnimplementedStateServer) GetPosition(context.Context, *Request) (*Position, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method GetPosition not implemented")
+}
+func (UnimplementedStateServer) Lag(context.Context, *Request) (*LagReport, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Lag not implemented")
+}
+func (UnimplementedStateServer) GetMaintenance(context.Context, *Request) (*Maintenance, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method GetMaintenance not implemented")
+}
+func (UnimplementedStateServer) GetSummary(context.Context, *Request) (*Summary, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method GetSummary not implemented")
+}
+func (UnimplementedStateServer) GetPromotion(context.Context, *Request) (*Promotion, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method GetPromotion not implemented")
+}
+func (UnimplementedStateServer) GetEpoch(context.Context, *Request) (*Epoch, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method GetEpoch not implemented")
+}
+func (UnimplementedStateServer) AcquireLease(context.Context, *LeaseRequest) (*Lease, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method AcquireLease not implemented")
+}
+func (UnimplementedStateServer) ReleaseLease(context.Context, *LeaseRequest) (*Empty, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method ReleaseLease not implemented")
+}
+func (UnimplementedStateServer) mustEmbedUnimplementedStateServer() {}
+
+// UnsafeStateServer may be embedded to opt out of forward compatibility for this service.
+// Use of this interface is not recommended, as added methods to StateServer will
+// result in compilation errors.
+type UnsafeStateServer interface {
+	mustEmbedUnimplementedStateServer()
+}
+
+func RegisterStateServer(s grpc.ServiceRegistrar, srv StateServer) {
+	s.RegisterService(&State_ServiceDesc, srv)
+}
+
+func _State_Hello_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(Versions)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(StateServer).Hello(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: State_Hello_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(StateServer).Hello(ctx, req.(*Versions))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _State_Ready_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(Request)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(StateServer).Ready(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: State_Ready_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(StateServer).Ready(ctx, req.(*Request))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _State_GetDataDir_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(Request)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(StateServer).GetDataDir(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: State_GetDataDir_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(StateServer).GetDataDir(ctx, req.(*Request))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _State_GetRole_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(Request)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(StateServer).GetRole(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: State_GetRole_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(StateServer).GetRole(ctx, req.(*Request))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _State_GetDBRole_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(Request)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(StateServer).GetDBRole(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: State_GetDBRole_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(StateServer).GetDBRole(ctx, req.(*Request))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _State_HasSynced_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(Request)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(StateServer).HasSynced(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: State_HasSynced_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(StateServer).HasSynced(ctx, req.(*Request))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _State_SetSynced_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(SetSyncedRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(StateServer).SetSynced(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: State_SetSynced_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(StateServer).SetSynced(ctx, req.(*SetSyncedRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _State_GetPosition_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(Request)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(StateServer).GetPosition(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: State_GetPosition_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(StateServer).GetPosition(ctx, req.(*Request))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _State_Lag_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(Request)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(StateServer).Lag(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: State_Lag_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(StateServer).Lag(ctx, req.(*Request))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _State_GetMaintenance_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(Request)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(StateServer).GetMaintenance(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: State_GetMaintenance_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(StateServer).GetMaintenance(ctx, req.(*Request))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _State_GetSummary_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(Request)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(StateServer).GetSummary(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: State_GetSummary_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(StateServer).GetSummary(ctx, req.(*Request))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _State_GetPromotion_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(Request)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(StateServer).GetPromotion(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: State_GetPromotion_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(StateServer).GetPromotion(ctx, req.(*Request))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _State_GetEpoch_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(Request)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(StateServer).GetEpoch(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: State_GetEpoch_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(StateServer).GetEpoch(ctx, req.(*Request))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _State_AcquireLease_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(LeaseRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(StateServer).AcquireLease(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: State_AcquireLease_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(StateServer).AcquireLease(ctx, req.(*LeaseRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _State_ReleaseLease_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(LeaseRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(StateServer).ReleaseLease(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: State_ReleaseLease_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(StateServer).ReleaseLease(ctx, req.(*LeaseRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+// State_ServiceDesc is the grpc.ServiceDesc for State service.
+// It's only intended for direct use with grpc.RegisterService,
+// and not to be introspected or modified (even as a copy)
+var State_ServiceDesc = grpc.ServiceDesc{
+	ServiceName: "yoke.state.State",
+	HandlerType: (*StateServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "Hello",
+			Handler:    _State_Hello_Handler,
+		},
+		{
+			MethodName: "Ready",
+			Handler:    _State_Ready_Handler,
+		},
+		{
+			MethodName: "GetDataDir",
+			Handler:    _State_GetDataDir_Handler,
+		},
+		{
+			MethodName: "GetRole",
+			Handler:    _State_GetRole_Handler,
+		},
+		{
+			MethodName: "GetDBRole",
+			Handler:    _State_GetDBRole_Handler,
+		},
+		{
+			MethodName: "HasSynced",
+			Handler:    _State_HasSynced_Handler,
+		},
+		{
+			MethodName: "SetSynced",
+			Handler:    _State_SetSynced_Handler,
+		},
+		{
+			MethodName: "GetPosition",
+			Handler:    _State_GetPosition_Handler,
+		},
+		{
+			MethodName: "Lag",
+			Handler:    _State_Lag_Handler,
+		},
+		{
+			MethodName: "GetMaintenance",
+			Handler:    _State_GetMaintenance_Handler,
+		},
+		{
+			MethodName: "GetSummary",
+			Handler:    _State_GetSummary_Handler,
+		},
+		{
+			MethodName: "GetPromotion",
+			Handler:    _State_GetPromotion_Handler,
+		},
+		{
+			MethodName: "GetEpoch",
+			Handler:    _State_GetEpoch_Handler,
+		},
+		{
+			MethodName: "AcquireLease",
+			Handler:    _State_AcquireLease_Handler,
+		},
+		{
+			MethodName: "ReleaseLease",
+			Handler:    _State_ReleaseLease_Handler,
+		},
+	},
+	Streams:  []grpc.StreamDesc{},
+	Metadata: "state.proto",
+}
@@ -0,0 +1,159 @@
+// Copyright (c) 2015 Pagoda Box Inc
+//
+// This Source Code Form is subject to the terms of the Mozilla Public License, v.
+// 2.0. If a copy of the MPL was not distributed with this file, You can obtain one
+// at http://mozilla.org/MPL/2.0/.
+//
+
+package state
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"io/ioutil"
+	"net"
+	"os"
+	"sync"
+	"time"
+)
+
+var (
+	NoCertificates = errors.New("no certificates could be read from the ca file")
+
+	// the certificates every rpc connection is secured with, nil leaves them in
+	// plain text
+	certs     *Certificates
+	certsLock sync.RWMutex
+)
+
+type (
+	// Certificates holds the certificate of this node and the ca that the other
+	// nodes' certificates have to be signed by. The files are read again when they
+	// change on disk, so certificates can be rotated without a restart.
+	Certificates struct {
+		sync.Mutex
+		certFile string
+		keyFile  string
+		caFile   string
+		checked  time.Time
+		modified time.Time
+		cert     tls.Certificate
+		pool     *x509.CertPool
+	}
+)
+
+// how often the certificate files are checked for changes
+var reloadInterval = 5 * time.Second
+
+// NewCertificates loads the certificate, key and ca from disk
+func NewCertificates(certFile, keyFile, caFile string) (*Certificates, error) {
+	certificates := &Certificates{
+		certFile: certFile,
+		keyFile:  keyFile,
+		caFile:   caFile,
+	}
+	if err := certificates.load(); err != nil {
+		return nil, err
+	}
+	return certificates, nil
+}
+
+// EnableTLS secures every rpc connection this process makes or accepts with mutual
+// certificate authentication. Passing nil goes back to plain text.
+func EnableTLS(certificates *Certificates) {
+	certsLock.Lock()
+	defer certsLock.Unlock()
+	certs = certificates
+}
+
+func currentCerts() *Certificates {
+	certsLock.RLock()
+	defer certsLock.RUnlock()
+	return certs
+}
+
+// ServerConfig returns the tls config to accept connections with, only clients
+// with a certificate signed by the ca are accepted
+func (certificates *Certificates) ServerConfig() *tls.Config {
+	return &tls.Config{
+		GetConfigForClient: func(*tls.ClientHelloInfo) (*tls.Config, error) {
+			cert, pool := certificates.current()
+			return &tls.Config{
+				Certificates: []tls.Certificate{cert},
+				ClientCAs:    pool,
+				ClientAuth:   tls.RequireAndVerifyClientCert,
+				MinVersion:   tls.VersionTLS12,
+			}, nil
+		},
+	}
+}
+
+// ClientConfig returns the tls config to connect to the node at location with
+func (certificates *Certificates) ClientConfig(location string) *tls.Config {
+	cert, pool := certificates.current()
+	host, _, err := net.SplitHostPort(location)
+	if err != nil {
+		host = location
+	}
+	return &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		RootCAs:      pool,
+		ServerName:   host,
+		MinVersion:   tls.VersionTLS12,
+	}
+}
+
+// returns the certificates, reading them again if the files have changed
+func (certificates *Certificates) current() (tls.Certificate, *x509.CertPool) {
+	certificates.Lock()
+	defer certificates.Unlock()
+
+	if time.Since(certificates.checked) > reloadInterval {
+		certificates.checked = time.Now()
+		if certificates.lastModified().After(certificates.modified) {
+			// keep using the old certificates if the new ones are broken
+			certificates.reload()
+		}
+	}
+	return certificates.cert, certificates.pool
+}
+
+func (certificates *Certificates) load() error {
+	certificates.Lock()
+	defer certificates.Unlock()
+	return certificates.reload()
+}
+
+func (certificates *Certificates) reload() error {
+	modified := certificates.lastModified()
+	cert, err := tls.LoadX509KeyPair(certificates.certFile, certificates.keyFile)
+	if err != nil {
+		return err
+	}
+	ca, err := ioutil.ReadFile(certificates.caFile)
+	if err != nil {
+		return err
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(ca) {
+		return NoCertificates
+	}
+
+	certificates.cert = cert
+	certificates.pool = pool
+	certificates.modified = modified
+	certificates.checked = time.Now()
+	return nil
+}
+
+// the most recent modification time of any of the files
+func (certificates *Certificates) lastModified() time.Time {
+	latest := time.Time{}
+	for _, file := range []string{certificates.certFile, certificates.keyFile, certificates.caFile} {
+		if info, err := os.Stat(file); err == nil && info.ModTime().After(latest) {
+			latest = info.ModTime()
+		}
+	}
+	return latest
+}
@@ -0,0 +1,125 @@
+// Copyright (c) 2015 Pagoda Box Inc
+//
+// This Source Code Form is subject to the terms of the Mozilla Public License, v.
+// 2.0. If a copy of the MPL was not distributed with this file, You can obtain one
+// at http://mozilla.org/MPL/2.0/.
+//
+
+package state_test
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"github.com/golang/mock/gomock"
+	"github.com/nanopack/yoke/state"
+	"github.com/nanopack/yoke/state/mock"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// writes a ca, and a certificate for 127.0.0.1 signed by it, into dir
+func writeCertificates(test *testing.T, dir string) (string, string, string) {
+	caKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	ca := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "yoke test ca"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+	}
+	caDER, err := x509.CreateCertificate(rand.Reader, ca, ca, &caKey.PublicKey, caKey)
+	if err != nil {
+		test.Log(err)
+		test.FailNow()
+	}
+
+	key, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	leaf := &x509.Certificate{
+		SerialNumber: big.NewInt(2),
+		Subject:      pkix.Name{CommonName: "127.0.0.1"},
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+	}
+	leafDER, err := x509.CreateCertificate(rand.Reader, leaf, ca, &key.PublicKey, caKey)
+	if err != nil {
+		test.Log(err)
+		test.FailNow()
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		test.Log(err)
+		test.FailNow()
+	}
+
+	caFile := filepath.Join(dir, "ca.pem")
+	certFile := filepath.Join(dir, "cert.pem")
+	keyFile := filepath.Join(dir, "key.pem")
+	ioutil.WriteFile(caFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: caDER}), 0600)
+	ioutil.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: leafDER}), 0600)
+	ioutil.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0600)
+	return certFile, keyFile, caFile
+}
+
+func TestTLS(test *testing.T) {
+	ctrl := gomock.NewController(test)
+	defer ctrl.Finish()
+
+	dir, err := ioutil.TempDir("", "yoke-tls")
+	if err != nil {
+		test.Log(err)
+		test.FailNow()
+	}
+	defer os.RemoveAll(dir)
+
+	certificates, err := state.NewCertificates(writeCertificates(test, dir))
+	if err != nil {
+		test.Log(err)
+		test.FailNow()
+	}
+	state.EnableTLS(certificates)
+	defer state.EnableTLS(nil)
+
+	store := mock_state.NewMockStore(ctrl)
+	store.EXPECT().Read("states", "something", gomock.Any()).Return(fakeErr)
+	store.EXPECT().Write("states", "something", gomock.Any()).Return(nil)
+	local, err := state.NewLocalState("something", "wherever", "//here", store)
+	if err != nil {
+		test.Log(err)
+		test.FailNow()
+	}
+
+	listen, err := local.ExposeRPCEndpoint("tcp", "127.0.0.1:1240")
+	if err != nil {
+		test.Log(err)
+		test.FailNow()
+	}
+	defer listen.Close()
+
+	client := state.NewRemoteState("tcp", "127.0.0.1:1240", time.Second)
+	role, err := client.GetRole()
+	if err != nil || role != "something" {
+		test.Logf("could not talk over tls '%v' %v", role, err)
+		test.FailNow()
+	}
+
+	// a client without a certificate is turned away
+	state.EnableTLS(nil)
+	if _, err := client.GetRole(); err == nil {
+		test.Log("a plain text client should not have been able to connect")
+		test.Fail()
+	}
+}
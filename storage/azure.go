@@ -0,0 +1,150 @@
+// Copyright (c) 2015 Pagoda Box Inc
+//
+// This Source Code Form is subject to the terms of the Mozilla Public License, v.
+// 2.0. If a copy of the MPL was not distributed with this file, You can obtain one
+// at http://mozilla.org/MPL/2.0/.
+//
+
+package storage
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// azure keeps the archive in a container of an Azure storage account through the az
+// cli, or in Azurite at the endpoint. The az cli takes the credentials from
+// AZURE_STORAGE_KEY, AZURE_STORAGE_SAS_TOKEN or AZURE_STORAGE_CONNECTION_STRING, or
+// looks up the account key with the account it is logged in as.
+type azure struct {
+	account   string
+	container string
+	prefix    string
+	endpoint  string
+}
+
+func newAzure(destination, endpoint string) (Driver, error) {
+	root, err := withScheme("azure", destination)
+	if err != nil {
+		return nil, err
+	}
+	parts := strings.SplitN(strings.TrimPrefix(root, "azure://"), "/", 3)
+	if len(parts) < 2 || parts[1] == "" {
+		return nil, fmt.Errorf("'%v' is not an azure://account/container url", destination)
+	}
+	driver := azure{account: parts[0], container: parts[1], endpoint: endpoint}
+	if len(parts) == 3 {
+		driver.prefix = parts[2]
+	}
+	return driver, nil
+}
+
+// name is the name of the blob at remote
+func (azure azure) name(remote string) string {
+	if azure.prefix == "" {
+		return remote
+	}
+	return azure.prefix + "/" + remote
+}
+
+// args builds the arguments of an az storage blob command, pointing it at the
+// account and the endpoint
+func (azure azure) args(args ...string) []string {
+	args = append([]string{"storage", "blob"}, args...)
+	args = append(args, "--account-name", azure.account, "--only-show-errors")
+	if azure.endpoint != "" {
+		args = append(args, "--blob-endpoint", azure.endpoint)
+	}
+	return args
+}
+
+// Put never overwrites a blob, az refuses to unless it is told to
+func (azure azure) Put(local, remote string) string {
+	return command("az", azure.args("upload", "--container-name", azure.container, "--name", azure.name(remote), "--file", local, "--output", "none")...)
+}
+
+func (azure azure) Get(remote, local string) string {
+	return command("az", azure.args("download", "--container-name", azure.container, "--name", azure.name(remote), "--file", local, "--output", "none")...)
+}
+
+func (azure azure) Upload(local, remote string) error {
+	return run("az", azure.args("upload-batch", "--destination", azure.container, "--destination-path", azure.name(remote), "--source", local, "--output", "none")...)
+}
+
+// Download fetches the blobs one by one, download-batch would put them under the
+// whole of their name
+func (azure azure) Download(remote, local string) error {
+	entries, err := azure.List(remote)
+	if err != nil {
+		return err
+	}
+	for _, entry := range entries {
+		if entry.Dir {
+			continue
+		}
+		if err := run("az", azure.args("download", "--container-name", azure.container, "--name", azure.name(remote+"/"+entry.Name), "--file", filepath.Join(local, entry.Name), "--output", "none")...); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (azure azure) Read(remote string) ([]byte, error) {
+	file, err := ioutil.TempFile("", "yoke-blob")
+	if err != nil {
+		return nil, err
+	}
+	file.Close()
+	defer os.Remove(file.Name())
+	if err := run("az", azure.args("download", "--container-name", azure.container, "--name", azure.name(remote), "--file", file.Name(), "--output", "none")...); err != nil {
+		return nil, err
+	}
+	return ioutil.ReadFile(file.Name())
+}
+
+func (azure azure) List(remote string) ([]Entry, error) {
+	prefix := azure.name(remote) + "/"
+	out, err := output("az", azure.args("list", "--container-name", azure.container, "--prefix", prefix, "--delimiter", "/", "--num-results", "*",
+		"--query", "[].[name, properties.lastModified]", "--output", "tsv")...)
+	if err != nil {
+		return nil, err
+	}
+	entries := []Entry{}
+	for _, line := range strings.Split(string(out), "\n") {
+		fields := strings.Split(strings.TrimSpace(line), "\t")
+		name := strings.TrimPrefix(fields[0], prefix)
+		switch {
+		case name == "":
+		// 'base/20260102T030405Z/'
+		case strings.HasSuffix(name, "/"):
+			entries = append(entries, Entry{Name: path.Base(name), Dir: true})
+		// 'wal/000000010000000000000002	2026-01-02T03:04:05+00:00'
+		case len(fields) == 2:
+			modified, err := time.Parse(time.RFC3339, fields[1])
+			if err != nil {
+				continue
+			}
+			entries = append(entries, Entry{Name: name, Modified: modified})
+		}
+	}
+	return entries, nil
+}
+
+func (azure azure) Remove(remote string) error {
+	return run("az", azure.args("delete-batch", "--source", azure.container, "--pattern", azure.name(remote)+"/*")...)
+}
+
+// RemoveAll removes the blobs one by one, delete-batch only takes a single pattern
+func (azure azure) RemoveAll(remote string, names []string) error {
+	for _, name := range names {
+		if err := run("az", azure.args("delete", "--container-name", azure.container, "--name", azure.name(remote+"/"+name))...); err != nil {
+			return err
+		}
+	}
+	return nil
+}
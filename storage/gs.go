@@ -0,0 +1,90 @@
+// Copyright (c) 2015 Pagoda Box Inc
+//
+// This Source Code Form is subject to the terms of the Mozilla Public License, v.
+// 2.0. If a copy of the MPL was not distributed with this file, You can obtain one
+// at http://mozilla.org/MPL/2.0/.
+//
+
+package storage
+
+import (
+	"path"
+	"strings"
+	"time"
+)
+
+// gs keeps the archive in a Google Cloud Storage bucket through gsutil, with the
+// credentials gsutil finds
+type gs struct {
+	root string
+}
+
+func newGS(destination, endpoint string) (Driver, error) {
+	root, err := withScheme("gs", destination)
+	if err != nil {
+		return nil, err
+	}
+	return gs{root}, nil
+}
+
+func (gs gs) url(remote string) string {
+	return gs.root + "/" + remote
+}
+
+func (gs gs) Put(local, remote string) string {
+	return command("gsutil", "-q", "cp", local, gs.url(remote))
+}
+
+func (gs gs) Get(remote, local string) string {
+	return command("gsutil", "-q", "cp", gs.url(remote), local)
+}
+
+func (gs gs) Upload(local, remote string) error {
+	return run("gsutil", "-q", "-m", "cp", "-r", local+"/*", gs.url(remote)+"/")
+}
+
+func (gs gs) Download(remote, local string) error {
+	return run("gsutil", "-q", "-m", "cp", "-r", gs.url(remote)+"/*", local+"/")
+}
+
+func (gs gs) Read(remote string) ([]byte, error) {
+	return output("gsutil", "-q", "cat", gs.url(remote))
+}
+
+func (gs gs) List(remote string) ([]Entry, error) {
+	out, err := output("gsutil", "ls", "-l", gs.url(remote)+"/")
+	if err != nil {
+		return nil, err
+	}
+	entries := []Entry{}
+	for _, line := range strings.Split(string(out), "\n") {
+		fields := strings.Fields(line)
+		switch {
+		// '                                 gs://bucket/base/20260102T030405Z/'
+		case len(fields) == 1 && strings.HasSuffix(fields[0], "/"):
+			entries = append(entries, Entry{Name: path.Base(fields[0]), Dir: true})
+		// '  16777216  2026-01-02T03:04:05Z  gs://bucket/wal/000000010000000000000002'
+		case len(fields) == 3:
+			modified, err := time.Parse(time.RFC3339, fields[1])
+			if err != nil {
+				continue
+			}
+			entries = append(entries, Entry{Name: path.Base(fields[2]), Modified: modified})
+		}
+	}
+	return entries, nil
+}
+
+func (gs gs) Remove(remote string) error {
+	return run("gsutil", "-q", "-m", "rm", "-r", gs.url(remote))
+}
+
+func (gs gs) RemoveAll(remote string, names []string) error {
+	return each(names, 100, func(batch []string) error {
+		args := []string{"-q", "-m", "rm"}
+		for _, name := range batch {
+			args = append(args, gs.url(remote)+"/"+name)
+		}
+		return run("gsutil", args...)
+	})
+}
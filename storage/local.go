@@ -0,0 +1,85 @@
+// Copyright (c) 2015 Pagoda Box Inc
+//
+// This Source Code Form is subject to the terms of the Mozilla Public License, v.
+// 2.0. If a copy of the MPL was not distributed with this file, You can obtain one
+// at http://mozilla.org/MPL/2.0/.
+//
+
+package storage
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// local keeps the archive in a directory
+type local struct {
+	root string
+}
+
+func newLocal(destination, endpoint string) (Driver, error) {
+	if strings.Contains(destination, "://") {
+		return nil, fmt.Errorf("'%v' is not a path", destination)
+	}
+	if destination == "" {
+		return nil, fmt.Errorf("the archive needs a path")
+	}
+	return local{strings.TrimSuffix(destination, "/")}, nil
+}
+
+func (local local) path(remote string) string {
+	return local.root + "/" + remote
+}
+
+// Put never overwrites a file, and copies it next to where it goes first
+func (local local) Put(from, remote string) string {
+	to := local.path(remote)
+	return fmt.Sprintf("mkdir -p %s && test ! -f %s && cp %s %s.tmp && mv %s.tmp %s", filepath.Dir(to), to, from, to, to, to)
+}
+
+func (local local) Get(remote, to string) string {
+	return command("cp", local.path(remote), to)
+}
+
+func (local local) Upload(from, remote string) error {
+	if err := os.MkdirAll(local.path(remote), 0700); err != nil {
+		return err
+	}
+	return run("cp", "-r", from+"/.", local.path(remote))
+}
+
+func (local local) Download(remote, to string) error {
+	return run("cp", "-r", local.path(remote)+"/.", to)
+}
+
+func (local local) Read(remote string) ([]byte, error) {
+	return ioutil.ReadFile(local.path(remote))
+}
+
+func (local local) List(remote string) ([]Entry, error) {
+	infos, err := ioutil.ReadDir(local.path(remote))
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	entries := []Entry{}
+	for _, info := range infos {
+		entries = append(entries, Entry{Name: info.Name(), Dir: info.IsDir(), Modified: info.ModTime()})
+	}
+	return entries, nil
+}
+
+func (local local) Remove(remote string) error {
+	return os.RemoveAll(local.path(remote))
+}
+
+func (local local) RemoveAll(remote string, names []string) error {
+	for _, name := range names {
+		if err := os.Remove(filepath.Join(local.path(remote), name)); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	return nil
+}
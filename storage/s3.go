@@ -0,0 +1,98 @@
+// Copyright (c) 2015 Pagoda Box Inc
+//
+// This Source Code Form is subject to the terms of the Mozilla Public License, v.
+// 2.0. If a copy of the MPL was not distributed with this file, You can obtain one
+// at http://mozilla.org/MPL/2.0/.
+//
+
+package storage
+
+import (
+	"strings"
+	"time"
+)
+
+// s3 keeps the archive in an S3 bucket, or in S3 compatible storage at the
+// endpoint, through the aws cli. The credentials are the ones the aws cli finds.
+type s3 struct {
+	root     string
+	endpoint string
+}
+
+func newS3(destination, endpoint string) (Driver, error) {
+	root, err := withScheme("s3", destination)
+	if err != nil {
+		return nil, err
+	}
+	return s3{root, endpoint}, nil
+}
+
+func (s3 s3) url(remote string) string {
+	return s3.root + "/" + remote
+}
+
+// args builds the arguments of an aws s3 command, pointing it at the endpoint
+func (s3 s3) args(args ...string) []string {
+	if s3.endpoint != "" {
+		args = append([]string{"--endpoint-url", s3.endpoint}, args...)
+	}
+	return append([]string{"s3"}, args...)
+}
+
+func (s3 s3) Put(local, remote string) string {
+	return command("aws", s3.args("cp", "--quiet", local, s3.url(remote))...)
+}
+
+func (s3 s3) Get(remote, local string) string {
+	return command("aws", s3.args("cp", "--quiet", s3.url(remote), local)...)
+}
+
+func (s3 s3) Upload(local, remote string) error {
+	return run("aws", s3.args("cp", "--quiet", "--recursive", local, s3.url(remote))...)
+}
+
+func (s3 s3) Download(remote, local string) error {
+	return run("aws", s3.args("cp", "--quiet", "--recursive", s3.url(remote), local)...)
+}
+
+func (s3 s3) Read(remote string) ([]byte, error) {
+	return output("aws", s3.args("cp", "--quiet", s3.url(remote), "-")...)
+}
+
+func (s3 s3) List(remote string) ([]Entry, error) {
+	out, err := output("aws", s3.args("ls", s3.url(remote)+"/")...)
+	if err != nil {
+		return nil, err
+	}
+	entries := []Entry{}
+	for _, line := range strings.Split(string(out), "\n") {
+		fields := strings.Fields(line)
+		switch {
+		// '                           PRE 20260102T030405Z/'
+		case len(fields) == 2 && fields[0] == "PRE":
+			entries = append(entries, Entry{Name: strings.TrimSuffix(fields[1], "/"), Dir: true})
+		// '2026-01-02 03:04:05 16777216 000000010000000000000002'
+		case len(fields) == 4:
+			modified, err := time.ParseInLocation("2006-01-02 15:04:05", fields[0]+" "+fields[1], time.Local)
+			if err != nil {
+				continue
+			}
+			entries = append(entries, Entry{Name: fields[3], Modified: modified})
+		}
+	}
+	return entries, nil
+}
+
+func (s3 s3) Remove(remote string) error {
+	return run("aws", s3.args("rm", "--quiet", "--recursive", s3.url(remote))...)
+}
+
+func (s3 s3) RemoveAll(remote string, names []string) error {
+	return each(names, 100, func(batch []string) error {
+		args := []string{"rm", "--quiet", "--recursive", s3.url(remote) + "/", "--exclude", "*"}
+		for _, name := range batch {
+			args = append(args, "--include", name)
+		}
+		return run("aws", s3.args(args...)...)
+	})
+}
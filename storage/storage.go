@@ -0,0 +1,167 @@
+// Copyright (c) 2015 Pagoda Box Inc
+//
+// This Source Code Form is subject to the terms of the Mozilla Public License, v.
+// 2.0. If a copy of the MPL was not distributed with this file, You can obtain one
+// at http://mozilla.org/MPL/2.0/.
+//
+
+// storage keeps the archive of base backups and WAL segments in one of the kinds
+// of storage yoke can archive to. The drivers shell out to the cli of their
+// storage, which the archive_command and the restore_command postgres runs have
+// to do anyway:
+//
+//	local    a path on the node, or on a network filesystem mounted on every node
+//	s3       s3://bucket/path through the aws cli, or any S3 compatible storage
+//	         (e.g. MinIO) at the endpoint
+//	gs       gs://bucket/path through gsutil
+//	azure    azure://account/container/path through the az cli, or Azurite at
+//	         the endpoint
+//
+// More can be added with Register.
+package storage
+
+import (
+	"bytes"
+	"fmt"
+	"os/exec"
+	"strings"
+	"sync"
+	"time"
+)
+
+type (
+	// Driver stores files under the archive destination, the remote paths it is
+	// given are relative to it
+	Driver interface {
+		// Put and Get are the shell commands that copy the file local to remote and
+		// back, for the archive_command and the restore_command. They can't contain
+		// single quotes, the commands are quoted in the postgres config.
+		Put(local, remote string) string
+		Get(remote, local string) string
+
+		// Upload stores every file of the directory local under remote, Download
+		// fetches them back into local
+		Upload(local, remote string) error
+		Download(remote, local string) error
+
+		// Read returns the contents of the file remote
+		Read(remote string) ([]byte, error)
+
+		// List returns the entries directly under remote
+		List(remote string) ([]Entry, error)
+
+		// Remove removes remote and everything under it, RemoveAll removes the
+		// files names directly under remote
+		Remove(remote string) error
+		RemoveAll(remote string, names []string) error
+	}
+
+	// Entry is a file or a directory of the storage
+	Entry struct {
+		Name     string
+		Dir      bool
+		Modified time.Time // of a file, directories don't have one everywhere
+	}
+
+	// Factory creates a driver for the destination, and the endpoint of the
+	// storage when it isn't the default one
+	Factory func(destination, endpoint string) (Driver, error)
+)
+
+var (
+	driverLock sync.Mutex
+	drivers    = map[string]Factory{}
+)
+
+func init() {
+	Register("local", newLocal)
+	Register("s3", newS3)
+	Register("gs", newGS)
+	Register("azure", newAzure)
+}
+
+// Register makes a driver available under name, so it can be selected with the
+// [archive] storage config option or the scheme of the destination
+func Register(name string, factory Factory) {
+	driverLock.Lock()
+	defer driverLock.Unlock()
+	drivers[name] = factory
+}
+
+// New creates the driver name for the destination, the driver is picked by the
+// scheme of the destination when there is no name
+func New(name, destination, endpoint string) (Driver, error) {
+	if name == "" {
+		name = Scheme(destination)
+	}
+
+	driverLock.Lock()
+	factory, ok := drivers[name]
+	driverLock.Unlock()
+	if !ok {
+		return nil, fmt.Errorf("unknown storage '%v'", name)
+	}
+	return factory(destination, endpoint)
+}
+
+// Scheme is the scheme of the destination url, 'local' for a path
+func Scheme(destination string) string {
+	if i := strings.Index(destination, "://"); i > 0 {
+		return destination[:i]
+	}
+	return "local"
+}
+
+// withScheme checks that the destination is a url of scheme, a destination without
+// a scheme gets it
+func withScheme(scheme, destination string) (string, error) {
+	switch {
+	case strings.HasPrefix(destination, scheme+"://"):
+	case strings.Contains(destination, "://"):
+		return "", fmt.Errorf("'%v' is not a %v:// url", destination, scheme)
+	default:
+		destination = scheme + "://" + destination
+	}
+	if len(strings.Trim(destination[len(scheme+"://"):], "/")) == 0 {
+		return "", fmt.Errorf("'%v' has no bucket", destination)
+	}
+	return strings.TrimSuffix(destination, "/"), nil
+}
+
+// each calls do with names, batch at a time
+func each(names []string, batch int, do func([]string) error) error {
+	for len(names) != 0 {
+		next := names
+		if len(next) > batch {
+			next = next[:batch]
+		}
+		names = names[len(next):]
+		if err := do(next); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// command is a shell command of name and args, none of which need to be quoted
+func command(name string, args ...string) string {
+	return strings.Join(append([]string{name}, args...), " ")
+}
+
+func run(name string, args ...string) error {
+	_, err := output(name, args...)
+	return err
+}
+
+// output runs name and returns what it wrote to stdout, or what it wrote to stderr
+// in the error
+func output(name string, args ...string) ([]byte, error) {
+	cmd := exec.Command(name, args...)
+	stderr := &bytes.Buffer{}
+	cmd.Stderr = stderr
+	out, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("%v failed: %v %s", name, err, strings.TrimSpace(stderr.String()))
+	}
+	return out, nil
+}
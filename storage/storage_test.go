@@ -0,0 +1,122 @@
+// Copyright (c) 2015 Pagoda Box Inc
+//
+// This Source Code Form is subject to the terms of the Mozilla Public License, v.
+// 2.0. If a copy of the MPL was not distributed with this file, You can obtain one
+// at http://mozilla.org/MPL/2.0/.
+//
+
+package storage_test
+
+import (
+	"github.com/nanopack/yoke/storage"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestNew(test *testing.T) {
+	for _, destination := range []struct {
+		storage     string
+		destination string
+		valid       bool
+	}{
+		{"", "/backups", true},
+		{"", "s3://bucket/yoke", true},
+		{"", "gs://bucket", true},
+		{"", "azure://account/container/yoke", true},
+		{"", "azure://account", false},
+		{"", "ftp://host/yoke", false},
+		{"s3", "bucket/yoke", true},
+		{"s3", "gs://bucket/yoke", false},
+		{"local", "s3://bucket", false},
+		{"s3", "s3://", false},
+	} {
+		_, err := storage.New(destination.storage, destination.destination, "")
+		if (err == nil) != destination.valid {
+			test.Logf("'%v' '%v' should be valid %v (%v)", destination.storage, destination.destination, destination.valid, err)
+			test.Fail()
+		}
+	}
+
+	// the archive_command is quoted in postgresql.conf
+	driver, _ := storage.New("", "azure://account/container/yoke", "http://127.0.0.1:10000/account")
+	if put := driver.Put("%p", "wal/%f"); !strings.Contains(put, "--container-name container --name yoke/wal/%f --file %p") ||
+		!strings.Contains(put, "--blob-endpoint http://127.0.0.1:10000/account") || strings.Contains(put, "'") {
+		test.Logf("the segment is not uploaded to the container '%v'", put)
+		test.Fail()
+	}
+	driver, _ = storage.New("", "s3://bucket/yoke/", "https://minio.local:9000")
+	if get := driver.Get("wal/%f", "%p"); get != "aws s3 --endpoint-url https://minio.local:9000 cp --quiet s3://bucket/yoke/wal/%f %p" {
+		test.Logf("the segment is not fetched from minio '%v'", get)
+		test.Fail()
+	}
+}
+
+func TestLocal(test *testing.T) {
+	dir, err := ioutil.TempDir("", "yoke-storage")
+	if err != nil {
+		test.Log(err)
+		test.FailNow()
+	}
+	defer os.RemoveAll(dir)
+	driver, err := storage.New("", filepath.Join(dir, "archive"), "")
+	if err != nil {
+		test.Log(err)
+		test.FailNow()
+	}
+
+	segment := filepath.Join(dir, "segment")
+	ioutil.WriteFile(segment, []byte("wal"), 0600)
+	for i, archived := range []bool{true, false} {
+		err := exec.Command("sh", "-c", driver.Put(segment, "wal/000000010000000000000001")).Run()
+		if (err == nil) != archived {
+			test.Logf("put %v should have archived %v (%v)", i, archived, err)
+			test.Fail()
+		}
+	}
+	backup := filepath.Join(dir, "backup")
+	os.MkdirAll(backup, 0700)
+	ioutil.WriteFile(filepath.Join(backup, "base.tar"), []byte("base"), 0600)
+	if err := driver.Upload(backup, "base/20260102T000000Z"); err != nil {
+		test.Log(err)
+		test.FailNow()
+	}
+
+	entries, err := driver.List("base")
+	if err != nil || len(entries) != 1 || !entries[0].Dir || entries[0].Name != "20260102T000000Z" {
+		test.Logf("the base backup is not listed %+v %v", entries, err)
+		test.Fail()
+	}
+	if contents, err := driver.Read("base/20260102T000000Z/base.tar"); err != nil || string(contents) != "base" {
+		test.Logf("the base.tar does not read back '%s' %v", contents, err)
+		test.Fail()
+	}
+	restored := filepath.Join(dir, "restored")
+	os.MkdirAll(restored, 0700)
+	if err := driver.Download("base/20260102T000000Z", restored); err != nil {
+		test.Log(err)
+		test.Fail()
+	}
+	if contents, _ := ioutil.ReadFile(filepath.Join(restored, "base.tar")); string(contents) != "base" {
+		test.Logf("the base.tar was not downloaded '%s'", contents)
+		test.Fail()
+	}
+
+	if err := driver.RemoveAll("wal", []string{"000000010000000000000001", "000000010000000000000002"}); err != nil {
+		test.Log(err)
+		test.Fail()
+	}
+	if err := driver.Remove("base/20260102T000000Z"); err != nil {
+		test.Log(err)
+		test.Fail()
+	}
+	for _, remote := range []string{"wal", "base", "nothing"} {
+		if entries, err := driver.List(remote); err != nil || len(entries) != 0 {
+			test.Logf("'%v' should be empty %+v %v", remote, entries, err)
+			test.Fail()
+		}
+	}
+}
@@ -0,0 +1,90 @@
+// Copyright (c) 2015 Pagoda Box Inc
+//
+// This Source Code Form is subject to the terms of the Mozilla Public License, v.
+// 2.0. If a copy of the MPL was not distributed with this file, You can obtain one
+// at http://mozilla.org/MPL/2.0/.
+//
+
+// systemd speaks the sd_notify protocol, so that a unit with 'Type=notify' only
+// counts as started once yoke has made its first decision, and a unit with
+// 'WatchdogSec=' is restarted when the decider stops coming around.
+package systemd
+
+import (
+	"github.com/nanopack/yoke/config"
+	"net"
+	"os"
+	"strconv"
+	"time"
+)
+
+// the states yoke notifies systemd of
+const (
+	Ready    = "READY=1"
+	Stopping = "STOPPING=1"
+	Watchdog = "WATCHDOG=1"
+)
+
+// Notify sends state to the socket systemd is listening on, it returns false
+// when yoke was not started by systemd with 'Type=notify'
+func Notify(state string) (bool, error) {
+	socket := os.Getenv("NOTIFY_SOCKET")
+	if socket == "" {
+		return false, nil
+	}
+	// a name that starts with '@' is an abstract socket, net takes care of it
+	conn, err := net.DialUnix("unixgram", nil, &net.UnixAddr{Name: socket, Net: "unixgram"})
+	if err != nil {
+		return false, err
+	}
+	defer conn.Close()
+	if _, err := conn.Write([]byte(state)); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// Status sends a line systemd shows in 'systemctl status'
+func Status(status string) (bool, error) {
+	return Notify("STATUS=" + status)
+}
+
+// WatchdogInterval returns how often systemd expects to hear from yoke, it
+// returns false when the watchdog is not enabled for this process
+func WatchdogInterval() (time.Duration, bool) {
+	usec, err := strconv.ParseInt(os.Getenv("WATCHDOG_USEC"), 10, 64)
+	if err != nil || usec <= 0 {
+		return 0, false
+	}
+	// the watchdog is meant for another process
+	if pid := os.Getenv("WATCHDOG_PID"); pid != "" && pid != strconv.Itoa(os.Getpid()) {
+		return 0, false
+	}
+	return time.Duration(usec) * time.Microsecond, true
+}
+
+// RunWatchdog pings the watchdog twice per interval for as long as beat has moved
+// within the last interval, so a stalled beat gets the process restarted. It
+// returns when done is closed, or right away when the watchdog is not enabled.
+func RunWatchdog(beat func() time.Time, done <-chan struct{}) {
+	interval, ok := WatchdogInterval()
+	if !ok {
+		return
+	}
+	ticker := time.NewTicker(interval / 2)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-done:
+			return
+		case <-ticker.C:
+		}
+		if stalled := time.Since(beat()); stalled > interval {
+			config.Log.Warn("[systemd] the decider has not come around in %v, not pinging the watchdog", stalled)
+			continue
+		}
+		if _, err := Notify(Watchdog); err != nil {
+			config.Log.Warn("[systemd] could not ping the watchdog (%v)", err)
+		}
+	}
+}
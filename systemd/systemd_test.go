@@ -0,0 +1,96 @@
+// Copyright (c) 2015 Pagoda Box Inc
+//
+// This Source Code Form is subject to the terms of the Mozilla Public License, v.
+// 2.0. If a copy of the MPL was not distributed with this file, You can obtain one
+// at http://mozilla.org/MPL/2.0/.
+//
+
+package systemd_test
+
+import (
+	"github.com/nanopack/yoke/systemd"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// listens where systemd would, and returns what it was notified of
+func listen(test *testing.T) (*net.UnixConn, func()) {
+	dir, err := ioutil.TempDir("", "systemd")
+	if err != nil {
+		test.Log(err)
+		test.FailNow()
+	}
+	socket := filepath.Join(dir, "notify")
+	conn, err := net.ListenUnixgram("unixgram", &net.UnixAddr{Name: socket, Net: "unixgram"})
+	if err != nil {
+		test.Log(err)
+		test.FailNow()
+	}
+	os.Setenv("NOTIFY_SOCKET", socket)
+	return conn, func() {
+		os.Unsetenv("NOTIFY_SOCKET")
+		os.Unsetenv("WATCHDOG_USEC")
+		conn.Close()
+		os.RemoveAll(dir)
+	}
+}
+
+func read(conn *net.UnixConn, timeout time.Duration) string {
+	conn.SetReadDeadline(time.Now().Add(timeout))
+	buffer := make([]byte, 64)
+	n, err := conn.Read(buffer)
+	if err != nil {
+		return ""
+	}
+	return string(buffer[:n])
+}
+
+func TestNotify(test *testing.T) {
+	if sent, err := systemd.Notify(systemd.Ready); sent || err != nil {
+		test.Logf("nothing should be sent without a socket %v", err)
+		test.Fail()
+	}
+
+	conn, done := listen(test)
+	defer done()
+	if sent, err := systemd.Notify(systemd.Ready); !sent || err != nil {
+		test.Logf("the notification was not sent %v", err)
+		test.FailNow()
+	}
+	if state := read(conn, time.Second); state != systemd.Ready {
+		test.Logf("wrong state '%v'", state)
+		test.Fail()
+	}
+}
+
+func TestWatchdog(test *testing.T) {
+	conn, done := listen(test)
+	defer done()
+	os.Setenv("WATCHDOG_USEC", "100000")
+	if interval, ok := systemd.WatchdogInterval(); !ok || interval != 100*time.Millisecond {
+		test.Logf("wrong interval %v", interval)
+		test.FailNow()
+	}
+
+	stop := make(chan struct{})
+	go systemd.RunWatchdog(time.Now, stop)
+	if state := read(conn, time.Second); state != systemd.Watchdog {
+		test.Logf("the watchdog was not pinged '%v'", state)
+		test.Fail()
+	}
+	close(stop)
+
+	// a beat that stopped moving does not keep the process alive
+	read(conn, 100*time.Millisecond)
+	stalled := make(chan struct{})
+	defer close(stalled)
+	go systemd.RunWatchdog(func() time.Time { return time.Now().Add(-time.Minute) }, stalled)
+	if state := read(conn, 200*time.Millisecond); state != "" {
+		test.Logf("the watchdog should not have been pinged '%v'", state)
+		test.Fail()
+	}
+}
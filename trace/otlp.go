@@ -0,0 +1,208 @@
+// Copyright (c) 2015 Pagoda Box Inc
+//
+// This Source Code Form is subject to the terms of the Mozilla Public License, v.
+// 2.0. If a copy of the MPL was not distributed with this file, You can obtain one
+// at http://mozilla.org/MPL/2.0/.
+//
+
+package trace
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/nanopack/yoke/config"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// how many spans are kept while the collector can't be reached, the oldest are
+// dropped first
+const maxQueued = 2048
+
+type (
+	// OTLP exports the spans in batches to an opentelemetry collector, with the
+	// json encoding of otlp over http
+	OTLP struct {
+		sync.Mutex
+		url      string
+		service  string
+		instance string
+		client   *http.Client
+		queued   []*Span
+	}
+
+	// the parts of the otlp json encoding that are sent
+	otlpRequest struct {
+		ResourceSpans []otlpResourceSpans `json:"resourceSpans"`
+	}
+
+	otlpResourceSpans struct {
+		Resource   otlpResource     `json:"resource"`
+		ScopeSpans []otlpScopeSpans `json:"scopeSpans"`
+	}
+
+	otlpResource struct {
+		Attributes []otlpAttribute `json:"attributes"`
+	}
+
+	otlpScopeSpans struct {
+		Scope otlpScope  `json:"scope"`
+		Spans []otlpSpan `json:"spans"`
+	}
+
+	otlpScope struct {
+		Name string `json:"name"`
+	}
+
+	otlpSpan struct {
+		TraceID      string          `json:"traceId"`
+		SpanID       string          `json:"spanId"`
+		ParentSpanID string          `json:"parentSpanId,omitempty"`
+		Name         string          `json:"name"`
+		Kind         int             `json:"kind"`
+		Start        string          `json:"startTimeUnixNano"`
+		End          string          `json:"endTimeUnixNano"`
+		Attributes   []otlpAttribute `json:"attributes,omitempty"`
+		Status       otlpStatus      `json:"status"`
+	}
+
+	otlpAttribute struct {
+		Key   string    `json:"key"`
+		Value otlpValue `json:"value"`
+	}
+
+	otlpValue struct {
+		StringValue string `json:"stringValue"`
+	}
+
+	otlpStatus struct {
+		Code    int    `json:"code"` // 0 unset, 2 error
+		Message string `json:"message,omitempty"`
+	}
+)
+
+// NewOTLP creates an exporter for the collector at endpoint (e.g.
+// 'http://127.0.0.1:4318'), the spans are sent as the service running on instance
+func NewOTLP(endpoint, service, instance string, timeout time.Duration) *OTLP {
+	return &OTLP{
+		url:      strings.TrimSuffix(endpoint, "/") + "/v1/traces",
+		service:  service,
+		instance: instance,
+		client:   &http.Client{Timeout: timeout},
+	}
+}
+
+// Export queues the span for the next batch
+func (otlp *OTLP) Export(span *Span) {
+	otlp.Lock()
+	defer otlp.Unlock()
+	otlp.queued = append(otlp.queued, span)
+	if len(otlp.queued) > maxQueued {
+		otlp.queued = otlp.queued[len(otlp.queued)-maxQueued:]
+	}
+}
+
+// Run sends the queued spans every interval until ctx is done, and once more
+// after that
+func (otlp *OTLP) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			otlp.Flush()
+			return
+		case <-ticker.C:
+			if err := otlp.Flush(); err != nil {
+				config.Log.Warn("[trace] the spans could not be exported %v", err)
+			}
+		}
+	}
+}
+
+// Flush sends the queued spans, they are queued again when they can't be sent
+func (otlp *OTLP) Flush() error {
+	otlp.Lock()
+	batch := otlp.queued
+	otlp.queued = nil
+	otlp.Unlock()
+	if len(batch) == 0 {
+		return nil
+	}
+
+	err := otlp.send(batch)
+	if err != nil {
+		otlp.Lock()
+		otlp.queued = append(batch, otlp.queued...)
+		if len(otlp.queued) > maxQueued {
+			otlp.queued = otlp.queued[len(otlp.queued)-maxQueued:]
+		}
+		otlp.Unlock()
+	}
+	return err
+}
+
+func (otlp *OTLP) send(batch []*Span) error {
+	spans := make([]otlpSpan, 0, len(batch))
+	for _, span := range batch {
+		spans = append(spans, encode(span))
+	}
+	body, err := json.Marshal(otlpRequest{ResourceSpans: []otlpResourceSpans{{
+		Resource: otlpResource{Attributes: attributes(map[string]string{
+			"service.name":        otlp.service,
+			"service.instance.id": otlp.instance,
+		})},
+		ScopeSpans: []otlpScopeSpans{{Scope: otlpScope{Name: "yoke"}, Spans: spans}},
+	}}})
+	if err != nil {
+		return err
+	}
+
+	res, err := otlp.client.Post(otlp.url, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	res.Body.Close()
+	if res.StatusCode < 200 || res.StatusCode > 299 {
+		return fmt.Errorf("unexpected response %v", res.Status)
+	}
+	return nil
+}
+
+func encode(span *Span) otlpSpan {
+	span.Lock()
+	defer span.Unlock()
+	encoded := otlpSpan{
+		TraceID:      span.TraceID,
+		SpanID:       span.SpanID,
+		ParentSpanID: span.ParentID,
+		Name:         span.Name,
+		Kind:         1, // internal
+		Start:        strconv.FormatInt(span.Start.UnixNano(), 10),
+		End:          strconv.FormatInt(span.End.UnixNano(), 10),
+		Attributes:   attributes(span.Attributes),
+	}
+	if span.Error != "" {
+		encoded.Status = otlpStatus{Code: 2, Message: span.Error}
+	}
+	return encoded
+}
+
+func attributes(values map[string]string) []otlpAttribute {
+	keys := make([]string, 0, len(values))
+	for key := range values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	encoded := make([]otlpAttribute, 0, len(keys))
+	for _, key := range keys {
+		encoded = append(encoded, otlpAttribute{Key: key, Value: otlpValue{StringValue: values[key]}})
+	}
+	return encoded
+}
@@ -0,0 +1,117 @@
+// Copyright (c) 2015 Pagoda Box Inc
+//
+// This Source Code Form is subject to the terms of the Mozilla Public License, v.
+// 2.0. If a copy of the MPL was not distributed with this file, You can obtain one
+// at http://mozilla.org/MPL/2.0/.
+//
+
+// trace times the steps of what a node does as spans of a trace, like every check
+// of the cluster and the calls and transitions it made, and exports them over
+// otlp. Nothing is recorded unless an exporter is set, a nil span does nothing.
+package trace
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"sync"
+	"time"
+)
+
+type (
+	// Span is a single timed step of a trace
+	Span struct {
+		sync.Mutex
+		TraceID    string
+		SpanID     string
+		ParentID   string // empty for the root of the trace
+		Name       string
+		Start      time.Time
+		End        time.Time
+		Attributes map[string]string
+		Error      string // what the step failed with, empty when it didn't
+	}
+
+	// Exporter is given every span once it ended
+	Exporter interface {
+		Export(span *Span)
+	}
+)
+
+var (
+	exporter     Exporter
+	exporterLock sync.RWMutex
+)
+
+// SetExporter changes where the spans of this process are exported to, nil stops
+// recording them
+func SetExporter(e Exporter) {
+	exporterLock.Lock()
+	defer exporterLock.Unlock()
+	exporter = e
+}
+
+func current() Exporter {
+	exporterLock.RLock()
+	defer exporterLock.RUnlock()
+	return exporter
+}
+
+// Start starts a new trace, it is nil when no exporter is set
+func Start(name string) *Span {
+	if current() == nil {
+		return nil
+	}
+	return newSpan(id(16), "", name)
+}
+
+// Child starts a span inside of span
+func (span *Span) Child(name string) *Span {
+	if span == nil {
+		return nil
+	}
+	return newSpan(span.TraceID, span.SpanID, name)
+}
+
+func newSpan(traceID, parentID, name string) *Span {
+	return &Span{
+		TraceID:    traceID,
+		SpanID:     id(8),
+		ParentID:   parentID,
+		Name:       name,
+		Start:      time.Now(),
+		Attributes: map[string]string{},
+	}
+}
+
+// Set records an attribute of the step
+func (span *Span) Set(key, value string) {
+	if span == nil {
+		return
+	}
+	span.Lock()
+	defer span.Unlock()
+	span.Attributes[key] = value
+}
+
+// Finish ends the span and exports it, err is what the step failed with
+func (span *Span) Finish(err error) {
+	if span == nil {
+		return
+	}
+	span.Lock()
+	span.End = time.Now()
+	if err != nil {
+		span.Error = err.Error()
+	}
+	span.Unlock()
+	if exporter := current(); exporter != nil {
+		exporter.Export(span)
+	}
+}
+
+// a random id of size bytes, in hex
+func id(size int) string {
+	b := make([]byte, size)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
@@ -0,0 +1,85 @@
+// Copyright (c) 2015 Pagoda Box Inc
+//
+// This Source Code Form is subject to the terms of the Mozilla Public License, v.
+// 2.0. If a copy of the MPL was not distributed with this file, You can obtain one
+// at http://mozilla.org/MPL/2.0/.
+//
+
+package trace_test
+
+import (
+	"encoding/json"
+	"errors"
+	"github.com/nanopack/yoke/trace"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestOTLP(test *testing.T) {
+	if trace.Start("off") != nil {
+		test.Log("nothing should be traced without an exporter")
+		test.Fail()
+	}
+
+	received := []map[string]interface{}{}
+	collector := httptest.NewServer(http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+		if req.URL.Path != "/v1/traces" {
+			res.WriteHeader(http.StatusNotFound)
+			return
+		}
+		body := map[string]interface{}{}
+		if err := json.NewDecoder(req.Body).Decode(&body); err != nil {
+			test.Log(err)
+			test.Fail()
+		}
+		received = append(received, body)
+	}))
+	defer collector.Close()
+
+	exporter := trace.NewOTLP(collector.URL, "yoke", "10.0.0.1:4400", time.Second)
+	trace.SetExporter(exporter)
+	defer trace.SetExporter(nil)
+
+	root := trace.Start("recheck")
+	child := root.Child("check peer")
+	child.Set("peer", "10.0.0.2:4400")
+	child.Finish(errors.New("Timeout"))
+	root.Finish(nil)
+	if err := exporter.Flush(); err != nil {
+		test.Log(err)
+		test.FailNow()
+	}
+
+	if len(received) != 1 {
+		test.Logf("expected a single batch, got %v", len(received))
+		test.FailNow()
+	}
+	var batch struct {
+		ResourceSpans []struct {
+			ScopeSpans []struct {
+				Spans []struct {
+					TraceID      string `json:"traceId"`
+					SpanID       string `json:"spanId"`
+					ParentSpanID string `json:"parentSpanId"`
+					Name         string `json:"name"`
+					Status       struct {
+						Code int `json:"code"`
+					} `json:"status"`
+				} `json:"spans"`
+			} `json:"scopeSpans"`
+		} `json:"resourceSpans"`
+	}
+	encoded, _ := json.Marshal(received[0])
+	json.Unmarshal(encoded, &batch)
+	spans := batch.ResourceSpans[0].ScopeSpans[0].Spans
+	if len(spans) != 2 || spans[0].Name != "check peer" || spans[1].Name != "recheck" {
+		test.Logf("wrong spans were exported %+v", spans)
+		test.FailNow()
+	}
+	if spans[0].TraceID != spans[1].TraceID || spans[0].ParentSpanID != spans[1].SpanID || spans[0].Status.Code != 2 {
+		test.Logf("the check should be a failed child of the recheck %+v", spans)
+		test.Fail()
+	}
+}
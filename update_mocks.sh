@@ -5,4 +5,4 @@ mkdir -p \
   state/mock
 
 mockgen github.com/nanopack/yoke/state State,Store > state/mock/mock.go
-mockgen github.com/nanopack/yoke/monitor Performer > monitor/mock/mock.go
+mockgen github.com/nanopack/yoke/monitor Performer,Decider > monitor/mock/mock.go
@@ -0,0 +1,253 @@
+// Copyright (c) 2015 Pagoda Box Inc
+//
+// This Source Code Form is subject to the terms of the Mozilla Public License, v.
+// 2.0. If a copy of the MPL was not distributed with this file, You can obtain one
+// at http://mozilla.org/MPL/2.0/.
+//
+
+// vip moves a floating ip over to whichever node is running the writable
+// database, so clients can follow the active node without any outside help.
+package vip
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"github.com/nanopack/yoke/config"
+	"github.com/nanopack/yoke/kube"
+	"io"
+	"strings"
+)
+
+// None is used when no vip is configured, it never does anything
+var None VIP = none{}
+
+type (
+	// VIP is a floating ip that can be assigned to, and released from, this node
+	VIP interface {
+		// assigns the ip to this node
+		Add() error
+		// releases the ip from this node
+		Remove() error
+	}
+
+	none struct{}
+
+	// command runs the add and remove commands from the config
+	command struct {
+		ip     string
+		add    string
+		remove string
+	}
+
+	// local adds the ip to a network interface and announces it with a
+	// gratuitous arp
+	local struct {
+		ip    string
+		iface string
+	}
+
+	// aws moves an elastic ip between instances
+	aws struct {
+		allocation string
+		instance   string
+	}
+
+	// gcp points a route for the ip at the instance
+	gcp struct {
+		ip       string
+		route    string
+		network  string
+		instance string
+		zone     string
+	}
+
+	// kubernetes points the endpoints of a service without a selector at the pod,
+	// the vip is the name of the service
+	kubernetes struct {
+		client  *kube.Client
+		service string
+		ip      string
+		port    int
+	}
+)
+
+// New creates the vip backend that was selected in the config
+func New(conf config.Config) (VIP, error) {
+	if conf.Vip == "" {
+		return None, nil
+	}
+
+	switch conf.VipBackend {
+	case "", "command":
+		if conf.VipAddCommand == "" || conf.VipRemoveCommand == "" {
+			return None, nil
+		}
+		return command{ip: conf.Vip, add: conf.VipAddCommand, remove: conf.VipRemoveCommand}, nil
+	case "ip":
+		if conf.VipInterface == "" {
+			return nil, fmt.Errorf("the 'ip' vip backend needs an interface")
+		}
+		return local{ip: conf.Vip, iface: conf.VipInterface}, nil
+	case "aws":
+		if conf.VipAWSAllocationID == "" || conf.VipAWSInstanceID == "" {
+			return nil, fmt.Errorf("the 'aws' vip backend needs an allocation id and an instance id")
+		}
+		return aws{allocation: conf.VipAWSAllocationID, instance: conf.VipAWSInstanceID}, nil
+	case "gcp":
+		if conf.VipGCPNetwork == "" || conf.VipGCPInstance == "" || conf.VipGCPZone == "" {
+			return nil, fmt.Errorf("the 'gcp' vip backend needs a network, instance and zone")
+		}
+		route := conf.VipGCPRoute
+		if route == "" {
+			route = "yoke-" + strings.NewReplacer(".", "-", "/", "-").Replace(conf.Vip)
+		}
+		return gcp{ip: conf.Vip, route: route, network: conf.VipGCPNetwork, instance: conf.VipGCPInstance, zone: conf.VipGCPZone}, nil
+	case "kubernetes":
+		client, err := kube.InCluster(conf.KubeAPI, conf.KubeNamespace)
+		if err != nil {
+			return nil, err
+		}
+		return kubernetes{client: client, service: conf.Vip, ip: conf.AdvertiseIp, port: conf.DatabasePort()}, nil
+	}
+	return nil, fmt.Errorf("unknown vip backend '%v'", conf.VipBackend)
+}
+
+func (none) Add() error    { return nil }
+func (none) Remove() error { return nil }
+
+func (vip command) Add() error {
+	_, err := run("VIPAddCommand", fmt.Sprintf("%s %s", vip.add, vip.ip))
+	return err
+}
+
+func (vip command) Remove() error {
+	_, err := run("VIPRemoveCommand", fmt.Sprintf("%s %s", vip.remove, vip.ip))
+	return err
+}
+
+func (vip local) Add() error {
+	// replace does not fail when the ip is already there
+	if _, err := run("ip", fmt.Sprintf("ip addr replace %s dev %s", vip.cidr(), vip.iface)); err != nil {
+		return err
+	}
+	// let the rest of the network know where the ip lives now
+	_, err := run("arping", fmt.Sprintf("arping -U -c 3 -I %s %s", vip.iface, vip.address()))
+	return err
+}
+
+func (vip local) Remove() error {
+	out, err := run("ip", fmt.Sprintf("ip -o addr show dev %s to %s", vip.iface, vip.cidr()))
+	if err != nil || len(strings.TrimSpace(string(out))) == 0 {
+		// it is not on this node
+		return err
+	}
+	_, err = run("ip", fmt.Sprintf("ip addr del %s dev %s", vip.cidr(), vip.iface))
+	return err
+}
+
+func (vip local) address() string {
+	return strings.Split(vip.ip, "/")[0]
+}
+
+func (vip local) cidr() string {
+	if strings.Contains(vip.ip, "/") {
+		return vip.ip
+	}
+	return vip.ip + "/32"
+}
+
+func (vip aws) Add() error {
+	_, err := run("aws", fmt.Sprintf("aws ec2 associate-address --allocation-id %s --instance-id %s --allow-reassociation", vip.allocation, vip.instance))
+	return err
+}
+
+func (vip aws) Remove() error {
+	out, err := run("aws", fmt.Sprintf("aws ec2 describe-addresses --allocation-ids %s --query 'Addresses[0].[InstanceId,AssociationId]' --output text", vip.allocation))
+	if err != nil {
+		return err
+	}
+	fields := strings.Fields(string(out))
+	// only let go of the ip if this instance is the one holding it
+	if len(fields) != 2 || fields[0] != vip.instance {
+		return nil
+	}
+	_, err = run("aws", fmt.Sprintf("aws ec2 disassociate-address --association-id %s", fields[1]))
+	return err
+}
+
+func (vip gcp) Add() error {
+	// the route may still be pointing at the old active node
+	run("gcloud", fmt.Sprintf("gcloud compute routes delete %s --quiet", vip.route))
+	_, err := run("gcloud", fmt.Sprintf("gcloud compute routes create %s --network %s --destination-range %s --next-hop-instance %s --next-hop-instance-zone %s",
+		vip.route, vip.network, vip.cidr(), vip.instance, vip.zone))
+	return err
+}
+
+func (vip gcp) Remove() error {
+	out, err := run("gcloud", fmt.Sprintf("gcloud compute routes describe %s --format 'value(nextHopInstance)'", vip.route))
+	// only delete the route if it is pointing at this instance
+	if err != nil || !strings.HasSuffix(strings.TrimSpace(string(out)), "/"+vip.instance) {
+		return nil
+	}
+	_, err = run("gcloud", fmt.Sprintf("gcloud compute routes delete %s --quiet", vip.route))
+	return err
+}
+
+func (vip gcp) cidr() string {
+	if strings.Contains(vip.ip, "/") {
+		return vip.ip
+	}
+	return vip.ip + "/32"
+}
+
+func (vip kubernetes) Add() error {
+	subsets := []kube.Subset{{
+		Addresses: []kube.Address{{IP: vip.ip}},
+		Ports:     []kube.Port{{Name: "db", Port: vip.port}},
+	}}
+	config.Log.Debug("[vip] pointing the endpoints of %s at %s:%d", vip.service, vip.ip, vip.port)
+	return vip.client.SetEndpoints(vip.service, subsets)
+}
+
+func (vip kubernetes) Remove() error {
+	endpoints, err := vip.client.GetEndpoints(vip.service)
+	if kube.IsNotFound(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	// only clear the endpoints if they are pointing at this pod
+	for _, subset := range endpoints.Subsets {
+		for _, address := range subset.Addresses {
+			if address.IP == vip.ip {
+				return vip.client.SetEndpoints(vip.service, nil)
+			}
+		}
+	}
+	return nil
+}
+
+// run runs command with the shell, logging its stderr
+func run(name, command string) ([]byte, error) {
+	config.Log.Debug("[vip] %s command(%s)", name, command)
+	cmd := config.Shell(context.Background(), command)
+	cmd.Stderr = newPrefix("[" + name + ".stderr]")
+	out, err := cmd.Output()
+	if err != nil {
+		return out, fmt.Errorf("%s failed: %v", name, err)
+	}
+	return out, nil
+}
+
+func newPrefix(prefix string) io.Writer {
+	read, write := io.Pipe()
+	scan := bufio.NewScanner(read)
+	go func() {
+		for scan.Scan() {
+			fmt.Printf("%v %v\n", prefix, scan.Text())
+		}
+	}()
+	return write
+}
@@ -0,0 +1,74 @@
+// Copyright (c) 2015 Pagoda Box Inc
+//
+// This Source Code Form is subject to the terms of the Mozilla Public License, v.
+// 2.0. If a copy of the MPL was not distributed with this file, You can obtain one
+// at http://mozilla.org/MPL/2.0/.
+//
+
+package vip_test
+
+import (
+	"github.com/nanopack/yoke/config"
+	"github.com/nanopack/yoke/vip"
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestCommand(test *testing.T) {
+	file, err := ioutil.TempFile("", "vip")
+	if err != nil {
+		test.Log(err)
+		test.FailNow()
+	}
+	file.Close()
+	defer os.Remove(file.Name())
+
+	floating, err := vip.New(config.Config{
+		Vip:              "10.0.0.5",
+		VipAddCommand:    "echo add >> " + file.Name() + " && echo",
+		VipRemoveCommand: "echo remove >> " + file.Name() + " && echo",
+	})
+	if err != nil {
+		test.Log(err)
+		test.FailNow()
+	}
+
+	if err := floating.Add(); err != nil {
+		test.Log(err)
+		test.FailNow()
+	}
+	if err := floating.Remove(); err != nil {
+		test.Log(err)
+		test.FailNow()
+	}
+
+	out, err := ioutil.ReadFile(file.Name())
+	if err != nil {
+		test.Log(err)
+		test.FailNow()
+	}
+	if strings.Join(strings.Fields(string(out)), " ") != "add remove" {
+		test.Logf("commands were not run in order '%s'", out)
+		test.FailNow()
+	}
+}
+
+func TestNew(test *testing.T) {
+	floating, err := vip.New(config.Config{})
+	if err != nil || floating != vip.None {
+		test.Log("no vip should be managed without an ip", err)
+		test.FailNow()
+	}
+
+	if _, err := vip.New(config.Config{Vip: "10.0.0.5", VipBackend: "ip"}); err == nil {
+		test.Log("the ip backend should require an interface")
+		test.FailNow()
+	}
+
+	if _, err := vip.New(config.Config{Vip: "10.0.0.5", VipBackend: "carrier-pigeon"}); err == nil {
+		test.Log("unknown backends should be rejected")
+		test.FailNow()
+	}
+}
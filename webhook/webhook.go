@@ -0,0 +1,102 @@
+// Copyright (c) 2015 Pagoda Box Inc
+//
+// This Source Code Form is subject to the terms of the Mozilla Public License, v.
+// 2.0. If a copy of the MPL was not distributed with this file, You can obtain one
+// at http://mozilla.org/MPL/2.0/.
+//
+
+// webhook posts the events of a node as json to the urls in the [webhook] section
+// of the config, so failovers can be noticed without watching the logs.
+package webhook
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"github.com/nanopack/yoke/config"
+	"github.com/nanopack/yoke/events"
+	"net/http"
+	"time"
+)
+
+type (
+	// Webhook delivers events to the configured urls
+	Webhook struct {
+		urls    []string
+		headers map[string]string
+		types   map[events.Type]bool
+		retries int
+		delay   time.Duration
+		client  *http.Client
+	}
+)
+
+// New creates a webhook from the [webhook] section of the config
+func New(conf config.Config) *Webhook {
+	webhook := &Webhook{
+		urls:    conf.WebhookURLs(),
+		headers: conf.WebhookHeaders(),
+		types:   map[events.Type]bool{},
+		retries: conf.WebhookRetries,
+		delay:   time.Duration(conf.WebhookRetryDelay) * time.Second,
+		client:  &http.Client{Timeout: time.Duration(conf.WebhookTimeout) * time.Second},
+	}
+	for _, kind := range conf.WebhookEvents() {
+		webhook.types[events.Type(kind)] = true
+	}
+	return webhook
+}
+
+// Handle is an events.Handler that sends every event the webhook is interested
+// in to all of the urls
+func (webhook *Webhook) Handle(event events.Event) {
+	if !webhook.types[event.Type] {
+		return
+	}
+	for _, url := range webhook.urls {
+		if err := webhook.Send(url, event); err != nil {
+			config.Log.Error("[webhook] unable to deliver '%v' to %v: %v", event.Type, url, err)
+		}
+	}
+}
+
+// Send posts the event to url, retrying with a doubling delay when it can not be
+// delivered
+func (webhook *Webhook) Send(url string, event events.Event) error {
+	body, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+
+	delay := webhook.delay
+	for attempt := 0; ; attempt++ {
+		err = webhook.post(url, body)
+		if err == nil || attempt >= webhook.retries {
+			return err
+		}
+		config.Log.Warn("[webhook] delivering '%v' to %v failed, retrying in %v: %v", event.Type, url, delay, err)
+		time.Sleep(delay)
+		delay *= 2
+	}
+}
+
+func (webhook *Webhook) post(url string, body []byte) error {
+	req, err := http.NewRequest("POST", url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for name, value := range webhook.headers {
+		req.Header.Set(name, value)
+	}
+
+	res, err := webhook.client.Do(req)
+	if err != nil {
+		return err
+	}
+	res.Body.Close()
+	if res.StatusCode < 200 || res.StatusCode > 299 {
+		return fmt.Errorf("unexpected response %v", res.Status)
+	}
+	return nil
+}
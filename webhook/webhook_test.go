@@ -0,0 +1,63 @@
+// Copyright (c) 2015 Pagoda Box Inc
+//
+// This Source Code Form is subject to the terms of the Mozilla Public License, v.
+// 2.0. If a copy of the MPL was not distributed with this file, You can obtain one
+// at http://mozilla.org/MPL/2.0/.
+//
+
+package webhook_test
+
+import (
+	"encoding/json"
+	"github.com/nanopack/yoke/config"
+	"github.com/nanopack/yoke/events"
+	"github.com/nanopack/yoke/webhook"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestHandle(test *testing.T) {
+	attempts := 0
+	received := []events.Event{}
+	server := httptest.NewServer(http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+		attempts++
+		if req.Header.Get("X-Token") != "secret" {
+			test.Logf("missing header, got '%v'", req.Header.Get("X-Token"))
+			test.Fail()
+		}
+		// the first attempt fails so the webhook has to retry
+		if attempts == 1 {
+			res.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		event := events.Event{}
+		if err := json.NewDecoder(req.Body).Decode(&event); err != nil {
+			test.Log(err)
+			test.Fail()
+		}
+		received = append(received, event)
+	}))
+	defer server.Close()
+
+	conf := config.Config{
+		WebhookURL:     server.URL,
+		WebhookHeader:  "X-Token: secret",
+		WebhookEvent:   "promotion_completed,stopped",
+		WebhookRetries: 1,
+		WebhookTimeout: 1,
+	}
+	hook := webhook.New(conf)
+
+	hook.Handle(events.Event{Type: events.PromotionStarted, Node: "127.0.0.1:1234"})
+	hook.Handle(events.Event{Type: events.PromotionCompleted, Node: "127.0.0.1:1234"})
+
+	if attempts != 2 {
+		test.Logf("expected 2 attempts, got %v", attempts)
+		test.FailNow()
+	}
+	if len(received) != 1 || received[0].Type != events.PromotionCompleted {
+		test.Logf("wrong events were delivered %v", received)
+		test.FailNow()
+	}
+}
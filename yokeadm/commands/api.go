@@ -0,0 +1,84 @@
+// Copyright (c) 2015 Pagoda Box Inc
+//
+// This Source Code Form is subject to the terms of the Mozilla Public License, v.
+// 2.0. If a copy of the MPL was not distributed with this file, You can obtain one
+// at http://mozilla.org/MPL/2.0/.
+//
+
+package commands
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"strings"
+
+	"github.com/nanopack/yoke/admin"
+)
+
+// client is used for every request to a node's admin api, there is no timeout as
+// a switchover can take as long as the timeout it was given
+var client = &http.Client{}
+
+// request issues a request to the admin api of the designated node, decoding the
+// reply into out
+func request(method, path string, out interface{}) error {
+	return requestAt(fmt.Sprintf("%s:%s", fHost, fPort), method, path, out)
+}
+
+// requestAt issues a request to the admin api listening at address
+func requestAt(address, method, path string, out interface{}) error {
+	req, err := http.NewRequest(method, fmt.Sprintf("http://%s%s", address, path), nil)
+	if err != nil {
+		return err
+	}
+
+	res, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		body, _ := ioutil.ReadAll(res.Body)
+		return fmt.Errorf("%s (%s)", strings.TrimSpace(string(body)), res.Status)
+	}
+
+	if out == nil {
+		return nil
+	}
+	return json.NewDecoder(res.Body).Decode(out)
+}
+
+// action posts to one of the transition endpoints of the designated node and
+// prints the status the node replied with
+func action(name, path string) {
+	status := admin.Status{}
+	if err := request("POST", path, &status); err != nil {
+		fmt.Printf("[commands/%s] request failed - %s\n", name, err.Error())
+		os.Exit(1)
+	}
+	printStatus([]admin.Status{status})
+}
+
+// printStatus displays the status of nodes as a table
+func printStatus(members []admin.Status) {
+	fmt.Println(`
+Cluster Role |      Location       |  Postgres Role  | Synced |     Position     | Epoch | Paused
+----------------------------------------------------------------------------------------------------`)
+	for _, member := range members {
+		fmt.Printf("%-12s | %-19s | %-15s | %-6t | %-16X | %-5d | %t\n", member.Role, member.Location, member.DBRole, member.Synced, member.Position, member.Epoch, member.Paused)
+	}
+	fmt.Println("")
+	for _, member := range members {
+		if member.RTO == nil || member.RTO.Met {
+			continue
+		}
+		for _, warning := range member.RTO.Warnings {
+			fmt.Printf("%s: %s\n", member.Location, warning)
+		}
+		fmt.Println("")
+	}
+}
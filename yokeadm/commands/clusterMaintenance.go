@@ -0,0 +1,40 @@
+// Copyright (c) 2015 Pagoda Box Inc
+//
+// This Source Code Form is subject to the terms of the Mozilla Public License, v.
+// 2.0. If a copy of the MPL was not distributed with this file, You can obtain one
+// at http://mozilla.org/MPL/2.0/.
+//
+
+package commands
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/spf13/cobra"
+)
+
+// maintenanceCmd is used to stop the whole cluster from making automatic transitions
+var maintenanceCmd = &cobra.Command{
+	Use:   "maintenance on|off",
+	Short: "Starts or ends maintenance of the whole cluster",
+	Long:  `While the cluster is in maintenance every node keeps checking the cluster, but only plans the transitions it would make. It can be switched on or off at any node, the other nodes pick it up on their next check.`,
+
+	Run: clusterMaintenance,
+}
+
+// clusterMaintenance switches maintenance on or off through the designated node
+func clusterMaintenance(ccmd *cobra.Command, args []string) {
+	if len(args) != 1 || (args[0] != "on" && args[0] != "off") {
+		fmt.Println("[commands/clusterMaintenance] expected 'on' or 'off'")
+		os.Exit(1)
+	}
+	enabled := args[0] == "on"
+	if enabled {
+		fmt.Printf("starting maintenance through '%s'...\n", fHost)
+	} else {
+		fmt.Printf("ending maintenance through '%s'...\n", fHost)
+	}
+
+	action("clusterMaintenance", fmt.Sprintf("/maintenance?enabled=%t", enabled))
+}
@@ -0,0 +1,177 @@
+// Copyright (c) 2015 Pagoda Box Inc
+//
+// This Source Code Form is subject to the terms of the Mozilla Public License, v.
+// 2.0. If a copy of the MPL was not distributed with this file, You can obtain one
+// at http://mozilla.org/MPL/2.0/.
+//
+
+package commands
+
+import (
+	"bufio"
+	"fmt"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/nanopack/yoke/admin"
+	"github.com/nanopack/yoke/state"
+	"github.com/spf13/cobra"
+)
+
+var (
+	// majorUpgradeCmd is used to move the cluster to a new major version of postgres
+	majorUpgradeCmd = &cobra.Command{
+		Use:   "major-upgrade [host:port...]",
+		Short: "Upgrades the cluster to a new major version of postgres with pg_upgrade",
+		Long: `Upgrades the designated node and any other admin api addresses given as arguments,
+which have to be the active node and its backups, to the new major version of
+postgres in the new_bin_dir of their [upgrade] section. The first backup checks
+that pg_upgrade can upgrade its data, the active node is frozen so that it turns
+down writes, the backup catches up with it and upgrades its data, the other nodes
+stop, the upgraded backup takes over and the other nodes are reseeded from it.
+The command asks before each stage, until the upgraded backup took over the
+cluster can be left as it was.`,
+
+		Run: clusterMajorUpgrade,
+	}
+
+	// flags
+	fMajorTimeout time.Duration //
+	fReseed       bool          //
+)
+
+func init() {
+	majorUpgradeCmd.Flags().DurationVarP(&fMajorTimeout, "timeout", "t", time.Minute, "how long to wait for the backup to catch up with the frozen active node")
+	majorUpgradeCmd.Flags().BoolVarP(&fReseed, "reseed", "r", false, "only reseed the nodes that were left stopped by an earlier run")
+}
+
+// clusterMajorUpgrade steps through the stages of a major upgrade, asking the
+// operator before each of them
+func clusterMajorUpgrade(ccmd *cobra.Command, args []string) {
+	addresses := append([]string{fmt.Sprintf("%s:%s", fHost, fPort)}, args...)
+	if fReseed {
+		reseedAll(addresses)
+		clusterList(ccmd, args)
+		return
+	}
+
+	active := ""
+	backups := []string{}
+	for _, address := range addresses {
+		status := admin.Status{}
+		if err := requestAt(address, "GET", "/status", &status); err != nil {
+			fmt.Printf("[commands/clusterMajorUpgrade] Failed to get the status of '%s'! %s\n", address, err)
+			os.Exit(1)
+		}
+		switch state.DBRole(status.DBRole) {
+		case state.Active:
+			active = address
+		case state.Backup:
+			backups = append(backups, address)
+		default:
+			fmt.Printf("[commands/clusterMajorUpgrade] '%s' is '%s', only the active node and its backups can be upgraded\n", address, status.DBRole)
+			os.Exit(1)
+		}
+	}
+	if active == "" || len(backups) == 0 {
+		fmt.Println("[commands/clusterMajorUpgrade] the active node and at least one backup have to be given")
+		os.Exit(1)
+	}
+	// the active node stops last, so no backup takes over from it
+	upgraded, others := backups[0], append(append([]string{}, backups[1:]...), active)
+
+	fmt.Printf("checking that pg_upgrade can upgrade '%s'...\n", upgraded)
+	if err := stageAt(upgraded, "check"); err != nil {
+		fmt.Printf("[commands/clusterMajorUpgrade] the check failed, nothing was changed - %s\n", err.Error())
+		os.Exit(1)
+	}
+	if !confirm(fmt.Sprintf("'%s' can be upgraded. Freeze '%s', which turns down writes until the upgraded node takes over?", upgraded, active)) {
+		return
+	}
+
+	if err := stageAt(active, "freeze"); err != nil {
+		fmt.Printf("[commands/clusterMajorUpgrade] freezing '%s' failed - %s\n", active, err.Error())
+		thaw(active)
+		os.Exit(1)
+	}
+	if !confirm(fmt.Sprintf("'%s' is frozen. Upgrade the data of '%s' with pg_upgrade?", active, upgraded)) {
+		thaw(active)
+		return
+	}
+
+	fmt.Printf("upgrading '%s'...\n", upgraded)
+	if err := stageAt(upgraded, "upgrade"); err != nil {
+		fmt.Printf("[commands/clusterMajorUpgrade] upgrading '%s' failed - %s\n", upgraded, err.Error())
+		thaw(active)
+		leftUpgrading(upgraded)
+		os.Exit(1)
+	}
+	if !confirm(fmt.Sprintf("'%s' was upgraded. Stop the other nodes and have it take over? This can not be undone.", upgraded)) {
+		thaw(active)
+		leftUpgrading(upgraded)
+		return
+	}
+
+	for _, other := range others {
+		fmt.Printf("stopping '%s'...\n", other)
+		if err := stageAt(other, "stop"); err != nil {
+			fmt.Printf("[commands/clusterMajorUpgrade] stopping '%s' failed - %s\n", other, err.Error())
+			os.Exit(1)
+		}
+	}
+	fmt.Printf("promoting '%s'...\n", upgraded)
+	if err := stageAt(upgraded, "promote"); err != nil {
+		fmt.Printf("[commands/clusterMajorUpgrade] promoting '%s' failed - %s\n", upgraded, err.Error())
+		os.Exit(1)
+	}
+	if !confirm(fmt.Sprintf("'%s' takes writes on the new version. Reseed the other nodes from it?", upgraded)) {
+		fmt.Printf("the other nodes are left stopped, reseed them with 'yokeadm major-upgrade --reseed -H <host> -p <port> [host:port...]'\n")
+		return
+	}
+
+	reseedAll(others)
+	clusterList(ccmd, args)
+}
+
+// stageAt runs a stage of the major upgrade on the node at address
+func stageAt(address, stage string) error {
+	path := fmt.Sprintf("/major-upgrade?stage=%s&timeout=%s", stage, url.QueryEscape(fMajorTimeout.String()))
+	return requestAt(address, "POST", path, nil)
+}
+
+// reseedAll has every node at addresses follow the upgraded node as a backup
+func reseedAll(addresses []string) {
+	for _, address := range addresses {
+		fmt.Printf("reseeding '%s'...\n", address)
+		if err := stageAt(address, "reseed"); err != nil {
+			fmt.Printf("[commands/clusterMajorUpgrade] reseeding '%s' failed - %s\n", address, err.Error())
+			os.Exit(1)
+		}
+	}
+}
+
+// thaw has the frozen active node take writes again
+func thaw(active string) {
+	fmt.Printf("thawing '%s'...\n", active)
+	if err := stageAt(active, "thaw"); err != nil {
+		fmt.Printf("[commands/clusterMajorUpgrade] thawing '%s' failed, it still turns down writes - %s\n", active, err.Error())
+		os.Exit(1)
+	}
+}
+
+func leftUpgrading(address string) {
+	fmt.Printf("'%s' is left upgrading, the data of the old version is kept next to its data_dir\n", address)
+}
+
+// confirm asks the operator a yes or no question
+func confirm(question string) bool {
+	fmt.Printf("%s [y/N] ", question)
+	answer, _ := bufio.NewReader(os.Stdin).ReadString('\n')
+	switch strings.ToLower(strings.TrimSpace(answer)) {
+	case "y", "yes":
+		return true
+	}
+	return false
+}
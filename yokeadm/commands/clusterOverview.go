@@ -0,0 +1,59 @@
+// Copyright (c) 2015 Pagoda Box Inc
+//
+// This Source Code Form is subject to the terms of the Mozilla Public License, v.
+// 2.0. If a copy of the MPL was not distributed with this file, You can obtain one
+// at http://mozilla.org/MPL/2.0/.
+//
+
+package commands
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/nanopack/yoke/admin"
+	"github.com/spf13/cobra"
+)
+
+// clusterOverviewCmd is used to show the whole cluster as one node sees it
+var clusterOverviewCmd = &cobra.Command{
+	Use:   "overview",
+	Short: "Returns status information for the whole cluster from a single node",
+	Long: `Asks the designated node, which can be the monitor, to report on every node of the
+cluster: their roles, lag, the last decision of their deciders and how each of them
+reached the other nodes on its last check.`,
+
+	Run: clusterOverview,
+}
+
+// clusterOverview displays the cluster as the designated node gathered it
+func clusterOverview(ccmd *cobra.Command, args []string) {
+	nodes := []admin.Node{}
+	if err := request("GET", "/cluster", &nodes); err != nil {
+		fmt.Printf("[commands/clusterOverview] request failed - %s\n", err.Error())
+		os.Exit(1)
+	}
+
+	fmt.Println(`
+Cluster Role |      Location       |  Postgres Role  |    Phase    | Lag (bytes) | Epoch |     Last Decision    | Reached
+----------------------------------------------------------------------------------------------------------------------------`)
+	for _, node := range nodes {
+		if node.Error != "" {
+			fmt.Printf("%-12s | %-19s | %s\n", "?", node.Location, node.Error)
+			continue
+		}
+		reached := []string{}
+		for location, how := range node.Peers {
+			reached = append(reached, fmt.Sprintf("%s (%s)", location, how))
+		}
+		sort.Strings(reached)
+		phase := string(node.Phase)
+		if phase == "" {
+			phase = "-"
+		}
+		fmt.Printf("%-12s | %-19s | %-15s | %-11s | %-11d | %-5d | %-20s | %s\n", node.Role, node.Location, node.DBRole, phase, node.LagBytes, node.Epoch, node.Rule, strings.Join(reached, ", "))
+	}
+	fmt.Println("")
+}
@@ -0,0 +1,48 @@
+// Copyright (c) 2015 Pagoda Box Inc
+//
+// This Source Code Form is subject to the terms of the Mozilla Public License, v.
+// 2.0. If a copy of the MPL was not distributed with this file, You can obtain one
+// at http://mozilla.org/MPL/2.0/.
+//
+
+package commands
+
+import (
+	"fmt"
+
+	"github.com/spf13/cobra"
+)
+
+var (
+	// pauseCmd is used to stop a node from making automatic transitions
+	pauseCmd = &cobra.Command{
+		Use:   "pause",
+		Short: "Stops a node from making automatic transitions",
+		Long:  ``,
+
+		Run: clusterPause,
+	}
+
+	// resumeCmd is used to let a node go back to making automatic transitions
+	resumeCmd = &cobra.Command{
+		Use:   "resume",
+		Short: "Lets a paused node make automatic transitions again",
+		Long:  ``,
+
+		Run: clusterResume,
+	}
+)
+
+// clusterPause pauses the decider of the designated node
+func clusterPause(ccmd *cobra.Command, args []string) {
+	fmt.Printf("pausing '%s'...\n", fHost)
+
+	action("clusterPause", "/pause")
+}
+
+// clusterResume resumes the decider of the designated node
+func clusterResume(ccmd *cobra.Command, args []string) {
+	fmt.Printf("resuming '%s'...\n", fHost)
+
+	action("clusterResume", "/resume")
+}
@@ -0,0 +1,35 @@
+// Copyright (c) 2015 Pagoda Box Inc
+//
+// This Source Code Form is subject to the terms of the Mozilla Public License, v.
+// 2.0. If a copy of the MPL was not distributed with this file, You can obtain one
+// at http://mozilla.org/MPL/2.0/.
+//
+
+package commands
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/nanopack/yoke/admin"
+	"github.com/spf13/cobra"
+)
+
+// statusCmd is used to show the status of the designated node
+var statusCmd = &cobra.Command{
+	Use:   "status",
+	Short: "Returns status information for a node",
+	Long:  ``,
+
+	Run: clusterStatus,
+}
+
+// clusterStatus displays the status of the designated node
+func clusterStatus(ccmd *cobra.Command, args []string) {
+	status := admin.Status{}
+	if err := request("GET", "/status", &status); err != nil {
+		fmt.Printf("[commands/clusterStatus] request failed - %s\n", err.Error())
+		os.Exit(1)
+	}
+	printStatus([]admin.Status{status})
+}
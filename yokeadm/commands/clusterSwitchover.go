@@ -0,0 +1,43 @@
+// Copyright (c) 2015 Pagoda Box Inc
+//
+// This Source Code Form is subject to the terms of the Mozilla Public License, v.
+// 2.0. If a copy of the MPL was not distributed with this file, You can obtain one
+// at http://mozilla.org/MPL/2.0/.
+//
+
+package commands
+
+import (
+	"fmt"
+	"net/url"
+	"time"
+
+	"github.com/spf13/cobra"
+)
+
+var (
+	// switchoverCmd is used to hand the active role over to a backup
+	switchoverCmd = &cobra.Command{
+		Use:   "switchover",
+		Short: "Hands the active role over from a node to its backup",
+		Long: `Asks the designated node, which has to be the active node, to wait for a backup
+to catch up, stop its database, and hand the active role over to the most caught
+up backup. The node then follows the new active node as a backup.`,
+
+		Run: clusterSwitchover,
+	}
+
+	// flags
+	fSwitchoverTimeout time.Duration //
+)
+
+func init() {
+	switchoverCmd.Flags().DurationVarP(&fSwitchoverTimeout, "timeout", "t", time.Minute, "how long to wait for a backup to catch up")
+}
+
+// clusterSwitchover hands the active role of the designated node over to a backup
+func clusterSwitchover(ccmd *cobra.Command, args []string) {
+	fmt.Printf("asking '%s' to hand over to its backup...\n", fHost)
+
+	action("clusterSwitchover", "/switchover?timeout="+url.QueryEscape(fSwitchoverTimeout.String()))
+}
@@ -0,0 +1,112 @@
+// Copyright (c) 2015 Pagoda Box Inc
+//
+// This Source Code Form is subject to the terms of the Mozilla Public License, v.
+// 2.0. If a copy of the MPL was not distributed with this file, You can obtain one
+// at http://mozilla.org/MPL/2.0/.
+//
+
+package commands
+
+import (
+	"fmt"
+	"net/url"
+	"os"
+	"time"
+
+	"github.com/nanopack/yoke/admin"
+	"github.com/nanopack/yoke/state"
+	"github.com/spf13/cobra"
+)
+
+var (
+	// upgradeCmd is used to upgrade the databases of the cluster one node at a time
+	upgradeCmd = &cobra.Command{
+		Use:   "upgrade [host:port...]",
+		Short: "Upgrades the databases of the cluster one node at a time",
+		Long: `Upgrades the designated node and any other admin api addresses given as arguments,
+which have to be the active node and its backups. Every backup is upgraded first,
+then the active role is switched over to a backup, and the node that used to be
+active is upgraded once it follows the new active node. Each node stops its
+database, runs its upgrade command and starts the database again, the cluster
+keeps running on the other nodes in the meantime.`,
+
+		Run: clusterUpgrade,
+	}
+
+	// flags
+	fUpgradeTimeout time.Duration //
+)
+
+func init() {
+	upgradeCmd.Flags().DurationVarP(&fUpgradeTimeout, "timeout", "t", time.Minute, "how long to wait for a backup to catch up before and after switching over")
+}
+
+// clusterUpgrade upgrades the backups, switches over and upgrades the former active
+func clusterUpgrade(ccmd *cobra.Command, args []string) {
+	addresses := append([]string{fmt.Sprintf("%s:%s", fHost, fPort)}, args...)
+
+	active := ""
+	backups := []string{}
+	for _, address := range addresses {
+		status := admin.Status{}
+		if err := requestAt(address, "GET", "/status", &status); err != nil {
+			fmt.Printf("[commands/clusterUpgrade] Failed to get the status of '%s'! %s\n", address, err)
+			os.Exit(1)
+		}
+		switch state.DBRole(status.DBRole) {
+		case state.Active:
+			active = address
+		case state.Backup:
+			backups = append(backups, address)
+		default:
+			fmt.Printf("[commands/clusterUpgrade] '%s' is '%s', only the active node and its backups can be upgraded\n", address, status.DBRole)
+			os.Exit(1)
+		}
+	}
+	if active == "" || len(backups) == 0 {
+		fmt.Println("[commands/clusterUpgrade] the active node and at least one backup have to be given")
+		os.Exit(1)
+	}
+
+	for _, backup := range backups {
+		upgradeAt(backup)
+	}
+
+	fmt.Printf("asking '%s' to hand over to its backup...\n", active)
+	if err := requestAt(active, "POST", "/switchover?timeout="+url.QueryEscape(fUpgradeTimeout.String()), nil); err != nil {
+		fmt.Printf("[commands/clusterUpgrade] switching over failed - %s\n", err.Error())
+		os.Exit(1)
+	}
+	waitForBackup(active)
+	upgradeAt(active)
+
+	clusterList(ccmd, args)
+}
+
+// upgradeAt upgrades the backup at address, which is back to following the active
+// node once the request returns
+func upgradeAt(address string) {
+	fmt.Printf("upgrading '%s'...\n", address)
+	if err := requestAt(address, "POST", "/upgrade", nil); err != nil {
+		fmt.Printf("[commands/clusterUpgrade] upgrading '%s' failed - %s\n", address, err.Error())
+		os.Exit(1)
+	}
+}
+
+// waitForBackup waits for the node at address to follow the new active node as a
+// synced backup
+func waitForBackup(address string) {
+	fmt.Printf("waiting for '%s' to follow the new active node...\n", address)
+	deadline := time.Now().Add(fUpgradeTimeout)
+	for {
+		status := admin.Status{}
+		if err := requestAt(address, "GET", "/status", &status); err == nil && state.DBRole(status.DBRole) == state.Backup && status.Synced {
+			return
+		}
+		if time.Now().After(deadline) {
+			fmt.Printf("[commands/clusterUpgrade] '%s' did not become a synced backup in time\n", address)
+			os.Exit(1)
+		}
+		<-time.After(time.Second)
+	}
+}
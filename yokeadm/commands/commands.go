@@ -22,6 +22,7 @@ var (
 	// subcommands
 	clusterCmd = &cobra.Command{Use: "cluster", Short: "", Long: ``}
 	memberCmd  = &cobra.Command{Use: "member", Short: "", Long: ``}
+	drCmd      = &cobra.Command{Use: "dr", Short: "Manages a DR cluster", Long: ``}
 
 	// flags
 	fHost string //
@@ -32,14 +33,32 @@ var (
 func init() {
 
 	// persistent flags
-	YokeCmd.PersistentFlags().StringVarP(&fHost, "host", "H", "localhost", "")
-	YokeCmd.PersistentFlags().StringVarP(&fPort, "port", "p", "4400", "")
+	YokeCmd.PersistentFlags().StringVarP(&fHost, "host", "H", "localhost", "the node to talk to")
+	YokeCmd.PersistentFlags().StringVarP(&fPort, "port", "p", "4500", "the port of the node's admin api")
 
 	//
 	YokeCmd.AddCommand(clusterCmd)
 	clusterCmd.AddCommand(clusterListCmd)
+	clusterCmd.AddCommand(clusterOverviewCmd)
 
 	//
 	YokeCmd.AddCommand(memberCmd)
 	memberCmd.AddCommand(memberDemoteCmd)
+	memberCmd.AddCommand(memberReplaceCmd)
+
+	//
+	YokeCmd.AddCommand(drCmd)
+	drCmd.AddCommand(drPromoteCmd)
+
+	//
+	YokeCmd.AddCommand(statusCmd)
+	YokeCmd.AddCommand(failoverCmd)
+	YokeCmd.AddCommand(switchoverCmd)
+	YokeCmd.AddCommand(upgradeCmd)
+	YokeCmd.AddCommand(majorUpgradeCmd)
+	YokeCmd.AddCommand(pauseCmd)
+	YokeCmd.AddCommand(resumeCmd)
+	YokeCmd.AddCommand(maintenanceCmd)
+	YokeCmd.AddCommand(reloadCmd)
+	YokeCmd.AddCommand(recheckCmd)
 }
@@ -0,0 +1,34 @@
+// Copyright (c) 2015 Pagoda Box Inc
+//
+// This Source Code Form is subject to the terms of the Mozilla Public License, v.
+// 2.0. If a copy of the MPL was not distributed with this file, You can obtain one
+// at http://mozilla.org/MPL/2.0/.
+//
+
+package commands
+
+import (
+	"fmt"
+
+	"github.com/spf13/cobra"
+)
+
+// drPromoteCmd is used to have a DR cluster take writes
+var drPromoteCmd = &cobra.Command{
+	Use:   "promote",
+	Short: "Promotes the DR cluster of the designated node",
+	Long: `The DR cluster stops streaming from the cluster it is the DR cluster of, and its
+writable node starts taking writes. It can be run against any node of the DR
+cluster, the other nodes pick it up on their next check. A promoted DR cluster
+stays promoted, it has to be set up as the DR cluster of the other one again to
+track it.`,
+
+	Run: drPromote,
+}
+
+// drPromote promotes the DR cluster through the designated node
+func drPromote(ccmd *cobra.Command, args []string) {
+	fmt.Printf("promoting the DR cluster through '%s'...\n", fHost)
+
+	action("drPromote", "/dr/promote")
+}
@@ -0,0 +1,32 @@
+// Copyright (c) 2015 Pagoda Box Inc
+//
+// This Source Code Form is subject to the terms of the Mozilla Public License, v.
+// 2.0. If a copy of the MPL was not distributed with this file, You can obtain one
+// at http://mozilla.org/MPL/2.0/.
+//
+
+package commands
+
+import (
+	"fmt"
+
+	"github.com/spf13/cobra"
+)
+
+// failoverCmd is used to force a designated member node to take over the cluster
+var failoverCmd = &cobra.Command{
+	Use:   "failover",
+	Short: "Forces a node to take over as the active node",
+	Long: `Forces the designated node to take over as the active node without waiting
+for the current active node. Any writes that have not been replicated yet are lost,
+use 'switchover' when the active node is still healthy.`,
+
+	Run: memberFailover,
+}
+
+// memberFailover promotes the designated member node
+func memberFailover(ccmd *cobra.Command, args []string) {
+	fmt.Printf("forcing '%s' to take over...\n", fHost)
+
+	action("memberFailover", "/promote")
+}
@@ -0,0 +1,41 @@
+// Copyright (c) 2015 Pagoda Box Inc
+//
+// This Source Code Form is subject to the terms of the Mozilla Public License, v.
+// 2.0. If a copy of the MPL was not distributed with this file, You can obtain one
+// at http://mozilla.org/MPL/2.0/.
+//
+
+package commands
+
+import (
+	"fmt"
+
+	"github.com/spf13/cobra"
+)
+
+var (
+	// recheckCmd is used to have a node recheck the cluster right away
+	recheckCmd = &cobra.Command{
+		Use:   "recheck",
+		Short: "Has a node recheck the cluster right away",
+		Long: `Rechecks the cluster instead of waiting for the next check. A node does not make
+a transition again that it has already made, --force makes it anyway for when the
+database got out of step with what the node thinks it did.`,
+
+		Run: memberRecheck,
+	}
+
+	// flags
+	fForce bool //
+)
+
+func init() {
+	recheckCmd.Flags().BoolVarP(&fForce, "force", "f", false, "make the transition again even when it was already made")
+}
+
+// memberRecheck rechecks the cluster from the designated node
+func memberRecheck(ccmd *cobra.Command, args []string) {
+	fmt.Printf("asking '%s' to recheck the cluster...\n", fHost)
+
+	action("memberRecheck", fmt.Sprintf("/recheck?force=%t", fForce))
+}
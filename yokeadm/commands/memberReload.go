@@ -0,0 +1,30 @@
+// Copyright (c) 2015 Pagoda Box Inc
+//
+// This Source Code Form is subject to the terms of the Mozilla Public License, v.
+// 2.0. If a copy of the MPL was not distributed with this file, You can obtain one
+// at http://mozilla.org/MPL/2.0/.
+//
+
+package commands
+
+import (
+	"fmt"
+
+	"github.com/spf13/cobra"
+)
+
+// reloadCmd is used to have a node read its config file again
+var reloadCmd = &cobra.Command{
+	Use:   "reload",
+	Short: "Has a node read its config file again",
+	Long:  `Applies the check interval, timeouts, peer addresses, log level and thresholds from the config file of the node without restarting it. Every other option needs a restart.`,
+
+	Run: memberReload,
+}
+
+// memberReload reloads the config of the designated node
+func memberReload(ccmd *cobra.Command, args []string) {
+	fmt.Printf("reloading the config of '%s'...\n", fHost)
+
+	action("memberReload", "/reload")
+}
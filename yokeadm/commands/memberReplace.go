@@ -0,0 +1,49 @@
+// Copyright (c) 2015 Pagoda Box Inc
+//
+// This Source Code Form is subject to the terms of the Mozilla Public License, v.
+// 2.0. If a copy of the MPL was not distributed with this file, You can obtain one
+// at http://mozilla.org/MPL/2.0/.
+//
+
+package commands
+
+import (
+	"fmt"
+	"net/url"
+	"os"
+
+	"github.com/nanopack/yoke/admin"
+	"github.com/spf13/cobra"
+)
+
+// memberReplaceCmd is used to swap a dead node for a new one
+var memberReplaceCmd = &cobra.Command{
+	Use:   "replace old new [host:port...]",
+	Short: "Replaces a dead node with a new one",
+	Long:  `Points the designated node, and any other admin api addresses given after the old and new rpc addresses (IP:port), at the new node instead of the dead one. Every node that is left in the cluster has to be given, the monitor included. The new node is synced by the active node, and only decided on once it has caught up. The config files still have to be updated before the nodes are restarted.`,
+
+	Run: memberReplace,
+}
+
+// memberReplace asks every given node to replace old with new
+func memberReplace(ccmd *cobra.Command, args []string) {
+	if len(args) < 2 {
+		fmt.Println("[commands/memberReplace] expected the old and the new address")
+		os.Exit(1)
+	}
+	old, address := args[0], args[1]
+	addresses := append([]string{fmt.Sprintf("%s:%s", fHost, fPort)}, args[2:]...)
+	path := fmt.Sprintf("/replace?old=%s&new=%s", url.QueryEscape(old), url.QueryEscape(address))
+
+	members := []admin.Status{}
+	for _, member := range addresses {
+		fmt.Printf("replacing '%s' with '%s' on '%s'...\n", old, address, member)
+		status := admin.Status{}
+		if err := requestAt(member, "POST", path, &status); err != nil {
+			fmt.Printf("[commands/memberReplace] request to '%s' failed - %s\n", member, err.Error())
+			os.Exit(1)
+		}
+		members = append(members, status)
+	}
+	printStatus(members)
+}
@@ -0,0 +1,159 @@
+// Copyright (c) 2015 Pagoda Box Inc
+//
+// This Source Code Form is subject to the terms of the Mozilla Public License, v.
+// 2.0. If a copy of the MPL was not distributed with this file, You can obtain one
+// at http://mozilla.org/MPL/2.0/.
+//
+
+// yoketest simulates a cluster in memory, so that a program embedding the decider
+// can test how it fails over without running postgres or talking over the network.
+// Every node keeps a real local state in a store in memory, the others see it
+// through views that can be cut, delayed or made to lie about its role, and the
+// deciders reason with a clock that only moves when the test moves it.
+
+package yoketest
+
+import (
+	"fmt"
+	"github.com/nanopack/yoke/config"
+	"github.com/nanopack/yoke/monitor"
+	"github.com/nanopack/yoke/state"
+	"sync"
+	"time"
+)
+
+type (
+	// Cluster is a simulated cluster of nodes and a monitor
+	Cluster struct {
+		Clock   *Clock
+		Monitor *Node
+		Nodes   []*Node
+
+		sync.Mutex
+		cut      map[link]bool
+		deciders []monitor.Decider
+	}
+
+	// Clock is a clock that only moves when it is told to
+	Clock struct {
+		sync.Mutex
+		now time.Time
+	}
+
+	// a link between two nodes, the nodes are in the order of their locations
+	link struct {
+		a string
+		b string
+	}
+)
+
+// NewCluster creates a cluster of a node for each of the roles and a monitor, it
+// is a primary and a secondary when no roles are given. The deciders of the process
+// reason with the clock of the cluster until it is closed.
+func NewCluster(roles ...string) (*Cluster, error) {
+	if len(roles) == 0 {
+		roles = []string{"primary", "secondary"}
+	}
+	cluster := &Cluster{
+		Clock: &Clock{now: time.Date(2015, time.January, 1, 0, 0, 0, 0, time.UTC)},
+		cut:   map[link]bool{},
+	}
+	for i, role := range roles {
+		node, err := newNode(cluster, role, fmt.Sprintf("10.0.0.%v:4400", i+1))
+		if err != nil {
+			return nil, err
+		}
+		cluster.Nodes = append(cluster.Nodes, node)
+	}
+	monitorNode, err := newNode(cluster, "monitor", fmt.Sprintf("10.0.0.%v:4400", len(roles)+1))
+	if err != nil {
+		return nil, err
+	}
+	cluster.Monitor = monitorNode
+	monitor.SetClock(cluster.Clock)
+	return cluster, nil
+}
+
+// Close gives the deciders of the process back the wall clock
+func (cluster *Cluster) Close() {
+	monitor.SetClock(nil)
+}
+
+// Decider starts a decider on the node, with a performer that only records what it
+// is told to do. It returns once the decider made its first decision.
+func (cluster *Cluster) Decider(node *Node, conf config.Config) (monitor.Decider, *Performer, error) {
+	timeout := conf.CallTimeout()
+	if timeout == 0 {
+		timeout = time.Second
+	}
+	others := []state.State{}
+	for _, other := range cluster.Nodes {
+		if other != node {
+			others = append(others, &view{from: node, to: other, timeout: timeout})
+		}
+	}
+	arbiter := &view{from: node, to: cluster.Monitor, timeout: timeout}
+	performer := &Performer{node: node}
+
+	decider, err := monitor.NewDecider(node, others, arbiter, performer, conf)
+	if err != nil {
+		return nil, nil, err
+	}
+	cluster.Lock()
+	cluster.deciders = append(cluster.deciders, decider)
+	cluster.Unlock()
+	return decider, performer, nil
+}
+
+// Recheck rechecks every decider once, in the order they were started. What each
+// recheck failed with can be read from its decider.
+func (cluster *Cluster) Recheck() {
+	cluster.Lock()
+	deciders := append([]monitor.Decider{}, cluster.deciders...)
+	cluster.Unlock()
+	for _, decider := range deciders {
+		decider.ReCheck()
+	}
+}
+
+// Cut stops the two nodes from reaching each other, both of them can still reach
+// every other node
+func (cluster *Cluster) Cut(a, b *Node) {
+	cluster.Lock()
+	defer cluster.Unlock()
+	cluster.cut[between(a, b)] = true
+}
+
+// Heal lets two nodes that were cut off from each other reach each other again
+func (cluster *Cluster) Heal(a, b *Node) {
+	cluster.Lock()
+	defer cluster.Unlock()
+	delete(cluster.cut, between(a, b))
+}
+
+func (cluster *Cluster) isCut(a, b *Node) bool {
+	cluster.Lock()
+	defer cluster.Unlock()
+	return cluster.cut[between(a, b)]
+}
+
+func between(a, b *Node) link {
+	if a.location > b.location {
+		a, b = b, a
+	}
+	return link{a: a.location, b: b.location}
+}
+
+// Now returns the time the clock was moved to
+func (clock *Clock) Now() time.Time {
+	clock.Lock()
+	defer clock.Unlock()
+	return clock.now
+}
+
+// Advance moves the clock on
+func (clock *Clock) Advance(d time.Duration) {
+	clock.Lock()
+	defer clock.Unlock()
+	clock.now = clock.now.Add(d)
+}
@@ -0,0 +1,198 @@
+// Copyright (c) 2015 Pagoda Box Inc
+//
+// This Source Code Form is subject to the terms of the Mozilla Public License, v.
+// 2.0. If a copy of the MPL was not distributed with this file, You can obtain one
+// at http://mozilla.org/MPL/2.0/.
+//
+
+package yoketest_test
+
+import (
+	"bytes"
+	"github.com/jcelliott/lumber"
+	"github.com/nanopack/yoke/config"
+	"github.com/nanopack/yoke/monitor"
+	"github.com/nanopack/yoke/state"
+	"github.com/nanopack/yoke/trace"
+	"github.com/nanopack/yoke/yoketest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// starts a decider on every node of a new cluster, in order
+func start(test *testing.T, conf config.Config) (*yoketest.Cluster, []*yoketest.Performer) {
+	cluster, err := yoketest.NewCluster()
+	if err != nil {
+		test.Log(err)
+		test.FailNow()
+	}
+	performers := []*yoketest.Performer{}
+	for _, node := range cluster.Nodes {
+		_, performer, err := cluster.Decider(node, conf)
+		if err != nil {
+			test.Log(err)
+			test.FailNow()
+		}
+		performers = append(performers, performer)
+	}
+	return cluster, performers
+}
+
+func dbRole(test *testing.T, node *yoketest.Node) state.DBRole {
+	role, err := node.GetDBRole()
+	if err != nil {
+		test.Log(err)
+		test.FailNow()
+	}
+	return state.DBRole(role)
+}
+
+func TestFailover(test *testing.T) {
+	cluster, performers := start(test, config.Config{})
+	defer cluster.Close()
+	primary, secondary := cluster.Nodes[0], cluster.Nodes[1]
+
+	if role := dbRole(test, primary); role != state.Active {
+		test.Logf("the primary should have come up active, not '%v'", role)
+		test.FailNow()
+	}
+	if role := dbRole(test, secondary); role != state.Backup {
+		test.Logf("the secondary should have come up as a backup, not '%v'", role)
+		test.FailNow()
+	}
+
+	primary.Drop()
+	cluster.Recheck()
+	if role := dbRole(test, secondary); role != state.Single {
+		test.Logf("the secondary should have taken over, it is '%v'", role)
+		test.Fail()
+	}
+	if transitions := performers[1].Transitions(); len(transitions) != 2 || transitions[1] != state.Single {
+		test.Logf("the secondary should have followed and then taken over, not %v", transitions)
+		test.Fail()
+	}
+}
+
+func TestFailoverDelay(test *testing.T) {
+	cluster, _ := start(test, config.Config{FailoverDelay: 30})
+	defer cluster.Close()
+	primary, secondary := cluster.Nodes[0], cluster.Nodes[1]
+
+	primary.Drop()
+	cluster.Recheck()
+	if role := dbRole(test, secondary); role != state.Backup {
+		test.Logf("the secondary should wait out the failover delay, it is '%v'", role)
+		test.FailNow()
+	}
+	cluster.Clock.Advance(time.Minute)
+	cluster.Recheck()
+	if role := dbRole(test, secondary); role != state.Single {
+		test.Logf("the secondary should have taken over after the failover delay, it is '%v'", role)
+		test.Fail()
+	}
+}
+
+func TestFaults(test *testing.T) {
+	cluster, _ := start(test, config.Config{})
+	defer cluster.Close()
+	primary, secondary := cluster.Nodes[0], cluster.Nodes[1]
+
+	// the monitor is too slow to answer, but the primary is still there
+	cluster.Monitor.Delay(time.Minute)
+	cluster.Recheck()
+	if role := dbRole(test, secondary); role != state.Backup {
+		test.Logf("a slow monitor should change nothing, the secondary is '%v'", role)
+		test.Fail()
+	}
+	cluster.Monitor.Delay(0)
+
+	// the secondary is lied to about the primary once
+	primary.Flap(state.Dead)
+	cluster.Recheck()
+	if role := dbRole(test, secondary); role == state.Backup {
+		test.Log("the secondary should have believed the primary was dead")
+		test.Fail()
+	}
+
+	// the primary and the secondary can't see each other, but the monitor sees both
+	cluster, _ = start(test, config.Config{})
+	defer cluster.Close()
+	primary, secondary = cluster.Nodes[0], cluster.Nodes[1]
+	cluster.Cut(primary, secondary)
+	cluster.Recheck()
+	if role := dbRole(test, secondary); role != state.Backup {
+		test.Logf("the secondary should still see the primary through the monitor, it is '%v'", role)
+		test.Fail()
+	}
+}
+
+func TestLogger(test *testing.T) {
+	out := &bytes.Buffer{}
+	log := config.NewLogger(out, lumber.INFO, "console").With(config.Fields{"cluster": "test"})
+	cluster, _ := start(test, config.Config{Logger: log})
+	cluster.Close()
+
+	lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+	ready := 0
+	for _, line := range lines {
+		if !strings.Contains(line, " cluster=test") {
+			test.Logf("the line %q is missing the field of the cluster", line)
+			test.Fail()
+		}
+		if strings.Contains(line, "cluster is ready") {
+			ready++
+		}
+	}
+	if ready != 2 {
+		test.Logf("both deciders should have written to the logger, got %q", lines)
+		test.Fail()
+	}
+}
+
+// keeps the names of the spans that were exported
+type recorder struct {
+	sync.Mutex
+	names []string
+}
+
+func (recorder *recorder) Export(span *trace.Span) {
+	recorder.Lock()
+	defer recorder.Unlock()
+	recorder.names = append(recorder.names, span.Name)
+}
+
+func TestTracing(test *testing.T) {
+	cluster, err := yoketest.NewCluster()
+	if err != nil {
+		test.Log(err)
+		test.FailNow()
+	}
+	defer cluster.Close()
+	deciders := []monitor.Decider{}
+	for _, node := range cluster.Nodes {
+		decider, _, err := cluster.Decider(node, config.Config{})
+		if err != nil {
+			test.Log(err)
+			test.FailNow()
+		}
+		deciders = append(deciders, decider)
+	}
+
+	spans := &recorder{}
+	trace.SetExporter(spans)
+	defer trace.SetExporter(nil)
+	cluster.Nodes[0].Drop()
+	deciders[1].ReCheck()
+
+	// the primary can't be found directly or through the monitor, and the
+	// secondary takes over
+	expected := []string{"direct", "bounce", "check peer", "transition", "recheck"}
+	spans.Lock()
+	defer spans.Unlock()
+	if strings.Join(spans.names, ",") != strings.Join(expected, ",") {
+		test.Logf("expected the spans %v, not %v", expected, spans.names)
+		test.Fail()
+	}
+}
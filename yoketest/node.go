@@ -0,0 +1,419 @@
+// Copyright (c) 2015 Pagoda Box Inc
+//
+// This Source Code Form is subject to the terms of the Mozilla Public License, v.
+// 2.0. If a copy of the MPL was not distributed with this file, You can obtain one
+// at http://mozilla.org/MPL/2.0/.
+//
+
+// node.go is a single node of a simulated cluster. The node is the local state its
+// decider runs with, the other nodes see it through a view, the way they would
+// see it through a remote state. Every fault is injected into the views, the node
+// itself always answers the decider that runs on it.
+
+package yoketest
+
+import (
+	"encoding/json"
+	"github.com/nanopack/yoke/state"
+	"sync"
+	"time"
+)
+
+type (
+	// Node is a node of a simulated cluster
+	Node struct {
+		sync.Mutex
+		cluster  *Cluster
+		role     string
+		location string
+		store    *Store
+		local    state.LocalState
+		dropped  bool
+		delay    time.Duration
+		flaps    []state.DBRole
+	}
+
+	// Store is a store that keeps what is written to it in memory, as json so that
+	// what is read back is a copy
+	Store struct {
+		sync.Mutex
+		data map[string][]byte
+	}
+
+	// view is how a node is seen from another node, directly or bounced through a
+	// third one
+	view struct {
+		from    *Node
+		to      *Node
+		timeout time.Duration
+		through *view // the hop to the node that bounces the view
+	}
+)
+
+func newNode(cluster *Cluster, role, location string) (*Node, error) {
+	node := &Node{
+		cluster:  cluster,
+		role:     role,
+		location: location,
+		store:    &Store{data: map[string][]byte{}},
+	}
+	return node, node.Restart()
+}
+
+// Restart starts the node again from what its store kept, as if its process was
+// restarted. The node can be reached again.
+func (node *Node) Restart() error {
+	local, err := state.NewLocalState(node.role, node.location, "/data/"+node.role, node.store)
+	if err != nil {
+		return err
+	}
+	node.Lock()
+	defer node.Unlock()
+	node.local = local
+	node.dropped = false
+	return nil
+}
+
+// Drop takes the node off the network, every call to it times out
+func (node *Node) Drop() {
+	node.Lock()
+	defer node.Unlock()
+	node.dropped = true
+}
+
+// Restore puts a node that was dropped back on the network
+func (node *Node) Restore() {
+	node.Lock()
+	defer node.Unlock()
+	node.dropped = false
+}
+
+// Delay makes every call to the node take d, on the clock of the cluster. A call
+// that takes as long as its timeout times out.
+func (node *Node) Delay(d time.Duration) {
+	node.Lock()
+	defer node.Unlock()
+	node.delay = d
+}
+
+// Flap makes the node answer the next calls for its db role with the roles, one
+// after the other, before it answers with its own again. Only the other nodes are
+// lied to.
+func (node *Node) Flap(roles ...state.DBRole) {
+	node.Lock()
+	defer node.Unlock()
+	node.flaps = append(node.flaps, roles...)
+}
+
+// answers how long a call to the node takes, and if it gets through at all
+func (node *Node) answer() (time.Duration, bool) {
+	node.Lock()
+	defer node.Unlock()
+	return node.delay, !node.dropped
+}
+
+func (node *Node) flap() (state.DBRole, bool) {
+	node.Lock()
+	defer node.Unlock()
+	if len(node.flaps) == 0 {
+		return "", false
+	}
+	role := node.flaps[0]
+	node.flaps = node.flaps[1:]
+	return role, true
+}
+
+func (node *Node) Ready() {}
+
+func (node *Node) Location() string {
+	return node.location
+}
+
+// the node doesn't bounce like the local state doesn't, the decider bounces through
+// its arbiter
+func (node *Node) Bounce(location string) state.State {
+	return nil
+}
+
+func (node *Node) GetDataDir() (string, error) {
+	node.Lock()
+	defer node.Unlock()
+	return node.local.GetDataDir()
+}
+
+func (node *Node) GetRole() (string, error) {
+	node.Lock()
+	defer node.Unlock()
+	return node.local.GetRole()
+}
+
+func (node *Node) GetDBRole() (string, error) {
+	node.Lock()
+	defer node.Unlock()
+	return node.local.GetDBRole()
+}
+
+func (node *Node) SetDBRole(role string) error {
+	node.Lock()
+	defer node.Unlock()
+	return node.local.SetDBRole(role)
+}
+
+func (node *Node) HasSynced() (bool, error) {
+	node.Lock()
+	defer node.Unlock()
+	return node.local.HasSynced()
+}
+
+func (node *Node) SetSynced(synced bool) error {
+	node.Lock()
+	defer node.Unlock()
+	return node.local.SetSynced(synced)
+}
+
+func (node *Node) GetPosition() (uint64, error) {
+	node.Lock()
+	defer node.Unlock()
+	return node.local.GetPosition()
+}
+
+func (node *Node) SetPosition(position uint64) error {
+	node.Lock()
+	defer node.Unlock()
+	return node.local.SetPosition(position)
+}
+
+func (node *Node) Lag() (time.Duration, int64, error) {
+	node.Lock()
+	defer node.Unlock()
+	return node.local.Lag()
+}
+
+func (node *Node) SetLag(delay time.Duration, bytes int64) error {
+	node.Lock()
+	defer node.Unlock()
+	return node.local.SetLag(delay, bytes)
+}
+
+func (node *Node) History() state.History {
+	node.Lock()
+	defer node.Unlock()
+	return node.local.History()
+}
+
+func (node *Node) RememberPeer(location, dbRole string) error {
+	node.Lock()
+	defer node.Unlock()
+	return node.local.RememberPeer(location, dbRole)
+}
+
+func (node *Node) GetMaintenance() (state.Maintenance, error) {
+	node.Lock()
+	defer node.Unlock()
+	return node.local.(maintainer).GetMaintenance()
+}
+
+func (node *Node) SetMaintenance(maintenance state.Maintenance) error {
+	node.Lock()
+	defer node.Unlock()
+	return node.local.(maintainer).SetMaintenance(maintenance)
+}
+
+func (node *Node) GetSummary() (state.Summary, error) {
+	node.Lock()
+	defer node.Unlock()
+	return node.local.(summarizer).GetSummary()
+}
+
+func (node *Node) SetSummary(summary state.Summary) error {
+	node.Lock()
+	defer node.Unlock()
+	return node.local.(summarizer).SetSummary(summary)
+}
+
+type (
+	// the optional halves of the local state, see the monitor package
+	maintainer interface {
+		GetMaintenance() (state.Maintenance, error)
+		SetMaintenance(state.Maintenance) error
+	}
+
+	summarizer interface {
+		GetSummary() (state.Summary, error)
+		SetSummary(state.Summary) error
+	}
+)
+
+func (store *Store) Read(table, key string, v interface{}) error {
+	store.Lock()
+	defer store.Unlock()
+	data, ok := store.data[table+"/"+key]
+	if !ok {
+		return state.NotSupported
+	}
+	return json.Unmarshal(data, v)
+}
+
+func (store *Store) Write(table, key string, v interface{}) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	store.Lock()
+	defer store.Unlock()
+	store.data[table+"/"+key] = data
+	return nil
+}
+
+// reach makes a call through the view, it fails with a timeout when the call
+// would not get through
+func (v *view) reach() error {
+	if v.through != nil {
+		if err := v.through.reach(); err != nil {
+			return err
+		}
+	}
+	if _, up := v.from.answer(); !up || v.from.cluster.isCut(v.from, v.to) {
+		v.from.cluster.Clock.Advance(v.timeout)
+		return state.Timeout
+	}
+	delay, up := v.to.answer()
+	if !up || (delay > 0 && delay >= v.timeout) {
+		v.from.cluster.Clock.Advance(v.timeout)
+		return state.Timeout
+	}
+	v.from.cluster.Clock.Advance(delay)
+	return nil
+}
+
+// Ready blocks until the node can be reached
+func (v *view) Ready() {
+	for {
+		if _, up := v.to.answer(); up && !v.from.cluster.isCut(v.from, v.to) {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func (v *view) Location() string {
+	return v.to.location
+}
+
+// Bounce returns the view of the node at location from the node this view is of
+func (v *view) Bounce(location string) state.State {
+	for _, node := range append(v.to.cluster.Nodes, v.to.cluster.Monitor) {
+		if node.location == location {
+			return &view{from: v.to, to: node, timeout: v.timeout / 2, through: v}
+		}
+	}
+	return nil
+}
+
+func (v *view) GetDataDir() (string, error) {
+	if err := v.reach(); err != nil {
+		return "", err
+	}
+	return v.to.GetDataDir()
+}
+
+func (v *view) GetRole() (string, error) {
+	if err := v.reach(); err != nil {
+		return "", err
+	}
+	return v.to.GetRole()
+}
+
+// a bounced view answers that a node that timed out is dead, like the remote
+// states do
+func (v *view) GetDBRole() (string, error) {
+	if v.through != nil {
+		if err := v.through.reach(); err != nil {
+			return "", err
+		}
+	}
+	hop := *v
+	hop.through = nil
+	if err := hop.reach(); err != nil {
+		if v.through != nil && err == state.Timeout {
+			return string(state.Dead), nil
+		}
+		return "", err
+	}
+	if role, ok := v.to.flap(); ok {
+		return string(role), nil
+	}
+	return v.to.GetDBRole()
+}
+
+func (v *view) SetDBRole(role string) error {
+	if err := v.reach(); err != nil {
+		return err
+	}
+	return v.to.SetDBRole(role)
+}
+
+func (v *view) HasSynced() (bool, error) {
+	if err := v.reach(); err != nil {
+		return false, err
+	}
+	return v.to.HasSynced()
+}
+
+func (v *view) SetSynced(synced bool) error {
+	if err := v.reach(); err != nil {
+		return err
+	}
+	return v.to.SetSynced(synced)
+}
+
+func (v *view) GetPosition() (uint64, error) {
+	if err := v.reach(); err != nil {
+		return 0, err
+	}
+	return v.to.GetPosition()
+}
+
+func (v *view) SetPosition(position uint64) error {
+	if err := v.reach(); err != nil {
+		return err
+	}
+	return v.to.SetPosition(position)
+}
+
+func (v *view) Lag() (time.Duration, int64, error) {
+	if err := v.reach(); err != nil {
+		return 0, 0, err
+	}
+	return v.to.Lag()
+}
+
+func (v *view) SetLag(delay time.Duration, bytes int64) error {
+	if err := v.reach(); err != nil {
+		return err
+	}
+	return v.to.SetLag(delay, bytes)
+}
+
+func (v *view) GetMaintenance() (state.Maintenance, error) {
+	if err := v.reach(); err != nil {
+		return state.Maintenance{}, err
+	}
+	return v.to.GetMaintenance()
+}
+
+// only the local state can be changed
+func (v *view) SetMaintenance(state.Maintenance) error {
+	return state.NotSupported
+}
+
+func (v *view) GetSummary() (state.Summary, error) {
+	if err := v.reach(); err != nil {
+		return state.Summary{}, err
+	}
+	return v.to.GetSummary()
+}
+
+func (v *view) SetSummary(state.Summary) error {
+	return state.NotSupported
+}
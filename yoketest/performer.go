@@ -0,0 +1,97 @@
+// Copyright (c) 2015 Pagoda Box Inc
+//
+// This Source Code Form is subject to the terms of the Mozilla Public License, v.
+// 2.0. If a copy of the MPL was not distributed with this file, You can obtain one
+// at http://mozilla.org/MPL/2.0/.
+//
+
+// performer.go stands in for the database of a simulated node. It records the
+// transitions it is told to make, and records the db role of each of them on its
+// node the way the real performer does once the database is in it.
+
+package yoketest
+
+import (
+	"errors"
+	"github.com/nanopack/yoke/state"
+	"sync"
+	"time"
+)
+
+// Stopped is returned for the position of a database that is not running
+var Stopped = errors.New("the database is not running")
+
+// Performer is the database of a simulated node
+type Performer struct {
+	sync.Mutex
+	node        *Node
+	transitions []state.DBRole
+	position    uint64
+}
+
+// Transitions returns the db roles the performer was told to go to, in order
+func (performer *Performer) Transitions() []state.DBRole {
+	performer.Lock()
+	defer performer.Unlock()
+	return append([]state.DBRole{}, performer.transitions...)
+}
+
+// Write moves the replication position of the database on by bytes, as if they
+// were written to it
+func (performer *Performer) Write(bytes uint64) {
+	performer.Lock()
+	defer performer.Unlock()
+	performer.position += bytes
+}
+
+func (performer *Performer) transition(role state.DBRole) {
+	performer.Lock()
+	performer.transitions = append(performer.transitions, role)
+	performer.Unlock()
+	performer.node.SetDBRole(string(role))
+}
+
+func (performer *Performer) TransitionToActive() {
+	performer.transition(state.Active)
+}
+
+// there is no data to copy, a backup is synced as soon as it follows the active
+// node
+func (performer *Performer) TransitionToBackup() {
+	performer.transition(state.Backup)
+	performer.node.SetSynced(true)
+}
+
+func (performer *Performer) TransitionToSingle() {
+	performer.transition(state.Single)
+}
+
+func (performer *Performer) Stop() {
+	performer.transition(state.Dead)
+}
+
+func (performer *Performer) Position() (uint64, error) {
+	if role, _ := performer.node.GetDBRole(); state.DBRole(role) == state.Dead {
+		return 0, Stopped
+	}
+	performer.Lock()
+	defer performer.Unlock()
+	return performer.position, nil
+}
+
+func (performer *Performer) ReplayDelay() (time.Duration, error) {
+	return 0, nil
+}
+
+func (performer *Performer) Initialize() error {
+	return nil
+}
+
+func (performer *Performer) Start() error {
+	return nil
+}
+
+// there is no database to wait on
+func (performer *Performer) Loop() error {
+	return nil
+}